@@ -0,0 +1,111 @@
+package jsontoxml
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+)
+
+var adaptiveWorkers bool
+
+func init() {
+	rootCmd.PersistentFlags().BoolVar(&adaptiveWorkers, "adaptive", false,
+		"Scale the number of urls fetched concurrently between 1 and --workers: up while the"+
+			" responses are fast, halved when timeouts, 429s or 5xx responses occur.")
+}
+
+// latencyTolerance is how much slower than the fastest one a response can be
+// for the concurrency to keep increasing.
+const latencyTolerance = 2
+
+// aimdLimiter bounds the attempts in progress with an additive increase,
+// multiplicative decrease (AIMD) limit, as TCP congestion control does. The
+// limit doubles every round trip until the first overload (slow start), then
+// grows by one per round trip while the responses are fast. An overload halves
+// it, once per round trip: the attempts started before the previous decrease
+// don't decrease it again. It is safe for concurrent use. A nil limiter has no
+// limit.
+type aimdLimiter struct {
+	max int
+
+	mu       sync.Mutex
+	limit    float64
+	inFlight int
+	// wake is closed when an attempt completes, to wake up the waiting ones.
+	wake         chan struct{}
+	slowStart    bool
+	minLatency   time.Duration
+	lastDecrease time.Time
+}
+
+func newAIMDLimiter(max int) *aimdLimiter {
+	return &aimdLimiter{max: max, limit: 1, wake: make(chan struct{}), slowStart: true}
+}
+
+// acquire waits until an attempt can start, or ctx is done.
+func (l *aimdLimiter) acquire(ctx context.Context) error {
+	if l == nil {
+		return nil
+	}
+	for {
+		l.mu.Lock()
+		if l.inFlight < int(l.limit) {
+			l.inFlight++
+			l.mu.Unlock()
+			return nil
+		}
+		wake := l.wake
+		l.mu.Unlock()
+		select {
+		case <-wake:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// release ends an attempt started at start, and adjusts the limit with its
+// outcome: overloaded is set if the attempt failed in a way that suggests that
+// the server is overloaded.
+func (l *aimdLimiter) release(start, now time.Time, overloaded bool) {
+	if l == nil {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.inFlight--
+	close(l.wake)
+	l.wake = make(chan struct{})
+	prev := int(l.limit)
+	latency := now.Sub(start)
+	switch {
+	case overloaded:
+		if !start.After(l.lastDecrease) {
+			break
+		}
+		l.limit /= 2
+		if l.limit < 1 {
+			l.limit = 1
+		}
+		l.lastDecrease, l.slowStart = now, false
+	default:
+		if l.minLatency == 0 || latency < l.minLatency {
+			l.minLatency = latency
+		}
+		if latency > latencyTolerance*l.minLatency {
+			break
+		}
+		if l.slowStart {
+			l.limit++
+		} else {
+			l.limit += 1 / l.limit
+		}
+		if l.limit > float64(l.max) {
+			l.limit = float64(l.max)
+		}
+	}
+	if n := int(l.limit); n != prev {
+		log.Printf("Adaptive concurrency: %d workers", n)
+	}
+}
@@ -0,0 +1,64 @@
+package jsontoxml
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestAIMDLimiter(t *testing.T) {
+	l := newAIMDLimiter(6)
+	t0 := time.Now()
+	ms := func(n int) time.Time { return t0.Add(time.Duration(n) * time.Millisecond) }
+	canceled, cancel := context.WithCancel(context.Background())
+	cancel()
+	acquire := func(n int) {
+		for i := 0; i < n; i++ {
+			require.NoError(t, l.acquire(context.Background()))
+		}
+		// The limit is reached.
+		require.Equal(t, context.Canceled, l.acquire(canceled))
+	}
+
+	// Slow start: the limit doubles every round trip.
+	acquire(1)
+	l.release(ms(0), ms(10), false)
+	require.Equal(t, 2.0, l.limit)
+	acquire(2)
+	l.release(ms(10), ms(20), false)
+	l.release(ms(10), ms(25), false)
+	require.Equal(t, 4.0, l.limit)
+	acquire(4)
+	l.release(ms(25), ms(35), false)
+	l.release(ms(25), ms(35), false)
+	// Slow responses don't increase it.
+	l.release(ms(25), ms(60), false)
+	require.Equal(t, 6.0, l.limit)
+
+	// An overload halves it, once for the attempts in progress.
+	l.release(ms(25), ms(70), true)
+	require.Equal(t, 3.0, l.limit)
+	acquire(3)
+	l.release(ms(30), ms(80), true)
+	require.Equal(t, 3.0, l.limit)
+	l.release(ms(75), ms(85), false)
+	require.InDelta(t, 3.33, l.limit, 0.01)
+	l.release(ms(80), ms(90), true)
+	require.InDelta(t, 1.67, l.limit, 0.01)
+
+	var nl *aimdLimiter
+	require.NoError(t, nl.acquire(canceled))
+	nl.release(t0, t0, true)
+}
+
+func TestRunnerAdaptive(t *testing.T) {
+	var sinks memSinks
+	r := &Runner{Workers: 4, Adaptive: true, NewSink: sinks.newSink}
+	r.Add(&urlSource{client: new(mockClient), urls: []string{"valid", "valid", "unknown", "valid"}})
+	rep, err := r.Run(context.Background())
+	require.NoError(t, err)
+	require.Equal(t, 4, rep.Processed)
+	require.Equal(t, 1, rep.Failed)
+}
@@ -0,0 +1,143 @@
+package jsontoxml
+
+import (
+	"context"
+	"math"
+	"net/http"
+	"runtime"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+var (
+	maxConcurrent int
+	maxQueue      int
+	queueTimeout  time.Duration
+)
+
+func init() {
+	serveCmd.Flags().IntVar(&maxConcurrent, "max-concurrent", runtime.NumCPU(),
+		"Maximum number of conversions running at the same time. Other requests wait in the"+
+			" queue.")
+	serveCmd.Flags().IntVar(&maxQueue, "max-queue", 64,
+		"Maximum number of requests waiting for a conversion slot. Requests beyond it are"+
+			" rejected with a 429.")
+	serveCmd.Flags().DurationVar(&queueTimeout, "queue-timeout", 30*time.Second,
+		"Maximum time a request waits in the queue before it is rejected with a 429.")
+}
+
+// errSaturated is returned when a request can't be admitted.
+var errSaturated = errors.New("too many requests")
+
+// admission bounds the number of requests served concurrently. Requests over
+// the limit wait in a bounded queue, for up to timeout.
+type admission struct {
+	slots    chan struct{}
+	maxQueue int
+	timeout  time.Duration
+
+	mu     sync.Mutex
+	queued int
+	// avg is the moving average of the time a slot is held.
+	avg time.Duration
+}
+
+func newAdmission(concurrent, queue int, timeout time.Duration) (*admission, error) {
+	if concurrent < 1 {
+		return nil, errors.New("--max-concurrent must be at least 1")
+	}
+	if queue < 0 {
+		return nil, errors.New("--max-queue can't be negative")
+	}
+	return &admission{
+		slots:    make(chan struct{}, concurrent),
+		maxQueue: queue,
+		timeout:  timeout,
+	}, nil
+}
+
+// admit waits for a slot. It returns errSaturated if the queue is full or the
+// wait times out, and the error of ctx if it is done first. release must be
+// called once the request is served.
+func (a *admission) admit(ctx context.Context) (release func(), err error) {
+	select {
+	case a.slots <- struct{}{}:
+		return a.release(time.Now()), nil
+	default:
+	}
+	a.mu.Lock()
+	if a.queued >= a.maxQueue {
+		a.mu.Unlock()
+		return nil, errSaturated
+	}
+	a.queued++
+	a.mu.Unlock()
+	defer func() {
+		a.mu.Lock()
+		a.queued--
+		a.mu.Unlock()
+	}()
+
+	var expired <-chan time.Time
+	if a.timeout > 0 {
+		timer := time.NewTimer(a.timeout)
+		defer timer.Stop()
+		expired = timer.C
+	}
+	select {
+	case a.slots <- struct{}{}:
+		return a.release(time.Now()), nil
+	case <-expired:
+		return nil, errSaturated
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+func (a *admission) release(start time.Time) func() {
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			d := time.Since(start)
+			a.mu.Lock()
+			if a.avg == 0 {
+				a.avg = d
+			} else {
+				a.avg = (4*a.avg + d) / 5
+			}
+			a.mu.Unlock()
+			<-a.slots
+		})
+	}
+}
+
+// retryAfter estimates when a rejected request could be admitted: once the
+// queue ahead of it is drained. It is at least a second.
+func (a *admission) retryAfter() time.Duration {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	wait := float64(a.avg) * float64(a.queued+1) / float64(cap(a.slots))
+	return time.Duration(math.Max(1, math.Ceil(wait/float64(time.Second)))) * time.Second
+}
+
+// handler serves the requests admitted with h. The others are rejected with
+// a 429 and a Retry-After header.
+func (a *admission) handler(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		release, err := a.admit(r.Context())
+		if err == errSaturated {
+			w.Header().Set("Retry-After", strconv.Itoa(int(a.retryAfter()/time.Second)))
+			http.Error(w, "too many requests, retry later", http.StatusTooManyRequests)
+			return
+		}
+		if err != nil {
+			// The client went away.
+			return
+		}
+		defer release()
+		h.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,84 @@
+package jsontoxml
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestAdmission(t *testing.T) {
+	a, err := newAdmission(1, 1, 100*time.Millisecond)
+	require.NoError(t, err)
+	started, unblock := make(chan struct{}), make(chan struct{})
+	srv := httptest.NewServer(a.handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/block" {
+			started <- struct{}{}
+			<-unblock
+		}
+	})))
+	defer srv.Close()
+	get := func(path string) *http.Response {
+		resp, err := http.Get(srv.URL + path)
+		require.NoError(t, err)
+		resp.Body.Close()
+		return resp
+	}
+
+	done := make(chan int)
+	go func() { done <- get("/block").StatusCode }()
+	<-started
+	// The second request waits in the queue, so the third one is rejected.
+	queued := make(chan *http.Response)
+	go func() { queued <- get("/") }()
+	require.Eventually(t, func() bool {
+		a.mu.Lock()
+		defer a.mu.Unlock()
+		return a.queued == 1
+	}, time.Second, time.Millisecond)
+	resp := get("/")
+	require.Equal(t, http.StatusTooManyRequests, resp.StatusCode)
+	require.Equal(t, "1", resp.Header.Get("Retry-After"))
+	// The queued request times out.
+	resp = <-queued
+	require.Equal(t, http.StatusTooManyRequests, resp.StatusCode)
+
+	// Queued requests are served once a slot is released.
+	go func() { queued <- get("/") }()
+	require.Eventually(t, func() bool {
+		a.mu.Lock()
+		defer a.mu.Unlock()
+		return a.queued == 1
+	}, time.Second, time.Millisecond)
+	close(unblock)
+	require.Equal(t, http.StatusOK, (<-queued).StatusCode)
+	require.Equal(t, http.StatusOK, <-done)
+
+	// Requests whose client goes away leave the queue.
+	release, err := a.admit(context.Background())
+	require.NoError(t, err)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = a.admit(ctx)
+	require.Equal(t, context.Canceled, err)
+	release()
+	release()
+	release, err = a.admit(context.Background())
+	require.NoError(t, err)
+	release()
+
+	_, err = newAdmission(0, 1, time.Second)
+	require.Error(t, err)
+}
+
+func TestRetryAfter(t *testing.T) {
+	a, err := newAdmission(2, 10, time.Second)
+	require.NoError(t, err)
+	require.Equal(t, time.Second, a.retryAfter())
+	a.avg, a.queued = 3*time.Second, 3
+	// 4 conversions of 3s ahead, 2 at a time.
+	require.Equal(t, 6*time.Second, a.retryAfter())
+}
@@ -0,0 +1,83 @@
+package jsontoxml
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+var allowedHosts []string
+
+func init() {
+	serveCmd.Flags().StringArrayVar(&allowedHosts, "allow-host", nil,
+		"Host the clients of the server may have it fetch, by /convert?url= or the jobs API,"+
+			" e.g. api.example.com, or *.example.com for its subdomains. Can be repeated. No url"+
+			" can be fetched if unset.")
+}
+
+// hostAllowlist holds the hosts that can be fetched on behalf of the clients of
+// serve. An entry "*.example.com" allows the subdomains of example.com.
+type hostAllowlist []string
+
+func parseAllowedHosts(hosts []string) (hostAllowlist, error) {
+	l := make(hostAllowlist, 0, len(hosts))
+	for _, h := range hosts {
+		h = strings.ToLower(strings.TrimSpace(h))
+		name := strings.TrimPrefix(h, "*.")
+		if len(name) == 0 || strings.ContainsAny(name, "*/:@ ") {
+			return nil, errors.Errorf("invalid --allow-host %q. Expected a host name, e.g."+
+				" api.example.com or *.example.com", h)
+		}
+		l = append(l, h)
+	}
+	return l, nil
+}
+
+// allows returns true if the host, without its port, is allowed.
+func (l hostAllowlist) allows(host string) bool {
+	host = strings.ToLower(strings.TrimSuffix(host, "."))
+	for _, h := range l {
+		if host == h || strings.HasPrefix(h, "*.") && strings.HasSuffix(host, h[1:]) {
+			return true
+		}
+	}
+	return false
+}
+
+// allowTransport only sends the requests to the hosts of allow, including the
+// redirects and the next pages.
+type allowTransport struct {
+	base  http.RoundTripper
+	allow hostAllowlist
+}
+
+func (t *allowTransport) CloseIdleConnections() {
+	closeIdleConnections(t.base)
+}
+
+func (t *allowTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if !t.allow.allows(req.URL.Hostname()) {
+		if req.Body != nil {
+			req.Body.Close()
+		}
+		return nil, errors.Errorf("host %q isn't allowed by --allow-host", req.URL.Hostname())
+	}
+	return t.base.RoundTrip(req)
+}
+
+// newServeClient returns the client fetching the urls sent by the clients of
+// serve. Those choose the hosts, so the client only reaches the hosts of allow
+// and sends none of the credentials of the flags: neither the headers, nor
+// the OAuth tokens, nor the client certificate. It doesn't use the cache
+// either, which may hold responses fetched with them.
+func newServeClient(allow hostAllowlist) *http.Client {
+	cfg := tlsConfig
+	if cfg != nil && len(cfg.Certificates) > 0 {
+		cfg = cfg.Clone()
+		cfg.Certificates = nil
+	}
+	c := newClient(cfg, "")
+	c.Transport = &allowTransport{base: c.Transport, allow: allow}
+	return c
+}
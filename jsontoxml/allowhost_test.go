@@ -0,0 +1,58 @@
+package jsontoxml
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestHostAllowlist(t *testing.T) {
+	l, err := parseAllowedHosts([]string{"API.example.com", "*.example.org"})
+	require.NoError(t, err)
+	for host, want := range map[string]bool{
+		"api.example.com": true, "Api.Example.com.": true, "example.com": false,
+		"a.api.example.com": false, "a.example.org": true, "a.b.example.org": true,
+		"example.org": false, "badexample.org": false, "127.0.0.1": false,
+	} {
+		require.Equal(t, want, l.allows(host), host)
+	}
+	require.False(t, hostAllowlist(nil).allows("localhost"))
+
+	for _, h := range []string{"", "*.", "a.com:80", "*.*.a.com", "http://a.com", "a.*.com"} {
+		_, err := parseAllowedHosts([]string{h})
+		require.Error(t, err, h)
+	}
+}
+
+func TestServeClient(t *testing.T) {
+	defer func(h http.Header) { requestHeader = h }(requestHeader)
+	requestHeader = http.Header{"Authorization": {"Bearer secret"}}
+	var auth []string
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		auth = append(auth, r.Header.Get("Authorization"))
+		if r.URL.Path == "/redirect" {
+			u, _ := url.Parse(r.URL.Query().Get("to"))
+			http.Redirect(w, r, u.String(), http.StatusFound)
+		}
+	}))
+	defer upstream.Close()
+
+	// The credentials of the flags are only sent by fetchClient.
+	resp, err := newHTTPClient().Get(upstream.URL)
+	require.NoError(t, err)
+	resp.Body.Close()
+	c := newServeClient(hostAllowlist{"127.0.0.1"})
+	resp, err = c.Get(upstream.URL)
+	require.NoError(t, err)
+	resp.Body.Close()
+	require.Equal(t, []string{"Bearer secret", ""}, auth)
+
+	// Neither the urls nor their redirects reach the other hosts.
+	_, err = newServeClient(hostAllowlist{"example.com"}).Get(upstream.URL)
+	require.ErrorContains(t, err, `host "127.0.0.1" isn't allowed by --allow-host`)
+	_, err = c.Get(upstream.URL + "/redirect?to=http://localhost/")
+	require.ErrorContains(t, err, `host "localhost" isn't allowed by --allow-host`)
+}
@@ -0,0 +1,244 @@
+package jsontoxml
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v3"
+)
+
+var apiKeysFile string
+
+func init() {
+	serveCmd.Flags().StringVar(&apiKeysFile, "api-keys", "",
+		"YAML file of the API keys allowed to use the server, with their tenants and rate"+
+			" limits. Usage per key and tenant is exposed at /metrics.")
+}
+
+// apiKeysConfig is the --api-keys file:
+//
+//	keys:
+//	  - name: team-a-ci
+//	    key: ${secret:team_a_key}
+//	    rate: 5 # requests per second, no limit if 0
+//	    tenant: team-a # the name of the key by default
+//	tenants:
+//	  - name: team-a
+//	    rate: 20 # requests per second of all the keys of the tenant
+//	    max_jobs: 4 # jobs in progress, no limit if 0
+type apiKeysConfig struct {
+	Keys []struct {
+		Name   string  `yaml:"name"`
+		Key    string  `yaml:"key"`
+		Rate   float64 `yaml:"rate"`
+		Tenant string  `yaml:"tenant"`
+	} `yaml:"keys"`
+	Tenants []struct {
+		Name    string  `yaml:"name"`
+		Rate    float64 `yaml:"rate"`
+		MaxJobs int     `yaml:"max_jobs"`
+	} `yaml:"tenants"`
+}
+
+// apiClient is the holder of an API key.
+type apiClient struct {
+	name string
+	key  []byte
+	// quota limits the requests of the client. No limit if nil.
+	quota  *tokenBucket
+	tenant *tenant
+	usage
+}
+
+// keyring authenticates the requests with API keys and records the usage of
+// every key and tenant.
+type keyring struct {
+	clients []*apiClient
+	tenants *tenantSet
+	// unauthorized counts the requests without a valid key.
+	unauthorized int64
+	mu           sync.Mutex
+}
+
+// loadKeyring reads the --api-keys file. Secrets must be loaded first.
+func loadKeyring(path string) (*keyring, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, errors.Wrap(err, "--api-keys")
+	}
+	var conf apiKeysConfig
+	if err := yaml.Unmarshal(data, &conf); err != nil {
+		return nil, errors.Wrapf(err, "parse %s", path)
+	}
+	if len(conf.Keys) == 0 {
+		return nil, errors.Errorf("no keys in %s", path)
+	}
+	k := &keyring{tenants: newTenantSet()}
+	for i, c := range conf.Tenants {
+		if !validTenant(c.Name) || k.tenants.tenants[c.Name] != nil {
+			return nil, errors.Errorf("tenant %d of %s: the name must be set, unique and made"+
+				" of letters, digits, '.', '_' and '-'", i, path)
+		}
+		if err := checkRateLimit(c.Rate); err != nil {
+			return nil, errors.Wrapf(err, "tenant %q", c.Name)
+		}
+		if c.MaxJobs < 0 {
+			return nil, errors.Errorf("tenant %q: invalid max_jobs %d", c.Name, c.MaxJobs)
+		}
+		t := k.tenants.get(c.Name)
+		t.maxJobs = c.MaxJobs
+		if c.Rate > 0 {
+			t.quota = newTokenBucket(c.Rate, time.Now())
+		}
+	}
+	names := make(map[string]bool)
+	for i, c := range conf.Keys {
+		if len(c.Name) == 0 || names[c.Name] {
+			return nil, errors.Errorf("key %d of %s: the name must be set and unique", i, path)
+		}
+		names[c.Name] = true
+		key, err := expandSecrets(c.Key)
+		if err != nil {
+			return nil, errors.Wrapf(err, "key %q", c.Name)
+		}
+		if len(key) == 0 {
+			return nil, errors.Errorf("key %q is empty", c.Name)
+		}
+		if err := checkRateLimit(c.Rate); err != nil {
+			return nil, errors.Wrapf(err, "key %q", c.Name)
+		}
+		tenant := c.Tenant
+		if len(tenant) == 0 {
+			tenant = c.Name
+		}
+		if !validTenant(tenant) {
+			return nil, errors.Errorf("key %q: invalid tenant %q", c.Name, tenant)
+		}
+		defaultRedactor.addValues(key)
+		client := &apiClient{name: c.Name, key: []byte(key), tenant: k.tenants.get(tenant)}
+		if c.Rate > 0 {
+			client.quota = newTokenBucket(c.Rate, time.Now())
+		}
+		k.clients = append(k.clients, client)
+	}
+	return k, nil
+}
+
+// requestKey returns the API key of the request, sent as a bearer token or in
+// the X-Api-Key header.
+func requestKey(r *http.Request) string {
+	if key := r.Header.Get("X-Api-Key"); len(key) > 0 {
+		return key
+	}
+	auth := r.Header.Get("Authorization")
+	if len(auth) > 7 && strings.EqualFold(auth[:7], "Bearer ") {
+		return auth[7:]
+	}
+	return ""
+}
+
+// client returns the holder of key, or nil if the key is unknown. Every key
+// is compared, in constant time, so that the comparisons don't leak it.
+func (k *keyring) client(key string) *apiClient {
+	var found *apiClient
+	for _, c := range k.clients {
+		if subtle.ConstantTimeCompare(c.key, []byte(key)) == 1 {
+			found = c
+		}
+	}
+	return found
+}
+
+// handler serves the requests with a valid API key, within the quotas of the
+// key and of its tenant, with h. The others are rejected with a 401 or a 429.
+func (k *keyring) handler(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		c := k.client(requestKey(r))
+		if c == nil {
+			k.mu.Lock()
+			k.unauthorized++
+			k.mu.Unlock()
+			w.Header().Set("WWW-Authenticate", `Bearer realm="jsonToXml"`)
+			http.Error(w, "missing or invalid API key", http.StatusUnauthorized)
+			return
+		}
+		if !checkQuota(w, c.quota, &c.usage, "API key") ||
+			!checkQuota(w, c.tenant.quota, &c.tenant.usage, "tenant") {
+			return
+		}
+		r = r.WithContext(withTenant(r.Context(), c.tenant))
+		serveCounted(w, r, h, &c.usage, &c.tenant.usage)
+	})
+}
+
+// ServeHTTP writes the usage of the keys and of the tenants in the Prometheus
+// text format.
+func (k *keyring) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	k.writeMetrics(w)
+}
+
+func (k *keyring) writeMetrics(w io.Writer) {
+	names := make([]string, 0, len(k.clients))
+	series := make(map[string]*usage, len(k.clients))
+	for _, c := range k.clients {
+		names = append(names, c.name)
+		series[c.name] = &c.usage
+	}
+	writeUsage(w, "jsontoxml_", "key", "API key", names, series)
+	k.mu.Lock()
+	fmt.Fprintln(w, "# HELP jsontoxml_unauthorized_total Requests without a valid API key.")
+	fmt.Fprintln(w, "# TYPE jsontoxml_unauthorized_total counter")
+	fmt.Fprintf(w, "jsontoxml_unauthorized_total %d\n", k.unauthorized)
+	k.mu.Unlock()
+	k.tenants.writeMetrics(w)
+}
+
+type countingReader struct {
+	r io.ReadCloser
+	n int64
+}
+
+func (cr *countingReader) Read(p []byte) (int, error) {
+	n, err := cr.r.Read(p)
+	cr.n += int64(n)
+	return n, err
+}
+
+func (cr *countingReader) Close() error {
+	return cr.r.Close()
+}
+
+// countingWriter records the status and the size of a response.
+type countingWriter struct {
+	http.ResponseWriter
+	code        int
+	wroteHeader bool
+	n           int64
+}
+
+func (cw *countingWriter) WriteHeader(code int) {
+	if !cw.wroteHeader {
+		cw.code, cw.wroteHeader = code, true
+	}
+	cw.ResponseWriter.WriteHeader(code)
+}
+
+func (cw *countingWriter) Write(p []byte) (int, error) {
+	cw.wroteHeader = true
+	n, err := cw.ResponseWriter.Write(p)
+	cw.n += int64(n)
+	return n, err
+}
+
+// Unwrap lets http.ResponseController reach the flusher of the response.
+func (cw *countingWriter) Unwrap() http.ResponseWriter {
+	return cw.ResponseWriter
+}
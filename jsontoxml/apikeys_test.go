@@ -0,0 +1,113 @@
+package jsontoxml
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestKeyring(t *testing.T) {
+	dir, err := ioutil.TempDir("", "apikeys")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "keys.yaml")
+	secrets["team_b"] = "key-b"
+	defer delete(secrets, "team_b")
+	require.NoError(t, ioutil.WriteFile(path, []byte(`
+keys:
+  - name: team-a
+    key: key-a
+  - name: team-b
+    key: ${secret:team_b}
+    rate: 0.001
+  - name: team-b-ci
+    key: key-b-ci
+    tenant: team-b
+tenants:
+  - name: team-b
+    rate: 0.001
+`), 0644))
+	keys, err := loadKeyring(path)
+	require.NoError(t, err)
+
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", keys.handler(keys))
+	mux.Handle("/", keys.handler(&converter{opts: &convertOptions{}}))
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+	post := func(header, key, doc string) *http.Response {
+		req, err := http.NewRequest(http.MethodPost, srv.URL+"/convert", strings.NewReader(doc))
+		require.NoError(t, err)
+		if len(header) > 0 {
+			req.Header.Set(header, key)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		return resp
+	}
+
+	require.Equal(t, http.StatusUnauthorized, post("", "", `[]`).StatusCode)
+	require.Equal(t, http.StatusUnauthorized, post("X-Api-Key", "key-c", `[]`).StatusCode)
+	require.Equal(t, http.StatusOK, post("X-Api-Key", "key-a", `[]`).StatusCode)
+	require.Equal(t, http.StatusBadRequest, post("Authorization", "Bearer key-a", `{`).StatusCode)
+	// The second request of team-b exceeds its rate limit.
+	require.Equal(t, http.StatusOK, post("Authorization", "bearer key-b", `[]`).StatusCode)
+	resp := post("X-Api-Key", "key-b", `[]`)
+	require.Equal(t, http.StatusTooManyRequests, resp.StatusCode)
+	require.Equal(t, "1000", resp.Header.Get("Retry-After"))
+	// The other key of team-b shares the rate limit of the tenant.
+	require.Equal(t, http.StatusTooManyRequests, post("X-Api-Key", "key-b-ci", `[]`).StatusCode)
+
+	resp, err = http.Get(srv.URL + "/metrics")
+	require.NoError(t, err)
+	resp.Body.Close()
+	require.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+	req, err := http.NewRequest(http.MethodGet, srv.URL+"/metrics", nil)
+	require.NoError(t, err)
+	req.Header.Set("X-Api-Key", "key-a")
+	resp, err = http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	metrics, err := ioutil.ReadAll(resp.Body)
+	require.NoError(t, err)
+	for _, line := range []string{
+		`jsontoxml_requests_total{key="team-a",code="200"} 1`,
+		`jsontoxml_requests_total{key="team-a",code="400"} 1`,
+		`jsontoxml_requests_total{key="team-b",code="200"} 1`,
+		`jsontoxml_request_bytes_total{key="team-a"} 3`,
+		`jsontoxml_response_bytes_total{key="team-b"} 21`,
+		`jsontoxml_quota_exceeded_total{key="team-a"} 0`,
+		`jsontoxml_quota_exceeded_total{key="team-b"} 1`,
+		`jsontoxml_unauthorized_total 3`,
+		`jsontoxml_tenant_requests_total{tenant="team-a",code="200"} 1`,
+		`jsontoxml_tenant_requests_total{tenant="team-b",code="200"} 1`,
+		`jsontoxml_tenant_quota_exceeded_total{tenant="team-b"} 1`,
+	} {
+		require.Contains(t, string(metrics), line+"\n")
+	}
+	require.NotContains(t, string(metrics), "key-b")
+
+	for conf, msg := range map[string]string{
+		`keys: []`: "no keys in " + path,
+		"keys:\n  - name: a\n    key: k\n  - name: a\n    key: l": "key 1 of " + path +
+			": the name must be set and unique",
+		"keys:\n  - name: a": `key "a" is empty`,
+		"keys:\n  - name: a\n    key: k\n    tenant: ../b": `key "a": invalid tenant "../b"`,
+		"keys:\n  - name: a\n    key: k\ntenants:\n  - name: b\n    max_jobs: -1": `tenant "b":` +
+			` invalid max_jobs -1`,
+		"keys:\n  - name: a\n    key: k\n    rate: -1": `key "a": invalid --rate-limit -1.` +
+			` Expected a positive number of requests per second`,
+	} {
+		require.NoError(t, ioutil.WriteFile(path, []byte(conf), 0644))
+		_, err := loadKeyring(path)
+		require.EqualError(t, err, msg)
+	}
+}
@@ -0,0 +1,76 @@
+package jsontoxml
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"sort"
+)
+
+var attributeFields []string
+
+func init() {
+	rootCmd.PersistentFlags().StringArrayVar(&attributeFields, "attribute-field", nil,
+		"Json key whose values are written as attributes of the element of their object"+
+			" instead of child elements, e.g. id. Requires --generic, --openapi or --preset."+
+			" Can be repeated.")
+}
+
+// newAttributeFields returns the set of the json keys of --attribute-field, or
+// nil if there is none.
+func newAttributeFields(keys []string) map[string]bool {
+	if len(keys) == 0 {
+		return nil
+	}
+	fields := make(map[string]bool, len(keys))
+	for _, k := range keys {
+		fields[k] = true
+	}
+	return fields
+}
+
+// isScalar returns true if the decoded json value is a string, a number or a
+// bool.
+func isScalar(v interface{}) bool {
+	switch v.(type) {
+	case string, json.Number, float64, bool:
+		return true
+	}
+	return false
+}
+
+// asAttribute returns true if the value v of the json key is written as an
+// attribute. Only the scalars can be.
+func (o *convertOptions) asAttribute(key string, v interface{}) bool {
+	return o.attributeFields[key] && isScalar(v)
+}
+
+// fieldAttrs returns the attributes of the fields of obj mapped to
+// attributes, in key order. The other values of the mapped keys are kept as
+// elements, with a warning.
+func (o *convertOptions) fieldAttrs(obj map[string]interface{}) []xml.Attr {
+	if len(o.attributeFields) == 0 {
+		return nil
+	}
+	var keys []string
+	for k := range obj {
+		if o.attributeFields[k] {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	var attrs []xml.Attr
+	for _, k := range keys {
+		if !o.asAttribute(k, obj[k]) {
+			if obj[k] != nil {
+				o.warn("value of %s isn't a string, number or bool, kept as an element", k)
+			}
+			continue
+		}
+		name, ok := xmlName(k)
+		if !ok {
+			o.warn("key %q written as attribute %s", k, name)
+		}
+		attrs = append(attrs, xml.Attr{Name: xml.Name{Local: name}, Value: o.formatScalar(obj[k])})
+	}
+	return attrs
+}
@@ -0,0 +1,46 @@
+package jsontoxml
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestAttributeFields(t *testing.T) {
+	warnings := &docWarnings{}
+	opts := &convertOptions{generic: true, warnings: warnings,
+		attributeFields: newAttributeFields([]string{"id", "lang", "tags", "x y"}),
+		layout:          &xmlLayout{compact: true}}
+	out, err := convert([]byte(`{"id": 7, "name": "a", "x y": true, "tags": ["t"],`+
+		` "items": [{"id": "b1", "lang": "en", "text": "hi"}]}`), opts)
+	require.NoError(t, err)
+	require.Equal(t, `<jsonData id="7" x_y="true"><items><item id="b1" lang="en"><text>hi</text>`+
+		`</item></items><name>a</name><tags><item>t</item></tags></jsonData>`, string(out))
+	require.Equal(t, []string{
+		`value of tags isn't a string, number or bool, kept as an element`,
+		`key "x y" written as attribute x_y`,
+	}, warnings.messages())
+}
+
+func TestAttributeFieldsGML(t *testing.T) {
+	opts := &convertOptions{preset: presetGML, attributeFields: newAttributeFields([]string{"name"})}
+	got, err := convert([]byte(geoFixture), opts)
+	require.NoError(t, err)
+	wellFormed(t, got)
+	require.Contains(t, string(got), `<Feature gml:id="_7" name="Park">`)
+	require.NotContains(t, string(got), `<name>`)
+}
+
+func TestAttributeFieldsModes(t *testing.T) {
+	defer func() { attributeFields, generic = nil, false }()
+	attributeFields = []string{"id"}
+	// The jsonData records keep their elements.
+	_, err := newConvertOptions()
+	require.EqualError(t, err, "--attribute-field requires --generic, --openapi or --preset")
+	generic = true
+	opts, err := newConvertOptions()
+	require.NoError(t, err)
+	data, err := convert([]byte(`{"id": 7, "name": "a"}`), opts)
+	require.NoError(t, err)
+	require.Contains(t, string(data), `<jsonData id="7">`)
+}
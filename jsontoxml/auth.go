@@ -0,0 +1,224 @@
+package jsontoxml
+
+import (
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"golang.org/x/term"
+)
+
+var (
+	basicAuth   string
+	bearerToken string
+	oauthConfig struct {
+		tokenURL, clientID, clientSecret, scope string
+	}
+	// oauthTokens authenticates the requests of fetchClient if the client
+	// credentials flow is configured.
+	oauthTokens *tokenSource
+)
+
+func init() {
+	rootCmd.PersistentFlags().StringVar(&basicAuth, "basic-auth", "",
+		"Credentials sent with every request using HTTP basic authentication, as user:password."+
+			" The password can refer to a secret as ${secret:name}. It is prompted for if omitted.")
+	rootCmd.PersistentFlags().StringVar(&bearerToken, "bearer-token", "",
+		"Token sent with every request as \"Authorization: Bearer <token>\". It can refer to a"+
+			" secret as ${secret:name}.")
+	rootCmd.PersistentFlags().StringVar(&oauthConfig.tokenURL, "oauth-token-url", "",
+		"Token endpoint of the OAuth2 client credentials flow. The token is fetched before the"+
+			" first request and refreshed when it expires.")
+	rootCmd.PersistentFlags().StringVar(&oauthConfig.clientID, "client-id", "",
+		"OAuth2 client id.")
+	rootCmd.PersistentFlags().StringVar(&oauthConfig.clientSecret, "client-secret", "",
+		"OAuth2 client secret. It can refer to a secret as ${secret:name}.")
+	rootCmd.PersistentFlags().StringVar(&oauthConfig.scope, "oauth-scope", "",
+		"Space separated scopes requested with the OAuth2 token.")
+}
+
+// setupAuth applies the authentication flags to requestHeader and
+// oauthTokens. Secrets must be loaded first.
+func setupAuth() error {
+	n := 0
+	for _, set := range []bool{len(basicAuth) > 0, len(bearerToken) > 0,
+		len(oauthConfig.tokenURL) > 0} {
+		if set {
+			n++
+		}
+	}
+	if n > 1 {
+		return errors.New("--basic-auth, --bearer-token and --oauth-token-url are mutually exclusive")
+	}
+	switch {
+	case len(basicAuth) > 0:
+		user, pass, err := parseBasicAuth(basicAuth, promptPassword)
+		if err != nil {
+			return err
+		}
+		defaultRedactor.addValues(pass)
+		setBasicAuth(requestHeader, user, pass)
+	case len(bearerToken) > 0:
+		token, err := expandSecrets(bearerToken)
+		if err != nil {
+			return errors.Wrap(err, "--bearer-token")
+		}
+		defaultRedactor.addValues(token)
+		requestHeader.Set("Authorization", "Bearer "+token)
+	case len(oauthConfig.tokenURL) > 0:
+		secret, err := expandSecrets(oauthConfig.clientSecret)
+		if err != nil {
+			return errors.Wrap(err, "--client-secret")
+		}
+		if len(oauthConfig.clientID) == 0 {
+			return errors.New("--oauth-token-url requires --client-id")
+		}
+		defaultRedactor.addValues(secret)
+		oauthTokens = &tokenSource{
+			url:    oauthConfig.tokenURL,
+			id:     oauthConfig.clientID,
+			secret: secret,
+			scope:  oauthConfig.scope,
+			now:    time.Now,
+		}
+	}
+	return nil
+}
+
+// promptPassword reads the password of user from the terminal without echoing
+// it.
+func promptPassword(user string) (string, error) {
+	fd := int(os.Stdin.Fd())
+	if !term.IsTerminal(fd) {
+		return "", errors.Errorf("no password for --basic-auth user %q and stdin isn't a terminal",
+			user)
+	}
+	fmt.Fprintf(os.Stderr, "Password for %s: ", user)
+	pass, err := term.ReadPassword(fd)
+	fmt.Fprintln(os.Stderr)
+	return string(pass), errors.Wrap(err, "read password")
+}
+
+// parseBasicAuth parses the --basic-auth flag. prompt is called if the password
+// is omitted. Secrets must be loaded first.
+func parseBasicAuth(spec string, prompt func(user string) (string, error)) (user, pass string,
+	err error) {
+	if len(spec) == 0 {
+		return "", "", nil
+	}
+	if spec, err = expandSecrets(spec); err != nil {
+		return "", "", errors.Wrap(err, "--basic-auth")
+	}
+	parts := strings.SplitN(spec, ":", 2)
+	if len(parts[0]) == 0 {
+		return "", "", errors.New("invalid --basic-auth. Expected user:password")
+	}
+	if len(parts) == 2 {
+		return parts[0], parts[1], nil
+	}
+	pass, err = prompt(parts[0])
+	return parts[0], pass, err
+}
+
+// setBasicAuth adds the Authorization header of the credentials to h.
+func setBasicAuth(h http.Header, user, pass string) {
+	cred := base64.StdEncoding.EncodeToString([]byte(user + ":" + pass))
+	h.Set("Authorization", "Basic "+cred)
+}
+
+// tokenSource fetches OAuth2 access tokens with the client credentials flow
+// and caches them until shortly before they expire. It is safe for concurrent
+// use.
+type tokenSource struct {
+	url, id, secret, scope string
+	now                    func() time.Time
+
+	mu      sync.Mutex
+	token   string
+	expires time.Time
+}
+
+// tokenExpiryMargin is how long before its expiry a token is refreshed, so
+// that it doesn't expire while a request is in flight.
+const tokenExpiryMargin = 30 * time.Second
+
+// get returns a valid token, fetching a new one if needed.
+func (s *tokenSource) get() (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.token) > 0 && (s.expires.IsZero() || s.now().Before(s.expires)) {
+		return s.token, nil
+	}
+	form := url.Values{"grant_type": {"client_credentials"}}
+	if len(s.scope) > 0 {
+		form.Set("scope", s.scope)
+	}
+	req, err := http.NewRequest(http.MethodPost, s.url, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", errors.Wrap(err, "oauth token")
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.SetBasicAuth(url.QueryEscape(s.id), url.QueryEscape(s.secret))
+	var out struct {
+		AccessToken string `json:"access_token"`
+		ExpiresIn   int64  `json:"expires_in"`
+	}
+	if err := doJSON(req, &out); err != nil {
+		return "", errors.Wrap(err, "oauth token")
+	}
+	if len(out.AccessToken) == 0 {
+		return "", errors.New("oauth token: no access_token in the response")
+	}
+	s.token, s.expires = out.AccessToken, time.Time{}
+	if out.ExpiresIn > 0 {
+		s.expires = s.now().Add(time.Duration(out.ExpiresIn)*time.Second - tokenExpiryMargin)
+	}
+	defaultRedactor.addValues(s.token)
+	return s.token, nil
+}
+
+// invalidate drops token if it is still cached, e.g. because the server
+// rejected it.
+func (s *tokenSource) invalidate(token string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.token == token {
+		s.token = ""
+	}
+}
+
+// oauthTransport authenticates every request with a token of tokens.
+type oauthTransport struct {
+	base   http.RoundTripper
+	tokens *tokenSource
+}
+
+func (t *oauthTransport) CloseIdleConnections() {
+	closeIdleConnections(t.base)
+}
+
+func (t *oauthTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	token, err := t.tokens.get()
+	if err != nil {
+		return nil, err
+	}
+	req = req.Clone(req.Context())
+	req.Header.Set("Authorization", "Bearer "+token)
+	base := t.base
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	resp, err := base.RoundTrip(req)
+	if err == nil && resp.StatusCode == http.StatusUnauthorized {
+		// The token was revoked or expired early: the next request, e.g. a
+		// retry, gets a new one.
+		t.tokens.invalidate(token)
+	}
+	return resp, err
+}
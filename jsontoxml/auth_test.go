@@ -0,0 +1,97 @@
+package jsontoxml
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseBasicAuth(t *testing.T) {
+	noPrompt := func(string) (string, error) { return "", errors.New("no terminal") }
+	user, pass, err := parseBasicAuth("", noPrompt)
+	require.NoError(t, err)
+	require.Empty(t, user)
+
+	user, pass, err = parseBasicAuth("alice:pa:ss", noPrompt)
+	require.NoError(t, err)
+	require.Equal(t, "alice", user)
+	require.Equal(t, "pa:ss", pass)
+
+	secrets["pw"] = "s3cr3t"
+	defer delete(secrets, "pw")
+	_, pass, err = parseBasicAuth("alice:${secret:pw}", noPrompt)
+	require.NoError(t, err)
+	require.Equal(t, "s3cr3t", pass)
+
+	_, pass, err = parseBasicAuth("alice", func(user string) (string, error) {
+		return "prompted-" + user, nil
+	})
+	require.NoError(t, err)
+	require.Equal(t, "prompted-alice", pass)
+
+	_, _, err = parseBasicAuth("alice", noPrompt)
+	require.Error(t, err)
+	_, _, err = parseBasicAuth(":pass", noPrompt)
+	require.Error(t, err)
+}
+
+func TestSetBasicAuth(t *testing.T) {
+	h := make(http.Header)
+	setBasicAuth(h, "alice", "s3cr3t")
+	req := &http.Request{Header: h}
+	user, pass, ok := req.BasicAuth()
+	require.True(t, ok)
+	require.Equal(t, "alice", user)
+	require.Equal(t, "s3cr3t", pass)
+}
+
+func TestOAuthTransport(t *testing.T) {
+	var issued int32
+	tokens := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id, secret, _ := r.BasicAuth()
+		if id != "client" || secret != "s3cr3t" || r.FormValue("grant_type") != "client_credentials" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		n := atomic.AddInt32(&issued, 1)
+		fmt.Fprintf(w, `{"access_token": "token%d", "expires_in": 3600}`, n)
+	}))
+	defer tokens.Close()
+	api := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") == "Bearer token2" {
+			w.WriteHeader(http.StatusUnauthorized)
+		}
+		w.Write([]byte(r.Header.Get("Authorization")))
+	}))
+	defer api.Close()
+
+	now := time.Now()
+	src := &tokenSource{url: tokens.URL, id: "client", secret: "s3cr3t",
+		now: func() time.Time { return now }}
+	c := &http.Client{Transport: &oauthTransport{tokens: src}}
+	get := func() int {
+		resp, err := c.Get(api.URL)
+		require.NoError(t, err)
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+	// The token is cached until it expires.
+	require.Equal(t, http.StatusOK, get())
+	require.Equal(t, http.StatusOK, get())
+	require.EqualValues(t, 1, issued)
+	now = now.Add(time.Hour)
+	// A rejected token is replaced on the next request.
+	require.Equal(t, http.StatusUnauthorized, get())
+	require.Equal(t, http.StatusOK, get())
+	require.EqualValues(t, 3, issued)
+
+	src = &tokenSource{url: tokens.URL, id: "client", secret: "wrong", now: time.Now}
+	_, err := (&http.Client{Transport: &oauthTransport{tokens: src}}).Get(api.URL)
+	require.Error(t, err)
+}
@@ -0,0 +1,418 @@
+package jsontoxml
+
+import (
+	"bufio"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// awsCredentials are the credentials used to sign requests to AWS.
+type awsCredentials struct {
+	accessKey    string
+	secretKey    string
+	sessionToken string
+	region       string
+}
+
+// awsCredentialsFromEnv reads the credentials from the standard AWS environment
+// variables. ok is false if they aren't set.
+func awsCredentialsFromEnv() (c awsCredentials, ok bool) {
+	c = awsCredentials{
+		accessKey:    os.Getenv("AWS_ACCESS_KEY_ID"),
+		secretKey:    os.Getenv("AWS_SECRET_ACCESS_KEY"),
+		sessionToken: os.Getenv("AWS_SESSION_TOKEN"),
+	}
+	return c, len(c.accessKey) > 0 && len(c.secretKey) > 0
+}
+
+// awsCredentialProvider returns the credentials of the standard AWS chain:
+// the environment variables, the shared credentials file, the container
+// credentials of ECS and then the instance metadata of EC2. The temporary
+// credentials of the last two are refreshed before they expire. It is safe for
+// concurrent use.
+type awsCredentialProvider struct {
+	region string
+	// refresh fetches temporary credentials, and the time they expire at. It
+	// is nil for static credentials.
+	refresh func() (awsCredentials, time.Time, error)
+
+	mu      sync.Mutex
+	creds   awsCredentials
+	expires time.Time
+}
+
+// awsRefreshWindow is how long before they expire the temporary credentials
+// are refreshed.
+const awsRefreshWindow = 5 * time.Minute
+
+// staticAWSCredentials returns a provider of the credentials c.
+func staticAWSCredentials(c awsCredentials) *awsCredentialProvider {
+	return &awsCredentialProvider{region: c.region, creds: c}
+}
+
+// newAWSCredentialProvider looks up the credentials and the region of the
+// standard AWS chain. client fetches the temporary credentials.
+func newAWSCredentialProvider(client *http.Client) (*awsCredentialProvider, error) {
+	profile := os.Getenv("AWS_PROFILE")
+	if len(profile) == 0 {
+		profile = "default"
+	}
+	region, err := awsRegion(profile)
+	if err != nil {
+		return nil, err
+	}
+	if len(region) == 0 {
+		return nil, errors.New("AWS_REGION or the region of the profile in ~/.aws/config" +
+			" must be set")
+	}
+	if c, ok := awsCredentialsFromEnv(); ok {
+		c.region = region
+		return staticAWSCredentials(c), nil
+	}
+	c, ok, err := awsCredentialsFromFile(profile)
+	if err != nil {
+		return nil, err
+	}
+	if ok {
+		c.region = region
+		return staticAWSCredentials(c), nil
+	}
+	p := &awsCredentialProvider{region: region}
+	if uri, token := containerCredentialsURI(); len(uri) > 0 {
+		p.refresh = func() (awsCredentials, time.Time, error) {
+			return fetchContainerCredentials(client, uri, token)
+		}
+	} else if os.Getenv("AWS_EC2_METADATA_DISABLED") != "true" {
+		endpoint := os.Getenv("AWS_EC2_METADATA_SERVICE_ENDPOINT")
+		if len(endpoint) == 0 {
+			endpoint = "http://169.254.169.254"
+		}
+		p.refresh = func() (awsCredentials, time.Time, error) {
+			return fetchInstanceCredentials(client, endpoint)
+		}
+	} else {
+		return nil, errors.New("no AWS credentials found. Set AWS_ACCESS_KEY_ID and" +
+			" AWS_SECRET_ACCESS_KEY, or the credentials of the profile in ~/.aws/credentials")
+	}
+	if _, err := p.get(); err != nil {
+		return nil, errors.Wrap(err, "no AWS credentials found in the environment or"+
+			" ~/.aws/credentials")
+	}
+	return p, nil
+}
+
+// get returns the credentials, refreshing them if they are about to expire.
+// Credentials that failed to refresh are used until they expire.
+func (p *awsCredentialProvider) get() (awsCredentials, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.refresh == nil {
+		return p.creds, nil
+	}
+	now := time.Now()
+	if now.Before(p.expires.Add(-awsRefreshWindow)) {
+		return p.creds, nil
+	}
+	c, expires, err := p.refresh()
+	if err != nil {
+		if now.Before(p.expires) {
+			return p.creds, nil
+		}
+		return awsCredentials{}, err
+	}
+	c.region = p.region
+	p.creds, p.expires = c, expires
+	return c, nil
+}
+
+// awsRegion returns the region of the environment, or else of the profile in
+// the config file.
+func awsRegion(profile string) (string, error) {
+	for _, env := range []string{"AWS_REGION", "AWS_DEFAULT_REGION"} {
+		if r := os.Getenv(env); len(r) > 0 {
+			return r, nil
+		}
+	}
+	path, err := awsConfigPath("AWS_CONFIG_FILE", "config")
+	if err != nil {
+		return "", err
+	}
+	section := "profile " + profile
+	if profile == "default" {
+		section = profile
+	}
+	values, err := readINISection(path, section)
+	return values["region"], err
+}
+
+// awsCredentialsFromFile reads the credentials of the profile from the shared
+// credentials file. ok is false if the file or the profile don't exist.
+func awsCredentialsFromFile(profile string) (c awsCredentials, ok bool, err error) {
+	path, err := awsConfigPath("AWS_SHARED_CREDENTIALS_FILE", "credentials")
+	if err != nil {
+		return c, false, err
+	}
+	values, err := readINISection(path, profile)
+	if err != nil {
+		return c, false, err
+	}
+	c = awsCredentials{
+		accessKey:    values["aws_access_key_id"],
+		secretKey:    values["aws_secret_access_key"],
+		sessionToken: values["aws_session_token"],
+	}
+	return c, len(c.accessKey) > 0 && len(c.secretKey) > 0, nil
+}
+
+// awsConfigPath returns the path of the file set by the env variable, or else
+// of the file name in ~/.aws.
+func awsConfigPath(env, name string) (string, error) {
+	if path := os.Getenv(env); len(path) > 0 {
+		return path, nil
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		// Without a home, there are no files.
+		return "", nil
+	}
+	return filepath.Join(home, ".aws", name), nil
+}
+
+// readINISection returns the keys of the section of the ini file. A missing
+// file has no sections.
+func readINISection(path, section string) (map[string]string, error) {
+	if len(path) == 0 {
+		return nil, nil
+	}
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	values := make(map[string]string)
+	in := false
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		line := strings.TrimSpace(sc.Text())
+		switch {
+		case len(line) == 0 || line[0] == '#' || line[0] == ';':
+		case line[0] == '[':
+			in = strings.TrimSpace(strings.Trim(line, "[]")) == section
+		case in:
+			if i := strings.IndexByte(line, '='); i > 0 {
+				values[strings.TrimSpace(line[:i])] = strings.TrimSpace(line[i+1:])
+			}
+		}
+	}
+	return values, errors.Wrapf(sc.Err(), "read %s", path)
+}
+
+// containerCredentialsURI returns the endpoint of the container credentials of
+// ECS, and the authorization token to send to it.
+func containerCredentialsURI() (uri, token string) {
+	token = os.Getenv("AWS_CONTAINER_AUTHORIZATION_TOKEN")
+	if rel := os.Getenv("AWS_CONTAINER_CREDENTIALS_RELATIVE_URI"); len(rel) > 0 {
+		return "http://169.254.170.2" + rel, token
+	}
+	return os.Getenv("AWS_CONTAINER_CREDENTIALS_FULL_URI"), token
+}
+
+// temporaryCredentials are the temporary credentials returned by the container
+// and the instance metadata endpoints.
+type temporaryCredentials struct {
+	AccessKeyID     string `json:"AccessKeyId"`
+	SecretAccessKey string
+	Token           string
+	Expiration      time.Time
+}
+
+func (t temporaryCredentials) credentials() (awsCredentials, time.Time, error) {
+	if len(t.AccessKeyID) == 0 || len(t.SecretAccessKey) == 0 {
+		return awsCredentials{}, time.Time{}, errors.New("no credentials in the response")
+	}
+	return awsCredentials{accessKey: t.AccessKeyID, secretKey: t.SecretAccessKey,
+		sessionToken: t.Token}, t.Expiration, nil
+}
+
+// fetchContainerCredentials fetches the credentials of the ECS task.
+func fetchContainerCredentials(client *http.Client, uri, token string) (awsCredentials,
+	time.Time, error) {
+	req, err := http.NewRequest(http.MethodGet, uri, nil)
+	if err != nil {
+		return awsCredentials{}, time.Time{}, err
+	}
+	if len(token) > 0 {
+		req.Header.Set("Authorization", token)
+	}
+	var t temporaryCredentials
+	if err := doJSONWith(client, req, &t); err != nil {
+		return awsCredentials{}, time.Time{}, errors.Wrap(err, "container credentials")
+	}
+	return t.credentials()
+}
+
+// fetchInstanceCredentials fetches the credentials of the role of the EC2
+// instance with IMDSv2.
+func fetchInstanceCredentials(client *http.Client, endpoint string) (awsCredentials,
+	time.Time, error) {
+	fail := func(err error) (awsCredentials, time.Time, error) {
+		return awsCredentials{}, time.Time{}, errors.Wrap(err, "instance metadata")
+	}
+	req, err := http.NewRequest(http.MethodPut, endpoint+"/latest/api/token", nil)
+	if err != nil {
+		return fail(err)
+	}
+	req.Header.Set("X-Aws-Ec2-Metadata-Token-Ttl-Seconds", "21600")
+	token, err := getText(client, req)
+	if err != nil {
+		return fail(err)
+	}
+	const credsPath = "/latest/meta-data/iam/security-credentials/"
+	req, err = http.NewRequest(http.MethodGet, endpoint+credsPath, nil)
+	if err != nil {
+		return fail(err)
+	}
+	req.Header.Set("X-Aws-Ec2-Metadata-Token", token)
+	roles, err := getText(client, req)
+	if err != nil {
+		return fail(err)
+	}
+	role := strings.TrimSpace(strings.SplitN(roles, "\n", 2)[0])
+	if len(role) == 0 {
+		return fail(errors.New("the instance has no role"))
+	}
+	req, err = http.NewRequest(http.MethodGet, endpoint+credsPath+role, nil)
+	if err != nil {
+		return fail(err)
+	}
+	req.Header.Set("X-Aws-Ec2-Metadata-Token", token)
+	var t temporaryCredentials
+	if err := doJSONWith(client, req, &t); err != nil {
+		return fail(err)
+	}
+	return t.credentials()
+}
+
+// getText sends the request and returns the text of the response.
+func getText(client *http.Client, req *http.Request) (string, error) {
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(io.LimitReader(resp.Body, 64<<10))
+	if err != nil {
+		return "", err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", errors.Errorf("%s returned %q", req.URL.Redacted(), resp.Status)
+	}
+	return string(body), nil
+}
+
+// signV4 signs the request with AWS Signature Version 4. payload must be the
+// request body.
+func (c awsCredentials) signV4(req *http.Request, payload []byte, service string,
+	now time.Time) {
+	now = now.UTC()
+	amzDate := now.Format("20060102T150405Z")
+	date := now.Format("20060102")
+	payloadHash := sha256Hex(payload)
+
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+	if len(c.sessionToken) > 0 {
+		req.Header.Set("X-Amz-Security-Token", c.sessionToken)
+	}
+
+	headers := map[string]string{"host": req.URL.Host}
+	for k, v := range req.Header {
+		headers[strings.ToLower(k)] = strings.TrimSpace(strings.Join(v, ","))
+	}
+	names := make([]string, 0, len(headers))
+	for k := range headers {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	var canonicalHeaders strings.Builder
+	for _, k := range names {
+		canonicalHeaders.WriteString(k + ":" + headers[k] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	path := awsEscapePath(req.URL.Path)
+	if len(path) == 0 {
+		path = "/"
+	}
+	query := strings.Replace(req.URL.Query().Encode(), "+", "%20", -1)
+	canonicalRequest := strings.Join([]string{req.Method, path, query,
+		canonicalHeaders.String(), signedHeaders, payloadHash}, "\n")
+
+	scope := strings.Join([]string{date, c.region, service, "aws4_request"}, "/")
+	stringToSign := strings.Join([]string{"AWS4-HMAC-SHA256", amzDate, scope,
+		sha256Hex([]byte(canonicalRequest))}, "\n")
+
+	key := hmacSHA256([]byte("AWS4"+c.secretKey), date)
+	key = hmacSHA256(key, c.region)
+	key = hmacSHA256(key, service)
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set("Authorization", "AWS4-HMAC-SHA256 Credential="+c.accessKey+"/"+scope+
+		", SignedHeaders="+signedHeaders+", Signature="+signature)
+}
+
+// awsEscapePath URI-encodes every segment of the path the way AWS does: all
+// the bytes but the unreserved characters are percent-encoded. The canonical
+// path of the signature is encoded once, as S3 expects, so the request must be
+// sent with the same encoding for the signature to match.
+func awsEscapePath(p string) string {
+	segments := strings.Split(p, "/")
+	for i, s := range segments {
+		segments[i] = awsURIEncode(s)
+	}
+	return strings.Join(segments, "/")
+}
+
+// awsURIEncode percent-encodes the bytes of s other than A-Z, a-z, 0-9, '-',
+// '.', '_' and '~'.
+func awsURIEncode(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if 'A' <= c && c <= 'Z' || 'a' <= c && c <= 'z' || '0' <= c && c <= '9' ||
+			c == '-' || c == '.' || c == '_' || c == '~' {
+			b.WriteByte(c)
+			continue
+		}
+		fmt.Fprintf(&b, "%%%02X", c)
+	}
+	return b.String()
+}
+
+func sha256Hex(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
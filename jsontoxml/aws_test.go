@@ -0,0 +1,135 @@
+package jsontoxml
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// clearAWSEnv unsets the AWS environment variables for the duration of the
+// test, and points the AWS files at dir.
+func clearAWSEnv(t *testing.T, dir string) {
+	for _, env := range []string{"AWS_ACCESS_KEY_ID", "AWS_SECRET_ACCESS_KEY",
+		"AWS_SESSION_TOKEN", "AWS_REGION", "AWS_DEFAULT_REGION", "AWS_PROFILE",
+		"AWS_CONTAINER_CREDENTIALS_RELATIVE_URI", "AWS_CONTAINER_CREDENTIALS_FULL_URI",
+		"AWS_CONTAINER_AUTHORIZATION_TOKEN", "AWS_EC2_METADATA_SERVICE_ENDPOINT"} {
+		t.Setenv(env, "")
+	}
+	t.Setenv("AWS_EC2_METADATA_DISABLED", "true")
+	t.Setenv("AWS_CONFIG_FILE", filepath.Join(dir, "config"))
+	t.Setenv("AWS_SHARED_CREDENTIALS_FILE", filepath.Join(dir, "credentials"))
+}
+
+func TestAWSCredentialChain(t *testing.T) {
+	dir := t.TempDir()
+	clearAWSEnv(t, dir)
+	client := &http.Client{Timeout: time.Second}
+
+	_, err := newAWSCredentialProvider(client)
+	require.EqualError(t, err, "AWS_REGION or the region of the profile in ~/.aws/config must be set")
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, "config"), []byte(
+		"[default]\nregion = eu-west-1\n\n[profile ci]\nregion = us-east-2\n"), 0600))
+	_, err = newAWSCredentialProvider(client)
+	require.EqualError(t, err, "no AWS credentials found. Set AWS_ACCESS_KEY_ID and"+
+		" AWS_SECRET_ACCESS_KEY, or the credentials of the profile in ~/.aws/credentials")
+
+	// The shared credentials file.
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, "credentials"), []byte(
+		"# comment\n[default]\naws_access_key_id = AKDEFAULT\naws_secret_access_key = s1\n"+
+			"[ci]\naws_access_key_id=AKCI\naws_secret_access_key=s2\naws_session_token=tok\n"),
+		0600))
+	p, err := newAWSCredentialProvider(client)
+	require.NoError(t, err)
+	c, err := p.get()
+	require.NoError(t, err)
+	require.Equal(t, awsCredentials{accessKey: "AKDEFAULT", secretKey: "s1", region: "eu-west-1"}, c)
+	t.Setenv("AWS_PROFILE", "ci")
+	p, err = newAWSCredentialProvider(client)
+	require.NoError(t, err)
+	c, err = p.get()
+	require.NoError(t, err)
+	require.Equal(t, awsCredentials{accessKey: "AKCI", secretKey: "s2", sessionToken: "tok",
+		region: "us-east-2"}, c)
+
+	// The environment takes precedence.
+	t.Setenv("AWS_ACCESS_KEY_ID", "AKENV")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "s3")
+	t.Setenv("AWS_REGION", "ap-south-1")
+	p, err = newAWSCredentialProvider(client)
+	require.NoError(t, err)
+	c, err = p.get()
+	require.NoError(t, err)
+	require.Equal(t, awsCredentials{accessKey: "AKENV", secretKey: "s3", region: "ap-south-1"}, c)
+}
+
+func TestAWSTemporaryCredentials(t *testing.T) {
+	dir := t.TempDir()
+	clearAWSEnv(t, dir)
+	t.Setenv("AWS_REGION", "us-east-1")
+	var fetches int32
+	expires := time.Now().Add(time.Hour)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/latest/api/token":
+			require.Equal(t, http.MethodPut, r.Method)
+			require.Equal(t, "21600", r.Header.Get("X-Aws-Ec2-Metadata-Token-Ttl-Seconds"))
+			fmt.Fprint(w, "imds-token")
+			return
+		case "/latest/meta-data/iam/security-credentials/":
+			fmt.Fprint(w, "role\n")
+			return
+		case "/latest/meta-data/iam/security-credentials/role":
+			require.Equal(t, "imds-token", r.Header.Get("X-Aws-Ec2-Metadata-Token"))
+		case "/task":
+			require.Equal(t, "secret-token", r.Header.Get("Authorization"))
+		default:
+			http.NotFound(w, r)
+			return
+		}
+		n := atomic.AddInt32(&fetches, 1)
+		fmt.Fprintf(w, `{"AccessKeyId": "ASIA%d", "SecretAccessKey": "s", "Token": "t",
+			"Expiration": %q}`, n, expires.Format(time.RFC3339))
+	}))
+	defer srv.Close()
+
+	// The instance metadata.
+	t.Setenv("AWS_EC2_METADATA_DISABLED", "false")
+	t.Setenv("AWS_EC2_METADATA_SERVICE_ENDPOINT", srv.URL)
+	p, err := newAWSCredentialProvider(srv.Client())
+	require.NoError(t, err)
+	c, err := p.get()
+	require.NoError(t, err)
+	require.Equal(t, awsCredentials{accessKey: "ASIA1", secretKey: "s", sessionToken: "t",
+		region: "us-east-1"}, c)
+	// The credentials are cached until they are about to expire.
+	c, err = p.get()
+	require.NoError(t, err)
+	require.Equal(t, "ASIA1", c.accessKey)
+	expires = time.Now().Add(time.Minute)
+	p.expires = expires
+	c, err = p.get()
+	require.NoError(t, err)
+	require.Equal(t, "ASIA2", c.accessKey)
+
+	// The container credentials take precedence.
+	expires = time.Now().Add(time.Hour)
+	t.Setenv("AWS_CONTAINER_CREDENTIALS_FULL_URI", srv.URL+"/task")
+	t.Setenv("AWS_CONTAINER_AUTHORIZATION_TOKEN", "secret-token")
+	p, err = newAWSCredentialProvider(srv.Client())
+	require.NoError(t, err)
+	c, err = p.get()
+	require.NoError(t, err)
+	require.Equal(t, "ASIA3", c.accessKey)
+
+	t.Setenv("AWS_CONTAINER_CREDENTIALS_FULL_URI", srv.URL+"/missing")
+	_, err = newAWSCredentialProvider(srv.Client())
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "container credentials")
+}
@@ -0,0 +1,122 @@
+package jsontoxml
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// azureStorageVersion is the version of the Blob service REST API used.
+const azureStorageVersion = "2020-10-02"
+
+// azureCredentials authorize the requests to an Azure storage account, with a
+// shared key or a SAS token.
+type azureCredentials struct {
+	account string
+	// key is the decoded shared key of the account, if set.
+	key []byte
+	// sas is the query of a SAS token, if set.
+	sas string
+}
+
+// azureCredentialsFromEnv reads the credentials of the account from
+// AZURE_STORAGE_KEY or AZURE_STORAGE_SAS_TOKEN.
+func azureCredentialsFromEnv(account string) (azureCredentials, error) {
+	c := azureCredentials{account: account}
+	if sas := os.Getenv("AZURE_STORAGE_SAS_TOKEN"); len(sas) > 0 {
+		c.sas = strings.TrimPrefix(sas, "?")
+		return c, nil
+	}
+	key := os.Getenv("AZURE_STORAGE_KEY")
+	if len(key) == 0 {
+		return c, errors.New("AZURE_STORAGE_KEY or AZURE_STORAGE_SAS_TOKEN must be set")
+	}
+	decoded, err := base64.StdEncoding.DecodeString(key)
+	if err != nil {
+		return c, errors.New("invalid AZURE_STORAGE_KEY: not base64")
+	}
+	c.key = decoded
+	return c, nil
+}
+
+// authorize adds the SAS token to the url of the request, or signs it with the
+// shared key.
+func (c azureCredentials) authorize(req *http.Request, now time.Time) {
+	req.Header.Set("X-Ms-Date", now.UTC().Format(http.TimeFormat))
+	req.Header.Set("X-Ms-Version", azureStorageVersion)
+	if len(c.sas) > 0 {
+		if len(req.URL.RawQuery) > 0 {
+			req.URL.RawQuery += "&"
+		}
+		req.URL.RawQuery += c.sas
+		return
+	}
+	req.Header.Set("Authorization", "SharedKey "+c.account+":"+c.signature(req))
+}
+
+// signature returns the Shared Key signature of the request.
+func (c azureCredentials) signature(req *http.Request) string {
+	length := ""
+	if req.ContentLength > 0 {
+		length = strconv.FormatInt(req.ContentLength, 10)
+	}
+	h := req.Header
+	var b strings.Builder
+	for _, s := range []string{req.Method, h.Get("Content-Encoding"), h.Get("Content-Language"),
+		length, h.Get("Content-Md5"), h.Get("Content-Type"), "", h.Get("If-Modified-Since"),
+		h.Get("If-Match"), h.Get("If-None-Match"), h.Get("If-Unmodified-Since"),
+		h.Get("Range")} {
+		b.WriteString(s + "\n")
+	}
+	var names []string
+	for k := range h {
+		if k := strings.ToLower(k); strings.HasPrefix(k, "x-ms-") {
+			names = append(names, k)
+		}
+	}
+	sort.Strings(names)
+	for _, k := range names {
+		b.WriteString(k + ":" + strings.TrimSpace(h.Get(k)) + "\n")
+	}
+	b.WriteString("/" + c.account + req.URL.EscapedPath())
+	query := req.URL.Query()
+	keys := make([]string, 0, len(query))
+	for k := range query {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		values := query[k]
+		sort.Strings(values)
+		b.WriteString("\n" + strings.ToLower(k) + ":" + strings.Join(values, ","))
+	}
+	mac := hmac.New(sha256.New, c.key)
+	mac.Write([]byte(b.String()))
+	return base64.StdEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// parseAzureOutput parses az://account/container/prefix.
+func parseAzureOutput(output string) (account, container, prefix string, err error) {
+	u, err := url.Parse(output)
+	parts := []string{}
+	if err == nil {
+		parts = strings.SplitN(strings.Trim(u.Path, "/"), "/", 2)
+	}
+	if err != nil || len(u.Host) == 0 || len(parts[0]) == 0 {
+		return "", "", "", errors.Errorf("invalid azure output %q. Expected"+
+			" az://account/container/prefix", output)
+	}
+	if len(parts) == 2 {
+		prefix = parts[1]
+	}
+	return u.Host, parts[0], prefix, nil
+}
@@ -0,0 +1,142 @@
+package jsontoxml
+
+import (
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// maxBackfillPeriods guards against ranges generating an endless url list,
+// e.g. a typo in a year.
+const maxBackfillPeriods = 100000
+
+// Steps of a backfill.
+const (
+	backfillDay  = "day"
+	backfillHour = "hour"
+)
+
+var (
+	backfillSpec, backfillStep string
+	// backfill is parsed from --backfill, if set.
+	backfill *backfillRange
+)
+
+func init() {
+	rootCmd.PersistentFlags().StringVar(&backfillSpec, "backfill", "",
+		"Range of dates, e.g. 2023-01-01..2023-12-31, of a historical load: every url is a"+
+			" template fetched once per period of the range, with {date}, {hour}, {start} and"+
+			" {end} replaced by those of the period.")
+	rootCmd.PersistentFlags().StringVar(&backfillStep, "backfill-step", backfillDay,
+		"Period of the --backfill urls: day or hour.")
+}
+
+// backfillRange is the range of the periods of a backfill, in UTC. to is
+// exclusive.
+type backfillRange struct {
+	from, to time.Time
+	hourly   bool
+}
+
+// parseBackfillTime parses a bound of a backfill: a date, a date and an hour
+// (2023-01-01T13) or an RFC 3339 time. dateOnly is set for dates.
+func parseBackfillTime(s string) (t time.Time, dateOnly bool, err error) {
+	if t, err = time.Parse("2006-01-02", s); err == nil {
+		return t, true, nil
+	}
+	if t, err = time.Parse("2006-01-02T15", s); err == nil {
+		return t, false, nil
+	}
+	t, err = time.Parse(time.RFC3339, s)
+	return t.UTC(), false, err
+}
+
+// parseBackfill parses the range from..to of --backfill, whose bounds are
+// inclusive, e.g. 2023-01-01..2023-01-31 is the whole of January. It returns
+// nil if spec is empty.
+func parseBackfill(spec, step string) (*backfillRange, error) {
+	if len(spec) == 0 {
+		return nil, nil
+	}
+	invalid := func(msg string) error {
+		return errors.Errorf("invalid --backfill %q: %s", spec, msg)
+	}
+	bounds := strings.Split(spec, "..")
+	if len(bounds) != 2 {
+		return nil, invalid("expected from..to")
+	}
+	from, _, err := parseBackfillTime(bounds[0])
+	if err != nil {
+		return nil, invalid("expected a date, e.g. 2023-01-01, or an hour, e.g. 2023-01-01T13")
+	}
+	to, dateOnly, err := parseBackfillTime(bounds[1])
+	if err != nil {
+		return nil, invalid("expected a date, e.g. 2023-01-31, or an hour, e.g. 2023-01-31T23")
+	}
+	r := &backfillRange{}
+	switch step {
+	case backfillDay:
+		r.from, r.to = from.Truncate(24*time.Hour), to.Truncate(24*time.Hour).AddDate(0, 0, 1)
+	case backfillHour:
+		r.from, r.to = from.Truncate(time.Hour), to.Truncate(time.Hour).Add(time.Hour)
+		r.hourly = true
+		if dateOnly {
+			// The last date covers all its hours.
+			r.to = to.AddDate(0, 0, 1)
+		}
+	default:
+		return nil, errors.Errorf("invalid --backfill-step %q. Expected day or hour", step)
+	}
+	if !r.from.Before(r.to) {
+		return nil, invalid("from is after to")
+	}
+	if r.to.Sub(r.from)/r.step() > maxBackfillPeriods {
+		return nil, invalid("more than 100000 periods")
+	}
+	return r, nil
+}
+
+// step returns the duration of the periods.
+func (r *backfillRange) step() time.Duration {
+	if r.hourly {
+		return time.Hour
+	}
+	return 24 * time.Hour
+}
+
+// hasPlaceholder returns true if the url template has a placeholder of a
+// backfill.
+func hasPlaceholder(tmpl string) bool {
+	for _, p := range []string{"{date}", "{hour}", "{start}", "{end}"} {
+		if strings.Contains(tmpl, p) {
+			return true
+		}
+	}
+	return false
+}
+
+// expand returns the urls of the templates, period by period: all the
+// templates of the first period come first.
+func (r *backfillRange) expand(templates []string) ([]string, error) {
+	for _, tmpl := range templates {
+		if !hasPlaceholder(tmpl) {
+			return nil, errors.Errorf("--backfill url %q has no {date}, {hour}, {start} nor {end}",
+				tmpl)
+		}
+	}
+	var urls []string
+	for start := r.from; start.Before(r.to); start = start.Add(r.step()) {
+		end := start.Add(r.step())
+		repl := strings.NewReplacer(
+			"{date}", start.Format("2006-01-02"),
+			"{hour}", start.Format("15"),
+			"{start}", start.Format(time.RFC3339),
+			"{end}", end.Format(time.RFC3339),
+		)
+		for _, tmpl := range templates {
+			urls = append(urls, repl.Replace(tmpl))
+		}
+	}
+	return urls, nil
+}
@@ -0,0 +1,77 @@
+package jsontoxml
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseBackfill(t *testing.T) {
+	r, err := parseBackfill("", backfillDay)
+	require.NoError(t, err)
+	require.Nil(t, r)
+
+	for spec, step := range map[string]string{
+		"2023-01-01":                           backfillDay,
+		"2023-01-02..2023-01-01":               backfillDay,
+		"2023-01-01..2023-13-01":               backfillDay,
+		"2023-01-01..2023-01-02":               "week",
+		"2000-01-01..2099-12-31":               backfillHour,
+		"2023-01-01T10..2023-01-01T09":         backfillHour,
+		"2023-01-01..2023-01-02..2023-01-03":   backfillDay,
+		"2023-01-01T00:00:00..2023-01-02T00":   backfillDay,
+		"2023-01-01T00:00:00Z..2023-01-02T0:0": backfillDay,
+	} {
+		_, err := parseBackfill(spec, step)
+		require.Error(t, err, spec)
+	}
+}
+
+func TestBackfillExpand(t *testing.T) {
+	r, err := parseBackfill("2023-01-30..2023-02-01", backfillDay)
+	require.NoError(t, err)
+	urls, err := r.expand([]string{
+		"http://a/{date}.json",
+		"http://b/x?from={start}&to={end}|http://c/{date}",
+	})
+	require.NoError(t, err)
+	require.Equal(t, []string{
+		"http://a/2023-01-30.json",
+		"http://b/x?from=2023-01-30T00:00:00Z&to=2023-01-31T00:00:00Z|http://c/2023-01-30",
+		"http://a/2023-01-31.json",
+		"http://b/x?from=2023-01-31T00:00:00Z&to=2023-02-01T00:00:00Z|http://c/2023-01-31",
+		"http://a/2023-02-01.json",
+		"http://b/x?from=2023-02-01T00:00:00Z&to=2023-02-02T00:00:00Z|http://c/2023-02-01",
+	}, urls)
+
+	_, err = r.expand([]string{"http://a/{date}", "http://a/static"})
+	require.Error(t, err)
+
+	// The hours of the last date are included.
+	r, err = parseBackfill("2023-01-01T22..2023-01-02", backfillHour)
+	require.NoError(t, err)
+	urls, err = r.expand([]string{"http://a/{date}/{hour}"})
+	require.NoError(t, err)
+	require.Len(t, urls, 26)
+	require.Equal(t, "http://a/2023-01-01/22", urls[0])
+	require.Equal(t, "http://a/2023-01-02/23", urls[25])
+
+	r, err = parseBackfill("2023-01-01T22..2023-01-01T23", backfillHour)
+	require.NoError(t, err)
+	urls, err = r.expand([]string{"http://a/{start}"})
+	require.NoError(t, err)
+	require.Equal(t, []string{"http://a/2023-01-01T22:00:00Z", "http://a/2023-01-01T23:00:00Z"},
+		urls)
+}
+
+func TestBackfillURLList(t *testing.T) {
+	urls, backfill = "http://a/{date},http://b/{date}", nil
+	defer func() { urls, backfill = "", nil }()
+	var err error
+	backfill, err = parseBackfill("2023-01-01..2023-01-02", backfillDay)
+	require.NoError(t, err)
+	list, err := urlList()
+	require.NoError(t, err)
+	require.Equal(t, []string{"http://a/2023-01-01", "http://b/2023-01-01", "http://a/2023-01-02",
+		"http://b/2023-01-02"}, list)
+}
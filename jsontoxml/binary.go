@@ -0,0 +1,211 @@
+package jsontoxml
+
+import (
+	"encoding/base64"
+	"encoding/xml"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// Policies of the base64 values of --binary-field.
+const (
+	// binaryInline writes the base64 text in the element. It is the default.
+	binaryInline = "inline"
+	// binaryFile decodes the value to a file next to the document, referenced
+	// by the href attribute of the element.
+	binaryFile = "file"
+	// binaryDrop leaves the value out of the document.
+	binaryDrop = "drop"
+)
+
+// hrefAttr holds the name of the file of a binaryFile value, relative to the
+// document.
+const hrefAttr = "href"
+
+var binaryFields []string
+
+func init() {
+	rootCmd.PersistentFlags().StringArrayVar(&binaryFields, "binary-field", nil,
+		"Handling of the base64 values of a json key, as key=policy: inline (the default),"+
+			" file (decoded to a .bin file next to the document, referenced by an href"+
+			" attribute) or drop. Applies to --generic, --openapi and --preset. Can be repeated.")
+}
+
+// binaryOptions apply the policies of the base64 values. A nil binaryOptions
+// keeps every value inline.
+type binaryOptions struct {
+	// fields are the policies by json key.
+	fields map[string]string
+}
+
+// newBinaryOptions builds the binary options from the flags. It returns nil if
+// every value is kept inline.
+func newBinaryOptions(fields []string) (*binaryOptions, error) {
+	bo := &binaryOptions{fields: make(map[string]string)}
+	for _, f := range fields {
+		parts := strings.SplitN(f, "=", 2)
+		if len(parts) != 2 || len(parts[0]) == 0 {
+			return nil, errors.Errorf("invalid --binary-field %q. Expected key=policy", f)
+		}
+		switch parts[1] {
+		case binaryInline:
+			continue
+		case binaryFile, binaryDrop:
+			bo.fields[parts[0]] = parts[1]
+		default:
+			return nil, errors.Errorf("invalid --binary-field %q. Expected a policy of inline,"+
+				" file or drop", f)
+		}
+	}
+	if len(bo.fields) == 0 {
+		return nil, nil
+	}
+	return bo, nil
+}
+
+// policy returns the policy of the values of the json key.
+func (bo *binaryOptions) policy(key string) string {
+	if bo == nil {
+		return binaryInline
+	}
+	if p, ok := bo.fields[key]; ok {
+		return p
+	}
+	return binaryInline
+}
+
+// files returns true if a policy writes files.
+func (bo *binaryOptions) files() bool {
+	if bo == nil {
+		return false
+	}
+	for _, p := range bo.fields {
+		if p == binaryFile {
+			return true
+		}
+	}
+	return false
+}
+
+// decodeBase64 decodes s, with or without padding, in the standard or the url
+// alphabet.
+func decodeBase64(s string) ([]byte, error) {
+	s = strings.TrimRight(s, "=")
+	if data, err := base64.RawStdEncoding.DecodeString(s); err == nil {
+		return data, nil
+	}
+	return base64.RawURLEncoding.DecodeString(s)
+}
+
+// encodeField encodes the value v of the json key as the element start,
+// applying the policy of the key to its base64 value. The values kept inline
+// are written in a CDATA section if the key asks for it.
+func (o *convertOptions) encodeField(enc *xml.Encoder, key string, start xml.StartElement,
+	v interface{}) error {
+	s, ok := v.(string)
+	inline := func() error {
+		if ok && o.cdataFields[key] {
+			return o.encodeCDATA(enc, start, s)
+		}
+		return o.encodeValue(enc, start, v, nil)
+	}
+	policy := o.binaryFields.policy(key)
+	if policy == binaryInline || !ok {
+		return inline()
+	}
+	if policy == binaryDrop {
+		return nil
+	}
+	data, err := decodeBase64(s)
+	if err != nil {
+		o.warn("value of %s isn't base64, kept inline", key)
+		return inline()
+	}
+	if o.attachments == nil {
+		o.warn("value of %s kept inline, the output has no files", key)
+		return inline()
+	}
+	href := o.attachments.add(start.Name.Local, data)
+	start.Attr = append(start.Attr, xml.Attr{Name: xml.Name{Local: hrefAttr}, Value: href})
+	if err := enc.EncodeToken(start); err != nil {
+		return err
+	}
+	return enc.EncodeToken(start.End())
+}
+
+// attachment is a file written next to a document.
+type attachment struct {
+	name string
+	data []byte
+}
+
+// attachmentSink writes the files of the binaryFile values of the documents
+// written to sink. The converter adds them while the document is written, and
+// they are written once it is committed, under its name with the element name
+// and the index of the file, e.g. 0-image-1.bin for 0.xml.
+type attachmentSink struct {
+	sink  Sink
+	name  string
+	files []attachment
+}
+
+// add queues the file of the element name. It returns its name, relative to
+// the document.
+func (s *attachmentSink) add(element string, data []byte) string {
+	name := strings.TrimSuffix(s.name, path.Ext(s.name)) + "-" + element + "-" +
+		strconv.Itoa(len(s.files)+1) + ".bin"
+	s.files = append(s.files, attachment{name: name, data: data})
+	return path.Base(name)
+}
+
+func (s *attachmentSink) Open(name string) error {
+	s.name, s.files = name, nil
+	return s.sink.Open(name)
+}
+
+func (s *attachmentSink) Write(p []byte) (int, error) {
+	return s.sink.Write(p)
+}
+
+func (s *attachmentSink) Commit() error {
+	files := s.files
+	s.files = nil
+	if err := s.sink.Commit(); err != nil {
+		return err
+	}
+	for _, f := range files {
+		if err := s.sink.Open(f.name); err != nil {
+			return errors.Wrap(err, "binary file")
+		}
+		if _, err := s.sink.Write(f.data); err != nil {
+			s.sink.Abort()
+			return errors.Wrap(err, "binary file")
+		}
+		if err := s.sink.Commit(); err != nil {
+			return errors.Wrap(err, "binary file")
+		}
+	}
+	return nil
+}
+
+func (s *attachmentSink) Abort() error {
+	s.files = nil
+	return s.sink.Abort()
+}
+
+func (s *attachmentSink) Exists(name string) bool {
+	es, ok := s.sink.(ExistingSink)
+	return ok && es.Exists(name)
+}
+
+// KeepPartial keeps the partial document, if sink can, without its files.
+func (s *attachmentSink) KeepPartial() error {
+	s.files = nil
+	if ps, ok := s.sink.(PartialSink); ok {
+		return ps.KeepPartial()
+	}
+	return s.sink.Abort()
+}
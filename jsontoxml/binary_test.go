@@ -0,0 +1,63 @@
+package jsontoxml
+
+import (
+	"context"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewBinaryOptions(t *testing.T) {
+	bo, err := newBinaryOptions([]string{"photo=inline"})
+	require.NoError(t, err)
+	require.Nil(t, bo)
+	bo, err = newBinaryOptions([]string{"photo=file", "thumb=drop"})
+	require.NoError(t, err)
+	require.Equal(t, binaryFile, bo.policy("photo"))
+	require.Equal(t, binaryInline, bo.policy("name"))
+	require.True(t, bo.files())
+	_, err = newBinaryOptions([]string{"photo=zip"})
+	require.Error(t, err)
+	_, err = newBinaryOptions([]string{"photo"})
+	require.Error(t, err)
+}
+
+func TestBinaryFields(t *testing.T) {
+	bo, err := newBinaryOptions([]string{"photo=file", "thumb=drop"})
+	require.NoError(t, err)
+	warnings := &docWarnings{}
+	opts := &convertOptions{generic: true, binaryFields: bo, warnings: warnings,
+		layout: &xmlLayout{compact: true}}
+	// Without a sink, the files are kept inline.
+	out, err := convert([]byte(`{"photo": "aGk=", "thumb": "aGk=", "name": "a"}`), opts)
+	require.NoError(t, err)
+	require.Equal(t, `<jsonData><name>a</name><photo>aGk=</photo></jsonData>`, string(out))
+	require.Equal(t, []string{"value of photo kept inline, the output has no files"},
+		warnings.messages())
+}
+
+func TestRunnerBinaryFiles(t *testing.T) {
+	dir := t.TempDir()
+	src := filepath.Join(dir, "in.json")
+	payload := `{"photo": "aGVsbG8", "doc": {"photo": "not base64!"}}`
+	require.NoError(t, ioutil.WriteFile(src, []byte(payload), 0600))
+	bo, err := newBinaryOptions([]string{"photo=file"})
+	require.NoError(t, err)
+	out := filepath.Join(dir, "out")
+	r := &Runner{NewSink: func() Sink { return &fileSink{dir: out} }}
+	r.opts = &convertOptions{generic: true, binaryFields: bo, layout: &xmlLayout{compact: true}}
+	r.Add(&fileSource{paths: []string{src}})
+	rep, err := r.Run(context.Background())
+	require.NoError(t, err)
+	require.Zero(t, rep.Failed)
+	data, err := ioutil.ReadFile(filepath.Join(out, "0.xml"))
+	require.NoError(t, err)
+	require.Equal(t, `<jsonData><doc><photo>not base64!</photo></doc>`+
+		`<photo href="0-photo-1.bin"></photo></jsonData>`, string(data))
+	data, err = ioutil.ReadFile(filepath.Join(out, "0-photo-1.bin"))
+	require.NoError(t, err)
+	require.Equal(t, "hello", string(data))
+	require.Equal(t, []string{"value of photo isn't base64, kept inline"}, rep.Warnings[src])
+}
@@ -0,0 +1,117 @@
+package jsontoxml
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+var (
+	maxURLs       int
+	maxTotalBytes int64
+	maxDuration   time.Duration
+)
+
+func init() {
+	rootCmd.PersistentFlags().IntVar(&maxURLs, "max-urls", 0,
+		"Maximum number of urls (or files) processed by the run. The run stops once they are"+
+			" processed. No limit if 0.")
+	rootCmd.PersistentFlags().Int64Var(&maxTotalBytes, "max-total-bytes", 0,
+		"Maximum number of payload bytes read by the run. The run is canceled once it reads"+
+			" more, as with SIGINT. No limit if 0.")
+	rootCmd.PersistentFlags().DurationVar(&maxDuration, "max-duration", 0,
+		"Maximum duration of the run. The run is canceled after it, as with SIGINT. No limit"+
+			" if 0.")
+}
+
+// Budget bounds a run, so that a misconfigured run can't go on for days. The
+// zero value has no limits.
+type Budget struct {
+	// MaxPayloads is the number of payloads processed by the run. Once they
+	// are claimed, the payloads in progress complete and the run stops.
+	MaxPayloads int
+	// MaxBytes is the number of payload bytes read by the run. The run is
+	// canceled once it reads more.
+	MaxBytes int64
+	// MaxDuration is the duration of the run, after which it is canceled.
+	MaxDuration time.Duration
+}
+
+// BudgetError is returned by Run when the run exceeded its budget.
+type BudgetError struct {
+	// Limit describes the exceeded limit.
+	Limit string
+}
+
+func (e *BudgetError) Error() string {
+	return "budget exceeded: " + e.Limit
+}
+
+// runBudget tracks the budget of a run. It is safe for concurrent use.
+type runBudget struct {
+	Budget
+	// cancel cancels the payloads in progress.
+	cancel   context.CancelFunc
+	payloads int64
+	bytes    int64
+
+	mu  sync.Mutex
+	err *BudgetError
+}
+
+// stop stops the run for err, canceling the payloads in progress if cancel
+// is set. Only the first error is kept.
+func (b *runBudget) stop(err *BudgetError, cancel bool) {
+	b.mu.Lock()
+	if b.err == nil {
+		b.err = err
+	}
+	b.mu.Unlock()
+	if cancel {
+		b.cancel()
+	}
+}
+
+// exceeded returns the error that stopped the run, or nil.
+func (b *runBudget) exceeded() *BudgetError {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.err
+}
+
+// take counts a claimed payload. It returns false, and stops the run, if the
+// payload is over budget.
+func (b *runBudget) take() bool {
+	if n := atomic.AddInt64(&b.payloads, 1); b.MaxPayloads > 0 && n > int64(b.MaxPayloads) {
+		b.stop(&BudgetError{Limit: fmt.Sprintf("more than %d payloads", b.MaxPayloads)}, false)
+		return false
+	}
+	return true
+}
+
+// reader counts the bytes read from body.
+func (b *runBudget) reader(body io.ReadCloser) io.ReadCloser {
+	if b.MaxBytes <= 0 {
+		return body
+	}
+	return &budgetReader{ReadCloser: body, b: b}
+}
+
+type budgetReader struct {
+	io.ReadCloser
+	b *runBudget
+}
+
+func (r *budgetReader) Read(p []byte) (int, error) {
+	n, err := r.ReadCloser.Read(p)
+	if atomic.AddInt64(&r.b.bytes, int64(n)) > r.b.MaxBytes {
+		// The payload fails too, even if it was read fully.
+		berr := &BudgetError{Limit: fmt.Sprintf("more than %d payload bytes read", r.b.MaxBytes)}
+		r.b.stop(berr, true)
+		return n, berr
+	}
+	return n, err
+}
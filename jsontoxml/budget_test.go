@@ -0,0 +1,65 @@
+package jsontoxml
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestRunnerBudget(t *testing.T) {
+	var sinks memSinks
+	valid := []string{"valid", "valid", "valid", "valid"}
+	r := &Runner{NewSink: sinks.newSink, Budget: Budget{MaxPayloads: 4}}
+	r.Add(&urlSource{client: new(mockClient), urls: valid})
+	rep, err := r.Run(context.Background())
+	require.NoError(t, err)
+	require.Equal(t, 4, rep.Processed)
+	require.Nil(t, rep.Exceeded)
+
+	// The payloads in progress complete, and no other one is started.
+	r = &Runner{Workers: 2, NewSink: sinks.newSink, Budget: Budget{MaxPayloads: 3}}
+	r.Add(&urlSource{client: new(mockClient), urls: valid})
+	r.Add(&urlSource{client: new(mockClient), urls: valid})
+	rep, err = r.Run(context.Background())
+	require.EqualError(t, err, "budget exceeded: more than 3 payloads")
+	require.Equal(t, rep.Exceeded, err)
+	require.Equal(t, 3, rep.Processed)
+	require.Zero(t, rep.Failed)
+
+	stall := make(chan struct{})
+	defer close(stall)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if r.URL.Path == "/big" {
+			fmt.Fprintf(w, `[{"id": 1, "first_name": %q}]`, strings.Repeat("a", 1000))
+			return
+		}
+		w.Write([]byte(`[{"id": 1}, `))
+		w.(http.Flusher).Flush()
+		select {
+		case <-stall:
+		case <-r.Context().Done():
+		}
+	}))
+	defer srv.Close()
+
+	r = &Runner{NewSink: sinks.newSink, Budget: Budget{MaxBytes: 100}}
+	r.Add(&urlSource{client: srv.Client(), urls: []string{srv.URL + "/big", srv.URL + "/big"}})
+	rep, err = r.Run(context.Background())
+	require.EqualError(t, err, "budget exceeded: more than 100 payload bytes read")
+	require.Equal(t, 1, rep.Processed)
+	require.Equal(t, 1, rep.Failed)
+
+	r = &Runner{NewSink: sinks.newSink, Budget: Budget{MaxDuration: 100 * time.Millisecond}}
+	r.Add(&urlSource{client: srv.Client(), urls: []string{srv.URL + "/stall"}})
+	rep, err = r.Run(context.Background())
+	require.EqualError(t, err, "budget exceeded: ran for more than 100ms")
+	require.Equal(t, 1, rep.Failed)
+	require.Less(t, int64(rep.Duration), int64(2*time.Second))
+}
@@ -0,0 +1,284 @@
+package jsontoxml
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+)
+
+var cacheDir string
+
+func init() {
+	rootCmd.PersistentFlags().StringVar(&cacheDir, "cache-dir", "",
+		"Directory caching the responses with an ETag or Last-Modified header. The next runs"+
+			" send conditional requests and skip the urls that weren't modified.")
+}
+
+// cacheHeader is set on the responses replayed from the cache after a 304.
+const cacheHeader = "X-Jsontoxml-Cache"
+
+// errNotModified is returned for a payload that wasn't modified since its
+// output was written, which is then kept.
+var errNotModified = errors.New("not modified")
+
+// cacheEntry holds the validators of a cached response, next to its body.
+type cacheEntry struct {
+	URL          string `json:"url"`
+	ETag         string `json:"etag,omitempty"`
+	LastModified string `json:"last_modified,omitempty"`
+	ContentType  string `json:"content_type,omitempty"`
+	// Output and Options are the name of the document last converted from
+	// the response and the hash of the conversion flags it was converted
+	// with, see convertedOutput.
+	Output  string `json:"output,omitempty"`
+	Options string `json:"options,omitempty"`
+}
+
+// conversionFlags are the flags changing the content of the documents. An
+// output is only kept after a 304 if it was converted with the same values.
+var conversionFlags = []string{"array-root", "attribute-field", "binary-field", "cdata-field",
+	"compact", "converted-time-field", "empty-elements", "empty-records", "entity",
+	"entity-file", "entity-ref", "error-field", "error-path", "fragments", "generic", "html",
+	"html-allow", "hypermedia", "indent", "indent-prefix", "json-schema", "locale", "lossless",
+	"mapping", "max-field-length", "openapi", "operation", "preset", "record-id",
+	"record-id-attr", "records-field", "root-element", "routes", "run-time-field", "select",
+	"split-language-field", "string-types", "timestamp-format", "timezone", "whitespace",
+	"whitespace-field", "xml-declaration", "xsd", "xsd-mode", "xsi-types", "xslt"}
+
+// outputOptions is the hash of the conversionFlags of the run.
+var outputOptions string
+
+// hashConversionFlags returns the hash of the values of the conversionFlags
+// of cmd.
+func hashConversionFlags(cmd *cobra.Command) string {
+	h := sha256.New()
+	for _, name := range conversionFlags {
+		if f := cmd.Flag(name); f != nil {
+			fmt.Fprintf(h, "%s=%q\n", name, f.Value.String())
+		}
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// convertedOutput returns true if the output name was converted from the
+// cached response of the url, with the conversion flags of the run.
+func convertedOutput(rawURL, name string) bool {
+	if len(cacheDir) == 0 {
+		return false
+	}
+	e, ok := (&cacheTransport{dir: cacheDir}).load(cacheKey(rawURL))
+	return ok && e.Output == name && e.Options == outputOptions
+}
+
+// recordOutput notes in the cached response of the url, if any, that the
+// output name was converted from it.
+func recordOutput(rawURL, name string) error {
+	if len(cacheDir) == 0 {
+		return nil
+	}
+	t := &cacheTransport{dir: cacheDir}
+	key := cacheKey(rawURL)
+	e, ok := t.load(key)
+	if !ok || e.Output == name && e.Options == outputOptions {
+		return nil
+	}
+	e.Output, e.Options = name, outputOptions
+	data, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	return atomicWrite(t.path(key)+".json", data)
+}
+
+// cacheKey returns the url the way the requests of rawURL spell it.
+func cacheKey(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return rawURL
+	}
+	return u.String()
+}
+
+// cacheTransport makes conditional requests for the urls it has a response
+// for. A 304 is answered with the cached response, marked with cacheHeader.
+// The other successful responses with an ETag or Last-Modified header are
+// cached as they are read.
+type cacheTransport struct {
+	base http.RoundTripper
+	dir  string
+}
+
+// path returns the path of the files of the url in the cache, without
+// extension.
+func (t *cacheTransport) path(url string) string {
+	sum := sha256.Sum256([]byte(url))
+	return filepath.Join(t.dir, hex.EncodeToString(sum[:]))
+}
+
+func (t *cacheTransport) load(url string) (*cacheEntry, bool) {
+	data, err := ioutil.ReadFile(t.path(url) + ".json")
+	if err != nil {
+		return nil, false
+	}
+	var e cacheEntry
+	if err := json.Unmarshal(data, &e); err != nil || e.URL != url {
+		return nil, false
+	}
+	return &e, true
+}
+
+func (t *cacheTransport) CloseIdleConnections() {
+	closeIdleConnections(t.base)
+}
+
+func (t *cacheTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Method != http.MethodGet {
+		return t.base.RoundTrip(req)
+	}
+	url := req.URL.String()
+	e, cached := t.load(url)
+	if cached && len(req.Header.Get("If-None-Match")) == 0 &&
+		len(req.Header.Get("If-Modified-Since")) == 0 {
+		req = req.Clone(req.Context())
+		if len(e.ETag) > 0 {
+			req.Header.Set("If-None-Match", e.ETag)
+		}
+		if len(e.LastModified) > 0 {
+			req.Header.Set("If-Modified-Since", e.LastModified)
+		}
+	}
+	resp, err := t.base.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case resp.StatusCode == http.StatusNotModified && cached:
+		f, err := os.Open(t.path(url) + ".body")
+		if err != nil {
+			// The entry is incomplete: let the caller handle the 304.
+			return resp, nil
+		}
+		resp.Body.Close()
+		resp.StatusCode, resp.Status = http.StatusOK, "200 OK"
+		resp.Body = f
+		resp.ContentLength = -1
+		resp.Header.Set("Content-Type", e.ContentType)
+		resp.Header.Set(cacheHeader, "not-modified")
+	case resp.StatusCode == http.StatusOK:
+		e := &cacheEntry{
+			URL:          url,
+			ETag:         resp.Header.Get("ETag"),
+			LastModified: resp.Header.Get("Last-Modified"),
+			ContentType:  resp.Header.Get("Content-Type"),
+		}
+		if len(e.ETag) == 0 && len(e.LastModified) == 0 {
+			break
+		}
+		if body, err := t.store(e, resp.Body); err != nil {
+			log.Printf("Failed caching url: %q err: %s", url, err)
+		} else {
+			resp.Body = body
+		}
+	}
+	return resp, nil
+}
+
+// store returns body, copying it to the cache as it is read. The entry is
+// only saved once the body is read fully.
+func (t *cacheTransport) store(e *cacheEntry, body io.ReadCloser) (io.ReadCloser, error) {
+	if err := os.MkdirAll(t.dir, 0700); err != nil {
+		return nil, err
+	}
+	f, err := ioutil.TempFile(t.dir, ".body")
+	if err != nil {
+		return nil, err
+	}
+	return &cachingBody{t: t, e: e, body: body, f: f}, nil
+}
+
+// cachingBody copies a response body to a temporary file, saved in the cache
+// at EOF. It is discarded if the body is closed before.
+type cachingBody struct {
+	t    *cacheTransport
+	e    *cacheEntry
+	body io.ReadCloser
+	f    *os.File
+	err  error
+}
+
+func (b *cachingBody) Read(p []byte) (int, error) {
+	n, err := b.body.Read(p)
+	if b.f != nil && b.err == nil {
+		if _, werr := b.f.Write(p[:n]); werr != nil {
+			b.err = werr
+		}
+	}
+	if err == io.EOF && b.f != nil {
+		if serr := b.save(); serr != nil {
+			log.Printf("Failed caching url: %q err: %s", b.e.URL, serr)
+		}
+	}
+	return n, err
+}
+
+// save moves the body to the cache, then writes the entry.
+func (b *cachingBody) save() error {
+	f := b.f
+	b.f = nil
+	if err := f.Close(); err != nil || b.err != nil {
+		os.Remove(f.Name())
+		if err == nil {
+			err = b.err
+		}
+		return err
+	}
+	path := b.t.path(b.e.URL)
+	if err := os.Rename(f.Name(), path+".body"); err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+	data, err := json.Marshal(b.e)
+	if err != nil {
+		return err
+	}
+	return atomicWrite(path+".json", data)
+}
+
+func (b *cachingBody) Close() error {
+	if b.f != nil {
+		b.f.Close()
+		os.Remove(b.f.Name())
+		b.f = nil
+	}
+	return b.body.Close()
+}
+
+// atomicWrite writes data to path through a temporary file, so that readers
+// never see a partial file.
+func atomicWrite(path string, data []byte) error {
+	f, err := ioutil.TempFile(filepath.Dir(path), "."+filepath.Base(path))
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return err
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+	return os.Rename(f.Name(), path)
+}
@@ -0,0 +1,117 @@
+package jsontoxml
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestCache(t *testing.T) {
+	var mu sync.Mutex
+	var conditional []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		conditional = append(conditional, r.Header.Get("If-None-Match")+"|"+
+			r.Header.Get("If-Modified-Since"))
+		mu.Unlock()
+		switch r.URL.Path {
+		case "/etag":
+			w.Header().Set("ETag", `"v1"`)
+			if r.Header.Get("If-None-Match") == `"v1"` {
+				w.WriteHeader(http.StatusNotModified)
+				return
+			}
+		case "/date":
+			w.Header().Set("Last-Modified", "Mon, 01 Mar 2021 10:00:00 GMT")
+			if len(r.Header.Get("If-Modified-Since")) > 0 {
+				w.WriteHeader(http.StatusNotModified)
+				return
+			}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"first_name": "` + r.URL.Path[1:] + `"}`))
+	}))
+	defer srv.Close()
+	dir, err := ioutil.TempDir("", "cache")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	out := filepath.Join(dir, "out")
+
+	defer func(d string, c *http.Client) { cacheDir, fetchClient = d, c }(cacheDir, fetchClient)
+	cacheDir = filepath.Join(dir, "cache")
+	fetchClient = newHTTPClient()
+	urls := []string{srv.URL + "/etag", srv.URL + "/date", srv.URL + "/plain"}
+	run := func() {
+		r := &Runner{NewSink: func() Sink { return &fileSink{dir: out} }, capture: newFailureCapture(),
+			Transport: fetchClient.Transport}
+		r.AddURLs(urls...)
+		rep, err := r.Run(context.Background())
+		require.NoError(t, err)
+		require.Equal(t, 0, rep.Failed)
+	}
+	read := func(name string) string {
+		data, err := ioutil.ReadFile(filepath.Join(out, name))
+		require.NoError(t, err)
+		return string(data)
+	}
+
+	run()
+	require.Contains(t, read("0.xml"), "<first>etag</first>")
+	// The unmodified outputs are kept as they are.
+	for _, name := range []string{"0.xml", "1.xml", "2.xml"} {
+		require.NoError(t, ioutil.WriteFile(filepath.Join(out, name), []byte("old"), 0600))
+	}
+	conditional = nil
+	run()
+	require.ElementsMatch(t, []string{`"v1"|`, "|Mon, 01 Mar 2021 10:00:00 GMT", "|"}, conditional)
+	require.Equal(t, "old", read("0.xml"))
+	require.Equal(t, "old", read("1.xml"))
+	require.Contains(t, read("2.xml"), "<first>plain</first>")
+
+	// Missing outputs are converted from the cache.
+	require.NoError(t, os.Remove(filepath.Join(out, "1.xml")))
+	run()
+	require.Contains(t, read("1.xml"), "<first>date</first>")
+
+	// The outputs of other urls, or converted with other flags, are replaced.
+	require.NoError(t, ioutil.WriteFile(filepath.Join(out, "0.xml"), []byte("old"), 0600))
+	urls[0], urls[1] = urls[1], urls[0]
+	run()
+	require.Contains(t, read("0.xml"), "<first>date</first>")
+	require.Contains(t, read("1.xml"), "<first>etag</first>")
+	require.NoError(t, ioutil.WriteFile(filepath.Join(out, "0.xml"), []byte("old"), 0600))
+	run()
+	require.Equal(t, "old", read("0.xml"))
+	defer func(o string) { outputOptions = o }(outputOptions)
+	outputOptions = "other"
+	run()
+	require.Contains(t, read("0.xml"), "<first>date</first>")
+
+	// Bodies that aren't read fully aren't cached.
+	tr := &cacheTransport{base: http.DefaultTransport, dir: filepath.Join(dir, "partial")}
+	resp, err := (&http.Client{Transport: tr}).Get(srv.URL + "/etag")
+	require.NoError(t, err)
+	resp.Body.Close()
+	_, ok := tr.load(srv.URL + "/etag")
+	require.False(t, ok)
+	files, err := ioutil.ReadDir(tr.dir)
+	require.NoError(t, err)
+	require.Empty(t, files)
+}
+
+func TestHashConversionFlags(t *testing.T) {
+	flags := rootCmd.PersistentFlags()
+	defer func(w int, g bool) { workers, generic = w, g }(workers, generic)
+	h := hashConversionFlags(rootCmd)
+	require.NoError(t, flags.Set("workers", "3"))
+	require.Equal(t, h, hashConversionFlags(rootCmd))
+	require.NoError(t, flags.Set("generic", "true"))
+	require.NotEqual(t, h, hashConversionFlags(rootCmd))
+}
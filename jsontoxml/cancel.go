@@ -0,0 +1,109 @@
+package jsontoxml
+
+import (
+	"context"
+	"io"
+
+	"github.com/pkg/errors"
+)
+
+// PartialPolicy decides what happens to the output of a conversion that is
+// canceled midway.
+type PartialPolicy string
+
+const (
+	// PartialDelete discards the output. It is the default.
+	PartialDelete PartialPolicy = "delete"
+	// PartialKeep keeps the output written so far, under its name with a
+	// .partial suffix.
+	PartialKeep PartialPolicy = "keep"
+	// PartialFinalize completes the document with the entries of the json
+	// array converted so far, so that it is valid xml, and commits it. Outputs
+	// that can't be completed are discarded.
+	PartialFinalize PartialPolicy = "finalize"
+)
+
+// partialSuffix is appended to the name of the outputs kept by PartialKeep.
+const partialSuffix = ".partial"
+
+// PartialSink is implemented by the sinks that can keep a partially written
+// document under its name with a .partial suffix.
+type PartialSink interface {
+	Sink
+	KeepPartial() error
+}
+
+// errTruncated is returned when a conversion was canceled and the document was
+// completed with the entries converted so far.
+var errTruncated = errors.New("document truncated")
+
+var (
+	onCancel string
+	// partialPolicy is set from --on-cancel.
+	partialPolicy = PartialDelete
+)
+
+func init() {
+	rootCmd.PersistentFlags().StringVar(&onCancel, "on-cancel", string(PartialDelete),
+		"What happens to the partial output of a conversion interrupted by SIGINT or SIGTERM:"+
+			" delete, keep (with a .partial suffix) or finalize (close the document after the"+
+			" last complete record).")
+}
+
+// parsePartialPolicy validates the --on-cancel flag.
+func parsePartialPolicy(s string) (PartialPolicy, error) {
+	switch p := PartialPolicy(s); p {
+	case PartialDelete, PartialKeep, PartialFinalize:
+		return p, nil
+	}
+	return "", errors.Errorf("unknown --on-cancel %q. Expected delete, keep or finalize", s)
+}
+
+// ctxReader fails the reads once ctx is done, so that a conversion stops
+// midway when it is canceled.
+type ctxReader struct {
+	ctx context.Context
+	r   io.Reader
+}
+
+func (r ctxReader) Read(p []byte) (int, error) {
+	if err := r.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return r.r.Read(p)
+}
+
+// closeOnDone closes c once ctx is done, until the returned function is called.
+func closeOnDone(ctx context.Context, c io.Closer) (stop func()) {
+	done := make(chan struct{})
+	go func() {
+		select {
+		case <-ctx.Done():
+			c.Close()
+		case <-done:
+		}
+	}()
+	return func() { close(done) }
+}
+
+// isCanceled returns true if err is caused by a canceled context.
+func isCanceled(err error) bool {
+	cause := errors.Cause(err)
+	return cause == context.Canceled || cause == context.DeadlineExceeded
+}
+
+// cancelOutput applies the policy to the output of the canceled conversion,
+// which failed with err.
+func cancelOutput(s Sink, policy PartialPolicy, err error) error {
+	switch policy {
+	case PartialKeep:
+		if ps, ok := s.(PartialSink); ok {
+			return ps.KeepPartial()
+		}
+	case PartialFinalize:
+		if errors.Cause(err) == errTruncated {
+			return s.Commit()
+		}
+	}
+	return s.Abort()
+}
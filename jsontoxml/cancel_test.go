@@ -0,0 +1,77 @@
+package jsontoxml
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/require"
+)
+
+// canceledReader returns data, then fails as if the conversion was canceled.
+func canceledReader(data string) io.Reader {
+	return io.MultiReader(strings.NewReader(data), ctxReader{ctx: canceledContext(), r: nil})
+}
+
+func canceledContext() context.Context {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	return ctx
+}
+
+func TestConvertStreamFinalize(t *testing.T) {
+	for _, opts := range []*convertOptions{nil, {generic: true}} {
+		want, err := convert([]byte(`[{"id": 1}]`), opts)
+		require.NoError(t, err)
+
+		var got bytes.Buffer
+		err = convertStream(canceledReader(`[{"id": 1}, {"id"`), &got, opts, true)
+		require.Equal(t, errTruncated, errors.Cause(err))
+		require.Equal(t, string(want), got.String())
+
+		err = convertStream(canceledReader(`[{"id": 1}, {"id"`), new(bytes.Buffer), opts, false)
+		require.Equal(t, context.Canceled, errors.Cause(err))
+	}
+
+	// Documents that aren't arrays can't be completed.
+	err := convertStream(canceledReader(`{"id": 1`), new(bytes.Buffer), nil, true)
+	require.Equal(t, context.Canceled, errors.Cause(err))
+}
+
+func TestCancelOutput(t *testing.T) {
+	dir, err := ioutil.TempDir("", "cancel")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	write := func(name string) Sink {
+		s := &fileSink{dir: dir}
+		require.NoError(t, s.Open(name))
+		s.Write([]byte("<x>"))
+		return s
+	}
+	require.NoError(t, cancelOutput(write("delete.xml"), PartialDelete, context.Canceled))
+	require.NoError(t, cancelOutput(write("keep.xml"), PartialKeep, context.Canceled))
+	require.NoError(t, cancelOutput(write("final.xml"), PartialFinalize, errTruncated))
+	require.NoError(t, cancelOutput(write("notfinal.xml"), PartialFinalize, context.Canceled))
+
+	files, err := filepath.Glob(filepath.Join(dir, "*"))
+	require.NoError(t, err)
+	require.Equal(t, []string{
+		filepath.Join(dir, "final.xml"),
+		filepath.Join(dir, "keep.xml.partial"),
+	}, files)
+}
+
+func TestParsePartialPolicy(t *testing.T) {
+	p, err := parsePartialPolicy("keep")
+	require.NoError(t, err)
+	require.Equal(t, PartialKeep, p)
+	_, err = parsePartialPolicy("ignore")
+	require.Error(t, err)
+}
@@ -0,0 +1,117 @@
+package jsontoxml
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// failureCapture dumps the request and the response of failed urls to dir so
+// that provider issues can be debugged after the fact. At most limit bytes of
+// the response body are kept. A nil *failureCapture captures nothing.
+type failureCapture struct {
+	dir   string
+	limit int64
+}
+
+// newFailureCapture returns the capture configured by --error-dir, or nil if
+// it isn't set.
+func newFailureCapture() *failureCapture {
+	if len(errorDir) == 0 {
+		return nil
+	}
+	return &failureCapture{dir: errorDir, limit: captureLimit}
+}
+
+// bodyRecorder keeps the first limit bytes read from the response body.
+type bodyRecorder struct {
+	io.ReadCloser
+	buf   bytes.Buffer
+	limit int64
+}
+
+func (r *bodyRecorder) Read(p []byte) (int, error) {
+	n, err := r.ReadCloser.Read(p)
+	if rem := r.limit - int64(r.buf.Len()); rem > 0 {
+		if int64(n) < rem {
+			rem = int64(n)
+		}
+		r.buf.Write(p[:rem])
+	}
+	return n, err
+}
+
+// record replaces the body of resp with one that remembers what was read from
+// it.
+func (c *failureCapture) record(resp *http.Response) *bodyRecorder {
+	if c == nil {
+		return nil
+	}
+	rec := &bodyRecorder{ReadCloser: resp.Body, limit: c.limit}
+	resp.Body = rec
+	return rec
+}
+
+// save writes the capture for url to <dir>/<name>.failure.txt. resp and rec
+// are nil if no response was received.
+func (c *failureCapture) save(name, url string, resp *http.Response, rec *bodyRecorder,
+	cause error) error {
+	if c == nil {
+		return nil
+	}
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "URL: %s\nError: %s\n\n", url, cause)
+
+	method, header := http.MethodGet, http.Header(nil)
+	if resp != nil && resp.Request != nil {
+		method, header = resp.Request.Method, resp.Request.Header
+	}
+	fmt.Fprintf(&b, "> %s %s\n", method, url)
+	writeHeader(&b, "> ", header)
+
+	if resp != nil {
+		fmt.Fprintf(&b, "\n< %s %s\n", resp.Proto, resp.Status)
+		writeHeader(&b, "< ", resp.Header)
+		if rec != nil {
+			// The body may not have been read at all (e.g. on an invalid
+			// Content-Type), so read whatever fits in the limit.
+			if rem := rec.limit - int64(rec.buf.Len()); rem > 0 {
+				io.Copy(ioutil.Discard, io.LimitReader(rec, rem))
+			}
+			b.WriteString("\n")
+			b.Write(rec.buf.Bytes())
+			b.WriteString("\n")
+		}
+	}
+
+	if err := os.MkdirAll(c.dir, 0700); err != nil {
+		return errors.Wrap(err, "create error dir")
+	}
+	path := filepath.Join(c.dir, name+".failure.txt")
+	dump := defaultRedactor.redact(b.String())
+	return errors.Wrap(ioutil.WriteFile(path, []byte(dump), 0600), "write capture")
+}
+
+// writeHeader writes the header in a stable order, redacting sensitive values.
+func writeHeader(w io.Writer, prefix string, h http.Header) {
+	keys := make([]string, 0, len(h))
+	for k := range h {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		v := strings.Join(h[k], ", ")
+		if defaultRedactor.header(k) {
+			v = redacted
+		}
+		fmt.Fprintf(w, "%s%s: %s\n", prefix, k, v)
+	}
+}
@@ -0,0 +1,52 @@
+package jsontoxml
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestFailureCapture(t *testing.T) {
+	dir, err := ioutil.TempDir("", "capture")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	var buf bytes.Buffer
+	w := &worker{
+		client:  new(mockClient),
+		sink:    mockSink{&buf},
+		name:    "7",
+		capture: &failureCapture{dir: dir, limit: 10},
+	}
+	require.Error(t, w.fetchAndProcess("invalid"))
+
+	data, err := ioutil.ReadFile(filepath.Join(dir, "7.failure.txt"))
+	require.NoError(t, err)
+	dump := string(data)
+	require.Contains(t, dump, "URL: invalid")
+	require.Contains(t, dump, "Invalid Content-Type header")
+	require.Contains(t, dump, "> GET invalid")
+	// Only the first 10 bytes of the body are kept.
+	require.Contains(t, dump, "\n\"last_name\n")
+}
+
+func TestFailureCaptureRedaction(t *testing.T) {
+	var buf bytes.Buffer
+	writeHeader(&buf, "> ", http.Header{
+		"Authorization": {"Bearer secret"},
+		"Accept":        {"application/json"},
+	})
+	require.Equal(t, "> Accept: application/json\n> Authorization: [REDACTED]\n", buf.String())
+}
+
+func TestNilFailureCapture(t *testing.T) {
+	var c *failureCapture
+	resp := &http.Response{Body: ioutil.NopCloser(bytes.NewReader(nil))}
+	require.Nil(t, c.record(resp))
+	require.NoError(t, c.save("0", "url", resp, nil, nil))
+}
@@ -0,0 +1,38 @@
+package jsontoxml
+
+import "encoding/xml"
+
+var cdataFields []string
+
+func init() {
+	rootCmd.PersistentFlags().StringArrayVar(&cdataFields, "cdata-field", nil,
+		"Json key whose string values are wrapped in a CDATA section instead of being"+
+			" escaped, e.g. for html. Requires --generic, --openapi or --preset. Can be"+
+			" repeated.")
+}
+
+// newCDATAFields returns the set of the json keys of --cdata-field, or nil if
+// there is none.
+func newCDATAFields(keys []string) map[string]bool {
+	if len(keys) == 0 {
+		return nil
+	}
+	fields := make(map[string]bool, len(keys))
+	for _, k := range keys {
+		fields[k] = true
+	}
+	return fields
+}
+
+// cdataText is marshaled as a CDATA section. encoding/xml splits the
+// sections around the ]]> of the text.
+type cdataText struct {
+	Text string `xml:",cdata"`
+}
+
+// encodeCDATA encodes the string s as the element start, with its text in a
+// CDATA section.
+func (o *convertOptions) encodeCDATA(enc *xml.Encoder, start xml.StartElement, s string) error {
+	start, v := o.truncate(start, s)
+	return enc.EncodeElement(cdataText{Text: v.(string)}, o.annotate(start, v))
+}
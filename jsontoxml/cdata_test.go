@@ -0,0 +1,37 @@
+package jsontoxml
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestCDATAFields(t *testing.T) {
+	opts := &convertOptions{generic: true, cdataFields: newCDATAFields([]string{"body", "n"}),
+		layout: &xmlLayout{compact: true}}
+	out, err := convert([]byte(`{"body": "<p>a & b</p>", "n": 1, "title": "<b>"}`), opts)
+	require.NoError(t, err)
+	require.Equal(t, `<jsonData><body><![CDATA[<p>a & b</p>]]></body><n>1</n>`+
+		`<title>&lt;b&gt;</title></jsonData>`, string(out))
+
+	// The ]]> of the text ends a section and starts the next one.
+	out, err = convert([]byte(`{"body": "a]]>b"}`), opts)
+	require.NoError(t, err)
+	require.Equal(t, `<jsonData><body><![CDATA[a]]]]><![CDATA[>b]]></body></jsonData>`,
+		string(out))
+	wellFormed(t, out)
+}
+
+func TestCDATAFieldsModes(t *testing.T) {
+	defer func() { cdataFields, generic = nil, false }()
+	cdataFields = []string{"City"}
+	// The jsonData records are escaped.
+	_, err := newConvertOptions()
+	require.EqualError(t, err, "--cdata-field requires --generic, --openapi or --preset")
+	generic = true
+	opts, err := newConvertOptions()
+	require.NoError(t, err)
+	data, err := convert([]byte(`{"City": "<b>"}`), opts)
+	require.NoError(t, err)
+	require.Contains(t, string(data), `<City><![CDATA[<b>]]></City>`)
+}
@@ -0,0 +1,209 @@
+package jsontoxml
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"hash"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+// ChecksumMode decides how the SHA-256 checksums of the documents are
+// published.
+type ChecksumMode string
+
+const (
+	// ChecksumNone publishes no checksum. It is the default.
+	ChecksumNone ChecksumMode = ""
+	// ChecksumSums lists the checksums of the documents written by the run in
+	// a SHA256SUMS file at the root of the destination.
+	ChecksumSums ChecksumMode = "sums"
+	// ChecksumSidecar writes the checksum of every document next to it, under
+	// its name with a .sha256 suffix.
+	ChecksumSidecar ChecksumMode = "sidecar"
+)
+
+const (
+	// sumsName is the name of the file of ChecksumSums.
+	sumsName = "SHA256SUMS"
+	// sidecarSuffix is appended to the name of the documents for the files of
+	// ChecksumSidecar.
+	sidecarSuffix = ".sha256"
+)
+
+var (
+	checksumsFlag string
+	// checksums is set from --checksums.
+	checksums ChecksumMode
+)
+
+func init() {
+	rootCmd.PersistentFlags().StringVar(&checksumsFlag, "checksums", "",
+		"Publish the SHA-256 checksums of the generated xml: sums (a SHA256SUMS file at the root"+
+			" of --output) or sidecar (a .sha256 file next to every document). None if empty.")
+}
+
+// parseChecksumMode validates the --checksums flag. The documents written to
+// stdout have no checksum.
+func parseChecksumMode(s, output string) (ChecksumMode, error) {
+	switch m := ChecksumMode(s); m {
+	case ChecksumNone:
+		return m, nil
+	case ChecksumSums, ChecksumSidecar:
+		if output == "-" && len(singleFile) == 0 {
+			return "", errors.New("--checksums can't be used with --output -")
+		}
+		return m, nil
+	}
+	return "", errors.Errorf("unknown --checksums %q. Expected sums or sidecar", s)
+}
+
+// checksumLine returns the line of the document name in a file checked by
+// sha256sum -c.
+func checksumLine(sum []byte, name string) string {
+	return hex.EncodeToString(sum) + "  " + name + "\n"
+}
+
+// checksumList collects the checksums of the committed documents, by name.
+type checksumList struct {
+	mu   sync.Mutex
+	sums map[string][]byte
+}
+
+func newChecksumList() *checksumList {
+	return &checksumList{sums: make(map[string][]byte)}
+}
+
+func (l *checksumList) add(name string, sum []byte) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.sums[name] = sum
+}
+
+// write writes the SHA256SUMS file listing the checksums, sorted by name, to
+// sink. Nothing is written if the list is empty.
+func (l *checksumList) write(sink Sink) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if len(l.sums) == 0 {
+		return nil
+	}
+	names := make([]string, 0, len(l.sums))
+	for name := range l.sums {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var b strings.Builder
+	for _, name := range names {
+		b.WriteString(checksumLine(l.sums[name], name))
+	}
+	if err := sink.Open(sumsName); err != nil {
+		return errors.Wrap(err, "checksums")
+	}
+	if _, err := sink.Write([]byte(b.String())); err != nil {
+		sink.Abort()
+		return errors.Wrap(err, "checksums")
+	}
+	return errors.Wrap(sink.Commit(), "checksums")
+}
+
+// checksumSink hashes the documents written to sink. The checksums of the
+// committed documents are added to sums with ChecksumSums, or written next
+// to them with ChecksumSidecar.
+type checksumSink struct {
+	sink Sink
+	mode ChecksumMode
+	sums *checksumList
+	name string
+	h    hash.Hash
+}
+
+func (s *checksumSink) Open(name string) error {
+	s.name = name
+	s.h = sha256.New()
+	return s.sink.Open(name)
+}
+
+func (s *checksumSink) Write(p []byte) (int, error) {
+	n, err := s.sink.Write(p)
+	s.h.Write(p[:n])
+	return n, err
+}
+
+func (s *checksumSink) Commit() error {
+	if err := s.sink.Commit(); err != nil {
+		return err
+	}
+	sum := s.h.Sum(nil)
+	if s.mode != ChecksumSidecar {
+		s.sums.add(s.name, sum)
+		return nil
+	}
+	if err := s.sink.Open(s.name + sidecarSuffix); err != nil {
+		return errors.Wrap(err, "checksum")
+	}
+	if _, err := s.sink.Write([]byte(checksumLine(sum, path.Base(s.name)))); err != nil {
+		s.sink.Abort()
+		return errors.Wrap(err, "checksum")
+	}
+	return errors.Wrap(s.sink.Commit(), "checksum")
+}
+
+func (s *checksumSink) Abort() error {
+	return s.sink.Abort()
+}
+
+func (s *checksumSink) Exists(name string) bool {
+	es, ok := s.sink.(ExistingSink)
+	return ok && es.Exists(name)
+}
+
+// KeepPartial keeps the partial document, if sink can, without a checksum.
+func (s *checksumSink) KeepPartial() error {
+	if ps, ok := s.sink.(PartialSink); ok {
+		return ps.KeepPartial()
+	}
+	return s.sink.Abort()
+}
+
+// writeFileChecksum publishes the checksum of the file at p, e.g. the
+// --single-file, next to it.
+func writeFileChecksum(p string, mode ChecksumMode) error {
+	var target string
+	switch mode {
+	case ChecksumSums:
+		target = filepath.Join(filepath.Dir(p), sumsName)
+	case ChecksumSidecar:
+		target = p + sidecarSuffix
+	default:
+		return nil
+	}
+	sum, err := fileChecksum(p)
+	if err != nil {
+		return errors.Wrap(err, "checksum")
+	}
+	err = ioutil.WriteFile(target, []byte(checksumLine(sum, filepath.Base(p))), 0600)
+	return errors.Wrap(err, "checksum")
+}
+
+// fileChecksum returns the SHA-256 checksum of the file at p.
+func fileChecksum(p string) ([]byte, error) {
+	f, err := os.Open(p)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return nil, errors.Wrapf(err, "read %s", p)
+	}
+	return h.Sum(nil), nil
+}
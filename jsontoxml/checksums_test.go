@@ -0,0 +1,60 @@
+package jsontoxml
+
+import (
+	"context"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseChecksumMode(t *testing.T) {
+	m, err := parseChecksumMode("", "./out")
+	require.NoError(t, err)
+	require.Equal(t, ChecksumNone, m)
+	m, err = parseChecksumMode("sidecar", "./out")
+	require.NoError(t, err)
+	require.Equal(t, ChecksumSidecar, m)
+	_, err = parseChecksumMode("sums", "-")
+	require.Error(t, err)
+	_, err = parseChecksumMode("md5", "./out")
+	require.Error(t, err)
+}
+
+// fileSHA256 returns the hex encoded checksum of the file at p.
+func fileSHA256(t *testing.T, p string) string {
+	data, err := ioutil.ReadFile(p)
+	require.NoError(t, err)
+	return sha256Hex(data)
+}
+
+func TestRunnerChecksums(t *testing.T) {
+	run := func(mode ChecksumMode) string {
+		dir := t.TempDir()
+		r := &Runner{
+			NewSink:   func() Sink { return &fileSink{dir: dir} },
+			Checksums: mode,
+		}
+		r.Add(&urlSource{client: new(mockClient), urls: []string{"valid", "invalid", "valid"},
+			shard: shard{index: 1, total: 1}})
+		rep, err := r.Run(context.Background())
+		require.NoError(t, err)
+		require.Equal(t, 1, rep.Failed)
+		return dir
+	}
+
+	dir := run(ChecksumSums)
+	sums, err := ioutil.ReadFile(filepath.Join(dir, "SHA256SUMS"))
+	require.NoError(t, err)
+	require.Equal(t, fileSHA256(t, filepath.Join(dir, "0.xml"))+"  0.xml\n"+
+		fileSHA256(t, filepath.Join(dir, "2.xml"))+"  2.xml\n", string(sums))
+	require.NoFileExists(t, filepath.Join(dir, "0.xml.sha256"))
+
+	dir = run(ChecksumSidecar)
+	sidecar, err := ioutil.ReadFile(filepath.Join(dir, "2.xml.sha256"))
+	require.NoError(t, err)
+	require.Equal(t, fileSHA256(t, filepath.Join(dir, "2.xml"))+"  2.xml\n", string(sidecar))
+	require.NoFileExists(t, filepath.Join(dir, "1.xml.sha256"))
+	require.NoFileExists(t, filepath.Join(dir, "SHA256SUMS"))
+}
@@ -0,0 +1,95 @@
+package jsontoxml
+
+import (
+	"encoding/json"
+	"reflect"
+	"regexp"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// Values of --string-types.
+const (
+	// stringTypesStrict keeps the json types of the source.
+	stringTypesStrict = "strict"
+	// stringTypesCoerce turns strings that look like numbers or bools into
+	// numbers and bools.
+	stringTypesCoerce = "coerce"
+)
+
+// jsonNumberExpr matches the json number grammar. Strings like "007" or "+1"
+// don't match and are left alone, as converting them would change the value.
+var jsonNumberExpr = regexp.MustCompile(`^-?(0|[1-9][0-9]*)(\.[0-9]+)?([eE][+-]?[0-9]+)?$`)
+
+// coerceScalar returns the number or bool a string looks like. ok is false if
+// the string doesn't look like either.
+func coerceScalar(s string) (v interface{}, ok bool) {
+	switch {
+	case s == "true":
+		return true, true
+	case s == "false":
+		return false, true
+	case jsonNumberExpr.MatchString(s):
+		return json.Number(s), true
+	}
+	return nil, false
+}
+
+// coerceValue coerces every string in the decoded json value.
+func coerceValue(v interface{}) interface{} {
+	switch val := v.(type) {
+	case map[string]interface{}:
+		for k, item := range val {
+			val[k] = coerceValue(item)
+		}
+	case []interface{}:
+		for i, item := range val {
+			val[i] = coerceValue(item)
+		}
+	case string:
+		if c, ok := coerceScalar(val); ok {
+			return c
+		}
+	}
+	return v
+}
+
+// coerceForStruct rewrites the json object in data so that strings stored in
+// numeric and bool fields of the struct type t are coerced. String fields are
+// left alone, so a first name of "123" stays a string.
+func coerceForStruct(data []byte, t reflect.Type) ([]byte, error) {
+	var obj map[string]interface{}
+	if err := json.Unmarshal(data, &obj); err != nil {
+		// Leave it to the caller to report invalid json.
+		return data, nil
+	}
+	changed := false
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		switch f.Type.Kind() {
+		case reflect.String, reflect.Struct, reflect.Slice, reflect.Map, reflect.Ptr:
+			continue
+		}
+		name := strings.Split(f.Tag.Get("json"), ",")[0]
+		if len(name) == 0 {
+			name = f.Name
+		}
+		// encoding/json matches keys case insensitively.
+		for k, v := range obj {
+			s, ok := v.(string)
+			if !ok || !strings.EqualFold(k, name) {
+				continue
+			}
+			if c, ok := coerceScalar(s); ok {
+				obj[k] = c
+				changed = true
+			}
+		}
+	}
+	if !changed {
+		return data, nil
+	}
+	data, err := json.Marshal(obj)
+	return data, errors.Wrap(err, "json.Marshal")
+}
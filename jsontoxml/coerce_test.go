@@ -0,0 +1,56 @@
+package jsontoxml
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestCoerceScalar(t *testing.T) {
+	tt := []struct {
+		in string
+		v  interface{}
+		ok bool
+	}{
+		{"123", json.Number("123"), true},
+		{"-1.5e3", json.Number("-1.5e3"), true},
+		{"true", true, true},
+		{"false", false, true},
+		{"007", nil, false},
+		{"+1", nil, false},
+		{"True", nil, false},
+		{"12abc", nil, false},
+	}
+	for _, ti := range tt {
+		v, ok := coerceScalar(ti.in)
+		require.Equal(t, ti.ok, ok, ti.in)
+		require.Equal(t, ti.v, v, ti.in)
+	}
+}
+
+func TestCoerceStruct(t *testing.T) {
+	jdata := []byte(`{"id": "10", "first_name": "123"}`)
+	buf := &bytes.Buffer{}
+	// The source types are kept by default.
+	require.Error(t, jsonToXml(jdata, buf, nil))
+
+	require.NoError(t, jsonToXml(jdata, buf, &convertOptions{coerce: true}))
+	require.Contains(t, buf.String(), "<Id>10</Id>")
+	require.Contains(t, buf.String(), "<first>123</first>")
+}
+
+func TestCoerceGeneric(t *testing.T) {
+	jdata := []byte(`{"a": "1", "b": ["true", "x"]}`)
+	buf := &bytes.Buffer{}
+	require.NoError(t, jsonToXml(jdata, buf, &convertOptions{lossless: true, coerce: true}))
+	res := ` <jsonData type="object">
+  <a type="number">1</a>
+  <b type="array">
+   <item type="bool">true</item>
+   <item>x</item>
+  </b>
+ </jsonData>`
+	require.Equal(t, res, buf.String())
+}
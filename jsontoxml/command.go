@@ -0,0 +1,1127 @@
+// Package jsontoxml converts json documents to xml. Runner converts batches of
+// payloads read from Sources and writes them to Sinks, and Execute runs the
+// command line of the jsonToXml tool.
+package jsontoxml
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"encoding/xml"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"os"
+	"os/signal"
+	"path"
+	"reflect"
+	"sort"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+)
+
+// jsonData is the json format that the tool understands. Please update update
+// this type if your json data has different format.
+type jsonData struct {
+	Id        int
+	FirstName string `json:"first_name" xml:"name>first"`
+	LastName  string `json:"last_name" xml:"name>last"`
+	City      string
+	State     string
+	// present holds the json keys of the fields set (to a value other than
+	// null) by the decoded json, to tell absent fields from zero valued ones.
+	present map[string]bool
+}
+
+// jsonDataKeys are the json keys of the jsonData fields.
+var jsonDataKeys = structJSONKeys(reflect.TypeOf(jsonData{}))
+
+// structJSONKeys returns the json keys of the exported fields of the struct
+// type t.
+func structJSONKeys(t reflect.Type) []string {
+	var keys []string
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if len(f.PkgPath) > 0 {
+			continue
+		}
+		key := strings.Split(f.Tag.Get("json"), ",")[0]
+		if key == "-" {
+			continue
+		}
+		if len(key) == 0 {
+			key = f.Name
+		}
+		keys = append(keys, key)
+	}
+	return keys
+}
+
+// UnmarshalJSON decodes the json object and records which fields it sets.
+func (p *jsonData) UnmarshalJSON(data []byte) error {
+	type plain jsonData
+	if err := json.Unmarshal(data, (*plain)(p)); err != nil {
+		return err
+	}
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return err
+	}
+	p.present = nil
+	for k, v := range fields {
+		if string(v) == "null" {
+			continue
+		}
+		// encoding/json matches keys case insensitively.
+		for _, key := range jsonDataKeys {
+			if strings.EqualFold(k, key) {
+				if p.present == nil {
+					p.present = make(map[string]bool)
+				}
+				p.present[key] = true
+			}
+		}
+	}
+	return nil
+}
+
+// IsEmpty returns true if all attributes of jsonData are empty (zero valued).
+// A record with id 0 and no other field is empty, see IsAbsent.
+func (p *jsonData) IsEmpty() bool {
+	return p.Id == 0 && len(p.FirstName) == 0 && len(p.LastName) == 0 &&
+		len(p.City) == 0 && len(p.State) == 0
+}
+
+// IsAbsent returns true if none of the attributes of jsonData was set by the
+// decoded json, even to a zero value.
+func (p *jsonData) IsAbsent() bool {
+	return len(p.present) == 0
+}
+
+// Has returns true if the attribute with the json key was set by the decoded
+// json, e.g. Has("Id") for {"id": 0}.
+func (p *jsonData) Has(key string) bool {
+	return p.present[key]
+}
+
+var (
+	rootCmd = &cobra.Command{
+		Use:   "jsonToXml [-]",
+		Short: "jsonToXml is a fast jsonToXml converter",
+		Long: `jsonToXml is fast jsonToXml converter. The tool is capable of concurrenly fetching` +
+			` multiple URLs and converting them to XML`,
+		PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+			if err := loadSecrets(); err != nil {
+				return err
+			}
+			setupRedaction()
+			log.SetOutput(redactingWriter{w: os.Stderr, r: defaultRedactor})
+			opts, err := newConvertOptions()
+			if err != nil {
+				return err
+			}
+			convOpts = opts
+			outputOptions = hashConversionFlags(cmd)
+			if namer, err = newNamer(naming); err != nil {
+				return err
+			}
+			if partialPolicy, err = parsePartialPolicy(onCancel); err != nil {
+				return err
+			}
+			overwritePolicy, err = parseOverwritePolicy(skipExisting, forceOverwrite, errorIfExists)
+			if err != nil {
+				return err
+			}
+			if checksums, err = parseChecksumMode(checksumsFlag, output); err != nil {
+				return err
+			}
+			if statsMode, err = parseStatsMode(statsFlag, output); err != nil {
+				return err
+			}
+			if err := checkSplitLanguage(splitLanguageField, output); err != nil {
+				return err
+			}
+			if len(routesFile) > 0 {
+				if routes, err = loadRoutes(routesFile); err != nil {
+					return err
+				}
+				if err := checkRoutes(); err != nil {
+					return err
+				}
+			}
+			if len(ledgerPath) > 0 {
+				if output == "-" || len(singleFile) > 0 {
+					return errors.New("--delivery-ledger can't be used with --output - or --single-file")
+				}
+				if ledger, err = OpenDeliveryLedger(ledgerPath); err != nil {
+					return err
+				}
+			}
+			if requestHeader, err = parseHeaders(headerFlags); err != nil {
+				return err
+			}
+			if err := setupAuth(); err != nil {
+				return err
+			}
+			if ipPrefs, err = parseIPPreference(preferIPv4, preferIPv6, preferIPFlags); err != nil {
+				return err
+			}
+			if proxyURL, err = parseProxy(proxyFlag); err != nil {
+				return err
+			}
+			if tlsConfig, err = newTLSConfig(); err != nil {
+				return err
+			}
+			if validator, err = newValidator(); err != nil {
+				return err
+			}
+			if err := checkRateLimit(rateLimit); err != nil {
+				return err
+			}
+			if acceptedStatus, err = parseStatusSet(acceptStatusFlags); err != nil {
+				return err
+			}
+			if backfill, err = parseBackfill(backfillSpec, backfillStep); err != nil {
+				return err
+			}
+			if backfill != nil && (len(files) > 0 || len(inputDir) > 0) {
+				return errors.New("--backfill can't be used with --files nor --input-dir")
+			}
+			if len(jobFilePath) > 0 {
+				if len(strings.TrimSpace(urls)) > 0 || len(urlFile) > 0 || len(files) > 0 ||
+					len(inputDir) > 0 || backfill != nil || len(shardSpec) > 0 {
+					return errors.New("--job-file can't be used with --urls, --url-file, --files," +
+						" --input-dir, --backfill nor --shard")
+				}
+				if jobGraph, err = loadJobFile(jobFilePath); err != nil {
+					return err
+				}
+			}
+			if pager, err = newPagination(); err != nil {
+				return err
+			}
+			if requestMethod, requestBody, err = parseRequest(); err != nil {
+				return err
+			}
+			fetchClient = newHTTPClient()
+			newSink, err = sinkFactory(output)
+			return err
+		},
+		Args: func(cmd *cobra.Command, args []string) error {
+			if len(args) > 1 || len(args) == 1 && args[0] != "-" {
+				return errors.Errorf("unexpected arguments %q. Use - to read from stdin", args)
+			}
+			return nil
+		},
+		Run: func(cmd *cobra.Command, args []string) {
+			if len(args) == 1 || readStdin {
+				if err := runPipe(os.Stdin, os.Stdout); err != nil {
+					log.Fatal(err)
+				}
+				return
+			}
+			run()
+		},
+	}
+	urls, output   string
+	urlFile        string
+	files          string
+	inputDir       string
+	perURLDeadline time.Duration
+	inputPattern   string
+	readStdin      bool
+	workers        int
+	shardSpec      string
+	errorDir       string
+	captureLimit   int64
+	// ignoreContentType is set if the payloads are sniffed instead of being
+	// checked by their Content-Type.
+	ignoreContentType bool
+	ErrUnknownJSON    = errors.New("JSON is valid but it is not of type jsonData")
+)
+
+// Execute runs the command line of the jsonToXml tool with the arguments of
+// the process.
+func Execute() error {
+	return rootCmd.Execute()
+}
+
+func init() {
+	rootCmd.PersistentFlags().StringVarP(&urls, "urls", "u", "",
+		"Comma separated list of URLs to process.")
+	rootCmd.PersistentFlags().StringVar(&urlFile, "url-file", "",
+		"File listing the URLs to process, one per line, read as they are processed. Blank"+
+			" lines and lines starting with # are skipped. - reads stdin.")
+	rootCmd.PersistentFlags().StringVarP(&output, "output", "o", "./out",
+		"Output directory to store xml files. One file per url will be created. - writes all"+
+			" the documents to stdout instead, see --stdout-delimiter and --stdout-root.")
+	rootCmd.PersistentFlags().StringVar(&files, "files", "",
+		"Comma separated list of json files to convert instead of --urls.")
+	rootCmd.PersistentFlags().StringVar(&inputDir, "input-dir", "",
+		"Convert the files of the directory (recursively) matching --pattern instead of --urls."+
+			" The directory structure is mirrored in the output.")
+	rootCmd.PersistentFlags().StringVar(&inputPattern, "pattern", "*.json",
+		"Pattern of the names of the files converted with --input-dir.")
+	rootCmd.PersistentFlags().DurationVar(&perURLDeadline, "per-url-deadline", 0,
+		"Maximum time spent on a url, from the request to the end of the conversion. A url"+
+			" that takes longer is recorded as timed out. No limit if 0.")
+	rootCmd.Flags().BoolVar(&readStdin, "stdin", false,
+		"Convert the json read from stdin and write the xml to stdout, like -.")
+	rootCmd.PersistentFlags().IntVar(&workers, "workers", 16,
+		"Number of urls processed concurrently.")
+	rootCmd.PersistentFlags().StringVar(&shardSpec, "shard", "",
+		"Process only the i-th of n shards of the url list, e.g. 2/5. Lets multiple"+
+			" instances split a batch between them.")
+	rootCmd.PersistentFlags().StringVar(&errorDir, "error-dir", "",
+		"Directory in which the request and response of failed urls are dumped."+
+			" Nothing is dumped if empty.")
+	rootCmd.PersistentFlags().Int64Var(&captureLimit, "capture-limit", 64<<10,
+		"Maximum number of bytes of the response body dumped to --error-dir.")
+	rootCmd.PersistentFlags().BoolVar(&ignoreContentType, "ignore-content-type", false,
+		"Don't check the Content-Type of the responses, e.g. for servers sending json as"+
+			" text/plain. Responses starting with { or [ are converted.")
+}
+func run() {
+	if len(strings.TrimSpace(urls)) == 0 && len(urlFile) == 0 &&
+		len(strings.TrimSpace(files)) == 0 && len(inputDir) == 0 && jobGraph == nil &&
+		!streamInput {
+		log.Fatal("--urls flag cannot be empty.")
+	}
+	if streamInput && (len(strings.TrimSpace(urls)) > 0 || len(urlFile) > 0 ||
+		len(strings.TrimSpace(files)) > 0 || len(inputDir) > 0 || jobGraph != nil) {
+		log.Fatal("--stream can't be used with --urls, --url-file, --files, --input-dir nor" +
+			" --job-file.")
+	}
+	if !streamInput && (windowSize != 0 || windowEvery != 0) {
+		log.Fatal("--window-size and --window-every require --stream.")
+	}
+	if len(strings.TrimSpace(output)) == 0 {
+		log.Fatal("--output flag cannot be empty.")
+	}
+	if workers < 1 {
+		log.Fatal("--workers must be at least 1.")
+	}
+	if maxURLs < 0 || maxTotalBytes < 0 || maxDuration < 0 {
+		log.Fatal("--max-urls, --max-total-bytes and --max-duration can't be negative.")
+	}
+	sh, err := parseShard(shardSpec)
+	if err != nil {
+		log.Fatal(err)
+	}
+	log.Printf("Started Processing")
+
+	r := newDefaultRunner()
+	// list is read as the urls are processed.
+	var list *urlIter
+	// streamErr receives the error reading --stream.
+	var streamErr chan error
+	if jobGraph != nil {
+		jobGraph.addTo(r)
+	} else if streamInput {
+		ch := make(chan []byte)
+		src, err := newStreamSource(ch, windowSize, windowEvery)
+		if err != nil {
+			log.Fatal(err)
+		}
+		streamErr = make(chan error, 1)
+		go func() { streamErr <- readStream(os.Stdin, ch) }()
+		r.Add(src)
+	} else if len(inputDir) > 0 {
+		paths, err := findFiles(inputDir, inputPattern)
+		if err != nil {
+			log.Fatal(err)
+		}
+		r.Add(&fileSource{paths: paths})
+		r.Namer = PathNamer{Root: inputDir}
+	} else if len(strings.TrimSpace(files)) > 0 {
+		r.Add(&fileSource{paths: splitList(files)})
+	} else {
+		if list, err = openURLList(); err != nil {
+			log.Fatal(err)
+		}
+		defer list.Close()
+		if warmUp {
+			owned, err := warmUpList(sh, workers)
+			if err != nil {
+				log.Fatal(err)
+			}
+			warmUpHosts(context.Background(), fetchClient, owned, workers)
+		}
+		src := r.urlSource(nil)
+		src.client = fetchClient
+		// The position in the full list is kept so that shards writing to a
+		// shared directory never collide.
+		src.iter, src.shard = list, sh
+		r.Add(src)
+	}
+
+	if len(singleFile) > 0 {
+		// The file is the only document. outputPath names it.
+		if err := overwritePolicy.checkOutput(&fileSink{}, singleFile); err != nil {
+			if err = keepExisting(logger{}, singleFile, err); err != nil {
+				log.Fatal(err)
+			}
+			return
+		}
+		sf, err := newSingleFileStream(singleFile, singleFileRoot)
+		if err != nil {
+			log.Fatal(err)
+		}
+		sf.declaration = xmlDeclaration
+		outStream, r.NewSink = sf, sf.newSink
+		// The checksum is the one of the whole file.
+		r.Checksums = ChecksumNone
+	} else {
+		if err := supportsOverwritePolicy(overwritePolicy, newSink); err != nil {
+			log.Fatal(err)
+		}
+		checkAndCreateDir()
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	rep, err := r.Run(ctx)
+	if ledger != nil {
+		if cerr := ledger.Close(); cerr != nil {
+			log.Printf("Failed closing delivery ledger err: %s", cerr)
+		}
+	}
+	if outStream != nil {
+		if cerr := outStream.Close(); cerr != nil {
+			log.Printf("Failed closing output err: %s", cerr)
+		} else if len(singleFile) > 0 {
+			if cerr := writeFileChecksum(singleFile, checksums); cerr != nil {
+				log.Printf("Failed writing checksum err: %s", cerr)
+			}
+		}
+	}
+	failedURLs := make([]string, 0, len(rep.Errors))
+	for u := range rep.Errors {
+		failedURLs = append(failedURLs, u)
+	}
+	sort.Strings(failedURLs)
+	for _, u := range failedURLs {
+		log.Printf("Failed url: %q err: %s", u, rep.Errors[u])
+	}
+	log.Printf("Processed %d urls (%d failed, %d timed out, %d with warnings) in %s",
+		rep.Processed, rep.Failed, rep.TimedOut, len(rep.Warnings), rep.Duration)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if list != nil && list.Err() != nil {
+		log.Fatal(errors.Wrapf(list.Err(), "read %s", urlFile))
+	}
+	if streamErr != nil {
+		if err := <-streamErr; err != nil {
+			log.Fatal(err)
+		}
+	}
+}
+
+// runPipe converts the json document read from r and writes the xml to w,
+// followed by a newline.
+func runPipe(r io.Reader, w io.Writer) error {
+	if validator != nil {
+		var err error
+		if r, err = validator(Metadata{URL: "-"}, bufio.NewReader(r)); err != nil {
+			return errors.Wrap(err, "validation failed")
+		}
+	}
+	if convOpts.selector != nil {
+		var err error
+		if r, err = convOpts.selector.reader(r); err != nil {
+			return err
+		}
+	}
+	// The warnings are logged as those of the urls are.
+	opts := *convOpts
+	opts.warnings = &docWarnings{}
+	defer func() {
+		for _, msg := range opts.warnings.messages() {
+			log.Printf("Warning: %s", msg)
+		}
+	}()
+	bw := bufio.NewWriter(w)
+	if err := convertStream(r, bw, &opts, false); err != nil {
+		return err
+	}
+	if err := bw.WriteByte('\n'); err != nil {
+		return err
+	}
+	return bw.Flush()
+}
+
+// urlList returns the urls of --urls followed by the ones of --url-file.
+func urlList() ([]string, error) {
+	it, err := openURLList()
+	if err != nil {
+		return nil, err
+	}
+	defer it.Close()
+	return it.all()
+}
+
+// openURLList returns an iterator over the urls of --urls followed by the ones
+// of --url-file, which is read as the urls are claimed. --url-file - reads
+// stdin. With --backfill, the urls are the templates of the urls of the
+// periods.
+func openURLList() (*urlIter, error) {
+	it, err := openURLEntries()
+	if err != nil || backfill == nil {
+		return it, err
+	}
+	defer it.Close()
+	templates, err := it.all()
+	if err != nil {
+		return nil, err
+	}
+	list, err := backfill.expand(templates)
+	if err != nil {
+		return nil, err
+	}
+	return newURLIter("", strings.NewReader(strings.Join(list, "\n"))), nil
+}
+
+// openURLEntries returns an iterator over the entries of --urls and
+// --url-file.
+func openURLEntries() (*urlIter, error) {
+	switch urlFile {
+	case "":
+		return newURLIter(urls, nil), nil
+	case "-":
+		return newURLIter(urls, os.Stdin), nil
+	}
+	f, err := os.Open(urlFile)
+	if err != nil {
+		return nil, err
+	}
+	it := newURLIter(urls, f)
+	it.c = f
+	return it, nil
+}
+
+// readURLs reads one url per line. Blank lines and lines starting with # are
+// skipped.
+func readURLs(r io.Reader) ([]string, error) {
+	return newURLIter("", r).all()
+}
+
+// urlIter returns the entries of a url list one at a time, so that long lists
+// aren't held in memory: the entries of a comma separated list, as splitList
+// does, then the lines of a reader, as readURLs does.
+type urlIter struct {
+	list     string
+	listDone bool
+	sc       *bufio.Scanner
+	c        io.Closer
+	err      error
+}
+
+func newURLIter(list string, r io.Reader) *urlIter {
+	it := &urlIter{list: list, listDone: len(strings.TrimSpace(list)) == 0}
+	if r != nil {
+		it.sc = bufio.NewScanner(r)
+	}
+	return it
+}
+
+// next returns the next entry, or io.EOF once the list is exhausted or can't
+// be read, see Err.
+func (it *urlIter) next() (string, error) {
+	if !it.listDone {
+		entry := it.list
+		if i := strings.IndexByte(it.list, ','); i >= 0 {
+			entry, it.list = it.list[:i], it.list[i+1:]
+		} else {
+			it.listDone = true
+		}
+		return strings.TrimSpace(entry), nil
+	}
+	for it.sc != nil && it.sc.Scan() {
+		line := strings.TrimSpace(it.sc.Text())
+		if len(line) == 0 || strings.HasPrefix(line, "#") {
+			continue
+		}
+		return line, nil
+	}
+	if it.sc != nil {
+		it.err, it.sc = it.sc.Err(), nil
+	}
+	return "", io.EOF
+}
+
+// all returns the remaining entries.
+func (it *urlIter) all() ([]string, error) {
+	var list []string
+	for {
+		entry, err := it.next()
+		if err == io.EOF {
+			return list, it.Err()
+		}
+		list = append(list, entry)
+	}
+}
+
+// Err returns the error that stopped the reading of the list, if any.
+func (it *urlIter) Err() error {
+	return it.err
+}
+
+// Close closes the file of the list, if any.
+func (it *urlIter) Close() error {
+	if it.c == nil {
+		return nil
+	}
+	return it.c.Close()
+}
+
+// splitList splits the comma separated list, trimming the entries.
+func splitList(s string) []string {
+	list := strings.Split(s, ",")
+	for i := range list {
+		list[i] = strings.TrimSpace(list[i])
+	}
+	return list
+}
+
+// processURL fetches the url (or one of its mirrors) and writes the converted xml to the document name
+// of the output sink. The outcome is logged with the logger carried by ctx.
+func processURL(ctx context.Context, u, name string) error {
+	if perURLDeadline > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, perURLDeadline)
+		defer cancel()
+	}
+	if err := overwritePolicy.checkOutput(newDefaultWorker(name).sink, name); err != nil {
+		return keepExisting(loggerFromContext(ctx), name, err)
+	}
+	// The mirrors of the url are tried in turn.
+	url, mirrors := splitMirrors(u)
+	var err error
+	for _, u := range append([]string{url}, mirrors...) {
+		err = processPayload(ctx, newDefaultWorker(name), name, func(w *worker) error {
+			return w.fetchAndProcess(u)
+		})
+		if err == nil || ctx.Err() != nil {
+			return err
+		}
+	}
+	return err
+}
+
+// processPayload writes the document name to the sink of w, with process
+// converting the payload. The outcome is logged with the logger carried by ctx.
+func processPayload(ctx context.Context, w *worker, name string,
+	process func(w *worker) error) error {
+	l := loggerFromContext(ctx)
+	l.Printf("Started processing")
+	if err := w.sink.Open(name); err != nil {
+		l.Printf("Failed processing err: %s", err)
+		return errors.Wrap(err, "open output")
+	}
+	w.ctx, w.output = ctx, name
+	if err := process(w); err != nil {
+		if err == errNotModified {
+			if aerr := w.sink.Abort(); aerr != nil {
+				l.Printf("Failed discarding output err: %s", aerr)
+			}
+			l.Printf("Not modified, kept output: %q", outputPath(name))
+			return nil
+		}
+		if ctx.Err() == context.Canceled {
+			if cerr := cancelOutput(w.sink, w.partial, err); cerr != nil {
+				l.Printf("Failed cleaning up output policy: %s err: %s", w.partial, cerr)
+			}
+			l.Printf("Canceled processing policy: %s err: %s", w.partial, err)
+			return err
+		}
+		if aerr := w.sink.Abort(); aerr != nil {
+			l.Printf("Failed discarding output err: %s", aerr)
+		}
+		l.Printf("Failed processing err: %s", err)
+		return err
+	}
+	if err := w.sink.Commit(); err != nil {
+		l.Printf("Failed processing err: %s", err)
+		return errors.Wrap(err, "commit output")
+	}
+	if err := recordOutput(w.url, name); err != nil {
+		l.Printf("Failed caching output name err: %s", err)
+	}
+	for _, msg := range w.warnings.messages() {
+		l.Printf("Warning: %s", msg)
+	}
+	l.Printf("Finished processing output: %q", outputPath(name))
+	return nil
+}
+
+// checkAndCreateDir creates the output directory. Remote outputs are left
+// alone.
+func checkAndCreateDir() {
+	if !isLocalOutput(output) {
+		return
+	}
+	dirExists, err := exists(output)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if dirExists {
+		return
+	}
+	if err = os.MkdirAll(output, 0700); err != nil {
+		log.Fatalf("Error Creating Dir: %q", output)
+	}
+}
+
+// Getter interface is used to mock the client in tests.
+type Getter interface {
+	Get(url string) (*http.Response, error)
+}
+
+// Worker encapsulates the client and sink. Multiple workers can run
+// concurrently for fetch and process urls.
+type worker struct {
+	client Getter
+	sink   Sink
+	// name identifies the output of the worker in captures.
+	name string
+	// output is the name of the document being written.
+	output  string
+	capture *failureCapture
+	opts    *convertOptions
+	// ctx cancels the conversion, and partial decides what happens to the
+	// output when it does.
+	ctx     context.Context
+	partial PartialPolicy
+	// validate is applied to the payloads before they are converted, if set.
+	validate Validator
+	// sniff is set if the payloads are recognized by their content rather
+	// than by their Content-Type.
+	sniff bool
+	// pages follows the pages of the fetched urls, if set.
+	pages *pagination
+	// method and body are those of the requests of the fetched urls, and
+	// accepted the statuses other than 2xx whose payloads are converted.
+	method   string
+	body     []byte
+	accepted statusSet
+	// warnings are the warnings of the last conversion.
+	warnings *docWarnings
+	// url is the url of the last payload.
+	url string
+}
+
+// newDefaultWorker returns a worker writing the document name to a new sink.
+func newDefaultWorker(name string) *worker {
+	w := &worker{
+		client:   fetchClient,
+		name:     strings.TrimSuffix(path.Base(name), path.Ext(name)),
+		opts:     convOpts,
+		partial:  partialPolicy,
+		validate: validator,
+		sniff:    ignoreContentType,
+		pages:    pager,
+		method:   requestMethod,
+		body:     requestBody,
+		accepted: acceptedStatus,
+	}
+	wrap := func(sink Sink) Sink {
+		if ledger != nil {
+			sink = &ledgerSink{sink: sink, ledger: ledger}
+		}
+		if convOpts.binaryFields.files() {
+			sink = &attachmentSink{sink: sink}
+		}
+		if statsMode != StatsNone {
+			sink = &statsSink{sink: sink, mode: statsMode}
+		}
+		return sink
+	}
+	if len(routes) > 0 {
+		w.sink = newRouteSink(routes, wrap)
+	} else {
+		w.sink = wrap(newSink())
+	}
+	if fragments {
+		w.sink = &fragmentSink{sink: w.sink}
+	}
+	if len(splitLanguageField) > 0 {
+		w.sink = &languageSink{sink: w.sink, field: splitLanguageField}
+	}
+	w.capture = newFailureCapture()
+	return w
+}
+
+// fetchAndProcess will fetch the provided URL. If the data is json, it will convert it to xml.
+func (w *worker) fetchAndProcess(url string) error {
+	ctx := w.ctx
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	src := &urlSource{client: w.client, capture: w.capture, pages: w.pages, method: w.method,
+		body: w.body, accepted: w.accepted}
+	body, m, err := src.fetch(ctx, Metadata{URL: url})
+	return w.handle(body, m, err)
+}
+
+// handle converts the payload read from a source. err is the error returned
+// by the source along with the payload.
+func (w *worker) handle(body io.ReadCloser, m Metadata, err error) error {
+	if err != nil {
+		w.saveCapture(m, err)
+		return err
+	}
+	defer body.Close()
+	w.url = m.URL
+	if es, ok := w.sink.(ExistingSink); ok && m.NotModified && es.Exists(w.output) &&
+		convertedOutput(m.URL, w.output) {
+		return errNotModified
+	}
+	if w.ctx != nil {
+		// Closing the body unblocks a read waiting for a slow server.
+		defer closeOnDone(w.ctx, body)()
+	}
+	if err := w.process(body, m); err != nil {
+		w.saveCapture(m, err)
+		return err
+	}
+	return nil
+}
+
+func (w *worker) saveCapture(m Metadata, cause error) {
+	if err := w.capture.save(w.name, m.URL, m.resp, m.rec, cause); err != nil {
+		log.Printf("Failed capturing url: %q err: %s", m.URL, err)
+	}
+}
+
+// process converts the json payload to xml as it is read.
+func (w *worker) process(body io.Reader, m Metadata) error {
+	if !w.sniff && !isJSON(m.ContentType) {
+		return errors.Errorf("Invalid Content-Type header. Expected application/json, received %q",
+			m.ContentType)
+	}
+	// The options are copied to gather the warnings and statistics of the
+	// document.
+	opts := &convertOptions{}
+	if w.opts != nil {
+		*opts = *w.opts
+	}
+	w.warnings = &docWarnings{}
+	opts.warnings = w.warnings
+	opts.attachments = w.attachments()
+	if stats := w.stats(); stats != nil {
+		opts.stats = stats
+		// The records of --fragments and the languages count their own json.
+		if w.sink == w.documentSink() {
+			body = statsReader{r: body, stats: stats}
+		}
+	}
+	if w.ctx != nil {
+		body = ctxReader{ctx: w.ctx, r: body}
+	}
+	if w.sniff {
+		br := bufio.NewReader(body)
+		if err := sniffJSON(br); err != nil {
+			return err
+		}
+		body = br
+	}
+	if w.validate != nil {
+		var err error
+		if body, err = w.validate(m, bufio.NewReader(body)); err != nil {
+			return errors.Wrap(err, "validation failed")
+		}
+	}
+	if opts.selector != nil {
+		var err error
+		if body, err = opts.selector.reader(body); err != nil {
+			return err
+		}
+	}
+	if fs, ok := w.sink.(*fragmentSink); ok {
+		return convertFragments(body, fs, opts)
+	}
+	if ls, ok := w.sink.(*languageSink); ok {
+		return convertLanguages(body, ls, opts)
+	}
+	if rs, ok := w.sink.(*routeSink); ok {
+		return convertRoutes(body, rs, opts)
+	}
+	return convertStream(body, w.sink, opts, w.partial == PartialFinalize)
+}
+
+// documentSink returns the sink of the worker the documents are written to:
+// the one under the sink splitting the payloads into several documents, if
+// any.
+func (w *worker) documentSink() Sink {
+	switch s := w.sink.(type) {
+	case *fragmentSink:
+		return s.sink
+	case *languageSink:
+		return s.sink
+	}
+	return w.sink
+}
+
+// stats returns the statistics gathered by the sink of the worker, if it
+// publishes them.
+func (w *worker) stats() *docStats {
+	return sinkStats(w.documentSink())
+}
+
+// attachments returns the sink writing the files of the documents of the
+// worker, if the options write them.
+func (w *worker) attachments() *attachmentSink {
+	return sinkAttachments(w.documentSink())
+}
+
+// sinkStats returns the statistics gathered by sink, if it publishes them.
+func sinkStats(sink Sink) *docStats {
+	if ss, ok := sink.(*statsSink); ok {
+		return &ss.stats
+	}
+	return nil
+}
+
+// sinkAttachments returns the sink under sink writing the files of the
+// documents, if any.
+func sinkAttachments(sink Sink) *attachmentSink {
+	if ss, ok := sink.(*statsSink); ok {
+		sink = ss.sink
+	}
+	as, _ := sink.(*attachmentSink)
+	return as
+}
+
+// sniffJSON checks that the payload starts like a json object or array, after
+// whitespace and an optional byte order mark.
+func sniffJSON(br *bufio.Reader) error {
+	if bom, err := br.Peek(3); err == nil && string(bom) == "\xef\xbb\xbf" {
+		br.Discard(3)
+	}
+	for {
+		b, err := br.Peek(1)
+		if err == io.EOF {
+			return errors.New("Empty payload")
+		}
+		if err != nil {
+			return errors.Wrap(err, "read")
+		}
+		switch b[0] {
+		case ' ', '\t', '\r', '\n':
+			br.Discard(1)
+		case '{', '[':
+			return nil
+		default:
+			return errors.Errorf("Payload doesn't look like json. It starts with %q",
+				peekLine(br, 32))
+		}
+	}
+}
+
+// peekLine returns up to n bytes of the first line of br, without consuming
+// them.
+func peekLine(br *bufio.Reader, n int) string {
+	b, _ := br.Peek(n)
+	if i := bytes.IndexAny(b, "\r\n"); i >= 0 {
+		b = b[:i]
+	}
+	return string(b)
+}
+
+// isJSON returns true if the media type of the Content-Type header is json:
+// application/json or a type with a +json suffix, e.g. application/vnd.api+json.
+// Parameters such as the charset are ignored.
+func isJSON(contentType string) bool {
+	t, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+	return t == "application/json" || strings.HasSuffix(t, "+json")
+}
+
+// jsonToXml converts the json data in "data" to xml and writes it to the writer.
+// opts may be nil.
+func jsonToXml(data []byte, w io.Writer, opts *convertOptions) error {
+	data, err := convert(data, opts)
+	if err != nil {
+		return err
+	}
+	if opts != nil && opts.xslt != nil {
+		if data, err = opts.transform(data); err != nil {
+			return err
+		}
+	}
+	if opts != nil && opts.xsd != nil {
+		if err := opts.checkXSD(data); err != nil {
+			return err
+		}
+	}
+	_, err = w.Write(data)
+	return errors.Wrap(err, "write")
+
+}
+
+// Convert converts the json document to xml with the default options. It has
+// no side effects and is safe for concurrent use.
+func Convert(data []byte) ([]byte, error) {
+	return convert(data, nil)
+}
+
+// convert converts the json document to xml. opts may be nil.
+func convert(data []byte, opts *convertOptions) ([]byte, error) {
+	if opts == nil {
+		opts = &convertOptions{}
+	}
+	out, err := convertDocument(data, opts)
+	if err != nil {
+		return out, err
+	}
+	if opts.selfClosing {
+		out = selfClose(out)
+	}
+	if opts.declaration {
+		out = append([]byte(xml.Header), out...)
+	}
+	if opts.stats != nil {
+		opts.stats.addRecords(countRecords(data))
+	}
+	return opts.layout.apply(out), nil
+}
+
+// convertDocument converts the json document to xml with the converter
+// selected by opts.
+func convertDocument(data []byte, opts *convertOptions) ([]byte, error) {
+	if len(opts.preset) > 0 {
+		return opts.geoJSONToXml(data)
+	}
+	if opts.schema != nil {
+		return opts.schemaToXml(data)
+	}
+	var err error
+	root := opts.rootElementName()
+	if opts.generic || opts.lossless {
+		data, err = opts.genericToXml(data)
+	} else if opts.mapping != nil {
+		if isJSONArray(data) {
+			root = opts.arrayRootName()
+		} else if len(opts.mapping.Root) > 0 {
+			root = opts.mapping.Root
+		}
+		data, err = opts.mappingToXml(data)
+	} else {
+		if isJSONArray(data) {
+			root = opts.arrayRootName()
+		}
+		data, err = opts.structToXml(data)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if doctype := opts.entities.doctype(root); len(doctype) > 0 {
+		data = append([]byte(doctype), opts.entities.substitute(data)...)
+	}
+	return data, nil
+}
+
+// structToXml converts the json data to the jsonData type and marshals it. A
+// json array is converted to one element per entry, wrapped in the array root
+// element.
+func (o *convertOptions) structToXml(data []byte) ([]byte, error) {
+	if isJSONArray(data) {
+		return o.structArrayToXml(data)
+	}
+	p, err := o.decodeRecord(data)
+	if err != nil {
+		return nil, err
+	}
+	rec, err := o.newRecord(p)
+	if err != nil {
+		return nil, err
+	}
+	data, err = xml.MarshalIndent(rec, " ", " ")
+	if err != nil {
+		return nil, errors.Wrap(err, "xml.Marshal")
+	}
+	if o.xsiTypes {
+		return addXSITypes(data, structXSITypes(reflect.TypeOf(*p)), 1, true)
+	}
+	return data, nil
+}
+
+// recordList is the root element wrapping the records of a json array.
+type recordList struct {
+	XMLName xml.Name
+	Records []*record `xml:",any"`
+}
+
+func (o *convertOptions) structArrayToXml(data []byte) ([]byte, error) {
+	var entries []json.RawMessage
+	if err := json.Unmarshal(data, &entries); err != nil {
+		return nil, errors.Wrap(err, "json.Unmarshal")
+	}
+	list := recordList{XMLName: xml.Name{Local: o.arrayRootName()}}
+	for i, e := range entries {
+		p, err := o.decodeRecord(e)
+		if err != nil {
+			return nil, errors.Wrapf(err, "entry %d", i)
+		}
+		rec, err := o.newRecord(p)
+		if err != nil {
+			return nil, err
+		}
+		list.Records = append(list.Records, rec)
+	}
+	data, err := xml.MarshalIndent(list, " ", " ")
+	if err != nil {
+		return nil, errors.Wrap(err, "xml.Marshal")
+	}
+	if o.xsiTypes {
+		return addXSITypes(data, structXSITypes(reflect.TypeOf(jsonData{})), 2, true)
+	}
+	return data, nil
+}
+
+// decodeRecord decodes a json object of the jsonData type.
+func (o *convertOptions) decodeRecord(data []byte) (*jsonData, error) {
+	var p jsonData
+	if o.coerce {
+		var err error
+		if data, err = coerceForStruct(data, reflect.TypeOf(p)); err != nil {
+			return nil, err
+		}
+	}
+	if err := json.Unmarshal(data, &p); err != nil {
+		return nil, errors.Wrap(err, "json.Unmarshal")
+	}
+
+	// Data could be valid json but not of type jsonData.
+	if o.isEmptyRecord(&p) {
+		return nil, ErrUnknownJSON
+	}
+	return &p, nil
+}
+
+// isJSONArray returns true if the json document is an array.
+func isJSONArray(data []byte) bool {
+	data = bytes.TrimLeft(data, " \t\r\n")
+	return len(data) > 0 && data[0] == '['
+}
+
+// exists checks if the "path" exists.
+func exists(path string) (bool, error) {
+	_, err := os.Stat(path)
+	if err == nil {
+		return true, nil
+	}
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+	return true, err
+}
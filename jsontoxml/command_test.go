@@ -0,0 +1,309 @@
+package jsontoxml
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/require"
+)
+
+type mockClient struct{}
+
+// Ensure we don't break the interface.
+var _ Getter = &mockClient{}
+
+func (mc *mockClient) Get(url string) (*http.Response, error) {
+	switch {
+	case url == "valid": // Returns valid response.
+		reader := bytes.NewReader([]byte(`{"first_name": "firstname", "last_name":"lastname"}`))
+		body := ioutil.NopCloser(reader)
+		header := make(http.Header)
+		header["Content-Type"] = []string{"application/json"}
+		return &http.Response{
+			StatusCode: 200,
+			Header:     header,
+			Body:       body,
+		}, nil
+	case url == "invalid": // Returns invalid response.
+		reader := bytes.NewReader([]byte(`"last_name":"lastname"}`))
+		body := ioutil.NopCloser(reader)
+		return &http.Response{
+			StatusCode: 200,
+			Body:       body,
+		}, nil
+	case url == "unknown": // Return unknown response.
+		reader := bytes.NewReader([]byte(`{"foo":"bar"}`))
+		body := ioutil.NopCloser(reader)
+		header := make(http.Header)
+		header["Content-Type"] = []string{"application/json"}
+		return &http.Response{
+			StatusCode: 200,
+			Body:       body,
+		}, nil
+	default:
+		return nil, errors.New("Unknown url")
+	}
+}
+
+type mockSink struct {
+	io.Writer
+}
+
+var _ Sink = mockSink{}
+
+func (mockSink) Open(string) error { return nil }
+func (mockSink) Commit() error     { return nil }
+func (mockSink) Abort() error      { return nil }
+
+func TestWorker(t *testing.T) {
+	tt := []struct {
+		name      string
+		output    string
+		shouldErr bool
+	}{
+		{"valid", " <jsonData>\n  <Id>0</Id>\n  <name>\n   <first>firstname</first>\n   " +
+			"<last>lastname</last>\n  </name>\n  <City></City>\n  <State></State>\n </jsonData>",
+			false},
+		{"invalid", "", true},
+		{"unknown", "", true},
+	}
+
+	for _, ti := range tt {
+		t.Run(ti.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			w := &worker{
+				client: new(mockClient),
+				sink:   mockSink{&buf},
+			}
+			err := w.fetchAndProcess(ti.name)
+			if ti.shouldErr {
+				require.Error(t, err)
+				require.Zero(t, buf.Bytes())
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, ti.output, string(buf.Bytes()))
+		})
+	}
+
+}
+
+func TestIsJSON(t *testing.T) {
+	for ct, want := range map[string]bool{
+		"application/json":                true,
+		"application/json; charset=utf-8": true,
+		"Application/JSON":                true,
+		"application/vnd.api+json":        true,
+		"application/hal+json; q=1":       true,
+		"application/problem+json":        true,
+		"text/plain":                      false,
+		"application/jsonp":               false,
+		"":                                false,
+		"application/json; charset":       false,
+	} {
+		require.Equal(t, want, isJSON(ct), ct)
+	}
+}
+
+func TestIgnoreContentType(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain")
+		switch r.URL.Path {
+		case "/json":
+			w.Write([]byte("\xef\xbb\xbf\n [{\"first_name\": \"a\"}]"))
+		case "/html":
+			w.Write([]byte("<html>\n<body>Error</body>"))
+		}
+	}))
+	defer srv.Close()
+
+	var buf bytes.Buffer
+	w := &worker{client: srv.Client(), sink: mockSink{&buf}}
+	require.EqualError(t, w.fetchAndProcess(srv.URL+"/json"),
+		`Invalid Content-Type header. Expected application/json, received "text/plain"`)
+	w.sniff = true
+	require.NoError(t, w.fetchAndProcess(srv.URL+"/json"))
+	require.Contains(t, buf.String(), "<first>a</first>")
+	require.EqualError(t, w.fetchAndProcess(srv.URL+"/html"),
+		`Payload doesn't look like json. It starts with "<html>"`)
+	require.EqualError(t, w.fetchAndProcess(srv.URL+"/empty"), "Empty payload")
+}
+
+func TestJsonRespToXml(t *testing.T) {
+	t.Run("ok", func(t *testing.T) {
+		jdata := []byte(`{"id": 10, "first_name": "firstname", "last_name":"lastname"}`)
+		buf := &bytes.Buffer{}
+		require.NoError(t, jsonToXml(jdata, buf, nil))
+		res := ` <jsonData>
+  <Id>10</Id>
+  <name>
+   <first>firstname</first>
+   <last>lastname</last>
+  </name>
+  <City></City>
+  <State></State>
+ </jsonData>`
+		require.Equal(t, res, string(buf.Bytes()))
+	})
+	t.Run("valid json but not jsonData", func(t *testing.T) {
+		jdata := []byte(`{"foo":"lastname"}`)
+		buf := &bytes.Buffer{}
+		err := jsonToXml(jdata, buf, nil)
+		require.Error(t, err)
+		require.ErrorIs(t, ErrUnknownJSON, err)
+		require.Empty(t, buf)
+	})
+	t.Run("invalid json", func(t *testing.T) {
+		jdata := []byte(`{"foo":"lastname"`)
+		buf := &bytes.Buffer{}
+		err := jsonToXml(jdata, buf, nil)
+		require.NotErrorIs(t, ErrUnknownJSON, err)
+		require.Empty(t, buf)
+	})
+}
+
+func TestDataIsEmpty(t *testing.T) {
+	var p jsonData
+	require.True(t, p.IsEmpty())
+	p.FirstName = "foo"
+	require.False(t, p.IsEmpty())
+}
+
+func TestDataIsAbsent(t *testing.T) {
+	var p jsonData
+	require.NoError(t, json.Unmarshal([]byte(`{"ID": 0, "city": null, "foo": 1}`), &p))
+	require.True(t, p.IsEmpty())
+	require.False(t, p.IsAbsent())
+	require.True(t, p.Has("Id"))
+	require.False(t, p.Has("City"))
+
+	p = jsonData{}
+	require.NoError(t, json.Unmarshal([]byte(`{"foo": 1, "State": null}`), &p))
+	require.True(t, p.IsAbsent())
+}
+
+func TestEmptyRecords(t *testing.T) {
+	opts := &convertOptions{}
+	data, err := convert([]byte(`{"id": 0}`), opts)
+	require.NoError(t, err)
+	require.Contains(t, string(data), "<Id>0</Id>")
+	_, err = convert([]byte(`{"foo": 1}`), opts)
+	require.ErrorIs(t, err, ErrUnknownJSON)
+
+	opts.emptyRecords = emptyRecordsZero
+	_, err = convert([]byte(`{"id": 0}`), opts)
+	require.ErrorIs(t, err, ErrUnknownJSON)
+}
+
+// goos: linux
+// goarch: amd64
+// pkg: jsonToXml
+// BenchmarkWorkers
+// BenchmarkWorkers-8   	  287504	      4744 ns/op
+// PASS
+// ok  	jsonToXml	2.314s
+func BenchmarkWorkers(b *testing.B) {
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			var buf bytes.Buffer
+			w := &worker{
+				client: new(mockClient),
+				sink:   mockSink{&buf},
+			}
+			err := w.fetchAndProcess("valid")
+			if err != nil {
+				panic(err)
+			}
+		}
+	})
+}
+
+func TestJsonArrayToXml(t *testing.T) {
+	t.Run("ok", func(t *testing.T) {
+		jdata := []byte(` [{"id": 1, "first_name": "a"}, {"id": 2, "last_name": "b"}]`)
+		buf := &bytes.Buffer{}
+		require.NoError(t, jsonToXml(jdata, buf, &convertOptions{arrayRoot: "people"}))
+		res := ` <people>
+  <jsonData>
+   <Id>1</Id>
+   <name>
+    <first>a</first>
+    <last></last>
+   </name>
+   <City></City>
+   <State></State>
+  </jsonData>
+  <jsonData>
+   <Id>2</Id>
+   <name>
+    <first></first>
+    <last>b</last>
+   </name>
+   <City></City>
+   <State></State>
+  </jsonData>
+ </people>`
+		require.Equal(t, res, string(buf.Bytes()))
+	})
+	t.Run("default root", func(t *testing.T) {
+		buf := &bytes.Buffer{}
+		require.NoError(t, jsonToXml([]byte(`[]`), buf, nil))
+		require.Equal(t, " <records></records>", buf.String())
+	})
+	t.Run("unknown entry", func(t *testing.T) {
+		buf := &bytes.Buffer{}
+		err := jsonToXml([]byte(`[{"id": 1}, {"foo": "bar"}]`), buf, nil)
+		require.ErrorIs(t, err, ErrUnknownJSON)
+		require.Empty(t, buf)
+	})
+}
+
+func TestRunPipe(t *testing.T) {
+	var out bytes.Buffer
+	require.NoError(t, runPipe(strings.NewReader(`{"id": 1, "city": "Pune"}`), &out))
+	require.True(t, strings.HasPrefix(out.String(), " <jsonData>\n  <Id>1</Id>"))
+	require.True(t, strings.HasSuffix(out.String(), "</jsonData>\n"))
+
+	require.Error(t, runPipe(strings.NewReader(`{"foo": 1}`), &out))
+
+	// The payloads are validated like those of the urls.
+	defer func() { validator = nil }()
+	validator, _ = newSoftErrorValidator("error", "")
+	require.EqualError(t, runPipe(strings.NewReader(`{"id": 1, "error": "down"}`), &out),
+		`validation failed: error in the payload: "down"`)
+	validator = nil
+
+	// The warnings are logged.
+	s, err := compileXSD([]byte(testXSD))
+	require.NoError(t, err)
+	s.warn = true
+	defer func(opts *convertOptions) { convOpts = opts }(convOpts)
+	convOpts = &convertOptions{xsd: s}
+	var logs bytes.Buffer
+	log.SetOutput(&logs)
+	defer log.SetOutput(os.Stderr)
+	out.Reset()
+	require.NoError(t, runPipe(strings.NewReader(`{"id": 1, "state": "California"}`), &out))
+	require.Contains(t, out.String(), "<State>California</State>")
+	require.Contains(t, logs.String(), `Warning: xsd: /jsonData/State[1]: "California" doesn't match`)
+}
+
+func TestReadURLs(t *testing.T) {
+	list, err := readURLs(strings.NewReader(`# provider a
+http://a/1.json
+
+  http://a/2.json#page
+	# provider b
+http://b/1.json`))
+	require.NoError(t, err)
+	require.Equal(t, []string{"http://a/1.json", "http://a/2.json#page", "http://b/1.json"}, list)
+}
@@ -0,0 +1,497 @@
+package jsontoxml
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// convertOptions control how the xml is generated. The zero value produces the
+// plain marshaled jsonData.
+type convertOptions struct {
+	// runTimeField and convertedTimeField name the element (or attribute, if
+	// prefixed with @) holding the start time of the run and the time at which
+	// the record was converted. Empty names disable the field.
+	runTimeField       string
+	convertedTimeField string
+	runTime            time.Time
+	timeFormat         string
+	location           *time.Location
+	// locale controls the rendering of month names and decimal separators. nil
+	// renders values the way Go does.
+	locale *locale
+	// idGen, if set, generates an id stored in the idAttr attribute of every
+	// record.
+	idGen    idGenerator
+	idAttr   string
+	entities entityOptions
+	// generic converts any json document instead of the jsonData type.
+	generic bool
+	// lossless converts the whole json document, annotating the elements with
+	// their json type so that the reverse subcommand can restore it. It implies
+	// generic.
+	lossless bool
+	// xsiTypes annotates the values with xsi:type.
+	xsiTypes bool
+	// coerce turns strings that look like numbers or bools into numbers and
+	// bools.
+	coerce bool
+	// arrayRoot names the element wrapping the records of a json array.
+	arrayRoot string
+	// rootElement names the element of every record, or the root of the
+	// generic documents. rootName if empty.
+	rootElement string
+	// emptyRecords is the policy deciding which jsonData records are empty,
+	// and rejected with ErrUnknownJSON. Empty means emptyRecordsAbsent.
+	emptyRecords string
+	// selfClosing writes the empty elements as self-closing tags.
+	selfClosing bool
+	// layout replaces the indentation of the xml. nil keeps the one of the
+	// converter.
+	layout *xmlLayout
+	// declaration starts the documents with the xml declaration.
+	declaration bool
+	// stats gathers the statistics of the document being converted, and
+	// warnings its warnings, if set. They are set on a copy of the options made
+	// for the document.
+	stats    *docStats
+	warnings *docWarnings
+	// whitespace applies the whitespace policies to the string values. nil
+	// keeps them.
+	whitespace *whitespaceOptions
+	// html strips or sanitizes the html of the string values. nil keeps it.
+	html *htmlSanitizer
+	// maxFieldLength truncates the longer string values, if > 0.
+	maxFieldLength int
+	// binaryFields applies the policies of the base64 values. nil keeps them
+	// inline. The files of the document are added to attachments, set on the
+	// copy of the options made for the document.
+	binaryFields *binaryOptions
+	attachments  *attachmentSink
+	// attributeFields are the json keys whose scalar values are written as
+	// attributes of their parent element.
+	attributeFields map[string]bool
+	// cdataFields are the json keys whose string values are written in CDATA
+	// sections.
+	cdataFields map[string]bool
+	// preset, if set, converts the json format to its xml counterpart, e.g.
+	// GeoJSON to GML, instead of using the options above.
+	preset string
+	// schema, if set, drives the conversion instead of the jsonData type.
+	schema *operationSchema
+	// mapping, if set, describes the records instead of the jsonData type.
+	mapping *recordMapping
+	// selector, if set, plucks the part of the payloads to convert.
+	selector *jsonSelector
+	// xslt, if set, transforms the generated documents.
+	xslt *xsltStylesheet
+	// xsd, if set, validates the generated documents.
+	xsd *xsdSchema
+	// now returns the current time. Replaced in tests.
+	now func() time.Time
+}
+
+// Options are the conversion options of a Runner, for the programs embedding
+// the converter. The zero value converts the jsonData records, like the command
+// line without flags.
+type Options struct {
+	// Generic converts any json document instead of the jsonData records.
+	Generic bool
+	// Lossless converts the whole json document, annotating the elements with
+	// their json type so that the reverse subcommand can restore it. It implies
+	// Generic.
+	Lossless bool
+	// XSITypes annotates the values with xsi:type.
+	XSITypes bool
+	// Coerce turns the strings that look like numbers or bools into numbers
+	// and bools.
+	Coerce bool
+	// ArrayRoot names the element wrapping the records of a json array.
+	// "records" if empty.
+	ArrayRoot string
+	// RootElement names the element of every record, or the root element of
+	// the generic documents. "jsonData" if empty.
+	RootElement string
+	// Declaration starts the documents with the xml declaration.
+	Declaration bool
+	// SelfClosing writes the empty elements as self-closing tags.
+	SelfClosing bool
+}
+
+// convertOptions returns the convert options of o.
+func (o *Options) convertOptions() (*convertOptions, error) {
+	for _, name := range []string{o.ArrayRoot, o.RootElement} {
+		if len(name) > 0 && !xmlNameExpr.MatchString(name) {
+			return nil, errors.Errorf("invalid element name %q", name)
+		}
+	}
+	return &convertOptions{
+		generic:     o.Generic || o.Lossless,
+		lossless:    o.Lossless,
+		xsiTypes:    o.XSITypes,
+		coerce:      o.Coerce,
+		arrayRoot:   o.ArrayRoot,
+		rootElement: o.RootElement,
+		declaration: o.Declaration,
+		selfClosing: o.SelfClosing,
+	}, nil
+}
+
+var (
+	runTimeField, convertedTimeField string
+	timestampFormat, timezone        string
+	localeTag                        string
+	recordIDKind, recordIDAttr       string
+	generic, lossless, xsiTypes      bool
+	stringTypes, arrayRoot           string
+	rootElement                      string
+	xmlDeclaration                   bool
+	emptyElements, emptyRecords      string
+	// convOpts are the options used by every worker. They are built from the
+	// flags before any command runs.
+	convOpts = &convertOptions{}
+)
+
+func init() {
+	rootCmd.PersistentFlags().StringVar(&runTimeField, "run-time-field", "",
+		"Name of an element holding the start time of the run, added to every record."+
+			" Prefix with @ to add an attribute instead.")
+	rootCmd.PersistentFlags().StringVar(&convertedTimeField, "converted-time-field", "",
+		"Name of an element holding the time the record was converted. Prefix with @"+
+			" to add an attribute instead.")
+	rootCmd.PersistentFlags().StringVar(&timestampFormat, "timestamp-format", "rfc3339",
+		"Format of the injected timestamps: rfc3339, rfc3339nano, unix, unixmilli or a Go"+
+			" time layout.")
+	rootCmd.PersistentFlags().StringVar(&timezone, "timezone", "UTC",
+		"Time zone of the injected timestamps, e.g. Europe/Berlin or Local.")
+	rootCmd.PersistentFlags().StringVar(&localeTag, "locale", "",
+		"Locale used for month names and decimal separators in formatted values, e.g. de"+
+			" or fr-FR.")
+	rootCmd.PersistentFlags().StringVar(&recordIDKind, "record-id", "",
+		"Add a generated id to every record: uuidv4, uuidv7, ulid or sha256 (hash of the"+
+			" record).")
+	rootCmd.PersistentFlags().StringVar(&recordIDAttr, "record-id-attr", "id",
+		"Name of the attribute holding the generated record id.")
+	rootCmd.PersistentFlags().BoolVar(&generic, "generic", false,
+		"Convert any json document (objects, arrays, nested structures) instead of only"+
+			" json of the jsonData type.")
+	rootCmd.PersistentFlags().BoolVar(&lossless, "lossless", false,
+		"Convert the whole json document and annotate the elements with their json type,"+
+			" so that the reverse subcommand can convert the xml back to the same json.")
+	rootCmd.PersistentFlags().BoolVar(&xsiTypes, "xsi-types", false,
+		"Annotate the values with xsi:type (and nulls with xsi:nil) and declare the"+
+			" XMLSchema-instance namespace on the root element.")
+	rootCmd.PersistentFlags().StringVar(&arrayRoot, "array-root", "records",
+		"Name of the element wrapping the records when the json is an array of records.")
+	rootCmd.PersistentFlags().StringVar(&rootElement, "root-element", rootName,
+		"Name of the element of every record, e.g. person, or of the root element with"+
+			" --generic.")
+	rootCmd.PersistentFlags().BoolVar(&xmlDeclaration, "xml-declaration", false,
+		"Start every document with <?xml version=\"1.0\" encoding=\"UTF-8\"?>, or the"+
+			" --single-file (or --stdout-root) stream once.")
+	rootCmd.PersistentFlags().StringVar(&stringTypes, "string-types", stringTypesStrict,
+		"How strings that look like numbers or bools (\"123\", \"true\") are handled: strict"+
+			" keeps them as strings, coerce converts them to numbers and bools.")
+	rootCmd.PersistentFlags().StringVar(&emptyElements, "empty-elements", emptyElementsExpanded,
+		"How empty elements are written: expanded (<City></City>) or self-closing (<City/>).")
+	rootCmd.PersistentFlags().StringVar(&emptyRecords, "empty-records", "",
+		"Which json objects aren't jsonData records: absent (the default, none of the fields"+
+			" is set, so {\"id\": 0} is a record) or zero (all the fields are zero valued)."+
+			" With --json-schema, the schema decides by default.")
+}
+
+const (
+	emptyRecordsAbsent = "absent"
+	emptyRecordsZero   = "zero"
+	// emptyRecordsNone rejects no record, for the payloads checked by a JSON
+	// Schema.
+	emptyRecordsNone = "none"
+)
+
+// isEmptyRecord returns true if p is empty under the emptiness policy.
+func (o *convertOptions) isEmptyRecord(p *jsonData) bool {
+	switch o.emptyRecords {
+	case emptyRecordsZero:
+		return p.IsEmpty()
+	case emptyRecordsNone:
+		return false
+	}
+	return p.IsAbsent()
+}
+
+// newConvertOptions builds the convert options from the flags.
+func newConvertOptions() (*convertOptions, error) {
+	loc, err := time.LoadLocation(timezone)
+	if err != nil {
+		return nil, errors.Wrap(err, "invalid --timezone")
+	}
+	loc10n, err := lookupLocale(localeTag)
+	if err != nil {
+		return nil, err
+	}
+	opts := &convertOptions{
+		runTimeField:       runTimeField,
+		convertedTimeField: convertedTimeField,
+		timeFormat:         timestampFormat,
+		location:           loc,
+		locale:             loc10n,
+		runTime:            time.Now(),
+		generic:            generic || lossless,
+		lossless:           lossless,
+		xsiTypes:           xsiTypes,
+		arrayRoot:          arrayRoot,
+		rootElement:        rootElement,
+		maxFieldLength:     maxFieldLength,
+		attributeFields:    newAttributeFields(attributeFields),
+		cdataFields:        newCDATAFields(cdataFields),
+		// The documents wrapped in a shared root element are preceded by the
+		// declaration of the stream instead.
+		declaration: xmlDeclaration && len(singleFile) == 0 &&
+			(output != "-" || len(stdoutRoot) == 0),
+	}
+	switch preset {
+	case "", presetGML, presetKML:
+		opts.preset = preset
+	default:
+		return nil, errors.Errorf("invalid --preset %q. Expected %s or %s", preset, presetGML,
+			presetKML)
+	}
+	if len(openAPIFile) > 0 || len(operationID) > 0 {
+		if len(openAPIFile) == 0 || len(operationID) == 0 {
+			return nil, errors.New("--openapi and --operation must be set together")
+		}
+		if len(preset) > 0 {
+			return nil, errors.New("--openapi can't be used with --preset")
+		}
+		if opts.schema, err = loadOperationSchema(openAPIFile, operationID); err != nil {
+			return nil, err
+		}
+	}
+	if opts.selector, err = newJSONSelector(selectExpr); err != nil {
+		return nil, err
+	}
+	if len(mappingFile) > 0 {
+		if generic || lossless || len(preset) > 0 || opts.schema != nil {
+			return nil, errors.New("--mapping can't be used with --generic, --lossless, --preset" +
+				" nor --openapi")
+		}
+		if opts.mapping, err = loadMapping(mappingFile); err != nil {
+			return nil, err
+		}
+	}
+	if len(xsltFile) > 0 {
+		if opts.xslt, err = loadXSLT(xsltFile); err != nil {
+			return nil, err
+		}
+	}
+	if len(xsdFile) > 0 {
+		if opts.xsd, err = loadXSD(xsdFile); err != nil {
+			return nil, err
+		}
+		switch xsdMode {
+		case xsdFail:
+		case xsdWarn:
+			opts.xsd.warn = true
+		default:
+			return nil, errors.Errorf("invalid --xsd-mode %q. Expected fail or warn", xsdMode)
+		}
+	}
+	if !xmlNameExpr.MatchString(arrayRoot) {
+		return nil, errors.Errorf("invalid --array-root %q", arrayRoot)
+	}
+	if !xmlNameExpr.MatchString(rootElement) {
+		return nil, errors.Errorf("invalid --root-element %q", rootElement)
+	}
+	if opts.binaryFields, err = newBinaryOptions(binaryFields); err != nil {
+		return nil, err
+	}
+	if opts.binaryFields != nil && lossless {
+		return nil, errors.New("--binary-field can't be used with --lossless")
+	}
+	if opts.binaryFields.files() && (output == "-" || len(singleFile) > 0) {
+		return nil, errors.New("--binary-field file can't be used with --output - or" +
+			" --single-file")
+	}
+	if len(attributeFields) > 0 && lossless {
+		return nil, errors.New("--attribute-field can't be used with --lossless")
+	}
+	// The jsonData records, and those of --mapping, have a fixed layout.
+	structRecords := !opts.generic && len(opts.preset) == 0 && opts.schema == nil
+	if len(attributeFields) > 0 && structRecords {
+		return nil, errors.New("--attribute-field requires --generic, --openapi or --preset")
+	}
+	if len(cdataFields) > 0 && structRecords {
+		return nil, errors.New("--cdata-field requires --generic, --openapi or --preset")
+	}
+	if maxFieldLength < 0 {
+		return nil, errors.Errorf("invalid --max-field-length %d", maxFieldLength)
+	}
+	if maxFieldLength > 0 && lossless {
+		return nil, errors.New("--max-field-length can't be used with --lossless")
+	}
+	switch stringTypes {
+	case stringTypesStrict:
+	case stringTypesCoerce:
+		opts.coerce = true
+	default:
+		return nil, errors.Errorf("invalid --string-types %q. Expected strict or coerce",
+			stringTypes)
+	}
+	switch emptyRecords {
+	case "":
+		if len(jsonSchemaFile) > 0 {
+			// The schema decides which payloads are records.
+			opts.emptyRecords = emptyRecordsNone
+		}
+	case emptyRecordsAbsent, emptyRecordsZero:
+		opts.emptyRecords = emptyRecords
+	default:
+		return nil, errors.Errorf("invalid --empty-records %q. Expected absent or zero",
+			emptyRecords)
+	}
+	switch emptyElements {
+	case emptyElementsExpanded:
+	case emptyElementsSelfClosing:
+		opts.selfClosing = true
+	default:
+		return nil, errors.Errorf("invalid --empty-elements %q. Expected expanded or self-closing",
+			emptyElements)
+	}
+	if opts.layout, err = newXMLLayout(indentPrefix, indentFlag, compactOutput); err != nil {
+		return nil, err
+	}
+	opts.entities, err = newEntityOptions(entityDecls, entityFiles, entityRefs)
+	if err != nil {
+		return nil, err
+	}
+	opts.whitespace, err = newWhitespaceOptions(whitespaceDefault, whitespaceFields)
+	if err != nil {
+		return nil, err
+	}
+	if opts.html, err = newHTMLSanitizer(htmlMode, htmlAllow); err != nil {
+		return nil, err
+	}
+	if len(recordIDKind) > 0 {
+		opts.idAttr = recordIDAttr
+		opts.idGen, err = newIDGenerator(recordIDKind, randReader, opts.currentTime)
+		if err != nil {
+			return nil, err
+		}
+	}
+	for _, f := range []string{runTimeField, convertedTimeField} {
+		if len(f) > 0 && len(strings.TrimPrefix(f, "@")) == 0 {
+			return nil, errors.Errorf("invalid timestamp field name %q", f)
+		}
+	}
+	return opts, nil
+}
+
+// formatTime formats t in the configured zone and format.
+func (o *convertOptions) formatTime(t time.Time) string {
+	if o.location != nil {
+		t = t.In(o.location)
+	}
+	switch strings.ToLower(o.timeFormat) {
+	case "", "rfc3339":
+		return t.Format(time.RFC3339)
+	case "rfc3339nano":
+		return t.Format(time.RFC3339Nano)
+	case "unix":
+		return strconv.FormatInt(t.Unix(), 10)
+	case "unixmilli":
+		return strconv.FormatInt(t.UnixNano()/int64(time.Millisecond), 10)
+	default:
+		return o.locale.formatTime(t, o.timeFormat)
+	}
+}
+
+func (o *convertOptions) currentTime() time.Time {
+	if o.now != nil {
+		return o.now()
+	}
+	return time.Now()
+}
+
+// rootName is the default name of the element of every record.
+const rootName = "jsonData"
+
+// rootElementName returns the name of the element of every record.
+func (o *convertOptions) rootElementName() string {
+	if len(o.rootElement) == 0 {
+		return rootName
+	}
+	return o.rootElement
+}
+
+// arrayRootName returns the name of the element wrapping the records of a json
+// array.
+func (o *convertOptions) arrayRootName() string {
+	if len(o.arrayRoot) == 0 {
+		return "records"
+	}
+	return o.arrayRoot
+}
+
+// element is a simple text element added to a record.
+type element struct {
+	XMLName xml.Name
+	Value   string `xml:",chardata"`
+}
+
+// record wraps jsonData with the elements and attributes injected by the
+// convert options.
+type record struct {
+	XMLName xml.Name
+	Attrs   []xml.Attr `xml:",any,attr"`
+	*jsonData
+	Extra []element `xml:",any"`
+}
+
+// newRecord wraps p for marshaling.
+func (o *convertOptions) newRecord(p *jsonData) (*record, error) {
+	r := &record{XMLName: xml.Name{Local: o.rootElementName()}, jsonData: p}
+	o.html.record(p)
+	if o.whitespace.record(p) {
+		r.Attrs = append(r.Attrs, xmlSpacePreserve)
+	}
+	if attr, ok := o.truncateRecord(p); ok {
+		r.Attrs = append(r.Attrs, attr)
+	}
+	return r, o.decorate(r, p)
+}
+
+// decorate adds the id and timestamps to the record. v is the json value of the
+// record and is used to derive content based ids.
+func (o *convertOptions) decorate(r *record, v interface{}) error {
+	if o.idGen != nil {
+		content, err := json.Marshal(v)
+		if err != nil {
+			return errors.Wrap(err, "json.Marshal")
+		}
+		id, err := o.idGen(content)
+		if err != nil {
+			return err
+		}
+		r.add("@"+o.idAttr, id)
+	}
+	if len(o.runTimeField) > 0 {
+		r.add(o.runTimeField, o.formatTime(o.runTime))
+	}
+	if len(o.convertedTimeField) > 0 {
+		r.add(o.convertedTimeField, o.formatTime(o.currentTime()))
+	}
+	return nil
+}
+
+// add adds an element to the record, or an attribute if name starts with @.
+func (r *record) add(name, value string) {
+	if strings.HasPrefix(name, "@") {
+		r.Attrs = append(r.Attrs, xml.Attr{Name: xml.Name{Local: name[1:]}, Value: value})
+		return
+	}
+	r.Extra = append(r.Extra, element{XMLName: xml.Name{Local: name}, Value: value})
+}
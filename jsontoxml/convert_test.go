@@ -0,0 +1,85 @@
+package jsontoxml
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestTimestampFields(t *testing.T) {
+	loc, err := time.LoadLocation("Asia/Kolkata")
+	require.NoError(t, err)
+	runTime := time.Date(2021, 3, 1, 10, 0, 0, 0, time.UTC)
+	opts := &convertOptions{
+		runTimeField:       "@generatedAt",
+		convertedTimeField: "convertedAt",
+		runTime:            runTime,
+		location:           loc,
+		now:                func() time.Time { return runTime.Add(time.Minute) },
+	}
+	jdata := []byte(`{"id": 10, "first_name": "firstname"}`)
+	buf := &bytes.Buffer{}
+	require.NoError(t, jsonToXml(jdata, buf, opts))
+	res := ` <jsonData generatedAt="2021-03-01T15:30:00+05:30">
+  <Id>10</Id>
+  <name>
+   <first>firstname</first>
+   <last></last>
+  </name>
+  <City></City>
+  <State></State>
+  <convertedAt>2021-03-01T15:31:00+05:30</convertedAt>
+ </jsonData>`
+	require.Equal(t, res, buf.String())
+}
+
+func TestRootElement(t *testing.T) {
+	opts := &convertOptions{rootElement: "person", arrayRoot: "people"}
+	out, err := convert([]byte(`[{"id": 1}]`), opts)
+	require.NoError(t, err)
+	require.Equal(t, ` <people>
+  <person>
+   <Id>1</Id>
+   <name>
+    <first></first>
+    <last></last>
+   </name>
+   <City></City>
+   <State></State>
+  </person>
+ </people>`, string(out))
+
+	opts = &convertOptions{rootElement: "doc", generic: true}
+	out, err = convert([]byte(`{"a": 1}`), opts)
+	require.NoError(t, err)
+	require.Equal(t, " <doc>\n  <a>1</a>\n </doc>", string(out))
+}
+
+func TestXMLDeclaration(t *testing.T) {
+	entities, err := newEntityOptions([]string{"co=ACME"}, nil, nil)
+	require.NoError(t, err)
+	opts := &convertOptions{declaration: true, entities: entities, generic: true,
+		layout: &xmlLayout{compact: true}}
+	out, err := convert([]byte(`{"a": "{{co}}"}`), opts)
+	require.NoError(t, err)
+	require.Equal(t, `<?xml version="1.0" encoding="UTF-8"?><!DOCTYPE jsonData [<!ENTITY co "ACME">]>`+
+		`<jsonData><a>&co;</a></jsonData>`, string(out))
+}
+
+func TestFormatTime(t *testing.T) {
+	ts := time.Date(2021, 3, 1, 10, 0, 0, 0, time.UTC)
+	tt := []struct {
+		format, out string
+	}{
+		{"", "2021-03-01T10:00:00Z"},
+		{"unix", "1614592800"},
+		{"unixmilli", "1614592800000"},
+		{"2006-01-02", "2021-03-01"},
+	}
+	for _, ti := range tt {
+		opts := &convertOptions{timeFormat: ti.format}
+		require.Equal(t, ti.out, opts.formatTime(ts))
+	}
+}
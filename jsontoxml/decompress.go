@@ -0,0 +1,105 @@
+package jsontoxml
+
+import (
+	"bufio"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/andybalholm/brotli"
+	"github.com/pkg/errors"
+)
+
+// acceptEncoding lists the content codings decoded by decompressTransport.
+const acceptEncoding = "gzip, deflate, br"
+
+// decompressTransport asks for compressed responses and decodes them, so that
+// the payloads are converted whatever their Content-Encoding. The base
+// transport must not decode them itself.
+type decompressTransport struct {
+	base http.RoundTripper
+}
+
+func (t *decompressTransport) CloseIdleConnections() {
+	closeIdleConnections(t.base)
+}
+
+func (t *decompressTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if len(req.Header.Get("Accept-Encoding")) == 0 && req.Method != http.MethodHead {
+		req = req.Clone(req.Context())
+		req.Header.Set("Accept-Encoding", acceptEncoding)
+	}
+	resp, err := t.base.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	coding := strings.ToLower(strings.TrimSpace(resp.Header.Get("Content-Encoding")))
+	var open func(r *bufio.Reader) (io.Reader, error)
+	switch coding {
+	case "gzip", "x-gzip":
+		open = func(r *bufio.Reader) (io.Reader, error) { return gzip.NewReader(r) }
+	case "deflate":
+		open = openDeflate
+	case "br":
+		open = func(r *bufio.Reader) (io.Reader, error) { return brotli.NewReader(r), nil }
+	default:
+		return resp, nil
+	}
+	resp.Body = &decodedBody{body: resp.Body, open: open, coding: coding}
+	resp.Header.Del("Content-Encoding")
+	resp.Header.Del("Content-Length")
+	resp.ContentLength = -1
+	resp.Uncompressed = true
+	return resp, nil
+}
+
+// openDeflate decodes deflate bodies. The coding is the zlib format, but some
+// servers send raw deflate data, which is recognized by its missing zlib
+// header.
+func openDeflate(r *bufio.Reader) (io.Reader, error) {
+	h, err := r.Peek(2)
+	if err == nil && h[0]&0x0f == 8 && (uint16(h[0])<<8|uint16(h[1]))%31 == 0 {
+		return zlib.NewReader(r)
+	}
+	return flate.NewReader(r), nil
+}
+
+// decodedBody decodes a response body as it is read. The decoder is created by
+// the first read, so that reading the header of the coding doesn't block the
+// round trip.
+type decodedBody struct {
+	body   io.ReadCloser
+	open   func(r *bufio.Reader) (io.Reader, error)
+	coding string
+	r      io.Reader
+	err    error
+}
+
+func (b *decodedBody) Read(p []byte) (int, error) {
+	if b.r == nil && b.err == nil {
+		r, err := b.open(bufio.NewReader(b.body))
+		if err != nil {
+			b.err = errors.Wrapf(err, "decode %s body", b.coding)
+		} else {
+			b.r = r
+		}
+	}
+	if b.err != nil {
+		return 0, b.err
+	}
+	n, err := b.r.Read(p)
+	if err != nil && err != io.EOF {
+		err = errors.Wrapf(err, "decode %s body", b.coding)
+	}
+	return n, err
+}
+
+func (b *decodedBody) Close() error {
+	if c, ok := b.r.(io.Closer); ok {
+		c.Close()
+	}
+	return b.body.Close()
+}
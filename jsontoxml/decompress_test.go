@@ -0,0 +1,77 @@
+package jsontoxml
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/andybalholm/brotli"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDecompress(t *testing.T) {
+	const doc = `{"first_name": "firstname"}`
+	encoders := map[string]func(w io.Writer) io.WriteCloser{
+		"/gzip": func(w io.Writer) io.WriteCloser { return gzip.NewWriter(w) },
+		"/deflate": func(w io.Writer) io.WriteCloser {
+			return zlib.NewWriter(w)
+		},
+		"/raw-deflate": func(w io.Writer) io.WriteCloser {
+			fw, _ := flate.NewWriter(w, flate.DefaultCompression)
+			return fw
+		},
+		"/br": func(w io.Writer) io.WriteCloser { return brotli.NewWriter(w) },
+	}
+	codings := map[string]string{"/gzip": "gzip", "/deflate": "deflate",
+		"/raw-deflate": "deflate", "/br": "br"}
+	var accepted string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		accepted = r.Header.Get("Accept-Encoding")
+		w.Header().Set("Content-Type", "application/json")
+		enc, ok := encoders[r.URL.Path]
+		if !ok {
+			if r.URL.Path == "/corrupt" {
+				w.Header().Set("Content-Encoding", "gzip")
+			}
+			w.Write([]byte(doc))
+			return
+		}
+		w.Header().Set("Content-Encoding", codings[r.URL.Path])
+		var buf bytes.Buffer
+		zw := enc(&buf)
+		zw.Write([]byte(doc))
+		zw.Close()
+		w.Write(buf.Bytes())
+	}))
+	defer srv.Close()
+
+	c := newHTTPClient()
+	get := func(path string) (*http.Response, string, error) {
+		resp, err := c.Get(srv.URL + path)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		body, err := ioutil.ReadAll(resp.Body)
+		return resp, string(body), err
+	}
+	for _, path := range []string{"/gzip", "/deflate", "/raw-deflate", "/br", "/plain"} {
+		resp, body, err := get(path)
+		require.NoError(t, err, path)
+		require.Equal(t, doc, body, path)
+		require.Empty(t, resp.Header.Get("Content-Encoding"), path)
+		require.Equal(t, "gzip, deflate, br", accepted)
+	}
+	_, _, err := get("/corrupt")
+	require.EqualError(t, err, "decode gzip body: gzip: invalid header")
+
+	// The payloads are converted once decoded.
+	var buf bytes.Buffer
+	w := &worker{client: c, sink: mockSink{&buf}}
+	require.NoError(t, w.fetchAndProcess(srv.URL+"/br"))
+	require.Contains(t, buf.String(), "<first>firstname</first>")
+}
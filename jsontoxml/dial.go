@@ -0,0 +1,122 @@
+package jsontoxml
+
+import (
+	"context"
+	"net"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// ipFamily is the address family dialed first: "tcp4", "tcp6", or empty for
+// the default happy eyeballs behavior, which races both families.
+type ipFamily string
+
+const (
+	anyFamily ipFamily = ""
+	ipv4      ipFamily = "tcp4"
+	ipv6      ipFamily = "tcp6"
+)
+
+var (
+	preferIPv4, preferIPv6 bool
+	preferIPFlags          []string
+	// ipPrefs is the dial preference of fetchClient.
+	ipPrefs ipPreference
+)
+
+func init() {
+	rootCmd.PersistentFlags().BoolVar(&preferIPv4, "prefer-ipv4", false,
+		"Dial the IPv4 addresses of the hosts first. IPv6 is only tried if IPv4 fails.")
+	rootCmd.PersistentFlags().BoolVar(&preferIPv6, "prefer-ipv6", false,
+		"Dial the IPv6 addresses of the hosts first. IPv4 is only tried if IPv6 fails.")
+	rootCmd.PersistentFlags().StringArrayVar(&preferIPFlags, "prefer-ip", nil,
+		"Address family dialed first for a host, as host=ipv4, host=ipv6 or host=any."+
+			" Overrides --prefer-ipv4 and --prefer-ipv6. Can be repeated.")
+}
+
+// ipPreference selects the address family dialed first for a host.
+type ipPreference struct {
+	def   ipFamily
+	hosts map[string]ipFamily
+}
+
+// parseIPPreference parses the --prefer-ipv4, --prefer-ipv6 and --prefer-ip
+// flags.
+func parseIPPreference(v4, v6 bool, hosts []string) (ipPreference, error) {
+	var p ipPreference
+	switch {
+	case v4 && v6:
+		return p, errors.New("--prefer-ipv4 and --prefer-ipv6 are mutually exclusive")
+	case v4:
+		p.def = ipv4
+	case v6:
+		p.def = ipv6
+	}
+	for _, h := range hosts {
+		parts := strings.SplitN(h, "=", 2)
+		if len(parts) != 2 || len(parts[0]) == 0 {
+			return p, errors.Errorf("invalid --prefer-ip %q. Expected host=ipv4|ipv6|any", h)
+		}
+		var f ipFamily
+		switch parts[1] {
+		case "ipv4":
+			f = ipv4
+		case "ipv6":
+			f = ipv6
+		case "any":
+			f = anyFamily
+		default:
+			return p, errors.Errorf("invalid --prefer-ip %q. Expected host=ipv4|ipv6|any", h)
+		}
+		if p.hosts == nil {
+			p.hosts = make(map[string]ipFamily)
+		}
+		p.hosts[strings.ToLower(parts[0])] = f
+	}
+	return p, nil
+}
+
+// family returns the family dialed first for host.
+func (p ipPreference) family(host string) ipFamily {
+	if f, ok := p.hosts[strings.ToLower(host)]; ok {
+		return f
+	}
+	return p.def
+}
+
+// enabled returns true if the preference differs from the default behavior.
+func (p ipPreference) enabled() bool {
+	return p.def != anyFamily || len(p.hosts) > 0
+}
+
+// familyDialer dials the preferred family of a host, then the other one if that
+// fails. Unlike happy eyeballs, a host with broken addresses in the preferred
+// family doesn't delay the connection.
+type familyDialer struct {
+	prefs ipPreference
+	dial  func(ctx context.Context, network, addr string) (net.Conn, error)
+}
+
+func (d *familyDialer) DialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil || network != "tcp" {
+		return d.dial(ctx, network, addr)
+	}
+	first := d.prefs.family(host)
+	if first == anyFamily {
+		return d.dial(ctx, network, addr)
+	}
+	conn, err := d.dial(ctx, string(first), addr)
+	if err == nil || ctx.Err() != nil {
+		return conn, err
+	}
+	second := ipv4
+	if first == ipv4 {
+		second = ipv6
+	}
+	if conn, err2 := d.dial(ctx, string(second), addr); err2 == nil {
+		return conn, nil
+	}
+	return nil, err
+}
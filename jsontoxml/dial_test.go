@@ -0,0 +1,58 @@
+package jsontoxml
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseIPPreference(t *testing.T) {
+	p, err := parseIPPreference(true, false, []string{"Broken.example.com=ipv6", "b=any"})
+	require.NoError(t, err)
+	require.Equal(t, ipv6, p.family("broken.example.com"))
+	require.Equal(t, anyFamily, p.family("b"))
+	require.Equal(t, ipv4, p.family("c"))
+
+	p, err = parseIPPreference(false, false, nil)
+	require.NoError(t, err)
+	require.False(t, p.enabled())
+
+	_, err = parseIPPreference(true, true, nil)
+	require.Error(t, err)
+	for _, f := range []string{"a", "=ipv4", "a=ipv5"} {
+		_, err := parseIPPreference(false, false, []string{f})
+		require.Error(t, err, f)
+	}
+}
+
+func TestFamilyDialer(t *testing.T) {
+	var dialed []string
+	d := &familyDialer{
+		prefs: ipPreference{def: ipv4, hosts: map[string]ipFamily{"v6": ipv6, "any": anyFamily}},
+		dial: func(ctx context.Context, network, addr string) (net.Conn, error) {
+			dialed = append(dialed, network+" "+addr)
+			if network == "tcp6" {
+				return nil, errors.New("unreachable")
+			}
+			return nil, nil
+		},
+	}
+	dial := func(addr string) error {
+		_, err := d.DialContext(context.Background(), "tcp", addr)
+		return err
+	}
+	require.NoError(t, dial("a:80"))
+	require.NoError(t, dial("any:80"))
+	// The other family is tried if the preferred one fails, and the error of the
+	// preferred one is returned if both fail.
+	require.NoError(t, dial("v6:443"))
+	require.Equal(t, []string{"tcp4 a:80", "tcp any:80", "tcp6 v6:443", "tcp4 v6:443"}, dialed)
+
+	d.dial = func(ctx context.Context, network, addr string) (net.Conn, error) {
+		return nil, errors.New(network)
+	}
+	require.EqualError(t, dial("v6:443"), "tcp6")
+}
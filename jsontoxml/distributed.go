@@ -0,0 +1,366 @@
+package jsontoxml
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+	"golang.org/x/sync/errgroup"
+)
+
+// The coordinator hands out urls to remote workers over a small JSON/HTTP
+// protocol:
+//
+//	POST /lease     -> leaseResponse. Leases the next pending task.
+//	POST /complete  <- completeRequest. Reports the result of a leased task.
+//
+// A lease that isn't completed within the lease timeout is handed out again, so
+// a worker that dies mid-task doesn't lose the url.
+
+var (
+	coordinatorCmd = &cobra.Command{
+		Use:   "coordinator",
+		Short: "Distribute the urls to remote workers",
+		Long: `coordinator serves the url list to remote workers started with the worker` +
+			` subcommand. Each worker fetches and converts urls locally and reports back.`,
+		Run: func(cmd *cobra.Command, args []string) {
+			runCoordinator()
+		},
+	}
+	workerCmd = &cobra.Command{
+		Use:   "worker",
+		Short: "Fetch and convert urls handed out by a coordinator",
+		Run: func(cmd *cobra.Command, args []string) {
+			runWorker()
+		},
+	}
+	listenAddr, coordinatorAddr string
+	leaseTimeout                time.Duration
+	workerConcurrency           int
+	retryState                  string
+)
+
+func init() {
+	coordinatorCmd.Flags().StringVar(&listenAddr, "listen", ":8080",
+		"Address the coordinator listens on.")
+	coordinatorCmd.Flags().DurationVar(&leaseTimeout, "lease-timeout", time.Minute,
+		"Time after which a task leased by an unresponsive worker is handed out again.")
+	coordinatorCmd.Flags().StringVar(&retryState, "retry-state", "",
+		"File in which the retry queue is persisted, so that a restarted coordinator"+
+			" resumes the retry schedule.")
+	workerCmd.Flags().StringVar(&coordinatorAddr, "coordinator", "http://localhost:8080",
+		"Base URL of the coordinator.")
+	workerCmd.Flags().IntVar(&workerConcurrency, "concurrency", 4,
+		"Number of urls processed concurrently by this worker.")
+	rootCmd.AddCommand(coordinatorCmd, workerCmd)
+}
+
+// task is a single unit of work. ID is the position of the url in the url list
+// and is used to name the output file.
+type task struct {
+	ID  int    `json:"id"`
+	URL string `json:"url"`
+	// Attempt is 1 for the first attempt and is incremented on every retry.
+	Attempt int `json:"attempt"`
+}
+
+type leaseResponse struct {
+	Task *task `json:"task,omitempty"`
+	// Done is set once every task has been completed. Workers should exit.
+	Done bool `json:"done"`
+}
+
+type completeRequest struct {
+	ID    int    `json:"id"`
+	Error string `json:"error,omitempty"`
+}
+
+type lease struct {
+	task     task
+	deadline time.Time
+}
+
+// coordinator tracks the state of every task. It is safe for concurrent use.
+type coordinator struct {
+	sync.Mutex
+	pending []task
+	leased  map[int]lease
+	retries *retryQueue
+	timeout time.Duration
+	// maxRetries and backoff control how failed tasks are retried.
+	maxRetries int
+	backoff    time.Duration
+	total      int
+	failed     int
+	// finished is closed when the last task is completed.
+	finished chan struct{}
+}
+
+// newCoordinator returns a coordinator for the tasks. Tasks that are already
+// waiting in the retry queue (from a previous run) keep their schedule; the
+// retries of the urls that are no longer listed are dropped.
+func newCoordinator(tasks []task, timeout time.Duration, retries *retryQueue) *coordinator {
+	if retries == nil {
+		retries = &retryQueue{}
+	}
+	rest, err := retries.restore(tasks)
+	if err != nil {
+		log.Printf("Failed persisting retry queue: %s", err)
+	}
+	c := &coordinator{
+		leased:   make(map[int]lease),
+		retries:  retries,
+		timeout:  timeout,
+		total:    len(rest) + retries.len(),
+		finished: make(chan struct{}),
+	}
+	for _, t := range rest {
+		if t.Attempt == 0 {
+			t.Attempt = 1
+		}
+		c.pending = append(c.pending, t)
+	}
+	if c.idle() {
+		close(c.finished)
+	}
+	return c
+}
+
+// idle returns true if there is no task left to process. Must be called with
+// the lock held.
+func (c *coordinator) idle() bool {
+	return len(c.pending) == 0 && len(c.leased) == 0 && c.retries.len() == 0
+}
+
+// lease returns the next task to process. It returns nil if there is nothing to
+// hand out right now. done is true once all the tasks have been completed.
+func (c *coordinator) lease(now time.Time) (t *task, done bool) {
+	c.Lock()
+	defer c.Unlock()
+	// Requeue the tasks of workers that went away.
+	for id, l := range c.leased {
+		if now.After(l.deadline) {
+			delete(c.leased, id)
+			c.pending = append(c.pending, l.task)
+		}
+	}
+	due, err := c.retries.popDue(now)
+	if err != nil {
+		log.Printf("Failed persisting retry queue: %s", err)
+	}
+	for _, r := range due {
+		c.pending = append(c.pending, r.Task)
+	}
+	if len(c.pending) == 0 {
+		return nil, c.idle()
+	}
+	next := c.pending[0]
+	c.pending = c.pending[1:]
+	c.leased[next.ID] = lease{task: next, deadline: now.Add(c.timeout)}
+	return &next, false
+}
+
+// complete marks the task as completed. Completions for unknown tasks (for
+// instance, a task that was already requeued and completed by another worker)
+// are ignored.
+func (c *coordinator) complete(req completeRequest) {
+	c.Lock()
+	defer c.Unlock()
+	l, ok := c.leased[req.ID]
+	if !ok {
+		return
+	}
+	delete(c.leased, req.ID)
+	tl := logger{}.with("url", l.task.URL).with("attempt", l.task.Attempt)
+	switch {
+	case len(req.Error) > 0 && l.task.Attempt <= c.maxRetries:
+		next := l.task
+		next.Attempt++
+		delay := backoff(c.backoff, l.task.Attempt)
+		tl.Printf("Failed processing err: %s. Retrying in %s", req.Error, delay)
+		r := retry{Task: next, NextAttempt: time.Now().Add(delay)}
+		if err := c.retries.push(r); err != nil {
+			log.Printf("Failed persisting retry queue: %s", err)
+		}
+	case len(req.Error) > 0:
+		c.failed++
+		tl.Printf("Failed processing err: %s", req.Error)
+	default:
+		tl.Printf("Finished processing")
+	}
+	if c.idle() {
+		close(c.finished)
+	}
+}
+
+func (c *coordinator) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	switch r.URL.Path {
+	case "/lease":
+		t, done := c.lease(time.Now())
+		writeJSON(w, leaseResponse{Task: t, Done: done})
+	case "/complete":
+		var req completeRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		c.complete(req)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Printf("Failed writing response: %s", err)
+	}
+}
+
+func runCoordinator() {
+	if len(strings.TrimSpace(urls)) == 0 && len(urlFile) == 0 {
+		log.Fatal("--urls flag cannot be empty.")
+	}
+	sh, err := parseShard(shardSpec)
+	if err != nil {
+		log.Fatal(err)
+	}
+	list, err := urlList()
+	if err != nil {
+		log.Fatal(err)
+	}
+	var tasks []task
+	for i, u := range list {
+		if sh.owns(primaryURL(u)) {
+			tasks = append(tasks, task{ID: i, URL: u})
+		}
+	}
+
+	retries, err := loadRetryQueue(retryState)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	start := time.Now()
+	c := newCoordinator(tasks, leaseTimeout, retries)
+	if retries.len() > 0 {
+		log.Printf("Resuming %d urls pending retry from %q", retries.len(), retryState)
+	}
+	c.maxRetries, c.backoff = maxRetries, retryBackoff
+	srv := &http.Server{Addr: listenAddr, Handler: c}
+	go func() {
+		<-c.finished
+		// Keep answering for a bit so that idle workers learn that we're done.
+		time.Sleep(2 * time.Second)
+		if err := srv.Shutdown(context.Background()); err != nil {
+			log.Printf("Failed shutting down coordinator: %s", err)
+		}
+	}()
+	log.Printf("Coordinator listening on %s with %d urls", listenAddr, len(tasks))
+	if err := srv.ListenAndServe(); err != http.ErrServerClosed {
+		log.Fatal(err)
+	}
+	log.Printf("Processed %d urls (%d failed) in %s", len(tasks), c.failed, time.Since(start))
+}
+
+// coordinatorClient talks to a coordinator on behalf of a worker.
+type coordinatorClient struct {
+	addr   string
+	client *http.Client
+}
+
+func (cc *coordinatorClient) post(path string, in, out interface{}) error {
+	var body bytes.Buffer
+	if in != nil {
+		if err := json.NewEncoder(&body).Encode(in); err != nil {
+			return errors.Wrap(err, "encode")
+		}
+	}
+	resp, err := cc.client.Post(strings.TrimSuffix(cc.addr, "/")+path, "application/json", &body)
+	if err != nil {
+		return errors.Wrap(err, "post")
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return errors.Errorf("coordinator returned %s", resp.Status)
+	}
+	if out == nil {
+		return nil
+	}
+	return errors.Wrap(json.NewDecoder(resp.Body).Decode(out), "decode")
+}
+
+// work leases tasks from the coordinator until it reports that it is done.
+func (cc *coordinatorClient) work(process func(t task) error) error {
+	for {
+		var lr leaseResponse
+		if err := cc.post("/lease", nil, &lr); err != nil {
+			return errors.Wrap(err, "lease")
+		}
+		if lr.Done {
+			return nil
+		}
+		if lr.Task == nil {
+			// Everything is leased out. Wait in case some lease expires.
+			time.Sleep(time.Second)
+			continue
+		}
+		req := completeRequest{ID: lr.Task.ID}
+		if err := process(*lr.Task); err != nil {
+			req.Error = err.Error()
+		}
+		if err := cc.post("/complete", req, nil); err != nil {
+			return errors.Wrap(err, "complete")
+		}
+	}
+}
+
+func runWorker() {
+	if len(strings.TrimSpace(output)) == 0 {
+		log.Fatal("--output flag cannot be empty.")
+	}
+	if workerConcurrency < 1 {
+		log.Fatal("--concurrency must be at least 1.")
+	}
+	if err := supportsOverwritePolicy(overwritePolicy, newSink); err != nil {
+		log.Fatal(err)
+	}
+	checkAndCreateDir()
+
+	cc := &coordinatorClient{
+		addr:   coordinatorAddr,
+		client: &http.Client{Timeout: 10 * time.Second},
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	guard := newMemoryGuard(memoryLimit, fetchClient.CloseIdleConnections)
+	go guard.run(ctx, recycleEvery)
+	log.Printf("Worker started. Coordinator: %q", coordinatorAddr)
+	var eg errgroup.Group
+	for i := 0; i < workerConcurrency; i++ {
+		l := logger{}.with("worker", i)
+		eg.Go(func() error {
+			return cc.work(func(t task) error {
+				name := namer.Name(Metadata{Index: t.ID, URL: primaryURL(t.URL)})
+				tl := l.with("url", t.URL).with("attempt", t.Attempt)
+				return processURL(withLogger(context.Background(), tl), t.URL, name)
+			})
+		})
+	}
+	if err := eg.Wait(); err != nil {
+		log.Fatal(err)
+	}
+	log.Printf("Worker finished")
+}
@@ -0,0 +1,63 @@
+package jsontoxml
+
+import (
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCoordinatorLease(t *testing.T) {
+	now := time.Now()
+	c := newCoordinator([]task{{ID: 0, URL: "a"}, {ID: 1, URL: "b"}}, time.Minute, nil)
+
+	t1, done := c.lease(now)
+	require.False(t, done)
+	require.Equal(t, "a", t1.URL)
+	t2, _ := c.lease(now)
+	require.Equal(t, "b", t2.URL)
+
+	// Everything is leased, but nothing is complete yet.
+	t3, done := c.lease(now)
+	require.Nil(t, t3)
+	require.False(t, done)
+
+	// The lease on "a" expires and it is handed out again.
+	c.complete(completeRequest{ID: t2.ID})
+	t4, _ := c.lease(now.Add(2 * time.Minute))
+	require.Equal(t, "a", t4.URL)
+
+	c.complete(completeRequest{ID: t4.ID, Error: "failed"})
+	_, done = c.lease(now)
+	require.True(t, done)
+	require.Equal(t, 1, c.failed)
+	<-c.finished
+}
+
+func TestCoordinatorWorker(t *testing.T) {
+	var tasks []task
+	for i := 0; i < 10; i++ {
+		tasks = append(tasks, task{ID: i, URL: "valid"})
+	}
+	c := newCoordinator(tasks, time.Minute, nil)
+	srv := httptest.NewServer(c)
+	defer srv.Close()
+
+	var mu sync.Mutex
+	seen := make(map[int]bool)
+	cc := &coordinatorClient{addr: srv.URL, client: srv.Client()}
+	require.NoError(t, cc.work(func(t task) error {
+		mu.Lock()
+		defer mu.Unlock()
+		seen[t.ID] = true
+		if t.ID == 3 {
+			return errors.New("boom")
+		}
+		return nil
+	}))
+	require.Len(t, seen, 10)
+	require.Equal(t, 1, c.failed)
+}
@@ -0,0 +1,130 @@
+package jsontoxml
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// entity is a general entity declared in the internal DTD subset.
+type entity struct {
+	name  string
+	value string
+}
+
+// entityOptions declare custom entities in a DOCTYPE before the root element and
+// replace {{name}} placeholders in the text of the output with &name;
+// references.
+type entityOptions struct {
+	entities []entity
+	// files are external entity files included with a parameter entity.
+	files []string
+	// refs are the names of entities declared in files that may be
+	// substituted.
+	refs []string
+}
+
+var (
+	entityDecls, entityFiles, entityRefs []string
+	xmlNameExpr                          = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_.-]*$`)
+	placeholderExpr                      = regexp.MustCompile(`\{\{([A-Za-z_][A-Za-z0-9_.-]*)\}\}`)
+)
+
+func init() {
+	rootCmd.PersistentFlags().StringArrayVar(&entityDecls, "entity", nil,
+		"Declare an entity, as name=value, in the DOCTYPE of every output. {{name}} in the"+
+			" data is replaced with &name;. Can be repeated.")
+	rootCmd.PersistentFlags().StringArrayVar(&entityFiles, "entity-file", nil,
+		"External entity file to include in the DOCTYPE of every output. Can be repeated.")
+	rootCmd.PersistentFlags().StringArrayVar(&entityRefs, "entity-ref", nil,
+		"Name of an entity declared in an --entity-file. {{name}} in the data is replaced"+
+			" with &name;. Can be repeated.")
+}
+
+// newEntityOptions builds the entity options from the flags.
+func newEntityOptions(decls, files, refs []string) (entityOptions, error) {
+	eo := entityOptions{files: files}
+	for _, d := range decls {
+		parts := strings.SplitN(d, "=", 2)
+		if len(parts) != 2 {
+			return eo, errors.Errorf("invalid --entity %q. Expected name=value", d)
+		}
+		eo.entities = append(eo.entities, entity{name: parts[0], value: parts[1]})
+	}
+	for _, e := range eo.entities {
+		if !xmlNameExpr.MatchString(e.name) {
+			return eo, errors.Errorf("invalid entity name %q", e.name)
+		}
+	}
+	for _, r := range refs {
+		if !xmlNameExpr.MatchString(r) {
+			return eo, errors.Errorf("invalid entity name %q", r)
+		}
+	}
+	for _, f := range files {
+		if strings.Contains(f, `"`) && strings.Contains(f, "'") {
+			return eo, errors.Errorf("invalid --entity-file %q. A path can't hold both quotes", f)
+		}
+	}
+	eo.refs = refs
+	return eo, nil
+}
+
+func (eo entityOptions) enabled() bool {
+	return len(eo.entities) > 0 || len(eo.files) > 0
+}
+
+// doctype returns the DOCTYPE declaring the entities for the root element.
+func (eo entityOptions) doctype(root string) string {
+	if !eo.enabled() {
+		return ""
+	}
+	var b strings.Builder
+	fmt.Fprintf(&b, "<!DOCTYPE %s [\n", root)
+	for i, f := range eo.files {
+		fmt.Fprintf(&b, "<!ENTITY %% ext%d SYSTEM %s>\n%%ext%d;\n", i, systemLiteral(f), i)
+	}
+	// The character references of an entity value are expanded when the
+	// entity is declared and the result is parsed again where the entity is
+	// referenced, so & and < are escaped twice to stay text.
+	r := strings.NewReplacer(`"`, "&#34;", "%", "&#37;", "&", "&#38;#38;", "<", "&#38;#60;")
+	for _, e := range eo.entities {
+		fmt.Fprintf(&b, "<!ENTITY %s \"%s\">\n", e.name, r.Replace(e.value))
+	}
+	b.WriteString("]>\n")
+	return b.String()
+}
+
+// systemLiteral quotes the path of an external entity. System literals have
+// no escapes: they are quoted with the quote the path doesn't hold.
+func systemLiteral(path string) string {
+	if strings.Contains(path, `"`) {
+		return "'" + path + "'"
+	}
+	return `"` + path + `"`
+}
+
+// substitute replaces the placeholders of the known entities in data with
+// entity references. Placeholders of unknown entities are left untouched.
+func (eo entityOptions) substitute(data []byte) []byte {
+	if !eo.enabled() {
+		return data
+	}
+	known := make(map[string]bool)
+	for _, e := range eo.entities {
+		known[e.name] = true
+	}
+	for _, r := range eo.refs {
+		known[r] = true
+	}
+	return placeholderExpr.ReplaceAllFunc(data, func(m []byte) []byte {
+		name := m[2 : len(m)-2]
+		if !known[string(name)] {
+			return m
+		}
+		return bytes.Join([][]byte{[]byte("&"), name, []byte(";")}, nil)
+	})
+}
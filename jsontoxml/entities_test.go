@@ -0,0 +1,47 @@
+package jsontoxml
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestEntities(t *testing.T) {
+	eo, err := newEntityOptions([]string{`company=ACME "Inc" 100% & <Co>`},
+		[]string{"shared.ent", `my "shared".ent`},
+		[]string{"copy"})
+	require.NoError(t, err)
+	opts := &convertOptions{entities: eo}
+	jdata := []byte(`{"id": 1, "first_name": "{{company}}", "last_name": "{{copy}} {{unknown}} <b>"}`)
+	buf := &bytes.Buffer{}
+	require.NoError(t, jsonToXml(jdata, buf, opts))
+	res := `<!DOCTYPE jsonData [
+<!ENTITY % ext0 SYSTEM "shared.ent">
+%ext0;
+<!ENTITY % ext1 SYSTEM 'my "shared".ent'>
+%ext1;
+<!ENTITY company "ACME &#34;Inc&#34; 100&#37; &#38;#38; &#38;#60;Co>">
+]>
+ <jsonData>
+  <Id>1</Id>
+  <name>
+   <first>&company;</first>
+   <last>&copy; {{unknown}} &lt;b&gt;</last>
+  </name>
+  <City></City>
+  <State></State>
+ </jsonData>`
+	require.Equal(t, res, buf.String())
+}
+
+func TestInvalidEntities(t *testing.T) {
+	_, err := newEntityOptions([]string{"novalue"}, nil, nil)
+	require.Error(t, err)
+	_, err = newEntityOptions([]string{"1bad=x"}, nil, nil)
+	require.Error(t, err)
+	_, err = newEntityOptions(nil, nil, []string{"bad name"})
+	require.Error(t, err)
+	_, err = newEntityOptions(nil, []string{`a"b'c.ent`}, nil)
+	require.Error(t, err)
+}
@@ -0,0 +1,136 @@
+package jsontoxml
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+var fragments bool
+
+func init() {
+	rootCmd.PersistentFlags().BoolVar(&fragments, "fragments", false,
+		"Write every record of a json array as a standalone xml document, named after the"+
+			" document of the url with the index of the record, e.g. 0-3.xml, instead of"+
+			" wrapping the records in a shared root.")
+}
+
+// fragmentSink writes every record of a document as a separate document of
+// sink, named by fragmentName. The records are committed as they are
+// converted: aborting the document discards the record being written only.
+type fragmentSink struct {
+	sink Sink
+	name string
+	// n is the number of records started.
+	n    int
+	open bool
+}
+
+// fragmentName returns the name of the i-th record of the document name.
+func fragmentName(name string, i int) string {
+	ext := path.Ext(name)
+	return strings.TrimSuffix(name, ext) + "-" + strconv.Itoa(i) + ext
+}
+
+func (s *fragmentSink) Open(name string) error {
+	s.name, s.n = name, 0
+	return nil
+}
+
+// next commits the record being written, if any, and starts the next one.
+func (s *fragmentSink) next() error {
+	if err := s.Commit(); err != nil {
+		return err
+	}
+	if err := s.sink.Open(fragmentName(s.name, s.n)); err != nil {
+		return err
+	}
+	s.n++
+	s.open = true
+	return nil
+}
+
+// Exists returns true if the first record of the document name exists.
+func (s *fragmentSink) Exists(name string) bool {
+	es, ok := s.sink.(ExistingSink)
+	return ok && es.Exists(fragmentName(name, 0))
+}
+
+func (s *fragmentSink) Write(p []byte) (int, error) {
+	if !s.open {
+		return 0, errors.New("write outside of a record")
+	}
+	return s.sink.Write(p)
+}
+
+func (s *fragmentSink) Commit() error {
+	if !s.open {
+		return nil
+	}
+	s.open = false
+	return s.sink.Commit()
+}
+
+func (s *fragmentSink) Abort() error {
+	if !s.open {
+		return nil
+	}
+	s.open = false
+	return s.sink.Abort()
+}
+
+// convertFragments converts every entry of the json array read from r as a
+// separate document written to s, as if it was the whole payload. A document
+// that isn't an array is written as a single record.
+func convertFragments(r io.Reader, s *fragmentSink, opts *convertOptions) error {
+	if opts == nil {
+		opts = &convertOptions{}
+	}
+	br := bufio.NewReader(r)
+	array, err := peekArray(br)
+	if err != nil {
+		return errors.Wrap(err, "read")
+	}
+	if !array {
+		data, err := ioutil.ReadAll(br)
+		if err != nil {
+			return errors.Wrap(err, "read")
+		}
+		if err := s.next(); err != nil {
+			return err
+		}
+		opts.stats.addInput(len(data))
+		return jsonToXml(data, s, opts)
+	}
+	dec := json.NewDecoder(br)
+	if _, err := dec.Token(); err != nil {
+		return errors.Wrap(err, "json.Unmarshal")
+	}
+	for i := 0; dec.More(); i++ {
+		var e json.RawMessage
+		if err := dec.Decode(&e); err != nil {
+			return errors.Wrap(err, "json.Unmarshal")
+		}
+		if err := s.next(); err != nil {
+			return err
+		}
+		opts.stats.addInput(len(e))
+		if err := jsonToXml(e, s, opts); err != nil {
+			return errors.Wrapf(err, "entry %d", i)
+		}
+	}
+	if _, err := dec.Token(); err != nil {
+		return errors.Wrap(err, "json.Unmarshal")
+	}
+	// Only whitespace may follow the array.
+	if _, err := dec.Token(); err != io.EOF {
+		return errors.New("json.Unmarshal: invalid data after top-level value")
+	}
+	return nil
+}
@@ -0,0 +1,48 @@
+package jsontoxml
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestFragments(t *testing.T) {
+	payloads := map[string]string{
+		"/array":  `[{"id": 1}, {"id": 2}]`,
+		"/object": `{"id": 3}`,
+		"/broken": `[{"id": 4}, {"id": "five"}, {"id": 6}]`,
+		"/empty":  ` [] `,
+	}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(payloads[r.URL.Path]))
+	}))
+	defer srv.Close()
+
+	var sinks memSinks
+	r := &Runner{Workers: 1, Fragments: true, NewSink: sinks.newSink}
+	r.Add(&urlSource{client: srv.Client(), urls: []string{
+		srv.URL + "/array", srv.URL + "/object", srv.URL + "/broken", srv.URL + "/empty",
+	}})
+	rep, err := r.Run(context.Background())
+	require.NoError(t, err)
+	require.Equal(t, 1, rep.Failed)
+	require.Contains(t, rep.Errors[srv.URL+"/broken"].Error(), "entry 1")
+
+	var names []string
+	for name, doc := range sinks.docs {
+		names = append(names, name)
+		// Every record is a standalone document.
+		require.Equal(t, 1, strings.Count(doc, "<jsonData>"), doc)
+	}
+	sort.Strings(names)
+	// The records converted before the failure are kept.
+	require.Equal(t, []string{"0-0.xml", "0-1.xml", "1-0.xml", "2-0.xml"}, names)
+	require.Contains(t, sinks.docs["0-1.xml"], "<Id>2</Id>")
+	require.Contains(t, sinks.docs["1-0.xml"], "<Id>3</Id>")
+}
@@ -0,0 +1,74 @@
+package jsontoxml
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+	"unicode/utf8"
+)
+
+// addSeeds adds the sample data to the seed corpus of f.
+func addSeeds(f *testing.F) {
+	// The sample data is at the root of the repository.
+	files, err := filepath.Glob(filepath.Join("..", "sample-data", "*.json"))
+	if err != nil {
+		f.Fatal(err)
+	}
+	if len(files) == 0 {
+		f.Fatal("no sample data in ../sample-data")
+	}
+	for _, file := range files {
+		data, err := ioutil.ReadFile(file)
+		if err != nil {
+			f.Fatal(err)
+		}
+		f.Add(data)
+	}
+	f.Add([]byte(`{"id": 1, "first_name": "a", "tags": [1, "b", null, true, {}]}`))
+	f.Add([]byte(`[{"1st key": "<&>"}, -0.5e10]`))
+	f.Add([]byte(`{"a\u0001": "\u0000\r\ufffe", "b": ["\u001f"]}`))
+}
+
+func FuzzConvert(f *testing.F) {
+	addSeeds(f)
+	f.Fuzz(func(t *testing.T, data []byte) {
+		out, err := Convert(data)
+		if err != nil {
+			return
+		}
+		if !utf8.Valid(out) {
+			t.Fatalf("invalid utf8 output %q", out)
+		}
+	})
+}
+
+// FuzzLosslessRoundTrip checks that xml generated with --lossless converts
+// back to the same json.
+func FuzzLosslessRoundTrip(f *testing.F) {
+	addSeeds(f)
+	f.Fuzz(func(t *testing.T, data []byte) {
+		var want interface{}
+		if err := json.Unmarshal(data, &want); err != nil {
+			return
+		}
+		out, err := convert(data, &convertOptions{lossless: true})
+		if err != nil {
+			t.Fatalf("convert %q: %s", data, err)
+		}
+		var back bytes.Buffer
+		if err := xmlToJson(bytes.NewReader(out), &back); err != nil {
+			t.Fatalf("reverse %q: %s", out, err)
+		}
+		var got interface{}
+		if err := json.Unmarshal(back.Bytes(), &got); err != nil {
+			t.Fatalf("invalid json %q: %s", back.Bytes(), err)
+		}
+		wantJSON, _ := json.Marshal(want)
+		gotJSON, _ := json.Marshal(got)
+		if !bytes.Equal(wantJSON, gotJSON) {
+			t.Fatalf("round trip mismatch: %s != %s", wantJSON, gotJSON)
+		}
+	})
+}
@@ -0,0 +1,228 @@
+package jsontoxml
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// gcsScope is the OAuth2 scope of the tokens used to write to Cloud Storage.
+const gcsScope = "https://www.googleapis.com/auth/devstorage.read_write"
+
+// gcpTokenSource returns the access tokens of the Google application default
+// credentials and caches them until shortly before they expire. It is safe for
+// concurrent use.
+type gcpTokenSource struct {
+	// fetch returns a new token and its lifetime.
+	fetch func() (string, time.Duration, error)
+	now   func() time.Time
+
+	mu      sync.Mutex
+	token   string
+	expires time.Time
+}
+
+// get returns a valid token, fetching a new one if needed.
+func (s *gcpTokenSource) get() (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.token) > 0 && s.now().Before(s.expires) {
+		return s.token, nil
+	}
+	token, lifetime, err := s.fetch()
+	if err != nil {
+		return "", errors.Wrap(err, "google credentials")
+	}
+	s.token, s.expires = token, s.now().Add(lifetime-tokenExpiryMargin)
+	defaultRedactor.addValues(token)
+	return token, nil
+}
+
+// gcpCredentialsFile is a service account key or the credentials of a user
+// stored by gcloud auth application-default login.
+type gcpCredentialsFile struct {
+	Type         string `json:"type"`
+	ClientEmail  string `json:"client_email"`
+	PrivateKey   string `json:"private_key"`
+	TokenURI     string `json:"token_uri"`
+	ClientID     string `json:"client_id"`
+	ClientSecret string `json:"client_secret"`
+	RefreshToken string `json:"refresh_token"`
+}
+
+// newGCPTokenSource looks up the application default credentials: an access
+// token in GOOGLE_OAUTH_ACCESS_TOKEN, the credentials file of
+// GOOGLE_APPLICATION_CREDENTIALS or of gcloud, and then the service account of
+// the metadata server.
+func newGCPTokenSource(client *http.Client, scope string) (*gcpTokenSource, error) {
+	s := &gcpTokenSource{now: time.Now}
+	if token := os.Getenv("GOOGLE_OAUTH_ACCESS_TOKEN"); len(token) > 0 {
+		// The lifetime of the token isn't known: it is used until the end.
+		s.fetch = func() (string, time.Duration, error) {
+			return token, 100 * 365 * 24 * time.Hour, nil
+		}
+		return s, nil
+	}
+	path := os.Getenv("GOOGLE_APPLICATION_CREDENTIALS")
+	if len(path) == 0 {
+		if dir, err := os.UserConfigDir(); err == nil {
+			path = filepath.Join(dir, "gcloud", "application_default_credentials.json")
+			if _, err := os.Stat(path); err != nil {
+				path = ""
+			}
+		}
+	}
+	if len(path) == 0 {
+		host := os.Getenv("GCE_METADATA_HOST")
+		if len(host) == 0 {
+			host = "metadata.google.internal"
+		}
+		s.fetch = func() (string, time.Duration, error) {
+			return fetchMetadataToken(client, "http://"+host)
+		}
+		return s, nil
+	}
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, errors.Wrap(err, "google credentials")
+	}
+	var f gcpCredentialsFile
+	if err := json.Unmarshal(data, &f); err != nil {
+		return nil, errors.Wrapf(err, "google credentials %s", path)
+	}
+	if len(f.TokenURI) == 0 {
+		f.TokenURI = "https://oauth2.googleapis.com/token"
+	}
+	switch f.Type {
+	case "service_account":
+		key, err := parseRSAPrivateKey(f.PrivateKey)
+		if err != nil {
+			return nil, errors.Wrapf(err, "google credentials %s", path)
+		}
+		s.fetch = func() (string, time.Duration, error) {
+			assertion, err := signJWT(key, map[string]interface{}{
+				"iss":   f.ClientEmail,
+				"scope": scope,
+				"aud":   f.TokenURI,
+				"iat":   s.now().Unix(),
+				"exp":   s.now().Add(time.Hour).Unix(),
+			})
+			if err != nil {
+				return "", 0, err
+			}
+			return fetchGCPToken(client, f.TokenURI, url.Values{
+				"grant_type": {"urn:ietf:params:oauth:grant-type:jwt-bearer"},
+				"assertion":  {assertion},
+			})
+		}
+	case "authorized_user":
+		s.fetch = func() (string, time.Duration, error) {
+			return fetchGCPToken(client, f.TokenURI, url.Values{
+				"grant_type":    {"refresh_token"},
+				"client_id":     {f.ClientID},
+				"client_secret": {f.ClientSecret},
+				"refresh_token": {f.RefreshToken},
+			})
+		}
+	default:
+		return nil, errors.Errorf("google credentials %s: unsupported type %q. Expected"+
+			" service_account or authorized_user", path, f.Type)
+	}
+	return s, nil
+}
+
+// gcpToken is the response of the token endpoints.
+type gcpToken struct {
+	AccessToken string `json:"access_token"`
+	ExpiresIn   int64  `json:"expires_in"`
+}
+
+func (t gcpToken) token() (string, time.Duration, error) {
+	if len(t.AccessToken) == 0 {
+		return "", 0, errors.New("no access_token in the response")
+	}
+	return t.AccessToken, time.Duration(t.ExpiresIn) * time.Second, nil
+}
+
+// fetchGCPToken exchanges the grant of form for an access token.
+func fetchGCPToken(client *http.Client, tokenURI string, form url.Values) (string,
+	time.Duration, error) {
+	req, err := http.NewRequest(http.MethodPost, tokenURI, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", 0, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	var t gcpToken
+	if err := doJSONWith(client, req, &t); err != nil {
+		return "", 0, err
+	}
+	return t.token()
+}
+
+// fetchMetadataToken fetches a token of the default service account of the
+// instance from the metadata server.
+func fetchMetadataToken(client *http.Client, base string) (string, time.Duration, error) {
+	req, err := http.NewRequest(http.MethodGet,
+		base+"/computeMetadata/v1/instance/service-accounts/default/token", nil)
+	if err != nil {
+		return "", 0, err
+	}
+	req.Header.Set("Metadata-Flavor", "Google")
+	var t gcpToken
+	if err := doJSONWith(client, req, &t); err != nil {
+		return "", 0, errors.Wrap(err, "metadata server")
+	}
+	return t.token()
+}
+
+// parseRSAPrivateKey parses the PEM encoded PKCS #8 (or PKCS #1) RSA key.
+func parseRSAPrivateKey(s string) (*rsa.PrivateKey, error) {
+	block, _ := pem.Decode([]byte(s))
+	if block == nil {
+		return nil, errors.New("invalid private_key: no PEM block")
+	}
+	if key, err := x509.ParsePKCS1PrivateKey(block.Bytes); err == nil {
+		return key, nil
+	}
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, errors.Wrap(err, "invalid private_key")
+	}
+	rsaKey, ok := key.(*rsa.PrivateKey)
+	if !ok {
+		return nil, errors.New("invalid private_key: not an RSA key")
+	}
+	return rsaKey, nil
+}
+
+// signJWT returns the JWT of the claims, signed with RS256.
+func signJWT(key *rsa.PrivateKey, claims map[string]interface{}) (string, error) {
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		return "", err
+	}
+	enc := base64.RawURLEncoding
+	unsigned := enc.EncodeToString([]byte(`{"alg":"RS256","typ":"JWT"}`)) + "." +
+		enc.EncodeToString(payload)
+	sum := sha256.Sum256([]byte(unsigned))
+	sig, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, sum[:])
+	if err != nil {
+		return "", errors.Wrap(err, "sign jwt")
+	}
+	return unsigned + "." + enc.EncodeToString(sig), nil
+}
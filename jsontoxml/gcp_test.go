@@ -0,0 +1,133 @@
+package jsontoxml
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestGCPServiceAccount(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	require.NoError(t, err)
+
+	var fetches int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.NoError(t, r.ParseForm())
+		require.Equal(t, "urn:ietf:params:oauth:grant-type:jwt-bearer", r.Form.Get("grant_type"))
+		parts := strings.Split(r.Form.Get("assertion"), ".")
+		require.Len(t, parts, 3)
+		sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+		require.NoError(t, err)
+		sum := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+		require.NoError(t, rsa.VerifyPKCS1v15(&key.PublicKey, crypto.SHA256, sum[:], sig))
+		payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+		require.NoError(t, err)
+		var claims map[string]interface{}
+		require.NoError(t, json.Unmarshal(payload, &claims))
+		require.Equal(t, "sa@project.iam.gserviceaccount.com", claims["iss"])
+		require.Equal(t, gcsScope, claims["scope"])
+		n := atomic.AddInt32(&fetches, 1)
+		fmt.Fprintf(w, `{"access_token": "token%d", "expires_in": 3600}`, n)
+	}))
+	defer srv.Close()
+
+	path := filepath.Join(t.TempDir(), "sa.json")
+	data, err := json.Marshal(map[string]string{
+		"type":         "service_account",
+		"client_email": "sa@project.iam.gserviceaccount.com",
+		"private_key":  string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})),
+		"token_uri":    srv.URL,
+	})
+	require.NoError(t, err)
+	require.NoError(t, ioutil.WriteFile(path, data, 0600))
+	t.Setenv("GOOGLE_OAUTH_ACCESS_TOKEN", "")
+	t.Setenv("GOOGLE_APPLICATION_CREDENTIALS", path)
+
+	s, err := newGCPTokenSource(srv.Client(), gcsScope)
+	require.NoError(t, err)
+	now := time.Now()
+	s.now = func() time.Time { return now }
+	for i := 0; i < 2; i++ {
+		token, err := s.get()
+		require.NoError(t, err)
+		require.Equal(t, "token1", token)
+	}
+	// The token is refreshed shortly before it expires.
+	now = now.Add(time.Hour - tokenExpiryMargin)
+	token, err := s.get()
+	require.NoError(t, err)
+	require.Equal(t, "token2", token)
+}
+
+func TestGCPTokenSources(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/token":
+			require.NoError(t, r.ParseForm())
+			require.Equal(t, "refresh_token", r.Form.Get("grant_type"))
+			require.Equal(t, "rt", r.Form.Get("refresh_token"))
+			fmt.Fprint(w, `{"access_token": "user-token", "expires_in": 3600}`)
+		case "/computeMetadata/v1/instance/service-accounts/default/token":
+			require.Equal(t, "Google", r.Header.Get("Metadata-Flavor"))
+			fmt.Fprint(w, `{"access_token": "instance-token", "expires_in": 3600}`)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+	dir := t.TempDir()
+	t.Setenv("XDG_CONFIG_HOME", dir)
+	t.Setenv("HOME", dir)
+	t.Setenv("GOOGLE_APPLICATION_CREDENTIALS", "")
+
+	t.Setenv("GOOGLE_OAUTH_ACCESS_TOKEN", "static-token")
+	s, err := newGCPTokenSource(srv.Client(), gcsScope)
+	require.NoError(t, err)
+	token, err := s.get()
+	require.NoError(t, err)
+	require.Equal(t, "static-token", token)
+	t.Setenv("GOOGLE_OAUTH_ACCESS_TOKEN", "")
+
+	// The metadata server.
+	t.Setenv("GCE_METADATA_HOST", strings.TrimPrefix(srv.URL, "http://"))
+	s, err = newGCPTokenSource(srv.Client(), gcsScope)
+	require.NoError(t, err)
+	token, err = s.get()
+	require.NoError(t, err)
+	require.Equal(t, "instance-token", token)
+
+	// The credentials of gcloud auth application-default login.
+	path := filepath.Join(dir, "user.json")
+	require.NoError(t, ioutil.WriteFile(path, []byte(`{"type": "authorized_user",
+		"client_id": "id", "client_secret": "secret", "refresh_token": "rt",
+		"token_uri": "`+srv.URL+`/token"}`), 0600))
+	t.Setenv("GOOGLE_APPLICATION_CREDENTIALS", path)
+	s, err = newGCPTokenSource(srv.Client(), gcsScope)
+	require.NoError(t, err)
+	token, err = s.get()
+	require.NoError(t, err)
+	require.Equal(t, "user-token", token)
+
+	require.NoError(t, ioutil.WriteFile(path, []byte(`{"type": "external_account"}`), 0600))
+	_, err = newGCPTokenSource(srv.Client(), gcsScope)
+	require.EqualError(t, err, "google credentials "+path+`: unsupported type "external_account".`+
+		" Expected service_account or authorized_user")
+}
@@ -0,0 +1,302 @@
+package jsontoxml
+
+import (
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"sort"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/pkg/errors"
+)
+
+// Attributes used to annotate the generic xml.
+const (
+	// typeAttr holds the json type of an element: number, bool, null, array or
+	// object. Elements without it are strings.
+	typeAttr = "type"
+	// keyAttr holds the original json key if it isn't a valid xml name.
+	keyAttr = "key"
+	// itemName is the name of the elements holding the entries of an array.
+	itemName = "item"
+	// escapedAttr marks the elements whose key attribute and string value
+	// are json string literals, because they hold characters xml can't
+	// represent, such as control characters.
+	escapedAttr = "escaped"
+)
+
+var escapedJSON = xml.Attr{Name: xml.Name{Local: escapedAttr}, Value: "json"}
+
+// genericToXml converts any json document to xml. Objects become elements with
+// one child per key (in key order), arrays become elements with one <item> per
+// entry and keys that aren't valid xml names are sanitized.
+func (o *convertOptions) genericToXml(data []byte) ([]byte, error) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	var v interface{}
+	if err := dec.Decode(&v); err != nil {
+		return nil, errors.Wrap(err, "json.Unmarshal")
+	}
+	if o.coerce {
+		v = coerceValue(v)
+	}
+	v = o.whitespace.value("", o.html.value(v))
+
+	var buf bytes.Buffer
+	enc := xml.NewEncoder(&buf)
+	enc.Indent(" ", " ")
+	r := &record{XMLName: xml.Name{Local: o.rootElementName()}}
+	if err := o.decorate(r, v); err != nil {
+		return nil, err
+	}
+	if o.xsiTypes {
+		r.Attrs = append(r.Attrs, xsiNamespaceAttrs...)
+	}
+	if err := o.encodeValue(enc, xml.StartElement{Name: r.XMLName, Attr: r.Attrs}, v,
+		r.Extra); err != nil {
+		return nil, errors.Wrap(err, "xml.Marshal")
+	}
+	if err := enc.Flush(); err != nil {
+		return nil, errors.Wrap(err, "xml.Marshal")
+	}
+	return buf.Bytes(), nil
+}
+
+// encodeValue encodes v as the element start. extra elements are appended to
+// the children of the element.
+func (o *convertOptions) encodeValue(enc *xml.Encoder, start xml.StartElement, v interface{},
+	extra []element) error {
+	start, v = o.truncate(start, v)
+	if s, ok := v.(string); ok && o.lossless && !isXMLText(s) && !hasAttr(start, escapedAttr) {
+		start = escapeElement(start)
+	}
+	if obj, ok := v.(map[string]interface{}); ok {
+		start.Attr = append(start.Attr, o.fieldAttrs(obj)...)
+	}
+	if err := enc.EncodeToken(o.annotate(start, v)); err != nil {
+		return err
+	}
+	switch val := v.(type) {
+	case map[string]interface{}:
+		keys := make([]string, 0, len(val))
+		for k := range val {
+			if !o.asAttribute(k, val[k]) {
+				keys = append(keys, k)
+			}
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			name, ok := xmlName(k)
+			child := xml.StartElement{Name: xml.Name{Local: name}}
+			if !ok {
+				child.Attr = []xml.Attr{{Name: xml.Name{Local: keyAttr}, Value: k}}
+				if o.lossless && !isXMLText(k) {
+					child = escapeElement(child)
+				}
+				o.warnRenamed(k, name)
+			}
+			if o.whitespace.preserve(k, val[k]) {
+				child.Attr = append(child.Attr, xmlSpacePreserve)
+			}
+			if err := o.encodeField(enc, k, child, val[k]); err != nil {
+				return err
+			}
+		}
+	case []interface{}:
+		for _, item := range val {
+			child := xml.StartElement{Name: xml.Name{Local: itemName}}
+			if err := o.encodeValue(enc, child, item, nil); err != nil {
+				return err
+			}
+		}
+	case nil:
+	case string:
+		if hasAttr(start, escapedAttr) {
+			val = xmlJSONQuote(val)
+		}
+		if err := enc.EncodeToken(xml.CharData(val)); err != nil {
+			return err
+		}
+	default:
+		if err := enc.EncodeToken(xml.CharData(o.formatScalar(val))); err != nil {
+			return err
+		}
+	}
+	for _, e := range extra {
+		if err := enc.EncodeElement(e, xml.StartElement{Name: e.XMLName}); err != nil {
+			return err
+		}
+	}
+	return enc.EncodeToken(start.End())
+}
+
+// isXMLChar returns true if xml can represent the character r.
+func isXMLChar(r rune) bool {
+	return r == 0x09 || r == 0x0A || r == 0x0D || r >= 0x20 && r <= 0xD7FF ||
+		r >= 0xE000 && r <= 0xFFFD || r >= 0x10000 && r <= 0x10FFFF
+}
+
+// isXMLText returns true if xml can represent every character of s.
+func isXMLText(s string) bool {
+	for _, r := range s {
+		if !isXMLChar(r) {
+			return false
+		}
+	}
+	return true
+}
+
+// xmlJSONQuote returns s as a json string literal only holding characters xml
+// can represent.
+func xmlJSONQuote(s string) string {
+	var b bytes.Buffer
+	enc := json.NewEncoder(&b)
+	enc.SetEscapeHTML(false)
+	enc.Encode(s)
+	var q strings.Builder
+	for _, r := range strings.TrimSuffix(b.String(), "\n") {
+		if isXMLChar(r) {
+			q.WriteRune(r)
+		} else {
+			fmt.Fprintf(&q, "\\u%04x", r)
+		}
+	}
+	return q.String()
+}
+
+// escapeElement quotes the key attribute of the element, if any, and marks it
+// with escapedAttr.
+func escapeElement(start xml.StartElement) xml.StartElement {
+	attrs := make([]xml.Attr, 0, len(start.Attr)+1)
+	for _, a := range start.Attr {
+		if a.Name.Local == keyAttr {
+			a.Value = xmlJSONQuote(a.Value)
+		}
+		attrs = append(attrs, a)
+	}
+	start.Attr = append(attrs, escapedJSON)
+	return start
+}
+
+func hasAttr(start xml.StartElement, name string) bool {
+	for _, a := range start.Attr {
+		if a.Name.Local == name {
+			return true
+		}
+	}
+	return false
+}
+
+// annotate adds the type attributes of the json value v to the start element.
+func (o *convertOptions) annotate(start xml.StartElement, v interface{}) xml.StartElement {
+	typ := jsonType(v)
+	if o.lossless && typ != "string" {
+		start.Attr = append(start.Attr, xml.Attr{Name: xml.Name{Local: typeAttr}, Value: typ})
+	}
+	if o.xsiTypes {
+		if attr, ok := jsonXSIType(v); ok {
+			start.Attr = append(start.Attr, attr)
+		}
+	}
+	return start
+}
+
+// formatScalar renders a json string, number or bool as xml text.
+func (o *convertOptions) formatScalar(v interface{}) string {
+	switch val := v.(type) {
+	case string:
+		return val
+	case json.Number:
+		if o.locale != nil && !o.lossless {
+			return o.locale.formatNumber(val.String())
+		}
+		return val.String()
+	case bool:
+		if val {
+			return "true"
+		}
+		return "false"
+	}
+	return ""
+}
+
+// jsonType returns the json type of a decoded value.
+func jsonType(v interface{}) string {
+	switch v.(type) {
+	case map[string]interface{}:
+		return "object"
+	case []interface{}:
+		return "array"
+	case json.Number, float64:
+		return "number"
+	case bool:
+		return "bool"
+	case nil:
+		return "null"
+	}
+	return "string"
+}
+
+// xmlName turns a json key into a valid xml element name. ok is false if the
+// key had to be changed.
+func xmlName(key string) (name string, ok bool) {
+	name = sanitizeName(key, unicode.IsLetter, unicode.IsDigit)
+	if !parsableName(name) {
+		// Some unicode letters aren't allowed in xml 1.0 names.
+		name = sanitizeName(key, isASCIILetter, isASCIIDigit)
+	}
+	return name, name == key
+}
+
+// sanitizeName replaces the characters of key that aren't letters, digits, _,
+// - or . with _.
+func sanitizeName(key string, isLetter, isDigit func(rune) bool) string {
+	var b strings.Builder
+	for i, r := range key {
+		if i == 0 && isDigit(r) {
+			b.WriteRune('_')
+		}
+		valid := r == '_' || isLetter(r) || isDigit(r)
+		if i > 0 {
+			valid = valid || r == '-' || r == '.'
+		}
+		if valid {
+			b.WriteRune(r)
+		} else {
+			b.WriteRune('_')
+		}
+	}
+	name := b.String()
+	if len(name) == 0 {
+		name = "_"
+	}
+	// Names starting with xml are reserved.
+	if strings.HasPrefix(strings.ToLower(name), "xml") {
+		name = "_" + name
+	}
+	return name
+}
+
+func isASCIILetter(r rune) bool {
+	return r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z'
+}
+
+func isASCIIDigit(r rune) bool {
+	return r >= '0' && r <= '9'
+}
+
+// parsableName returns true if encoding/xml accepts name as an element name.
+func parsableName(name string) bool {
+	for _, r := range name {
+		if r >= utf8.RuneSelf {
+			tok, err := xml.NewDecoder(strings.NewReader("<" + name + "/>")).Token()
+			start, ok := tok.(xml.StartElement)
+			return err == nil && ok && start.Name.Local == name
+		}
+	}
+	// Ascii names built by sanitizeName are always valid.
+	return true
+}
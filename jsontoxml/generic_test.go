@@ -0,0 +1,113 @@
+package jsontoxml
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestLossless(t *testing.T) {
+	jdata := []byte(`{"id": 10, "name": {"first": "a <b>", "last": ""}, "tags": ["x", 1.5, true, null],
+		"empty": {}, "list": [], "1st key": "v"}`)
+	buf := &bytes.Buffer{}
+	require.NoError(t, jsonToXml(jdata, buf, &convertOptions{lossless: true}))
+	res := ` <jsonData type="object">
+  <_1st_key key="1st key">v</_1st_key>
+  <empty type="object"></empty>
+  <id type="number">10</id>
+  <list type="array"></list>
+  <name type="object">
+   <first>a &lt;b&gt;</first>
+   <last></last>
+  </name>
+  <tags type="array">
+   <item>x</item>
+   <item type="number">1.5</item>
+   <item type="bool">true</item>
+   <item type="null"></item>
+  </tags>
+ </jsonData>`
+	require.Equal(t, res, buf.String())
+
+	// Converting back yields the same json.
+	out := &bytes.Buffer{}
+	require.NoError(t, xmlToJson(buf, out))
+	var want, got interface{}
+	require.NoError(t, json.Unmarshal(jdata, &want))
+	require.NoError(t, json.Unmarshal(out.Bytes(), &got))
+	require.Equal(t, want, got)
+}
+
+func TestLosslessControlCharacters(t *testing.T) {
+	jdata := []byte(`{"a": "x\u0001y", "b\u0000": 1, "1": "\ufffe"}`)
+	buf := &bytes.Buffer{}
+	require.NoError(t, jsonToXml(jdata, buf, &convertOptions{lossless: true}))
+	res := ` <jsonData type="object">
+  <_1 key="&#34;1&#34;" escaped="json">&#34;\ufffe&#34;</_1>
+  <a escaped="json">&#34;x\u0001y&#34;</a>
+  <b_ key="&#34;b\u0000&#34;" escaped="json" type="number">1</b_>
+ </jsonData>`
+	require.Equal(t, res, buf.String())
+	out := &bytes.Buffer{}
+	require.NoError(t, xmlToJson(buf, out))
+	require.JSONEq(t, string(jdata), out.String())
+}
+
+func TestLosslessScalarRoot(t *testing.T) {
+	for _, jdata := range []string{`"text"`, `12`, `null`, `[{"a": 1}]`, `"\u0002"`} {
+		buf := &bytes.Buffer{}
+		require.NoError(t, jsonToXml([]byte(jdata), buf, &convertOptions{lossless: true}))
+		out := &bytes.Buffer{}
+		require.NoError(t, xmlToJson(buf, out))
+		require.JSONEq(t, jdata, out.String())
+	}
+}
+
+func TestXMLName(t *testing.T) {
+	tt := []struct {
+		key, name string
+		ok        bool
+	}{
+		{"first_name", "first_name", true},
+		{"first name", "first_name", false},
+		{"1st", "_1st", false},
+		{"xmlns", "_xmlns", false},
+		{"", "_", false},
+		{"a.b-c", "a.b-c", true},
+		{"名前", "名前", true},
+		{"ǅx", "_x", false},
+	}
+	for _, ti := range tt {
+		name, ok := xmlName(ti.key)
+		require.Equal(t, ti.name, name, ti.key)
+		require.Equal(t, ti.ok, ok, ti.key)
+	}
+}
+
+func TestGeneric(t *testing.T) {
+	jdata := []byte(`{"user": {"name": "a", "score": 1.5, "tags": ["x", "y"], "admin": false,
+		"manager": null}}`)
+	buf := &bytes.Buffer{}
+	opts := &convertOptions{generic: true, locale: locales["de"]}
+	require.NoError(t, jsonToXml(jdata, buf, opts))
+	res := ` <jsonData>
+  <user>
+   <admin>false</admin>
+   <manager></manager>
+   <name>a</name>
+   <score>1,5</score>
+   <tags>
+    <item>x</item>
+    <item>y</item>
+   </tags>
+  </user>
+ </jsonData>`
+	require.Equal(t, res, buf.String())
+
+	// Any json is accepted, including json that isn't of the jsonData type.
+	buf.Reset()
+	require.NoError(t, jsonToXml([]byte(`{"foo":"bar"}`), buf, opts))
+	require.Error(t, jsonToXml([]byte(`{"foo":`), buf, opts))
+}
@@ -0,0 +1,169 @@
+package jsontoxml
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"math/rand"
+	"os"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+)
+
+var (
+	genfixtureCmd = &cobra.Command{
+		Use:   "genfixture",
+		Short: "Generate synthetic json test data",
+		Long: `genfixture writes a json array of synthetic records, so that mappings can be` +
+			` tested and benchmarked at production scale without real data.`,
+		Run: func(cmd *cobra.Command, args []string) {
+			runGenfixture()
+		},
+	}
+	fixture    fixtureSpec
+	fixtureOut string
+	fixtureTyp string
+)
+
+func init() {
+	f := genfixtureCmd.Flags()
+	f.IntVar(&fixture.records, "records", 1000, "Number of records to generate.")
+	f.IntVar(&fixture.fields, "fields", 8, "Number of fields per object.")
+	f.IntVar(&fixture.depth, "depth", 1, "Nesting depth of the objects. 1 means flat records.")
+	f.StringVar(&fixtureTyp, "types", "string,int,float,bool",
+		"Comma separated field types to use: string, int, float, bool, null and array.")
+	f.StringVar(&fixture.shape, "shape", "generic",
+		"Shape of the records: generic, or jsondata for records of the jsonData type.")
+	f.Int64Var(&fixture.seed, "seed", 1, "Seed of the generator. The same seed yields the same data.")
+	f.StringVar(&fixtureOut, "out", "-", "File to write to, or - for stdout.")
+	rootCmd.AddCommand(genfixtureCmd)
+}
+
+// fixtureSpec describes the data generated by genfixture.
+type fixtureSpec struct {
+	records int
+	fields  int
+	depth   int
+	types   []string
+	shape   string
+	seed    int64
+}
+
+var fixtureTypes = map[string]bool{
+	"string": true, "int": true, "float": true, "bool": true, "null": true, "array": true,
+}
+
+func runGenfixture() {
+	fixture.types = strings.Split(fixtureTyp, ",")
+	out := io.Writer(os.Stdout)
+	if fixtureOut != "-" {
+		f, err := os.Create(fixtureOut)
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer f.Close()
+		out = f
+	}
+	bw := bufio.NewWriter(out)
+	if err := fixture.generate(bw); err != nil {
+		log.Fatal(err)
+	}
+	if err := bw.Flush(); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// generate writes the records to w. Records are written one at a time, so
+// memory use doesn't depend on the record count.
+func (s fixtureSpec) generate(w io.Writer) error {
+	if s.records < 0 || s.fields < 1 || s.depth < 1 {
+		return errors.New("--records must be >= 0, --fields and --depth must be >= 1")
+	}
+	for _, t := range s.types {
+		if !fixtureTypes[strings.TrimSpace(t)] {
+			return errors.Errorf("unknown field type %q", t)
+		}
+	}
+	if s.shape != "generic" && s.shape != "jsondata" {
+		return errors.Errorf("unknown shape %q. Expected generic or jsondata", s.shape)
+	}
+	rnd := rand.New(rand.NewSource(s.seed))
+	if _, err := io.WriteString(w, "[\n"); err != nil {
+		return err
+	}
+	for i := 0; i < s.records; i++ {
+		var rec interface{}
+		if s.shape == "jsondata" {
+			rec = map[string]interface{}{
+				"id":         i + 1,
+				"first_name": randWord(rnd),
+				"last_name":  randWord(rnd),
+				"city":       randWord(rnd),
+				"state":      randWord(rnd),
+			}
+		} else {
+			rec = s.object(rnd, s.depth)
+		}
+		data, err := json.Marshal(rec)
+		if err != nil {
+			return errors.Wrap(err, "json.Marshal")
+		}
+		sep := ",\n"
+		if i == s.records-1 {
+			sep = "\n"
+		}
+		if _, err := fmt.Fprintf(w, "  %s%s", data, sep); err != nil {
+			return err
+		}
+	}
+	_, err := io.WriteString(w, "]\n")
+	return err
+}
+
+// object returns an object with the configured fields. Objects above the last
+// level also get a nested object.
+func (s fixtureSpec) object(rnd *rand.Rand, depth int) map[string]interface{} {
+	obj := make(map[string]interface{}, s.fields+1)
+	for i := 0; i < s.fields; i++ {
+		obj[fmt.Sprintf("field%d", i)] = s.scalar(rnd, s.types[i%len(s.types)])
+	}
+	if depth > 1 {
+		obj["nested"] = s.object(rnd, depth-1)
+	}
+	return obj
+}
+
+func (s fixtureSpec) scalar(rnd *rand.Rand, typ string) interface{} {
+	switch strings.TrimSpace(typ) {
+	case "int":
+		return rnd.Intn(1000000)
+	case "float":
+		return float64(rnd.Intn(1000000)) / 100
+	case "bool":
+		return rnd.Intn(2) == 1
+	case "null":
+		return nil
+	case "array":
+		arr := make([]interface{}, rnd.Intn(4)+1)
+		for i := range arr {
+			arr[i] = randWord(rnd)
+		}
+		return arr
+	}
+	return randWord(rnd)
+}
+
+const letters = "abcdefghijklmnopqrstuvwxyz"
+
+func randWord(rnd *rand.Rand) string {
+	b := make([]byte, rnd.Intn(8)+3)
+	for i := range b {
+		b[i] = letters[rnd.Intn(len(letters))]
+	}
+	b[0] -= 'a' - 'A'
+	return string(b)
+}
@@ -0,0 +1,47 @@
+package jsontoxml
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestGenfixture(t *testing.T) {
+	spec := fixtureSpec{records: 5, fields: 3, depth: 2, types: []string{"int", "array"},
+		shape: "generic", seed: 7}
+	var buf bytes.Buffer
+	require.NoError(t, spec.generate(&buf))
+
+	var recs []map[string]interface{}
+	require.NoError(t, json.Unmarshal(buf.Bytes(), &recs))
+	require.Len(t, recs, 5)
+	require.Len(t, recs[0], 4)
+	require.IsType(t, float64(0), recs[0]["field0"])
+	require.IsType(t, []interface{}{}, recs[0]["field1"])
+	require.Len(t, recs[0]["nested"], 3)
+
+	// The same seed generates the same data.
+	var again bytes.Buffer
+	require.NoError(t, spec.generate(&again))
+	require.Equal(t, buf.String(), again.String())
+}
+
+func TestGenfixtureJSONData(t *testing.T) {
+	spec := fixtureSpec{records: 2, fields: 1, depth: 1, types: []string{"string"},
+		shape: "jsondata", seed: 1}
+	var buf bytes.Buffer
+	require.NoError(t, spec.generate(&buf))
+	var recs []jsonData
+	require.NoError(t, json.Unmarshal(buf.Bytes(), &recs))
+	require.Equal(t, 2, recs[1].Id)
+	require.NotEmpty(t, recs[1].FirstName)
+}
+
+func TestGenfixtureInvalid(t *testing.T) {
+	var buf bytes.Buffer
+	require.Error(t, fixtureSpec{records: 1, fields: 1, depth: 1, types: []string{"foo"},
+		shape: "generic"}.generate(&buf))
+	require.Error(t, fixtureSpec{records: 1, fields: 0, depth: 1}.generate(&buf))
+}
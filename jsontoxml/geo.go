@@ -0,0 +1,540 @@
+package jsontoxml
+
+import (
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// Presets convert a json format to a matching xml format instead of mapping its
+// elements generically.
+const (
+	// presetGML converts GeoJSON to GML 3.2 features.
+	presetGML = "geojson-gml"
+	// presetKML converts GeoJSON to KML 2.2 placemarks.
+	presetKML = "geojson-kml"
+)
+
+const (
+	gmlNamespace = "http://www.opengis.net/gml/3.2"
+	kmlNamespace = "http://www.opengis.net/kml/2.2"
+	// GeoJSON coordinates are longitude, latitude and optional height on
+	// WGS 84, which are the axes of CRS84 and CRS84h. EPSG:4326 would swap them.
+	crs84  = "http://www.opengis.net/def/crs/OGC/1.3/CRS84"
+	crs84h = "http://www.opengis.net/def/crs/OGC/0/CRS84h"
+)
+
+var preset string
+
+func init() {
+	rootCmd.PersistentFlags().StringVar(&preset, "preset", "",
+		"Convert a json format to its xml counterpart: geojson-gml converts GeoJSON to GML"+
+			" 3.2, geojson-kml to KML 2.2.")
+}
+
+// geoJSONToXml converts a GeoJSON FeatureCollection, Feature or geometry to the
+// format of the preset. Features become GML features or KML placemarks, with
+// their properties, and the coordinates are written unchanged.
+func (o *convertOptions) geoJSONToXml(data []byte) ([]byte, error) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	var doc map[string]interface{}
+	if err := dec.Decode(&doc); err != nil {
+		return nil, errors.Wrap(err, "json.Unmarshal")
+	}
+	var features []map[string]interface{}
+	switch doc["type"] {
+	case "FeatureCollection":
+		list, ok := doc["features"].([]interface{})
+		if !ok {
+			return nil, errors.New("geojson: features must be an array")
+		}
+		for _, f := range list {
+			feature, ok := f.(map[string]interface{})
+			if !ok {
+				return nil, errors.New("geojson: features must be objects")
+			}
+			features = append(features, feature)
+		}
+	case "Feature":
+		features = append(features, doc)
+	default:
+		features = append(features, map[string]interface{}{"geometry": doc})
+	}
+
+	var buf bytes.Buffer
+	g := &geoWriter{o: o, enc: xml.NewEncoder(&buf), kml: o.preset == presetKML}
+	g.enc.Indent(" ", " ")
+	if err := g.collection(features); err != nil {
+		return nil, err
+	}
+	if err := g.enc.Flush(); err != nil {
+		return nil, errors.Wrap(err, "xml.Marshal")
+	}
+	return buf.Bytes(), nil
+}
+
+// geoWriter writes GeoJSON features as GML or KML.
+type geoWriter struct {
+	o   *convertOptions
+	enc *xml.Encoder
+	kml bool
+}
+
+func (g *geoWriter) start(name string, attrs ...xml.Attr) error {
+	return g.enc.EncodeToken(xml.StartElement{Name: xml.Name{Local: name}, Attr: attrs})
+}
+
+func (g *geoWriter) end(name string) error {
+	return g.enc.EncodeToken(xml.EndElement{Name: xml.Name{Local: name}})
+}
+
+func (g *geoWriter) text(name, s string) error {
+	if err := g.start(name); err != nil {
+		return err
+	}
+	if err := g.enc.EncodeToken(xml.CharData(s)); err != nil {
+		return err
+	}
+	return g.end(name)
+}
+
+func attr(name, value string) xml.Attr {
+	return xml.Attr{Name: xml.Name{Local: name}, Value: value}
+}
+
+func (g *geoWriter) collection(features []map[string]interface{}) error {
+	root, wrapper := "FeatureCollection", "featureMember"
+	attrs := []xml.Attr{attr("xmlns:gml", gmlNamespace)}
+	if g.kml {
+		root, attrs = "kml", []xml.Attr{attr("xmlns", kmlNamespace)}
+	}
+	if err := g.start(root, attrs...); err != nil {
+		return err
+	}
+	if g.kml {
+		if err := g.start("Document"); err != nil {
+			return err
+		}
+	}
+	for i, f := range features {
+		if !g.kml {
+			if err := g.start(wrapper); err != nil {
+				return err
+			}
+		}
+		if err := g.feature(f); err != nil {
+			return errors.Wrapf(err, "feature %d", i)
+		}
+		if !g.kml {
+			if err := g.end(wrapper); err != nil {
+				return err
+			}
+		}
+	}
+	if g.kml {
+		if err := g.end("Document"); err != nil {
+			return err
+		}
+	}
+	return g.end(root)
+}
+
+func (g *geoWriter) feature(f map[string]interface{}) error {
+	name := "Feature"
+	if g.kml {
+		name = "Placemark"
+	}
+	var attrs []xml.Attr
+	if id, ok := f["id"]; ok && id != nil {
+		s := g.o.formatScalar(id)
+		if g.kml {
+			attrs = append(attrs, attr("id", s))
+		} else {
+			// gml:id must be an xml name, and ids are often numbers.
+			s, _ = xmlName(s)
+			attrs = append(attrs, attr("gml:id", s))
+		}
+	}
+	props, _ := f["properties"].(map[string]interface{})
+	if !g.kml {
+		attrs = append(attrs, g.o.fieldAttrs(props)...)
+	}
+	if err := g.start(name, attrs...); err != nil {
+		return err
+	}
+	keys := make([]string, 0, len(props))
+	for k := range props {
+		if g.kml || !g.o.asAttribute(k, props[k]) {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	if g.kml {
+		if err := g.kmlProperties(props, keys); err != nil {
+			return err
+		}
+	} else {
+		for _, k := range keys {
+			el, ok := xmlName(k)
+			child := xml.StartElement{Name: xml.Name{Local: el}}
+			if !ok {
+				child.Attr = []xml.Attr{attr(keyAttr, k)}
+				g.o.warnRenamed(k, el)
+			}
+			if err := g.o.encodeField(g.enc, k, child, props[k]); err != nil {
+				return err
+			}
+		}
+	}
+	if geom, ok := f["geometry"].(map[string]interface{}); ok {
+		if g.kml {
+			if err := g.kmlGeometry(geom); err != nil {
+				return err
+			}
+		} else {
+			if err := g.start("geometry"); err != nil {
+				return err
+			}
+			if err := g.gmlGeometry(geom, true); err != nil {
+				return err
+			}
+			if err := g.end("geometry"); err != nil {
+				return err
+			}
+		}
+	}
+	return g.end(name)
+}
+
+// kmlProperties writes the name and description of a placemark, and every
+// property as ExtendedData.
+func (g *geoWriter) kmlProperties(props map[string]interface{}, keys []string) error {
+	for _, k := range []string{"name", "description"} {
+		if s, ok := props[k].(string); ok {
+			if err := g.text(k, s); err != nil {
+				return err
+			}
+		}
+	}
+	if len(keys) == 0 {
+		return nil
+	}
+	if err := g.start("ExtendedData"); err != nil {
+		return err
+	}
+	for _, k := range keys {
+		v := props[k]
+		s := g.o.formatScalar(v)
+		switch v.(type) {
+		case map[string]interface{}, []interface{}:
+			s = compactJSON(v)
+		}
+		if err := g.start("Data", attr("name", k)); err != nil {
+			return err
+		}
+		if err := g.text("value", s); err != nil {
+			return err
+		}
+		if err := g.end("Data"); err != nil {
+			return err
+		}
+	}
+	return g.end("ExtendedData")
+}
+
+// gmlMultiNames are the GML element and member element of the GeoJSON multi
+// geometries.
+var gmlMultiNames = map[string][2]string{
+	"MultiPoint":      {"gml:MultiPoint", "gml:pointMember"},
+	"MultiLineString": {"gml:MultiCurve", "gml:curveMember"},
+	"MultiPolygon":    {"gml:MultiSurface", "gml:surfaceMember"},
+}
+
+// member returns the geometry of type typ with the coordinates of a member of
+// a multi geometry.
+func member(typ string, coords interface{}) map[string]interface{} {
+	return map[string]interface{}{"type": typ, "coordinates": coords}
+}
+
+// members returns the coordinates of the members of a multi geometry.
+func members(geom map[string]interface{}) ([]interface{}, error) {
+	list, ok := geom["coordinates"].([]interface{})
+	if !ok {
+		return nil, errors.Errorf("geojson: %v coordinates must be an array", geom["type"])
+	}
+	return list, nil
+}
+
+func (g *geoWriter) gmlGeometry(geom map[string]interface{}, top bool) error {
+	typ, _ := geom["type"].(string)
+	var attrs []xml.Attr
+	if top {
+		dim := dimension(geom)
+		srs := crs84
+		if dim == 3 {
+			srs = crs84h
+		}
+		attrs = []xml.Attr{attr("srsName", srs), attr("srsDimension", fmt.Sprint(dim))}
+	}
+	switch typ {
+	case "Point":
+		pos, err := position(geom["coordinates"])
+		if err != nil {
+			return err
+		}
+		return g.wrap("gml:Point", attrs, func() error {
+			return g.text("gml:pos", strings.Join(pos, " "))
+		})
+	case "LineString":
+		line, err := positions(geom["coordinates"])
+		if err != nil {
+			return err
+		}
+		return g.wrap("gml:LineString", attrs, func() error {
+			return g.text("gml:posList", gmlPosList(line))
+		})
+	case "Polygon":
+		rings, err := polygon(geom["coordinates"])
+		if err != nil {
+			return err
+		}
+		return g.wrap("gml:Polygon", attrs, func() error {
+			for i, ring := range rings {
+				boundary := "gml:interior"
+				if i == 0 {
+					boundary = "gml:exterior"
+				}
+				err := g.wrap(boundary, nil, func() error {
+					return g.wrap("gml:LinearRing", nil, func() error {
+						return g.text("gml:posList", gmlPosList(ring))
+					})
+				})
+				if err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+	case "MultiPoint", "MultiLineString", "MultiPolygon":
+		list, err := members(geom)
+		if err != nil {
+			return err
+		}
+		names := gmlMultiNames[typ]
+		name, memberName := names[0], names[1]
+		single := strings.TrimPrefix(typ, "Multi")
+		return g.wrap(name, attrs, func() error {
+			for _, coords := range list {
+				err := g.wrap(memberName, nil, func() error {
+					return g.gmlGeometry(member(single, coords), false)
+				})
+				if err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+	case "GeometryCollection":
+		list, _ := geom["geometries"].([]interface{})
+		return g.wrap("gml:MultiGeometry", attrs, func() error {
+			for _, v := range list {
+				child, ok := v.(map[string]interface{})
+				if !ok {
+					return errors.New("geojson: geometries must be objects")
+				}
+				err := g.wrap("gml:geometryMember", nil, func() error {
+					return g.gmlGeometry(child, false)
+				})
+				if err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+	}
+	return errors.Errorf("geojson: unknown geometry type %q", typ)
+}
+
+func (g *geoWriter) kmlGeometry(geom map[string]interface{}) error {
+	typ, _ := geom["type"].(string)
+	switch typ {
+	case "Point":
+		pos, err := position(geom["coordinates"])
+		if err != nil {
+			return err
+		}
+		return g.wrap("Point", nil, func() error {
+			return g.text("coordinates", strings.Join(pos, ","))
+		})
+	case "LineString":
+		line, err := positions(geom["coordinates"])
+		if err != nil {
+			return err
+		}
+		return g.wrap("LineString", nil, func() error {
+			return g.text("coordinates", kmlCoordinates(line))
+		})
+	case "Polygon":
+		rings, err := polygon(geom["coordinates"])
+		if err != nil {
+			return err
+		}
+		return g.wrap("Polygon", nil, func() error {
+			for i, ring := range rings {
+				boundary := "innerBoundaryIs"
+				if i == 0 {
+					boundary = "outerBoundaryIs"
+				}
+				err := g.wrap(boundary, nil, func() error {
+					return g.wrap("LinearRing", nil, func() error {
+						return g.text("coordinates", kmlCoordinates(ring))
+					})
+				})
+				if err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+	case "MultiPoint", "MultiLineString", "MultiPolygon":
+		list, err := members(geom)
+		if err != nil {
+			return err
+		}
+		single := strings.TrimPrefix(typ, "Multi")
+		return g.wrap("MultiGeometry", nil, func() error {
+			for _, coords := range list {
+				if err := g.kmlGeometry(member(single, coords)); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+	case "GeometryCollection":
+		list, _ := geom["geometries"].([]interface{})
+		return g.wrap("MultiGeometry", nil, func() error {
+			for _, v := range list {
+				child, ok := v.(map[string]interface{})
+				if !ok {
+					return errors.New("geojson: geometries must be objects")
+				}
+				if err := g.kmlGeometry(child); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+	}
+	return errors.Errorf("geojson: unknown geometry type %q", typ)
+}
+
+// wrap writes the element name around the elements written by inner.
+func (g *geoWriter) wrap(name string, attrs []xml.Attr, inner func() error) error {
+	if err := g.start(name, attrs...); err != nil {
+		return err
+	}
+	if err := inner(); err != nil {
+		return err
+	}
+	return g.end(name)
+}
+
+// position returns the coordinates of a GeoJSON position, as written in the
+// json so that no precision is lost.
+func position(v interface{}) ([]string, error) {
+	coords, ok := v.([]interface{})
+	if !ok || len(coords) < 2 || len(coords) > 3 {
+		return nil, errors.Errorf("geojson: invalid position %s", compactJSON(v))
+	}
+	pos := make([]string, len(coords))
+	for i, c := range coords {
+		n, ok := c.(json.Number)
+		if !ok {
+			return nil, errors.Errorf("geojson: invalid position %s", compactJSON(v))
+		}
+		pos[i] = n.String()
+	}
+	return pos, nil
+}
+
+func positions(v interface{}) ([][]string, error) {
+	list, ok := v.([]interface{})
+	if !ok {
+		return nil, errors.Errorf("geojson: invalid coordinates %s", compactJSON(v))
+	}
+	out := make([][]string, len(list))
+	for i, p := range list {
+		pos, err := position(p)
+		if err != nil {
+			return nil, err
+		}
+		if i > 0 && len(pos) != len(out[0]) {
+			return nil, errors.New("geojson: positions of mixed dimensions")
+		}
+		out[i] = pos
+	}
+	return out, nil
+}
+
+func polygon(v interface{}) ([][][]string, error) {
+	list, ok := v.([]interface{})
+	if !ok || len(list) == 0 {
+		return nil, errors.Errorf("geojson: invalid polygon %s", compactJSON(v))
+	}
+	rings := make([][][]string, len(list))
+	for i, r := range list {
+		ring, err := positions(r)
+		if err != nil {
+			return nil, err
+		}
+		rings[i] = ring
+	}
+	return rings, nil
+}
+
+// dimension returns the number of coordinates of the first position of the
+// geometry: 2, or 3 with a height.
+func dimension(geom map[string]interface{}) int {
+	if geom["type"] == "GeometryCollection" {
+		list, _ := geom["geometries"].([]interface{})
+		for _, v := range list {
+			if child, ok := v.(map[string]interface{}); ok {
+				return dimension(child)
+			}
+		}
+		return 2
+	}
+	v := geom["coordinates"]
+	for {
+		list, ok := v.([]interface{})
+		if !ok || len(list) == 0 {
+			return 2
+		}
+		if _, ok := list[0].(json.Number); ok {
+			return len(list)
+		}
+		v = list[0]
+	}
+}
+
+func gmlPosList(line [][]string) string {
+	parts := make([]string, len(line))
+	for i, pos := range line {
+		parts[i] = strings.Join(pos, " ")
+	}
+	return strings.Join(parts, " ")
+}
+
+func kmlCoordinates(line [][]string) string {
+	parts := make([]string, len(line))
+	for i, pos := range line {
+		parts[i] = strings.Join(pos, ",")
+	}
+	return strings.Join(parts, " ")
+}
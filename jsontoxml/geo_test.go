@@ -0,0 +1,94 @@
+package jsontoxml
+
+import (
+	"bytes"
+	"encoding/xml"
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// wellFormed fails the test if data isn't well formed xml.
+func wellFormed(t *testing.T, data []byte) {
+	dec := xml.NewDecoder(bytes.NewReader(data))
+	for {
+		_, err := dec.Token()
+		if err == io.EOF {
+			return
+		}
+		require.NoError(t, err, string(data))
+	}
+}
+
+const geoFixture = `{"type": "FeatureCollection", "features": [
+	{"type": "Feature", "id": 7, "properties": {"name": "Park", "area m2": 1200},
+	 "geometry": {"type": "Polygon", "coordinates": [
+		[[13.1, 52.1], [13.2, 52.1], [13.2, 52.2], [13.1, 52.1]],
+		[[13.12, 52.12], [13.13, 52.12], [13.13, 52.13], [13.12, 52.12]]]}},
+	{"type": "Feature", "properties": null,
+	 "geometry": {"type": "GeometryCollection", "geometries": [
+		{"type": "Point", "coordinates": [1.000000001, 2, 30]},
+		{"type": "MultiPoint", "coordinates": [[1, 2, 3]]}]}}
+]}`
+
+func TestGeoJSONToGML(t *testing.T) {
+	got, err := convert([]byte(geoFixture), &convertOptions{preset: presetGML})
+	require.NoError(t, err)
+	wellFormed(t, got)
+	for _, want := range []string{
+		`<FeatureCollection xmlns:gml="http://www.opengis.net/gml/3.2">`,
+		`<Feature gml:id="_7">`,
+		`<area_m2 key="area m2">1200</area_m2>`,
+		`<gml:Polygon srsName="http://www.opengis.net/def/crs/OGC/1.3/CRS84" srsDimension="2">`,
+		// Longitude first, as in GeoJSON.
+		`<gml:posList>13.1 52.1 13.2 52.1 13.2 52.2 13.1 52.1</gml:posList>`,
+		`<gml:interior>`,
+		`<gml:MultiGeometry srsName="http://www.opengis.net/def/crs/OGC/0/CRS84h" srsDimension="3">`,
+		// Coordinates are copied without rounding.
+		`<gml:pos>1.000000001 2 30</gml:pos>`,
+		`<gml:pointMember>`,
+	} {
+		require.Contains(t, string(got), want)
+	}
+}
+
+func TestGeoJSONToKML(t *testing.T) {
+	got, err := convert([]byte(geoFixture), &convertOptions{preset: presetKML})
+	require.NoError(t, err)
+	wellFormed(t, got)
+	for _, want := range []string{
+		`<kml xmlns="http://www.opengis.net/kml/2.2">`,
+		`<Placemark id="7">`,
+		`<name>Park</name>`,
+		`<Data name="area m2">`,
+		`<coordinates>13.1,52.1 13.2,52.1 13.2,52.2 13.1,52.1</coordinates>`,
+		`<innerBoundaryIs>`,
+		`<coordinates>1.000000001,2,30</coordinates>`,
+		`<MultiGeometry>`,
+	} {
+		require.Contains(t, string(got), want)
+	}
+
+	// A bare geometry becomes a placemark.
+	got, err = convert([]byte(`{"type": "LineString", "coordinates": [[0, 0], [1, 1]]}`),
+		&convertOptions{preset: presetKML})
+	require.NoError(t, err)
+	require.Contains(t, string(got), "<Placemark>")
+	require.Contains(t, string(got), "<coordinates>0,0 1,1</coordinates>")
+}
+
+func TestGeoJSONErrors(t *testing.T) {
+	for _, doc := range []string{
+		`{"type": "Point", "coordinates": [1]}`,
+		`{"type": "Point", "coordinates": ["1", "2"]}`,
+		`{"type": "LineString", "coordinates": [[0, 0], [1, 1, 1]]}`,
+		`{"type": "Polygon", "coordinates": []}`,
+		`{"type": "Circle", "coordinates": [0, 0]}`,
+		`{"type": "FeatureCollection", "features": {}}`,
+		`[]`,
+	} {
+		_, err := convert([]byte(doc), &convertOptions{preset: presetGML})
+		require.Error(t, err, doc)
+	}
+}
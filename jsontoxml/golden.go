@@ -0,0 +1,140 @@
+package jsontoxml
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+)
+
+var (
+	goldenCmd = &cobra.Command{
+		Use:   "test <dir>",
+		Short: "Check the conversion against golden files",
+		Long: `test converts every <name>.json in the directory (recursively) with the current` +
+			` flags and compares the result with <name>.xml, reporting a diff for every mismatch.`,
+		Args: cobra.ExactArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			failed, err := runGoldenTests(args[0], convOpts, updateGolden, os.Stdout)
+			if err != nil {
+				log.Fatal(err)
+			}
+			if failed > 0 {
+				os.Exit(1)
+			}
+		},
+	}
+	updateGolden bool
+)
+
+func init() {
+	goldenCmd.Flags().BoolVar(&updateGolden, "update", false,
+		"Write the current output to the golden files instead of comparing.")
+	rootCmd.AddCommand(goldenCmd)
+}
+
+// runGoldenTests compares the conversion of every json file in dir with its
+// golden xml file and reports the results to out. It returns the number of
+// failed cases.
+func runGoldenTests(dir string, opts *convertOptions, update bool, out io.Writer) (int, error) {
+	var inputs []string
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !info.IsDir() && filepath.Ext(path) == ".json" {
+			inputs = append(inputs, path)
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, errors.Wrap(err, "walk")
+	}
+	if len(inputs) == 0 {
+		return 0, errors.Errorf("no json files found in %q", dir)
+	}
+	sort.Strings(inputs)
+
+	failed := 0
+	for _, in := range inputs {
+		golden := strings.TrimSuffix(in, ".json") + ".xml"
+		data, err := ioutil.ReadFile(in)
+		if err != nil {
+			return failed, err
+		}
+		got, err := convert(data, opts)
+		if err != nil {
+			failed++
+			fmt.Fprintf(out, "FAIL %s: %s\n", in, err)
+			continue
+		}
+		if update {
+			if err := ioutil.WriteFile(golden, got, 0644); err != nil {
+				return failed, err
+			}
+			fmt.Fprintf(out, "UPDATED %s\n", golden)
+			continue
+		}
+		want, err := ioutil.ReadFile(golden)
+		if os.IsNotExist(err) {
+			failed++
+			fmt.Fprintf(out, "FAIL %s: missing golden file %s\n", in, golden)
+			continue
+		}
+		if err != nil {
+			return failed, err
+		}
+		if string(want) != string(got) {
+			failed++
+			fmt.Fprintf(out, "FAIL %s:\n%s", in, lineDiff(string(want), string(got)))
+			continue
+		}
+		fmt.Fprintf(out, "ok   %s\n", in)
+	}
+	fmt.Fprintf(out, "%d passed, %d failed\n", len(inputs)-failed, failed)
+	return failed, nil
+}
+
+// lineDiff returns the lines removed from want (prefixed with -) and added in
+// got (prefixed with +), based on their longest common subsequence.
+func lineDiff(want, got string) string {
+	a, b := strings.Split(want, "\n"), strings.Split(got, "\n")
+	// lcs[i][j] is the length of the lcs of a[i:] and b[j:].
+	lcs := make([][]int, len(a)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else if lcs[i+1][j] >= lcs[i][j+1] {
+				lcs[i][j] = lcs[i+1][j]
+			} else {
+				lcs[i][j] = lcs[i][j+1]
+			}
+		}
+	}
+	var sb strings.Builder
+	i, j := 0, 0
+	for i < len(a) || j < len(b) {
+		switch {
+		case i < len(a) && j < len(b) && a[i] == b[j]:
+			i, j = i+1, j+1
+		case i < len(a) && (j == len(b) || lcs[i+1][j] >= lcs[i][j+1]):
+			fmt.Fprintf(&sb, "  -%s\n", a[i])
+			i++
+		default:
+			fmt.Fprintf(&sb, "  +%s\n", b[j])
+			j++
+		}
+	}
+	return sb.String()
+}
@@ -0,0 +1,50 @@
+package jsontoxml
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestGoldenTests(t *testing.T) {
+	dir, err := ioutil.TempDir("", "golden")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	write := func(name, data string) {
+		require.NoError(t, ioutil.WriteFile(filepath.Join(dir, name), []byte(data), 0644))
+	}
+	write("a.json", `{"id": 1, "first_name": "a"}`)
+	write("b.json", `{"id": 2, "first_name": "b"}`)
+
+	// Without golden files everything fails.
+	var out bytes.Buffer
+	failed, err := runGoldenTests(dir, nil, false, &out)
+	require.NoError(t, err)
+	require.Equal(t, 2, failed)
+
+	failed, err = runGoldenTests(dir, nil, true, &out)
+	require.NoError(t, err)
+	require.Zero(t, failed)
+
+	out.Reset()
+	failed, err = runGoldenTests(dir, nil, false, &out)
+	require.NoError(t, err)
+	require.Zero(t, failed)
+	require.Contains(t, out.String(), "2 passed, 0 failed")
+
+	write("b.json", `{"id": 3, "first_name": "b"}`)
+	out.Reset()
+	failed, err = runGoldenTests(dir, nil, false, &out)
+	require.NoError(t, err)
+	require.Equal(t, 1, failed)
+	require.Contains(t, out.String(), "  -  <Id>2</Id>\n  +  <Id>3</Id>\n")
+}
+
+func TestLineDiff(t *testing.T) {
+	require.Equal(t, "", lineDiff("a\nb", "a\nb"))
+	require.Equal(t, "  -b\n  +c\n  +d\n", lineDiff("a\nb", "a\nc\nd"))
+}
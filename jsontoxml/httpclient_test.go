@@ -0,0 +1,177 @@
+package jsontoxml
+
+import (
+	"crypto/tls"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/quic-go/quic-go/http3"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHTTP3(t *testing.T) {
+	// httptest provides the certificate of the QUIC server.
+	tlsSrv := httptest.NewTLSServer(http.NotFoundHandler())
+	defer tlsSrv.Close()
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	require.NoError(t, err)
+	srv := &http3.Server{
+		TLSConfig: http3.ConfigureTLSConfig(tlsSrv.TLS),
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "application/json")
+			w.Write([]byte(r.Proto))
+		}),
+	}
+	go srv.Serve(conn)
+	defer srv.Close()
+
+	defer func(v bool) { useHTTP3 = v }(useHTTP3)
+	useHTTP3 = true
+	c := newHTTPClient()
+	tr := c.Transport.(*decompressTransport).base.(*http3.Transport)
+	tr.TLSClientConfig = &tls.Config{
+		RootCAs: tlsSrv.Client().Transport.(*http.Transport).TLSClientConfig.RootCAs,
+	}
+	defer tr.Close()
+
+	resp, err := c.Get("https://" + conn.LocalAddr().String() + "/")
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	require.NoError(t, err)
+	require.Equal(t, "HTTP/3.0", string(body))
+}
+
+func TestHeaders(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(r.Header.Get("X-Api-Key") + " " + r.Header.Get("Accept")))
+	}))
+	defer srv.Close()
+
+	secrets["key"] = "s3cr3t"
+	defer delete(secrets, "key")
+	h, err := parseHeaders([]string{"x-api-key: ${secret:key}", "Accept:application/json"})
+	require.NoError(t, err)
+
+	defer func(h http.Header) { requestHeader = h }(requestHeader)
+	requestHeader = h
+	req, err := http.NewRequest(http.MethodGet, srv.URL, nil)
+	require.NoError(t, err)
+	req.Header.Set("Accept", "text/html")
+	resp, err := newHTTPClient().Do(req)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	require.NoError(t, err)
+	require.Equal(t, "s3cr3t application/json", string(body))
+	// The request of the caller is left untouched.
+	require.Equal(t, "text/html", req.Header.Get("Accept"))
+
+	for _, f := range []string{"X-Api-Key", ": value", "X Api: value", "A: ${secret:missing}"} {
+		_, err := parseHeaders([]string{f})
+		require.Error(t, err, f)
+	}
+}
+
+func TestProxy(t *testing.T) {
+	target := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("target"))
+	}))
+	defer target.Close()
+	// The http proxy answers itself, so the test shows the request went through it.
+	httpProxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("proxied " + r.URL.String()))
+	}))
+	defer httpProxy.Close()
+	socks := socks5Server(t)
+	defer socks.Close()
+
+	get := func(proxy string) string {
+		defer func(u *url.URL) { proxyURL = u }(proxyURL)
+		var err error
+		proxyURL, err = parseProxy(proxy)
+		require.NoError(t, err)
+		resp, err := newHTTPClient().Get(target.URL + "/a")
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		body, err := ioutil.ReadAll(resp.Body)
+		require.NoError(t, err)
+		return string(body)
+	}
+	require.Equal(t, "proxied "+target.URL+"/a", get(httpProxy.URL))
+	require.Equal(t, "target", get("socks5://"+socks.Addr().String()))
+
+	for _, p := range []string{"ftp://proxy", "http://", "://x"} {
+		_, err := parseProxy(p)
+		require.Error(t, err, p)
+	}
+}
+
+// socks5Server serves the CONNECT command of SOCKS5 without authentication.
+func socks5Server(t *testing.T) net.Listener {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				buf := make([]byte, 262)
+				// Greeting: version, number of methods, methods.
+				if _, err := io.ReadFull(conn, buf[:2]); err != nil {
+					return
+				}
+				io.ReadFull(conn, buf[:buf[1]])
+				conn.Write([]byte{5, 0})
+				// Request: version, CONNECT, reserved, IPv4 address and port.
+				if _, err := io.ReadFull(conn, buf[:4]); err != nil || buf[3] != 1 {
+					return
+				}
+				io.ReadFull(conn, buf[:6])
+				addr := &net.TCPAddr{IP: net.IP(buf[:4]), Port: int(buf[4])<<8 | int(buf[5])}
+				upstream, err := net.Dial("tcp", addr.String())
+				if err != nil {
+					return
+				}
+				defer upstream.Close()
+				conn.Write([]byte{5, 0, 0, 1, 0, 0, 0, 0, 0, 0})
+				go io.Copy(upstream, conn)
+				io.Copy(conn, upstream)
+			}()
+		}
+	}()
+	return l
+}
+
+func TestTimeouts(t *testing.T) {
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-time.After(300 * time.Millisecond):
+		case <-r.Context().Done():
+		}
+	}))
+	defer slow.Close()
+	defer func(r, c time.Duration) { requestTimeout, connectTimeout = r, c }(requestTimeout,
+		connectTimeout)
+
+	requestTimeout, connectTimeout = 50*time.Millisecond, 2*time.Second
+	c := newHTTPClient()
+	require.Equal(t, 2*time.Second, c.Transport.(*decompressTransport).base.(*http.Transport).TLSHandshakeTimeout)
+	_, err := c.Get(slow.URL)
+	require.Error(t, err)
+	require.True(t, err.(net.Error).Timeout())
+
+	requestTimeout = 0
+	resp, err := newHTTPClient().Get(slow.URL)
+	require.NoError(t, err)
+	resp.Body.Close()
+}
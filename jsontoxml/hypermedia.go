@@ -0,0 +1,264 @@
+package jsontoxml
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"io"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+var hypermedia string
+
+func init() {
+	rootCmd.PersistentFlags().StringVar(&hypermedia, "hypermedia", "",
+		"Unwrap hypermedia envelopes into plain records before converting them: jsonapi, hal,"+
+			" or auto to detect the format of every payload.")
+}
+
+// newHypermediaValidator returns the Validator unwrapping the format: jsonapi,
+// hal or auto. It returns nil if format is empty.
+func newHypermediaValidator(format string) (Validator, error) {
+	switch format {
+	case "":
+		return nil, nil
+	case "jsonapi":
+		return UnwrapJSONAPI(), nil
+	case "hal":
+		return UnwrapHAL(), nil
+	case "auto":
+		return unwrapDocument(func(doc map[string]interface{}) (interface{}, error) {
+			switch {
+			case isJSONAPI(doc):
+				return unwrapJSONAPI(doc)
+			case isHAL(doc):
+				return unwrapHAL(doc), nil
+			}
+			return doc, nil
+		}), nil
+	}
+	return nil, errors.Errorf("invalid --hypermedia %q. Expected jsonapi, hal or auto", format)
+}
+
+// UnwrapJSONAPI returns a Validator turning JSON:API documents into plain
+// records. Every resource becomes an object with its type, id and attributes.
+// Its relationships become fields holding the related resources, with their
+// attributes if they are included in the document. Error documents are
+// rejected.
+func UnwrapJSONAPI() Validator {
+	return unwrapDocument(unwrapJSONAPI)
+}
+
+// UnwrapHAL returns a Validator turning HAL documents into plain records. The
+// _links are dropped and the _embedded resources become fields. A collection,
+// whose only embedded relation is an array, is converted to that array.
+func UnwrapHAL() Validator {
+	return unwrapDocument(func(doc map[string]interface{}) (interface{}, error) {
+		return unwrapHAL(doc), nil
+	})
+}
+
+// unwrapDocument returns a Validator rewriting the json objects with unwrap.
+// Other payloads are left untouched.
+func unwrapDocument(unwrap func(doc map[string]interface{}) (interface{}, error)) Validator {
+	return func(m Metadata, body *bufio.Reader) (io.Reader, error) {
+		d := json.NewDecoder(body)
+		d.UseNumber()
+		var doc interface{}
+		if err := d.Decode(&doc); err != nil {
+			return nil, errors.Wrap(err, "decode")
+		}
+		obj, ok := doc.(map[string]interface{})
+		if !ok {
+			return jsonReader(doc)
+		}
+		v, err := unwrap(obj)
+		if err != nil {
+			return nil, err
+		}
+		return jsonReader(v)
+	}
+}
+
+func jsonReader(v interface{}) (io.Reader, error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil, errors.Wrap(err, "encode")
+	}
+	return bytes.NewReader(data), nil
+}
+
+func isJSONAPI(doc map[string]interface{}) bool {
+	if _, ok := doc["jsonapi"]; ok {
+		return true
+	}
+	if _, ok := doc["errors"]; ok {
+		_, hasData := doc["data"]
+		return !hasData
+	}
+	switch data := doc["data"].(type) {
+	case map[string]interface{}:
+		_, ok := data["type"]
+		return ok
+	case []interface{}:
+		if len(data) == 0 {
+			return false
+		}
+		r, ok := data[0].(map[string]interface{})
+		if !ok {
+			return false
+		}
+		_, ok = r["type"]
+		return ok
+	}
+	return false
+}
+
+func isHAL(doc map[string]interface{}) bool {
+	_, links := doc["_links"]
+	_, embedded := doc["_embedded"]
+	return links || embedded
+}
+
+// resourceKey identifies a JSON:API resource.
+func resourceKey(r map[string]interface{}) string {
+	t, _ := r["type"].(string)
+	id, _ := r["id"].(string)
+	return t + "\x00" + id
+}
+
+func unwrapJSONAPI(doc map[string]interface{}) (interface{}, error) {
+	if errs, ok := doc["errors"].([]interface{}); ok && len(errs) > 0 {
+		return nil, errors.Errorf("JSON:API error: %s", jsonAPIError(errs[0]))
+	}
+	included := make(map[string]map[string]interface{})
+	if inc, ok := doc["included"].([]interface{}); ok {
+		for _, v := range inc {
+			if r, ok := v.(map[string]interface{}); ok {
+				included[resourceKey(r)] = r
+			}
+		}
+	}
+	switch data := doc["data"].(type) {
+	case map[string]interface{}:
+		return flattenResource(data, included, true), nil
+	case []interface{}:
+		records := make([]interface{}, 0, len(data))
+		for _, v := range data {
+			if r, ok := v.(map[string]interface{}); ok {
+				records = append(records, flattenResource(r, included, true))
+			}
+		}
+		return records, nil
+	case nil:
+		return nil, nil
+	}
+	return nil, errors.New("JSON:API data must be an object, an array or null")
+}
+
+// jsonAPIError describes an error object of a JSON:API document.
+func jsonAPIError(v interface{}) string {
+	e, ok := v.(map[string]interface{})
+	if !ok {
+		return compactJSON(v)
+	}
+	var parts []string
+	for _, k := range []string{"status", "code", "title", "detail"} {
+		if s, ok := e[k].(string); ok && len(s) > 0 {
+			parts = append(parts, s)
+		}
+	}
+	if len(parts) == 0 {
+		return compactJSON(v)
+	}
+	return strings.Join(parts, " ")
+}
+
+// flattenResource returns the type, id and attributes of the resource r. If
+// resolve is set, the relationships are added, resolved to the included
+// resources. The included resources aren't resolved in turn, so that cycles
+// between them terminate.
+func flattenResource(r map[string]interface{}, included map[string]map[string]interface{},
+	resolve bool) map[string]interface{} {
+	rec := make(map[string]interface{})
+	if attrs, ok := r["attributes"].(map[string]interface{}); ok {
+		for k, v := range attrs {
+			rec[k] = v
+		}
+	}
+	rec["type"], rec["id"] = r["type"], r["id"]
+	rels, ok := r["relationships"].(map[string]interface{})
+	if !resolve || !ok {
+		return rec
+	}
+	related := func(v interface{}) interface{} {
+		ref, ok := v.(map[string]interface{})
+		if !ok {
+			return v
+		}
+		if inc, ok := included[resourceKey(ref)]; ok {
+			return flattenResource(inc, included, false)
+		}
+		return flattenResource(ref, included, false)
+	}
+	for name, v := range rels {
+		rel, ok := v.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		switch data := rel["data"].(type) {
+		case []interface{}:
+			refs := make([]interface{}, 0, len(data))
+			for _, ref := range data {
+				refs = append(refs, related(ref))
+			}
+			rec[name] = refs
+		case map[string]interface{}:
+			rec[name] = related(data)
+		}
+	}
+	return rec
+}
+
+func unwrapHAL(doc map[string]interface{}) interface{} {
+	if embedded, ok := doc["_embedded"].(map[string]interface{}); ok && len(embedded) == 1 {
+		for _, v := range embedded {
+			if items, ok := v.([]interface{}); ok {
+				return stripHAL(items)
+			}
+		}
+	}
+	return stripHAL(doc)
+}
+
+// stripHAL drops the _links of the resources in v and turns their _embedded
+// resources into fields.
+func stripHAL(v interface{}) interface{} {
+	switch v := v.(type) {
+	case []interface{}:
+		out := make([]interface{}, len(v))
+		for i, item := range v {
+			out[i] = stripHAL(item)
+		}
+		return out
+	case map[string]interface{}:
+		out := make(map[string]interface{}, len(v))
+		for k, item := range v {
+			switch k {
+			case "_links":
+			case "_embedded":
+				if embedded, ok := item.(map[string]interface{}); ok {
+					for rel, res := range embedded {
+						out[rel] = stripHAL(res)
+					}
+				}
+			default:
+				out[k] = stripHAL(item)
+			}
+		}
+		return out
+	}
+	return v
+}
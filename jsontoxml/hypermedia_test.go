@@ -0,0 +1,99 @@
+package jsontoxml
+
+import (
+	"bufio"
+	"encoding/json"
+	"io/ioutil"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// unwrapped returns the payload rewritten by v, reformatted for comparison.
+func unwrapped(t *testing.T, v Validator, payload string) (string, error) {
+	r, err := v(Metadata{}, bufio.NewReader(strings.NewReader(payload)))
+	if err != nil {
+		return "", err
+	}
+	data, err := ioutil.ReadAll(r)
+	require.NoError(t, err)
+	var doc interface{}
+	require.NoError(t, json.Unmarshal(data, &doc))
+	data, err = json.Marshal(doc)
+	require.NoError(t, err)
+	return string(data), nil
+}
+
+func TestUnwrapJSONAPI(t *testing.T) {
+	doc := `{
+		"data": [{
+			"type": "articles", "id": "1",
+			"attributes": {"title": "JSON:API"},
+			"relationships": {
+				"author": {"data": {"type": "people", "id": "9"}},
+				"tags": {"data": [{"type": "tags", "id": "2"}]}
+			},
+			"links": {"self": "http://example.com/articles/1"}
+		}],
+		"included": [{
+			"type": "people", "id": "9",
+			"attributes": {"name": "Dan"},
+			"relationships": {"articles": {"data": [{"type": "articles", "id": "1"}]}}
+		}]
+	}`
+	got, err := unwrapped(t, UnwrapJSONAPI(), doc)
+	require.NoError(t, err)
+	require.JSONEq(t, `[{
+		"type": "articles", "id": "1", "title": "JSON:API",
+		"author": {"type": "people", "id": "9", "name": "Dan"},
+		"tags": [{"type": "tags", "id": "2"}]
+	}]`, got)
+
+	got, err = unwrapped(t, UnwrapJSONAPI(), `{"data": {"type": "a", "id": "1"}}`)
+	require.NoError(t, err)
+	require.JSONEq(t, `{"type": "a", "id": "1"}`, got)
+
+	_, err = unwrapped(t, UnwrapJSONAPI(),
+		`{"errors": [{"status": "404", "title": "Not Found"}]}`)
+	require.EqualError(t, err, "JSON:API error: 404 Not Found")
+}
+
+func TestUnwrapHAL(t *testing.T) {
+	got, err := unwrapped(t, UnwrapHAL(), `{
+		"_links": {"self": {"href": "/orders"}},
+		"count": 2,
+		"_embedded": {"orders": [
+			{"id": 1, "_links": {"self": {"href": "/orders/1"}},
+			 "_embedded": {"customer": {"name": "Ann", "_links": {}}}},
+			{"id": 2}
+		]}
+	}`)
+	require.NoError(t, err)
+	require.JSONEq(t, `[{"id": 1, "customer": {"name": "Ann"}}, {"id": 2}]`, got)
+
+	got, err = unwrapped(t, UnwrapHAL(), `{"id": 1, "_links": {}, "_embedded": {"a": {"x": 1}, "b": []}}`)
+	require.NoError(t, err)
+	require.JSONEq(t, `{"id": 1, "a": {"x": 1}, "b": []}`, got)
+}
+
+func TestHypermediaAuto(t *testing.T) {
+	v, err := newHypermediaValidator("auto")
+	require.NoError(t, err)
+	for payload, want := range map[string]string{
+		`{"data": {"type": "a", "id": "1", "attributes": {"x": 1}}}`: `{"type": "a", "id": "1", "x": 1}`,
+		`{"_embedded": {"items": [{"x": 1}]}}`:                       `[{"x": 1}]`,
+		`{"data": [1, 2]}`:                                           `{"data": [1, 2]}`,
+		`[1]`:                                                        `[1]`,
+	} {
+		got, err := unwrapped(t, v, payload)
+		require.NoError(t, err)
+		require.JSONEq(t, want, got, payload)
+	}
+
+	v, err = newHypermediaValidator("")
+	require.NoError(t, err)
+	require.Nil(t, v)
+	_, err = newHypermediaValidator("siren")
+	require.Error(t, err)
+}
@@ -0,0 +1,100 @@
+package jsontoxml
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// idGenerator returns a unique identifier for a record. content is the
+// canonical json of the record.
+type idGenerator func(content []byte) (string, error)
+
+// newIDGenerator returns the generator for kind: uuidv4, uuidv7, ulid or
+// sha256. rnd is the source of randomness and now the clock.
+func newIDGenerator(kind string, rnd io.Reader, now func() time.Time) (idGenerator, error) {
+	switch kind {
+	case "uuidv4":
+		return func([]byte) (string, error) {
+			return newUUID(rnd, 4, time.Time{})
+		}, nil
+	case "uuidv7":
+		return func([]byte) (string, error) {
+			return newUUID(rnd, 7, now())
+		}, nil
+	case "ulid":
+		return func([]byte) (string, error) {
+			return newULID(rnd, now())
+		}, nil
+	case "sha256":
+		return func(content []byte) (string, error) {
+			return sha256Hex(content), nil
+		}, nil
+	default:
+		return nil, errors.Errorf("unknown id kind %q. Expected uuidv4, uuidv7, ulid or sha256",
+			kind)
+	}
+}
+
+// newUUID returns a version 4 (random) or version 7 (time ordered) UUID as
+// described in RFC 9562.
+func newUUID(rnd io.Reader, version byte, t time.Time) (string, error) {
+	var b [16]byte
+	if _, err := io.ReadFull(rnd, b[:]); err != nil {
+		return "", errors.Wrap(err, "uuid")
+	}
+	if version == 7 {
+		putMillis(b[:6], t)
+	}
+	b[6] = b[6]&0x0f | version<<4
+	b[8] = b[8]&0x3f | 0x80
+	h := hex.EncodeToString(b[:])
+	return fmt.Sprintf("%s-%s-%s-%s-%s", h[:8], h[8:12], h[12:16], h[16:20], h[20:]), nil
+}
+
+const crockford = "0123456789ABCDEFGHJKMNPQRSTVWXYZ"
+
+// newULID returns a ULID: a 48 bit millisecond timestamp followed by 80 random
+// bits, encoded as 26 Crockford base32 characters.
+func newULID(rnd io.Reader, t time.Time) (string, error) {
+	var b [16]byte
+	putMillis(b[:6], t)
+	if _, err := io.ReadFull(rnd, b[6:]); err != nil {
+		return "", errors.Wrap(err, "ulid")
+	}
+	// 128 bits are encoded as 26 characters of 5 bits, the first holding only
+	// 3 bits.
+	out := make([]byte, 26)
+	var acc uint
+	var bits uint
+	i := len(out) - 1
+	for j := len(b) - 1; j >= 0; j-- {
+		acc |= uint(b[j]) << bits
+		bits += 8
+		for bits >= 5 && i >= 0 {
+			out[i] = crockford[acc&0x1f]
+			acc >>= 5
+			bits -= 5
+			i--
+		}
+	}
+	out[0] = crockford[acc&0x1f]
+	return string(out), nil
+}
+
+// putMillis writes the unix time of t in milliseconds as a 48 bit big endian
+// integer.
+func putMillis(b []byte, t time.Time) {
+	ms := uint64(t.UnixNano() / int64(time.Millisecond))
+	for i := 5; i >= 0; i-- {
+		b[i] = byte(ms)
+		ms >>= 8
+	}
+}
+
+// randReader is the default source of randomness for ids.
+var randReader io.Reader = rand.Reader
@@ -0,0 +1,52 @@
+package jsontoxml
+
+import (
+	"bytes"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestIDGenerators(t *testing.T) {
+	ts := time.Date(2021, 3, 1, 10, 0, 0, 0, time.UTC)
+	now := func() time.Time { return ts }
+	zeros := bytes.NewReader(make([]byte, 1024))
+
+	gen, err := newIDGenerator("uuidv4", randReader, now)
+	require.NoError(t, err)
+	id, err := gen(nil)
+	require.NoError(t, err)
+	require.Regexp(t, regexp.MustCompile(`^[0-9a-f]{8}-[0-9a-f]{4}-4[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$`), id)
+
+	gen, err = newIDGenerator("uuidv7", zeros, now)
+	require.NoError(t, err)
+	id, err = gen(nil)
+	require.NoError(t, err)
+	require.Equal(t, "0177ed3a-f500-7000-8000-000000000000", id)
+
+	gen, err = newIDGenerator("ulid", zeros, now)
+	require.NoError(t, err)
+	id, err = gen(nil)
+	require.NoError(t, err)
+	require.Equal(t, "01EZPKNX800000000000000000", id)
+
+	gen, err = newIDGenerator("sha256", zeros, now)
+	require.NoError(t, err)
+	id, err = gen([]byte("{}"))
+	require.NoError(t, err)
+	require.Equal(t, "44136fa355b3678a1146ad16f7e8649e94fb4fc21fe77e8310c060f61caaff8a", id)
+
+	_, err = newIDGenerator("foo", zeros, now)
+	require.Error(t, err)
+}
+
+func TestRecordID(t *testing.T) {
+	gen, err := newIDGenerator("sha256", randReader, time.Now)
+	require.NoError(t, err)
+	opts := &convertOptions{idGen: gen, idAttr: "uid"}
+	buf := &bytes.Buffer{}
+	require.NoError(t, jsonToXml([]byte(`{"id": 10}`), buf, opts))
+	require.Contains(t, buf.String(), ` <jsonData uid="`)
+}
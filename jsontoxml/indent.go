@@ -0,0 +1,117 @@
+package jsontoxml
+
+import (
+	"bytes"
+	"io"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+var (
+	indentFlag, indentPrefix string
+	compactOutput            bool
+)
+
+func init() {
+	rootCmd.PersistentFlags().StringVar(&indentFlag, "indent", " ",
+		"Indentation of a level of nesting of the xml, e.g. \"  \" or \"\\t\".")
+	rootCmd.PersistentFlags().StringVar(&indentPrefix, "indent-prefix", " ",
+		"Prefix of the indented lines of the xml, before the indentation of their level.")
+	rootCmd.PersistentFlags().BoolVar(&compactOutput, "compact", false,
+		"Write the xml without line breaks nor indentation, ignoring --indent and"+
+			" --indent-prefix.")
+}
+
+// xmlLayout replaces the indentation of the xml generated by the converter,
+// which prefixes every line with one space and indents it with one space per
+// level.
+type xmlLayout struct {
+	// prefix starts every indented line, followed by indent once per level.
+	prefix, indent string
+	// compact removes the line breaks and the indentation.
+	compact bool
+}
+
+// newXMLLayout returns the layout of the indentation flags, or nil for the
+// layout of the converter. The prefix and the indentation must be made of
+// spaces and tabs, so that the xml stays well-formed.
+func newXMLLayout(prefix, indent string, compact bool) (*xmlLayout, error) {
+	if compact {
+		return &xmlLayout{compact: true}, nil
+	}
+	for _, f := range []struct{ name, value string }{
+		{"--indent-prefix", prefix}, {"--indent", indent},
+	} {
+		if len(strings.Trim(f.value, " \t")) > 0 {
+			return nil, errors.Errorf("invalid %s %q. Expected spaces and tabs", f.name, f.value)
+		}
+	}
+	if prefix == " " && indent == " " {
+		return nil, nil
+	}
+	return &xmlLayout{prefix: prefix, indent: indent}, nil
+}
+
+// writer returns w writing the xml with the layout. l may be nil.
+func (l *xmlLayout) writer(w io.Writer) io.Writer {
+	if l == nil {
+		return w
+	}
+	return &indentWriter{w: w, layout: l, bol: true}
+}
+
+// apply returns the xml document with the layout. l may be nil.
+func (l *xmlLayout) apply(data []byte) []byte {
+	if l == nil {
+		return data
+	}
+	var buf bytes.Buffer
+	buf.Grow(len(data))
+	l.writer(&buf).Write(data)
+	return buf.Bytes()
+}
+
+// indentWriter changes the indentation of the xml generated by the converter
+// to its layout. The values can't hold line breaks, which are escaped, so the
+// lines are reindented as they are written.
+type indentWriter struct {
+	w      io.Writer
+	layout *xmlLayout
+	// bol is set at the beginning of a line, spaces counts the leading spaces
+	// of the line read so far.
+	bol    bool
+	spaces int
+	buf    []byte
+}
+
+func (iw *indentWriter) Write(p []byte) (int, error) {
+	l := iw.layout
+	out := iw.buf[:0]
+	for _, c := range p {
+		if iw.bol {
+			if c == ' ' {
+				iw.spaces++
+				continue
+			}
+			if iw.spaces > 0 && c != '\n' && !l.compact {
+				out = append(out, l.prefix...)
+				out = append(out, strings.Repeat(l.indent, iw.spaces-1)...)
+			}
+			iw.bol, iw.spaces = false, 0
+		}
+		if c == '\n' {
+			if !l.compact {
+				out = append(out, c)
+			}
+			iw.bol = true
+			continue
+		}
+		out = append(out, c)
+	}
+	iw.buf = out
+	if _, err := iw.w.Write(out); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
@@ -0,0 +1,41 @@
+package jsontoxml
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewXMLLayout(t *testing.T) {
+	l, err := newXMLLayout(" ", " ", false)
+	require.NoError(t, err)
+	require.Nil(t, l)
+	l, err = newXMLLayout("", "\t", false)
+	require.NoError(t, err)
+	require.Equal(t, &xmlLayout{indent: "\t"}, l)
+	l, err = newXMLLayout("x", "y", true)
+	require.NoError(t, err)
+	require.Equal(t, &xmlLayout{compact: true}, l)
+	_, err = newXMLLayout("", "--", false)
+	require.Error(t, err)
+}
+
+func TestConvertLayout(t *testing.T) {
+	doc := []byte(`{"id": 1, "city": ""}`)
+	out, err := convert(doc, &convertOptions{layout: &xmlLayout{prefix: "", indent: "  "}})
+	require.NoError(t, err)
+	require.Equal(t, `<jsonData>
+  <Id>1</Id>
+  <name>
+    <first></first>
+    <last></last>
+  </name>
+  <City></City>
+  <State></State>
+</jsonData>`, string(out))
+
+	out, err = convert(doc, &convertOptions{layout: &xmlLayout{compact: true}, selfClosing: true})
+	require.NoError(t, err)
+	require.Equal(t, `<jsonData><Id>1</Id><name><first/><last/></name><City/><State/></jsonData>`,
+		string(out))
+}
@@ -0,0 +1,460 @@
+package jsontoxml
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v3"
+)
+
+// maxExtractBytes bounds the responses values are extracted from.
+const maxExtractBytes = 32 << 20
+
+var (
+	jobFilePath string
+	// jobGraph is loaded from --job-file, if set.
+	jobGraph *jobFile
+
+	jobNameExpr = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+	// jobPlaceholderExpr matches the placeholders {source.value} of the urls.
+	jobPlaceholderExpr = regexp.MustCompile(`\{([A-Za-z0-9_-]+)\.([A-Za-z0-9_-]+)\}`)
+)
+
+func init() {
+	rootCmd.PersistentFlags().StringVar(&jobFilePath, "job-file", "",
+		"Yaml or json file of the sources to fetch instead of --urls. A source is fetched"+
+			" once the sources it needs succeeded, with the values extracted from their"+
+			" responses, e.g. {login.token}, in its url.")
+}
+
+// jobSource is a source of a job file.
+type jobSource struct {
+	// Name identifies the source in the needs and placeholders of the others.
+	Name string `yaml:"name"`
+	// URL is fetched once per combination of the values of its placeholders
+	// {source.value}. It can be followed by mirrors, separated by |.
+	URL string `yaml:"url"`
+	// Needs lists the sources fetched before this one. They must all succeed
+	// for it to be fetched.
+	Needs []string `yaml:"needs"`
+	// Extract maps the names of the values of the source to the JSONPaths
+	// matching them in its responses.
+	Extract map[string]string `yaml:"extract"`
+
+	paths map[string]*jsonSelector
+}
+
+// jobFile is a graph of dependent sources, replacing the url list.
+type jobFile struct {
+	Sources []*jobSource `yaml:"sources"`
+
+	// order lists the sources, each one after those it needs.
+	order []*jobSource
+}
+
+// loadJobFile reads the job file at p.
+func loadJobFile(p string) (*jobFile, error) {
+	data, err := ioutil.ReadFile(p)
+	if err != nil {
+		return nil, errors.Wrap(err, "--job-file")
+	}
+	var j jobFile
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	dec.KnownFields(true)
+	if err := dec.Decode(&j); err != nil {
+		return nil, errors.Wrapf(err, "--job-file %s", p)
+	}
+	if err := j.check(); err != nil {
+		return nil, errors.Wrapf(err, "--job-file %s", p)
+	}
+	return &j, nil
+}
+
+// check validates the sources and orders them by their needs.
+func (j *jobFile) check() error {
+	if len(j.Sources) == 0 {
+		return errors.New("no sources")
+	}
+	byName := make(map[string]*jobSource, len(j.Sources))
+	for _, s := range j.Sources {
+		if !jobNameExpr.MatchString(s.Name) {
+			return errors.Errorf("invalid source name %q", s.Name)
+		}
+		if byName[s.Name] != nil {
+			return errors.Errorf("duplicate source %s", s.Name)
+		}
+		byName[s.Name] = s
+		if len(strings.TrimSpace(s.URL)) == 0 {
+			return errors.Errorf("source %s has no url", s.Name)
+		}
+		s.paths = make(map[string]*jsonSelector, len(s.Extract))
+		for name, expr := range s.Extract {
+			if !jobNameExpr.MatchString(name) {
+				return errors.Errorf("source %s: invalid value name %q", s.Name, name)
+			}
+			sel, err := newJSONSelector(expr)
+			if err == nil && sel == nil {
+				err = errors.New("empty path")
+			}
+			if err != nil {
+				return errors.Wrapf(err, "source %s: extract %s", s.Name, name)
+			}
+			s.paths[name] = sel
+		}
+	}
+	for _, s := range j.Sources {
+		needs := make(map[string]bool, len(s.Needs))
+		for _, n := range s.Needs {
+			if byName[n] == nil {
+				return errors.Errorf("source %s needs unknown source %s", s.Name, n)
+			}
+			needs[n] = true
+		}
+		for _, m := range jobPlaceholderExpr.FindAllStringSubmatch(s.URL, -1) {
+			if !needs[m[1]] {
+				return errors.Errorf("source %s: %s refers to a source it doesn't need", s.Name, m[0])
+			}
+			if byName[m[1]].paths[m[2]] == nil {
+				return errors.Errorf("source %s: %s isn't extracted by source %s", s.Name, m[0], m[1])
+			}
+		}
+	}
+	// The first source whose needs are ordered comes next, so that the
+	// sources keep the order of the file when they can.
+	ordered := make(map[string]bool, len(j.Sources))
+	j.order = nil
+	for len(j.order) < len(j.Sources) {
+		var next *jobSource
+		for _, s := range j.Sources {
+			if !ordered[s.Name] && allOrdered(s.Needs, ordered) {
+				next = s
+				break
+			}
+		}
+		if next == nil {
+			var cycle []string
+			for _, s := range j.Sources {
+				if !ordered[s.Name] {
+					cycle = append(cycle, s.Name)
+				}
+			}
+			return errors.Errorf("the needs of the sources %s form a cycle",
+				strings.Join(cycle, ", "))
+		}
+		ordered[next.Name] = true
+		j.order = append(j.order, next)
+	}
+	return nil
+}
+
+func allOrdered(names []string, ordered map[string]bool) bool {
+	for _, n := range names {
+		if !ordered[n] {
+			return false
+		}
+	}
+	return true
+}
+
+// expandJobURL returns the urls of the template tmpl, one per combination of
+// the values of its placeholders, escaped. values returns the values of a
+// source.
+func expandJobURL(tmpl string, values func(source, name string) []string) ([]string, error) {
+	list := []string{tmpl}
+	seen := make(map[string]bool)
+	for _, m := range jobPlaceholderExpr.FindAllStringSubmatch(tmpl, -1) {
+		if seen[m[0]] {
+			continue
+		}
+		seen[m[0]] = true
+		vals := values(m[1], m[2])
+		if len(vals) == 0 {
+			return nil, errors.Errorf("%s matched nothing", m[0])
+		}
+		if len(list)*len(vals) > maxJobURLs {
+			return nil, errors.Errorf("more than %d urls", maxJobURLs)
+		}
+		next := make([]string, 0, len(list)*len(vals))
+		for _, u := range list {
+			for _, v := range vals {
+				// Escaped for both the paths and the queries.
+				v = strings.Replace(url.QueryEscape(v), "+", "%20", -1)
+				next = append(next, strings.Replace(u, m[0], v, -1))
+			}
+		}
+		list = next
+	}
+	return list, nil
+}
+
+// extractJobValues returns the values matched by the paths in the json
+// document data, by name. The values must be strings, numbers or bools.
+// Nulls are skipped.
+func extractJobValues(data []byte, paths map[string]*jsonSelector) (map[string][]string, error) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	var v interface{}
+	if err := dec.Decode(&v); err != nil {
+		return nil, errors.Wrap(err, "json.Unmarshal")
+	}
+	values := make(map[string][]string, len(paths))
+	for name, sel := range paths {
+		for _, match := range matchSteps(v, sel.steps) {
+			switch val := match.(type) {
+			case nil:
+			case string:
+				values[name] = append(values[name], val)
+			case json.Number:
+				values[name] = append(values[name], val.String())
+			case bool:
+				values[name] = append(values[name], strconv.FormatBool(val))
+			default:
+				return nil, errors.Errorf("extract %s: %s matched an object or an array", name,
+					sel.expr)
+			}
+		}
+	}
+	return values, nil
+}
+
+// addTo adds the sources of the job to the runner, in the order of their
+// needs. The runner reads a source once the previous ones are over, so the
+// urls of a source are expanded when it is first read.
+func (j *jobFile) addTo(r *Runner) {
+	run := &jobRun{
+		values: make(map[string]map[string][]string),
+		failed: make(map[string]string),
+	}
+	converted := r.Hooks.OnConverted
+	r.Hooks.OnConverted = func(m Metadata, name string) {
+		run.converted(m)
+		if converted != nil {
+			converted(m, name)
+		}
+	}
+	for _, s := range j.order {
+		r.Add(&jobNode{run: run, src: s, client: r.client(), runner: r})
+	}
+}
+
+// jobRun tracks the sources of a job file during a run.
+type jobRun struct {
+	mu sync.Mutex
+	// values holds the values extracted from the converted responses of the
+	// sources that succeeded, by source and name.
+	values map[string]map[string][]string
+	// failed holds why the sources that failed did, by source.
+	failed map[string]string
+	// current is the source being read.
+	current *jobNode
+	// next is the index of the first url of the next source, so that the
+	// outputs of the sources don't collide.
+	next int
+}
+
+// start expands the urls of n, once the previous source is over. It must be
+// called with r.mu held.
+func (r *jobRun) start(n *jobNode) {
+	if r.current != nil {
+		r.finish(r.current)
+	}
+	r.current = n
+	n.started = true
+	n.offset = r.next
+	n.extracted = make(map[int]jobExtract)
+	n.converted = make(map[int]bool)
+	for _, dep := range n.src.Needs {
+		if reason, ok := r.failed[dep]; ok {
+			n.err = errors.Errorf("skipped: source %s failed: %s", dep, reason)
+			break
+		}
+	}
+	var list []string
+	if n.err == nil {
+		list, n.err = expandJobURL(n.src.URL, func(source, name string) []string {
+			return r.values[source][name]
+		})
+	}
+	if n.err != nil {
+		log.Printf("Source %s failed err: %s", n.src.Name, n.err)
+		n.total = 1
+		r.next++
+		return
+	}
+	log.Printf("Fetching source %s: %d urls", n.src.Name, len(list))
+	n.total = len(list)
+	r.next += len(list)
+	n.urls = n.runner.urlSource(list)
+	n.urls.client = n.client
+}
+
+// finish records the outcome of n: it succeeded if all its urls were
+// converted. It must be called with r.mu held.
+func (r *jobRun) finish(n *jobNode) {
+	switch {
+	case n.err != nil:
+		r.failed[n.src.Name] = n.err.Error()
+		return
+	case len(n.converted) < n.total:
+		r.failed[n.src.Name] = fmt.Sprintf("%d of %d urls failed", n.total-len(n.converted),
+			n.total)
+		return
+	}
+	values := make(map[string][]string)
+	seen := make(map[string]bool)
+	for i := n.offset; i < n.offset+n.total && len(n.src.paths) > 0; i++ {
+		e := n.extracted[i]
+		if e.err != nil {
+			r.failed[n.src.Name] = e.err.Error()
+			return
+		}
+		for name := range n.src.paths {
+			for _, v := range e.values[name] {
+				if !seen[name+"\x00"+v] {
+					seen[name+"\x00"+v] = true
+					values[name] = append(values[name], v)
+				}
+			}
+		}
+	}
+	r.values[n.src.Name] = values
+}
+
+// converted records that the payload of m was converted.
+func (r *jobRun) converted(m Metadata) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.current != nil {
+		r.current.converted[m.Index] = true
+	}
+}
+
+// extracted records the values extracted from the response of the payload
+// at index i of n.
+func (r *jobRun) extracted(n *jobNode, i int, e jobExtract) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	n.extracted[i] = e
+}
+
+// jobExtract holds the values extracted from a response.
+type jobExtract struct {
+	values map[string][]string
+	err    error
+}
+
+// jobNode reads the urls of a source of a job file. Its urls are the ones of
+// the outputs following those of the previous sources.
+type jobNode struct {
+	run    *jobRun
+	src    *jobSource
+	client Getter
+	// runner sets the requests of the urls.
+	runner *Runner
+
+	// The fields below are guarded by run.mu.
+	started bool
+	// err is set if the source can't be fetched. It is the error of a single
+	// payload.
+	err     error
+	claimed bool
+	urls    *urlSource
+	offset  int
+	total   int
+	// extracted and converted are set by index of the payloads.
+	extracted map[int]jobExtract
+	converted map[int]bool
+}
+
+var _ refetcher = &jobNode{}
+
+func (n *jobNode) Next() (io.ReadCloser, Metadata, error) {
+	m, err := n.claim()
+	if err != nil {
+		return nil, m, err
+	}
+	return n.fetch(context.Background(), m)
+}
+
+func (n *jobNode) claim() (Metadata, error) {
+	n.run.mu.Lock()
+	if !n.started {
+		n.run.start(n)
+	}
+	if n.err != nil {
+		defer n.run.mu.Unlock()
+		if n.claimed {
+			return Metadata{}, io.EOF
+		}
+		n.claimed = true
+		return Metadata{Index: n.offset, URL: n.src.URL}, nil
+	}
+	n.run.mu.Unlock()
+	m, err := n.urls.claim()
+	m.Index += n.offset
+	return m, err
+}
+
+func (n *jobNode) fetch(ctx context.Context, m Metadata) (io.ReadCloser, Metadata, error) {
+	if n.err != nil {
+		return nil, m, n.err
+	}
+	body, m, err := n.urls.fetch(ctx, m)
+	if err != nil || len(n.src.paths) == 0 {
+		return body, m, err
+	}
+	return &extractReader{ReadCloser: body, node: n, index: m.Index}, m, nil
+}
+
+// extractReader extracts the values of the source from the response as it is
+// read, once it is closed. It can be closed while it is read, to cancel the
+// read.
+type extractReader struct {
+	io.ReadCloser
+	node  *jobNode
+	index int
+
+	mu  sync.Mutex
+	buf bytes.Buffer
+	// tooLarge is set once more than maxExtractBytes were read.
+	tooLarge bool
+}
+
+func (r *extractReader) Read(p []byte) (int, error) {
+	n, err := r.ReadCloser.Read(p)
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if !r.tooLarge {
+		if r.buf.Len()+n > maxExtractBytes {
+			r.tooLarge = true
+			r.buf = bytes.Buffer{}
+		} else {
+			r.buf.Write(p[:n])
+		}
+	}
+	return n, err
+}
+
+func (r *extractReader) Close() error {
+	r.mu.Lock()
+	var e jobExtract
+	if r.tooLarge {
+		e.err = errors.Errorf("response larger than %d bytes", maxExtractBytes)
+	} else {
+		e.values, e.err = extractJobValues(r.buf.Bytes(), r.node.src.paths)
+	}
+	r.mu.Unlock()
+	r.node.run.extracted(r.node, r.index, e)
+	return r.ReadCloser.Close()
+}
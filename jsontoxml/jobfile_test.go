@@ -0,0 +1,127 @@
+package jsontoxml
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestLoadJobFile(t *testing.T) {
+	dir := t.TempDir()
+	write := func(content string) string {
+		p := filepath.Join(dir, "job.yaml")
+		require.NoError(t, ioutil.WriteFile(p, []byte(content), 0644))
+		return p
+	}
+	j, err := loadJobFile(write(`
+sources:
+  - name: orders
+    url: http://a/users/{users.id}/orders
+    needs: [users]
+  - name: users
+    url: http://a/users?token={login.token}
+    needs: [login]
+    extract:
+      id: $.users[*].id
+  - name: login
+    url: http://a/login
+    extract:
+      token: $.token
+  - name: status
+    url: http://a/status
+`))
+	require.NoError(t, err)
+	var order []string
+	for _, s := range j.order {
+		order = append(order, s.Name)
+	}
+	require.Equal(t, []string{"login", "users", "orders", "status"}, order)
+
+	for _, content := range []string{
+		`sources: []`,
+		`sources: [{name: a}]`,
+		`sources: [{name: a.b, url: http://a}]`,
+		`sources: [{name: a, url: http://a}, {name: a, url: http://b}]`,
+		`sources: [{name: a, url: http://a, needs: [b]}]`,
+		`sources: [{name: a, url: http://a, extract: {id: id}}]`,
+		`sources: [{name: a, url: http://a, other: 1}]`,
+		`sources: [{name: a, url: http://a}, {name: b, url: "http://b/{a.id}"}]`,
+		`sources: [{name: a, url: http://a}, {name: b, url: "http://b/{a.id}", needs: [a]}]`,
+		`sources: [{name: a, url: http://a, needs: [b]}, {name: b, url: http://b, needs: [a]}]`,
+	} {
+		_, err := loadJobFile(write(content))
+		require.Error(t, err, content)
+	}
+}
+
+func TestExpandJobURL(t *testing.T) {
+	values := map[string][]string{"a.x": {"1", "2"}, "b.y": {"p q/r"}}
+	get := func(source, name string) []string { return values[source+"."+name] }
+	list, err := expandJobURL("http://h/{a.x}/{b.y}?x={a.x}", get)
+	require.NoError(t, err)
+	require.Equal(t, []string{"http://h/1/p%20q%2Fr?x=1", "http://h/2/p%20q%2Fr?x=2"}, list)
+
+	list, err = expandJobURL("http://h", get)
+	require.NoError(t, err)
+	require.Equal(t, []string{"http://h"}, list)
+
+	_, err = expandJobURL("http://h/{a.z}", get)
+	require.EqualError(t, err, "{a.z} matched nothing")
+}
+
+func TestJobRun(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		switch {
+		case r.URL.Path == "/login":
+			fmt.Fprint(w, `{"id": 1, "city": "t 1"}`)
+		case r.URL.Path == "/users" && r.URL.Query().Get("token") == "t 1":
+			fmt.Fprint(w, `[{"id": 1}, {"id": 2}, {"id": 1}]`)
+		case strings.HasPrefix(r.URL.Path, "/users/"):
+			fmt.Fprintf(w, `{"id": %s}`, strings.TrimPrefix(r.URL.Path, "/users/"))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+
+	j := &jobFile{Sources: []*jobSource{
+		{Name: "login", URL: srv.URL + "/login", Extract: map[string]string{"token": "$.city"}},
+		{Name: "users", URL: srv.URL + "/users?token={login.token}", Needs: []string{"login"},
+			Extract: map[string]string{"id": "$[*].id"}},
+		{Name: "user", URL: srv.URL + "/users/{users.id}", Needs: []string{"users"}},
+		{Name: "missing", URL: srv.URL + "/missing", Extract: map[string]string{"id": "$.id"}},
+		{Name: "after", URL: srv.URL + "/users/{missing.id}", Needs: []string{"missing"}},
+	}}
+	require.NoError(t, j.check())
+
+	var sinks memSinks
+	var mu sync.Mutex
+	var converted []string
+	r := &Runner{Workers: 2, NewSink: sinks.newSink, Transport: http.DefaultTransport}
+	r.Hooks.OnConverted = func(m Metadata, name string) {
+		mu.Lock()
+		defer mu.Unlock()
+		converted = append(converted, name)
+	}
+	j.addTo(r)
+	rep, err := r.Run(context.Background())
+	require.NoError(t, err)
+	require.Equal(t, 6, rep.Processed)
+	require.Equal(t, 2, rep.Failed)
+	require.Len(t, converted, 4)
+	// The outputs of the sources follow each other.
+	require.Contains(t, sinks.docs["2.xml"], "<Id>1</Id>")
+	require.Contains(t, sinks.docs["3.xml"], "<Id>2</Id>")
+	require.Contains(t, rep.Errors[srv.URL+"/missing"].Error(), "status 404")
+	require.EqualError(t, rep.Errors[srv.URL+"/users/{missing.id}"],
+		"skipped: source missing failed: 1 of 1 urls failed")
+}
@@ -0,0 +1,412 @@
+package jsontoxml
+
+import (
+	"archive/zip"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// The jobs API converts batches of urls in the background:
+//
+//	POST /jobs                <- jobRequest. Responds with the jobStatus.
+//	GET  /jobs/{id}           -> jobStatus: the progress of the job.
+//	GET  /jobs/{id}/results   -> zip of the converted documents, once done.
+//
+// The documents are written to a directory per job, removed --job-ttl after
+// the job finished. On servers with tenants, the jobs are only visible to
+// their tenant, and their directories are grouped by tenant.
+
+var (
+	jobsDir string
+	maxJobs int
+	jobTTL  time.Duration
+	// maxJobURLs bounds the size of a job.
+	maxJobURLs = 10000
+)
+
+func init() {
+	serveCmd.Flags().StringVar(&jobsDir, "jobs-dir", filepath.Join(os.TempDir(), "jsonToXml-jobs"),
+		"Directory in which the results of the jobs are written.")
+	serveCmd.Flags().IntVar(&maxJobs, "max-jobs", 2,
+		"Maximum number of jobs running at the same time. Other jobs are queued.")
+	serveCmd.Flags().DurationVar(&jobTTL, "job-ttl", 24*time.Hour,
+		"Time after which a finished job and its results are deleted.")
+}
+
+// jobRequest defines a job: the urls to convert and the conversion options,
+// overriding those of the server.
+type jobRequest struct {
+	URLs    []string `json:"urls"`
+	Options struct {
+		Generic     *bool   `json:"generic,omitempty"`
+		Lossless    *bool   `json:"lossless,omitempty"`
+		XSITypes    *bool   `json:"xsi_types,omitempty"`
+		ArrayRoot   *string `json:"array_root,omitempty"`
+		RootElement *string `json:"root_element,omitempty"`
+	} `json:"options"`
+}
+
+type jobState string
+
+const (
+	jobQueued   jobState = "queued"
+	jobRunning  jobState = "running"
+	jobDone     jobState = "done"
+	jobCanceled jobState = "canceled"
+	jobFailed   jobState = "failed"
+)
+
+type jobStatus struct {
+	ID        string   `json:"id"`
+	State     jobState `json:"state"`
+	Total     int      `json:"total"`
+	Processed int      `json:"processed"`
+	Failed    int      `json:"failed"`
+	// Errors holds the error of every failed url.
+	Errors map[string]string `json:"errors,omitempty"`
+	// Warnings holds the warnings of every url converted with warnings, once
+	// the job is done.
+	Warnings map[string][]string `json:"warnings,omitempty"`
+	// Results links to the converted documents, once the job is done.
+	Results string    `json:"results,omitempty"`
+	Created time.Time `json:"created"`
+	// Finished is set once the job is over.
+	Finished *time.Time `json:"finished,omitempty"`
+	// Error is why the job failed, e.g. its budget was exceeded.
+	Error string `json:"error,omitempty"`
+}
+
+// job is a batch converted in the background.
+type job struct {
+	mu     sync.Mutex
+	status jobStatus
+	dir    string
+	// tenant owns the job, if the server has tenants.
+	tenant *tenant
+}
+
+func (j *job) snapshot() jobStatus {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	s := j.status
+	s.Errors = make(map[string]string, len(j.status.Errors))
+	for url, err := range j.status.Errors {
+		s.Errors[url] = err
+	}
+	return s
+}
+
+// jobStore runs the jobs and serves their status and results. It is safe for
+// concurrent use.
+type jobStore struct {
+	// ctx cancels the running jobs.
+	ctx  context.Context
+	dir  string
+	opts *convertOptions
+	ttl  time.Duration
+	// slots bounds the jobs running at the same time.
+	slots chan struct{}
+	// newRunner returns the runner of a job, before its sink and options are
+	// set.
+	newRunner func() *Runner
+
+	mu   sync.Mutex
+	jobs map[string]*job
+	wg   sync.WaitGroup
+}
+
+func newJobStore(ctx context.Context, dir string, opts *convertOptions, running int,
+	ttl time.Duration) (*jobStore, error) {
+	if running < 1 {
+		return nil, errors.New("--max-jobs must be at least 1")
+	}
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, errors.Wrap(err, "--jobs-dir")
+	}
+	return &jobStore{
+		ctx:       ctx,
+		dir:       dir,
+		opts:      opts,
+		ttl:       ttl,
+		slots:     make(chan struct{}, running),
+		newRunner: newDefaultRunner,
+		jobs:      make(map[string]*job),
+	}, nil
+}
+
+// register adds the routes of the jobs API to mux.
+func (s *jobStore) register(mux *http.ServeMux) {
+	mux.HandleFunc("POST /jobs", s.create)
+	mux.HandleFunc("GET /jobs/{id}", func(w http.ResponseWriter, r *http.Request) {
+		j := s.get(r)
+		if j == nil {
+			http.NotFound(w, r)
+			return
+		}
+		writeJSON(w, j.snapshot())
+	})
+	mux.HandleFunc("GET /jobs/{id}/results", s.results)
+}
+
+// get returns the job of the request, or nil if there is none or it belongs
+// to another tenant.
+func (s *jobStore) get(r *http.Request) *job {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	j := s.jobs[r.PathValue("id")]
+	if j == nil || !sameTenant(j.tenant, tenantFromContext(r.Context())) {
+		return nil
+	}
+	return j
+}
+
+func (s *jobStore) create(w http.ResponseWriter, r *http.Request) {
+	var req jobRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "invalid job: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if len(req.URLs) == 0 || len(req.URLs) > maxJobURLs {
+		http.Error(w, fmt.Sprintf("invalid job: expected 1 to %d urls", maxJobURLs),
+			http.StatusBadRequest)
+		return
+	}
+	opts := *s.opts
+	if o := req.Options; o.Lossless != nil {
+		opts.lossless = *o.Lossless
+	}
+	if o := req.Options; o.Generic != nil {
+		opts.generic = *o.Generic
+	}
+	opts.generic = opts.generic || opts.lossless
+	if o := req.Options; o.XSITypes != nil {
+		opts.xsiTypes = *o.XSITypes
+	}
+	if o := req.Options; o.ArrayRoot != nil {
+		if _, ok := xmlName(*o.ArrayRoot); !ok {
+			http.Error(w, "invalid job: invalid array_root", http.StatusBadRequest)
+			return
+		}
+		opts.arrayRoot = *o.ArrayRoot
+	}
+	if o := req.Options; o.RootElement != nil {
+		if _, ok := xmlName(*o.RootElement); !ok {
+			http.Error(w, "invalid job: invalid root_element", http.StatusBadRequest)
+			return
+		}
+		opts.rootElement = *o.RootElement
+	}
+	id, err := newJobID()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	s.expire(time.Now())
+	j := &job{
+		status: jobStatus{
+			ID:      id,
+			State:   jobQueued,
+			Total:   len(req.URLs),
+			Errors:  make(map[string]string),
+			Created: time.Now().UTC(),
+		},
+		dir:    filepath.Join(s.dir, id),
+		tenant: tenantFromContext(r.Context()),
+	}
+	if j.tenant != nil {
+		j.dir = filepath.Join(s.dir, j.tenant.name, id)
+	}
+	s.mu.Lock()
+	if t := j.tenant; t != nil && t.maxJobs > 0 && s.inProgress(t) >= t.maxJobs {
+		s.mu.Unlock()
+		http.Error(w, fmt.Sprintf("tenant %q has %d jobs in progress already", t.name, t.maxJobs),
+			http.StatusTooManyRequests)
+		return
+	}
+	s.jobs[id] = j
+	s.mu.Unlock()
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		s.run(j, req.URLs, &opts)
+	}()
+	w.Header().Set("Location", "/jobs/"+id)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(j.snapshot())
+}
+
+// inProgress returns the number of jobs of t that aren't finished. s.mu must
+// be held.
+func (s *jobStore) inProgress(t *tenant) int {
+	n := 0
+	for _, j := range s.jobs {
+		if sameTenant(j.tenant, t) && j.snapshot().Finished == nil {
+			n++
+		}
+	}
+	return n
+}
+
+func newJobID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", errors.Wrap(err, "job id")
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// run converts the urls of the job once a slot is free.
+func (s *jobStore) run(j *job, urls []string, opts *convertOptions) {
+	finish := func(state jobState) {
+		now := time.Now().UTC()
+		j.mu.Lock()
+		j.status.State, j.status.Finished = state, &now
+		if state == jobDone {
+			j.status.Results = "/jobs/" + j.status.ID + "/results"
+		}
+		j.mu.Unlock()
+	}
+	select {
+	case s.slots <- struct{}{}:
+		defer func() { <-s.slots }()
+	case <-s.ctx.Done():
+		finish(jobCanceled)
+		return
+	}
+	j.mu.Lock()
+	j.status.State = jobRunning
+	j.mu.Unlock()
+
+	r := s.newRunner()
+	// The routes, the ledger and the checksums of the flags write outside of
+	// the directory of the job.
+	r.Routes, r.Ledger, r.Checksums = nil, nil, ChecksumNone
+	r.opts = opts
+	r.NewSink = func() Sink { return &fileSink{dir: j.dir} }
+	r.Hooks = Hooks{
+		OnConverted: func(m Metadata, name string) {
+			j.mu.Lock()
+			j.status.Processed++
+			j.mu.Unlock()
+		},
+		OnError: func(m Metadata, attempt int, err error, retry bool) {
+			if retry {
+				return
+			}
+			j.mu.Lock()
+			j.status.Processed++
+			j.status.Failed++
+			j.status.Errors[m.URL] = err.Error()
+			j.mu.Unlock()
+		},
+	}
+	r.AddURLs(urls...)
+	rep, err := r.Run(s.ctx)
+	if s.ctx.Err() != nil {
+		finish(jobCanceled)
+		return
+	}
+	if err != nil {
+		j.mu.Lock()
+		j.status.Error = err.Error()
+		j.mu.Unlock()
+		finish(jobFailed)
+		return
+	}
+	if len(rep.Warnings) > 0 {
+		j.mu.Lock()
+		j.status.Warnings = rep.Warnings
+		j.mu.Unlock()
+	}
+	finish(jobDone)
+}
+
+// results writes the documents of a finished job as a zip archive.
+func (s *jobStore) results(w http.ResponseWriter, r *http.Request) {
+	j := s.get(r)
+	if j == nil {
+		http.NotFound(w, r)
+		return
+	}
+	if st := j.snapshot(); st.State != jobDone {
+		http.Error(w, "job is "+string(st.State), http.StatusConflict)
+		return
+	}
+	var names []string
+	err := filepath.Walk(j.dir, func(p string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() {
+			return err
+		}
+		rel, err := filepath.Rel(j.dir, p)
+		names = append(names, filepath.ToSlash(rel))
+		return err
+	})
+	if err != nil && !os.IsNotExist(err) {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	sort.Strings(names)
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", `attachment; filename="`+j.status.ID+`.zip"`)
+	zw := zip.NewWriter(w)
+	for _, name := range names {
+		if err := addToZip(zw, filepath.Join(j.dir, filepath.FromSlash(name)), name); err != nil {
+			// The status is sent already: the truncated archive is invalid.
+			log.Printf("Failed writing results of job %s err: %s", j.status.ID, err)
+			return
+		}
+	}
+	if err := zw.Close(); err != nil {
+		log.Printf("Failed writing results of job %s err: %s", j.status.ID, err)
+	}
+}
+
+func addToZip(zw *zip.Writer, path, name string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	fw, err := zw.Create(name)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(fw, f)
+	return err
+}
+
+// expire deletes the jobs finished more than ttl before now, with their
+// results.
+func (s *jobStore) expire(now time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for id, j := range s.jobs {
+		st := j.snapshot()
+		if st.Finished == nil || now.Sub(*st.Finished) < s.ttl {
+			continue
+		}
+		delete(s.jobs, id)
+		if err := os.RemoveAll(j.dir); err != nil {
+			log.Printf("Failed deleting results of job %s err: %s", id, err)
+		}
+	}
+}
+
+// wait waits for the jobs to stop, once ctx is done.
+func (s *jobStore) wait() {
+	s.wg.Wait()
+}
@@ -0,0 +1,160 @@
+package jsontoxml
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestJobs(t *testing.T) {
+	source := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/missing" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`[{"a": 1}]`))
+	}))
+	defer source.Close()
+	dir, err := ioutil.TempDir("", "jobs")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	jobs, err := newJobStore(ctx, dir, &convertOptions{arrayRoot: "records"}, 1, time.Hour)
+	require.NoError(t, err)
+	jobs.newRunner = func() *Runner { return &Runner{Workers: 2, capture: newFailureCapture()} }
+	mux := http.NewServeMux()
+	jobs.register(mux)
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	post := func(body string) *http.Response {
+		resp, err := http.Post(srv.URL+"/jobs", "application/json", strings.NewReader(body))
+		require.NoError(t, err)
+		return resp
+	}
+	status := func(path string) (st jobStatus) {
+		resp, err := http.Get(srv.URL + path)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(&st))
+		return st
+	}
+
+	resp := post(`{"urls": ["` + source.URL + `/a", "` + source.URL + `/missing"],
+		"options": {"generic": true}}`)
+	resp.Body.Close()
+	require.Equal(t, http.StatusAccepted, resp.StatusCode)
+	loc := resp.Header.Get("Location")
+	require.Regexp(t, "^/jobs/[0-9a-f]{32}$", loc)
+	var st jobStatus
+	require.Eventually(t, func() bool {
+		st = status(loc)
+		return st.State == jobDone
+	}, 5*time.Second, 10*time.Millisecond)
+	require.Equal(t, 2, st.Total)
+	require.Equal(t, 2, st.Processed)
+	require.Equal(t, 1, st.Failed)
+	require.Equal(t, map[string]string{source.URL + "/missing": "get failed: status 404 Not Found"},
+		st.Errors)
+	require.Equal(t, loc+"/results", st.Results)
+	require.NotNil(t, st.Finished)
+
+	resp, err = http.Get(srv.URL + st.Results)
+	require.NoError(t, err)
+	data, err := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	require.NoError(t, err)
+	require.Equal(t, "application/zip", resp.Header.Get("Content-Type"))
+	zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	require.NoError(t, err)
+	require.Len(t, zr.File, 1)
+	require.Equal(t, "0.xml", zr.File[0].Name)
+	f, err := zr.File[0].Open()
+	require.NoError(t, err)
+	doc, err := ioutil.ReadAll(f)
+	require.NoError(t, err)
+	require.Equal(t, " <jsonData>\n  <item>\n   <a>1</a>\n  </item>\n </jsonData>", string(doc))
+
+	for _, body := range []string{`{"urls": []}`, `{`, `{"urls": ["x"], "options": {"array_root": "1"}}`} {
+		resp := post(body)
+		resp.Body.Close()
+		require.Equal(t, http.StatusBadRequest, resp.StatusCode, body)
+	}
+	resp, err = http.Get(srv.URL + "/jobs/nope")
+	require.NoError(t, err)
+	resp.Body.Close()
+	require.Equal(t, http.StatusNotFound, resp.StatusCode)
+
+	// Finished jobs expire with their results.
+	id := strings.TrimPrefix(loc, "/jobs/")
+	jobs.expire(time.Now().Add(2 * time.Hour))
+	jobs.mu.Lock()
+	require.Nil(t, jobs.jobs[id])
+	jobs.mu.Unlock()
+	_, err = os.Stat(filepath.Join(dir, id))
+	require.True(t, os.IsNotExist(err))
+
+	cancel()
+	jobs.wait()
+}
+
+func TestJobsFailed(t *testing.T) {
+	source := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"a": 1}`))
+	}))
+	defer source.Close()
+	dir, err := ioutil.TempDir("", "jobs")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	jobs, err := newJobStore(ctx, dir, &convertOptions{}, 1, time.Hour)
+	require.NoError(t, err)
+	// The checksums of the flags aren't written to the jobs.
+	jobs.newRunner = func() *Runner {
+		return &Runner{Checksums: ChecksumSidecar, Budget: Budget{MaxPayloads: 1},
+			capture: newFailureCapture()}
+	}
+	mux := http.NewServeMux()
+	jobs.register(mux)
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	resp, err := http.Post(srv.URL+"/jobs", "application/json", strings.NewReader(
+		`{"urls": ["`+source.URL+`/a", "`+source.URL+`/b"], "options": {"generic": true}}`))
+	require.NoError(t, err)
+	resp.Body.Close()
+	require.Equal(t, http.StatusAccepted, resp.StatusCode)
+	var st jobStatus
+	require.Eventually(t, func() bool {
+		resp, err := http.Get(srv.URL + resp.Header.Get("Location"))
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(&st))
+		return st.State != jobQueued && st.State != jobRunning
+	}, 5*time.Second, 10*time.Millisecond)
+	// A job over budget failed, it wasn't canceled.
+	require.Equal(t, jobFailed, st.State)
+	require.Contains(t, st.Error, "budget")
+	require.Empty(t, st.Results)
+	sidecars, err := filepath.Glob(filepath.Join(dir, "*", "*.sha256"))
+	require.NoError(t, err)
+	require.Empty(t, sidecars)
+}
@@ -0,0 +1,649 @@
+package jsontoxml
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/pkg/errors"
+)
+
+// maxSchemaErrors bounds the schema violations reported for a payload.
+const maxSchemaErrors = 10
+
+var jsonSchemaFile string
+
+func init() {
+	rootCmd.PersistentFlags().StringVar(&jsonSchemaFile, "json-schema", "",
+		"JSON Schema file the payloads must match to be converted, e.g. schema.json. The"+
+			" other payloads fail with their violations, and the jsonData records are no longer"+
+			" rejected for setting none of its fields.")
+}
+
+// schemaKeywords are the JSON Schema keywords that are checked. The
+// annotations are accepted and ignored.
+var (
+	schemaKeywords = map[string]bool{
+		"type": true, "enum": true, "const": true, "$ref": true,
+		"properties": true, "required": true, "additionalProperties": true,
+		"patternProperties": true, "minProperties": true, "maxProperties": true,
+		"items": true, "minItems": true, "maxItems": true, "uniqueItems": true,
+		"minimum": true, "maximum": true, "exclusiveMinimum": true, "exclusiveMaximum": true,
+		"multipleOf": true, "minLength": true, "maxLength": true, "pattern": true,
+		"allOf": true, "anyOf": true, "oneOf": true, "not": true,
+	}
+	schemaAnnotations = map[string]bool{
+		"$schema": true, "$id": true, "id": true, "$comment": true, "title": true,
+		"description": true, "default": true, "examples": true, "format": true,
+		"definitions": true, "$defs": true, "readOnly": true, "writeOnly": true,
+		"deprecated": true,
+	}
+)
+
+// jsonSchema is a compiled JSON Schema. A boolean schema accepts every value
+// if true and none if false.
+type jsonSchema struct {
+	always *bool
+	ref    *jsonSchema
+
+	types []string
+	enum  []interface{}
+	cnst  interface{}
+	// hasConst tells a const of null from no const.
+	hasConst bool
+
+	properties    map[string]*jsonSchema
+	required      []string
+	additional    *jsonSchema
+	patternProps  []patternSchema
+	minProperties int
+	maxProperties int
+
+	items    *jsonSchema
+	minItems int
+	maxItems int
+	unique   bool
+
+	minimum, maximum     *float64
+	exclusiveMin         *float64
+	exclusiveMax         *float64
+	multipleOf           *float64
+	minLength, maxLength int
+	pattern              *regexp.Regexp
+
+	allOf, anyOf, oneOf []*jsonSchema
+	not                 *jsonSchema
+}
+
+type patternSchema struct {
+	expr   *regexp.Regexp
+	schema *jsonSchema
+}
+
+// loadJSONSchema reads and compiles the JSON Schema file at p.
+func loadJSONSchema(p string) (*jsonSchema, error) {
+	data, err := ioutil.ReadFile(p)
+	if err != nil {
+		return nil, errors.Wrap(err, "--json-schema")
+	}
+	s, err := compileJSONSchema(data)
+	return s, errors.Wrapf(err, "--json-schema %s", p)
+}
+
+// compileJSONSchema compiles the JSON Schema document data. The $refs must
+// point inside the document, e.g. #/definitions/address.
+func compileJSONSchema(data []byte) (*jsonSchema, error) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	var doc interface{}
+	if err := dec.Decode(&doc); err != nil {
+		return nil, errors.Wrap(err, "json.Unmarshal")
+	}
+	c := &schemaCompiler{doc: doc, refs: make(map[string]*jsonSchema)}
+	return c.compile("#", doc)
+}
+
+// schemaCompiler compiles the schemas of a document. refs holds the schemas
+// by json pointer, so that the recursive schemas are compiled once.
+type schemaCompiler struct {
+	doc  interface{}
+	refs map[string]*jsonSchema
+}
+
+func (c *schemaCompiler) compile(ptr string, v interface{}) (*jsonSchema, error) {
+	if s, ok := c.refs[ptr]; ok {
+		return s, nil
+	}
+	s := &jsonSchema{minProperties: -1, maxProperties: -1, minItems: -1, maxItems: -1,
+		minLength: -1, maxLength: -1}
+	c.refs[ptr] = s
+	if b, ok := v.(bool); ok {
+		s.always = &b
+		return s, nil
+	}
+	obj, ok := v.(map[string]interface{})
+	if !ok {
+		return nil, errors.Errorf("%s: expected an object or a bool", ptr)
+	}
+	keys := make([]string, 0, len(obj))
+	for k := range obj {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		if err := c.keyword(s, ptr, k, obj[k]); err != nil {
+			return nil, err
+		}
+	}
+	return s, nil
+}
+
+// keyword compiles the keyword k of the schema s at ptr.
+func (c *schemaCompiler) keyword(s *jsonSchema, ptr, k string, v interface{}) error {
+	if schemaAnnotations[k] {
+		return nil
+	}
+	if !schemaKeywords[k] {
+		return errors.Errorf("%s: unsupported keyword %q", ptr, k)
+	}
+	at := ptr + "/" + k
+	invalid := func(expected string) error {
+		return errors.Errorf("%s: expected %s", at, expected)
+	}
+	var err error
+	switch k {
+	case "type":
+		switch t := v.(type) {
+		case string:
+			s.types = []string{t}
+		case []interface{}:
+			for _, item := range t {
+				name, ok := item.(string)
+				if !ok {
+					return invalid("a type or a list of types")
+				}
+				s.types = append(s.types, name)
+			}
+		default:
+			return invalid("a type or a list of types")
+		}
+		for _, t := range s.types {
+			switch t {
+			case "object", "array", "string", "number", "integer", "boolean", "null":
+			default:
+				return errors.Errorf("%s: unknown type %q", at, t)
+			}
+		}
+	case "enum":
+		list, ok := v.([]interface{})
+		if !ok {
+			return invalid("a list")
+		}
+		s.enum = list
+	case "const":
+		s.cnst, s.hasConst = v, true
+	case "$ref":
+		ref, ok := v.(string)
+		if !ok || !strings.HasPrefix(ref, "#") {
+			return invalid("a reference inside the schema, starting with #")
+		}
+		target, ok := resolvePointer(c.doc, ref)
+		if !ok {
+			return errors.Errorf("%s: %s not found", at, ref)
+		}
+		s.ref, err = c.compile(ref, target)
+	case "properties":
+		props, ok := v.(map[string]interface{})
+		if !ok {
+			return invalid("an object")
+		}
+		s.properties = make(map[string]*jsonSchema, len(props))
+		for name, p := range props {
+			if s.properties[name], err = c.compile(at+"/"+escapePointer(name), p); err != nil {
+				return err
+			}
+		}
+	case "required":
+		list, ok := v.([]interface{})
+		if !ok {
+			return invalid("a list of names")
+		}
+		for _, item := range list {
+			name, ok := item.(string)
+			if !ok {
+				return invalid("a list of names")
+			}
+			s.required = append(s.required, name)
+		}
+	case "additionalProperties":
+		s.additional, err = c.compile(at, v)
+	case "patternProperties":
+		props, ok := v.(map[string]interface{})
+		if !ok {
+			return invalid("an object")
+		}
+		for expr, p := range props {
+			re, err := regexp.Compile(expr)
+			if err != nil {
+				return errors.Wrapf(err, "%s", at)
+			}
+			ps, err := c.compile(at+"/"+escapePointer(expr), p)
+			if err != nil {
+				return err
+			}
+			s.patternProps = append(s.patternProps, patternSchema{expr: re, schema: ps})
+		}
+	case "items":
+		s.items, err = c.compile(at, v)
+	case "uniqueItems":
+		b, ok := v.(bool)
+		if !ok {
+			return invalid("a bool")
+		}
+		s.unique = b
+	case "minProperties", "maxProperties", "minItems", "maxItems", "minLength", "maxLength":
+		n, ok := schemaCount(v)
+		if !ok {
+			return invalid("a positive integer")
+		}
+		switch k {
+		case "minProperties":
+			s.minProperties = n
+		case "maxProperties":
+			s.maxProperties = n
+		case "minItems":
+			s.minItems = n
+		case "maxItems":
+			s.maxItems = n
+		case "minLength":
+			s.minLength = n
+		default:
+			s.maxLength = n
+		}
+	case "minimum", "maximum", "exclusiveMinimum", "exclusiveMaximum", "multipleOf":
+		f, ok := schemaNumber(v)
+		if !ok || k == "multipleOf" && f <= 0 {
+			return invalid("a number")
+		}
+		switch k {
+		case "minimum":
+			s.minimum = &f
+		case "maximum":
+			s.maximum = &f
+		case "exclusiveMinimum":
+			s.exclusiveMin = &f
+		case "exclusiveMaximum":
+			s.exclusiveMax = &f
+		default:
+			s.multipleOf = &f
+		}
+	case "pattern":
+		expr, ok := v.(string)
+		if !ok {
+			return invalid("a regular expression")
+		}
+		if s.pattern, err = regexp.Compile(expr); err != nil {
+			return errors.Wrapf(err, "%s", at)
+		}
+	case "allOf", "anyOf", "oneOf":
+		list, ok := v.([]interface{})
+		if !ok || len(list) == 0 {
+			return invalid("a list of schemas")
+		}
+		var subs []*jsonSchema
+		for i, item := range list {
+			sub, err := c.compile(at+"/"+strconv.Itoa(i), item)
+			if err != nil {
+				return err
+			}
+			subs = append(subs, sub)
+		}
+		switch k {
+		case "allOf":
+			s.allOf = subs
+		case "anyOf":
+			s.anyOf = subs
+		default:
+			s.oneOf = subs
+		}
+	case "not":
+		s.not, err = c.compile(at, v)
+	}
+	return err
+}
+
+// resolvePointer returns the value at the json pointer ref, e.g.
+// #/definitions/address, of doc.
+func resolvePointer(doc interface{}, ref string) (interface{}, bool) {
+	ref = strings.TrimPrefix(ref, "#")
+	if len(ref) == 0 {
+		return doc, true
+	}
+	if ref[0] != '/' {
+		return nil, false
+	}
+	v := doc
+	for _, token := range strings.Split(ref[1:], "/") {
+		token = strings.NewReplacer("~1", "/", "~0", "~").Replace(token)
+		switch val := v.(type) {
+		case map[string]interface{}:
+			item, ok := val[token]
+			if !ok {
+				return nil, false
+			}
+			v = item
+		case []interface{}:
+			i, err := strconv.Atoi(token)
+			if err != nil || i < 0 || i >= len(val) {
+				return nil, false
+			}
+			v = val[i]
+		default:
+			return nil, false
+		}
+	}
+	return v, true
+}
+
+// escapePointer escapes a key for a json pointer.
+func escapePointer(key string) string {
+	return strings.NewReplacer("~", "~0", "/", "~1").Replace(key)
+}
+
+// schemaNumber returns the float value of the json number v.
+func schemaNumber(v interface{}) (float64, bool) {
+	n, ok := v.(json.Number)
+	if !ok {
+		return 0, false
+	}
+	f, err := n.Float64()
+	return f, err == nil
+}
+
+// schemaCount returns the value of the non negative json integer v.
+func schemaCount(v interface{}) (int, bool) {
+	f, ok := schemaNumber(v)
+	if !ok || f < 0 || f != math.Trunc(f) {
+		return 0, false
+	}
+	return int(f), true
+}
+
+// schemaType returns the JSON Schema type of the json value v. The numbers
+// without a fraction are integers.
+func schemaType(v interface{}) string {
+	switch val := v.(type) {
+	case json.Number:
+		if f, err := val.Float64(); err == nil && f == math.Trunc(f) {
+			return "integer"
+		}
+		return "number"
+	case bool:
+		return "boolean"
+	}
+	return jsonType(v)
+}
+
+// jsonEqual returns true if the json values are equal, comparing the numbers
+// by value.
+func jsonEqual(a, b interface{}) bool {
+	switch av := a.(type) {
+	case json.Number:
+		bn, ok := b.(json.Number)
+		if !ok {
+			return false
+		}
+		af, aerr := av.Float64()
+		bf, berr := bn.Float64()
+		return aerr == nil && berr == nil && af == bf
+	case map[string]interface{}:
+		bv, ok := b.(map[string]interface{})
+		if !ok || len(av) != len(bv) {
+			return false
+		}
+		for k, item := range av {
+			other, ok := bv[k]
+			if !ok || !jsonEqual(item, other) {
+				return false
+			}
+		}
+		return true
+	case []interface{}:
+		bv, ok := b.([]interface{})
+		if !ok || len(av) != len(bv) {
+			return false
+		}
+		for i := range av {
+			if !jsonEqual(av[i], bv[i]) {
+				return false
+			}
+		}
+		return true
+	}
+	return a == b
+}
+
+// schemaErrors collects the violations of a value, up to maxSchemaErrors.
+type schemaErrors []string
+
+func (e *schemaErrors) add(path, format string, args ...interface{}) {
+	if len(*e) < maxSchemaErrors {
+		if len(path) == 0 {
+			path = "/"
+		}
+		*e = append(*e, path+": "+fmt.Sprintf(format, args...))
+	}
+}
+
+// validate returns the violations of the schema by the json value v,
+// decoded with json.Number numbers.
+func (s *jsonSchema) validate(v interface{}) []string {
+	var errs schemaErrors
+	s.check(v, "", &errs)
+	return errs
+}
+
+// valid returns true if v matches the schema.
+func (s *jsonSchema) valid(v interface{}) bool {
+	var errs schemaErrors
+	s.check(v, "", &errs)
+	return len(errs) == 0
+}
+
+// check adds the violations of the schema by the value v at the json pointer
+// path to errs.
+func (s *jsonSchema) check(v interface{}, path string, errs *schemaErrors) {
+	if s.always != nil {
+		if !*s.always {
+			errs.add(path, "not allowed")
+		}
+		return
+	}
+	if s.ref != nil {
+		s.ref.check(v, path, errs)
+	}
+	if len(s.types) > 0 {
+		t, ok := schemaType(v), false
+		for _, want := range s.types {
+			ok = ok || want == t || want == "number" && t == "integer"
+		}
+		if !ok {
+			errs.add(path, "expected %s, got %s", strings.Join(s.types, " or "), t)
+			return
+		}
+	}
+	if s.enum != nil {
+		ok := false
+		for _, item := range s.enum {
+			ok = ok || jsonEqual(v, item)
+		}
+		if !ok {
+			errs.add(path, "%s is not one of %s", compactJSON(v), compactJSON(s.enum))
+		}
+	}
+	if s.hasConst && !jsonEqual(v, s.cnst) {
+		errs.add(path, "expected %s", compactJSON(s.cnst))
+	}
+	switch val := v.(type) {
+	case map[string]interface{}:
+		s.checkObject(val, path, errs)
+	case []interface{}:
+		s.checkArray(val, path, errs)
+	case json.Number:
+		s.checkNumber(val, path, errs)
+	case string:
+		n := utf8.RuneCountInString(val)
+		if s.minLength >= 0 && n < s.minLength {
+			errs.add(path, "shorter than %d characters", s.minLength)
+		}
+		if s.maxLength >= 0 && n > s.maxLength {
+			errs.add(path, "longer than %d characters", s.maxLength)
+		}
+		if s.pattern != nil && !s.pattern.MatchString(val) {
+			errs.add(path, "doesn't match %s", s.pattern)
+		}
+	}
+	for _, sub := range s.allOf {
+		sub.check(v, path, errs)
+	}
+	if s.anyOf != nil {
+		ok := false
+		for _, sub := range s.anyOf {
+			ok = ok || sub.valid(v)
+		}
+		if !ok {
+			errs.add(path, "matches none of anyOf")
+		}
+	}
+	if s.oneOf != nil {
+		n := 0
+		for _, sub := range s.oneOf {
+			if sub.valid(v) {
+				n++
+			}
+		}
+		if n != 1 {
+			errs.add(path, "matches %d of oneOf, expected 1", n)
+		}
+	}
+	if s.not != nil && s.not.valid(v) {
+		errs.add(path, "matches not")
+	}
+}
+
+func (s *jsonSchema) checkObject(obj map[string]interface{}, path string,
+	errs *schemaErrors) {
+	for _, name := range s.required {
+		if _, ok := obj[name]; !ok {
+			errs.add(path, "missing required %q", name)
+		}
+	}
+	if s.minProperties >= 0 && len(obj) < s.minProperties {
+		errs.add(path, "fewer than %d properties", s.minProperties)
+	}
+	if s.maxProperties >= 0 && len(obj) > s.maxProperties {
+		errs.add(path, "more than %d properties", s.maxProperties)
+	}
+	keys := make([]string, 0, len(obj))
+	for k := range obj {
+		keys = append(keys, k)
+	}
+	// The violations are reported in key order.
+	sort.Strings(keys)
+	for _, k := range keys {
+		at := path + "/" + escapePointer(k)
+		matched := false
+		if p, ok := s.properties[k]; ok {
+			p.check(obj[k], at, errs)
+			matched = true
+		}
+		for _, pp := range s.patternProps {
+			if pp.expr.MatchString(k) {
+				pp.schema.check(obj[k], at, errs)
+				matched = true
+			}
+		}
+		if !matched && s.additional != nil {
+			if s.additional.always != nil && !*s.additional.always {
+				errs.add(at, "additional property not allowed")
+				continue
+			}
+			s.additional.check(obj[k], at, errs)
+		}
+	}
+}
+
+func (s *jsonSchema) checkArray(items []interface{}, path string, errs *schemaErrors) {
+	if s.minItems >= 0 && len(items) < s.minItems {
+		errs.add(path, "fewer than %d items", s.minItems)
+	}
+	if s.maxItems >= 0 && len(items) > s.maxItems {
+		errs.add(path, "more than %d items", s.maxItems)
+	}
+	if s.unique {
+		for i := range items {
+			for j := 0; j < i; j++ {
+				if jsonEqual(items[i], items[j]) {
+					errs.add(path, "items %d and %d are equal", j, i)
+				}
+			}
+		}
+	}
+	if s.items != nil {
+		for i, item := range items {
+			s.items.check(item, path+"/"+strconv.Itoa(i), errs)
+		}
+	}
+}
+
+func (s *jsonSchema) checkNumber(n json.Number, path string, errs *schemaErrors) {
+	f, err := n.Float64()
+	if err != nil {
+		return
+	}
+	switch {
+	case s.minimum != nil && f < *s.minimum:
+		errs.add(path, "less than %v", *s.minimum)
+	case s.exclusiveMin != nil && f <= *s.exclusiveMin:
+		errs.add(path, "not greater than %v", *s.exclusiveMin)
+	case s.maximum != nil && f > *s.maximum:
+		errs.add(path, "greater than %v", *s.maximum)
+	case s.exclusiveMax != nil && f >= *s.exclusiveMax:
+		errs.add(path, "not less than %v", *s.exclusiveMax)
+	}
+	if s.multipleOf != nil {
+		if q := f / *s.multipleOf; math.Abs(q-math.Round(q)) > 1e-9 {
+			errs.add(path, "not a multiple of %v", *s.multipleOf)
+		}
+	}
+}
+
+// newSchemaValidator returns a Validator rejecting the payloads that don't
+// match the schema, with their violations. The whole payload is read.
+func newSchemaValidator(s *jsonSchema) Validator {
+	return func(m Metadata, body *bufio.Reader) (io.Reader, error) {
+		data, err := ioutil.ReadAll(body)
+		if err != nil {
+			return nil, errors.Wrap(err, "read")
+		}
+		dec := json.NewDecoder(bytes.NewReader(data))
+		dec.UseNumber()
+		var doc interface{}
+		if err := dec.Decode(&doc); err != nil {
+			return nil, errors.Wrap(err, "json.Unmarshal")
+		}
+		if errs := s.validate(doc); len(errs) > 0 {
+			return nil, errors.Errorf("json schema: %s", strings.Join(errs, "; "))
+		}
+		return bytes.NewReader(data), nil
+	}
+}
@@ -0,0 +1,115 @@
+package jsontoxml
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+const testSchema = `{
+  "$schema": "http://json-schema.org/draft-07/schema#",
+  "type": "array",
+  "items": {"$ref": "#/definitions/person"},
+  "minItems": 1,
+  "definitions": {
+    "person": {
+      "type": "object",
+      "required": ["id", "firstName"],
+      "properties": {
+        "id": {"type": "integer", "minimum": 1},
+        "firstName": {"type": "string", "minLength": 1},
+        "state": {"enum": ["CA", "NY"]},
+        "tags": {"type": "array", "items": {"type": "string"}, "uniqueItems": true},
+        "manager": {"anyOf": [{"type": "null"}, {"$ref": "#/definitions/person"}]}
+      },
+      "additionalProperties": false
+    }
+  }
+}`
+
+func TestJSONSchema(t *testing.T) {
+	s, err := compileJSONSchema([]byte(testSchema))
+	require.NoError(t, err)
+	validate := func(doc string) []string {
+		dec := json.NewDecoder(bytes.NewReader([]byte(doc)))
+		dec.UseNumber()
+		var v interface{}
+		require.NoError(t, dec.Decode(&v))
+		return s.validate(v)
+	}
+	require.Empty(t, validate(`[{"id": 1, "firstName": "a", "state": "CA", "tags": ["x"],
+		"manager": {"id": 2.0, "firstName": "b", "manager": null}}]`))
+	require.Equal(t, []string{"/: fewer than 1 items"}, validate(`[]`))
+	require.Equal(t, []string{"/: expected array, got object"}, validate(`{"id": 1}`))
+	require.Equal(t, []string{
+		`/0: missing required "firstName"`,
+		`/0/city: additional property not allowed`,
+		`/0/id: expected integer, got number`,
+		`/0/state: "TX" is not one of ["CA","NY"]`,
+		`/0/tags: items 0 and 1 are equal`,
+		`/1/id: less than 1`,
+		`/1/manager: matches none of anyOf`,
+	}, validate(`[{"id": 1.5, "state": "TX", "city": "x", "tags": ["a", "a"]},
+		{"id": 0, "firstName": "b", "manager": {"id": 3}}]`))
+}
+
+func TestJSONSchemaInvalid(t *testing.T) {
+	for _, schema := range []string{
+		`{"type": "date"}`,
+		`{"if": {"type": "string"}}`,
+		`{"$ref": "http://example.com/schema.json"}`,
+		`{"$ref": "#/definitions/missing"}`,
+		`{"minLength": -1}`,
+		`{"pattern": "("}`,
+		`[]`,
+	} {
+		_, err := compileJSONSchema([]byte(schema))
+		require.Error(t, err, schema)
+	}
+}
+
+func TestRunnerJSONSchema(t *testing.T) {
+	s, err := compileJSONSchema([]byte(`{"type": "object", "required": ["id"]}`))
+	require.NoError(t, err)
+	var sinks memSinks
+	r := &Runner{
+		NewSink:  sinks.newSink,
+		Validate: newSchemaValidator(s),
+		opts:     &convertOptions{emptyRecords: emptyRecordsNone},
+	}
+	r.Add(&queueSource{ch: func() chan []byte {
+		ch := make(chan []byte, 2)
+		ch <- []byte(`{"id": 1, "extra": true}`)
+		ch <- []byte(`{"name": "x"}`)
+		close(ch)
+		return ch
+	}()})
+	rep, err := r.Run(context.Background())
+	require.NoError(t, err)
+	require.Equal(t, 1, rep.Failed)
+	require.EqualError(t, rep.Errors["queue:1"],
+		`validation failed: json schema: /: missing required "id"`)
+	require.Contains(t, sinks.docs["0.xml"], "<Id>1</Id>")
+
+	// The schema decides which payloads are records: {} is one.
+	data, err := convertDocument([]byte(`{}`), &convertOptions{emptyRecords: emptyRecordsNone})
+	require.NoError(t, err)
+	require.Contains(t, string(data), "<jsonData>")
+	_, err = newSchemaValidator(s)(Metadata{}, bufio.NewReader(bytes.NewReader([]byte(`{`))))
+	require.Error(t, err)
+
+	// An explicit --empty-records is honoured.
+	defer func() { jsonSchemaFile, emptyRecords = "", "" }()
+	jsonSchemaFile = "schema.json"
+	opts, err := newConvertOptions()
+	require.NoError(t, err)
+	require.Equal(t, emptyRecordsNone, opts.emptyRecords)
+	emptyRecords = emptyRecordsZero
+	opts, err = newConvertOptions()
+	require.NoError(t, err)
+	require.Equal(t, emptyRecordsZero, opts.emptyRecords)
+}
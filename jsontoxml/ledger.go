@@ -0,0 +1,176 @@
+package jsontoxml
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io/ioutil"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+// States of the documents in a delivery ledger.
+const (
+	ledgerPending = "pending"
+	ledgerDone    = "done"
+)
+
+var (
+	ledgerPath string
+	// ledger is opened from --delivery-ledger.
+	ledger *DeliveryLedger
+)
+
+func init() {
+	rootCmd.PersistentFlags().StringVar(&ledgerPath, "delivery-ledger", "",
+		"File recording the documents delivered to the output, so that a run restarted after"+
+			" a crash doesn't deliver them again. The documents are sent with an"+
+			" Idempotency-Key header to http outputs.")
+}
+
+// DeliveryLedger is an append-only outbox of the documents delivered to a
+// sink, identified by their idempotency keys. A document is recorded as
+// pending before it is committed and as done once it is, so that a crash
+// between the two leaves it pending: it is delivered again, with the same key,
+// for the destination to drop the duplicate. It is safe for concurrent use.
+type DeliveryLedger struct {
+	mu     sync.Mutex
+	f      *os.File
+	states map[string]string
+}
+
+// OpenDeliveryLedger opens the ledger at p, creating it if needed.
+func OpenDeliveryLedger(p string) (*DeliveryLedger, error) {
+	f, err := os.OpenFile(p, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, errors.Wrap(err, "open delivery ledger")
+	}
+	data, err := ioutil.ReadAll(f)
+	if err != nil {
+		f.Close()
+		return nil, errors.Wrapf(err, "read delivery ledger %q", p)
+	}
+	l := &DeliveryLedger{f: f, states: make(map[string]string)}
+	for _, line := range strings.Split(string(data), "\n") {
+		fields := strings.Fields(line)
+		// A crash can leave the last line unfinished.
+		if len(fields) != 2 || fields[0] != ledgerPending && fields[0] != ledgerDone {
+			continue
+		}
+		if l.states[fields[1]] != ledgerDone {
+			l.states[fields[1]] = fields[0]
+		}
+	}
+	// The records are appended after the unfinished line, not to it.
+	if len(data) > 0 && data[len(data)-1] != '\n' {
+		if _, err := f.Write([]byte("\n")); err != nil {
+			f.Close()
+			return nil, errors.Wrap(err, "write delivery ledger")
+		}
+	}
+	return l, nil
+}
+
+// delivered returns true if the document of key was delivered.
+func (l *DeliveryLedger) delivered(key string) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.states[key] == ledgerDone
+}
+
+// mark records the state of the document of key, and syncs it to disk.
+func (l *DeliveryLedger) mark(state, key string) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if _, err := fmt.Fprintf(l.f, "%s %s\n", state, key); err != nil {
+		return errors.Wrap(err, "write delivery ledger")
+	}
+	if err := l.f.Sync(); err != nil {
+		return errors.Wrap(err, "write delivery ledger")
+	}
+	l.states[key] = state
+	return nil
+}
+
+// Close closes the file of the ledger.
+func (l *DeliveryLedger) Close() error {
+	return l.f.Close()
+}
+
+// idempotencyKey returns the key of the document name whose content has the
+// SHA-256 sum: a document converted again to the same xml keeps its key.
+func idempotencyKey(name string, sum []byte) string {
+	h := sha256.New()
+	h.Write([]byte(name))
+	h.Write([]byte{0})
+	h.Write(sum)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// idempotentSink is implemented by the sinks that can pass the idempotency
+// key of the document being committed to the destination.
+type idempotentSink interface {
+	setIdempotencyKey(key string)
+}
+
+// ledgerSink commits the documents written to sink once: the documents the
+// ledger has delivered are aborted instead.
+type ledgerSink struct {
+	sink   Sink
+	ledger *DeliveryLedger
+	name   string
+	h      hash.Hash
+}
+
+func (s *ledgerSink) Open(name string) error {
+	s.name = name
+	s.h = sha256.New()
+	return s.sink.Open(name)
+}
+
+func (s *ledgerSink) Write(p []byte) (int, error) {
+	n, err := s.sink.Write(p)
+	s.h.Write(p[:n])
+	return n, err
+}
+
+func (s *ledgerSink) Commit() error {
+	key := idempotencyKey(s.name, s.h.Sum(nil))
+	if s.ledger.delivered(key) {
+		logger{}.with("output", outputPath(s.name)).Printf("Already delivered, skipped")
+		return s.sink.Abort()
+	}
+	if err := s.ledger.mark(ledgerPending, key); err != nil {
+		s.sink.Abort()
+		return err
+	}
+	if is, ok := s.sink.(idempotentSink); ok {
+		is.setIdempotencyKey(key)
+	}
+	if err := s.sink.Commit(); err != nil {
+		return err
+	}
+	return s.ledger.mark(ledgerDone, key)
+}
+
+func (s *ledgerSink) Abort() error {
+	return s.sink.Abort()
+}
+
+func (s *ledgerSink) Exists(name string) bool {
+	es, ok := s.sink.(ExistingSink)
+	return ok && es.Exists(name)
+}
+
+// KeepPartial keeps the partial document, if sink can. Partial documents
+// aren't deliveries.
+func (s *ledgerSink) KeepPartial() error {
+	if ps, ok := s.sink.(PartialSink); ok {
+		return ps.KeepPartial()
+	}
+	return s.sink.Abort()
+}
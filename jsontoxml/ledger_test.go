@@ -0,0 +1,82 @@
+package jsontoxml
+
+import (
+	"crypto/sha256"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// deliver writes and commits the document name to sink.
+func deliver(t *testing.T, sink Sink, name, doc string) {
+	require.NoError(t, sink.Open(name))
+	_, err := sink.Write([]byte(doc))
+	require.NoError(t, err)
+	require.NoError(t, sink.Commit())
+}
+
+func TestLedgerSink(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ledger")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	p := filepath.Join(dir, "ledger")
+
+	var mu sync.Mutex
+	var keys []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		keys = append(keys, r.Header.Get("Idempotency-Key"))
+	}))
+	defer srv.Close()
+	newHTTPSink := func() Sink { return &httpSink{base: srv.URL, client: srv.Client()} }
+
+	l, err := OpenDeliveryLedger(p)
+	require.NoError(t, err)
+	deliver(t, &ledgerSink{sink: newHTTPSink(), ledger: l}, "0.xml", "<a></a>")
+	require.NoError(t, l.Close())
+	require.Len(t, keys, 1)
+	require.Len(t, keys[0], 64)
+
+	// A restarted run skips the delivered documents, but not the ones whose
+	// content changed.
+	l, err = OpenDeliveryLedger(p)
+	require.NoError(t, err)
+	defer l.Close()
+	sink := &ledgerSink{sink: newHTTPSink(), ledger: l}
+	deliver(t, sink, "0.xml", "<a></a>")
+	require.Len(t, keys, 1)
+	deliver(t, sink, "0.xml", "<b></b>")
+	require.Len(t, keys, 2)
+	require.NotEqual(t, keys[0], keys[1])
+}
+
+func TestLedgerPending(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ledger")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	p := filepath.Join(dir, "ledger")
+	sum := sha256.Sum256([]byte("<a></a>"))
+	key := idempotencyKey("0.xml", sum[:])
+	// A crash after the commit of 0.xml left it pending, and tore the last
+	// line.
+	require.NoError(t, ioutil.WriteFile(p, []byte("pending "+key+"\ndon"), 0644))
+
+	l, err := OpenDeliveryLedger(p)
+	require.NoError(t, err)
+	defer l.Close()
+	require.False(t, l.delivered(key))
+	var sinks memSinks
+	deliver(t, &ledgerSink{sink: sinks.newSink(), ledger: l}, "0.xml", "<a></a>")
+	require.Equal(t, "<a></a>", sinks.docs["0.xml"])
+	require.True(t, l.delivered(key))
+	data, err := ioutil.ReadFile(p)
+	require.NoError(t, err)
+	require.Contains(t, string(data), "\ndon\npending "+key+"\ndone "+key+"\n")
+}
@@ -0,0 +1,54 @@
+package jsontoxml_test
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"jsonToXml/jsontoxml"
+)
+
+// TestLibrary drives a batch with the exported API only, as the programs
+// importing the package do.
+func TestLibrary(t *testing.T) {
+	dir, err := ioutil.TempDir("", "library")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	in := filepath.Join(dir, "in.json")
+	require.NoError(t, ioutil.WriteFile(in, []byte(`[{"a": 1}, {"a": "x"}]`), 0600))
+	ch := make(chan []byte, 1)
+	ch <- []byte(`{"b": true}`)
+	close(ch)
+
+	out := filepath.Join(dir, "out")
+	r := &jsontoxml.Runner{
+		Workers: 1,
+		NewSink: func() jsontoxml.Sink { return jsontoxml.NewFileSink(out) },
+		Options: &jsontoxml.Options{Generic: true, RootElement: "row"},
+	}
+	r.Add(jsontoxml.NewFileSource(in))
+	rep, err := r.Run(context.Background())
+	require.NoError(t, err)
+	require.Equal(t, 1, rep.Processed)
+	data, err := ioutil.ReadFile(filepath.Join(out, "0.xml"))
+	require.NoError(t, err)
+	require.Equal(t, " <row>\n  <item>\n   <a>1</a>\n  </item>\n  <item>\n   <a>x</a>\n  </item>\n </row>",
+		string(data))
+
+	// The outputs of every source are numbered from 0.
+	r.Add(jsontoxml.NewQueueSource(ch))
+	rep, err = r.Run(context.Background())
+	require.NoError(t, err)
+	require.Equal(t, 1, rep.Processed)
+	data, err = ioutil.ReadFile(filepath.Join(out, "0.xml"))
+	require.NoError(t, err)
+	require.Equal(t, " <row>\n  <b>true</b>\n </row>", string(data))
+
+	r.Options.ArrayRoot = "1a"
+	_, err = r.Run(context.Background())
+	require.EqualError(t, err, `Options: invalid element name "1a"`)
+}
@@ -0,0 +1,130 @@
+package jsontoxml
+
+import (
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// locale describes how numbers and dates are rendered into xml text. Only the
+// parts that differ between the supported locales are described.
+type locale struct {
+	decimal string
+	months  [12]string
+	days    [7]string
+}
+
+var locales = map[string]*locale{
+	"en": {
+		decimal: ".",
+		months: [12]string{"January", "February", "March", "April", "May", "June", "July",
+			"August", "September", "October", "November", "December"},
+		days: [7]string{"Sunday", "Monday", "Tuesday", "Wednesday", "Thursday", "Friday",
+			"Saturday"},
+	},
+	"de": {
+		decimal: ",",
+		months: [12]string{"Januar", "Februar", "März", "April", "Mai", "Juni", "Juli",
+			"August", "September", "Oktober", "November", "Dezember"},
+		days: [7]string{"Sonntag", "Montag", "Dienstag", "Mittwoch", "Donnerstag", "Freitag",
+			"Samstag"},
+	},
+	"fr": {
+		decimal: ",",
+		months: [12]string{"janvier", "février", "mars", "avril", "mai", "juin", "juillet",
+			"août", "septembre", "octobre", "novembre", "décembre"},
+		days: [7]string{"dimanche", "lundi", "mardi", "mercredi", "jeudi", "vendredi",
+			"samedi"},
+	},
+	"es": {
+		decimal: ",",
+		months: [12]string{"enero", "febrero", "marzo", "abril", "mayo", "junio", "julio",
+			"agosto", "septiembre", "octubre", "noviembre", "diciembre"},
+		days: [7]string{"domingo", "lunes", "martes", "miércoles", "jueves", "viernes",
+			"sábado"},
+	},
+	"it": {
+		decimal: ",",
+		months: [12]string{"gennaio", "febbraio", "marzo", "aprile", "maggio", "giugno",
+			"luglio", "agosto", "settembre", "ottobre", "novembre", "dicembre"},
+		days: [7]string{"domenica", "lunedì", "martedì", "mercoledì", "giovedì", "venerdì",
+			"sabato"},
+	},
+	"nl": {
+		decimal: ",",
+		months: [12]string{"januari", "februari", "maart", "april", "mei", "juni", "juli",
+			"augustus", "september", "oktober", "november", "december"},
+		days: [7]string{"zondag", "maandag", "dinsdag", "woensdag", "donderdag", "vrijdag",
+			"zaterdag"},
+	},
+	"pt": {
+		decimal: ",",
+		months: [12]string{"janeiro", "fevereiro", "março", "abril", "maio", "junho", "julho",
+			"agosto", "setembro", "outubro", "novembro", "dezembro"},
+		days: [7]string{"domingo", "segunda-feira", "terça-feira", "quarta-feira",
+			"quinta-feira", "sexta-feira", "sábado"},
+	},
+}
+
+// lookupLocale returns the locale for a tag such as "de" or "de-DE". An empty
+// tag returns nil, which renders values the way Go does.
+func lookupLocale(tag string) (*locale, error) {
+	if len(tag) == 0 {
+		return nil, nil
+	}
+	parts := strings.FieldsFunc(tag, func(r rune) bool {
+		return r == '-' || r == '_'
+	})
+	if len(parts) == 0 {
+		return nil, errors.Errorf("unsupported locale %q", tag)
+	}
+	l, ok := locales[strings.ToLower(parts[0])]
+	if !ok {
+		return nil, errors.Errorf("unsupported locale %q", tag)
+	}
+	return l, nil
+}
+
+// formatTime formats t with the layout, using the month and day names of the
+// locale.
+func (l *locale) formatTime(t time.Time, layout string) string {
+	s := t.Format(layout)
+	if l == nil {
+		return s
+	}
+	en := locales["en"]
+	// Replace the long names first, otherwise "March" would become "Märch".
+	month, day := t.Month()-1, t.Weekday()
+	s = strings.Replace(s, en.months[month], l.months[month], -1)
+	s = strings.Replace(s, en.months[month][:3], shortName(l.months[month]), -1)
+	s = strings.Replace(s, en.days[day], l.days[day], -1)
+	return strings.Replace(s, en.days[day][:3], shortName(l.days[day]), -1)
+}
+
+func shortName(name string) string {
+	r := []rune(name)
+	if len(r) <= 3 {
+		return name
+	}
+	return string(r[:3])
+}
+
+// formatNumber formats the json number n with the decimal separator of the
+// locale. Exponents are expanded, since "1,5e3" isn't a number in any
+// locale, and thousands are not grouped so that the value can still be parsed
+// by a locale aware reader.
+func (l *locale) formatNumber(n string) string {
+	if l == nil {
+		return n
+	}
+	if strings.ContainsAny(n, "eE") {
+		f, err := strconv.ParseFloat(n, 64)
+		if err != nil {
+			return n
+		}
+		n = strconv.FormatFloat(f, 'f', -1, 64)
+	}
+	return strings.Replace(n, ".", l.decimal, 1)
+}
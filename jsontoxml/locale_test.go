@@ -0,0 +1,39 @@
+package jsontoxml
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestLookupLocale(t *testing.T) {
+	l, err := lookupLocale("")
+	require.NoError(t, err)
+	require.Nil(t, l)
+	l, err = lookupLocale("de-DE")
+	require.NoError(t, err)
+	require.Equal(t, locales["de"], l)
+	_, err = lookupLocale("xx")
+	require.Error(t, err)
+}
+
+func TestLocaleFormat(t *testing.T) {
+	ts := time.Date(2021, 3, 1, 10, 0, 0, 0, time.UTC)
+	layout := "Monday, 2 January 2006 (Mon Jan)"
+	var none *locale
+	require.Equal(t, "Monday, 1 March 2021 (Mon Mar)", none.formatTime(ts, layout))
+	require.Equal(t, "Montag, 1 März 2021 (Mon Mär)", locales["de"].formatTime(ts, layout))
+	require.Equal(t, "lundi, 1 mars 2021 (lun mar)", locales["fr"].formatTime(ts, layout))
+
+	require.Equal(t, "3.25", none.formatNumber("3.25"))
+	require.Equal(t, "1.5e3", none.formatNumber("1.5e3"))
+	require.Equal(t, "3,25", locales["de"].formatNumber("3.25"))
+	require.Equal(t, "1500", locales["de"].formatNumber("1500"))
+	require.Equal(t, "1500", locales["de"].formatNumber("1.5e3"))
+	require.Equal(t, "0,0015", locales["de"].formatNumber("1.5E-3"))
+	require.Equal(t, "-12345678901234567890,5", locales["fr"].formatNumber("-12345678901234567890.5"))
+
+	opts := &convertOptions{timeFormat: "2 Jan 2006", locale: locales["es"]}
+	require.Equal(t, "1 mar 2021", opts.formatTime(ts))
+}
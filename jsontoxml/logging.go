@@ -0,0 +1,65 @@
+package jsontoxml
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+)
+
+// field is a key value pair attached to every line logged by a logger.
+type field struct {
+	key   string
+	value interface{}
+}
+
+// logger prefixes every line with its fields (worker id, url, attempt, ...) so
+// that the interleaved logs of concurrent workers can be correlated. The zero
+// value logs without any fields. A logger is immutable and safe for concurrent
+// use.
+type logger struct {
+	fields []field
+}
+
+// with returns a copy of the logger with an additional field.
+func (l logger) with(key string, value interface{}) logger {
+	fields := make([]field, 0, len(l.fields)+1)
+	fields = append(fields, l.fields...)
+	return logger{fields: append(fields, field{key: key, value: value})}
+}
+
+// Printf logs the message followed by the fields of the logger in key=value
+// form.
+func (l logger) Printf(format string, args ...interface{}) {
+	log.Print(l.format(fmt.Sprintf(format, args...)))
+}
+
+func (l logger) format(msg string) string {
+	var b strings.Builder
+	b.WriteString(msg)
+	for _, f := range l.fields {
+		b.WriteByte(' ')
+		b.WriteString(f.key)
+		b.WriteByte('=')
+		if s, ok := f.value.(string); ok {
+			fmt.Fprintf(&b, "%q", s)
+		} else {
+			fmt.Fprintf(&b, "%v", f.value)
+		}
+	}
+	return b.String()
+}
+
+type loggerKey struct{}
+
+// withLogger returns a context carrying the logger.
+func withLogger(ctx context.Context, l logger) context.Context {
+	return context.WithValue(ctx, loggerKey{}, l)
+}
+
+// loggerFromContext returns the logger carried by ctx, or a logger without any
+// fields if there is none.
+func loggerFromContext(ctx context.Context) logger {
+	l, _ := ctx.Value(loggerKey{}).(logger)
+	return l
+}
@@ -0,0 +1,23 @@
+package jsontoxml
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestLoggerFields(t *testing.T) {
+	l := logger{}.with("worker", 3).with("url", "http://localhost/a.json")
+	l2 := l.with("attempt", 2)
+	require.Equal(t, `Finished worker=3 url="http://localhost/a.json"`, l.format("Finished"))
+	require.Equal(t, `Finished worker=3 url="http://localhost/a.json" attempt=2`,
+		l2.format("Finished"))
+	require.Equal(t, "Finished", logger{}.format("Finished"))
+}
+
+func TestLoggerFromContext(t *testing.T) {
+	require.Empty(t, loggerFromContext(context.Background()).fields)
+	l := logger{}.with("worker", 1)
+	require.Equal(t, l, loggerFromContext(withLogger(context.Background(), l)))
+}
@@ -0,0 +1,325 @@
+package jsontoxml
+
+import (
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"io/ioutil"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v3"
+)
+
+// Types of the fields of a mapping.
+const (
+	mappingString = "string"
+	mappingInt    = "int"
+	mappingNumber = "number"
+	mappingBool   = "bool"
+)
+
+var mappingFile string
+
+func init() {
+	rootCmd.PersistentFlags().StringVar(&mappingFile, "mapping", "",
+		"Yaml or json file mapping the json fields of the records to their xml element"+
+			" paths (e.g. name>first), attributes (@id) and types, used instead of the"+
+			" jsonData type.")
+}
+
+// mappingField maps a json field to an element or attribute of the records.
+type mappingField struct {
+	// JSON is the key of the field. The keys are matched case insensitively
+	// if no key matches exactly, as encoding/json does.
+	JSON string `yaml:"json"`
+	// XML is the path of the element of the field under the record, e.g.
+	// name>first. A last step starting with @ is an attribute of the element
+	// of the previous steps, or of the record.
+	XML string `yaml:"xml"`
+	// Type is the json type of the field: string (the default), int, number
+	// or bool.
+	Type string `yaml:"type"`
+	// OmitEmpty leaves the field out of the records it is absent from,
+	// instead of writing its zero value.
+	OmitEmpty bool `yaml:"omitempty"`
+
+	path []string
+}
+
+// recordMapping describes the records instead of the jsonData type.
+type recordMapping struct {
+	// Root names the element of the records. --root-element if empty.
+	Root   string          `yaml:"root"`
+	Fields []*mappingField `yaml:"fields"`
+}
+
+// loadMapping reads the mapping file at p.
+func loadMapping(p string) (*recordMapping, error) {
+	data, err := ioutil.ReadFile(p)
+	if err != nil {
+		return nil, errors.Wrap(err, "--mapping")
+	}
+	var m recordMapping
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	dec.KnownFields(true)
+	if err := dec.Decode(&m); err != nil {
+		return nil, errors.Wrapf(err, "--mapping %s", p)
+	}
+	if err := m.check(); err != nil {
+		return nil, errors.Wrapf(err, "--mapping %s", p)
+	}
+	return &m, nil
+}
+
+// check validates the mapping and splits the paths of its fields.
+func (m *recordMapping) check() error {
+	if len(m.Root) > 0 && !xmlNameExpr.MatchString(m.Root) {
+		return errors.Errorf("invalid root %q", m.Root)
+	}
+	if len(m.Fields) == 0 {
+		return errors.New("no field")
+	}
+	// valued holds the paths of the elements holding a value, parents the ones
+	// of the elements with children, and attrs the paths of the attributes.
+	valued := make(map[string]bool)
+	parents := make(map[string]bool)
+	attrs := make(map[string]bool)
+	for _, f := range m.Fields {
+		if len(f.JSON) == 0 || len(f.XML) == 0 {
+			return errors.Errorf("field %q: expected json and xml", f.JSON)
+		}
+		switch f.Type {
+		case "":
+			f.Type = mappingString
+		case mappingString, mappingInt, mappingNumber, mappingBool:
+		default:
+			return errors.Errorf("field %q: unknown type %q. Expected string, int, number or"+
+				" bool", f.JSON, f.Type)
+		}
+		f.path = strings.Split(f.XML, ">")
+		for i, step := range f.path {
+			name := step
+			if i == len(f.path)-1 {
+				name = strings.TrimPrefix(step, "@")
+			}
+			if !xmlNameExpr.MatchString(name) {
+				return errors.Errorf("field %q: invalid xml path %q", f.JSON, f.XML)
+			}
+		}
+		if f.isAttr() {
+			if attrs[f.XML] {
+				return errors.Errorf("field %q: %s is mapped twice", f.JSON, f.XML)
+			}
+			attrs[f.XML] = true
+			continue
+		}
+		// The elements holding a value can't have children.
+		if valued[f.XML] {
+			return errors.Errorf("field %q: %s is mapped twice", f.JSON, f.XML)
+		}
+		if parents[f.XML] {
+			return errors.Errorf("field %q: %s has child elements", f.JSON, f.XML)
+		}
+		for i := 1; i < len(f.path); i++ {
+			p := strings.Join(f.path[:i], ">")
+			if valued[p] {
+				return errors.Errorf("field %q: %s holds a value", f.JSON, p)
+			}
+			parents[p] = true
+		}
+		valued[f.XML] = true
+	}
+	return nil
+}
+
+// isAttr returns true if the field is an attribute.
+func (f *mappingField) isAttr() bool {
+	return strings.HasPrefix(f.path[len(f.path)-1], "@")
+}
+
+// value returns the text of the field in the record, or its zero value and
+// false if it is absent or null. coerce converts the strings that look like
+// the numbers and bools of the type.
+func (f *mappingField) value(record map[string]interface{}, coerce bool) (string, bool,
+	error) {
+	v, ok := record[f.JSON]
+	if !ok {
+		for k, item := range record {
+			if strings.EqualFold(k, f.JSON) {
+				v = item
+				break
+			}
+		}
+	}
+	if v == nil {
+		return f.zero(), false, nil
+	}
+	if s, ok := v.(string); ok && coerce && f.Type != mappingString {
+		if c, ok := coerceScalar(s); ok {
+			v = c
+		}
+	}
+	switch val := v.(type) {
+	case string:
+		if f.Type == mappingString {
+			return val, true, nil
+		}
+	case json.Number:
+		if f.Type == mappingNumber {
+			return val.String(), true, nil
+		}
+		if _, err := strconv.ParseInt(val.String(), 10, 64); f.Type == mappingInt && err == nil {
+			return val.String(), true, nil
+		}
+	case bool:
+		if f.Type == mappingBool {
+			return strconv.FormatBool(val), true, nil
+		}
+	}
+	return "", false, errors.Errorf("json.Unmarshal: field %q: expected %s, got %s", f.JSON,
+		f.Type, jsonType(v))
+}
+
+// zero returns the text of the zero value of the field.
+func (f *mappingField) zero() string {
+	switch f.Type {
+	case mappingInt, mappingNumber:
+		return "0"
+	case mappingBool:
+		return "false"
+	}
+	return ""
+}
+
+// mappingNode is an element of a record written by a mapping.
+type mappingNode struct {
+	start    xml.StartElement
+	text     *string
+	children []*mappingNode
+}
+
+// child returns the child element name of n, adding it if needed.
+func (n *mappingNode) child(name string) *mappingNode {
+	for _, c := range n.children {
+		if c.start.Name.Local == name {
+			return c
+		}
+	}
+	c := &mappingNode{start: xml.StartElement{Name: xml.Name{Local: name}}}
+	n.children = append(n.children, c)
+	return c
+}
+
+func (n *mappingNode) encode(enc *xml.Encoder) error {
+	if err := enc.EncodeToken(n.start); err != nil {
+		return err
+	}
+	if n.text != nil {
+		if err := enc.EncodeToken(xml.CharData(*n.text)); err != nil {
+			return err
+		}
+	}
+	for _, c := range n.children {
+		if err := c.encode(enc); err != nil {
+			return err
+		}
+	}
+	return enc.EncodeToken(n.start.End())
+}
+
+// mappingToXml converts the json records to xml as described by the mapping.
+// A json array is converted to one element per entry, wrapped in the array
+// root element.
+func (o *convertOptions) mappingToXml(data []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	enc := xml.NewEncoder(&buf)
+	enc.Indent(" ", " ")
+	if !isJSONArray(data) {
+		if err := o.encodeMapped(enc, data); err != nil {
+			return nil, err
+		}
+	} else {
+		var entries []json.RawMessage
+		if err := json.Unmarshal(data, &entries); err != nil {
+			return nil, errors.Wrap(err, "json.Unmarshal")
+		}
+		start := xml.StartElement{Name: xml.Name{Local: o.arrayRootName()}}
+		if err := enc.EncodeToken(start); err != nil {
+			return nil, errors.Wrap(err, "xml.Marshal")
+		}
+		for i, e := range entries {
+			if err := o.encodeMapped(enc, e); err != nil {
+				return nil, errors.Wrapf(err, "entry %d", i)
+			}
+		}
+		if err := enc.EncodeToken(start.End()); err != nil {
+			return nil, errors.Wrap(err, "xml.Marshal")
+		}
+	}
+	if err := enc.Flush(); err != nil {
+		return nil, errors.Wrap(err, "xml.Marshal")
+	}
+	return buf.Bytes(), nil
+}
+
+// encodeMapped encodes the json record as described by the mapping. The
+// records without any of the fields of the mapping are rejected with
+// ErrUnknownJSON.
+func (o *convertOptions) encodeMapped(enc *xml.Encoder, data []byte) error {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	var v interface{}
+	if err := dec.Decode(&v); err != nil {
+		return errors.Wrap(err, "json.Unmarshal")
+	}
+	obj, ok := o.whitespace.value("", o.html.value(v)).(map[string]interface{})
+	if !ok {
+		return ErrUnknownJSON
+	}
+	name := o.mapping.Root
+	if len(name) == 0 {
+		name = o.rootElementName()
+	}
+	root := &mappingNode{start: xml.StartElement{Name: xml.Name{Local: name}}}
+	present := false
+	for _, f := range o.mapping.Fields {
+		s, set, err := f.value(obj, o.coerce)
+		if err != nil {
+			return err
+		}
+		present = present || set
+		if !set && f.OmitEmpty {
+			continue
+		}
+		n := root
+		for _, step := range f.path[:len(f.path)-1] {
+			n = n.child(step)
+		}
+		if f.isAttr() {
+			n.start.Attr = append(n.start.Attr, xml.Attr{
+				Name: xml.Name{Local: strings.TrimPrefix(f.path[len(f.path)-1], "@")}, Value: s})
+			continue
+		}
+		n = n.child(f.path[len(f.path)-1])
+		start, text := o.truncate(n.start, s)
+		n.start = start
+		t := text.(string)
+		n.text = &t
+	}
+	if !present {
+		return ErrUnknownJSON
+	}
+	r := &record{}
+	if err := o.decorate(r, v); err != nil {
+		return err
+	}
+	root.start.Attr = append(root.start.Attr, r.Attrs...)
+	for _, e := range r.Extra {
+		value := e.Value
+		root.children = append(root.children,
+			&mappingNode{start: xml.StartElement{Name: e.XMLName}, text: &value})
+	}
+	return errors.Wrap(root.encode(enc), "xml.Marshal")
+}
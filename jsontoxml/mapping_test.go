@@ -0,0 +1,83 @@
+package jsontoxml
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+const testMapping = `root: person
+fields:
+  - json: id
+    xml: "@id"
+    type: int
+  - json: first_name
+    xml: name>first
+  - json: last_name
+    xml: name>last
+  - json: lang
+    xml: name>@lang
+    omitempty: true
+  - json: age
+    xml: age
+    type: number
+  - json: active
+    xml: status>active
+    type: bool
+  - json: city
+    xml: address>city
+    omitempty: true
+`
+
+func loadTestMapping(t *testing.T, s string) (*recordMapping, error) {
+	p := filepath.Join(t.TempDir(), "mapping.yaml")
+	require.NoError(t, ioutil.WriteFile(p, []byte(s), 0600))
+	return loadMapping(p)
+}
+
+func TestMapping(t *testing.T) {
+	m, err := loadTestMapping(t, testMapping)
+	require.NoError(t, err)
+	opts := &convertOptions{mapping: m, layout: &xmlLayout{compact: true}}
+	out, err := convert([]byte(`{"ID": 7, "first_name": "Jane", "last_name": "Doe", "lang": "en",`+
+		` "age": 41.5, "active": true}`), opts)
+	require.NoError(t, err)
+	require.Equal(t, `<person id="7"><name lang="en"><first>Jane</first><last>Doe</last></name>`+
+		`<age>41.5</age><status><active>true</active></status></person>`, string(out))
+
+	out, err = convert([]byte(`[{"first_name": "A", "city": "Oslo"}]`), opts)
+	require.NoError(t, err)
+	require.Equal(t, `<records><person id="0"><name><first>A</first><last></last></name>`+
+		`<age>0</age><status><active>false</active></status><address><city>Oslo</city></address>`+
+		`</person></records>`, string(out))
+
+	_, err = convert([]byte(`{"other": 1}`), opts)
+	require.Equal(t, ErrUnknownJSON, err)
+	_, err = convert([]byte(`{"id": "7"}`), opts)
+	require.Error(t, err)
+	_, err = convert([]byte(`{"id": 1.5}`), opts)
+	require.Error(t, err)
+	opts.coerce = true
+	out, err = convert([]byte(`{"id": "7"}`), opts)
+	require.NoError(t, err)
+	require.Contains(t, string(out), `<person id="7">`)
+}
+
+func TestLoadMappingErrors(t *testing.T) {
+	for _, s := range []string{
+		"fields: []",
+		"fields:\n  - json: a\n",
+		"fields:\n  - json: a\n    xml: a b\n",
+		"fields:\n  - json: a\n    xml: a\n    type: date\n",
+		"fields:\n  - json: a\n    xml: a\n  - json: b\n    xml: a\n",
+		"fields:\n  - json: a\n    xml: a\n  - json: b\n    xml: a>b\n",
+		"fields:\n  - json: a\n    xml: a>b\n  - json: b\n    xml: a\n",
+		"fields:\n  - json: a\n    xml: '@a'\n  - json: b\n    xml: '@a'\n",
+		"fields:\n  - json: a\n    xml: a\n    extra: 1\n",
+	} {
+		_, err := loadTestMapping(t, s)
+		require.Error(t, err, s)
+	}
+}
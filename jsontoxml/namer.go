@@ -0,0 +1,152 @@
+package jsontoxml
+
+import (
+	"fmt"
+	"net/url"
+	"path"
+	"path/filepath"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/pkg/errors"
+)
+
+// Namer decides the path of the output generated from a payload, relative to
+// the output directory. Implement it to use a custom layout, e.g. one directory
+// per tenant. Implementations must be safe for concurrent use and must return
+// distinct names for distinct sources.
+type Namer interface {
+	Name(m Metadata) string
+}
+
+// IndexNamer names the outputs after the position of the url in the url list:
+// 0.xml, 1.xml, ...
+type IndexNamer struct{}
+
+func (IndexNamer) Name(m Metadata) string {
+	return fmt.Sprintf("%d.xml", m.Index)
+}
+
+// HashNamer names the outputs after the sha256 hash of the url, spread over 256
+// directories: ab/abcdef....xml. The name doesn't depend on the order of the
+// url list.
+type HashNamer struct{}
+
+func (HashNamer) Name(m Metadata) string {
+	h := sha256Hex([]byte(m.URL))
+	return path.Join(h[:2], h+".xml")
+}
+
+// URLNamer mirrors the host and path of the url: host/path/to/file.xml. The
+// index is appended to the file name, as several urls may share a path (e.g.
+// differing only in their query string). The name is made portable with
+// portablePath.
+type URLNamer struct{}
+
+func (URLNamer) Name(m Metadata) string {
+	u, err := url.Parse(m.URL)
+	if err != nil || len(u.Host) == 0 {
+		return IndexNamer{}.Name(m)
+	}
+	var parts []string
+	for _, p := range strings.Split(u.Host+"/"+u.Path, "/") {
+		if p = strings.Trim(p, "."); len(p) > 0 {
+			parts = append(parts, p)
+		}
+	}
+	name := path.Join(parts...)
+	name = portablePath(strings.TrimSuffix(name, path.Ext(name)))
+	return fmt.Sprintf("%s.%d.xml", name, m.Index)
+}
+
+// PathNamer mirrors the path of input files relative to Root, with an .xml
+// extension: Root/a/b.json is named a/b.xml. The name is made portable with
+// portablePath.
+type PathNamer struct {
+	Root string
+}
+
+func (n PathNamer) Name(m Metadata) string {
+	rel, err := filepath.Rel(n.Root, m.URL)
+	if err != nil || strings.HasPrefix(rel, "..") {
+		return IndexNamer{}.Name(m)
+	}
+	rel = filepath.ToSlash(rel)
+	return portablePath(strings.TrimSuffix(rel, path.Ext(rel))) + ".xml"
+}
+
+// maxNameLen is the maximum length of the names made by portablePath, which
+// leaves room for the output directory and suffixes such as .partial within
+// the 260 characters of MAX_PATH on Windows.
+const maxNameLen = 160
+
+// windowsReserved are the device names which can't be used as file names on
+// Windows, whatever their extension.
+var windowsReserved = map[string]bool{
+	"CON": true, "PRN": true, "AUX": true, "NUL": true,
+	"COM1": true, "COM2": true, "COM3": true, "COM4": true, "COM5": true, "COM6": true,
+	"COM7": true, "COM8": true, "COM9": true,
+	"LPT1": true, "LPT2": true, "LPT3": true, "LPT4": true, "LPT5": true, "LPT6": true,
+	"LPT7": true, "LPT8": true, "LPT9": true,
+}
+
+// portablePath returns the slash separated path p, derived from a url or an
+// input, with the changes needed to create it on Windows as well: the
+// characters <>:"\|?* and the control characters are replaced with _, the
+// trailing dots and spaces of the elements are removed and reserved names
+// such as CON or NUL are prefixed with _. A path longer than maxNameLen is
+// truncated, and suffixed with a hash of p to keep it distinct.
+func portablePath(p string) string {
+	elems := strings.Split(p, "/")
+	for i, e := range elems {
+		e = strings.Map(func(r rune) rune {
+			if r < 0x20 || strings.ContainsRune(`<>:"\|?*`, r) {
+				return '_'
+			}
+			return r
+		}, e)
+		e = strings.TrimRight(e, ". ")
+		if windowsReserved[strings.ToUpper(strings.TrimSpace(strings.SplitN(e, ".", 2)[0]))] {
+			e = "_" + e
+		}
+		if len(e) == 0 {
+			e = "_"
+		}
+		elems[i] = e
+	}
+	name := strings.Join(elems, "/")
+	if len(name) <= maxNameLen {
+		return name
+	}
+	// The hash is appended to the last element, within maxNameLen.
+	end := maxNameLen - 17
+	for end > 0 && !utf8.RuneStart(name[end]) {
+		end--
+	}
+	return strings.TrimRight(name[:end], "/. ") + "-" + sha256Hex([]byte(p))[:16]
+}
+
+var (
+	naming string
+	// namer names the outputs of every command. It is set from --naming.
+	namer Namer = IndexNamer{}
+)
+
+func init() {
+	rootCmd.PersistentFlags().StringVar(&naming, "naming", "index",
+		"Layout of the output files: index (0.xml, 1.xml, ...), hash (sha256 of the url) or"+
+			" url (host/path of the url).")
+}
+
+// newNamer returns the namer for the --naming flag.
+func newNamer(kind string) (Namer, error) {
+	switch kind {
+	case "index":
+		return IndexNamer{}, nil
+	case "hash":
+		return HashNamer{}, nil
+	case "url":
+		return URLNamer{}, nil
+	}
+	return nil, errors.Errorf("unknown naming %q. Expected index, hash or url", kind)
+}
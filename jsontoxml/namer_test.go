@@ -0,0 +1,45 @@
+package jsontoxml
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+	"unicode/utf8"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestNamers(t *testing.T) {
+	src := Metadata{Index: 3, URL: "http://localhost:8080/data/../people.json?page=2"}
+	require.Equal(t, "3.xml", IndexNamer{}.Name(src))
+	require.Regexp(t, `^[0-9a-f]{2}/[0-9a-f]{64}\.xml$`, HashNamer{}.Name(src))
+	require.Equal(t, "localhost_8080/data/people.3.xml", URLNamer{}.Name(src))
+	require.Equal(t, "3.xml", URLNamer{}.Name(Metadata{Index: 3, URL: "not a url"}))
+	require.Equal(t, "host/a_b/_con/_NUL.txt/x_.3.xml",
+		URLNamer{}.Name(Metadata{Index: 3, URL: "http://host/a%3Fb/con/NUL.txt/x%2A..json"}))
+
+	for _, kind := range []string{"index", "hash", "url"} {
+		_, err := newNamer(kind)
+		require.NoError(t, err)
+	}
+	_, err := newNamer("foo")
+	require.Error(t, err)
+}
+
+func TestPathNamer(t *testing.T) {
+	n := PathNamer{Root: filepath.Join("data", "in")}
+	require.Equal(t, "a/b.xml", n.Name(Metadata{URL: filepath.Join("data", "in", "a", "b.json")}))
+	require.Equal(t, "3.xml", n.Name(Metadata{Index: 3, URL: filepath.Join("elsewhere", "b.json")}))
+}
+
+func TestPortablePath(t *testing.T) {
+	require.Equal(t, "a/b_c/d", portablePath("a/b|c/d. "))
+	require.Equal(t, "_COM1/_aux.tar.gz/com10", portablePath("COM1/aux.tar.gz/com10"))
+
+	long := strings.Repeat("a", 100) + "/" + strings.Repeat("é", 100)
+	name := portablePath(long)
+	require.LessOrEqual(t, len(name), maxNameLen)
+	require.True(t, utf8.ValidString(name))
+	require.True(t, strings.HasPrefix(name, strings.Repeat("a", 100)+"/é"))
+	require.NotEqual(t, name, portablePath(long+"é"))
+}
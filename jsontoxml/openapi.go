@@ -0,0 +1,499 @@
+package jsontoxml
+
+import (
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io/ioutil"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v3"
+)
+
+var openAPIFile, operationID string
+
+func init() {
+	rootCmd.PersistentFlags().StringVar(&openAPIFile, "openapi", "",
+		"OpenAPI spec (json or yaml) describing the payloads. The response schema of"+
+			" --operation then drives the conversion: names, types and required fields.")
+	rootCmd.PersistentFlags().StringVar(&operationID, "operation", "",
+		"Id of the OpenAPI operation whose response is converted.")
+}
+
+// apiSchema is the subset of an OpenAPI schema object that drives the
+// conversion.
+type apiSchema struct {
+	Ref        string                `yaml:"$ref"`
+	Type       interface{}           `yaml:"type"`
+	Properties map[string]*apiSchema `yaml:"properties"`
+	Items      *apiSchema            `yaml:"items"`
+	Required   []string              `yaml:"required"`
+	Nullable   bool                  `yaml:"nullable"`
+	Enum       []interface{}         `yaml:"enum"`
+	AllOf      []*apiSchema          `yaml:"allOf"`
+	OneOf      []*apiSchema          `yaml:"oneOf"`
+	AnyOf      []*apiSchema          `yaml:"anyOf"`
+	// AdditionalProperties is a bool or a schema. Only false changes the
+	// conversion: unknown properties are then rejected.
+	AdditionalProperties interface{} `yaml:"additionalProperties"`
+	XML                  *struct {
+		Name      string `yaml:"name"`
+		Attribute bool   `yaml:"attribute"`
+		Wrapped   bool   `yaml:"wrapped"`
+	} `yaml:"xml"`
+}
+
+// apiSpec is the subset of an OpenAPI document needed to find the response
+// schema of an operation.
+type apiSpec struct {
+	Paths      map[string]map[string]yaml.Node `yaml:"paths"`
+	Components struct {
+		Schemas map[string]*apiSchema `yaml:"schemas"`
+	} `yaml:"components"`
+}
+
+type apiOperation struct {
+	OperationID string `yaml:"operationId"`
+	Responses   map[string]struct {
+		Ref     string `yaml:"$ref"`
+		Content map[string]struct {
+			Schema *apiSchema `yaml:"schema"`
+		} `yaml:"content"`
+	} `yaml:"responses"`
+}
+
+// operationSchema is the response schema of an operation, with the schemas it
+// refers to.
+type operationSchema struct {
+	root    *apiSchema
+	schemas map[string]*apiSchema
+	// rootName names the root element: the xml name of the schema, or the
+	// name of the component it refers to.
+	rootName string
+}
+
+// loadOperationSchema returns the schema of the json response of the operation
+// in the spec at path.
+func loadOperationSchema(path, id string) (*operationSchema, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, errors.Wrap(err, "read --openapi")
+	}
+	// yaml is a superset of json, so both are parsed the same way.
+	var spec apiSpec
+	if err := yaml.Unmarshal(data, &spec); err != nil {
+		return nil, errors.Wrapf(err, "parse %q", path)
+	}
+	var ids []string
+	for p, item := range spec.Paths {
+		for method, node := range item {
+			switch method {
+			case "get", "put", "post", "delete", "options", "head", "patch", "trace":
+			default:
+				continue
+			}
+			var op apiOperation
+			if err := node.Decode(&op); err != nil {
+				return nil, errors.Wrapf(err, "parse %s %s", method, p)
+			}
+			if op.OperationID == id {
+				s, err := spec.responseSchema(op)
+				if err != nil {
+					return nil, errors.Wrapf(err, "operation %q", id)
+				}
+				return s, nil
+			}
+			ids = append(ids, op.OperationID)
+		}
+	}
+	sort.Strings(ids)
+	return nil, errors.Errorf("operation %q not found in %q. Operations: %s", id, path,
+		strings.Join(ids, ", "))
+}
+
+// responseSchema returns the schema of the success response of op: 200, then
+// the other 2xx, then default.
+func (spec *apiSpec) responseSchema(op apiOperation) (*operationSchema, error) {
+	codes := make([]string, 0, len(op.Responses))
+	for code := range op.Responses {
+		if strings.HasPrefix(code, "2") {
+			codes = append(codes, code)
+		}
+	}
+	sort.Strings(codes)
+	codes = append(codes, "default")
+	for _, code := range codes {
+		resp, ok := op.Responses[code]
+		if !ok {
+			continue
+		}
+		if len(resp.Ref) > 0 {
+			return nil, errors.Errorf("response %s: references aren't supported", code)
+		}
+		types := make([]string, 0, len(resp.Content))
+		for t := range resp.Content {
+			types = append(types, t)
+		}
+		sort.Strings(types)
+		for _, t := range types {
+			if !isJSON(t) {
+				continue
+			}
+			root := resp.Content[t].Schema
+			if root == nil {
+				break
+			}
+			s := &operationSchema{root: root, schemas: spec.Components.Schemas, rootName: rootName}
+			if root.XML != nil && len(root.XML.Name) > 0 {
+				s.rootName = root.XML.Name
+			} else if len(root.Ref) > 0 {
+				s.rootName = componentName(root.Ref)
+			}
+			return s, nil
+		}
+	}
+	return nil, errors.New("no json success response")
+}
+
+// resolve follows the $ref of s.
+func (o *operationSchema) resolve(s *apiSchema) (*apiSchema, error) {
+	for i := 0; s != nil && len(s.Ref) > 0; i++ {
+		const prefix = "#/components/schemas/"
+		if !strings.HasPrefix(s.Ref, prefix) || i > 32 {
+			return nil, errors.Errorf("unsupported $ref %q", s.Ref)
+		}
+		target, ok := o.schemas[strings.TrimPrefix(s.Ref, prefix)]
+		if !ok {
+			return nil, errors.Errorf("unknown $ref %q", s.Ref)
+		}
+		s = target
+	}
+	return s, nil
+}
+
+// types returns the json types allowed by s, and whether null is allowed.
+func (s *apiSchema) types() (types []string, nullable bool) {
+	switch t := s.Type.(type) {
+	case string:
+		types = []string{t}
+	case []interface{}:
+		for _, v := range t {
+			if name, ok := v.(string); ok {
+				types = append(types, name)
+			}
+		}
+	}
+	nullable = s.Nullable
+	for _, t := range types {
+		if t == "null" {
+			nullable = true
+		}
+	}
+	return types, nullable
+}
+
+// schemaToXml converts the json document as described by the response schema.
+func (o *convertOptions) schemaToXml(data []byte) ([]byte, error) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	var v interface{}
+	if err := dec.Decode(&v); err != nil {
+		return nil, errors.Wrap(err, "json.Unmarshal")
+	}
+	var buf bytes.Buffer
+	enc := xml.NewEncoder(&buf)
+	enc.Indent(" ", " ")
+	w := &schemaWriter{o: o, s: o.schema, enc: enc}
+	start := xml.StartElement{Name: xml.Name{Local: o.schema.rootName}}
+	if err := w.value(start, o.schema.root, v, "$"); err != nil {
+		return nil, err
+	}
+	if err := enc.Flush(); err != nil {
+		return nil, errors.Wrap(err, "xml.Marshal")
+	}
+	return buf.Bytes(), nil
+}
+
+// schemaWriter validates json values against their schema while writing them.
+type schemaWriter struct {
+	o   *convertOptions
+	s   *operationSchema
+	enc *xml.Encoder
+}
+
+// componentName returns the element name of the component ref refers to.
+func componentName(ref string) string {
+	name, _ := xmlName(ref[strings.LastIndex(ref, "/")+1:])
+	return name
+}
+
+// value writes v, at the json path p, as the element start.
+func (w *schemaWriter) value(start xml.StartElement, s *apiSchema, v interface{}, p string) error {
+	s, err := w.merge(s, v, p)
+	if err != nil {
+		return err
+	}
+	if err := w.check(s, v, p); err != nil {
+		return err
+	}
+	switch val := v.(type) {
+	case map[string]interface{}:
+		return w.object(start, s, val, p)
+	case []interface{}:
+		if err := w.enc.EncodeToken(start); err != nil {
+			return err
+		}
+		// Items are named after their component, unless they are the
+		// values of a property, which names them.
+		name := itemName
+		if s != nil && s.Items != nil && len(s.Items.Ref) > 0 {
+			name = componentName(s.Items.Ref)
+		}
+		if err := w.items(name, s, val, p); err != nil {
+			return err
+		}
+		return w.enc.EncodeToken(start.End())
+	}
+	return w.encodeScalar(start, v)
+}
+
+// merge resolves the references and composition of s for the value v.
+func (w *schemaWriter) merge(s *apiSchema, v interface{}, p string) (*apiSchema, error) {
+	s, err := w.s.resolve(s)
+	if err != nil || s == nil {
+		return s, err
+	}
+	if len(s.OneOf) > 0 || len(s.AnyOf) > 0 {
+		// The first alternative matching the value is used.
+		var firstErr error
+		for _, alt := range append(append([]*apiSchema{}, s.OneOf...), s.AnyOf...) {
+			m, err := w.merge(alt, v, p)
+			if err == nil {
+				err = w.check(m, v, p)
+			}
+			if err == nil {
+				return m, nil
+			}
+			if firstErr == nil {
+				firstErr = err
+			}
+		}
+		return nil, firstErr
+	}
+	if len(s.AllOf) == 0 {
+		return s, nil
+	}
+	merged := *s
+	merged.AllOf = nil
+	merged.Properties = make(map[string]*apiSchema)
+	for k, prop := range s.Properties {
+		merged.Properties[k] = prop
+	}
+	for _, part := range s.AllOf {
+		m, err := w.merge(part, v, p)
+		if err != nil {
+			return nil, err
+		}
+		if merged.Type == nil {
+			merged.Type = m.Type
+		}
+		if merged.Items == nil {
+			merged.Items = m.Items
+		}
+		for k, prop := range m.Properties {
+			merged.Properties[k] = prop
+		}
+		merged.Required = append(merged.Required, m.Required...)
+	}
+	return &merged, nil
+}
+
+// check validates the type, enum and required fields of v.
+func (w *schemaWriter) check(s *apiSchema, v interface{}, p string) error {
+	if s == nil {
+		return nil
+	}
+	types, nullable := s.types()
+	if v == nil {
+		if nullable || len(types) == 0 {
+			return nil
+		}
+		return errors.Errorf("%s: null isn't allowed", p)
+	}
+	if len(types) > 0 {
+		got, ok := jsonType(v), false
+		for _, t := range types {
+			switch {
+			case t == got, t == "boolean" && got == "bool":
+				ok = true
+			case t == "integer" && got == "number":
+				ok = !strings.ContainsAny(v.(json.Number).String(), ".eE")
+			}
+		}
+		if !ok {
+			return errors.Errorf("%s: expected %s, got %s", p, strings.Join(types, " or "), got)
+		}
+	}
+	if len(s.Enum) > 0 {
+		found := false
+		for _, e := range s.Enum {
+			if fmt.Sprint(e) == fmt.Sprint(v) {
+				found = true
+			}
+		}
+		if !found {
+			return errors.Errorf("%s: %s isn't one of the allowed values", p, compactJSON(v))
+		}
+	}
+	if obj, ok := v.(map[string]interface{}); ok {
+		for _, k := range s.Required {
+			if _, ok := obj[k]; !ok {
+				return errors.Errorf("%s: required field %q is missing", p, k)
+			}
+		}
+		if b, ok := s.AdditionalProperties.(bool); ok && !b {
+			for k := range obj {
+				if _, ok := s.Properties[k]; !ok {
+					return errors.Errorf("%s: unknown field %q", p, k)
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// name returns the element name of the property key.
+func (w *schemaWriter) name(key string, s *apiSchema) (xml.Name, []xml.Attr) {
+	if s != nil && s.XML != nil && len(s.XML.Name) > 0 {
+		return xml.Name{Local: s.XML.Name}, nil
+	}
+	name, ok := xmlName(key)
+	if !ok {
+		w.o.warnRenamed(key, name)
+		return xml.Name{Local: name}, []xml.Attr{{Name: xml.Name{Local: keyAttr}, Value: key}}
+	}
+	return xml.Name{Local: name}, nil
+}
+
+func (w *schemaWriter) object(start xml.StartElement, s *apiSchema, obj map[string]interface{},
+	p string) error {
+	keys := make([]string, 0, len(obj))
+	for k := range obj {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	var children []string
+	for _, k := range keys {
+		prop, err := w.property(s, k)
+		if err != nil {
+			return err
+		}
+		if prop != nil && prop.XML != nil && prop.XML.Attribute {
+			if _, isObj := obj[k].(map[string]interface{}); isObj {
+				return errors.Errorf("%s.%s: objects can't be attributes", p, k)
+			}
+			if err := w.check(prop, obj[k], p+"."+k); err != nil {
+				return err
+			}
+			name, _ := w.name(k, prop)
+			start.Attr = append(start.Attr, xml.Attr{Name: name, Value: w.o.formatScalar(obj[k])})
+			continue
+		}
+		children = append(children, k)
+	}
+	if err := w.enc.EncodeToken(start); err != nil {
+		return err
+	}
+	for _, k := range children {
+		prop, _ := w.property(s, k)
+		if prop == nil {
+			// Properties missing from the schema are converted generically.
+			name, attrs := w.name(k, nil)
+			err := w.o.encodeField(w.enc, k, xml.StartElement{Name: name, Attr: attrs}, obj[k])
+			if err != nil {
+				return err
+			}
+			continue
+		}
+		if err := w.field(k, prop, obj[k], p+"."+k); err != nil {
+			return err
+		}
+	}
+	return w.enc.EncodeToken(start.End())
+}
+
+// property returns the resolved schema of the property k of s, or nil if s
+// doesn't describe it.
+func (w *schemaWriter) property(s *apiSchema, k string) (*apiSchema, error) {
+	if s == nil || s.Properties[k] == nil {
+		return nil, nil
+	}
+	return w.s.resolve(s.Properties[k])
+}
+
+// field writes the property k. Arrays are written as repeated elements named
+// after the property, wrapped in an element of that name if the schema asks
+// for it.
+func (w *schemaWriter) field(k string, prop *apiSchema, v interface{}, p string) error {
+	name, attrs := w.name(k, prop)
+	arr, ok := v.([]interface{})
+	if !ok {
+		return w.value(xml.StartElement{Name: name, Attr: attrs}, prop, v, p)
+	}
+	prop, err := w.merge(prop, v, p)
+	if err != nil {
+		return err
+	}
+	if err := w.check(prop, v, p); err != nil {
+		return err
+	}
+	if prop.XML == nil || !prop.XML.Wrapped {
+		return w.items(name.Local, prop, arr, p)
+	}
+	start := xml.StartElement{Name: name, Attr: attrs}
+	if err := w.enc.EncodeToken(start); err != nil {
+		return err
+	}
+	if err := w.items(name.Local, prop, arr, p); err != nil {
+		return err
+	}
+	return w.enc.EncodeToken(start.End())
+}
+
+// items writes the entries of an array, named after the xml name of the items
+// schema, or def.
+func (w *schemaWriter) items(def string, s *apiSchema, arr []interface{}, p string) error {
+	var items *apiSchema
+	if s != nil {
+		items = s.Items
+	}
+	name := def
+	if resolved, err := w.s.resolve(items); err != nil {
+		return err
+	} else if resolved != nil && resolved.XML != nil && len(resolved.XML.Name) > 0 {
+		name = resolved.XML.Name
+	}
+	for i, item := range arr {
+		start := xml.StartElement{Name: xml.Name{Local: name}}
+		if err := w.value(start, items, item, fmt.Sprintf("%s[%d]", p, i)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (w *schemaWriter) encodeScalar(start xml.StartElement, v interface{}) error {
+	start, v = w.o.truncate(start, v)
+	if err := w.enc.EncodeToken(start); err != nil {
+		return err
+	}
+	if v != nil {
+		if err := w.enc.EncodeToken(xml.CharData(w.o.formatScalar(v))); err != nil {
+			return err
+		}
+	}
+	return w.enc.EncodeToken(start.End())
+}
@@ -0,0 +1,138 @@
+package jsontoxml
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+const petSpec = `
+openapi: 3.0.3
+paths:
+  /pets:
+    parameters: []
+    get:
+      operationId: listPets
+      responses:
+        "200":
+          content:
+            application/json:
+              schema:
+                type: array
+                xml: {name: Pets}
+                items: {$ref: "#/components/schemas/Pet"}
+        default:
+          content:
+            application/json:
+              schema: {$ref: "#/components/schemas/Error"}
+  /pets/{id}:
+    get:
+      operationId: getPet
+      responses:
+        "201":
+          content:
+            application/hal+json:
+              schema: {$ref: "#/components/schemas/Pet"}
+components:
+  schemas:
+    Pet:
+      xml: {name: Pet}
+      allOf:
+        - $ref: "#/components/schemas/Base"
+        - type: object
+          required: [name]
+          properties:
+            name: {type: string}
+            status: {type: string, enum: [available, sold]}
+            tag: {type: string, nullable: true}
+            photo urls:
+              type: array
+              xml: {name: photos, wrapped: true}
+              items: {type: string, xml: {name: url}}
+            aliases:
+              type: array
+              items: {type: string}
+    Owner:
+      type: object
+      properties:
+        pets:
+          type: array
+          items: {$ref: "#/components/schemas/Base"}
+        bases:
+          type: array
+          items:
+            type: array
+            items: {$ref: "#/components/schemas/Base"}
+    Base:
+      type: object
+      required: [id]
+      properties:
+        id: {type: integer, xml: {attribute: true}}
+    Error:
+      type: object
+      properties:
+        message: {type: string}
+`
+
+func TestOpenAPISchema(t *testing.T) {
+	dir, err := ioutil.TempDir("", "openapi")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	spec := filepath.Join(dir, "spec.yaml")
+	require.NoError(t, ioutil.WriteFile(spec, []byte(petSpec), 0644))
+
+	s, err := loadOperationSchema(spec, "listPets")
+	require.NoError(t, err)
+	require.Equal(t, "Pets", s.rootName)
+	opts := &convertOptions{schema: s}
+	got, err := convert([]byte(`[{"id": 1, "name": "Rex", "status": "sold", "tag": null,
+		"photo urls": ["a.png", "b.png"], "aliases": ["R"], "age": 3}]`), opts)
+	require.NoError(t, err)
+	require.Equal(t, ` <Pets>
+  <Pet id="1">
+   <age>3</age>
+   <aliases>R</aliases>
+   <name>Rex</name>
+   <photos>
+    <url>a.png</url>
+    <url>b.png</url>
+   </photos>
+   <status>sold</status>
+   <tag></tag>
+  </Pet>
+ </Pets>`, string(got))
+
+	for doc, msg := range map[string]string{
+		`[{"name": "Rex"}]`:                       `$[0]: required field "id" is missing`,
+		`[{"id": 1.5, "name": "Rex"}]`:            `$[0].id: expected integer, got number`,
+		`[{"id": 1, "name": null}]`:               `$[0].name: null isn't allowed`,
+		`[{"id": 1, "name": "R", "status": "x"}]`: `$[0].status: "x" isn't one of the allowed values`,
+		`{"id": 1}`: `$: expected array, got object`,
+	} {
+		_, err := convert([]byte(doc), opts)
+		require.EqualError(t, err, msg, doc)
+	}
+
+	owner := &operationSchema{root: &apiSchema{Ref: "#/components/schemas/Owner"},
+		schemas: s.schemas, rootName: "Owner"}
+	got, err = convert([]byte(`{"pets": [{"id": 1}], "bases": [[{"id": 2}]]}`), &convertOptions{schema: owner})
+	require.NoError(t, err)
+	require.Equal(t, ` <Owner>
+  <bases>
+   <Base id="2"></Base>
+  </bases>
+  <pets id="1"></pets>
+ </Owner>`, string(got))
+
+	// The component name of the schema names the root.
+	s, err = loadOperationSchema(spec, "getPet")
+	require.NoError(t, err)
+	require.Equal(t, "Pet", s.rootName)
+
+	_, err = loadOperationSchema(spec, "deletePet")
+	require.EqualError(t, err, `operation "deletePet" not found in "`+spec+
+		`". Operations: getPet, listPets`)
+}
@@ -0,0 +1,106 @@
+package jsontoxml
+
+import (
+	"github.com/pkg/errors"
+)
+
+// OverwritePolicy decides what happens to a document that is already at the
+// destination, e.g. written by a previous run.
+type OverwritePolicy string
+
+const (
+	// OverwriteReplace replaces the document. It is the default.
+	OverwriteReplace OverwritePolicy = "replace"
+	// OverwriteSkip keeps the document. Its payload isn't fetched.
+	OverwriteSkip OverwritePolicy = "skip"
+	// OverwriteError keeps the document and fails its payload.
+	OverwriteError OverwritePolicy = "error"
+)
+
+// errOutputExists is the cause of the failures of the payloads whose document
+// exists with OverwriteError.
+var errOutputExists = errors.New("output already exists")
+
+// errSkipExisting is returned for the payloads whose document exists with
+// OverwriteSkip.
+var errSkipExisting = errors.New("output exists, skipped")
+
+var (
+	skipExisting, forceOverwrite, errorIfExists bool
+	// overwritePolicy is set from --skip-existing, --force and
+	// --error-if-exists.
+	overwritePolicy = OverwriteReplace
+)
+
+func init() {
+	rootCmd.PersistentFlags().BoolVar(&skipExisting, "skip-existing", false,
+		"Keep the outputs that already exist, without fetching their urls.")
+	rootCmd.PersistentFlags().BoolVar(&forceOverwrite, "force", false,
+		"Replace the outputs that already exist. This is the default.")
+	rootCmd.PersistentFlags().BoolVar(&errorIfExists, "error-if-exists", false,
+		"Keep the outputs that already exist and fail their urls.")
+}
+
+// parseOverwritePolicy validates the overwrite flags. At most one can be set.
+func parseOverwritePolicy(skip, force, fail bool) (OverwritePolicy, error) {
+	policy, n := OverwriteReplace, 0
+	for _, f := range []struct {
+		set    bool
+		policy OverwritePolicy
+	}{{skip, OverwriteSkip}, {force, OverwriteReplace}, {fail, OverwriteError}} {
+		if f.set {
+			policy = f.policy
+			n++
+		}
+	}
+	if n > 1 {
+		return "", errors.New("only one of --skip-existing, --force and --error-if-exists" +
+			" can be set")
+	}
+	return policy, nil
+}
+
+// checkOutput applies the policy to the document name of sink. It returns
+// errSkipExisting or errOutputExists if the document exists and must be kept.
+// The sinks that can't tell whether a document exists are always written.
+func (p OverwritePolicy) checkOutput(sink Sink, name string) error {
+	if p != OverwriteSkip && p != OverwriteError {
+		return nil
+	}
+	es, ok := sink.(ExistingSink)
+	if !ok || !es.Exists(name) {
+		return nil
+	}
+	if p == OverwriteSkip {
+		return errSkipExisting
+	}
+	return errors.Wrap(errOutputExists, outputPath(name))
+}
+
+// supportsOverwritePolicy returns an error if the policy needs to know whether
+// the documents of the sinks returned by newSink exist and they can't tell.
+func supportsOverwritePolicy(p OverwritePolicy, newSink func() Sink) error {
+	if p != OverwriteSkip && p != OverwriteError {
+		return nil
+	}
+	if _, ok := newSink().(ExistingSink); ok {
+		return nil
+	}
+	flag := "--skip-existing"
+	if p == OverwriteError {
+		flag = "--error-if-exists"
+	}
+	return errors.Errorf("%s needs an output that can tell which documents exist: a local"+
+		" directory or --single-file", flag)
+}
+
+// keepExisting logs that the document name is kept, with err returned by
+// checkOutput. The skipped documents aren't failures: it returns nil for them.
+func keepExisting(l logger, name string, err error) error {
+	if err == errSkipExisting {
+		l.Printf("Skipped existing output: %q", outputPath(name))
+		return nil
+	}
+	l.Printf("Failed processing err: %s", err)
+	return err
+}
@@ -0,0 +1,91 @@
+package jsontoxml
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/require"
+)
+
+// countingClient counts the gets of mockClient.
+type countingClient struct {
+	gets int32
+}
+
+func (c *countingClient) Get(url string) (*http.Response, error) {
+	atomic.AddInt32(&c.gets, 1)
+	return new(mockClient).Get(url)
+}
+
+func TestParseOverwritePolicy(t *testing.T) {
+	p, err := parseOverwritePolicy(false, false, false)
+	require.NoError(t, err)
+	require.Equal(t, OverwriteReplace, p)
+	p, err = parseOverwritePolicy(true, false, false)
+	require.NoError(t, err)
+	require.Equal(t, OverwriteSkip, p)
+	p, err = parseOverwritePolicy(false, false, true)
+	require.NoError(t, err)
+	require.Equal(t, OverwriteError, p)
+	_, err = parseOverwritePolicy(true, true, false)
+	require.Error(t, err)
+}
+
+func TestRunnerOverwrite(t *testing.T) {
+	dir := t.TempDir()
+	run := func(p OverwritePolicy, fragments bool) (Report, *countingClient) {
+		client := &countingClient{}
+		r := &Runner{
+			NewSink:   func() Sink { return &fileSink{dir: dir} },
+			Overwrite: p,
+			Fragments: fragments,
+		}
+		r.Add(&urlSource{client: client, urls: []string{"valid"}, shard: shard{index: 1, total: 1}})
+		rep, err := r.Run(context.Background())
+		require.NoError(t, err)
+		return rep, client
+	}
+	path := filepath.Join(dir, "0.xml")
+	require.NoError(t, ioutil.WriteFile(path, []byte("old"), 0600))
+
+	rep, client := run(OverwriteSkip, false)
+	require.Equal(t, 0, rep.Failed)
+	require.Zero(t, client.gets)
+	data, err := ioutil.ReadFile(path)
+	require.NoError(t, err)
+	require.Equal(t, "old", string(data))
+
+	rep, client = run(OverwriteError, false)
+	require.Equal(t, 1, rep.Failed)
+	require.Zero(t, client.gets)
+	require.Equal(t, errOutputExists, errors.Cause(rep.Errors["valid"]))
+
+	rep, client = run(OverwriteReplace, false)
+	require.Equal(t, 0, rep.Failed)
+	require.EqualValues(t, 1, client.gets)
+	data, err = ioutil.ReadFile(path)
+	require.NoError(t, err)
+	require.Contains(t, string(data), "<first>firstname</first>")
+
+	// The fragments of a document exist if its first record does.
+	rep, client = run(OverwriteError, true)
+	require.Equal(t, 0, rep.Failed)
+	require.FileExists(t, filepath.Join(dir, "0-0.xml"))
+	rep, client = run(OverwriteError, true)
+	require.Equal(t, 1, rep.Failed)
+	require.Zero(t, client.gets)
+}
+
+func TestSupportsOverwritePolicy(t *testing.T) {
+	files := func() Sink { return &fileSink{} }
+	stdout := func() Sink { return &streamSink{} }
+	require.NoError(t, supportsOverwritePolicy(OverwriteSkip, files))
+	require.NoError(t, supportsOverwritePolicy(OverwriteReplace, stdout))
+	require.EqualError(t, supportsOverwritePolicy(OverwriteError, stdout), "--error-if-exists"+
+		" needs an output that can tell which documents exist: a local directory or --single-file")
+}
@@ -0,0 +1,335 @@
+package jsontoxml
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/url"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+var (
+	paginate     bool
+	cursorField  string
+	cursorParam  string
+	recordsField string
+	maxPages     int
+	// pager follows the pages of the urls. nil if pagination is disabled.
+	pager *pagination
+)
+
+func init() {
+	rootCmd.PersistentFlags().BoolVar(&paginate, "paginate", false,
+		`Follow the Link: <url>; rel="next" headers of the responses, converting the records of`+
+			` all the pages of a url to a single document.`)
+	rootCmd.PersistentFlags().StringVar(&cursorField, "cursor-field", "",
+		"Follow the pages of the urls with the json field of the pages holding the url of the"+
+			" next page, e.g. links.next. The last page has no such field, or an empty one.")
+	rootCmd.PersistentFlags().StringVar(&cursorParam, "cursor-param", "",
+		"Query parameter receiving the --cursor-field of a page to request the next page, if"+
+			" the field holds a cursor rather than a url.")
+	rootCmd.PersistentFlags().StringVar(&recordsField, "records-field", "",
+		"Json field of the pages holding their records, e.g. data. By default, the records are"+
+			" the entries of a page that is an array, or of the only array of a page object.")
+	rootCmd.PersistentFlags().IntVar(&maxPages, "max-pages", 100,
+		"Maximum number of pages followed per url. Urls with more pages fail.")
+}
+
+// pagination follows the pages of a url, with the Link headers of the
+// responses or a cursor field of the pages.
+type pagination struct {
+	links bool
+	// cursor is the path of the cursor field, param the query parameter
+	// receiving the cursor. The cursor is the url of the next page if param is
+	// empty.
+	cursor []string
+	param  string
+	// records is the path of the records of a page, if set.
+	records []string
+	max     int
+}
+
+// newPagination returns the pagination configured by the flags, or nil if the
+// pages aren't followed.
+func newPagination() (*pagination, error) {
+	if !paginate && len(cursorField) == 0 {
+		if len(cursorParam) > 0 || len(recordsField) > 0 {
+			return nil, errors.New("--cursor-param and --records-field require --paginate or" +
+				" --cursor-field")
+		}
+		return nil, nil
+	}
+	if len(cursorParam) > 0 && len(cursorField) == 0 {
+		return nil, errors.New("--cursor-param requires --cursor-field")
+	}
+	if maxPages < 1 {
+		return nil, errors.Errorf("invalid --max-pages %d. Expected at least 1", maxPages)
+	}
+	return &pagination{
+		links:   paginate,
+		cursor:  splitField(cursorField),
+		param:   cursorParam,
+		records: splitField(recordsField),
+		max:     maxPages,
+	}, nil
+}
+
+// splitField splits the path of a json field: a.b is the field b of the object
+// a.
+func splitField(path string) []string {
+	if len(path) == 0 {
+		return nil
+	}
+	return strings.Split(path, ".")
+}
+
+// follow returns a json array of the records of the page body, of m, and of
+// the pages following it, which are fetched with src as it is read.
+func (p *pagination) follow(ctx context.Context, src *urlSource, body io.ReadCloser,
+	m Metadata) io.ReadCloser {
+	r := &pageReader{ctx: ctx, p: p, src: src, body: body, m: m, first: m}
+	r.buf.WriteByte('[')
+	return r
+}
+
+// page returns the records of a page and the url of the next one, empty on the
+// last page.
+func (p *pagination) page(body io.Reader, m Metadata) ([]json.RawMessage, string, error) {
+	var doc json.RawMessage
+	if err := json.NewDecoder(body).Decode(&doc); err != nil {
+		return nil, "", errors.Wrap(err, "decode")
+	}
+	records, err := p.pageRecords(doc)
+	if err != nil {
+		return nil, "", err
+	}
+	next, err := p.next(doc, m)
+	return records, next, err
+}
+
+func (p *pagination) pageRecords(doc json.RawMessage) ([]json.RawMessage, error) {
+	if len(p.records) > 0 {
+		v, ok := lookupField(doc, p.records)
+		if !ok || string(v) == "null" {
+			return nil, nil
+		}
+		var records []json.RawMessage
+		if err := json.Unmarshal(v, &records); err != nil {
+			return nil, errors.Errorf("--records-field %q isn't an array",
+				strings.Join(p.records, "."))
+		}
+		return records, nil
+	}
+	var records []json.RawMessage
+	if isJSONArray(doc) {
+		err := json.Unmarshal(doc, &records)
+		return records, errors.Wrap(err, "decode")
+	}
+	var obj map[string]json.RawMessage
+	if err := json.Unmarshal(doc, &obj); err != nil {
+		// Not an object: the page is a single record.
+		return []json.RawMessage{doc}, nil
+	}
+	var arrays []json.RawMessage
+	for _, v := range obj {
+		if isJSONArray(v) {
+			arrays = append(arrays, v)
+		}
+	}
+	if len(arrays) != 1 {
+		return []json.RawMessage{doc}, nil
+	}
+	err := json.Unmarshal(arrays[0], &records)
+	return records, errors.Wrap(err, "decode")
+}
+
+// next returns the url of the page following the page doc of m. The Link
+// header takes precedence over the cursor field.
+func (p *pagination) next(doc json.RawMessage, m Metadata) (string, error) {
+	base, err := url.Parse(m.URL)
+	if err != nil {
+		return "", errors.Wrap(err, "page url")
+	}
+	if m.resp != nil && m.resp.Request != nil {
+		// The url the request was redirected to.
+		base = m.resp.Request.URL
+	}
+	next := ""
+	if p.links {
+		next = nextLink(m.Header["Link"])
+	}
+	if len(next) == 0 && len(p.cursor) > 0 {
+		v, ok := lookupField(doc, p.cursor)
+		if !ok {
+			return "", nil
+		}
+		d := json.NewDecoder(bytes.NewReader(v))
+		d.UseNumber()
+		var cursor interface{}
+		if err := d.Decode(&cursor); err != nil {
+			return "", errors.Wrap(err, "decode cursor")
+		}
+		switch c := cursor.(type) {
+		case string:
+			next = c
+		case json.Number:
+			next = c.String()
+		case nil:
+		default:
+			return "", errors.Errorf("--cursor-field %q isn't a string or a number",
+				strings.Join(p.cursor, "."))
+		}
+		if len(next) > 0 && len(p.param) > 0 {
+			u := *base
+			q := u.Query()
+			q.Set(p.param, next)
+			u.RawQuery = q.Encode()
+			return u.String(), nil
+		}
+	}
+	if len(next) == 0 {
+		return "", nil
+	}
+	u, err := base.Parse(next)
+	if err != nil {
+		return "", errors.Wrapf(err, "next page %q", next)
+	}
+	return u.String(), nil
+}
+
+// lookupField returns the value of the field at path in the json object doc.
+func lookupField(doc json.RawMessage, path []string) (json.RawMessage, bool) {
+	for _, name := range path {
+		var obj map[string]json.RawMessage
+		if err := json.Unmarshal(doc, &obj); err != nil {
+			return nil, false
+		}
+		v, ok := obj[name]
+		if !ok {
+			return nil, false
+		}
+		doc = v
+	}
+	return doc, true
+}
+
+// nextLink returns the target of the next link of the Link headers (RFC 8288,
+// formerly RFC 5988): <https://api.example.com/items?page=2>; rel="next".
+func nextLink(headers []string) string {
+	for _, h := range headers {
+		for len(h) > 0 {
+			h = strings.TrimLeft(h, " \t,")
+			end := strings.IndexByte(h, '>')
+			if !strings.HasPrefix(h, "<") || end < 0 {
+				break
+			}
+			target := h[1:end]
+			h = h[end+1:]
+			// The parameters run until the comma separating the next link.
+			// Quoted values may contain commas.
+			end, quoted := len(h), false
+			for i := 0; i < len(h) && end == len(h); i++ {
+				switch {
+				case h[i] == '"':
+					quoted = !quoted
+				case h[i] == ',' && !quoted:
+					end = i
+				}
+			}
+			params := h[:end]
+			h = h[end:]
+			for _, param := range strings.Split(params, ";") {
+				k, v, ok := strings.Cut(strings.TrimSpace(param), "=")
+				if !ok || !strings.EqualFold(strings.TrimSpace(k), "rel") {
+					continue
+				}
+				for _, rel := range strings.Fields(strings.Trim(strings.TrimSpace(v), `"`)) {
+					if strings.EqualFold(rel, "next") {
+						return target
+					}
+				}
+			}
+		}
+	}
+	return ""
+}
+
+// pageReader reads the records of all the pages of a url as a json array.
+type pageReader struct {
+	ctx context.Context
+	p   *pagination
+	src *urlSource
+	// body is the page being read, of m. nil once it is read.
+	body  io.ReadCloser
+	m     Metadata
+	first Metadata
+	// next is the url of the next page, pages the number of pages read.
+	next    string
+	pages   int
+	records int
+	buf     bytes.Buffer
+	err     error
+}
+
+func (r *pageReader) Read(b []byte) (int, error) {
+	for r.buf.Len() == 0 {
+		if r.err != nil {
+			return 0, r.err
+		}
+		r.err = r.readPage()
+	}
+	return r.buf.Read(b)
+}
+
+// readPage adds the records of the next page to the buffer. It returns
+// io.EOF after the last page.
+func (r *pageReader) readPage() error {
+	if r.body == nil {
+		if len(r.next) == 0 {
+			r.buf.WriteByte(']')
+			return io.EOF
+		}
+		if r.pages == r.p.max {
+			return errors.Errorf("more than --max-pages %d pages", r.p.max)
+		}
+		body, m, err := r.src.fetchPage(r.ctx, Metadata{Index: r.first.Index, URL: r.next})
+		if err != nil {
+			return errors.Wrapf(err, "page %d", r.pages+1)
+		}
+		// The payloads recognized by their content are sniffed on the first
+		// page only.
+		if isJSON(r.first.ContentType) && !isJSON(m.ContentType) {
+			body.Close()
+			return errors.Errorf("page %d: invalid Content-Type header. Expected"+
+				" application/json, received %q", r.pages+1, m.ContentType)
+		}
+		r.body, r.m = body, m
+	}
+	r.pages++
+	records, next, err := r.p.page(r.body, r.m)
+	r.body.Close()
+	r.body = nil
+	if err != nil {
+		return errors.Wrapf(err, "page %d", r.pages)
+	}
+	r.next = next
+	for _, rec := range records {
+		if r.records > 0 {
+			r.buf.WriteByte(',')
+		}
+		r.buf.Write(rec)
+		r.records++
+	}
+	return nil
+}
+
+func (r *pageReader) Close() error {
+	if r.body != nil {
+		r.body.Close()
+		r.body = nil
+	}
+	return nil
+}
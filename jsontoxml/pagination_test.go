@@ -0,0 +1,109 @@
+package jsontoxml
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestNextLink(t *testing.T) {
+	for _, tc := range []struct {
+		headers []string
+		want    string
+	}{
+		{nil, ""},
+		{[]string{`<https://a/items?page=2>; rel="next"`}, "https://a/items?page=2"},
+		{[]string{`<https://a/1>; rel="prev", <https://a/3>; rel="last next"`}, "https://a/3"},
+		{[]string{`<https://a/1>; title="a, b"; rel=prev, </3>; REL=Next`}, "/3"},
+		{[]string{`<https://a/1>; rel="first"`, `<https://a/2>; rel="next"`}, "https://a/2"},
+		{[]string{`https://a/2; rel="next"`}, ""},
+	} {
+		require.Equal(t, tc.want, nextLink(tc.headers), "%q", tc.headers)
+	}
+}
+
+func TestPagination(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		page, _ := strconv.Atoi(r.URL.Query().Get("page"))
+		w.Header().Set("Content-Type", "application/json")
+		switch r.URL.Path {
+		case "/links":
+			if page < 2 {
+				w.Header().Set("Link", fmt.Sprintf(`</links?page=%d>; rel="next"`, page+1))
+			}
+			fmt.Fprintf(w, `[{"page": %d, "i": 0}, {"page": %d, "i": 1}]`, page, page)
+		case "/cursor":
+			next := `null`
+			if page < 2 {
+				next = strconv.Itoa(page + 1)
+			}
+			fmt.Fprintf(w, `{"data": [{"page": %d}], "meta": {"next": %s}}`, page, next)
+		case "/text":
+			if page > 0 {
+				w.Header().Set("Content-Type", "text/html")
+			}
+			w.Header().Set("Link", `</text?page=1>; rel="next"`)
+			fmt.Fprint(w, `[]`)
+		}
+	}))
+	defer srv.Close()
+	fetch := func(p *pagination, path string) (string, error) {
+		src := &urlSource{client: srv.Client(), pages: p}
+		body, _, err := src.fetch(context.Background(), Metadata{URL: srv.URL + path})
+		require.NoError(t, err)
+		defer body.Close()
+		data, err := ioutil.ReadAll(body)
+		return string(data), err
+	}
+
+	got, err := fetch(&pagination{links: true, max: 3}, "/links")
+	require.NoError(t, err)
+	require.Equal(t, `[{"page": 0, "i": 0},{"page": 0, "i": 1},{"page": 1, "i": 0},`+
+		`{"page": 1, "i": 1},{"page": 2, "i": 0},{"page": 2, "i": 1}]`, got)
+
+	_, err = fetch(&pagination{links: true, max: 2}, "/links")
+	require.EqualError(t, err, "more than --max-pages 2 pages")
+
+	// The records are found without --records-field.
+	p := &pagination{cursor: []string{"meta", "next"}, param: "page", max: 5}
+	got, err = fetch(p, "/cursor")
+	require.NoError(t, err)
+	require.Equal(t, `[{"page": 0},{"page": 1},{"page": 2}]`, got)
+	p.records = []string{"data"}
+	got, err = fetch(p, "/cursor")
+	require.NoError(t, err)
+	require.Equal(t, `[{"page": 0},{"page": 1},{"page": 2}]`, got)
+
+	_, err = fetch(&pagination{links: true, max: 5}, "/text")
+	require.EqualError(t, err, `page 2: invalid Content-Type header. Expected application/json,`+
+		` received "text/html"`)
+}
+
+func TestNewPagination(t *testing.T) {
+	defer func() {
+		paginate, cursorField, cursorParam, recordsField, maxPages = false, "", "", "", 100
+	}()
+	p, err := newPagination()
+	require.NoError(t, err)
+	require.Nil(t, p)
+
+	recordsField = "data"
+	_, err = newPagination()
+	require.Error(t, err)
+
+	cursorField, cursorParam = "links.next", "after"
+	p, err = newPagination()
+	require.NoError(t, err)
+	require.Equal(t, &pagination{cursor: []string{"links", "next"}, param: "after",
+		records: []string{"data"}, max: 100}, p)
+
+	maxPages = 0
+	_, err = newPagination()
+	require.Error(t, err)
+}
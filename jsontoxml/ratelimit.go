@@ -0,0 +1,129 @@
+package jsontoxml
+
+import (
+	"math"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+var rateLimit float64
+
+func init() {
+	rootCmd.PersistentFlags().Float64Var(&rateLimit, "rate-limit", 0,
+		"Maximum number of requests per second to a host, shared by all the workers. Bursts"+
+			" of up to that many requests are allowed. No limit if 0.")
+}
+
+func checkRateLimit(limit float64) error {
+	if limit < 0 || math.IsNaN(limit) || math.IsInf(limit, 0) {
+		return errors.Errorf("invalid --rate-limit %v. Expected a positive number of requests"+
+			" per second", limit)
+	}
+	return nil
+}
+
+// tokenBucket allows rate events per second, in bursts of up to burst events.
+type tokenBucket struct {
+	mu     sync.Mutex
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+func newTokenBucket(rate float64, now time.Time) *tokenBucket {
+	burst := math.Max(1, rate)
+	return &tokenBucket{rate: rate, burst: burst, tokens: burst, last: now}
+}
+
+// reserve takes a token and returns how long to wait, from now, before using
+// it. The tokens are handed out in order: a token taken while the bucket is
+// empty is the one refilled after the tokens already reserved.
+func (b *tokenBucket) reserve(now time.Time) time.Duration {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if now.After(b.last) {
+		b.tokens = math.Min(b.burst, b.tokens+now.Sub(b.last).Seconds()*b.rate)
+		b.last = now
+	}
+	b.tokens--
+	if b.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-b.tokens / b.rate * float64(time.Second))
+}
+
+// cancel gives back a token reserved but not used.
+func (b *tokenBucket) cancel() {
+	b.mu.Lock()
+	b.tokens = math.Min(b.burst, b.tokens+1)
+	b.mu.Unlock()
+}
+
+// full returns true if the bucket has refilled by now, so that it behaves like
+// a new one.
+func (b *tokenBucket) full(now time.Time) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.tokens+now.Sub(b.last).Seconds()*b.rate >= b.burst
+}
+
+// rateLimitTransport limits the requests to every host to rate per second.
+type rateLimitTransport struct {
+	base http.RoundTripper
+	rate float64
+
+	mu      sync.Mutex
+	buckets map[string]*tokenBucket
+}
+
+func newRateLimitTransport(base http.RoundTripper, rate float64) *rateLimitTransport {
+	return &rateLimitTransport{base: base, rate: rate, buckets: make(map[string]*tokenBucket)}
+}
+
+func (t *rateLimitTransport) bucket(host string) *tokenBucket {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	b, ok := t.buckets[host]
+	if !ok {
+		b = newTokenBucket(t.rate, time.Now())
+		t.buckets[host] = b
+	}
+	return b
+}
+
+// CloseIdleConnections also drops the buckets of the hosts that were idle long
+// enough for them to refill, so that the map doesn't grow with every host ever
+// fetched.
+func (t *rateLimitTransport) CloseIdleConnections() {
+	now := time.Now()
+	t.mu.Lock()
+	for host, b := range t.buckets {
+		if b.full(now) {
+			delete(t.buckets, host)
+		}
+	}
+	t.mu.Unlock()
+	closeIdleConnections(t.base)
+}
+
+func (t *rateLimitTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	b := t.bucket(req.URL.Host)
+	if d := b.reserve(time.Now()); d > 0 {
+		timer := time.NewTimer(d)
+		select {
+		case <-timer.C:
+		case <-req.Context().Done():
+			timer.Stop()
+			b.cancel()
+			if req.Body != nil {
+				req.Body.Close()
+			}
+			return nil, req.Context().Err()
+		}
+	}
+	return t.base.RoundTrip(req)
+}
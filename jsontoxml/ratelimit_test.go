@@ -0,0 +1,83 @@
+package jsontoxml
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestTokenBucket(t *testing.T) {
+	start := time.Now()
+	b := newTokenBucket(2, start)
+	// The burst is free, then a token is refilled every 500ms.
+	require.Equal(t, time.Duration(0), b.reserve(start))
+	require.Equal(t, time.Duration(0), b.reserve(start))
+	require.Equal(t, 500*time.Millisecond, b.reserve(start))
+	require.Equal(t, time.Second, b.reserve(start))
+	b.cancel()
+	require.Equal(t, 750*time.Millisecond, b.reserve(start.Add(250*time.Millisecond)))
+	// Idle time refills the bucket up to the burst only.
+	later := start.Add(time.Minute)
+	require.Equal(t, time.Duration(0), b.reserve(later))
+	require.Equal(t, time.Duration(0), b.reserve(later))
+	require.Equal(t, 500*time.Millisecond, b.reserve(later))
+
+	// Less than a request per second is allowed.
+	b = newTokenBucket(0.5, start)
+	require.Equal(t, time.Duration(0), b.reserve(start))
+	require.Equal(t, 2*time.Second, b.reserve(start))
+
+	require.NoError(t, checkRateLimit(0))
+	require.NoError(t, checkRateLimit(0.5))
+	require.EqualError(t, checkRateLimit(-1),
+		"invalid --rate-limit -1. Expected a positive number of requests per second")
+}
+
+func TestRateLimitTransport(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer srv.Close()
+	other := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer other.Close()
+
+	c := &http.Client{Transport: newRateLimitTransport(http.DefaultTransport, 20)}
+	get := func(url string) {
+		resp, err := c.Get(url)
+		require.NoError(t, err)
+		resp.Body.Close()
+	}
+	// The workers share the bucket of the host: 20 requests are a burst and
+	// the 10 others take 500ms.
+	begin := time.Now()
+	var wg sync.WaitGroup
+	for i := 0; i < 3; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 10; j++ {
+				get(srv.URL)
+			}
+		}()
+	}
+	wg.Wait()
+	require.True(t, time.Since(begin) >= 450*time.Millisecond, time.Since(begin))
+
+	// Other hosts have their own bucket.
+	begin = time.Now()
+	get(other.URL)
+	require.True(t, time.Since(begin) < 40*time.Millisecond, time.Since(begin))
+
+	// Waiting requests are canceled with their context.
+	c.Transport = newRateLimitTransport(http.DefaultTransport, 0.5)
+	get(srv.URL)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, srv.URL, nil)
+	require.NoError(t, err)
+	_, err = c.Do(req)
+	require.ErrorIs(t, err, context.DeadlineExceeded)
+}
@@ -0,0 +1,147 @@
+package jsontoxml
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"runtime"
+	"runtime/debug"
+	"sync"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+var (
+	recycleEvery time.Duration
+	memoryLimit  int64
+)
+
+func init() {
+	for _, cmd := range []*cobra.Command{serveCmd, workerCmd} {
+		cmd.Flags().DurationVar(&recycleEvery, "recycle-every", time.Hour,
+			"Interval at which the idle connections and the cached per host state of the"+
+				" transports are dropped and the free memory is returned to the OS, so that long"+
+				" runs don't slowly grow. Never if 0.")
+		cmd.Flags().Int64Var(&memoryLimit, "memory-limit", 0,
+			"Soft limit, in bytes, of the memory used by the process, like GOMEMLIMIT: the"+
+				" garbage collector runs more often as it gets close. GOMEMLIMIT applies if 0.")
+	}
+}
+
+// closeIdleConnections closes the idle connections of rt, if it keeps any.
+// The transports wrapping another one forward the call to it, like
+// http.Client.CloseIdleConnections does.
+func closeIdleConnections(rt http.RoundTripper) {
+	if c, ok := rt.(interface{ CloseIdleConnections() }); ok {
+		c.CloseIdleConnections()
+	}
+}
+
+// metricsWriter writes metrics in the Prometheus text format.
+type metricsWriter interface {
+	writeMetrics(w io.Writer)
+}
+
+// metricsHandler serves the metrics of all its writers.
+type metricsHandler []metricsWriter
+
+func (h metricsHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	for _, m := range h {
+		m.writeMetrics(w)
+	}
+}
+
+// memoryGuard keeps the memory of the daemons from growing over weeks of
+// uptime: it periodically drops the idle connections, with their buffers,
+// and returns the free memory to the OS. It records the heap after every
+// recycle to expose its growth since the start.
+type memoryGuard struct {
+	// closeIdle drops the idle connections, e.g. of fetchClient.
+	closeIdle func()
+	limit     int64
+
+	mu sync.Mutex
+	// baseline is the heap in use at the start, heap the one after the last
+	// recycle.
+	baseline, heap uint64
+	recycles       int64
+}
+
+// newMemoryGuard returns a guard calling closeIdle on every recycle. limit
+// sets the soft memory limit of the runtime if > 0.
+func newMemoryGuard(limit int64, closeIdle func()) *memoryGuard {
+	if limit > 0 {
+		debug.SetMemoryLimit(limit)
+	}
+	g := &memoryGuard{closeIdle: closeIdle, limit: debug.SetMemoryLimit(-1)}
+	runtime.GC()
+	g.baseline = heapInUse()
+	g.heap = g.baseline
+	return g
+}
+
+// heapInUse returns the bytes of heap objects, reachable or not yet freed.
+func heapInUse() uint64 {
+	var ms runtime.MemStats
+	runtime.ReadMemStats(&ms)
+	return ms.HeapAlloc
+}
+
+// recycle drops the idle connections and returns the free memory to the OS.
+// It returns the heap in use afterwards.
+func (g *memoryGuard) recycle() uint64 {
+	if g.closeIdle != nil {
+		g.closeIdle()
+	}
+	// Collects the garbage first, which also empties the sync.Pools.
+	debug.FreeOSMemory()
+	heap := heapInUse()
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.heap = heap
+	g.recycles++
+	return heap
+}
+
+// run recycles every interval until ctx is done. It returns immediately if
+// every is 0.
+func (g *memoryGuard) run(ctx context.Context, every time.Duration) {
+	if every <= 0 {
+		return
+	}
+	t := time.NewTicker(every)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+			heap := g.recycle()
+			log.Printf("Recycled idle connections. Heap: %d bytes (%+d since start)", heap,
+				int64(heap)-int64(g.baseline))
+		}
+	}
+}
+
+func (g *memoryGuard) writeMetrics(w io.Writer) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	fmt.Fprintln(w, "# HELP jsontoxml_heap_bytes Heap in use after the last recycle.")
+	fmt.Fprintln(w, "# TYPE jsontoxml_heap_bytes gauge")
+	fmt.Fprintf(w, "jsontoxml_heap_bytes %d\n", g.heap)
+	fmt.Fprintln(w, "# HELP jsontoxml_heap_growth_bytes Growth of the heap in use since the"+
+		" start, measured after the last recycle.")
+	fmt.Fprintln(w, "# TYPE jsontoxml_heap_growth_bytes gauge")
+	fmt.Fprintf(w, "jsontoxml_heap_growth_bytes %d\n", int64(g.heap)-int64(g.baseline))
+	fmt.Fprintln(w, "# HELP jsontoxml_recycles_total Recycles of the idle connections and"+
+		" free memory.")
+	fmt.Fprintln(w, "# TYPE jsontoxml_recycles_total counter")
+	fmt.Fprintf(w, "jsontoxml_recycles_total %d\n", g.recycles)
+	fmt.Fprintln(w, "# HELP jsontoxml_memory_limit_bytes Soft memory limit of the runtime.")
+	fmt.Fprintln(w, "# TYPE jsontoxml_memory_limit_bytes gauge")
+	fmt.Fprintf(w, "jsontoxml_memory_limit_bytes %d\n", g.limit)
+}
@@ -0,0 +1,54 @@
+package jsontoxml
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// idleCloser counts the calls to CloseIdleConnections.
+type idleCloser struct {
+	http.RoundTripper
+	closed int
+}
+
+func (c *idleCloser) CloseIdleConnections() {
+	c.closed++
+}
+
+func TestCloseIdleConnections(t *testing.T) {
+	base := &idleCloser{}
+	rl := newRateLimitTransport(base, 1)
+	client := &http.Client{Transport: &headerTransport{base: &decompressTransport{base: rl}}}
+
+	start := time.Now()
+	rl.bucket("a").reserve(start)
+	rl.bucket("b")
+	client.CloseIdleConnections()
+	require.Equal(t, 1, base.closed)
+	// The bucket of a is still refilling.
+	require.Len(t, rl.buckets, 1)
+	require.Contains(t, rl.buckets, "a")
+}
+
+func TestMemoryGuard(t *testing.T) {
+	closed := 0
+	g := newMemoryGuard(0, func() { closed++ })
+	g.recycle()
+	g.recycle()
+	require.Equal(t, 2, closed)
+
+	rec := httptest.NewRecorder()
+	metricsHandler{g}.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	require.Contains(t, rec.Body.String(), "jsontoxml_recycles_total 2\n")
+	require.Contains(t, rec.Body.String(), "jsontoxml_heap_growth_bytes ")
+
+	rec = httptest.NewRecorder()
+	metricsHandler{newTenantSet(), g}.ServeHTTP(rec, httptest.NewRequest(http.MethodGet,
+		"/metrics", nil))
+	require.Contains(t, rec.Body.String(), "# TYPE jsontoxml_tenant_requests_total counter\n")
+	require.Contains(t, rec.Body.String(), "jsontoxml_memory_limit_bytes ")
+}
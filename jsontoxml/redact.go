@@ -0,0 +1,133 @@
+package jsontoxml
+
+import (
+	"io"
+	"net/http"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+const redacted = "[REDACTED]"
+
+// redactor removes credentials from text before it is logged or dumped. It
+// redacts sensitive headers, sensitive query string parameters, passwords in
+// urls and the value of every resolved secret. It is safe for concurrent use.
+type redactor struct {
+	sync.RWMutex
+	headers map[string]bool
+	params  map[string]bool
+	values  []string
+	// paramExpr matches a sensitive query string parameter, headerExpr a
+	// sensitive header line.
+	paramExpr  *regexp.Regexp
+	headerExpr *regexp.Regexp
+}
+
+var (
+	userinfoExpr = regexp.MustCompile(`(://[^:/@\s]+):[^@/\s]+@`)
+	// defaultRedactor is used by every log line and capture.
+	defaultRedactor = newRedactor()
+	redactHeaders   []string
+	redactParams    []string
+)
+
+func init() {
+	rootCmd.PersistentFlags().StringArrayVar(&redactHeaders, "redact-header", nil,
+		"Additional header whose value is redacted from logs and captures. Can be repeated.")
+	rootCmd.PersistentFlags().StringArrayVar(&redactParams, "redact-param", nil,
+		"Additional query string parameter whose value is redacted from logs and"+
+			" captures. Can be repeated.")
+}
+
+func newRedactor() *redactor {
+	r := &redactor{headers: map[string]bool{}, params: map[string]bool{}}
+	r.addHeaders("Authorization", "Proxy-Authorization", "Cookie", "Set-Cookie",
+		"X-Api-Key", "X-Auth-Token", "X-Vault-Token", "X-Amz-Security-Token")
+	r.addParams("token", "access_token", "api_key", "apikey", "key", "secret",
+		"client_secret", "password", "signature", "sig", "X-Amz-Signature",
+		"X-Amz-Credential")
+	return r
+}
+
+// addHeaders marks the headers as sensitive.
+func (r *redactor) addHeaders(names ...string) {
+	r.Lock()
+	defer r.Unlock()
+	for _, n := range names {
+		r.headers[http.CanonicalHeaderKey(n)] = true
+	}
+	keys := make([]string, 0, len(r.headers))
+	for k := range r.headers {
+		keys = append(keys, regexp.QuoteMeta(k))
+	}
+	r.headerExpr = regexp.MustCompile(`(?im)^([<>] )?(` + strings.Join(keys, "|") + `): .*$`)
+}
+
+// addParams marks the query string parameters as sensitive.
+func (r *redactor) addParams(names ...string) {
+	r.Lock()
+	defer r.Unlock()
+	for _, n := range names {
+		r.params[strings.ToLower(n)] = true
+	}
+	keys := make([]string, 0, len(r.params))
+	for k := range r.params {
+		keys = append(keys, regexp.QuoteMeta(k))
+	}
+	r.paramExpr = regexp.MustCompile(`(?i)([?&](?:` + strings.Join(keys, "|") + `)=)[^&#\s"]*`)
+}
+
+// addValues redacts the values wherever they appear. Very short values are
+// ignored as they would mangle unrelated text.
+func (r *redactor) addValues(values ...string) {
+	r.Lock()
+	defer r.Unlock()
+	for _, v := range values {
+		if len(v) >= 4 {
+			r.values = append(r.values, v)
+		}
+	}
+}
+
+// header returns true if the value of the header must not be revealed.
+func (r *redactor) header(name string) bool {
+	r.RLock()
+	defer r.RUnlock()
+	return r.headers[http.CanonicalHeaderKey(name)]
+}
+
+// redact returns s without any credentials.
+func (r *redactor) redact(s string) string {
+	r.RLock()
+	defer r.RUnlock()
+	for _, v := range r.values {
+		s = strings.Replace(s, v, redacted, -1)
+	}
+	s = userinfoExpr.ReplaceAllString(s, "$1:"+redacted+"@")
+	s = r.paramExpr.ReplaceAllString(s, "${1}"+redacted)
+	return r.headerExpr.ReplaceAllString(s, "${1}${2}: "+redacted)
+}
+
+// redactingWriter redacts everything written to it before passing it on.
+type redactingWriter struct {
+	w io.Writer
+	r *redactor
+}
+
+func (rw redactingWriter) Write(p []byte) (int, error) {
+	if _, err := rw.w.Write([]byte(rw.r.redact(string(p)))); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// setupRedaction configures the default redactor from the flags and the loaded
+// secrets.
+func setupRedaction() {
+	defaultRedactor.addHeaders(redactHeaders...)
+	defaultRedactor.addParams(redactParams...)
+	for _, v := range secrets {
+		defaultRedactor.addValues(v)
+	}
+}
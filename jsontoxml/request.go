@@ -0,0 +1,60 @@
+package jsontoxml
+
+import (
+	"io/ioutil"
+	"net/http"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+var (
+	methodFlag   string
+	bodyFlag     string
+	bodyFileFlag string
+	// requestMethod and requestBody are those of the requests of the urls.
+	// requestBody is nil if the requests have no body.
+	requestMethod = http.MethodGet
+	requestBody   []byte
+)
+
+func init() {
+	rootCmd.PersistentFlags().StringVar(&methodFlag, "method", http.MethodGet,
+		"Method of the requests of the urls: GET, POST, PUT or PATCH, e.g. for GraphQL or search"+
+			" APIs taking a query in the request body.")
+	rootCmd.PersistentFlags().StringVar(&bodyFlag, "body", "",
+		"Body sent with every request, e.g. a json query. The body can refer to secrets as"+
+			" ${secret:name}. Sent as application/json unless --header sets the Content-Type.")
+	rootCmd.PersistentFlags().StringVar(&bodyFileFlag, "body-file", "",
+		"File holding the body sent with every request, instead of --body.")
+}
+
+// parseRequest parses the --method, --body and --body-file flags. Secrets must
+// be loaded first.
+func parseRequest() (string, []byte, error) {
+	method := strings.ToUpper(methodFlag)
+	switch method {
+	case http.MethodGet, http.MethodPost, http.MethodPut, http.MethodPatch:
+	default:
+		return "", nil, errors.Errorf("invalid --method %q. Expected GET, POST, PUT or PATCH",
+			methodFlag)
+	}
+	body := bodyFlag
+	switch {
+	case len(bodyFlag) > 0 && len(bodyFileFlag) > 0:
+		return "", nil, errors.New("--body and --body-file are mutually exclusive")
+	case len(bodyFileFlag) > 0:
+		data, err := ioutil.ReadFile(bodyFileFlag)
+		if err != nil {
+			return "", nil, errors.Wrap(err, "--body-file")
+		}
+		body = string(data)
+	case len(bodyFlag) == 0:
+		return method, nil, nil
+	}
+	body, err := expandSecrets(body)
+	if err != nil {
+		return "", nil, errors.Wrap(err, "request body")
+	}
+	return method, []byte(body), nil
+}
@@ -0,0 +1,74 @@
+package jsontoxml
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseRequest(t *testing.T) {
+	defer func() { methodFlag, bodyFlag, bodyFileFlag = http.MethodGet, "", "" }()
+	method, body, err := parseRequest()
+	require.NoError(t, err)
+	require.Equal(t, http.MethodGet, method)
+	require.Nil(t, body)
+
+	secrets["token"] = "s3cr3t"
+	defer delete(secrets, "token")
+	methodFlag, bodyFlag = "post", `{"token": "${secret:token}"}`
+	method, body, err = parseRequest()
+	require.NoError(t, err)
+	require.Equal(t, http.MethodPost, method)
+	require.Equal(t, `{"token": "s3cr3t"}`, string(body))
+
+	dir, err := ioutil.TempDir("", "request")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "query.graphql.json")
+	require.NoError(t, ioutil.WriteFile(path, []byte(`{"query": "{ items { id } }"}`), 0644))
+	bodyFlag, bodyFileFlag = "", path
+	_, body, err = parseRequest()
+	require.NoError(t, err)
+	require.Equal(t, `{"query": "{ items { id } }"}`, string(body))
+
+	bodyFlag = "{}"
+	_, _, err = parseRequest()
+	require.EqualError(t, err, "--body and --body-file are mutually exclusive")
+	methodFlag, bodyFlag, bodyFileFlag = "DELETE", "", ""
+	_, _, err = parseRequest()
+	require.EqualError(t, err, `invalid --method "DELETE". Expected GET, POST, PUT or PATCH`)
+}
+
+func TestRequestBody(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprintf(w, `{"method": %q, "type": %q, "body": %q}`, r.Method,
+			r.Header.Get("Content-Type"), body)
+	}))
+	defer srv.Close()
+
+	query := []byte(`{"query": "a"}`)
+	// Every request, e.g. every retry, sends the whole body.
+	for i := 0; i < 2; i++ {
+		src := &urlSource{client: srv.Client(), method: http.MethodPost, body: query}
+		body, _, err := src.fetch(context.Background(), Metadata{URL: srv.URL})
+		require.NoError(t, err)
+		data, err := ioutil.ReadAll(body)
+		body.Close()
+		require.NoError(t, err)
+		require.JSONEq(t, `{"method": "POST", "type": "application/json",
+			"body": "{\"query\": \"a\"}"}`, string(data))
+	}
+
+	src := &urlSource{client: new(mockClient), method: http.MethodPost, body: query}
+	_, _, err := src.fetch(context.Background(), Metadata{URL: "valid"})
+	require.EqualError(t, err, "get failed: the client can't send POST requests with a body")
+}
@@ -0,0 +1,171 @@
+package jsontoxml
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// retry is a task waiting for its next attempt.
+type retry struct {
+	Task        task      `json:"task"`
+	NextAttempt time.Time `json:"next_attempt"`
+}
+
+// retryQueue holds the tasks that failed and are scheduled to be retried. If
+// path is set, the queue is written to disk on every change so that a restarted
+// coordinator resumes the backoff schedule instead of forgetting the failed
+// urls or retrying all of them at once. retryQueue is not safe for concurrent
+// use.
+type retryQueue struct {
+	path    string
+	entries []retry
+}
+
+// loadRetryQueue returns the retry queue persisted at path. A missing file
+// yields an empty queue. An empty path disables persistence.
+func loadRetryQueue(path string) (*retryQueue, error) {
+	q := &retryQueue{path: path}
+	if len(path) == 0 {
+		return q, nil
+	}
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return q, nil
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "read retry queue")
+	}
+	if err := json.Unmarshal(data, &q.entries); err != nil {
+		return nil, errors.Wrapf(err, "parse retry queue %q", path)
+	}
+	q.sort()
+	return q, nil
+}
+
+func (q *retryQueue) sort() {
+	sort.SliceStable(q.entries, func(i, j int) bool {
+		return q.entries[i].NextAttempt.Before(q.entries[j].NextAttempt)
+	})
+}
+
+// push schedules a retry and persists the queue.
+func (q *retryQueue) push(r retry) error {
+	q.entries = append(q.entries, r)
+	q.sort()
+	return q.save()
+}
+
+// popDue removes and returns all the retries due at now.
+func (q *retryQueue) popDue(now time.Time) ([]retry, error) {
+	n := 0
+	for n < len(q.entries) && !q.entries[n].NextAttempt.After(now) {
+		n++
+	}
+	if n == 0 {
+		return nil, nil
+	}
+	due := append([]retry(nil), q.entries[:n]...)
+	q.entries = q.entries[n:]
+	return due, q.save()
+}
+
+// has returns true if the task of url is waiting for a retry.
+func (q *retryQueue) has(url string) bool {
+	for _, r := range q.entries {
+		if r.Task.URL == url {
+			return true
+		}
+	}
+	return false
+}
+
+// restore matches the retries of a previous run with tasks by url, as the
+// position of a url may have changed since. The retries of the urls no longer
+// in tasks are dropped, the others take the ID of their task. restore returns
+// the tasks that aren't waiting for a retry.
+func (q *retryQueue) restore(tasks []task) ([]task, error) {
+	ids := make(map[string][]int)
+	for _, t := range tasks {
+		ids[t.URL] = append(ids[t.URL], t.ID)
+	}
+	kept := q.entries[:0]
+	waiting := make(map[int]bool)
+	for _, r := range q.entries {
+		// A url listed twice has a task per position.
+		if len(ids[r.Task.URL]) == 0 {
+			continue
+		}
+		r.Task.ID, ids[r.Task.URL] = ids[r.Task.URL][0], ids[r.Task.URL][1:]
+		waiting[r.Task.ID] = true
+		kept = append(kept, r)
+	}
+	dropped := len(q.entries) - len(kept)
+	q.entries = kept
+	var rest []task
+	for _, t := range tasks {
+		if !waiting[t.ID] {
+			rest = append(rest, t)
+		}
+	}
+	if dropped == 0 {
+		return rest, nil
+	}
+	return rest, q.save()
+}
+
+func (q *retryQueue) len() int {
+	return len(q.entries)
+}
+
+// save atomically writes the queue to disk.
+func (q *retryQueue) save() error {
+	if len(q.path) == 0 {
+		return nil
+	}
+	data, err := json.MarshalIndent(q.entries, "", "  ")
+	if err != nil {
+		return errors.Wrap(err, "encode retry queue")
+	}
+	tmp, err := ioutil.TempFile(filepath.Dir(q.path), filepath.Base(q.path)+".tmp")
+	if err != nil {
+		return errors.Wrap(err, "save retry queue")
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return errors.Wrap(err, "save retry queue")
+	}
+	if err := tmp.Close(); err != nil {
+		return errors.Wrap(err, "save retry queue")
+	}
+	return errors.Wrap(os.Rename(tmp.Name(), q.path), "save retry queue")
+}
+
+// backoff returns the delay before the given attempt. The delay doubles on every
+// attempt.
+func backoff(base time.Duration, attempt int) time.Duration {
+	if attempt < 1 {
+		attempt = 1
+	}
+	d := base
+	for i := 1; i < attempt && d < time.Hour; i++ {
+		d *= 2
+	}
+	return d
+}
+
+// jitter returns a random delay between d/2 and d so that the urls that failed
+// together aren't retried at the same time.
+func jitter(d time.Duration) time.Duration {
+	if d <= 1 {
+		return d
+	}
+	return d/2 + time.Duration(rand.Int63n(int64(d/2)+1))
+}
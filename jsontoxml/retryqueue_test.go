@@ -0,0 +1,101 @@
+package jsontoxml
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestRetryQueuePersistence(t *testing.T) {
+	dir, err := ioutil.TempDir("", "retryqueue")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "retries.json")
+
+	now := time.Now()
+	q, err := loadRetryQueue(path)
+	require.NoError(t, err)
+	require.NoError(t, q.push(retry{Task: task{ID: 1, URL: "b", Attempt: 2},
+		NextAttempt: now.Add(time.Hour)}))
+	require.NoError(t, q.push(retry{Task: task{ID: 0, URL: "a", Attempt: 2},
+		NextAttempt: now.Add(time.Minute)}))
+
+	// A restarted process sees the same schedule.
+	q, err = loadRetryQueue(path)
+	require.NoError(t, err)
+	require.Equal(t, 2, q.len())
+	require.True(t, q.has("a"))
+
+	due, err := q.popDue(now)
+	require.NoError(t, err)
+	require.Empty(t, due)
+	due, err = q.popDue(now.Add(2 * time.Minute))
+	require.NoError(t, err)
+	require.Len(t, due, 1)
+	require.Equal(t, "a", due[0].Task.URL)
+
+	q, err = loadRetryQueue(path)
+	require.NoError(t, err)
+	require.Equal(t, 1, q.len())
+	require.False(t, q.has("a"))
+}
+
+func TestCoordinatorRetries(t *testing.T) {
+	q := &retryQueue{}
+	// Task 1 is still waiting for its retry from a previous run.
+	require.NoError(t, q.push(retry{Task: task{ID: 1, URL: "b", Attempt: 2},
+		NextAttempt: time.Now().Add(time.Hour)}))
+	c := newCoordinator([]task{{ID: 0, URL: "a"}, {ID: 1, URL: "b"}}, time.Minute, q)
+	c.maxRetries, c.backoff = 1, time.Millisecond
+
+	tk, _ := c.lease(time.Now())
+	require.Equal(t, "a", tk.URL)
+	c.complete(completeRequest{ID: tk.ID, Error: "failed"})
+	require.True(t, q.has("a"))
+
+	tk, _ = c.lease(time.Now().Add(time.Second))
+	require.Equal(t, "a", tk.URL)
+	require.Equal(t, 2, tk.Attempt)
+	c.complete(completeRequest{ID: tk.ID, Error: "failed"})
+	require.Equal(t, 1, c.failed)
+
+	tk, done := c.lease(time.Now())
+	require.Nil(t, tk)
+	require.False(t, done)
+}
+
+func TestCoordinatorRestoredRetries(t *testing.T) {
+	dir, err := ioutil.TempDir("", "retryqueue")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "retries.json")
+	q, err := loadRetryQueue(path)
+	require.NoError(t, err)
+	due := time.Now().Add(-time.Second)
+	require.NoError(t, q.push(retry{Task: task{ID: 1, URL: "b", Attempt: 2}, NextAttempt: due}))
+	require.NoError(t, q.push(retry{Task: task{ID: 2, URL: "gone", Attempt: 2}, NextAttempt: due}))
+
+	// b moved to the first position of the list, and gone was removed.
+	c := newCoordinator([]task{{ID: 0, URL: "b"}, {ID: 1, URL: "c"}}, time.Minute, q)
+	require.Equal(t, 2, c.total)
+	require.Equal(t, 1, q.len())
+	require.Equal(t, []task{{ID: 1, URL: "c", Attempt: 1}}, c.pending)
+	// The dropped retry is gone from disk too.
+	saved, err := loadRetryQueue(path)
+	require.NoError(t, err)
+	require.False(t, saved.has("gone"))
+
+	tk, _ := c.lease(time.Now())
+	require.Equal(t, &task{ID: 1, URL: "c", Attempt: 1}, tk)
+	tk, _ = c.lease(time.Now())
+	require.Equal(t, &task{ID: 0, URL: "b", Attempt: 2}, tk)
+}
+
+func TestBackoff(t *testing.T) {
+	require.Equal(t, time.Second, backoff(time.Second, 1))
+	require.Equal(t, 4*time.Second, backoff(time.Second, 3))
+}
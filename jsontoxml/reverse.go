@@ -0,0 +1,187 @@
+package jsontoxml
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"io"
+	"log"
+	"os"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+)
+
+var reverseCmd = &cobra.Command{
+	Use:   "reverse [file]",
+	Short: "Convert xml generated with --lossless back to json",
+	Long: `reverse reads xml generated with --lossless from the file (or stdin) and writes the` +
+		` equivalent json to stdout.`,
+	Args: cobra.MaximumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		in := io.Reader(os.Stdin)
+		if len(args) == 1 {
+			f, err := os.Open(args[0])
+			if err != nil {
+				log.Fatal(err)
+			}
+			defer f.Close()
+			in = f
+		}
+		if err := xmlToJson(in, os.Stdout); err != nil {
+			log.Fatal(err)
+		}
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(reverseCmd)
+}
+
+// xnode is an element of the xml tree.
+type xnode struct {
+	name  string
+	attrs map[string]string
+	// attrList holds the attributes with their namespaces.
+	attrList []xml.Attr
+	text     strings.Builder
+	children []*xnode
+}
+
+// xmlToJson converts xml produced by genericToXml back to json.
+func xmlToJson(r io.Reader, w io.Writer) error {
+	root, err := parseXMLTree(r)
+	if err != nil {
+		return err
+	}
+	v, err := root.value()
+	if err != nil {
+		return err
+	}
+	enc := json.NewEncoder(w)
+	enc.SetEscapeHTML(false)
+	enc.SetIndent("", "  ")
+	return errors.Wrap(enc.Encode(v), "json.Marshal")
+}
+
+// parseXMLTree returns the root element of the xml document.
+func parseXMLTree(r io.Reader) (*xnode, error) {
+	dec := xml.NewDecoder(r)
+	// Entities declared in a DOCTYPE aren't expanded by encoding/xml.
+	dec.Strict = false
+	var stack []*xnode
+	var root *xnode
+	for {
+		tok, err := dec.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, errors.Wrap(err, "xml.Unmarshal")
+		}
+		switch t := tok.(type) {
+		case xml.StartElement:
+			n := &xnode{name: t.Name.Local, attrs: make(map[string]string), attrList: t.Attr}
+			for _, a := range t.Attr {
+				n.attrs[a.Name.Local] = a.Value
+			}
+			if len(stack) > 0 {
+				parent := stack[len(stack)-1]
+				parent.children = append(parent.children, n)
+			} else if root == nil {
+				root = n
+			}
+			stack = append(stack, n)
+		case xml.EndElement:
+			stack = stack[:len(stack)-1]
+		case xml.CharData:
+			if len(stack) > 0 {
+				stack[len(stack)-1].text.Write(t)
+			}
+		}
+	}
+	if root == nil {
+		return nil, errors.New("no root element")
+	}
+	return root, nil
+}
+
+// key returns the json key of the element.
+func (n *xnode) key() (string, error) {
+	k, ok := n.attrs[keyAttr]
+	if !ok {
+		return n.name, nil
+	}
+	if n.attrs[escapedAttr] == "json" {
+		return n.unquote(k)
+	}
+	return k, nil
+}
+
+// unquote decodes a json string literal of the element, see escapedAttr.
+func (n *xnode) unquote(s string) (string, error) {
+	var v string
+	if err := json.Unmarshal([]byte(s), &v); err != nil {
+		return "", errors.Errorf("invalid escaped string %q in <%s>", s, n.name)
+	}
+	return v, nil
+}
+
+// value returns the json value of the element. Elements without a type are
+// objects if they have children and strings otherwise.
+func (n *xnode) value() (interface{}, error) {
+	text := n.text.String()
+	switch n.attrs[typeAttr] {
+	case "null":
+		return nil, nil
+	case "bool":
+		switch strings.TrimSpace(text) {
+		case "true":
+			return true, nil
+		case "false":
+			return false, nil
+		}
+		return nil, errors.Errorf("invalid bool %q in <%s>", text, n.name)
+	case "number":
+		num := json.Number(strings.TrimSpace(text))
+		if _, err := num.Float64(); err != nil {
+			return nil, errors.Errorf("invalid number %q in <%s>", text, n.name)
+		}
+		return num, nil
+	case "array":
+		arr := make([]interface{}, 0, len(n.children))
+		for _, c := range n.children {
+			v, err := c.value()
+			if err != nil {
+				return nil, err
+			}
+			arr = append(arr, v)
+		}
+		return arr, nil
+	case "object":
+		return n.object()
+	}
+	if len(n.children) > 0 {
+		return n.object()
+	}
+	if n.attrs[escapedAttr] == "json" {
+		return n.unquote(text)
+	}
+	return text, nil
+}
+
+func (n *xnode) object() (interface{}, error) {
+	obj := make(map[string]interface{}, len(n.children))
+	for _, c := range n.children {
+		v, err := c.value()
+		if err != nil {
+			return nil, err
+		}
+		k, err := c.key()
+		if err != nil {
+			return nil, err
+		}
+		obj[k] = v
+	}
+	return obj, nil
+}
@@ -0,0 +1,237 @@
+package jsontoxml
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v3"
+)
+
+var (
+	routesFile string
+	// routes are loaded from --routes.
+	routes []Route
+)
+
+func init() {
+	rootCmd.PersistentFlags().StringVar(&routesFile, "routes", "",
+		"Yaml file of routing rules sending every record to the output of the first rule it"+
+			" matches, e.g. the records of a state to their own bucket, instead of --output.")
+}
+
+// Route sends the records it matches to its own destination.
+type Route struct {
+	// Name identifies the route in the warnings.
+	Name string
+	// Match returns true if the json record, decoded with json.Number numbers,
+	// takes the route. Every record does if nil.
+	Match func(record interface{}) bool
+	// NewSink returns a sink for the documents of the route.
+	NewSink func() Sink
+}
+
+// routeRule is a route of the --routes file:
+//
+//	routes:
+//	  - name: california
+//	    field: address.state
+//	    equals: CA
+//	    output: s3://bucket/ca
+//	  - name: rest
+//	    output: ./out/rest
+//
+// field is the dotted path of a value of the record. The rule matches if the
+// value equals equals, or one of in, or if it is set, as told by exists. A
+// rule without a field matches every record.
+type routeRule struct {
+	Name   string   `yaml:"name"`
+	Field  string   `yaml:"field"`
+	Equals *string  `yaml:"equals"`
+	In     []string `yaml:"in"`
+	Exists *bool    `yaml:"exists"`
+	Output string   `yaml:"output"`
+}
+
+// loadRoutes reads the routes of the --routes file at p.
+func loadRoutes(p string) ([]Route, error) {
+	data, err := ioutil.ReadFile(p)
+	if err != nil {
+		return nil, errors.Wrap(err, "--routes")
+	}
+	var file struct {
+		Routes []routeRule `yaml:"routes"`
+	}
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	dec.KnownFields(true)
+	if err := dec.Decode(&file); err != nil && err != io.EOF {
+		return nil, errors.Wrapf(err, "--routes %s", p)
+	}
+	if len(file.Routes) == 0 {
+		return nil, errors.Errorf("--routes %s has no route", p)
+	}
+	rs := make([]Route, 0, len(file.Routes))
+	for i, rule := range file.Routes {
+		r, err := rule.route()
+		if err != nil {
+			return nil, errors.Wrapf(err, "--routes %s: route %d", p, i+1)
+		}
+		if len(r.Name) == 0 {
+			r.Name = "route " + strconv.Itoa(i+1)
+		}
+		rs = append(rs, r)
+	}
+	return rs, nil
+}
+
+// checkRoutes validates the flags used with --routes. The routes replace
+// --output, and write several documents per payload.
+func checkRoutes() error {
+	switch {
+	case fragments, len(splitLanguageField) > 0:
+		return errors.New("--routes can't be used with --fragments nor --split-language-field")
+	case len(singleFile) > 0:
+		return errors.New("--routes can't be used with --single-file")
+	case checksums == ChecksumSums:
+		return errors.New("--routes can't be used with --checksums sums")
+	}
+	return nil
+}
+
+// route builds the route of the rule.
+func (rule routeRule) route() (Route, error) {
+	if len(rule.Output) == 0 || rule.Output == "-" {
+		return Route{}, errors.New("expected an output other than -")
+	}
+	conds := 0
+	for _, set := range []bool{rule.Equals != nil, rule.In != nil, rule.Exists != nil} {
+		if set {
+			conds++
+		}
+	}
+	if len(rule.Field) == 0 && conds > 0 {
+		return Route{}, errors.New("equals, in and exists need a field")
+	}
+	if len(rule.Field) > 0 && conds != 1 {
+		return Route{}, errors.New("expected one of equals, in or exists")
+	}
+	newSink, err := sinkFactory(rule.Output)
+	if err != nil {
+		return Route{}, err
+	}
+	r := Route{Name: rule.Name, NewSink: newSink}
+	if len(rule.Field) == 0 {
+		return r, nil
+	}
+	path := strings.Split(rule.Field, ".")
+	r.Match = func(record interface{}) bool {
+		v, ok := lookupPath(record, path)
+		switch {
+		case rule.Exists != nil:
+			return ok == *rule.Exists
+		case !ok || !isScalar(v):
+			return false
+		case rule.Equals != nil:
+			return fmt.Sprint(v) == *rule.Equals
+		}
+		s := fmt.Sprint(v)
+		for _, want := range rule.In {
+			if s == want {
+				return true
+			}
+		}
+		return false
+	}
+	return r, nil
+}
+
+// lookupPath returns the value at the path of object keys of v. ok is false
+// if it isn't set, or is null.
+func lookupPath(v interface{}, path []string) (_ interface{}, ok bool) {
+	for _, k := range path {
+		obj, isObj := v.(map[string]interface{})
+		if !isObj {
+			return nil, false
+		}
+		v = obj[k]
+	}
+	return v, v != nil
+}
+
+// routeSink writes the records of a payload to one document per route, with
+// the sinks of the routes.
+type routeSink struct {
+	splitSink
+	routes []Route
+	// sinks are the sinks of the routes.
+	sinks []Sink
+}
+
+// newRouteSink returns the sink of the routes. wrap adds the wrappers of the
+// documents, e.g. statsSink, to the sinks of the routes.
+func newRouteSink(routes []Route, wrap func(Sink) Sink) *routeSink {
+	s := &routeSink{routes: routes}
+	for _, r := range routes {
+		s.sinks = append(s.sinks, wrap(r.NewSink()))
+	}
+	return s
+}
+
+// route returns the index of the first route matching the json record, or
+// -1.
+func (s *routeSink) route(record json.RawMessage) int {
+	dec := json.NewDecoder(bytes.NewReader(record))
+	dec.UseNumber()
+	var v interface{}
+	if err := dec.Decode(&v); err != nil {
+		v = nil
+	}
+	for i, r := range s.routes {
+		if r.Match == nil || r.Match(v) {
+			return i
+		}
+	}
+	return -1
+}
+
+// convertRoutes converts the records of the json document read from r to
+// one document per route of s, in the order of the routes. The records
+// matching no route are dropped with a warning.
+func convertRoutes(r io.Reader, s *routeSink, opts *convertOptions) error {
+	if opts == nil {
+		opts = &convertOptions{}
+	}
+	records, array, err := readRecords(r)
+	if err != nil {
+		return err
+	}
+	groups := make([][]json.RawMessage, len(s.routes))
+	for _, record := range records {
+		i := s.route(record)
+		if i < 0 {
+			opts.warn("record matching no route dropped")
+			continue
+		}
+		groups[i] = append(groups[i], record)
+	}
+	for i, group := range groups {
+		if len(group) == 0 {
+			continue
+		}
+		if err := s.start(s.sinks[i], s.name); err != nil {
+			return err
+		}
+		// Every route publishes its own statistics and files.
+		o := *opts
+		o.stats, o.attachments = sinkStats(s.sinks[i]), sinkAttachments(s.sinks[i])
+		if err := convertRecords(group, array, s, &o); err != nil {
+			return errors.Wrapf(err, "route %s", s.routes[i].Name)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,108 @@
+package jsontoxml
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestLoadRoutes(t *testing.T) {
+	dir := t.TempDir()
+	p := filepath.Join(dir, "routes.yaml")
+	write := func(s string) {
+		require.NoError(t, ioutil.WriteFile(p, []byte(s), 0600))
+	}
+	write(`routes:
+  - name: west
+    field: address.state
+    in: [CA, OR]
+  - field: id
+    equals: "7"
+    output: ./b
+  - field: vip
+    exists: true
+    output: ./c
+  - output: ./d
+`)
+	_, err := loadRoutes(p)
+	require.Error(t, err, "the first route has no output")
+
+	write(`routes:
+  - name: west
+    field: address.state
+    in: [CA, OR]
+    output: ./a
+  - field: id
+    equals: "7"
+    output: ./b
+  - field: vip
+    exists: true
+    output: ./c
+  - output: ./d
+`)
+	rs, err := loadRoutes(p)
+	require.NoError(t, err)
+	require.Len(t, rs, 4)
+	require.Equal(t, "west", rs[0].Name)
+	require.Equal(t, "route 2", rs[1].Name)
+	sink := newRouteSink(rs, func(s Sink) Sink { return s })
+	for record, want := range map[string]int{
+		`{"address": {"state": "OR"}}`: 0,
+		`{"address": "CA"}`:            3,
+		`{"id": 7}`:                    1,
+		`{"id": "7"}`:                  1,
+		`{"vip": false}`:               2,
+		`{"vip": null}`:                3,
+		`[]`:                           3,
+	} {
+		require.Equal(t, want, sink.route(json.RawMessage(record)), record)
+	}
+
+	write(`routes:
+  - field: id
+    output: ./a
+`)
+	_, err = loadRoutes(p)
+	require.Error(t, err)
+	write(`routes:
+  - output: ./a
+    unknown: 1
+`)
+	_, err = loadRoutes(p)
+	require.Error(t, err)
+}
+
+func TestRunnerRoutes(t *testing.T) {
+	dir := t.TempDir()
+	src := filepath.Join(dir, "in.json")
+	payload := `[{"id": 1, "State": "CA"}, {"id": 2, "State": "NY"}, {"id": 3, "State": "CA"},` +
+		` {"id": 4}]`
+	require.NoError(t, ioutil.WriteFile(src, []byte(payload), 0600))
+	var ca, ny memSinks
+	match := func(state string) func(interface{}) bool {
+		return func(v interface{}) bool {
+			s, _ := lookupPath(v, []string{"State"})
+			return s == state
+		}
+	}
+	r := &Runner{
+		Routes: []Route{
+			{Name: "ca", Match: match("CA"), NewSink: ca.newSink},
+			{Name: "ny", Match: match("NY"), NewSink: ny.newSink},
+		},
+		Stats: StatsComment,
+	}
+	r.Add(&fileSource{paths: []string{src}})
+	rep, err := r.Run(context.Background())
+	require.NoError(t, err)
+	require.Zero(t, rep.Failed)
+	require.Contains(t, ca.docs["0.xml"], "<Id>3</Id>")
+	require.Contains(t, ca.docs["0.xml"], `{"records":2,`)
+	require.NotContains(t, ca.docs["0.xml"], "<Id>2</Id>")
+	require.Contains(t, ny.docs["0.xml"], `{"records":1,`)
+	require.Equal(t, []string{"record matching no route dropped"}, rep.Warnings[src])
+}
@@ -0,0 +1,557 @@
+package jsontoxml
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"path"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/pkg/errors"
+	"golang.org/x/sync/errgroup"
+)
+
+// Runner converts the payloads of its sources with a pool of workers and
+// writes them to its sink. It lets Go programs drive a batch without the
+// command line. The exported fields must be set before Run is called.
+type Runner struct {
+	// Workers is the number of payloads processed concurrently. 1 if < 1.
+	Workers int
+	// Adaptive scales the number of attempts in progress between 1 and
+	// Workers with their latency and failures, see aimdLimiter.
+	Adaptive bool
+	// Retries is the number of times a url is fetched again after a transient
+	// failure (see isTransient), Backoff (doubled after every attempt, with
+	// jitter) after the previous attempt. Once they are exhausted, or on any
+	// other failure, the mirrors of the url are tried in the same way. Payloads
+	// of other sources can't be read again and aren't retried.
+	Retries int
+	Backoff time.Duration
+	// Deadline is the maximum time spent on a payload, for every attempt. A
+	// payload that takes longer fails and is counted as timed out. No limit if
+	// 0.
+	Deadline time.Duration
+	// Namer names the outputs. IndexNamer if nil.
+	Namer Namer
+	// NewSink returns the sink of a worker. Required.
+	NewSink func() Sink
+	// Hooks report the progress of the run.
+	Hooks Hooks
+	// Partial decides what happens to the outputs being written when the
+	// context of Run is canceled. PartialDelete if empty.
+	Partial PartialPolicy
+	// Overwrite decides what happens to the outputs that already exist, for
+	// the sinks implementing ExistingSink. OverwriteReplace if empty.
+	Overwrite OverwritePolicy
+	// Validate checks or rewrites every payload before it is converted. Nil
+	// accepts every payload.
+	Validate Validator
+	// IgnoreContentType skips the check of the Content-Type of the payloads.
+	// The payloads that start like a json object or array are converted
+	// instead.
+	IgnoreContentType bool
+	// Transport sends the requests of the urls added with AddURLs, e.g. to add
+	// authentication or tracing, or to serve them from a test double.
+	// http.DefaultTransport if nil.
+	Transport http.RoundTripper
+	// Timeout bounds every request of the urls added with AddURLs, including
+	// the read of the body. No limit if 0.
+	Timeout time.Duration
+	// Method and Body are those of the requests of the urls added with
+	// AddURLs. GET if Method is empty, and no body if Body is nil.
+	Method string
+	Body   []byte
+	// Budget bounds the run. No limits if zero.
+	Budget Budget
+	// Fragments writes every record of the json arrays as a separate
+	// document, named after the document of the payload with the index of the
+	// record.
+	Fragments bool
+	// Checksums publishes the SHA-256 checksums of the documents committed by
+	// the run. The SHA256SUMS file of ChecksumSums is written with a sink of
+	// NewSink once the run ends, even if it was canceled.
+	Checksums ChecksumMode
+	// Stats publishes the statistics of every document.
+	Stats StatsMode
+	// SplitLanguageField, if set, is the json key holding the language of the
+	// records: the records of every payload are written to one document per
+	// language, under a directory named after it.
+	SplitLanguageField string
+	// Routes, if set, send every record of the payloads to the sink of the
+	// first route it matches instead of NewSink. They can't be used with
+	// Fragments, SplitLanguageField nor ChecksumSums.
+	Routes []Route
+	// Ledger, if set, records the documents committed to the sinks, which
+	// skip the documents it has already delivered. See DeliveryLedger.
+	Ledger *DeliveryLedger
+	// Options convert the payloads. Those of the command line flags if nil.
+	Options *Options
+
+	opts    *convertOptions
+	capture *failureCapture
+	// pages follows the pages of the urls added with AddURLs, if set.
+	pages *pagination
+	// accepted are the statuses other than 2xx whose payloads are converted.
+	accepted statusSet
+
+	mu      sync.Mutex
+	sources []Source
+}
+
+// runState is the state of a call to Run, kept apart from the Runner so that
+// concurrent runs don't share it.
+type runState struct {
+	// opts convert the payloads.
+	opts *convertOptions
+	// sums collects the checksums of ChecksumSums.
+	sums *checksumList
+	// warnings collects the warnings of the converted payloads, by url.
+	warnMu   sync.Mutex
+	warnings map[string][]string
+}
+
+// Report summarizes a run.
+type Report struct {
+	// Processed is the number of payloads processed, Failed the number of
+	// those that couldn't be converted.
+	Processed int
+	Failed    int
+	// TimedOut is the number of failed payloads that exceeded the deadline.
+	TimedOut int
+	Duration time.Duration
+	// Errors holds the final error of every failed payload, by url.
+	Errors map[string]error
+	// Warnings holds the warnings of every payload converted with warnings,
+	// by url. See docWarnings.
+	Warnings map[string][]string
+	// Exceeded is set if the run was stopped by its budget.
+	Exceeded *BudgetError
+}
+
+// errTimedOut is the cause of the failures of payloads that exceeded the
+// deadline.
+var errTimedOut = errors.New("deadline exceeded")
+
+// isTransient reports whether err may go away if the url is fetched again: the
+// url couldn't be fetched or its body couldn't be read, the fetch timed out, or
+// the server answered with a 5xx or 429 status.
+func isTransient(err error) bool {
+	switch e := errors.Cause(err).(type) {
+	case *fetchError, *readError:
+		return true
+	case *statusError:
+		return e.code >= 500 || e.code == http.StatusTooManyRequests
+	case net.Error:
+		return e.Timeout()
+	}
+	cause := errors.Cause(err)
+	return cause == errTimedOut || cause == context.DeadlineExceeded
+}
+
+// Hooks are called as a run progresses, e.g. to show live progress in a UI. They
+// are called concurrently by the workers and must be safe for concurrent use.
+// Nil hooks are skipped.
+type Hooks struct {
+	// OnFetchStart is called before a payload is read, for every attempt.
+	OnFetchStart func(m Metadata, attempt int)
+	// OnConverted is called once a payload has been converted and written to
+	// the output name.
+	OnConverted func(m Metadata, name string)
+	// OnError is called when an attempt fails. retry is set if the payload
+	// will be read again. attempt is 0 if the payload failed before its first
+	// attempt, e.g. because its output exists with OverwriteError.
+	OnError func(m Metadata, attempt int, err error, retry bool)
+	// OnComplete is called once the run is over.
+	OnComplete func(rep Report)
+}
+
+func (h Hooks) fetchStart(m Metadata, attempt int) {
+	if h.OnFetchStart != nil {
+		h.OnFetchStart(m, attempt)
+	}
+}
+
+func (h Hooks) converted(m Metadata, name string) {
+	if h.OnConverted != nil {
+		h.OnConverted(m, name)
+	}
+}
+
+func (h Hooks) error(m Metadata, attempt int, err error, retry bool) {
+	if h.OnError != nil {
+		h.OnError(m, attempt, err, retry)
+	}
+}
+
+func (h Hooks) complete(rep Report) {
+	if h.OnComplete != nil {
+		h.OnComplete(rep)
+	}
+}
+
+// refetcher is implemented by the sources that read a payload when it is
+// fetched rather than when it is claimed, and can read it again.
+type refetcher interface {
+	// claim returns the metadata of the next payload, or io.EOF.
+	claim() (Metadata, error)
+	fetch(ctx context.Context, m Metadata) (io.ReadCloser, Metadata, error)
+}
+
+var (
+	maxRetries   int
+	retryBackoff time.Duration
+)
+
+func init() {
+	rootCmd.PersistentFlags().IntVar(&maxRetries, "retries", 0,
+		"Number of times a url is retried after a transient failure: a network error, a"+
+			" timeout or a 5xx or 429 response.")
+	rootCmd.PersistentFlags().DurationVar(&retryBackoff, "retry-backoff", time.Second,
+		"Delay before the first retry of a failed url. The delay doubles on every attempt and"+
+			" is randomized by up to half.")
+}
+
+// newDefaultRunner returns a runner configured by the flags.
+func newDefaultRunner() *Runner {
+	return &Runner{
+		Workers:   workers,
+		Adaptive:  adaptiveWorkers,
+		Retries:   maxRetries,
+		Backoff:   retryBackoff,
+		Deadline:  perURLDeadline,
+		Namer:     namer,
+		NewSink:   newSink,
+		Partial:   partialPolicy,
+		Overwrite: overwritePolicy,
+		Validate:  validator,
+		Budget:    Budget{MaxPayloads: maxURLs, MaxBytes: maxTotalBytes, MaxDuration: maxDuration},
+		Fragments: fragments,
+		Checksums: checksums,
+		Stats:     statsMode,
+		opts:      convOpts,
+		capture:   newFailureCapture(),
+		pages:     pager,
+		accepted:  acceptedStatus,
+		Transport: fetchClient.Transport,
+		Timeout:   fetchClient.Timeout,
+		Method:    requestMethod,
+		Body:      requestBody,
+
+		IgnoreContentType:  ignoreContentType,
+		SplitLanguageField: splitLanguageField,
+		Routes:             routes,
+		Ledger:             ledger,
+	}
+}
+
+// Add adds a source to the runner. It is safe to call while the runner runs:
+// the source is read once the previous ones are exhausted.
+func (r *Runner) Add(src Source) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.sources = append(r.sources, src)
+}
+
+// AddURLs adds a source fetching the urls with Transport. A url can be followed
+// by mirrors, separated by |.
+func (r *Runner) AddURLs(urls ...string) {
+	r.Add(r.urlSource(urls))
+}
+
+// urlSource returns a source fetching urls with the requests of the runner.
+// Its client is set once it is read, if it has none.
+func (r *Runner) urlSource(urls []string) *urlSource {
+	return &urlSource{capture: r.capture, urls: urls, pages: r.pages, method: r.Method,
+		body: r.Body, accepted: r.accepted}
+}
+
+// client returns the client of the url sources that have none.
+func (r *Runner) client() Getter {
+	transport := r.Transport
+	if transport == nil {
+		transport = http.DefaultTransport
+	}
+	return &http.Client{Transport: transport, Timeout: r.Timeout}
+}
+
+// next removes the next source to read, or returns nil if there is none.
+func (r *Runner) next() Source {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if len(r.sources) == 0 {
+		return nil
+	}
+	src := r.sources[0]
+	r.sources = r.sources[1:]
+	if us, ok := src.(*urlSource); ok && us.client == nil {
+		us.client = r.client()
+	}
+	return src
+}
+
+// Run processes the payloads of the sources until they are exhausted, ctx is
+// done or the budget is exceeded. Failures of single payloads are logged and
+// counted in the report; the returned error is only set if ctx is done, is a
+// *BudgetError, if Options are invalid or if the SHA256SUMS file can't be
+// written. A run over budget is
+// stopped as if ctx was canceled. Run may be called concurrently: the runs share
+// the sources of the runner, each source being read by one of them, and report
+// the payloads they processed.
+func (r *Runner) Run(parent context.Context) (Report, error) {
+	start := time.Now()
+	st := &runState{opts: r.opts}
+	if r.Options != nil {
+		var err error
+		if st.opts, err = r.Options.convertOptions(); err != nil {
+			return Report{}, errors.Wrap(err, "Options")
+		}
+	}
+	n := r.Workers
+	if n < 1 {
+		n = 1
+	}
+	ctx, cancel := context.WithCancel(parent)
+	defer cancel()
+	budget := &runBudget{Budget: r.Budget, cancel: cancel}
+	var limiter *aimdLimiter
+	if r.Adaptive {
+		limiter = newAIMDLimiter(n)
+	}
+	if d := r.Budget.MaxDuration; d > 0 {
+		t := time.AfterFunc(d, func() {
+			budget.stop(&BudgetError{Limit: fmt.Sprintf("ran for more than %s", d)}, true)
+		})
+		defer t.Stop()
+	}
+	if r.Checksums == ChecksumSums {
+		st.sums = newChecksumList()
+	}
+	var processed, failed, timedOut int64
+	var errMu sync.Mutex
+	errs := make(map[string]error)
+	for src := r.next(); src != nil; src = r.next() {
+		var eg errgroup.Group
+		for i := 0; i < n; i++ {
+			eg.Go(func() error {
+				for ctx.Err() == nil && budget.exceeded() == nil {
+					url, err := r.processNext(ctx, st, src, budget, limiter)
+					if err == io.EOF {
+						return nil
+					}
+					atomic.AddInt64(&processed, 1)
+					if err != nil {
+						atomic.AddInt64(&failed, 1)
+						errMu.Lock()
+						errs[url] = err
+						errMu.Unlock()
+					}
+					if errors.Cause(err) == errTimedOut {
+						atomic.AddInt64(&timedOut, 1)
+					}
+				}
+				return nil
+			})
+		}
+		eg.Wait()
+		if ctx.Err() != nil || budget.exceeded() != nil {
+			break
+		}
+	}
+	rep := Report{
+		Processed: int(processed),
+		Failed:    int(failed),
+		TimedOut:  int(timedOut),
+		Duration:  time.Since(start),
+		Errors:    errs,
+		Warnings:  st.warnings,
+		Exceeded:  budget.exceeded(),
+	}
+	var sumsErr error
+	if st.sums != nil {
+		sumsErr = st.sums.write(r.NewSink())
+	}
+	r.Hooks.complete(rep)
+	if err := parent.Err(); err != nil {
+		return rep, err
+	}
+	if rep.Exceeded != nil {
+		return rep, rep.Exceeded
+	}
+	return rep, sumsErr
+}
+
+// processNext reads the next payload of src and converts it, reading it again
+// on failure if the source allows it. It returns the url of the payload, or
+// io.EOF if src is exhausted or the payload is over budget. The attempts wait
+// for limiter, if set.
+func (r *Runner) processNext(ctx context.Context, st *runState, src Source,
+	budget *runBudget, limiter *aimdLimiter) (string, error) {
+	var body io.ReadCloser
+	var m Metadata
+	var fetchErr error
+	rf, retryable := src.(refetcher)
+	if retryable {
+		var err error
+		if m, err = rf.claim(); err == io.EOF {
+			return "", err
+		}
+	} else if body, m, fetchErr = src.Next(); fetchErr == io.EOF {
+		// The other sources don't read the payload before it is converted.
+		return "", fetchErr
+	}
+	if !budget.take() {
+		if body != nil {
+			body.Close()
+		}
+		return "", io.EOF
+	}
+	name := r.name(m)
+	if err := r.Overwrite.checkOutput(r.newWorker(st, name).sink, name); err != nil {
+		if body != nil {
+			body.Close()
+		}
+		l := logger{}.with("worker", m.Index).with("url", m.URL)
+		if err = keepExisting(l, name, err); err != nil {
+			r.Hooks.error(m, 0, err, false)
+		}
+		return m.URL, err
+	}
+	// tryURL makes an attempt at the payload, fetching url if the source is
+	// retryable.
+	tryURL := func(url string, attempt int) error {
+		if err := limiter.acquire(ctx); err != nil {
+			return err
+		}
+		start := time.Now()
+		actx, cancel := ctx, context.CancelFunc(func() {})
+		if r.Deadline > 0 {
+			actx, cancel = context.WithTimeout(ctx, r.Deadline)
+		}
+		defer cancel()
+		r.Hooks.fetchStart(Metadata{Index: m.Index, URL: url}, attempt)
+		am := m
+		if retryable {
+			body, am, fetchErr = rf.fetch(actx, Metadata{Index: m.Index, URL: url})
+		}
+		if body != nil {
+			body = budget.reader(body)
+		}
+		l := logger{}.with("worker", am.Index).with("url", am.URL).with("attempt", attempt)
+		// Failures are logged by processPayload.
+		w := r.newWorker(st, name)
+		err := processPayload(withLogger(actx, l), w, name, func(w *worker) error {
+			return w.handle(body, am, fetchErr)
+		})
+		if err != nil && actx.Err() == context.DeadlineExceeded && ctx.Err() == nil {
+			err = errors.Wrap(errTimedOut, err.Error())
+		}
+		limiter.release(start, time.Now(), err != nil && ctx.Err() == nil && isTransient(err))
+		if err == nil {
+			st.addWarnings(m.URL, w.warnings.messages())
+			r.Hooks.converted(am, name)
+		}
+		return err
+	}
+
+	// The mirrors are tried once the retries of the url are exhausted.
+	targets := []string{m.URL}
+	tries := 1
+	if retryable {
+		targets = append(targets, m.Mirrors...)
+		tries += r.Retries
+	}
+	var err error
+	attempt := 0
+	for t, target := range targets {
+		for try := 1; try <= tries; try++ {
+			if try > 1 {
+				select {
+				case <-ctx.Done():
+					return m.URL, err
+				case <-time.After(jitter(backoff(r.Backoff, try-1))):
+				}
+			}
+			attempt++
+			if err = tryURL(target, attempt); err == nil {
+				return m.URL, nil
+			}
+			again := try < tries && isTransient(err)
+			r.Hooks.error(Metadata{Index: m.Index, URL: target}, attempt, err,
+				again || t < len(targets)-1)
+			if ctx.Err() != nil {
+				return m.URL, err
+			}
+			if !again {
+				break
+			}
+		}
+	}
+	return m.URL, err
+}
+
+func (r *Runner) name(m Metadata) string {
+	if r.Namer == nil {
+		return IndexNamer{}.Name(m)
+	}
+	return r.Namer.Name(m)
+}
+
+// addWarnings records the warnings of the payload of url, if any.
+func (st *runState) addWarnings(url string, msgs []string) {
+	if len(msgs) == 0 {
+		return
+	}
+	st.warnMu.Lock()
+	defer st.warnMu.Unlock()
+	if st.warnings == nil {
+		st.warnings = make(map[string][]string)
+	}
+	st.warnings[url] = msgs
+}
+
+// wrapSink adds the delivery ledger and the wrappers publishing the checksums,
+// files and statistics of the documents of the run to sink.
+func (r *Runner) wrapSink(st *runState, sink Sink) Sink {
+	if r.Ledger != nil {
+		sink = &ledgerSink{sink: sink, ledger: r.Ledger}
+	}
+	if r.Checksums != ChecksumNone {
+		sink = &checksumSink{sink: sink, mode: r.Checksums, sums: st.sums}
+	}
+	if st.opts != nil && st.opts.binaryFields.files() {
+		sink = &attachmentSink{sink: sink}
+	}
+	if r.Stats != StatsNone {
+		sink = &statsSink{sink: sink, mode: r.Stats}
+	}
+	return sink
+}
+
+func (r *Runner) newWorker(st *runState, name string) *worker {
+	var sink Sink
+	if len(r.Routes) > 0 {
+		sink = newRouteSink(r.Routes, func(s Sink) Sink { return r.wrapSink(st, s) })
+	} else {
+		sink = r.wrapSink(st, r.NewSink())
+	}
+	if r.Fragments {
+		sink = &fragmentSink{sink: sink}
+	}
+	if len(r.SplitLanguageField) > 0 {
+		sink = &languageSink{sink: sink, field: r.SplitLanguageField}
+	}
+	return &worker{
+		sink:     sink,
+		name:     strings.TrimSuffix(path.Base(name), path.Ext(name)),
+		capture:  r.capture,
+		opts:     st.opts,
+		partial:  r.Partial,
+		validate: r.Validate,
+		sniff:    r.IgnoreContentType,
+	}
+}
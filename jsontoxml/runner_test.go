@@ -0,0 +1,385 @@
+package jsontoxml
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// memSinks keeps the documents committed to its sinks.
+type memSinks struct {
+	mu   sync.Mutex
+	docs map[string]string
+}
+
+func (ms *memSinks) newSink() Sink {
+	return &memSink{sinks: ms}
+}
+
+type memSink struct {
+	sinks *memSinks
+	name  string
+	bytes.Buffer
+}
+
+func (s *memSink) Open(name string) error {
+	s.name = name
+	s.Reset()
+	return nil
+}
+
+func (s *memSink) Commit() error {
+	s.sinks.mu.Lock()
+	defer s.sinks.mu.Unlock()
+	if s.sinks.docs == nil {
+		s.sinks.docs = make(map[string]string)
+	}
+	s.sinks.docs[s.name] = s.String()
+	return nil
+}
+
+func (s *memSink) Abort() error { return nil }
+
+// flakyClient fails the first get of every url.
+type flakyClient struct {
+	mu   sync.Mutex
+	seen map[string]bool
+}
+
+func (c *flakyClient) Get(url string) (*http.Response, error) {
+	c.mu.Lock()
+	first := !c.seen[url]
+	if c.seen == nil {
+		c.seen = make(map[string]bool)
+	}
+	c.seen[url] = true
+	c.mu.Unlock()
+	if first {
+		return nil, errors.New("connection reset")
+	}
+	return new(mockClient).Get(url)
+}
+
+func TestRunner(t *testing.T) {
+	var sinks memSinks
+	r := &Runner{Workers: 3, NewSink: sinks.newSink}
+	r.Add(&urlSource{
+		client: new(mockClient),
+		urls:   []string{"invalid", "valid", "unknown", "valid"},
+		shard:  shard{index: 1, total: 1},
+	})
+	r.Add(&queueSource{ch: func() chan []byte {
+		ch := make(chan []byte, 1)
+		ch <- []byte(`[{"id": 7}]`)
+		close(ch)
+		return ch
+	}()})
+	rep, err := r.Run(context.Background())
+	require.NoError(t, err)
+	require.Equal(t, 5, rep.Processed)
+	require.Equal(t, 2, rep.Failed)
+	require.Len(t, sinks.docs, 3)
+	require.Contains(t, sinks.docs["3.xml"], "<first>firstname</first>")
+	// The queue is a separate source, so its indexes start over.
+	require.Contains(t, sinks.docs["0.xml"], "<Id>7</Id>")
+}
+
+func TestRunnerRetries(t *testing.T) {
+	var sinks memSinks
+	src := &urlSource{client: new(flakyClient), urls: []string{"valid"}, shard: shard{index: 1, total: 1}}
+	r := &Runner{NewSink: sinks.newSink}
+	r.Add(src)
+	rep, err := r.Run(context.Background())
+	require.NoError(t, err)
+	require.Equal(t, 1, rep.Failed)
+	require.EqualError(t, rep.Errors["valid"], "get failed: connection reset")
+
+	src = &urlSource{client: new(flakyClient), urls: []string{"valid"}, shard: shard{index: 1, total: 1}}
+	r = &Runner{NewSink: sinks.newSink, Retries: 1}
+	r.Add(src)
+	rep, err = r.Run(context.Background())
+	require.NoError(t, err)
+	require.Equal(t, Report{Processed: 1, Duration: rep.Duration, Errors: map[string]error{}}, rep)
+	require.Contains(t, sinks.docs["0.xml"], "<first>firstname</first>")
+}
+
+func TestRunnerCanceled(t *testing.T) {
+	var sinks memSinks
+	r := &Runner{NewSink: sinks.newSink}
+	r.Add(&urlSource{client: new(mockClient), urls: []string{"valid"}, shard: shard{index: 1, total: 1}})
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	rep, err := r.Run(ctx)
+	require.Equal(t, context.Canceled, err)
+	require.Zero(t, rep.Processed)
+}
+
+func TestRunnerHooks(t *testing.T) {
+	var mu sync.Mutex
+	var events []string
+	event := func(s string) {
+		mu.Lock()
+		defer mu.Unlock()
+		events = append(events, s)
+	}
+	var sinks memSinks
+	r := &Runner{
+		NewSink: sinks.newSink,
+		Retries: 1,
+		Hooks: Hooks{
+			OnFetchStart: func(m Metadata, attempt int) {
+				event(fmt.Sprintf("fetch %s %d", m.URL, attempt))
+			},
+			OnConverted: func(m Metadata, name string) {
+				event(fmt.Sprintf("converted %s %s", m.URL, name))
+			},
+			OnError: func(m Metadata, attempt int, err error, retry bool) {
+				event(fmt.Sprintf("error %s %d %v", m.URL, attempt, retry))
+			},
+			OnComplete: func(rep Report) {
+				event(fmt.Sprintf("complete %d %d", rep.Processed, rep.Failed))
+			},
+		},
+	}
+	r.Add(&urlSource{client: new(flakyClient), urls: []string{"valid", "unknown"}})
+	_, err := r.Run(context.Background())
+	require.NoError(t, err)
+	require.Equal(t, []string{
+		"fetch valid 1",
+		"error valid 1 true",
+		"fetch valid 2",
+		"converted valid 0.xml",
+		"fetch unknown 1",
+		"error unknown 1 true",
+		"fetch unknown 2",
+		"error unknown 2 false",
+		"complete 2 1",
+	}, events)
+}
+
+func TestRunnerDeadline(t *testing.T) {
+	// The server sends the start of the body, then stalls.
+	stall := make(chan struct{})
+	defer close(stall)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`[{"id": 1}, `))
+		w.(http.Flusher).Flush()
+		select {
+		case <-stall:
+		case <-r.Context().Done():
+		}
+	}))
+	defer srv.Close()
+
+	var sinks memSinks
+	r := &Runner{NewSink: sinks.newSink, Deadline: 100 * time.Millisecond}
+	r.Add(&urlSource{client: srv.Client(), urls: []string{srv.URL}})
+	rep, err := r.Run(context.Background())
+	require.NoError(t, err)
+	require.Equal(t, 1, rep.Failed)
+	require.Equal(t, 1, rep.TimedOut)
+	require.Less(t, int64(rep.Duration), int64(2*time.Second))
+}
+
+func TestRunnerMirrors(t *testing.T) {
+	var mu sync.Mutex
+	var fetched []string
+	var sinks memSinks
+	r := &Runner{
+		NewSink: sinks.newSink,
+		Retries: 1,
+		Hooks: Hooks{OnFetchStart: func(m Metadata, attempt int) {
+			mu.Lock()
+			defer mu.Unlock()
+			fetched = append(fetched, fmt.Sprintf("%s %d", m.URL, attempt))
+		}},
+	}
+	r.Add(&urlSource{client: new(mockClient), urls: []string{"nope | unknown | valid"}})
+	rep, err := r.Run(context.Background())
+	require.NoError(t, err)
+	require.Zero(t, rep.Failed)
+	// Conversion errors aren't transient, so unknown isn't retried.
+	require.Equal(t, []string{"nope 1", "nope 2", "unknown 3", "valid 4"}, fetched)
+	require.Contains(t, sinks.docs["0.xml"], "<first>firstname</first>")
+}
+
+func TestRunnerStatusRetries(t *testing.T) {
+	var mu sync.Mutex
+	gets := make(map[string]int)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		gets[r.URL.Path]++
+		n := gets[r.URL.Path]
+		mu.Unlock()
+		switch {
+		case r.URL.Path == "/missing":
+			w.WriteHeader(http.StatusNotFound)
+		case n == 1:
+			w.WriteHeader(http.StatusServiceUnavailable)
+		default:
+			w.Header().Set("Content-Type", "application/json")
+			w.Write([]byte(`{"first_name": "firstname", "last_name": "lastname"}`))
+		}
+	}))
+	defer srv.Close()
+
+	var sinks memSinks
+	r := &Runner{NewSink: sinks.newSink, Retries: 2, Backoff: time.Millisecond}
+	r.Add(&urlSource{client: srv.Client(), urls: []string{srv.URL + "/flaky", srv.URL + "/missing"}})
+	rep, err := r.Run(context.Background())
+	require.NoError(t, err)
+	require.Equal(t, 1, rep.Failed)
+	require.EqualError(t, rep.Errors[srv.URL+"/missing"], "get failed: status 404 Not Found")
+	require.Equal(t, map[string]int{"/flaky": 2, "/missing": 1}, gets)
+	require.Contains(t, sinks.docs["0.xml"], "<first>firstname</first>")
+}
+
+func TestRunnerTruncatedBody(t *testing.T) {
+	var mu sync.Mutex
+	gets := make(map[string]int)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		gets[r.URL.Path]++
+		n := gets[r.URL.Path]
+		mu.Unlock()
+		body := `[{"first_name": "a"}, {"first_name": "b"}]`
+		if r.URL.Path == "/object" {
+			body = `{"first_name": "a"}`
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Content-Length", strconv.Itoa(len(body)))
+		if r.URL.Path != "/flaky" || n == 1 {
+			// The connection is closed before the Content-Length is sent.
+			body = body[:len(body)/2+1]
+		}
+		w.Write([]byte(body))
+	}))
+	defer srv.Close()
+
+	var sinks memSinks
+	r := &Runner{NewSink: sinks.newSink, Retries: 1, Backoff: time.Millisecond}
+	r.Add(&urlSource{client: srv.Client(),
+		urls: []string{srv.URL + "/flaky", srv.URL + "/array", srv.URL + "/object"}})
+	rep, err := r.Run(context.Background())
+	require.NoError(t, err)
+	require.Equal(t, 2, rep.Failed)
+	for _, path := range []string{"/array", "/object"} {
+		var re *readError
+		require.True(t, errors.As(rep.Errors[srv.URL+path], &re), rep.Errors[srv.URL+path])
+		require.Contains(t, rep.Errors[srv.URL+path].Error(), "read body failed: unexpected EOF")
+	}
+	require.Equal(t, map[string]int{"/flaky": 2, "/array": 2, "/object": 2}, gets)
+	require.Contains(t, sinks.docs["0.xml"], "<first>b</first>")
+	require.NotContains(t, sinks.docs, "1.xml")
+	require.NotContains(t, sinks.docs, "2.xml")
+
+	// The workers without a runner report the failure too.
+	w := &worker{client: srv.Client(), sink: sinks.newSink()}
+	require.Error(t, w.fetchAndProcess(srv.URL+"/object"))
+}
+
+func TestIsTransient(t *testing.T) {
+	require.True(t, isTransient(&fetchError{err: errors.New("connection reset")}))
+	require.True(t, isTransient(&readError{err: io.ErrUnexpectedEOF}))
+	require.True(t, isTransient(&statusError{code: http.StatusBadGateway}))
+	require.True(t, isTransient(&statusError{code: http.StatusTooManyRequests}))
+	require.False(t, isTransient(&statusError{code: http.StatusNotFound}))
+	require.True(t, isTransient(errTimedOut))
+	require.False(t, isTransient(ErrUnknownJSON))
+}
+
+func TestJitter(t *testing.T) {
+	for i := 0; i < 100; i++ {
+		d := jitter(time.Second)
+		require.True(t, d >= time.Second/2 && d <= time.Second, d)
+	}
+	require.Zero(t, jitter(0))
+}
+
+func TestRunnerConcurrentRuns(t *testing.T) {
+	var sinks memSinks
+	r := &Runner{Workers: 2, NewSink: sinks.newSink, Checksums: ChecksumSums}
+	for i := 0; i < 4; i++ {
+		r.Add(&urlSource{client: new(mockClient), urls: []string{"valid", "invalid"}})
+	}
+	reps := make([]Report, 2)
+	var wg sync.WaitGroup
+	for i := range reps {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			var err error
+			reps[i], err = r.Run(context.Background())
+			require.NoError(t, err)
+		}(i)
+	}
+	wg.Wait()
+	// Every source is read by one of the runs.
+	require.Equal(t, 8, reps[0].Processed+reps[1].Processed)
+	require.Equal(t, 4, reps[0].Failed+reps[1].Failed)
+}
+
+func TestRunnerTransport(t *testing.T) {
+	var mu sync.Mutex
+	var requested []string
+	// The test double answers every request, the middleware records them.
+	double := RoundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		return new(mockClient).Get(req.URL.Path[1:])
+	})
+	var sinks memSinks
+	r := &Runner{
+		NewSink: sinks.newSink,
+		Transport: RoundTripperFunc(func(req *http.Request) (*http.Response, error) {
+			mu.Lock()
+			requested = append(requested, req.URL.String())
+			mu.Unlock()
+			return double.RoundTrip(req)
+		}),
+	}
+	r.AddURLs("http://a/unknown | http://b/valid")
+	rep, err := r.Run(context.Background())
+	require.NoError(t, err)
+	require.Zero(t, rep.Failed)
+	require.Equal(t, []string{"http://a/unknown", "http://b/valid"}, requested)
+	require.Contains(t, sinks.docs["0.xml"], "<first>firstname</first>")
+}
+
+func TestRunnerRequest(t *testing.T) {
+	// The requests of a runner don't depend on the flags.
+	defer func() { requestMethod, requestBody = http.MethodGet, nil }()
+	requestMethod, requestBody = http.MethodPost, []byte(`{"token": "secret"}`)
+	var mu sync.Mutex
+	var requests []string
+	transport := RoundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		var body []byte
+		if req.Body != nil {
+			body, _ = io.ReadAll(req.Body)
+		}
+		mu.Lock()
+		requests = append(requests, req.Method+" "+string(body))
+		mu.Unlock()
+		return new(mockClient).Get("valid")
+	})
+	var sinks memSinks
+	r := &Runner{NewSink: sinks.newSink, Transport: transport}
+	r.AddURLs("http://a/")
+	_, err := r.Run(context.Background())
+	require.NoError(t, err)
+	r = &Runner{NewSink: sinks.newSink, Transport: transport, Method: http.MethodPut,
+		Body: []byte(`{"query": "a"}`)}
+	r.AddURLs("http://a/")
+	_, err = r.Run(context.Background())
+	require.NoError(t, err)
+	require.Equal(t, []string{"GET ", `PUT {"query": "a"}`}, requests)
+}
@@ -0,0 +1,221 @@
+package jsontoxml
+
+import (
+	"reflect"
+	"strings"
+
+	"github.com/pkg/errors"
+	"golang.org/x/net/html"
+)
+
+// Values of --html.
+const (
+	// htmlKeep writes the html of the string values as it is. It is the
+	// default.
+	htmlKeep = "keep"
+	// htmlStrip removes the html tags, keeping the text.
+	htmlStrip = "strip"
+	// htmlSanitize keeps the tags and attributes of an allowlist and removes
+	// the others, keeping their text.
+	htmlSanitize = "sanitize"
+)
+
+// defaultHTMLAllow are the tags kept by --html sanitize by default.
+const defaultHTMLAllow = "a,b,blockquote,br,code,em,i,li,ol,p,pre,strong,u,ul"
+
+// htmlAllowedAttrs are the attributes kept on the allowed tags. The others,
+// e.g. the event handlers and styles, are removed.
+var htmlAllowedAttrs = map[string][]string{
+	"a": {"href", "title"},
+}
+
+// htmlDropped are the elements removed with their content: their text isn't
+// meant to be read.
+var htmlDropped = map[string]bool{
+	"script": true, "style": true, "iframe": true, "object": true, "embed": true,
+	"template": true, "noscript": true, "svg": true, "math": true,
+}
+
+var htmlMode, htmlAllow string
+
+func init() {
+	rootCmd.PersistentFlags().StringVar(&htmlMode, "html", htmlKeep,
+		"What happens to the html embedded in string values: keep, strip (remove the tags and"+
+			" keep the text) or sanitize (keep the tags of --html-allow only). Scripts and"+
+			" styles are removed with their content.")
+	rootCmd.PersistentFlags().StringVar(&htmlAllow, "html-allow", defaultHTMLAllow,
+		"Comma separated list of the tags kept by --html sanitize. Only the href and title"+
+			" attributes of links are kept.")
+}
+
+// htmlSanitizer strips or sanitizes the html of the string values. A nil
+// htmlSanitizer keeps it.
+type htmlSanitizer struct {
+	// allow are the tags kept. Every tag is removed if empty.
+	allow map[string]bool
+}
+
+// newHTMLSanitizer builds the sanitizer from the flags. It returns nil if the
+// html is kept.
+func newHTMLSanitizer(mode, allow string) (*htmlSanitizer, error) {
+	switch mode {
+	case htmlKeep:
+		return nil, nil
+	case htmlStrip:
+		return &htmlSanitizer{}, nil
+	case htmlSanitize:
+		s := &htmlSanitizer{allow: make(map[string]bool)}
+		for _, tag := range splitList(allow) {
+			tag = strings.ToLower(tag)
+			if len(tag) == 0 {
+				continue
+			}
+			if htmlDropped[tag] {
+				return nil, errors.Errorf("invalid --html-allow %q: %s can't be allowed", allow,
+					tag)
+			}
+			s.allow[tag] = true
+		}
+		return s, nil
+	}
+	return nil, errors.Errorf("invalid --html %q. Expected keep, strip or sanitize", mode)
+}
+
+// clean returns s without the disallowed tags. The result is html: the text
+// is escaped, so escaped markup such as &lt;script&gt; stays escaped. Strings
+// without markup are returned as they are.
+func (hs *htmlSanitizer) clean(s string) string {
+	if !strings.ContainsAny(s, "<>") {
+		return s
+	}
+	var b strings.Builder
+	var open []string
+	// dropped is the number of dropped elements the tokenizer is in.
+	dropped := 0
+	z := html.NewTokenizer(strings.NewReader(s))
+	for {
+		tt := z.Next()
+		if tt == html.ErrorToken {
+			break
+		}
+		tok := z.Token()
+		switch tt {
+		case html.TextToken:
+			if dropped == 0 {
+				b.WriteString(html.EscapeString(tok.Data))
+			}
+		case html.StartTagToken, html.SelfClosingTagToken:
+			if htmlDropped[tok.Data] {
+				if tt == html.StartTagToken {
+					dropped++
+				}
+				continue
+			}
+			if dropped > 0 || !hs.allow[tok.Data] {
+				continue
+			}
+			b.WriteString(hs.startTag(tok))
+			if tt == html.StartTagToken && !htmlVoid[tok.Data] {
+				open = append(open, tok.Data)
+			}
+		case html.EndTagToken:
+			if htmlDropped[tok.Data] {
+				if dropped > 0 {
+					dropped--
+				}
+				continue
+			}
+			if dropped > 0 || !hs.allow[tok.Data] {
+				continue
+			}
+			// The end tags close the matching open element, if any.
+			for i := len(open) - 1; i >= 0; i-- {
+				if open[i] != tok.Data {
+					continue
+				}
+				for j := len(open) - 1; j >= i; j-- {
+					b.WriteString("</" + open[j] + ">")
+				}
+				open = open[:i]
+				break
+			}
+		}
+	}
+	for i := len(open) - 1; i >= 0; i-- {
+		b.WriteString("</" + open[i] + ">")
+	}
+	return b.String()
+}
+
+// htmlVoid are the allowed elements without end tags.
+var htmlVoid = map[string]bool{"br": true, "hr": true, "img": true, "wbr": true}
+
+// startTag renders the start tag with its allowed attributes.
+func (hs *htmlSanitizer) startTag(tok html.Token) string {
+	var b strings.Builder
+	b.WriteString("<" + tok.Data)
+	for _, a := range tok.Attr {
+		if !allowedHTMLAttr(tok.Data, a) {
+			continue
+		}
+		b.WriteString(" " + a.Key + `="` + html.EscapeString(a.Val) + `"`)
+	}
+	b.WriteString(">")
+	return b.String()
+}
+
+// allowedHTMLAttr returns true if the attribute is kept. The links must be
+// http, https or mailto urls, or relative ones.
+func allowedHTMLAttr(tag string, a html.Attribute) bool {
+	if len(a.Namespace) > 0 {
+		return false
+	}
+	allowed := false
+	for _, k := range htmlAllowedAttrs[tag] {
+		allowed = allowed || k == a.Key
+	}
+	if !allowed {
+		return false
+	}
+	if a.Key == "href" || a.Key == "src" {
+		v := strings.ToLower(strings.TrimSpace(a.Val))
+		if i := strings.IndexAny(v, ":/?#"); i >= 0 && v[i] == ':' {
+			scheme := v[:i]
+			return scheme == "http" || scheme == "https" || scheme == "mailto"
+		}
+	}
+	return true
+}
+
+// value cleans the strings of the decoded json value v.
+func (hs *htmlSanitizer) value(v interface{}) interface{} {
+	if hs == nil {
+		return v
+	}
+	switch val := v.(type) {
+	case map[string]interface{}:
+		for k, item := range val {
+			val[k] = hs.value(item)
+		}
+	case []interface{}:
+		for i, item := range val {
+			val[i] = hs.value(item)
+		}
+	case string:
+		return hs.clean(val)
+	}
+	return v
+}
+
+// record cleans the string fields of p.
+func (hs *htmlSanitizer) record(p *jsonData) {
+	if hs == nil {
+		return
+	}
+	v := reflect.ValueOf(p).Elem()
+	for i := 0; i < v.NumField(); i++ {
+		if f := v.Field(i); f.Kind() == reflect.String {
+			f.SetString(hs.clean(f.String()))
+		}
+	}
+}
@@ -0,0 +1,58 @@
+package jsontoxml
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestHTMLSanitizer(t *testing.T) {
+	strip, err := newHTMLSanitizer(htmlStrip, defaultHTMLAllow)
+	require.NoError(t, err)
+	sanitize, err := newHTMLSanitizer(htmlSanitize, defaultHTMLAllow)
+	require.NoError(t, err)
+
+	tests := []struct {
+		in, stripped, sanitized string
+	}{
+		{"plain & simple", "plain & simple", "plain & simple"},
+		{"<b>bold</b> text", "bold text", "<b>bold</b> text"},
+		{`<p onclick="x()">a<script>alert(1)</script>b</p>`, "ab", "<p>ab</p>"},
+		{"<style>p{}</style><div>x</div>", "x", "x"},
+		{`<a href="javascript:alert(1)" title="t">link</a>`, "link", `<a title="t">link</a>`},
+		{`<a href="https://example.com/?a=1&amp;b=2">x</a>`, "x",
+			`<a href="https://example.com/?a=1&amp;b=2">x</a>`},
+		{`<a href="/relative:path">x</a>`, "x", `<a href="/relative:path">x</a>`},
+		{"&lt;script&gt; <i>x", "&lt;script&gt; x", "&lt;script&gt; <i>x</i>"},
+		{"<b><i>x</b>y</i>", "xy", "<b><i>x</i></b>y"},
+		{"line<br/>break<img src=x onerror=y>", "linebreak", "line<br>break"},
+	}
+	for _, tt := range tests {
+		require.Equal(t, tt.stripped, strip.clean(tt.in), tt.in)
+		require.Equal(t, tt.sanitized, sanitize.clean(tt.in), tt.in)
+	}
+
+	keep, err := newHTMLSanitizer(htmlKeep, "")
+	require.NoError(t, err)
+	require.Nil(t, keep)
+	_, err = newHTMLSanitizer("escape", "")
+	require.EqualError(t, err, `invalid --html "escape". Expected keep, strip or sanitize`)
+	_, err = newHTMLSanitizer(htmlSanitize, "b,script")
+	require.EqualError(t, err, `invalid --html-allow "b,script": script can't be allowed`)
+}
+
+func TestConvertHTML(t *testing.T) {
+	sanitize, err := newHTMLSanitizer(htmlSanitize, "b")
+	require.NoError(t, err)
+	opts := &convertOptions{html: sanitize}
+	data, err := convert([]byte(`{"first_name": "<b>Ada</b><script>x</script>",
+		"City": "<i>London</i>"}`), opts)
+	require.NoError(t, err)
+	require.Contains(t, string(data), "<first>&lt;b&gt;Ada&lt;/b&gt;</first>")
+	require.Contains(t, string(data), "<City>London</City>")
+
+	opts.generic = true
+	data, err = convert([]byte(`{"notes": ["<b onclick=\"x\">a</b>"]}`), opts)
+	require.NoError(t, err)
+	require.Contains(t, string(data), "<item>&lt;b&gt;a&lt;/b&gt;</item>")
+}
@@ -0,0 +1,219 @@
+package jsontoxml
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Secrets are declared with --secret name=ref and resolved once at startup.
+// Flags that accept credentials can then refer to them as ${secret:name} so that
+// the credentials themselves never appear on the command line. Supported refs:
+//
+//	env:VAR                      environment variable VAR.
+//	envfile:/path/to/.env#KEY    KEY in an env file of KEY=VALUE lines.
+//	vault:secret/data/app#field  field of a Vault secret. Uses VAULT_ADDR and
+//	                             VAULT_TOKEN.
+//	aws-sm:secret-id[#key]       AWS Secrets Manager secret, or a key of a JSON
+//	                             secret. Uses the standard AWS env variables.
+var (
+	secretRefs []string
+	secrets    = map[string]string{}
+	secretExpr = regexp.MustCompile(`\$\{secret:([^}]+)\}`)
+)
+
+// secretHTTPClient is used to talk to Vault, AWS and OAuth2 token endpoints.
+var secretHTTPClient = &http.Client{Timeout: 10 * time.Second}
+
+func init() {
+	rootCmd.PersistentFlags().StringArrayVar(&secretRefs, "secret", nil,
+		"Secret to resolve at startup, as name=ref. Refer to it as ${secret:name} in"+
+			" other flags. Can be repeated.")
+}
+
+// loadSecrets resolves every --secret flag.
+func loadSecrets() error {
+	for _, s := range secretRefs {
+		parts := strings.SplitN(s, "=", 2)
+		if len(parts) != 2 || len(parts[0]) == 0 {
+			return errors.Errorf("invalid --secret %q. Expected name=ref", s)
+		}
+		v, err := resolveSecret(parts[1])
+		if err != nil {
+			return errors.Wrapf(err, "resolve secret %q", parts[0])
+		}
+		secrets[parts[0]] = v
+	}
+	return nil
+}
+
+// expandSecrets replaces every ${secret:name} in s with the value of the
+// secret.
+func expandSecrets(s string) (string, error) {
+	var err error
+	res := secretExpr.ReplaceAllStringFunc(s, func(m string) string {
+		name := secretExpr.FindStringSubmatch(m)[1]
+		v, ok := secrets[name]
+		if !ok && err == nil {
+			err = errors.Errorf("unknown secret %q", name)
+		}
+		return v
+	})
+	return res, err
+}
+
+// resolveSecret returns the value referenced by ref.
+func resolveSecret(ref string) (string, error) {
+	parts := strings.SplitN(ref, ":", 2)
+	if len(parts) != 2 {
+		return "", errors.Errorf("invalid secret ref %q", ref)
+	}
+	kind, ref := parts[0], parts[1]
+	path, key := ref, ""
+	if i := strings.LastIndex(ref, "#"); i >= 0 {
+		path, key = ref[:i], ref[i+1:]
+	}
+	switch kind {
+	case "env":
+		v, ok := os.LookupEnv(ref)
+		if !ok {
+			return "", errors.Errorf("environment variable %q is not set", ref)
+		}
+		return v, nil
+	case "envfile":
+		return secretFromEnvFile(path, key)
+	case "vault":
+		return secretFromVault(path, key)
+	case "aws-sm":
+		return secretFromAWS(path, key)
+	default:
+		return "", errors.Errorf("unknown secret provider %q", kind)
+	}
+}
+
+// secretFromEnvFile reads key from a file of KEY=VALUE lines.
+func secretFromEnvFile(path, key string) (string, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return "", errors.Wrap(err, "read env file")
+	}
+	s := bufio.NewScanner(bytes.NewReader(data))
+	for s.Scan() {
+		line := strings.TrimSpace(s.Text())
+		if len(line) == 0 || strings.HasPrefix(line, "#") {
+			continue
+		}
+		line = strings.TrimPrefix(line, "export ")
+		parts := strings.SplitN(line, "=", 2)
+		if len(parts) != 2 || strings.TrimSpace(parts[0]) != key {
+			continue
+		}
+		v := strings.TrimSpace(parts[1])
+		if len(v) >= 2 && (v[0] == '"' || v[0] == '\'') && v[len(v)-1] == v[0] {
+			v = v[1 : len(v)-1]
+		}
+		return v, nil
+	}
+	return "", errors.Errorf("key %q not found in %q", key, path)
+}
+
+// secretFromVault reads field from the Vault secret at path. Both KV version 1
+// and 2 secrets are supported.
+func secretFromVault(path, field string) (string, error) {
+	addr, token := os.Getenv("VAULT_ADDR"), os.Getenv("VAULT_TOKEN")
+	if len(addr) == 0 || len(token) == 0 {
+		return "", errors.New("VAULT_ADDR and VAULT_TOKEN must be set")
+	}
+	req, err := http.NewRequest(http.MethodGet,
+		strings.TrimSuffix(addr, "/")+"/v1/"+strings.TrimPrefix(path, "/"), nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("X-Vault-Token", token)
+	var out struct {
+		Data map[string]interface{} `json:"data"`
+	}
+	if err := doJSON(req, &out); err != nil {
+		return "", errors.Wrap(err, "vault")
+	}
+	data := out.Data
+	if inner, ok := data["data"].(map[string]interface{}); ok {
+		data = inner
+	}
+	v, ok := data[field]
+	if !ok {
+		return "", errors.Errorf("field %q not found in vault secret %q", field, path)
+	}
+	return fmt.Sprint(v), nil
+}
+
+// secretFromAWS reads the secret id from AWS Secrets Manager. If key is set, the
+// secret must be a JSON object and the value of key is returned.
+func secretFromAWS(id, key string) (string, error) {
+	p, err := newAWSCredentialProvider(secretHTTPClient)
+	if err != nil {
+		return "", err
+	}
+	creds, err := p.get()
+	if err != nil {
+		return "", err
+	}
+	body, err := json.Marshal(map[string]string{"SecretId": id})
+	if err != nil {
+		return "", err
+	}
+	endpoint := "https://secretsmanager." + creds.region + ".amazonaws.com/"
+	req, err := http.NewRequest(http.MethodPost, endpoint, bytes.NewReader(body))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/x-amz-json-1.1")
+	req.Header.Set("X-Amz-Target", "secretsmanager.GetSecretValue")
+	creds.signV4(req, body, "secretsmanager", time.Now())
+	var out struct {
+		SecretString string
+	}
+	if err := doJSON(req, &out); err != nil {
+		return "", errors.Wrap(err, "aws secrets manager")
+	}
+	if len(key) == 0 {
+		return out.SecretString, nil
+	}
+	var fields map[string]interface{}
+	if err := json.Unmarshal([]byte(out.SecretString), &fields); err != nil {
+		return "", errors.Wrapf(err, "secret %q is not a JSON object", id)
+	}
+	v, ok := fields[key]
+	if !ok {
+		return "", errors.Errorf("key %q not found in secret %q", key, id)
+	}
+	return fmt.Sprint(v), nil
+}
+
+// doJSON sends the request and decodes the JSON response into out.
+func doJSON(req *http.Request, out interface{}) error {
+	return doJSONWith(secretHTTPClient, req, out)
+}
+
+// doJSONWith sends the request with client and decodes the JSON response into
+// out.
+func doJSONWith(client *http.Client, req *http.Request, out interface{}) error {
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return errors.Errorf("unexpected status %s", resp.Status)
+	}
+	return errors.Wrap(json.NewDecoder(resp.Body).Decode(out), "decode")
+}
@@ -0,0 +1,176 @@
+package jsontoxml
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+var selectExpr string
+
+func init() {
+	rootCmd.PersistentFlags().StringVar(&selectExpr, "select", "",
+		"JSONPath of the part of the payloads to convert, e.g. $.data.items[*] to convert"+
+			" the items of an envelope. The jq like .data.items[] is accepted too.")
+}
+
+// selectStep is a step of a json path: an object key, an array index or a
+// wildcard matching every entry.
+type selectStep struct {
+	key      string
+	index    int
+	isIndex  bool
+	wildcard bool
+}
+
+// jsonSelector plucks the values at a json path out of the payloads. The
+// paths with a wildcard select an array of the values they match, the others
+// the value itself.
+type jsonSelector struct {
+	expr  string
+	steps []selectStep
+	multi bool
+}
+
+// newJSONSelector parses the subset of JSONPath made of $, .key, ['key'],
+// [n], [*] and .*. A path starting with . is read as a jq path, where []
+// matches every entry. It returns nil for an empty path.
+func newJSONSelector(expr string) (*jsonSelector, error) {
+	if len(expr) == 0 {
+		return nil, nil
+	}
+	invalid := func(msg string) error {
+		return errors.Errorf("invalid --select %q: %s", expr, msg)
+	}
+	s := &jsonSelector{expr: expr}
+	p := expr
+	switch {
+	case strings.HasPrefix(p, "$"):
+		p = p[1:]
+	case strings.HasPrefix(p, "."):
+		if p == "." {
+			p = ""
+		}
+	default:
+		return nil, invalid("expected a path starting with $ or .")
+	}
+	for len(p) > 0 {
+		var step selectStep
+		switch {
+		case strings.HasPrefix(p, ".*"):
+			step.wildcard, p = true, p[2:]
+		case p[0] == '.':
+			end := strings.IndexAny(p[1:], ".[")
+			if end < 0 {
+				end = len(p) - 1
+			}
+			step.key, p = p[1:end+1], p[end+1:]
+			if len(step.key) == 0 {
+				return nil, invalid("empty key")
+			}
+		case p[0] == '[':
+			end := strings.IndexByte(p, ']')
+			if end < 0 {
+				return nil, invalid("unclosed [")
+			}
+			inner := p[1:end]
+			p = p[end+1:]
+			switch {
+			case inner == "*" || inner == "":
+				step.wildcard = true
+			case len(inner) >= 2 && (inner[0] == '\'' || inner[0] == '"') &&
+				inner[len(inner)-1] == inner[0]:
+				step.key = inner[1 : len(inner)-1]
+			default:
+				n, err := strconv.Atoi(inner)
+				if err != nil || n < 0 {
+					return nil, invalid("expected an index, * or a quoted key in []")
+				}
+				step.index, step.isIndex = n, true
+			}
+		default:
+			return nil, invalid("expected . or [ at " + strconv.Quote(p))
+		}
+		s.multi = s.multi || step.wildcard
+		s.steps = append(s.steps, step)
+	}
+	return s, nil
+}
+
+// matchSteps returns the values of v matching the steps.
+func matchSteps(v interface{}, steps []selectStep) []interface{} {
+	if len(steps) == 0 {
+		return []interface{}{v}
+	}
+	step, rest := steps[0], steps[1:]
+	var out []interface{}
+	switch val := v.(type) {
+	case map[string]interface{}:
+		switch {
+		case step.wildcard:
+			keys := make([]string, 0, len(val))
+			for k := range val {
+				keys = append(keys, k)
+			}
+			// The entries of an object are matched in key order.
+			sort.Strings(keys)
+			for _, k := range keys {
+				out = append(out, matchSteps(val[k], rest)...)
+			}
+		case !step.isIndex:
+			if item, ok := val[step.key]; ok {
+				out = matchSteps(item, rest)
+			}
+		}
+	case []interface{}:
+		switch {
+		case step.wildcard:
+			for _, item := range val {
+				out = append(out, matchSteps(item, rest)...)
+			}
+		case step.isIndex && step.index < len(val):
+			out = matchSteps(val[step.index], rest)
+		}
+	}
+	return out
+}
+
+// apply returns the json selected in the json document data.
+func (s *jsonSelector) apply(data []byte) ([]byte, error) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	var v interface{}
+	if err := dec.Decode(&v); err != nil {
+		return nil, errors.Wrap(err, "json.Unmarshal")
+	}
+	matches := matchSteps(v, s.steps)
+	var selected interface{} = matches
+	if !s.multi {
+		if len(matches) == 0 {
+			return nil, errors.Errorf("--select %s matched nothing", s.expr)
+		}
+		selected = matches[0]
+	} else if matches == nil {
+		selected = []interface{}{}
+	}
+	out, err := json.Marshal(selected)
+	return out, errors.Wrap(err, "json.Marshal")
+}
+
+// reader returns the json selected in the json document read from r.
+func (s *jsonSelector) reader(r io.Reader) (io.Reader, error) {
+	var buf bytes.Buffer
+	if _, err := buf.ReadFrom(r); err != nil {
+		return nil, errors.Wrap(err, "read")
+	}
+	data, err := s.apply(buf.Bytes())
+	if err != nil {
+		return nil, err
+	}
+	return bytes.NewReader(data), nil
+}
@@ -0,0 +1,56 @@
+package jsontoxml
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestJSONSelector(t *testing.T) {
+	doc := []byte(`{"data": {"items": [{"id": 1, "n": 1.50}, {"id": 2}], "next": null,` +
+		` "odd key": "x"}}`)
+	for expr, want := range map[string]string{
+		"$":                    `{"data":{"items":[{"id":1,"n":1.50},{"id":2}],"next":null,"odd key":"x"}}`,
+		"$.data.items[*]":      `[{"id":1,"n":1.50},{"id":2}]`,
+		".data.items[]":        `[{"id":1,"n":1.50},{"id":2}]`,
+		"$.data.items[*].id":   `[1,2]`,
+		"$.data.items[1]":      `{"id":2}`,
+		"$['data']['odd key']": `"x"`,
+		"$.data.next":          `null`,
+		"$.data.*":             `[[{"id":1,"n":1.50},{"id":2}],null,"x"]`,
+		"$.data.missing[*]":    `[]`,
+		".data.items[0].n":     `1.50`,
+	} {
+		s, err := newJSONSelector(expr)
+		require.NoError(t, err, expr)
+		out, err := s.apply(doc)
+		require.NoError(t, err, expr)
+		require.Equal(t, want, string(out), expr)
+	}
+
+	s, err := newJSONSelector("$.data.missing")
+	require.NoError(t, err)
+	_, err = s.apply(doc)
+	require.Error(t, err)
+
+	for _, expr := range []string{"data", "$.", "$[x]", "$[1", "$..a"} {
+		_, err := newJSONSelector(expr)
+		require.Error(t, err, expr)
+	}
+	s, err = newJSONSelector("")
+	require.NoError(t, err)
+	require.Nil(t, s)
+}
+
+func TestSelectConvert(t *testing.T) {
+	s, err := newJSONSelector("$.data.items[*]")
+	require.NoError(t, err)
+	opts := &convertOptions{generic: true, selector: s, layout: &xmlLayout{compact: true}}
+	r, err := opts.selector.reader(strings.NewReader(`{"data": {"items": [{"a": 1}]}, "meta": {}}`))
+	require.NoError(t, err)
+	var buf bytes.Buffer
+	require.NoError(t, convertStream(r, &buf, opts, false))
+	require.Equal(t, `<jsonData><item><a>1</a></item></jsonData>`, buf.String())
+}
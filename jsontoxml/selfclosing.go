@@ -0,0 +1,126 @@
+package jsontoxml
+
+import (
+	"bytes"
+	"io"
+)
+
+// Values of --empty-elements.
+const (
+	// emptyElementsExpanded writes empty elements as <City></City>, as
+	// encoding/xml does.
+	emptyElementsExpanded = "expanded"
+	// emptyElementsSelfClosing writes empty elements as <City/>.
+	emptyElementsSelfClosing = "self-closing"
+)
+
+// selfClose rewrites the empty elements of the xml document as self-closing
+// tags.
+func selfClose(data []byte) []byte {
+	var buf bytes.Buffer
+	buf.Grow(len(data))
+	sw := &selfClosingWriter{w: &buf}
+	sw.Write(data)
+	return buf.Bytes()
+}
+
+// States of selfClosingWriter.
+const (
+	// scText is outside of the tags, or in a tag that isn't a start tag.
+	scText = iota
+	// scOpen follows a <.
+	scOpen
+	// scStartTag is in a start tag.
+	scStartTag
+	// scHeld follows a start tag, whose > is held until it is known whether
+	// the element is empty.
+	scHeld
+)
+
+// selfClosingWriter rewrites the empty elements of the xml written to it as
+// self-closing tags, as it is written: the > of a start tag is held until the
+// next bytes tell whether they close the element right away. The values are
+// escaped by the converter, so < and > only appear in markup. Everything is
+// written once the document is complete, since it ends with an end tag.
+type selfClosingWriter struct {
+	w     io.Writer
+	state int
+	// name is the name of the last start tag, and inName is set while it is
+	// being read. slash is set if the last byte of the tag was /.
+	name   []byte
+	inName bool
+	slash  bool
+	// held are the bytes following the held >, which match the start of the
+	// end tag so far.
+	held []byte
+	buf  []byte
+}
+
+func (sw *selfClosingWriter) Write(p []byte) (int, error) {
+	out := sw.buf[:0]
+	for _, c := range p {
+		out = sw.feed(out, c)
+	}
+	sw.buf = out
+	if len(out) == 0 {
+		return len(p), nil
+	}
+	if _, err := sw.w.Write(out); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// feed appends the output of the byte c to out.
+func (sw *selfClosingWriter) feed(out []byte, c byte) []byte {
+	switch sw.state {
+	case scOpen:
+		if c == '/' || c == '!' || c == '?' || c == '<' {
+			sw.state = scText
+			break
+		}
+		sw.state = scStartTag
+		sw.name, sw.inName, sw.slash = append(sw.name[:0], c), true, false
+	case scStartTag:
+		if c == '>' {
+			if sw.slash {
+				sw.state = scText
+				break
+			}
+			sw.state, sw.held = scHeld, sw.held[:0]
+			return out
+		}
+		if c == ' ' || c == '\t' || c == '\n' || c == '/' {
+			sw.inName = false
+		}
+		if sw.inName {
+			sw.name = append(sw.name, c)
+		}
+		sw.slash = c == '/'
+	case scHeld:
+		sw.held = append(sw.held, c)
+		n := len(sw.held)
+		end := 2 + len(sw.name) + 1
+		switch {
+		case n == 1 && c == '<', n == 2 && c == '/',
+			n > 2 && n < end && c == sw.name[n-3]:
+			return out
+		case n == end && c == '>':
+			sw.state = scText
+			return append(out, "/>"...)
+		}
+		// The element isn't empty: the held bytes are written as they came.
+		held := append([]byte(nil), sw.held...)
+		sw.state = scText
+		out = append(out, '>')
+		for _, c := range held {
+			out = sw.feed(out, c)
+		}
+		return out
+	default:
+		if c == '<' {
+			sw.state = scOpen
+		}
+	}
+	return append(out, c)
+}
@@ -0,0 +1,57 @@
+package jsontoxml
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSelfClose(t *testing.T) {
+	tests := []struct {
+		in, want string
+	}{
+		{"<a></a>", "<a/>"},
+		{`<a x="1"></a>`, `<a x="1"/>`},
+		{"<a><b></b><c>1</c></a>", "<a><b/><c>1</c></a>"},
+		{"<a>\n <b></b>\n</a>", "<a>\n <b/>\n</a>"},
+		{"<ab></a>", "<ab></a>"},
+		{"<a></ab>", "<a></ab>"},
+		{"<a><a></a></a>", "<a><a/></a>"},
+		{"<a/><b x=\"/\"></b>", "<a/><b x=\"/\"/>"},
+		{`<!DOCTYPE a [<!ENTITY e "x">]><a>&e;</a>`, `<!DOCTYPE a [<!ENTITY e "x">]><a>&e;</a>`},
+		{"<a>&lt;b&gt;&lt;/b&gt;</a>", "<a>&lt;b&gt;&lt;/b&gt;</a>"},
+	}
+	for _, tt := range tests {
+		require.Equal(t, tt.want, string(selfClose([]byte(tt.in))), tt.in)
+		// The output doesn't depend on how the document is split in writes.
+		var buf bytes.Buffer
+		sw := &selfClosingWriter{w: &buf}
+		for i := range tt.in {
+			n, err := sw.Write([]byte(tt.in[i : i+1]))
+			require.NoError(t, err)
+			require.Equal(t, 1, n)
+		}
+		require.Equal(t, tt.want, buf.String(), tt.in)
+	}
+}
+
+func TestConvertSelfClosing(t *testing.T) {
+	opts := &convertOptions{selfClosing: true}
+	data, err := convert([]byte(`{"first_name": "a"}`), opts)
+	require.NoError(t, err)
+	require.Contains(t, string(data), "<City/>")
+	require.NotContains(t, string(data), "</City>")
+
+	var buf bytes.Buffer
+	require.NoError(t, convertStream(strings.NewReader(`[{"first_name": "a"}, {"first_name": "b"}]`), &buf,
+		opts, false))
+	require.Equal(t, 2, strings.Count(buf.String(), "<City/>"), buf.String())
+	require.NotContains(t, buf.String(), "</City>")
+
+	defer func() { emptyElements = emptyElementsExpanded }()
+	emptyElements = "short"
+	_, err = newConvertOptions()
+	require.EqualError(t, err, `invalid --empty-elements "short". Expected expanded or self-closing`)
+}
@@ -0,0 +1,267 @@
+package jsontoxml
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+)
+
+// serve converts json documents over HTTP:
+//
+//	GET  /          -> web page converting documents, see ui.go.
+//	POST /convert   <- json document. Responds with its xml. The query may
+//	                   change the conversion, see parseConversion.
+//	GET  /metrics   -> heap growth, see memoryGuard, and the usage of the API
+//	                   keys and tenants, if --api-keys or --tenant-header is
+//	                   set.
+//
+// The xml is streamed to the client as it is produced, with chunked transfer
+// encoding, so that large documents aren't buffered by the server. Batches of
+// urls are converted in the background with the jobs API, see jobStore.
+
+var (
+	serveCmd = &cobra.Command{
+		Use:   "serve",
+		Short: "Convert json documents posted over HTTP",
+		Long: `serve converts the json documents posted to /convert with the conversion flags` +
+			` and streams the xml back.`,
+		Run: func(cmd *cobra.Command, args []string) {
+			runServe()
+		},
+	}
+	// flushSize is the amount of xml buffered before it is sent to the
+	// client as a chunk.
+	flushSize = 32 << 10
+)
+
+func init() {
+	serveCmd.Flags().StringVar(&listenAddr, "listen", "localhost:8080",
+		"Address the server listens on. Only the local host can connect by default; use"+
+			" :8080 to listen on every interface.")
+	rootCmd.AddCommand(serveCmd)
+}
+
+// converter serves the conversions.
+type converter struct {
+	opts *convertOptions
+	// validate checks the documents before they are converted, if set.
+	validate Validator
+	// client fetches the documents of the url= conversions, from the hosts of
+	// allow.
+	client Getter
+	allow  hostAllowlist
+}
+
+func (c *converter) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/convert" {
+		http.NotFound(w, r)
+		return
+	}
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	conv, err := parseConversion(c.opts, c.allow, r.URL.Query())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	conv.opts.warnings = &docWarnings{}
+	defer func() {
+		for _, msg := range conv.opts.warnings.messages() {
+			log.Printf("Warning converting request from %s: %s", r.RemoteAddr, msg)
+		}
+	}()
+	body := io.ReadCloser(r.Body)
+	m := Metadata{URL: "request", ContentType: r.Header.Get("Content-Type")}
+	if len(conv.url) > 0 {
+		if body, m, err = fetchJSON(r.Context(), c.client, conv.url); err != nil {
+			http.Error(w, err.Error(), http.StatusBadGateway)
+			return
+		}
+		defer body.Close()
+	}
+	in := io.Reader(body)
+	if c.validate != nil {
+		if in, err = c.validate(m, bufio.NewReader(in)); err != nil {
+			http.Error(w, "validation failed: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+	if conv.opts.selector != nil {
+		if in, err = conv.opts.selector.reader(in); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+	// Reading the request while the response is sent isn't allowed by default
+	// over HTTP/1.x. HTTP/2 always allows it.
+	rc := http.NewResponseController(w)
+	rc.EnableFullDuplex()
+	fw := &flushWriter{w: w, rc: rc, download: conv.download}
+	bw := bufio.NewWriterSize(fw, flushSize)
+	err = convertStream(in, bw, conv.opts, false)
+	// The compact xml has no line break.
+	if err == nil && (conv.opts.layout == nil || !conv.opts.layout.compact) {
+		err = bw.WriteByte('\n')
+	}
+	if err == nil {
+		err = bw.Flush()
+	}
+	if err == nil {
+		return
+	}
+	if !fw.started {
+		// Nothing was sent yet: the buffered xml is dropped for the error.
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	// The status is already sent. Aborting the response leaves the chunked
+	// body unterminated, so that the client sees that it is incomplete.
+	log.Printf("Failed converting request from %s err: %s", r.RemoteAddr, err)
+	panic(http.ErrAbortHandler)
+}
+
+// fetchJSON returns the body of the json document at url, fetched with client,
+// and its metadata.
+func fetchJSON(ctx context.Context, client Getter, url string) (io.ReadCloser, Metadata, error) {
+	body, m, err := (&urlSource{client: client, pages: pager, accepted: acceptedStatus}).fetch(ctx, Metadata{URL: url})
+	if err != nil {
+		return nil, m, errors.Wrapf(err, "url %q", url)
+	}
+	if !ignoreContentType && !isJSON(m.ContentType) {
+		body.Close()
+		return nil, m, errors.Errorf("url %q: invalid Content-Type header. Expected application/json, received %q",
+			url, m.ContentType)
+	}
+	return body, m, nil
+}
+
+// flushWriter sends every write to the client right away.
+type flushWriter struct {
+	w  http.ResponseWriter
+	rc *http.ResponseController
+	// download names the attachment of the xml, if set.
+	download string
+	started  bool
+}
+
+func (fw *flushWriter) Write(p []byte) (int, error) {
+	if !fw.started {
+		fw.w.Header().Set("Content-Type", "application/xml; charset=utf-8")
+		if len(fw.download) > 0 {
+			fw.w.Header().Set("Content-Disposition", `attachment; filename="`+fw.download+`"`)
+		}
+		fw.started = true
+	}
+	n, err := fw.w.Write(p)
+	if err != nil {
+		return n, err
+	}
+	return n, fw.rc.Flush()
+}
+
+func runServe() {
+	admit, err := newAdmission(maxConcurrent, maxQueue, queueTimeout)
+	if err != nil {
+		log.Fatal(err)
+	}
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	jobs, err := newJobStore(ctx, jobsDir, convOpts, maxJobs, jobTTL)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := checkRateLimit(tenantRate); err != nil {
+		log.Fatal(errors.Wrap(err, "--tenant-rate"))
+	}
+	if maxTenants < 0 || tenantMaxJobs < 0 {
+		log.Fatal("--max-tenants and --tenant-max-jobs must not be negative")
+	}
+	allow, err := parseAllowedHosts(allowedHosts)
+	if err != nil {
+		log.Fatal(err)
+	}
+	// The urls of the clients are fetched without the credentials of the flags,
+	// nor the body of --body, which may hold secrets.
+	client := newServeClient(allow)
+	jobs.newRunner = func() *Runner {
+		r := newDefaultRunner()
+		r.Transport, r.Timeout = client.Transport, client.Timeout
+		r.Method, r.Body = http.MethodGet, nil
+		return r
+	}
+	tenants := newTenantSet()
+	tenants.max, tenants.rate, tenants.maxJobs = maxTenants, tenantRate, tenantMaxJobs
+	guard := newMemoryGuard(memoryLimit, func() {
+		client.CloseIdleConnections()
+		tenants.expire()
+	})
+	go guard.run(ctx, recycleEvery)
+	mux := http.NewServeMux()
+	mux.Handle("/convert", admit.handler(&converter{opts: convOpts, validate: validator, client: client,
+		allow: allow}))
+	mux.Handle("GET /metrics", metricsHandler{guard})
+	mux.HandleFunc("GET /{$}", serveUI)
+	jobs.register(mux)
+	handler := http.Handler(mux)
+	if len(apiKeysFile) > 0 || len(tenantHeader) > 0 {
+		outer := http.NewServeMux()
+		// The page is public: its conversions send the key.
+		outer.HandleFunc("GET /{$}", serveUI)
+		switch {
+		case len(apiKeysFile) > 0 && len(tenantHeader) > 0:
+			log.Fatal("--api-keys and --tenant-header are mutually exclusive")
+		case len(apiKeysFile) > 0:
+			keys, err := loadKeyring(apiKeysFile)
+			if err != nil {
+				log.Fatal(err)
+			}
+			// The metrics name the keys and the tenants.
+			outer.Handle("/metrics", keys.handler(metricsHandler{keys, guard}))
+			outer.Handle("/", keys.handler(mux))
+		default:
+			outer.Handle("/metrics", tenants.handler(tenantHeader, metricsHandler{tenants, guard}))
+			outer.Handle("/", tenants.handler(tenantHeader, mux))
+		}
+		handler = outer
+	}
+	tlsCfg, err := newServerTLSConfig()
+	if err != nil {
+		log.Fatal(err)
+	}
+	srv := &http.Server{
+		Addr:              listenAddr,
+		Handler:           handler,
+		TLSConfig:         tlsCfg,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	go func() {
+		<-ctx.Done()
+		// Let the conversions in progress finish. The jobs are canceled.
+		if err := srv.Shutdown(context.Background()); err != nil {
+			log.Printf("Failed shutting down server: %s", err)
+		}
+	}()
+	if tlsCfg != nil {
+		log.Printf("Serving conversions over HTTPS on %s", listenAddr)
+		// The certificates are in the TLS configuration.
+		err = srv.ListenAndServeTLS("", "")
+	} else {
+		log.Printf("Serving conversions on %s", listenAddr)
+		err = srv.ListenAndServe()
+	}
+	if err != http.ErrServerClosed {
+		log.Fatal(err)
+	}
+	jobs.wait()
+}
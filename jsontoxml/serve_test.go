@@ -0,0 +1,95 @@
+package jsontoxml
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestServe(t *testing.T) {
+	defer func(n int) { flushSize = n }(flushSize)
+	flushSize = 64
+	srv := httptest.NewServer(&converter{opts: &convertOptions{}})
+	defer srv.Close()
+
+	var doc strings.Builder
+	doc.WriteString("[")
+	for i := 0; i < 1000; i++ {
+		if i > 0 {
+			doc.WriteString(",")
+		}
+		fmt.Fprintf(&doc, `{"id": %d, "first_name": "a"}`, i)
+	}
+	doc.WriteString("]")
+	want, err := convert([]byte(doc.String()), nil)
+	require.NoError(t, err)
+	resp, err := http.Post(srv.URL+"/convert", "application/json", strings.NewReader(doc.String()))
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	require.Equal(t, []string{"chunked"}, resp.TransferEncoding)
+	require.Equal(t, "application/xml; charset=utf-8", resp.Header.Get("Content-Type"))
+	got, err := ioutil.ReadAll(resp.Body)
+	require.NoError(t, err)
+	require.Equal(t, string(want)+"\n", string(got))
+
+	// The entries are sent before the end of the request is received.
+	pr, pw := io.Pipe()
+	defer pw.Close()
+	go func() {
+		io.WriteString(pw, "[")
+		for i := 0; i < 10; i++ {
+			fmt.Fprintf(pw, `{"id": %d, "first_name": "a"},`, i)
+		}
+	}()
+	resp, err = http.Post(srv.URL+"/convert", "application/json", pr)
+	require.NoError(t, err)
+	line, err := bufio.NewReader(resp.Body).ReadString('\n')
+	require.NoError(t, err)
+	require.Equal(t, " <records>\n", line)
+	pw.Close()
+	resp.Body.Close()
+
+	// Errors detected before anything is sent fail the request.
+	resp, err = http.Post(srv.URL+"/convert", "application/json", strings.NewReader(`{"foo": 1}`))
+	require.NoError(t, err)
+	body, err := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	require.NoError(t, err)
+	require.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	require.Contains(t, string(body), "JSON is valid but it is not of type jsonData")
+
+	// Later errors abort the response.
+	bad := strings.TrimSuffix(doc.String(), "]") + `, {"foo": 1}]`
+	resp, err = http.Post(srv.URL+"/convert", "application/json", strings.NewReader(bad))
+	require.NoError(t, err)
+	_, err = ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	require.Error(t, err)
+
+	resp, err = http.Get(srv.URL + "/convert")
+	require.NoError(t, err)
+	resp.Body.Close()
+	require.Equal(t, http.StatusMethodNotAllowed, resp.StatusCode)
+
+	// The documents are validated like those of the urls.
+	soft, err := newSoftErrorValidator("error", "")
+	require.NoError(t, err)
+	vsrv := httptest.NewServer(&converter{opts: &convertOptions{}, validate: soft})
+	defer vsrv.Close()
+	resp, err = http.Post(vsrv.URL+"/convert", "application/json",
+		strings.NewReader(`{"id": 1, "error": "down"}`))
+	require.NoError(t, err)
+	body, err = ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	require.NoError(t, err)
+	require.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	require.Contains(t, string(body), "validation failed: error in the payload")
+}
@@ -0,0 +1,92 @@
+package jsontoxml
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/pkg/errors"
+	"golang.org/x/crypto/acme/autocert"
+)
+
+var (
+	serveCert, serveKey string
+	acmeDomains         []string
+	acmeCache           string
+	acmeEmail           string
+	serveClientCA       string
+)
+
+func init() {
+	serveCmd.Flags().StringVar(&serveCert, "tls-cert", "",
+		"PEM file of the certificate (chain) served over HTTPS. Requires --tls-key.")
+	serveCmd.Flags().StringVar(&serveKey, "tls-key", "",
+		"PEM file of the private key of --tls-cert.")
+	serveCmd.Flags().StringSliceVar(&acmeDomains, "acme-domain", nil,
+		"Serve HTTPS with certificates issued automatically by Let's Encrypt for the domains."+
+			" The server must be reachable on port 443 of the domains.")
+	serveCmd.Flags().StringVar(&acmeCache, "acme-cache", "",
+		"Directory in which the ACME certificates are kept across restarts. Defaults to the"+
+			" user cache directory.")
+	serveCmd.Flags().StringVar(&acmeEmail, "acme-email", "",
+		"Contact email of the ACME account, notified about certificate problems.")
+	serveCmd.Flags().StringVar(&serveClientCA, "tls-client-ca", "",
+		"PEM file of the CA certificates of the clients. Clients must present a certificate"+
+			" signed by one of them (mutual TLS).")
+}
+
+// newServerTLSConfig builds the TLS configuration of the server from the flags.
+// It returns nil if the server serves plain HTTP.
+func newServerTLSConfig() (*tls.Config, error) {
+	var cfg *tls.Config
+	switch {
+	case len(acmeDomains) > 0 && (len(serveCert) > 0 || len(serveKey) > 0):
+		return nil, errors.New("--acme-domain and --tls-cert are mutually exclusive")
+	case len(acmeDomains) > 0:
+		dir := acmeCache
+		if len(dir) == 0 {
+			cache, err := os.UserCacheDir()
+			if err != nil {
+				return nil, errors.Wrap(err, "no --acme-cache")
+			}
+			dir = filepath.Join(cache, "jsonToXml", "acme")
+		}
+		m := &autocert.Manager{
+			Prompt:     autocert.AcceptTOS,
+			Cache:      autocert.DirCache(dir),
+			HostPolicy: autocert.HostWhitelist(acmeDomains...),
+			Email:      acmeEmail,
+		}
+		// The challenges are answered over TLS (tls-alpn-01), so port 80
+		// isn't needed.
+		cfg = m.TLSConfig()
+	case len(serveCert) > 0 || len(serveKey) > 0:
+		if len(serveCert) == 0 || len(serveKey) == 0 {
+			return nil, errors.New("--tls-cert and --tls-key must be set together")
+		}
+		cert, err := tls.LoadX509KeyPair(serveCert, serveKey)
+		if err != nil {
+			return nil, errors.Wrap(err, "load --tls-cert")
+		}
+		cfg = &tls.Config{Certificates: []tls.Certificate{cert}}
+	}
+	if len(serveClientCA) == 0 {
+		return cfg, nil
+	}
+	if cfg == nil {
+		return nil, errors.New("--tls-client-ca requires --tls-cert or --acme-domain")
+	}
+	pem, err := ioutil.ReadFile(serveClientCA)
+	if err != nil {
+		return nil, errors.Wrap(err, "read --tls-client-ca")
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, errors.Errorf("no certificate found in --tls-client-ca %q", serveClientCA)
+	}
+	cfg.ClientCAs = pool
+	cfg.ClientAuth = tls.RequireAndVerifyClientCert
+	return cfg, nil
+}
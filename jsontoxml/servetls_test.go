@@ -0,0 +1,112 @@
+package jsontoxml
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"net/http"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// selfSigned returns a self-signed certificate for 127.0.0.1 and its key.
+func selfSigned(t *testing.T, name string, usage x509.ExtKeyUsage) (der, keyDER []byte) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		ExtKeyUsage:  []x509.ExtKeyUsage{usage},
+		IPAddresses:  []net.IP{net.IPv4(127, 0, 0, 1)},
+	}
+	der, err = x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	require.NoError(t, err)
+	keyDER, err = x509.MarshalECPrivateKey(key)
+	require.NoError(t, err)
+	return der, keyDER
+}
+
+func TestServerTLSConfig(t *testing.T) {
+	dir, err := ioutil.TempDir("", "servetls")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	defer func(cert, key, ca string, domains []string) {
+		serveCert, serveKey, serveClientCA, acmeDomains = cert, key, ca, domains
+	}(serveCert, serveKey, serveClientCA, acmeDomains)
+
+	cfg, err := newServerTLSConfig()
+	require.NoError(t, err)
+	require.Nil(t, cfg)
+
+	der, keyDER := selfSigned(t, "server", x509.ExtKeyUsageServerAuth)
+	serveCert = writePEM(t, dir, "server.pem", "CERTIFICATE", der)
+	_, err = newServerTLSConfig()
+	require.EqualError(t, err, "--tls-cert and --tls-key must be set together")
+	serveKey = writePEM(t, dir, "server.key", "EC PRIVATE KEY", keyDER)
+	clientDER, clientKeyDER := selfSigned(t, "team-a", x509.ExtKeyUsageClientAuth)
+	serveClientCA = writePEM(t, dir, "clients.pem", "CERTIFICATE", clientDER)
+	cfg, err = newServerTLSConfig()
+	require.NoError(t, err)
+
+	l, err := tls.Listen("tcp", "127.0.0.1:0", cfg)
+	require.NoError(t, err)
+	srv := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(r.TLS.PeerCertificates[0].Subject.CommonName))
+	})}
+	go srv.Serve(l)
+	defer srv.Close()
+
+	roots := x509.NewCertPool()
+	cert, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+	roots.AddCert(cert)
+	get := func(certs ...tls.Certificate) (string, error) {
+		c := &http.Client{Transport: &http.Transport{
+			TLSClientConfig: &tls.Config{RootCAs: roots, Certificates: certs},
+		}}
+		resp, err := c.Get("https://" + l.Addr().String())
+		if err != nil {
+			return "", err
+		}
+		defer resp.Body.Close()
+		body, err := ioutil.ReadAll(resp.Body)
+		return string(body), err
+	}
+	// Clients without a certificate are rejected.
+	_, err = get()
+	require.Error(t, err)
+	name, err := get(tls.Certificate{Certificate: [][]byte{clientDER}, PrivateKey: mustParseEC(t,
+		clientKeyDER)})
+	require.NoError(t, err)
+	require.Equal(t, "team-a", name)
+
+	acmeDomains = []string{"example.com"}
+	_, err = newServerTLSConfig()
+	require.EqualError(t, err, "--acme-domain and --tls-cert are mutually exclusive")
+	serveCert, serveKey = "", ""
+	cfg, err = newServerTLSConfig()
+	require.NoError(t, err)
+	require.NotNil(t, cfg.GetCertificate)
+	require.Equal(t, tls.RequireAndVerifyClientCert, cfg.ClientAuth)
+
+	acmeDomains = nil
+	_, err = newServerTLSConfig()
+	require.EqualError(t, err, "--tls-client-ca requires --tls-cert or --acme-domain")
+}
+
+func mustParseEC(t *testing.T, der []byte) *ecdsa.PrivateKey {
+	key, err := x509.ParseECPrivateKey(der)
+	require.NoError(t, err)
+	return key
+}
@@ -0,0 +1,54 @@
+package jsontoxml
+
+import (
+	"hash/fnv"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// shard identifies the slice of the URL list owned by this instance. Shards are
+// 1-indexed, so "2/5" is the second of five shards. The zero value owns every
+// URL.
+type shard struct {
+	index int
+	total int
+}
+
+// parseShard parses a shard spec of the form "i/n". An empty spec disables
+// sharding.
+func parseShard(spec string) (shard, error) {
+	spec = strings.TrimSpace(spec)
+	if len(spec) == 0 {
+		return shard{}, nil
+	}
+	parts := strings.Split(spec, "/")
+	if len(parts) != 2 {
+		return shard{}, errors.Errorf("invalid shard %q. Expected format i/n", spec)
+	}
+	index, err := strconv.Atoi(strings.TrimSpace(parts[0]))
+	if err != nil {
+		return shard{}, errors.Wrapf(err, "invalid shard index in %q", spec)
+	}
+	total, err := strconv.Atoi(strings.TrimSpace(parts[1]))
+	if err != nil {
+		return shard{}, errors.Wrapf(err, "invalid shard count in %q", spec)
+	}
+	if total < 1 || index < 1 || index > total {
+		return shard{}, errors.Errorf("invalid shard %q. Expected 1 <= i <= n", spec)
+	}
+	return shard{index: index, total: total}, nil
+}
+
+// owns returns true if the url belongs to this shard. The decision depends only
+// on the url itself, so every instance agrees on the partitioning regardless of
+// the order in which the urls were supplied.
+func (s shard) owns(url string) bool {
+	if s.total <= 1 {
+		return true
+	}
+	h := fnv.New32a()
+	h.Write([]byte(url))
+	return int(h.Sum32()%uint32(s.total)) == s.index-1
+}
@@ -0,0 +1,40 @@
+package jsontoxml
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseShard(t *testing.T) {
+	s, err := parseShard("")
+	require.NoError(t, err)
+	require.Equal(t, shard{}, s)
+
+	s, err = parseShard("2/5")
+	require.NoError(t, err)
+	require.Equal(t, shard{index: 2, total: 5}, s)
+
+	for _, spec := range []string{"2", "0/5", "6/5", "a/5", "1/b", "1/0"} {
+		_, err := parseShard(spec)
+		require.Error(t, err, spec)
+	}
+}
+
+func TestShardOwns(t *testing.T) {
+	require.True(t, shard{}.owns("http://localhost/a.json"))
+
+	// Every url must be owned by exactly one shard.
+	const total = 4
+	for i := 0; i < 100; i++ {
+		u := fmt.Sprintf("http://localhost/%d.json", i)
+		owners := 0
+		for idx := 1; idx <= total; idx++ {
+			if (shard{index: idx, total: total}).owns(u) {
+				owners++
+			}
+		}
+		require.Equal(t, 1, owners, u)
+	}
+}
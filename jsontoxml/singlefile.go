@@ -0,0 +1,71 @@
+package jsontoxml
+
+import (
+	"bufio"
+	"os"
+	"path/filepath"
+
+	"github.com/pkg/errors"
+)
+
+var singleFile, singleFileRoot string
+
+func init() {
+	rootCmd.PersistentFlags().StringVar(&singleFile, "single-file", "",
+		"Write all the documents to this file, wrapped in a --single-file-root element, instead"+
+			" of one file per url in --output.")
+	rootCmd.PersistentFlags().StringVar(&singleFileRoot, "single-file-root", "documents",
+		"Name of the root element of --single-file.")
+}
+
+// singleFileStream writes the documents to a single file as they are
+// committed, so that the memory used doesn't grow with the number of
+// documents. The file is written to a temporary file which is renamed on
+// Close, so readers never see a partial document.
+type singleFileStream struct {
+	*docStream
+	path string
+	f    *os.File
+	bw   *bufio.Writer
+}
+
+func newSingleFileStream(path, root string) (*singleFileStream, error) {
+	if _, ok := xmlName(root); !ok {
+		return nil, errors.Errorf("invalid --single-file-root %q. Expected an xml name", root)
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return nil, errors.Wrap(err, "single file")
+	}
+	f, err := createTemp(path)
+	if err != nil {
+		return nil, errors.Wrap(err, "single file")
+	}
+	bw := bufio.NewWriter(f)
+	return &singleFileStream{
+		docStream: &docStream{w: bw, delim: []byte("\n"), root: root},
+		path:      path,
+		f:         f,
+		bw:        bw,
+	}, nil
+}
+
+// newSink returns a sink writing to the stream.
+func (s *singleFileStream) newSink() Sink {
+	return &streamSink{stream: s.docStream}
+}
+
+// Close closes the root element and renames the file to its path.
+func (s *singleFileStream) Close() error {
+	err := s.docStream.Close()
+	if err == nil {
+		err = s.bw.Flush()
+	}
+	if cerr := s.f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(s.f.Name())
+		return errors.Wrap(err, "single file")
+	}
+	return os.Rename(s.f.Name(), s.path)
+}
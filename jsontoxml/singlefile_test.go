@@ -0,0 +1,52 @@
+package jsontoxml
+
+import (
+	"context"
+	"encoding/xml"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSingleFileStream(t *testing.T) {
+	dir, err := ioutil.TempDir("", "single")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "sub", "out.xml")
+
+	sf, err := newSingleFileStream(path, "people")
+	require.NoError(t, err)
+	r := &Runner{Workers: 2, NewSink: sf.newSink}
+	r.Add(&urlSource{client: new(mockClient), urls: []string{"valid", "unknown", "valid", "valid"}})
+	rep, err := r.Run(context.Background())
+	require.NoError(t, err)
+	require.Equal(t, 1, rep.Failed)
+	// Nothing is visible until the stream is closed.
+	_, err = os.Stat(path)
+	require.True(t, os.IsNotExist(err))
+	require.NoError(t, sf.Close())
+
+	data, err := ioutil.ReadFile(path)
+	require.NoError(t, err)
+	var doc struct {
+		XMLName xml.Name
+		People  []struct {
+			First string `xml:"name>first"`
+		} `xml:"jsonData"`
+	}
+	require.NoError(t, xml.Unmarshal(data, &doc), string(data))
+	require.Equal(t, "people", doc.XMLName.Local)
+	require.Len(t, doc.People, 3)
+	require.Equal(t, "firstname", doc.People[2].First)
+	require.True(t, strings.HasSuffix(string(data), "</people>\n"))
+	files, err := ioutil.ReadDir(filepath.Dir(path))
+	require.NoError(t, err)
+	require.Len(t, files, 1)
+
+	_, err = newSingleFileStream(path, "1 2")
+	require.EqualError(t, err, `invalid --single-file-root "1 2". Expected an xml name`)
+}
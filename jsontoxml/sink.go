@@ -0,0 +1,716 @@
+package jsontoxml
+
+import (
+	"bytes"
+	"encoding/xml"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Sink is the destination of the converted documents. A worker opens the sink
+// for every document, writes the xml to it and then either commits the
+// document, making it visible at the destination, or aborts it. Implement it to
+// add a destination without touching the fetch and convert logic.
+type Sink interface {
+	// Open starts a document. name is the path of the document relative to the
+	// destination, as returned by the Namer.
+	Open(name string) error
+	Write(p []byte) (int, error)
+	// Commit finishes the document opened last.
+	Commit() error
+	// Abort discards the document opened last.
+	Abort() error
+}
+
+// ExistingSink is implemented by the sinks that can tell whether a document
+// is already at the destination, e.g. written by a previous run.
+type ExistingSink interface {
+	Sink
+	Exists(name string) bool
+}
+
+var stdoutDelimiter, stdoutRoot string
+
+func init() {
+	rootCmd.PersistentFlags().StringVar(&stdoutDelimiter, "stdout-delimiter", `\n`,
+		"Written after every document with --output -. Escape sequences such as \\n, \\t and"+
+			" \\x00 are interpreted.")
+	rootCmd.PersistentFlags().StringVar(&stdoutRoot, "stdout-root", "",
+		"With --output -, wrap all the documents in a single element of this name, so that"+
+			" stdout is one well-formed xml document.")
+}
+
+// newSink returns a sink for the --output destination. It is set from
+// --output.
+var newSink = func() Sink { return &fileSink{dir: output} }
+
+// sinkFactory returns the constructor of the sinks of the output destination:
+// stdout for "-", an S3 bucket for s3://bucket/prefix, a Cloud Storage bucket
+// for gs://bucket/prefix, an Azure Blob container for
+// az://account/container/prefix, PUT requests for http(s)://host/path and a
+// local directory otherwise.
+func sinkFactory(output string) (func() Sink, error) {
+	switch {
+	case output == "-":
+		st, err := newStdoutStream(os.Stdout, stdoutDelimiter, stdoutRoot)
+		if err != nil {
+			return nil, err
+		}
+		st.declaration = xmlDeclaration
+		outStream = st
+		return func() Sink { return &streamSink{stream: st} }, nil
+	case strings.HasPrefix(output, "s3://"):
+		u, err := url.Parse(output)
+		if err != nil || len(u.Host) == 0 {
+			return nil, errors.Errorf("invalid s3 output %q. Expected s3://bucket/prefix", output)
+		}
+		client := &http.Client{Timeout: 30 * time.Second}
+		creds, err := newAWSCredentialProvider(client)
+		if err != nil {
+			return nil, errors.Wrap(err, "s3 output")
+		}
+		return func() Sink {
+			return &s3Sink{
+				bucket:   u.Host,
+				prefix:   strings.Trim(u.Path, "/"),
+				endpoint: os.Getenv("AWS_ENDPOINT_URL"),
+				creds:    creds,
+				client:   client,
+			}
+		}, nil
+	case strings.HasPrefix(output, "gs://"):
+		u, err := url.Parse(output)
+		if err != nil || len(u.Host) == 0 {
+			return nil, errors.Errorf("invalid gs output %q. Expected gs://bucket/prefix", output)
+		}
+		client := &http.Client{Timeout: 30 * time.Second}
+		endpoint := "https://storage.googleapis.com"
+		var tokens *gcpTokenSource
+		// The emulators don't authenticate the requests.
+		if host := os.Getenv("STORAGE_EMULATOR_HOST"); len(host) > 0 {
+			endpoint = host
+			if !strings.Contains(host, "://") {
+				endpoint = "http://" + host
+			}
+		} else {
+			if tokens, err = newGCPTokenSource(client, gcsScope); err == nil {
+				_, err = tokens.get()
+			}
+			if err != nil {
+				return nil, errors.Wrap(err, "gs output")
+			}
+		}
+		return func() Sink {
+			return &gcsSink{
+				bucket:   u.Host,
+				prefix:   strings.Trim(u.Path, "/"),
+				endpoint: endpoint,
+				tokens:   tokens,
+				client:   client,
+			}
+		}, nil
+	case strings.HasPrefix(output, "az://"):
+		account, container, prefix, err := parseAzureOutput(output)
+		if err != nil {
+			return nil, err
+		}
+		creds, err := azureCredentialsFromEnv(account)
+		if err != nil {
+			return nil, errors.Wrap(err, "az output")
+		}
+		// The token is in the urls of the failed uploads.
+		defaultRedactor.addValues(creds.sas)
+		endpoint := os.Getenv("AZURE_STORAGE_ENDPOINT")
+		if len(endpoint) == 0 {
+			endpoint = "https://" + account + ".blob.core.windows.net"
+		}
+		client := &http.Client{Timeout: 30 * time.Second}
+		return func() Sink {
+			return &azureSink{
+				endpoint:  strings.TrimSuffix(endpoint, "/"),
+				container: container,
+				prefix:    prefix,
+				creds:     creds,
+				client:    client,
+			}
+		}, nil
+	case strings.HasPrefix(output, "http://"), strings.HasPrefix(output, "https://"):
+		client := &http.Client{Timeout: 30 * time.Second}
+		return func() Sink {
+			return &httpSink{base: strings.TrimSuffix(output, "/"), client: client}
+		}, nil
+	}
+	return func() Sink { return &fileSink{dir: output} }, nil
+}
+
+// isLocalOutput returns true if the output destination is a local directory.
+func isLocalOutput(output string) bool {
+	return output != "-" && !strings.Contains(output, "://")
+}
+
+// outputPath returns the location of the document name in the output
+// destination, for logging.
+func outputPath(name string) string {
+	switch {
+	case len(singleFile) > 0:
+		return singleFile
+	case output == "-":
+		return "-"
+	case isLocalOutput(output):
+		return filepath.Join(output, filepath.FromSlash(name))
+	}
+	return strings.TrimSuffix(output, "/") + "/" + name
+}
+
+// NewFileSink returns a sink writing the documents to files in dir, e.g. the
+// sink of Runner.NewSink.
+func NewFileSink(dir string) Sink {
+	return &fileSink{dir: dir}
+}
+
+// fileSink writes the documents to files in dir. A document is written to a
+// temporary file which is renamed on commit, so readers never see partial
+// output.
+type fileSink struct {
+	dir  string
+	path string
+	f    *os.File
+}
+
+func (s *fileSink) Open(name string) error {
+	s.path = filepath.Join(s.dir, filepath.FromSlash(name))
+	if err := os.MkdirAll(filepath.Dir(s.path), 0700); err != nil {
+		return err
+	}
+	f, err := createTemp(s.path)
+	if err != nil {
+		return err
+	}
+	s.f = f
+	return nil
+}
+
+// createTemp creates the temporary file of an output renamed to path once it
+// is complete. Unlike ioutil.TempFile, which uses 0600, the file gets the
+// permissions of os.Create (0666 before the umask), so the outputs stay
+// readable by the other users.
+func createTemp(path string) (*os.File, error) {
+	prefix := filepath.Join(filepath.Dir(path), "."+filepath.Base(path)+".tmp")
+	for i := 0; ; i++ {
+		f, err := os.OpenFile(prefix+strconv.FormatUint(uint64(rand.Uint32()), 10),
+			os.O_RDWR|os.O_CREATE|os.O_EXCL, 0666)
+		if os.IsExist(err) && i < 10000 {
+			continue
+		}
+		return f, err
+	}
+}
+
+func (s *fileSink) Write(p []byte) (int, error) {
+	return s.f.Write(p)
+}
+
+func (s *fileSink) Commit() error {
+	if err := s.f.Close(); err != nil {
+		os.Remove(s.f.Name())
+		return err
+	}
+	return os.Rename(s.f.Name(), s.path)
+}
+
+func (s *fileSink) Abort() error {
+	s.f.Close()
+	return os.Remove(s.f.Name())
+}
+
+func (s *fileSink) Exists(name string) bool {
+	_, err := os.Stat(filepath.Join(s.dir, filepath.FromSlash(name)))
+	return err == nil
+}
+
+func (s *fileSink) KeepPartial() error {
+	if err := s.f.Close(); err != nil {
+		os.Remove(s.f.Name())
+		return err
+	}
+	return os.Rename(s.f.Name(), s.path+partialSuffix)
+}
+
+// outStream is the stream of the documents written with --output - or
+// --single-file. It is closed at the end of the run. It is nil for the other
+// destinations.
+var outStream io.Closer
+
+// docStream writes the documents of concurrent workers to w, each followed by
+// delim. If root is set, the documents are wrapped in a root element, which is
+// opened with the first document, after the xml declaration if declaration is
+// set, and closed by Close.
+type docStream struct {
+	mu          sync.Mutex
+	w           io.Writer
+	delim       []byte
+	root        string
+	declaration bool
+	opened      bool
+}
+
+// newStdoutStream returns the stream of --output -, writing to w. delim may
+// contain Go escape sequences.
+func newStdoutStream(w io.Writer, delim, root string) (*docStream, error) {
+	d, err := strconv.Unquote(`"` + strings.ReplaceAll(delim, `"`, `\"`) + `"`)
+	if err != nil {
+		return nil, errors.Errorf("invalid --stdout-delimiter %q", delim)
+	}
+	if len(root) > 0 {
+		if _, ok := xmlName(root); !ok {
+			return nil, errors.Errorf("invalid --stdout-root %q. Expected an xml name", root)
+		}
+	}
+	return &docStream{w: w, delim: []byte(d), root: root}, nil
+}
+
+// open writes the opening tag of the root element, if any and not written yet.
+// The caller holds s.mu.
+func (s *docStream) open() error {
+	if s.opened || len(s.root) == 0 {
+		return nil
+	}
+	s.opened = true
+	head := "<" + s.root + ">\n"
+	if s.declaration {
+		head = xml.Header + head
+	}
+	_, err := io.WriteString(s.w, head)
+	return err
+}
+
+// write writes the document doc, followed by the delimiter.
+func (s *docStream) write(doc *bytes.Buffer) error {
+	doc.Write(s.delim)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.open(); err != nil {
+		return err
+	}
+	_, err := doc.WriteTo(s.w)
+	return err
+}
+
+// Close closes the root element, if any. The stream is a well-formed document
+// afterwards, even if no document was written to it.
+func (s *docStream) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.root) == 0 {
+		return nil
+	}
+	if err := s.open(); err != nil {
+		return err
+	}
+	_, err := io.WriteString(s.w, "</"+s.root+">\n")
+	return err
+}
+
+// streamSink writes every committed document to its stream. Documents are
+// buffered until commit so that they aren't interleaved.
+type streamSink struct {
+	stream *docStream
+	buf    bytes.Buffer
+}
+
+func (s *streamSink) Open(name string) error {
+	s.buf.Reset()
+	return nil
+}
+
+func (s *streamSink) Write(p []byte) (int, error) {
+	return s.buf.Write(p)
+}
+
+func (s *streamSink) Commit() error {
+	return s.stream.write(&s.buf)
+}
+
+func (s *streamSink) Abort() error {
+	s.buf.Reset()
+	return nil
+}
+
+func (s *streamSink) KeepPartial() error {
+	return s.Commit()
+}
+
+// httpSink PUTs every committed document to base/name.
+type httpSink struct {
+	base   string
+	client *http.Client
+	name   string
+	buf    bytes.Buffer
+	// key is the Idempotency-Key of the document, if any.
+	key string
+}
+
+func (s *httpSink) Open(name string) error {
+	s.name = name
+	s.key = ""
+	s.buf.Reset()
+	return nil
+}
+
+func (s *httpSink) Write(p []byte) (int, error) {
+	return s.buf.Write(p)
+}
+
+func (s *httpSink) Commit() error {
+	req, err := http.NewRequest(http.MethodPut, s.base+"/"+s.name, bytes.NewReader(s.buf.Bytes()))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/xml")
+	if len(s.key) > 0 {
+		req.Header.Set("Idempotency-Key", s.key)
+	}
+	return put(s.client, req)
+}
+
+func (s *httpSink) setIdempotencyKey(key string) {
+	s.key = key
+}
+
+func (s *httpSink) Abort() error {
+	s.buf.Reset()
+	return nil
+}
+
+func (s *httpSink) KeepPartial() error {
+	s.name += partialSuffix
+	return s.Commit()
+}
+
+// s3PartSize is the size of the parts of the multipart uploads to S3. The
+// documents smaller than a part are uploaded with a single PUT.
+const s3PartSize = 8 << 20
+
+// s3Sink uploads every committed document to the bucket, at prefix/name. If
+// endpoint is set (e.g. for minio), path style urls are used. The documents
+// are streamed: once a document outgrows a part, it is uploaded part by part
+// with a multipart upload as it is written, so that a worker holds at most a
+// part in memory.
+type s3Sink struct {
+	bucket   string
+	prefix   string
+	endpoint string
+	creds    *awsCredentialProvider
+	client   *http.Client
+	name     string
+	buf      bytes.Buffer
+	// partSize is the size of the parts. s3PartSize if 0.
+	partSize int
+	// uploadID is the id of the multipart upload in progress, if any, and
+	// parts the ETags of its uploaded parts.
+	uploadID string
+	parts    []string
+	// now is used to sign the request. time.Now if nil.
+	now func() time.Time
+}
+
+func (s *s3Sink) Open(name string) error {
+	s.name, s.uploadID, s.parts = name, "", nil
+	s.buf.Reset()
+	return nil
+}
+
+func (s *s3Sink) Write(p []byte) (int, error) {
+	s.buf.Write(p)
+	size := s.partSize
+	if size == 0 {
+		size = s3PartSize
+	}
+	for s.buf.Len() >= size {
+		if err := s.uploadPart(s.buf.Next(size)); err != nil {
+			return 0, err
+		}
+	}
+	return len(p), nil
+}
+
+// objectURL returns the url of the object.
+func (s *s3Sink) objectURL() string {
+	key := awsEscapePath(path.Join(s.prefix, s.name))
+	if len(s.endpoint) > 0 {
+		return strings.TrimSuffix(s.endpoint, "/") + "/" + s.bucket + "/" + key
+	}
+	return "https://" + s.bucket + ".s3." + s.creds.region + ".amazonaws.com/" + key
+}
+
+// send sends a signed request to the object, with the query and payload, and
+// returns the response once it succeeded.
+func (s *s3Sink) send(method string, query url.Values, payload []byte) (*http.Response,
+	[]byte, error) {
+	u := s.objectURL()
+	if len(query) > 0 {
+		u += "?" + query.Encode()
+	}
+	req, err := http.NewRequest(method, u, bytes.NewReader(payload))
+	if err != nil {
+		return nil, nil, err
+	}
+	// The type of the object is set by the request creating it.
+	if method == http.MethodPut && len(query) == 0 || query.Has("uploads") {
+		req.Header.Set("Content-Type", "application/xml")
+	}
+	creds, err := s.creds.get()
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "upload")
+	}
+	now := time.Now()
+	if s.now != nil {
+		now = s.now()
+	}
+	creds.signV4(req, payload, "s3", now)
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "upload")
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(io.LimitReader(resp.Body, 64<<10))
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "upload")
+	}
+	// S3 reports the failures of CompleteMultipartUpload with a 200 status.
+	if resp.StatusCode/100 != 2 || bytes.Contains(body, []byte("<Error>")) {
+		return nil, nil, errors.Errorf("upload of %q failed with status %q: %s",
+			req.URL.Redacted(), resp.Status, bytes.TrimSpace(body))
+	}
+	return resp, body, nil
+}
+
+// uploadPart uploads the next part of the document, starting the multipart
+// upload with the first one.
+func (s *s3Sink) uploadPart(part []byte) error {
+	if len(s.uploadID) == 0 {
+		_, body, err := s.send(http.MethodPost, url.Values{"uploads": {""}}, nil)
+		if err != nil {
+			return err
+		}
+		var out struct {
+			UploadID string `xml:"UploadId"`
+		}
+		if err := xml.Unmarshal(body, &out); err != nil || len(out.UploadID) == 0 {
+			return errors.Errorf("upload of %q: invalid response to the multipart upload: %q",
+				s.name, body)
+		}
+		s.uploadID = out.UploadID
+	}
+	resp, _, err := s.send(http.MethodPut, url.Values{
+		"partNumber": {strconv.Itoa(len(s.parts) + 1)},
+		"uploadId":   {s.uploadID},
+	}, part)
+	if err != nil {
+		return err
+	}
+	s.parts = append(s.parts, resp.Header.Get("ETag"))
+	return nil
+}
+
+// s3CompletedPart is a part of a CompleteMultipartUpload request.
+type s3CompletedPart struct {
+	PartNumber int
+	ETag       string
+}
+
+func (s *s3Sink) Commit() error {
+	if len(s.uploadID) == 0 {
+		_, _, err := s.send(http.MethodPut, nil, s.buf.Bytes())
+		return err
+	}
+	if s.buf.Len() > 0 {
+		if err := s.uploadPart(s.buf.Next(s.buf.Len())); err != nil {
+			s.Abort()
+			return err
+		}
+	}
+	complete := struct {
+		XMLName xml.Name          `xml:"CompleteMultipartUpload"`
+		Parts   []s3CompletedPart `xml:"Part"`
+	}{}
+	for i, etag := range s.parts {
+		complete.Parts = append(complete.Parts, s3CompletedPart{PartNumber: i + 1, ETag: etag})
+	}
+	payload, err := xml.Marshal(complete)
+	if err != nil {
+		return err
+	}
+	if _, _, err := s.send(http.MethodPost, url.Values{"uploadId": {s.uploadID}},
+		payload); err != nil {
+		s.Abort()
+		return err
+	}
+	s.uploadID = ""
+	return nil
+}
+
+// Abort discards the document, and the parts uploaded so far.
+func (s *s3Sink) Abort() error {
+	s.buf.Reset()
+	if len(s.uploadID) == 0 {
+		return nil
+	}
+	_, _, err := s.send(http.MethodDelete, url.Values{"uploadId": {s.uploadID}}, nil)
+	s.uploadID = ""
+	return err
+}
+
+// KeepPartial uploads the document under its name with a .partial suffix. The
+// documents being uploaded in parts already have their name, and are
+// discarded.
+func (s *s3Sink) KeepPartial() error {
+	if len(s.uploadID) > 0 {
+		if err := s.Abort(); err != nil {
+			return err
+		}
+		return errors.Errorf("can't keep the partial multipart upload of %q", s.name)
+	}
+	s.name += partialSuffix
+	return s.Commit()
+}
+
+// gcsSink uploads every committed document to the Cloud Storage bucket, at
+// prefix/name.
+type gcsSink struct {
+	bucket   string
+	prefix   string
+	endpoint string
+	// tokens authenticate the requests. nil for emulators.
+	tokens *gcpTokenSource
+	client *http.Client
+	name   string
+	buf    bytes.Buffer
+}
+
+func (s *gcsSink) Open(name string) error {
+	s.name = name
+	s.buf.Reset()
+	return nil
+}
+
+func (s *gcsSink) Write(p []byte) (int, error) {
+	return s.buf.Write(p)
+}
+
+// uploadURL returns the url of the media upload of the object.
+func (s *gcsSink) uploadURL() string {
+	return strings.TrimSuffix(s.endpoint, "/") + "/upload/storage/v1/b/" +
+		url.PathEscape(s.bucket) + "/o?" + url.Values{
+		"uploadType": {"media"},
+		"name":       {path.Join(s.prefix, s.name)},
+	}.Encode()
+}
+
+func (s *gcsSink) Commit() error {
+	req, err := http.NewRequest(http.MethodPost, s.uploadURL(), bytes.NewReader(s.buf.Bytes()))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/xml")
+	if s.tokens != nil {
+		token, err := s.tokens.get()
+		if err != nil {
+			return errors.Wrap(err, "upload")
+		}
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	return put(s.client, req)
+}
+
+func (s *gcsSink) Abort() error {
+	s.buf.Reset()
+	return nil
+}
+
+func (s *gcsSink) KeepPartial() error {
+	s.name += partialSuffix
+	return s.Commit()
+}
+
+// azureSink uploads every committed document as a block blob of the Azure
+// container, at prefix/name.
+type azureSink struct {
+	endpoint  string
+	container string
+	prefix    string
+	creds     azureCredentials
+	client    *http.Client
+	name      string
+	buf       bytes.Buffer
+	// now is used to sign the request. time.Now if nil.
+	now func() time.Time
+}
+
+func (s *azureSink) Open(name string) error {
+	s.name = name
+	s.buf.Reset()
+	return nil
+}
+
+func (s *azureSink) Write(p []byte) (int, error) {
+	return s.buf.Write(p)
+}
+
+// blobURL returns the url of the blob.
+func (s *azureSink) blobURL() string {
+	u := url.URL{Path: "/" + s.container + "/" + path.Join(s.prefix, s.name)}
+	return s.endpoint + u.EscapedPath()
+}
+
+func (s *azureSink) Commit() error {
+	req, err := http.NewRequest(http.MethodPut, s.blobURL(), bytes.NewReader(s.buf.Bytes()))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/xml")
+	req.Header.Set("X-Ms-Blob-Type", "BlockBlob")
+	now := time.Now()
+	if s.now != nil {
+		now = s.now()
+	}
+	s.creds.authorize(req, now)
+	return put(s.client, req)
+}
+
+func (s *azureSink) Abort() error {
+	s.buf.Reset()
+	return nil
+}
+
+func (s *azureSink) KeepPartial() error {
+	s.name += partialSuffix
+	return s.Commit()
+}
+
+// put sends the upload request and checks that it succeeded.
+func put(client *http.Client, req *http.Request) error {
+	resp, err := client.Do(req)
+	if err != nil {
+		return errors.Wrap(err, "upload")
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		body, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 512))
+		return errors.Errorf("upload of %q failed with status %q: %s", req.URL.Redacted(),
+			resp.Status, bytes.TrimSpace(body))
+	}
+	return nil
+}
@@ -0,0 +1,348 @@
+package jsontoxml
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/xml"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestFileSink(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sink")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	s := &fileSink{dir: dir}
+	require.NoError(t, s.Open("a/0.xml"))
+	_, err = s.Write([]byte("<x/>"))
+	require.NoError(t, err)
+	// Nothing is visible before the commit.
+	_, err = os.Stat(filepath.Join(dir, "a", "0.xml"))
+	require.True(t, os.IsNotExist(err))
+	require.NoError(t, s.Commit())
+	data, err := ioutil.ReadFile(filepath.Join(dir, "a", "0.xml"))
+	require.NoError(t, err)
+	require.Equal(t, "<x/>", string(data))
+	// The outputs get the permissions of os.Create.
+	f, err := os.Create(filepath.Join(dir, "created"))
+	require.NoError(t, err)
+	f.Close()
+	created, err := os.Stat(f.Name())
+	require.NoError(t, err)
+	written, err := os.Stat(filepath.Join(dir, "a", "0.xml"))
+	require.NoError(t, err)
+	require.Equal(t, created.Mode(), written.Mode())
+	require.NoError(t, os.Remove(f.Name()))
+
+	require.NoError(t, s.Open("1.xml"))
+	_, err = s.Write([]byte("partial"))
+	require.NoError(t, err)
+	require.NoError(t, s.Abort())
+	files, err := ioutil.ReadDir(dir)
+	require.NoError(t, err)
+	require.Len(t, files, 1)
+}
+
+func TestStdoutSink(t *testing.T) {
+	var buf bytes.Buffer
+	st, err := newStdoutStream(&buf, `\n`, "")
+	require.NoError(t, err)
+	s := &streamSink{stream: st}
+	require.NoError(t, s.Open("0.xml"))
+	s.Write([]byte("<a/>"))
+	require.NoError(t, s.Commit())
+	require.NoError(t, s.Open("1.xml"))
+	s.Write([]byte("<b/>"))
+	require.NoError(t, s.Abort())
+	require.NoError(t, st.Close())
+	require.Equal(t, "<a/>\n", buf.String())
+
+	buf.Reset()
+	st, err = newStdoutStream(&buf, `\n\n`, "docs")
+	require.NoError(t, err)
+	for _, doc := range []string{"<a/>", "<b/>"} {
+		s := &streamSink{stream: st}
+		require.NoError(t, s.Open("0.xml"))
+		s.Write([]byte(doc))
+		require.NoError(t, s.Commit())
+	}
+	require.NoError(t, st.Close())
+	require.Equal(t, "<docs>\n<a/>\n\n<b/>\n\n</docs>\n", buf.String())
+
+	// The root is a well-formed document without documents too.
+	buf.Reset()
+	st, err = newStdoutStream(&buf, "", "docs")
+	require.NoError(t, err)
+	require.NoError(t, st.Close())
+	require.Equal(t, "<docs>\n</docs>\n", buf.String())
+
+	// The declaration precedes the root element.
+	buf.Reset()
+	st, err = newStdoutStream(&buf, "", "docs")
+	require.NoError(t, err)
+	st.declaration = true
+	require.NoError(t, st.Close())
+	require.Equal(t, "<?xml version=\"1.0\" encoding=\"UTF-8\"?>\n<docs>\n</docs>\n", buf.String())
+
+	_, err = newStdoutStream(&buf, `\`, "")
+	require.EqualError(t, err, `invalid --stdout-delimiter "\\"`)
+	_, err = newStdoutStream(&buf, `\n`, "a b")
+	require.EqualError(t, err, `invalid --stdout-root "a b". Expected an xml name`)
+}
+
+func TestHTTPSink(t *testing.T) {
+	var gotPath, gotBody string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, http.MethodPut, r.Method)
+		body, _ := ioutil.ReadAll(r.Body)
+		gotPath, gotBody = r.URL.Path, string(body)
+		if strings.HasSuffix(r.URL.Path, "fail.xml") {
+			http.Error(w, "nope", http.StatusForbidden)
+		}
+	}))
+	defer srv.Close()
+
+	s := &httpSink{base: srv.URL + "/docs", client: srv.Client()}
+	require.NoError(t, s.Open("a/0.xml"))
+	s.Write([]byte("<x/>"))
+	require.NoError(t, s.Commit())
+	require.Equal(t, "/docs/a/0.xml", gotPath)
+	require.Equal(t, "<x/>", gotBody)
+
+	require.NoError(t, s.Open("fail.xml"))
+	err := s.Commit()
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "403")
+}
+
+func TestS3Sink(t *testing.T) {
+	var req *http.Request
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		req = r
+	}))
+	defer srv.Close()
+
+	s := &s3Sink{
+		bucket:   "bucket",
+		prefix:   "out",
+		endpoint: srv.URL,
+		creds: staticAWSCredentials(awsCredentials{accessKey: "AKID", secretKey: "secret",
+			region: "us-east-1"}),
+		client: srv.Client(),
+		now:    func() time.Time { return time.Date(2021, 3, 1, 0, 0, 0, 0, time.UTC) },
+	}
+	require.NoError(t, s.Open("0.xml"))
+	s.Write([]byte("<x/>"))
+	require.NoError(t, s.Commit())
+	require.Equal(t, "/bucket/out/0.xml", req.URL.Path)
+	require.Equal(t, sha256Hex([]byte("<x/>")), req.Header.Get("X-Amz-Content-Sha256"))
+	require.Contains(t, req.Header.Get("Authorization"),
+		"Credential=AKID/20210301/us-east-1/s3/aws4_request")
+
+	// The keys are sent encoded the way they are signed.
+	require.NoError(t, s.Open("a b/#1 50%=x,y.xml"))
+	require.NoError(t, s.Commit())
+	require.Equal(t, "/bucket/out/a b/#1 50%=x,y.xml", req.URL.Path)
+	require.Equal(t, "/bucket/out/a%20b/%231%2050%25%3Dx%2Cy.xml", req.URL.EscapedPath())
+
+	s.endpoint = ""
+	require.Equal(t, "https://bucket.s3.us-east-1.amazonaws.com/out/a%20b/%231%2050%25%3Dx%2Cy.xml",
+		s.objectURL())
+}
+
+func TestS3SinkMultipart(t *testing.T) {
+	var mu sync.Mutex
+	var requests []string
+	parts := make(map[string]string)
+	objects := make(map[string]string)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		body, _ := ioutil.ReadAll(r.Body)
+		q := r.URL.Query()
+		requests = append(requests, r.Method+" "+r.URL.RawQuery)
+		switch {
+		case r.Method == http.MethodPost && q.Has("uploads"):
+			require.Equal(t, "application/xml", r.Header.Get("Content-Type"))
+			fmt.Fprint(w, `<InitiateMultipartUploadResult><UploadId>u1</UploadId>`+
+				`</InitiateMultipartUploadResult>`)
+		case r.Method == http.MethodPut && q.Has("partNumber"):
+			require.Equal(t, "u1", q.Get("uploadId"))
+			parts[q.Get("partNumber")] = string(body)
+			w.Header().Set("ETag", `"etag`+q.Get("partNumber")+`"`)
+		case r.Method == http.MethodPost:
+			var complete struct {
+				Parts []s3CompletedPart `xml:"Part"`
+			}
+			require.NoError(t, xml.Unmarshal(body, &complete))
+			var object string
+			for _, p := range complete.Parts {
+				require.Equal(t, fmt.Sprintf(`"etag%d"`, p.PartNumber), p.ETag)
+				object += parts[strconv.Itoa(p.PartNumber)]
+			}
+			objects[r.URL.Path] = object
+		case r.Method == http.MethodPut:
+			objects[r.URL.Path] = string(body)
+		}
+	}))
+	defer srv.Close()
+
+	s := &s3Sink{
+		bucket:   "bucket",
+		endpoint: srv.URL,
+		creds: staticAWSCredentials(awsCredentials{accessKey: "AKID", secretKey: "secret",
+			region: "us-east-1"}),
+		client:   srv.Client(),
+		partSize: 4,
+	}
+	require.NoError(t, s.Open("0.xml"))
+	for _, p := range []string{"<a>", "hello", "</a>"} {
+		_, err := s.Write([]byte(p))
+		require.NoError(t, err)
+	}
+	// The parts are uploaded as they are written.
+	require.Equal(t, map[string]string{"1": "<a>h", "2": "ello", "3": "</a>"}, parts)
+	require.NoError(t, s.Commit())
+	require.Equal(t, "<a>hello</a>", objects["/bucket/0.xml"])
+	require.Equal(t, []string{"POST uploads=", "PUT partNumber=1&uploadId=u1",
+		"PUT partNumber=2&uploadId=u1", "PUT partNumber=3&uploadId=u1", "POST uploadId=u1"},
+		requests)
+
+	// Small documents are uploaded at once.
+	requests = nil
+	require.NoError(t, s.Open("1.xml"))
+	s.Write([]byte("<b>"))
+	require.NoError(t, s.Commit())
+	require.Equal(t, "<b>", objects["/bucket/1.xml"])
+	require.Equal(t, []string{"PUT "}, requests)
+
+	// Aborting a document discards its parts.
+	requests = nil
+	require.NoError(t, s.Open("2.xml"))
+	s.Write([]byte("<c>partial"))
+	require.NoError(t, s.Abort())
+	require.Equal(t, "DELETE uploadId=u1", requests[len(requests)-1])
+	require.NotContains(t, objects, "/bucket/2.xml")
+}
+
+func TestSinkFactory(t *testing.T) {
+	newSink, err := sinkFactory("-")
+	require.NoError(t, err)
+	require.IsType(t, &streamSink{}, newSink())
+	newSink, err = sinkFactory("https://example.com/up")
+	require.NoError(t, err)
+	require.IsType(t, &httpSink{}, newSink())
+	newSink, err = sinkFactory("./out")
+	require.NoError(t, err)
+	require.IsType(t, &fileSink{}, newSink())
+	_, err = sinkFactory("s3://")
+	require.Error(t, err)
+
+	t.Setenv("STORAGE_EMULATOR_HOST", "localhost:4443")
+	newSink, err = sinkFactory("gs://bucket/out/")
+	require.NoError(t, err)
+	gs := newSink().(*gcsSink)
+	require.Equal(t, "http://localhost:4443", gs.endpoint)
+	require.Equal(t, "out", gs.prefix)
+	// The emulator doesn't authenticate the requests.
+	require.Nil(t, gs.tokens)
+	t.Setenv("AZURE_STORAGE_SAS_TOKEN", "")
+	t.Setenv("AZURE_STORAGE_KEY", "")
+	_, err = sinkFactory("az://account/container")
+	require.EqualError(t, err,
+		"az output: AZURE_STORAGE_KEY or AZURE_STORAGE_SAS_TOKEN must be set")
+	t.Setenv("AZURE_STORAGE_KEY", "a2V5")
+	newSink, err = sinkFactory("az://account/container/out")
+	require.NoError(t, err)
+	as := newSink().(*azureSink)
+	require.Equal(t, "https://account.blob.core.windows.net", as.endpoint)
+	require.Equal(t, "out", as.prefix)
+	_, err = sinkFactory("az://account")
+	require.EqualError(t, err,
+		`invalid azure output "az://account". Expected az://account/container/prefix`)
+}
+
+func TestGCSSink(t *testing.T) {
+	var req *http.Request
+	var body string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		data, _ := ioutil.ReadAll(r.Body)
+		req, body = r, string(data)
+	}))
+	defer srv.Close()
+
+	s := &gcsSink{
+		bucket:   "bucket",
+		prefix:   "out",
+		endpoint: srv.URL,
+		tokens: &gcpTokenSource{now: time.Now, fetch: func() (string, time.Duration, error) {
+			return "ya29.token", time.Hour, nil
+		}},
+		client: srv.Client(),
+	}
+	require.NoError(t, s.Open("a/0.xml"))
+	s.Write([]byte("<x/>"))
+	require.NoError(t, s.Commit())
+	require.Equal(t, http.MethodPost, req.Method)
+	require.Equal(t, "/upload/storage/v1/b/bucket/o", req.URL.Path)
+	require.Equal(t, "out/a/0.xml", req.URL.Query().Get("name"))
+	require.Equal(t, "media", req.URL.Query().Get("uploadType"))
+	require.Equal(t, "Bearer ya29.token", req.Header.Get("Authorization"))
+	require.Equal(t, "<x/>", body)
+}
+
+func TestAzureSink(t *testing.T) {
+	var req *http.Request
+	var body string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		data, _ := ioutil.ReadAll(r.Body)
+		req, body = r, string(data)
+		w.WriteHeader(http.StatusCreated)
+	}))
+	defer srv.Close()
+
+	now := time.Date(2021, 3, 1, 0, 0, 0, 0, time.UTC)
+	s := &azureSink{
+		endpoint:  srv.URL,
+		container: "docs",
+		prefix:    "out",
+		creds:     azureCredentials{account: "acct", key: []byte("secret")},
+		client:    srv.Client(),
+		now:       func() time.Time { return now },
+	}
+	require.NoError(t, s.Open("a b.xml"))
+	s.Write([]byte("<x/>"))
+	require.NoError(t, s.Commit())
+	require.Equal(t, http.MethodPut, req.Method)
+	require.Equal(t, "/docs/out/a%20b.xml", req.URL.EscapedPath())
+	require.Equal(t, "BlockBlob", req.Header.Get("X-Ms-Blob-Type"))
+	require.Equal(t, "<x/>", body)
+	stringToSign := "PUT\n\n\n4\n\napplication/xml\n\n\n\n\n\n\n" +
+		"x-ms-blob-type:BlockBlob\nx-ms-date:Mon, 01 Mar 2021 00:00:00 GMT\n" +
+		"x-ms-version:" + azureStorageVersion + "\n/acct/docs/out/a%20b.xml"
+	mac := hmac.New(sha256.New, []byte("secret"))
+	mac.Write([]byte(stringToSign))
+	require.Equal(t, "SharedKey acct:"+base64.StdEncoding.EncodeToString(mac.Sum(nil)),
+		req.Header.Get("Authorization"))
+
+	// SAS tokens are added to the url instead.
+	s.creds = azureCredentials{account: "acct", sas: "sv=2020-10-02&sig=abc"}
+	require.NoError(t, s.Open("0.xml"))
+	require.NoError(t, s.Commit())
+	require.Equal(t, "abc", req.URL.Query().Get("sig"))
+	require.Empty(t, req.Header.Get("Authorization"))
+}
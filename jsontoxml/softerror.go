@@ -0,0 +1,177 @@
+package jsontoxml
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+var (
+	errorField, errorPath string
+	// validator checks the payloads of the command line, if set.
+	validator Validator
+)
+
+func init() {
+	rootCmd.PersistentFlags().StringVar(&errorField, "error-field", "",
+		"Fail the payloads that are objects with this field set (not null, false or empty), for"+
+			" APIs reporting errors in a 200 response.")
+	rootCmd.PersistentFlags().StringVar(&errorPath, "error-path", "",
+		"Fail the payloads with a value at this path, e.g. $.meta.error, or with the given"+
+			" value, e.g. $.status=error.")
+}
+
+// pathStep is a step of a path: an object key, or an array index if key is
+// empty.
+type pathStep struct {
+	key   string
+	index int
+}
+
+// parsePath parses a path of the form $.a.b[0].c.
+func parsePath(p string) ([]pathStep, error) {
+	if !strings.HasPrefix(p, "$") {
+		return nil, errors.Errorf("invalid path %q. Expected $.key[index]...", p)
+	}
+	var steps []pathStep
+	rest := p[1:]
+	for len(rest) > 0 {
+		switch rest[0] {
+		case '.':
+			end := strings.IndexAny(rest[1:], ".[") + 1
+			if end == 0 {
+				end = len(rest)
+			}
+			if end == 1 {
+				return nil, errors.Errorf("invalid path %q. Empty key", p)
+			}
+			steps = append(steps, pathStep{key: rest[1:end]})
+			rest = rest[end:]
+		case '[':
+			end := strings.IndexByte(rest, ']')
+			if end < 0 {
+				return nil, errors.Errorf("invalid path %q. Unterminated index", p)
+			}
+			i, err := strconv.Atoi(rest[1:end])
+			if err != nil || i < 0 {
+				return nil, errors.Errorf("invalid path %q. Bad index %q", p, rest[1:end])
+			}
+			steps = append(steps, pathStep{index: i})
+			rest = rest[end+1:]
+		default:
+			return nil, errors.Errorf("invalid path %q. Expected $.key[index]...", p)
+		}
+	}
+	return steps, nil
+}
+
+// lookup returns the value at the path in v, a decoded json document.
+func lookup(v interface{}, steps []pathStep) (interface{}, bool) {
+	for _, s := range steps {
+		if len(s.key) > 0 {
+			obj, ok := v.(map[string]interface{})
+			if !ok {
+				return nil, false
+			}
+			if v, ok = obj[s.key]; !ok {
+				return nil, false
+			}
+			continue
+		}
+		arr, ok := v.([]interface{})
+		if !ok || s.index >= len(arr) {
+			return nil, false
+		}
+		v = arr[s.index]
+	}
+	return v, true
+}
+
+// isSet returns false for the json values that don't report an error: null,
+// false, "", and empty objects and arrays.
+func isSet(v interface{}) bool {
+	switch v := v.(type) {
+	case nil:
+		return false
+	case bool:
+		return v
+	case string:
+		return len(v) > 0
+	case map[string]interface{}:
+		return len(v) > 0
+	case []interface{}:
+		return len(v) > 0
+	}
+	return true
+}
+
+// newSoftErrorValidator returns a Validator failing the payloads that report an
+// error in their content: field is a top-level field and path a path,
+// optionally followed by =value. It returns nil if both are empty.
+func newSoftErrorValidator(field, path string) (Validator, error) {
+	var checks []func(doc interface{}) (interface{}, bool)
+	if len(field) > 0 {
+		steps := []pathStep{{key: field}}
+		checks = append(checks, func(doc interface{}) (interface{}, bool) {
+			v, ok := lookup(doc, steps)
+			return v, ok && isSet(v)
+		})
+	}
+	if len(path) > 0 {
+		var want *string
+		if i := strings.IndexByte(path, '='); i >= 0 {
+			v := path[i+1:]
+			path, want = path[:i], &v
+		}
+		steps, err := parsePath(path)
+		if err != nil {
+			return nil, errors.Wrap(err, "--error-path")
+		}
+		checks = append(checks, func(doc interface{}) (interface{}, bool) {
+			v, ok := lookup(doc, steps)
+			if !ok || want == nil {
+				return v, ok && isSet(v)
+			}
+			s, isString := v.(string)
+			if !isString {
+				s = compactJSON(v)
+			}
+			return v, s == *want
+		})
+	}
+	if len(checks) == 0 {
+		return nil, nil
+	}
+	return func(m Metadata, body *bufio.Reader) (io.Reader, error) {
+		data, err := ioutil.ReadAll(body)
+		if err != nil {
+			return nil, errors.Wrap(err, "read")
+		}
+		d := json.NewDecoder(bytes.NewReader(data))
+		d.UseNumber()
+		var doc interface{}
+		// Invalid documents are reported by the conversion.
+		if d.Decode(&doc) == nil {
+			for _, check := range checks {
+				if v, failed := check(doc); failed {
+					return nil, errors.Errorf("error in the payload: %s", compactJSON(v))
+				}
+			}
+		}
+		return bytes.NewReader(data), nil
+	}, nil
+}
+
+func compactJSON(v interface{}) string {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return "?"
+	}
+	return string(data)
+}
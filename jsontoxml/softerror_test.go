@@ -0,0 +1,58 @@
+package jsontoxml
+
+import (
+	"bufio"
+	"io/ioutil"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParsePath(t *testing.T) {
+	steps, err := parsePath("$.a.b[1].c")
+	require.NoError(t, err)
+	require.Equal(t, []pathStep{{key: "a"}, {key: "b"}, {index: 1}, {key: "c"}}, steps)
+	steps, err = parsePath("$")
+	require.NoError(t, err)
+	require.Empty(t, steps)
+	for _, p := range []string{"a.b", "$..a", "$.a[", "$.a[x]", "$.a[-1]", "$a"} {
+		_, err := parsePath(p)
+		require.Error(t, err, p)
+	}
+}
+
+func TestSoftErrorValidator(t *testing.T) {
+	v, err := newSoftErrorValidator("", "")
+	require.NoError(t, err)
+	require.Nil(t, v)
+	_, err = newSoftErrorValidator("", "status")
+	require.Error(t, err)
+
+	check := func(field, path, payload string) error {
+		v, err := newSoftErrorValidator(field, path)
+		require.NoError(t, err)
+		r, err := v(Metadata{}, bufio.NewReader(strings.NewReader(payload)))
+		if err != nil {
+			return err
+		}
+		// Accepted payloads are converted unchanged.
+		data, err := ioutil.ReadAll(r)
+		require.NoError(t, err)
+		require.Equal(t, payload, string(data))
+		return nil
+	}
+	require.EqualError(t, check("error", "", `{"error": {"code": 42}}`),
+		`error in the payload: {"code":42}`)
+	for _, ok := range []string{`{"error": null}`, `{"error": false}`, `{"error": ""}`,
+		`{"id": 1}`, `[{"error": "x"}]`, `not json`} {
+		require.NoError(t, check("error", "", ok), ok)
+	}
+
+	require.Error(t, check("", "$.meta.errors[0]", `{"meta": {"errors": ["quota"]}}`))
+	require.NoError(t, check("", "$.meta.errors[0]", `{"meta": {"errors": []}}`))
+	require.EqualError(t, check("", "$.status=error", `{"status": "error"}`),
+		`error in the payload: "error"`)
+	require.NoError(t, check("", "$.status=error", `{"status": "ok"}`))
+	require.Error(t, check("", "$[0].code=500", `[{"code": 500}]`))
+}
@@ -0,0 +1,373 @@
+package jsontoxml
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+// Metadata describes a payload read from a Source.
+type Metadata struct {
+	// Index is the position of the payload in the source, e.g. of the url in
+	// the url list.
+	Index int
+	// URL identifies the payload: the url, file path, etc.
+	URL string
+	// Mirrors are alternate urls of the payload, tried if URL fails.
+	Mirrors     []string
+	ContentType string
+	// StatusCode and Header are those of the response of http payloads.
+	StatusCode int
+	Header     http.Header
+	// NotModified is set if the payload wasn't modified since the previous
+	// run and was read from the --cache-dir.
+	NotModified bool
+
+	// resp and rec are kept for the failure captures of http payloads.
+	resp *http.Response
+	rec  *bodyRecorder
+}
+
+var (
+	acceptStatusFlags []string
+	// acceptedStatus are the statuses other than 2xx whose payloads are
+	// converted.
+	acceptedStatus statusSet
+)
+
+func init() {
+	rootCmd.PersistentFlags().StringSliceVar(&acceptStatusFlags, "accept-status", nil,
+		"Statuses other than 2xx whose payloads are converted rather than failing the url,"+
+			" as codes or ranges, e.g. 404,410 or 400-499.")
+}
+
+// statusSet is a set of HTTP statuses, as inclusive ranges.
+type statusSet [][2]int
+
+// parseStatusSet parses the --accept-status flags.
+func parseStatusSet(flags []string) (statusSet, error) {
+	var set statusSet
+	for _, f := range flags {
+		lo, hi := f, f
+		if i := strings.Index(f, "-"); i >= 0 {
+			lo, hi = f[:i], f[i+1:]
+		}
+		from, err1 := strconv.Atoi(strings.TrimSpace(lo))
+		to, err2 := strconv.Atoi(strings.TrimSpace(hi))
+		if err1 != nil || err2 != nil || from < 100 || to > 599 || from > to {
+			return nil, errors.Errorf("invalid --accept-status %q. Expected a status, e.g. 404,"+
+				" or a range, e.g. 400-499", f)
+		}
+		set = append(set, [2]int{from, to})
+	}
+	return set, nil
+}
+
+func (s statusSet) has(code int) bool {
+	for _, r := range s {
+		if code >= r[0] && code <= r[1] {
+			return true
+		}
+	}
+	return false
+}
+
+// Source is an input of json payloads. Next returns the next payload and its
+// metadata, or io.EOF once the source is exhausted. Any other error concerns
+// the returned payload only and the source can still be read. Implement it to
+// add an input without touching the convert logic. Implementations must be
+// safe for concurrent use.
+type Source interface {
+	Next() (io.ReadCloser, Metadata, error)
+}
+
+// urlSource fetches the urls of the list owned by the shard. It can fetch a
+// url again, so its failures are retried.
+type urlSource struct {
+	client  Getter
+	capture *failureCapture
+	urls    []string
+	// iter returns the urls following urls, if set. It is read as the urls
+	// are claimed.
+	iter  *urlIter
+	shard shard
+	// pages follows the pages of the urls, if set.
+	pages *pagination
+	// method and body are those of the requests. GET if method is empty, and
+	// no body if body is nil.
+	method string
+	body   []byte
+	// accepted are the statuses other than 2xx whose payloads are converted.
+	accepted statusSet
+
+	mu   sync.Mutex
+	next int
+}
+
+func (s *urlSource) Next() (io.ReadCloser, Metadata, error) {
+	m, err := s.claim()
+	if err != nil {
+		return nil, m, err
+	}
+	return s.fetch(context.Background(), m)
+}
+
+var _ refetcher = &urlSource{}
+
+// claim returns the metadata of the next url owned by the shard.
+func (s *urlSource) claim() (Metadata, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for {
+		entry, err := s.entry()
+		if err != nil {
+			return Metadata{}, err
+		}
+		i := s.next
+		s.next++
+		// Urls are assigned to shards by their primary url, so adding mirrors
+		// doesn't move them.
+		if !s.shard.owns(primaryURL(entry)) {
+			continue
+		}
+		url, mirrors := splitMirrors(entry)
+		return Metadata{Index: i, URL: url, Mirrors: mirrors}, nil
+	}
+}
+
+// entry returns the entry of the list at s.next, or io.EOF.
+func (s *urlSource) entry() (string, error) {
+	if s.next < len(s.urls) {
+		return s.urls[s.next], nil
+	}
+	if s.iter == nil {
+		return "", io.EOF
+	}
+	return s.iter.next()
+}
+
+// splitMirrors splits an entry of the url list into the url and its mirrors,
+// separated by |: "http://a/x.json|http://b/x.json".
+func splitMirrors(entry string) (url string, mirrors []string) {
+	parts := strings.Split(entry, "|")
+	for i := range parts {
+		parts[i] = strings.TrimSpace(parts[i])
+	}
+	return parts[0], parts[1:]
+}
+
+// primaryURL returns the url of an entry of the url list, without mirrors.
+func primaryURL(entry string) string {
+	url, _ := splitMirrors(entry)
+	return url
+}
+
+// fetch gets the url of m. The request is canceled with ctx if the client
+// supports it.
+func (s *urlSource) fetch(ctx context.Context, m Metadata) (io.ReadCloser, Metadata, error) {
+	body, m, err := s.fetchPage(ctx, m)
+	if err != nil || s.pages == nil {
+		return body, m, err
+	}
+	// The cache only knows about the first page.
+	m.NotModified = false
+	return s.pages.follow(ctx, s, body, m), m, nil
+}
+
+// fetchPage gets the url of m, without following its pages.
+func (s *urlSource) fetchPage(ctx context.Context, m Metadata) (io.ReadCloser, Metadata, error) {
+	resp, err := s.get(ctx, m.URL)
+	if err != nil {
+		return nil, m, &fetchError{err: err}
+	}
+	m.resp = resp
+	m.rec = s.capture.record(resp)
+	m.ContentType = resp.Header.Get("Content-Type")
+	m.StatusCode, m.Header = resp.StatusCode, resp.Header
+	m.NotModified = len(resp.Header.Get(cacheHeader)) > 0
+	if (resp.StatusCode < 200 || resp.StatusCode > 299) && !s.accepted.has(resp.StatusCode) {
+		// Read the body so that it is captured.
+		io.Copy(ioutil.Discard, resp.Body)
+		resp.Body.Close()
+		return nil, m, &statusError{code: resp.StatusCode}
+	}
+	return bodyReader{resp.Body}, m, nil
+}
+
+// fetchError is returned when a url couldn't be fetched, e.g. because the
+// connection failed.
+type fetchError struct {
+	err error
+}
+
+func (e *fetchError) Error() string {
+	return "get failed: " + e.err.Error()
+}
+
+// readError is returned when the body of a response couldn't be read to the
+// end, e.g. because the connection was closed before the Content-Length was
+// received. It tells a truncated body from a truncated json document.
+type readError struct {
+	err error
+}
+
+func (e *readError) Error() string {
+	return "read body failed: " + e.err.Error()
+}
+
+// bodyReader returns the failures to read the body as *readError. The end of
+// the body and the cancellations are returned as they are.
+type bodyReader struct {
+	io.ReadCloser
+}
+
+func (b bodyReader) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	if err != nil && err != io.EOF && !isCanceled(err) {
+		err = &readError{err: err}
+	}
+	return n, err
+}
+
+// statusError is returned when a url is answered with a status other than
+// 2xx that isn't accepted.
+type statusError struct {
+	code int
+}
+
+func (e *statusError) Error() string {
+	return fmt.Sprintf("get failed: status %d %s", e.code, http.StatusText(e.code))
+}
+
+// get requests url with the method and the body of s.
+func (s *urlSource) get(ctx context.Context, url string) (*http.Response, error) {
+	method := s.method
+	if len(method) == 0 {
+		method = http.MethodGet
+	}
+	c, ok := s.client.(interface {
+		Do(req *http.Request) (*http.Response, error)
+	})
+	if !ok {
+		if method != http.MethodGet || s.body != nil {
+			return nil, errors.Errorf("the client can't send %s requests with a body", method)
+		}
+		return s.client.Get(url)
+	}
+	var body io.Reader
+	if s.body != nil {
+		body = bytes.NewReader(s.body)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, url, body)
+	if err != nil {
+		return nil, err
+	}
+	if s.body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	return c.Do(req)
+}
+
+// NewFileSource returns a source reading the json files at paths.
+func NewFileSource(paths ...string) Source {
+	return &fileSource{paths: paths}
+}
+
+// fileSource reads json files.
+type fileSource struct {
+	paths []string
+
+	mu   sync.Mutex
+	next int
+}
+
+func (s *fileSource) Next() (io.ReadCloser, Metadata, error) {
+	s.mu.Lock()
+	if s.next == len(s.paths) {
+		s.mu.Unlock()
+		return nil, Metadata{}, io.EOF
+	}
+	m := Metadata{Index: s.next, URL: s.paths[s.next], ContentType: "application/json"}
+	s.next++
+	s.mu.Unlock()
+	f, err := os.Open(m.URL)
+	if err != nil {
+		return nil, m, errors.Wrap(err, "open failed")
+	}
+	return f, m, nil
+}
+
+// findFiles returns the files under dir (recursively) whose name matches the
+// pattern, in lexical order.
+func findFiles(dir, pattern string) ([]string, error) {
+	if _, err := filepath.Match(pattern, ""); err != nil {
+		return nil, errors.Wrapf(err, "invalid pattern %q", pattern)
+	}
+	var paths []string
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if ok, _ := filepath.Match(pattern, info.Name()); ok && !info.IsDir() {
+			paths = append(paths, path)
+		}
+		return nil
+	})
+	return paths, errors.Wrap(err, "walk")
+}
+
+// stdinSource reads a single payload from r, normally stdin.
+type stdinSource struct {
+	r io.Reader
+
+	mu   sync.Mutex
+	done bool
+}
+
+func (s *stdinSource) Next() (io.ReadCloser, Metadata, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.done {
+		return nil, Metadata{}, io.EOF
+	}
+	s.done = true
+	return ioutil.NopCloser(s.r), Metadata{URL: "-", ContentType: "application/json"}, nil
+}
+
+// NewQueueSource returns a source reading the payloads pushed to ch, until it
+// is closed. It lets code embedding the converter feed payloads from a message
+// queue.
+func NewQueueSource(ch <-chan []byte) Source {
+	return &queueSource{ch: ch}
+}
+
+// queueSource reads the payloads pushed to a channel, until it is closed.
+type queueSource struct {
+	ch <-chan []byte
+
+	mu   sync.Mutex
+	next int
+}
+
+func (s *queueSource) Next() (io.ReadCloser, Metadata, error) {
+	payload, ok := <-s.ch
+	if !ok {
+		return nil, Metadata{}, io.EOF
+	}
+	s.mu.Lock()
+	m := Metadata{Index: s.next, ContentType: "application/json"}
+	s.next++
+	s.mu.Unlock()
+	m.URL = fmt.Sprintf("queue:%d", m.Index)
+	return ioutil.NopCloser(bytes.NewReader(payload)), m, nil
+}
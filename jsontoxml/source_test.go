@@ -0,0 +1,174 @@
+package jsontoxml
+
+import (
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// drain reads every payload of the source.
+func drain(t *testing.T, src Source) ([]string, []Metadata) {
+	var payloads []string
+	var metas []Metadata
+	for {
+		body, m, err := src.Next()
+		if err == io.EOF {
+			return payloads, metas
+		}
+		require.NoError(t, err)
+		data, err := ioutil.ReadAll(body)
+		require.NoError(t, err)
+		body.Close()
+		payloads = append(payloads, string(data))
+		metas = append(metas, m)
+	}
+}
+
+func TestURLSource(t *testing.T) {
+	urls := []string{"valid", "unknown", "valid", "unknown"}
+	src := &urlSource{client: new(mockClient), urls: urls, shard: shard{index: 1, total: 1}}
+	payloads, metas := drain(t, src)
+	require.Len(t, payloads, 4)
+	require.Equal(t, 2, metas[2].Index)
+	require.Equal(t, "valid", metas[2].URL)
+	require.Equal(t, "application/json", metas[0].ContentType)
+
+	// Only the urls owned by the shard are fetched, with their position in
+	// the full list.
+	sh := shard{index: 1, total: 2}
+	_, metas = drain(t, &urlSource{client: new(mockClient), urls: urls, shard: sh})
+	for _, m := range metas {
+		require.True(t, sh.owns(m.URL))
+		require.Equal(t, urls[m.Index], m.URL)
+	}
+
+	_, _, err := (&urlSource{client: new(mockClient), urls: []string{"nope"},
+		shard: shard{index: 1, total: 1}}).Next()
+	require.Error(t, err)
+}
+
+func TestURLSourceIter(t *testing.T) {
+	// The entries of the iterator follow the ones of urls.
+	iter := newURLIter(" valid, unknown ", strings.NewReader("# comment\nvalid\n\nunknown|valid\n"))
+	src := &urlSource{client: new(mockClient), urls: []string{"unknown"}, iter: iter}
+	_, metas := drain(t, src)
+	require.Len(t, metas, 5)
+	for i, u := range []string{"unknown", "valid", "unknown", "valid", "unknown"} {
+		require.Equal(t, i, metas[i].Index)
+		require.Equal(t, u, metas[i].URL)
+	}
+	require.Equal(t, []string{"valid"}, metas[4].Mirrors)
+	require.NoError(t, iter.Err())
+
+	// The entries are the ones of splitList and readURLs.
+	list, err := newURLIter("a,,b ,", strings.NewReader("c\n")).all()
+	require.NoError(t, err)
+	require.Equal(t, append(splitList("a,,b ,"), "c"), list)
+	list, err = newURLIter(" ", nil).all()
+	require.NoError(t, err)
+	require.Empty(t, list)
+
+	// A list that can't be read stops the source.
+	iter = newURLIter("", strings.NewReader("valid\n"+strings.Repeat("x", 1<<17)))
+	_, metas = drain(t, &urlSource{client: new(mockClient), iter: iter})
+	require.Len(t, metas, 1)
+	require.Error(t, iter.Err())
+}
+
+func TestAcceptStatus(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		switch r.URL.Path {
+		case "/gone":
+			w.WriteHeader(http.StatusGone)
+		case "/error":
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+		w.Write([]byte(`{"first_name": "a"}`))
+	}))
+	defer srv.Close()
+
+	accepted, err := parseStatusSet([]string{"404", "410-429"})
+	require.NoError(t, err)
+	src := &urlSource{client: srv.Client(), urls: []string{srv.URL + "/gone"},
+		shard: shard{index: 1, total: 1}, accepted: accepted}
+	payloads, metas := drain(t, src)
+	require.Equal(t, []string{`{"first_name": "a"}`}, payloads)
+	require.Equal(t, http.StatusGone, metas[0].StatusCode)
+
+	src = &urlSource{client: srv.Client(), urls: []string{srv.URL + "/error"},
+		shard: shard{index: 1, total: 1}, accepted: accepted}
+	_, _, err = src.Next()
+	require.EqualError(t, err, "get failed: status 500 Internal Server Error")
+
+	for _, f := range []string{"4xx", "429-410", "99", "600", "-"} {
+		_, err := parseStatusSet([]string{f})
+		require.Error(t, err, f)
+	}
+}
+
+func TestFileSource(t *testing.T) {
+	dir, err := ioutil.TempDir("", "source")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	a := filepath.Join(dir, "a.json")
+	require.NoError(t, ioutil.WriteFile(a, []byte(`{"id": 1}`), 0644))
+
+	src := &fileSource{paths: []string{a, filepath.Join(dir, "missing.json")}}
+	body, m, err := src.Next()
+	require.NoError(t, err)
+	data, _ := ioutil.ReadAll(body)
+	body.Close()
+	require.Equal(t, `{"id": 1}`, string(data))
+	require.Equal(t, Metadata{Index: 0, URL: a, ContentType: "application/json"}, m)
+
+	_, m, err = src.Next()
+	require.Error(t, err)
+	require.Equal(t, 1, m.Index)
+	_, _, err = src.Next()
+	require.Equal(t, io.EOF, err)
+}
+
+func TestStdinSource(t *testing.T) {
+	payloads, metas := drain(t, &stdinSource{r: strings.NewReader(`{"id": 1}`)})
+	require.Equal(t, []string{`{"id": 1}`}, payloads)
+	require.Equal(t, "-", metas[0].URL)
+}
+
+func TestQueueSource(t *testing.T) {
+	ch := make(chan []byte, 2)
+	ch <- []byte(`{"id": 1}`)
+	ch <- []byte(`{"id": 2}`)
+	close(ch)
+	payloads, metas := drain(t, &queueSource{ch: ch})
+	require.Equal(t, []string{`{"id": 1}`, `{"id": 2}`}, payloads)
+	require.Equal(t, "queue:1", metas[1].URL)
+}
+
+func TestFindFiles(t *testing.T) {
+	dir, err := ioutil.TempDir("", "findfiles")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	for _, name := range []string{"b.json", "a/c.json", "a/d.txt", "a/e/f.json"} {
+		p := filepath.Join(dir, filepath.FromSlash(name))
+		require.NoError(t, os.MkdirAll(filepath.Dir(p), 0700))
+		require.NoError(t, ioutil.WriteFile(p, []byte("{}"), 0644))
+	}
+	paths, err := findFiles(dir, "*.json")
+	require.NoError(t, err)
+	require.Equal(t, []string{
+		filepath.Join(dir, "a", "c.json"),
+		filepath.Join(dir, "a", "e", "f.json"),
+		filepath.Join(dir, "b.json"),
+	}, paths)
+
+	_, err = findFiles(dir, "[")
+	require.Error(t, err)
+}
@@ -0,0 +1,190 @@
+package jsontoxml
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"regexp"
+
+	"github.com/pkg/errors"
+)
+
+// undLanguage is the language of the records without a valid language, the
+// undetermined language of BCP 47.
+const undLanguage = "und"
+
+// languageExpr matches the BCP 47 like tags, e.g. en, de-CH or zh_Hant.
+var languageExpr = regexp.MustCompile(`^[A-Za-z]{2,8}([-_][A-Za-z0-9]{1,8})*$`)
+
+var splitLanguageField string
+
+func init() {
+	rootCmd.PersistentFlags().StringVar(&splitLanguageField, "split-language-field", "",
+		"Json key holding the language of the records, e.g. lang. The records of every"+
+			" document are split by language into one document per language, under a"+
+			" directory named after it, e.g. en/0.xml and de/0.xml.")
+}
+
+// checkSplitLanguage validates --split-language-field. The documents of a
+// language need their own name.
+func checkSplitLanguage(field, output string) error {
+	if len(field) == 0 {
+		return nil
+	}
+	if fragments {
+		return errors.New("--split-language-field can't be used with --fragments")
+	}
+	if output == "-" || len(singleFile) > 0 {
+		return errors.New("--split-language-field can't be used with --output - or --single-file")
+	}
+	return nil
+}
+
+// splitSink writes a payload as several documents, to sinks chosen as the
+// records are converted. They are committed as they are written: aborting
+// the payload discards the document being written only.
+type splitSink struct {
+	name string
+	// cur is the sink of the document being written, if any.
+	cur Sink
+}
+
+func (s *splitSink) Open(name string) error {
+	s.name = name
+	return nil
+}
+
+// start commits the document being written, if any, and starts the document
+// name of sink.
+func (s *splitSink) start(sink Sink, name string) error {
+	if err := s.Commit(); err != nil {
+		return err
+	}
+	if err := sink.Open(name); err != nil {
+		return err
+	}
+	s.cur = sink
+	return nil
+}
+
+func (s *splitSink) Write(p []byte) (int, error) {
+	if s.cur == nil {
+		return 0, errors.New("write outside of a document")
+	}
+	return s.cur.Write(p)
+}
+
+func (s *splitSink) Commit() error {
+	if s.cur == nil {
+		return nil
+	}
+	cur := s.cur
+	s.cur = nil
+	return cur.Commit()
+}
+
+func (s *splitSink) Abort() error {
+	if s.cur == nil {
+		return nil
+	}
+	cur := s.cur
+	s.cur = nil
+	return cur.Abort()
+}
+
+// languageSink writes the records of a payload to one document of sink per
+// language, named after the language and the document, e.g. en/0.xml.
+type languageSink struct {
+	splitSink
+	sink  Sink
+	field string
+}
+
+// next starts the document of lang.
+func (s *languageSink) next(lang string) error {
+	return s.start(s.sink, lang+"/"+s.name)
+}
+
+// recordLanguage returns the language of the json record: the string held by
+// field, or undLanguage if it has none. ok is false if the value isn't a
+// language tag.
+func recordLanguage(record json.RawMessage, field string) (lang string, ok bool) {
+	var obj map[string]json.RawMessage
+	if err := json.Unmarshal(record, &obj); err != nil || obj[field] == nil {
+		return undLanguage, true
+	}
+	if err := json.Unmarshal(obj[field], &lang); err != nil || !languageExpr.MatchString(lang) {
+		return undLanguage, string(obj[field]) == "null"
+	}
+	return lang, true
+}
+
+// readRecords reads the records of the json document from r: the entries of
+// an array, or the whole document.
+func readRecords(r io.Reader) (records []json.RawMessage, array bool, err error) {
+	br := bufio.NewReader(r)
+	if array, err = peekArray(br); err != nil {
+		return nil, false, errors.Wrap(err, "read")
+	}
+	data, err := ioutil.ReadAll(br)
+	if err != nil {
+		return nil, false, errors.Wrap(err, "read")
+	}
+	if !array {
+		return []json.RawMessage{data}, false, nil
+	}
+	if err := json.Unmarshal(data, &records); err != nil {
+		return nil, false, errors.Wrap(err, "json.Unmarshal")
+	}
+	return records, true, nil
+}
+
+// convertRecords converts the records, read by readRecords, to w like a whole
+// payload: in an array if they were read from one.
+func convertRecords(records []json.RawMessage, array bool, w io.Writer,
+	opts *convertOptions) error {
+	doc := records[0]
+	if array {
+		var err error
+		if doc, err = json.Marshal(records); err != nil {
+			return errors.Wrap(err, "json.Marshal")
+		}
+	}
+	opts.stats.addInput(len(doc))
+	return convertStream(bytes.NewReader(doc), w, opts, false)
+}
+
+// convertLanguages converts the records of the json document read from r to
+// one document of s per language, in the order the languages are first seen.
+func convertLanguages(r io.Reader, s *languageSink, opts *convertOptions) error {
+	if opts == nil {
+		opts = &convertOptions{}
+	}
+	records, array, err := readRecords(r)
+	if err != nil {
+		return err
+	}
+	var langs []string
+	groups := make(map[string][]json.RawMessage)
+	for _, record := range records {
+		lang, ok := recordLanguage(record, s.field)
+		if !ok {
+			opts.warn("record with an invalid %s written to %s", s.field, undLanguage)
+		}
+		if _, seen := groups[lang]; !seen {
+			langs = append(langs, lang)
+		}
+		groups[lang] = append(groups[lang], record)
+	}
+	for _, lang := range langs {
+		if err := s.next(lang); err != nil {
+			return err
+		}
+		if err := convertRecords(groups[lang], array, s, opts); err != nil {
+			return errors.Wrapf(err, "language %s", lang)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,54 @@
+package jsontoxml
+
+import (
+	"context"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestRecordLanguage(t *testing.T) {
+	for record, want := range map[string]string{
+		`{"lang": "de-CH"}`: "de-CH",
+		`{"lang": null}`:    undLanguage,
+		`{"id": 1}`:         undLanguage,
+		`{"lang": "../x"}`:  undLanguage,
+		`{"lang": 7}`:       undLanguage,
+	} {
+		lang, _ := recordLanguage([]byte(record), "lang")
+		require.Equal(t, want, lang, record)
+	}
+	_, ok := recordLanguage([]byte(`{"lang": "../x"}`), "lang")
+	require.False(t, ok)
+}
+
+func TestRunnerSplitLanguage(t *testing.T) {
+	dir := t.TempDir()
+	src := filepath.Join(dir, "in.json")
+	payload := `[{"id": 1, "lang": "en"}, {"id": 2, "lang": "de"}, {"id": 3, "lang": "en"},` +
+		` {"id": 4}]`
+	require.NoError(t, ioutil.WriteFile(src, []byte(payload), 0600))
+	out := filepath.Join(dir, "out")
+	r := &Runner{
+		NewSink:            func() Sink { return &fileSink{dir: out} },
+		SplitLanguageField: "lang",
+		Stats:              StatsSidecar,
+	}
+	r.opts = &convertOptions{generic: true, layout: &xmlLayout{compact: true}}
+	r.Add(&fileSource{paths: []string{src}})
+	rep, err := r.Run(context.Background())
+	require.NoError(t, err)
+	require.Zero(t, rep.Failed)
+	read := func(name string) string {
+		data, err := ioutil.ReadFile(filepath.Join(out, name))
+		require.NoError(t, err)
+		return string(data)
+	}
+	require.Equal(t, `<jsonData><item><id>1</id><lang>en</lang></item>`+
+		`<item><id>3</id><lang>en</lang></item></jsonData>`, read("en/0.xml"))
+	require.Contains(t, read("de/0.xml"), `<id>2</id>`)
+	require.Contains(t, read("und/0.xml"), `<id>4</id>`)
+	require.Contains(t, read("en/0.xml.stats.json"), `"records":2`)
+}
@@ -0,0 +1,192 @@
+package jsontoxml
+
+import (
+	"encoding/json"
+	"io"
+
+	"github.com/pkg/errors"
+)
+
+// StatsMode decides how the statistics of the documents are published, so
+// that consumers can check that a document is complete.
+type StatsMode string
+
+const (
+	// StatsNone publishes no statistics. It is the default.
+	StatsNone StatsMode = ""
+	// StatsSidecar writes the statistics of every document next to it, as
+	// json, under its name with a .stats.json suffix.
+	StatsSidecar StatsMode = "sidecar"
+	// StatsComment appends the statistics to every document, in a comment
+	// following its root element.
+	StatsComment StatsMode = "comment"
+)
+
+// statsSuffix is appended to the name of the documents for the files of
+// StatsSidecar.
+const statsSuffix = ".stats.json"
+
+var (
+	statsFlag string
+	// statsMode is set from --stats.
+	statsMode StatsMode
+)
+
+func init() {
+	rootCmd.PersistentFlags().StringVar(&statsFlag, "stats", "",
+		"Publish the statistics of every document (records, json and xml bytes, warnings):"+
+			" sidecar (a .stats.json file next to it) or comment (a comment at its end). None if"+
+			" empty.")
+}
+
+// parseStatsMode validates the --stats flag. The documents written to a
+// stream have no name for a sidecar.
+func parseStatsMode(s, output string) (StatsMode, error) {
+	switch m := StatsMode(s); m {
+	case StatsNone, StatsComment:
+		return m, nil
+	case StatsSidecar:
+		if output == "-" || len(singleFile) > 0 {
+			return "", errors.New("--stats sidecar can't be used with --output - or --single-file")
+		}
+		return m, nil
+	}
+	return "", errors.Errorf("unknown --stats %q. Expected sidecar or comment", s)
+}
+
+// docStats are the statistics of a document.
+type docStats struct {
+	// Records is the number of records of the document: the entries of the
+	// json array, or 1.
+	Records int `json:"records"`
+	// InputBytes is the size of the json the document was converted from, and
+	// OutputBytes the size of the xml, without the statistics.
+	InputBytes  int64 `json:"input_bytes"`
+	OutputBytes int64 `json:"output_bytes"`
+	// Warnings is the number of warnings of the conversion, see docWarnings.
+	Warnings int `json:"warnings"`
+}
+
+// sub returns the statistics gathered since o.
+func (s docStats) sub(o docStats) docStats {
+	return docStats{
+		Records:     s.Records - o.Records,
+		InputBytes:  s.InputBytes - o.InputBytes,
+		OutputBytes: s.OutputBytes - o.OutputBytes,
+		Warnings:    s.Warnings - o.Warnings,
+	}
+}
+
+// addRecords counts the records of a document. s may be nil.
+func (s *docStats) addRecords(n int) {
+	if s != nil {
+		s.Records += n
+	}
+}
+
+// addInput counts the json bytes of a document. s may be nil.
+func (s *docStats) addInput(n int) {
+	if s != nil {
+		s.InputBytes += int64(n)
+	}
+}
+
+// addWarning counts a warning of a document. s may be nil.
+func (s *docStats) addWarning() {
+	if s != nil {
+		s.Warnings++
+	}
+}
+
+// countRecords returns the number of records of the json document: the
+// number of entries of an array, or 1.
+func countRecords(data []byte) int {
+	if !isJSONArray(data) {
+		return 1
+	}
+	var entries []json.RawMessage
+	if err := json.Unmarshal(data, &entries); err != nil {
+		return 0
+	}
+	return len(entries)
+}
+
+// statsReader counts the bytes read from r in stats.
+type statsReader struct {
+	r     io.Reader
+	stats *docStats
+}
+
+func (r statsReader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	r.stats.addInput(n)
+	return n, err
+}
+
+// statsSink publishes the statistics of the documents written to sink. The
+// converter gathers the records and the json bytes of the payload in stats,
+// the sink the xml bytes: a document committed gets the statistics gathered
+// since it was opened, so that the records of --fragments get their own.
+type statsSink struct {
+	sink  Sink
+	mode  StatsMode
+	stats docStats
+	name  string
+	// start is the value of stats when the document was opened.
+	start docStats
+}
+
+func (s *statsSink) Open(name string) error {
+	s.name, s.start = name, s.stats
+	return s.sink.Open(name)
+}
+
+func (s *statsSink) Write(p []byte) (int, error) {
+	n, err := s.sink.Write(p)
+	s.stats.OutputBytes += int64(n)
+	return n, err
+}
+
+func (s *statsSink) Commit() error {
+	data, err := json.Marshal(s.stats.sub(s.start))
+	if err != nil {
+		s.sink.Abort()
+		return err
+	}
+	if s.mode == StatsComment {
+		// The json of the numbers can't hold --, which ends a comment.
+		if _, err := s.sink.Write([]byte("\n<!--stats " + string(data) + "-->")); err != nil {
+			s.sink.Abort()
+			return errors.Wrap(err, "stats")
+		}
+		return s.sink.Commit()
+	}
+	if err := s.sink.Commit(); err != nil {
+		return err
+	}
+	if err := s.sink.Open(s.name + statsSuffix); err != nil {
+		return errors.Wrap(err, "stats")
+	}
+	if _, err := s.sink.Write(append(data, '\n')); err != nil {
+		s.sink.Abort()
+		return errors.Wrap(err, "stats")
+	}
+	return errors.Wrap(s.sink.Commit(), "stats")
+}
+
+func (s *statsSink) Abort() error {
+	return s.sink.Abort()
+}
+
+func (s *statsSink) Exists(name string) bool {
+	es, ok := s.sink.(ExistingSink)
+	return ok && es.Exists(name)
+}
+
+// KeepPartial keeps the partial document, if sink can, without statistics.
+func (s *statsSink) KeepPartial() error {
+	if ps, ok := s.sink.(PartialSink); ok {
+		return ps.KeepPartial()
+	}
+	return s.sink.Abort()
+}
@@ -0,0 +1,70 @@
+package jsontoxml
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseStatsMode(t *testing.T) {
+	m, err := parseStatsMode("comment", "-")
+	require.NoError(t, err)
+	require.Equal(t, StatsComment, m)
+	m, err = parseStatsMode("sidecar", "./out")
+	require.NoError(t, err)
+	require.Equal(t, StatsSidecar, m)
+	_, err = parseStatsMode("sidecar", "-")
+	require.Error(t, err)
+	_, err = parseStatsMode("xml", "./out")
+	require.Error(t, err)
+}
+
+func TestRunnerStats(t *testing.T) {
+	dir := t.TempDir()
+	payload := `[{"id": 1}, {"id": 2}, {"id": 3}]`
+	src := filepath.Join(dir, "in.json")
+	require.NoError(t, ioutil.WriteFile(src, []byte(payload), 0600))
+	run := func(mode StatsMode, fragments bool) string {
+		out := filepath.Join(dir, string(mode))
+		r := &Runner{
+			NewSink:   func() Sink { return &fileSink{dir: out} },
+			Stats:     mode,
+			Fragments: fragments,
+		}
+		r.Add(&fileSource{paths: []string{src}})
+		rep, err := r.Run(context.Background())
+		require.NoError(t, err)
+		require.Zero(t, rep.Failed)
+		return out
+	}
+	readStats := func(p string) docStats {
+		data, err := ioutil.ReadFile(p)
+		require.NoError(t, err)
+		var s docStats
+		require.NoError(t, json.Unmarshal(data, &s))
+		return s
+	}
+
+	out := run(StatsSidecar, false)
+	doc, err := os.Stat(filepath.Join(out, "0.xml"))
+	require.NoError(t, err)
+	require.Equal(t, docStats{Records: 3, InputBytes: int64(len(payload)),
+		OutputBytes: doc.Size()}, readStats(filepath.Join(out, "0.xml.stats.json")))
+
+	out = run(StatsComment, false)
+	data, err := ioutil.ReadFile(filepath.Join(out, "0.xml"))
+	require.NoError(t, err)
+	require.Regexp(t, `</records>\n<!--stats \{"records":3,"input_bytes":33,"output_bytes":\d+,"warnings":0\}-->$`,
+		string(data))
+
+	out = run(StatsSidecar, true)
+	fragment, err := os.Stat(filepath.Join(out, "0-2.xml"))
+	require.NoError(t, err)
+	require.Equal(t, docStats{Records: 1, InputBytes: int64(len(`{"id": 3}`)),
+		OutputBytes: fragment.Size()}, readStats(filepath.Join(out, "0-2.xml.stats.json")))
+}
@@ -0,0 +1,258 @@
+package jsontoxml
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"io"
+	"io/ioutil"
+	"reflect"
+
+	"github.com/pkg/errors"
+)
+
+// convertStream converts the json document read from r to xml and writes it to
+// w. Top-level arrays are converted one entry at a time, so memory use is
+// bounded by the largest entry rather than by the size of the document. Other
+// documents are read in full and converted with convert. opts may be nil.
+//
+// The output is the same as the one of convert, but w may have received part of
+// it when an error is returned. If finalize is set and reading r fails because
+// the conversion was canceled, the array is closed after the entries converted
+// so far and an error caused by errTruncated is returned.
+func convertStream(r io.Reader, w io.Writer, opts *convertOptions, finalize bool) error {
+	if opts == nil {
+		opts = &convertOptions{}
+	}
+	br := bufio.NewReader(r)
+	array, err := peekArray(br)
+	if err != nil {
+		return errors.Wrap(err, "read")
+	}
+	generic := opts.generic || opts.lossless
+	// The id of a generic document is stored on its root element, which is
+	// written before the entries, so it can't be streamed. Neither can the
+	// presets, schemas and mappings, nor the documents transformed by --xslt
+	// or validated by --xsd.
+	if !array || generic && opts.idGen != nil || len(opts.preset) > 0 || opts.schema != nil ||
+		opts.mapping != nil || opts.xslt != nil || opts.xsd != nil {
+		data, err := ioutil.ReadAll(br)
+		if err != nil {
+			return errors.Wrap(err, "read")
+		}
+		return jsonToXml(data, w, opts)
+	}
+
+	root := opts.arrayRootName()
+	if generic {
+		root = opts.rootElementName()
+	}
+	w = opts.layout.writer(w)
+	if opts.selfClosing {
+		w = &selfClosingWriter{w: w}
+	}
+	s := &xmlStream{w: w, opts: opts, finalize: finalize}
+	if opts.declaration {
+		if _, err := io.WriteString(w, xml.Header); err != nil {
+			return errors.Wrap(err, "write")
+		}
+	}
+	if doctype := opts.entities.doctype(root); len(doctype) > 0 {
+		s.substitute = true
+		if _, err := io.WriteString(w, doctype); err != nil {
+			return errors.Wrap(err, "write")
+		}
+	}
+	dec := json.NewDecoder(br)
+	dec.UseNumber()
+	if _, err := dec.Token(); err != nil {
+		return errors.Wrap(err, "json.Unmarshal")
+	}
+	if generic {
+		err = s.genericArray(dec)
+	} else {
+		err = s.structArray(dec, root)
+	}
+	if err != nil {
+		return err
+	}
+	// Only whitespace may follow the array.
+	if _, err := dec.Token(); err != io.EOF {
+		return errors.New("json.Unmarshal: invalid data after top-level value")
+	}
+	return nil
+}
+
+// peekArray returns true if the json document starts with an array.
+func peekArray(br *bufio.Reader) (bool, error) {
+	for {
+		b, err := br.Peek(1)
+		if err == io.EOF {
+			return false, nil
+		}
+		if err != nil {
+			return false, err
+		}
+		switch b[0] {
+		case ' ', '\t', '\r', '\n':
+			br.ReadByte()
+		default:
+			return b[0] == '[', nil
+		}
+	}
+}
+
+// xmlStream writes the xml of a document piece by piece.
+type xmlStream struct {
+	w    io.Writer
+	opts *convertOptions
+	// substitute is set if the entities are substituted in the output.
+	substitute bool
+	// finalize is set if the document is completed when the conversion is
+	// canceled.
+	finalize bool
+}
+
+// stop handles the error returned when reading the array. If the conversion
+// was canceled and is finalized, end is called to complete the document.
+func (s *xmlStream) stop(err error, end func() error) error {
+	if !s.finalize || !isCanceled(err) {
+		return errors.Wrap(err, "json.Unmarshal")
+	}
+	if err := end(); err != nil {
+		return err
+	}
+	return errors.Wrap(errTruncated, err.Error())
+}
+
+func (s *xmlStream) write(data []byte) error {
+	if s.substitute {
+		data = s.opts.entities.substitute(data)
+	}
+	_, err := s.w.Write(data)
+	return errors.Wrap(err, "write")
+}
+
+// structArray converts the entries of the json array to jsonData records,
+// wrapped in the root element. The opening bracket has been read from dec.
+func (s *xmlStream) structArray(dec *json.Decoder, root string) error {
+	var buf bytes.Buffer
+	enc := xml.NewEncoder(&buf)
+	enc.Indent(" ", " ")
+	start := xml.StartElement{Name: xml.Name{Local: root}}
+	if s.opts.xsiTypes {
+		start.Attr = xsiNamespaceAttrs
+	}
+	if err := enc.EncodeToken(start); err != nil {
+		return errors.Wrap(err, "xml.Marshal")
+	}
+	if err := enc.Flush(); err != nil {
+		return errors.Wrap(err, "xml.Marshal")
+	}
+	if err := s.write(buf.Bytes()); err != nil {
+		return err
+	}
+	types := structXSITypes(reflect.TypeOf(jsonData{}))
+	i := 0
+	end := func() error {
+		if i == 0 {
+			return s.write([]byte("</" + root + ">"))
+		}
+		return s.write([]byte("\n </" + root + ">"))
+	}
+	for ; dec.More(); i++ {
+		var e json.RawMessage
+		if err := dec.Decode(&e); err != nil {
+			return s.stop(err, end)
+		}
+		p, err := s.opts.decodeRecord(e)
+		if err != nil {
+			return errors.Wrapf(err, "entry %d", i)
+		}
+		rec, err := s.opts.newRecord(p)
+		if err != nil {
+			return err
+		}
+		data, err := xml.MarshalIndent(rec, "  ", " ")
+		if err != nil {
+			return errors.Wrap(err, "xml.Marshal")
+		}
+		if s.opts.xsiTypes {
+			if data, err = addXSITypes(data, types, 1, false); err != nil {
+				return err
+			}
+		}
+		if err := s.write(append([]byte("\n"), data...)); err != nil {
+			return err
+		}
+		s.opts.stats.addRecords(1)
+	}
+	if _, err := dec.Token(); err != nil {
+		return s.stop(err, end)
+	}
+	return end()
+}
+
+// genericArray converts the entries of the json array to <item> elements of
+// the root element. The opening bracket has been read from dec.
+func (s *xmlStream) genericArray(dec *json.Decoder) error {
+	var buf bytes.Buffer
+	enc := xml.NewEncoder(&buf)
+	enc.Indent(" ", " ")
+	// flush writes what has been encoded so far.
+	flush := func() error {
+		if err := enc.Flush(); err != nil {
+			return errors.Wrap(err, "xml.Marshal")
+		}
+		err := s.write(buf.Bytes())
+		buf.Reset()
+		return err
+	}
+
+	r := &record{XMLName: xml.Name{Local: s.opts.rootElementName()}}
+	// Without ids, the value isn't used by decorate.
+	if err := s.opts.decorate(r, nil); err != nil {
+		return err
+	}
+	if s.opts.xsiTypes {
+		r.Attrs = append(r.Attrs, xsiNamespaceAttrs...)
+	}
+	start := s.opts.annotate(xml.StartElement{Name: r.XMLName, Attr: r.Attrs}, []interface{}{})
+	if err := enc.EncodeToken(start); err != nil {
+		return errors.Wrap(err, "xml.Marshal")
+	}
+	end := func() error {
+		for _, e := range r.Extra {
+			if err := enc.EncodeElement(e, xml.StartElement{Name: e.XMLName}); err != nil {
+				return errors.Wrap(err, "xml.Marshal")
+			}
+		}
+		if err := enc.EncodeToken(start.End()); err != nil {
+			return errors.Wrap(err, "xml.Marshal")
+		}
+		return flush()
+	}
+	for dec.More() {
+		var v interface{}
+		if err := dec.Decode(&v); err != nil {
+			return s.stop(err, end)
+		}
+		if s.opts.coerce {
+			v = coerceValue(v)
+		}
+		v = s.opts.whitespace.value("", s.opts.html.value(v))
+		item := xml.StartElement{Name: xml.Name{Local: itemName}}
+		if err := s.opts.encodeValue(enc, item, v, nil); err != nil {
+			return errors.Wrap(err, "xml.Marshal")
+		}
+		if err := flush(); err != nil {
+			return err
+		}
+		s.opts.stats.addRecords(1)
+	}
+	if _, err := dec.Token(); err != nil {
+		return s.stop(err, end)
+	}
+	return end()
+}
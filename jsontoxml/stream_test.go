@@ -0,0 +1,64 @@
+package jsontoxml
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestConvertStream(t *testing.T) {
+	entities, err := newEntityOptions([]string{"co=ACME"}, nil, nil)
+	require.NoError(t, err)
+	runTime := time.Date(2021, 3, 1, 10, 0, 0, 0, time.UTC)
+	options := map[string]*convertOptions{
+		"default":  nil,
+		"root":     {arrayRoot: "people"},
+		"element":  {rootElement: "person"},
+		"gelement": {generic: true, rootElement: "doc"},
+		"layout":   {layout: &xmlLayout{prefix: "\t", indent: "  "}},
+		"compact":  {generic: true, layout: &xmlLayout{compact: true}},
+		"decl":     {declaration: true, entities: entities},
+		"xsi":      {xsiTypes: true},
+		"entities": {entities: entities},
+		"time":     {runTimeField: "run", runTime: runTime},
+		"coerce":   {coerce: true},
+		"generic":  {generic: true, runTimeField: "run", runTime: runTime},
+		"lossless": {lossless: true, xsiTypes: true},
+	}
+	docs := []string{
+		`[{"id": 1, "first_name": "a"}, {"id": "2", "last_name": "{{co}}"}]`,
+		` [ ]`,
+		`[{"id": 1}]`,
+		`{"id": 1, "first_name": "a"}`,
+		`[[1, 2.5], {"a": true, "b": null}, "x"]`,
+	}
+	for name, opts := range options {
+		for _, doc := range docs {
+			want, wantErr := convert([]byte(doc), opts)
+			var got bytes.Buffer
+			err := convertStream(strings.NewReader(doc), &got, opts, false)
+			if wantErr != nil {
+				require.Error(t, err, "%s: %s", name, doc)
+				continue
+			}
+			require.NoError(t, err, "%s: %s", name, doc)
+			require.Equal(t, string(want), got.String(), "%s: %s", name, doc)
+		}
+	}
+}
+
+func TestConvertStreamErrors(t *testing.T) {
+	for _, doc := range []string{
+		`[{"id": 1}, {"foo": "bar"}]`,
+		`[{"id": 1}, `,
+		`[{"id": 1}] [`,
+		``,
+	} {
+		require.Error(t, convertStream(strings.NewReader(doc), new(bytes.Buffer), nil, false), doc)
+	}
+	err := convertStream(strings.NewReader(`[{"id": 1}, {"foo": "bar"}]`), new(bytes.Buffer), nil, false)
+	require.Contains(t, err.Error(), "entry 1")
+}
@@ -0,0 +1,57 @@
+package jsontoxml
+
+import (
+	"encoding/json"
+	"io"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+var (
+	streamInput bool
+	windowSize  int
+	windowEvery time.Duration
+)
+
+func init() {
+	rootCmd.Flags().BoolVar(&streamInput, "stream", false,
+		"Convert the json documents streamed to stdin, e.g. one per line, each to a document of"+
+			" --output, until stdin is closed.")
+	rootCmd.Flags().IntVar(&windowSize, "window-size", 0,
+		"With --stream, convert the records of the documents in windows of that many records"+
+			" instead, e.g. 10000. No limit if 0.")
+	rootCmd.Flags().DurationVar(&windowEvery, "window-every", 0,
+		"With --stream, convert the records of the documents in windows closing at that"+
+			" interval instead, e.g. 5m. No limit if 0.")
+}
+
+// newStreamSource returns the source of the payloads of ch: every payload,
+// or the windows of their records if size or every is positive.
+func newStreamSource(ch <-chan []byte, size int, every time.Duration) (Source, error) {
+	if size < 0 || every < 0 {
+		return nil, errors.New("--window-size and --window-every can't be negative")
+	}
+	if size == 0 && every == 0 {
+		return NewQueueSource(ch), nil
+	}
+	return NewWindowSource(ch, size, every)
+}
+
+// readStream pushes the json documents of r to ch, then closes it. The
+// documents can be separated by whitespace, as in NDJSON, or not at all.
+func readStream(r io.Reader, ch chan<- []byte) error {
+	defer close(ch)
+	dec := json.NewDecoder(r)
+	for {
+		var doc json.RawMessage
+		err := dec.Decode(&doc)
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return errors.Wrap(err, "read stream")
+		}
+		ch <- doc
+	}
+}
@@ -0,0 +1,44 @@
+package jsontoxml
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestStreamInput(t *testing.T) {
+	const stream = "{\"id\": 1}\n[{\"id\": 2}, {\"id\": 3}]\n{\"id\":4}{\"id\":5}\n"
+	for _, tc := range []struct {
+		size  int
+		every time.Duration
+		want  []string
+	}{
+		{0, 0, []string{`{"id": 1}`, `[{"id": 2}, {"id": 3}]`, `{"id":4}`, `{"id":5}`}},
+		{3, 0, []string{`[{"id":1},{"id":2},{"id":3}]`, `[{"id":4},{"id":5}]`}},
+		{0, time.Hour, []string{`[{"id":1},{"id":2},{"id":3},{"id":4},{"id":5}]`}},
+	} {
+		ch := make(chan []byte)
+		src, err := newStreamSource(ch, tc.size, tc.every)
+		require.NoError(t, err)
+		errc := make(chan error, 1)
+		go func() { errc <- readStream(strings.NewReader(stream), ch) }()
+		payloads, _ := drain(t, src)
+		require.Equal(t, tc.want, payloads)
+		require.NoError(t, <-errc)
+	}
+
+	_, err := newStreamSource(make(chan []byte), -1, 0)
+	require.Error(t, err)
+
+	// The documents read before an invalid one are still converted.
+	ch := make(chan []byte, 2)
+	require.EqualError(t, readStream(strings.NewReader(`{"id": 1} {"id": `), ch),
+		"read stream: unexpected EOF")
+	var docs []string
+	for doc := range ch {
+		docs = append(docs, string(doc))
+	}
+	require.Equal(t, []string{`{"id": 1}`}, docs)
+}
@@ -0,0 +1,287 @@
+package jsontoxml
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+var (
+	tenantHeader  string
+	maxTenants    int
+	tenantRate    float64
+	tenantMaxJobs int
+)
+
+func init() {
+	serveCmd.Flags().StringVar(&tenantHeader, "tenant-header", "",
+		"Header naming the tenant of the requests, e.g. X-Tenant, for servers behind an"+
+			" authenticating proxy. With --api-keys, the tenants are those of the keys instead.")
+	serveCmd.Flags().IntVar(&maxTenants, "max-tenants", 1000,
+		"Most tenants named by --tenant-header known at once. The requests of new tenants"+
+			" are rejected with a 503 until the idle ones are forgotten, every --recycle-every.")
+	serveCmd.Flags().Float64Var(&tenantRate, "tenant-rate", 0,
+		"Requests per second allowed to every tenant named by --tenant-header. No limit if 0.")
+	serveCmd.Flags().IntVar(&tenantMaxJobs, "tenant-max-jobs", 0,
+		"Jobs in progress allowed to every tenant named by --tenant-header. No limit if 0.")
+}
+
+// tenant is a user of a shared server. The jobs of a tenant are only visible
+// to it, and written to its own directory.
+type tenant struct {
+	name string
+	// quota limits the requests of all the keys of the tenant. No limit if
+	// nil.
+	quota *tokenBucket
+	// maxJobs limits the jobs of the tenant in progress. No limit if 0.
+	maxJobs int
+	// active is true if the tenant made requests since the last expire. It is
+	// guarded by the mutex of its tenantSet.
+	active bool
+	usage
+}
+
+// sameTenant returns true if a and b are the same tenant, or both nil. The
+// tenants are compared by name, as a tenant forgotten by expire is added
+// again by its next request.
+func sameTenant(a, b *tenant) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return a.name == b.name
+}
+
+// validTenant returns true if name can name a tenant, which is also a
+// directory name.
+func validTenant(name string) bool {
+	if len(name) == 0 || len(name) > 64 || name == "." || name == ".." {
+		return false
+	}
+	for _, c := range name {
+		if !('a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || '0' <= c && c <= '9' ||
+			c == '.' || c == '_' || c == '-') {
+			return false
+		}
+	}
+	return true
+}
+
+// tenantSet holds the tenants of the server. It is safe for concurrent use.
+type tenantSet struct {
+	mu      sync.Mutex
+	tenants map[string]*tenant
+	// max bounds the tenants added by the handler, no bound if 0. They are
+	// given the rate and maxJobs limits.
+	max     int
+	rate    float64
+	maxJobs int
+}
+
+func newTenantSet() *tenantSet {
+	return &tenantSet{tenants: make(map[string]*tenant)}
+}
+
+// get returns the tenant name, which is added without limits if it is
+// unknown.
+func (s *tenantSet) get(name string) *tenant {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	t, ok := s.tenants[name]
+	if !ok {
+		t = &tenant{name: name}
+		s.tenants[name] = t
+	}
+	return t
+}
+
+// add returns the tenant name, which is added with the limits of the set if
+// it is unknown. It returns nil if the set has max tenants already.
+func (s *tenantSet) add(name string) *tenant {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	t, ok := s.tenants[name]
+	if !ok {
+		if s.max > 0 && len(s.tenants) >= s.max {
+			return nil
+		}
+		t = &tenant{name: name, maxJobs: s.maxJobs}
+		if s.rate > 0 {
+			t.quota = newTokenBucket(s.rate, time.Now())
+		}
+		s.tenants[name] = t
+	}
+	t.active = true
+	return t
+}
+
+// expire forgets the tenants without requests since the previous expire, with
+// their usage, so that the set doesn't keep every name ever sent.
+func (s *tenantSet) expire() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for name, t := range s.tenants {
+		if !t.active {
+			delete(s.tenants, name)
+		}
+		t.active = false
+	}
+}
+
+// handler serves the requests with the tenant named by their header with h.
+// The requests without a valid tenant are rejected with a 400, those of new
+// tenants with a 503 if the set is full.
+func (s *tenantSet) handler(header string, h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		name := r.Header.Get(header)
+		if !validTenant(name) {
+			http.Error(w, "missing or invalid "+header+" header", http.StatusBadRequest)
+			return
+		}
+		t := s.add(name)
+		if t == nil {
+			http.Error(w, "too many tenants", http.StatusServiceUnavailable)
+			return
+		}
+		if !checkQuota(w, t.quota, &t.usage, "tenant") {
+			return
+		}
+		serveCounted(w, r.WithContext(withTenant(r.Context(), t)), h, &t.usage)
+	})
+}
+
+// ServeHTTP writes the usage of the tenants in the Prometheus text format.
+func (s *tenantSet) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	s.writeMetrics(w)
+}
+
+func (s *tenantSet) writeMetrics(w io.Writer) {
+	s.mu.Lock()
+	names := make([]string, 0, len(s.tenants))
+	series := make(map[string]*usage, len(s.tenants))
+	for name, t := range s.tenants {
+		names = append(names, name)
+		series[name] = &t.usage
+	}
+	s.mu.Unlock()
+	sort.Strings(names)
+	writeUsage(w, "jsontoxml_tenant_", "tenant", "tenant", names, series)
+}
+
+type tenantKey struct{}
+
+func withTenant(ctx context.Context, t *tenant) context.Context {
+	return context.WithValue(ctx, tenantKey{}, t)
+}
+
+// tenantFromContext returns the tenant of a request, or nil if the server has
+// no tenants.
+func tenantFromContext(ctx context.Context) *tenant {
+	t, _ := ctx.Value(tenantKey{}).(*tenant)
+	return t
+}
+
+// usage records the requests of an API key or a tenant.
+type usage struct {
+	mu sync.Mutex
+	// requests counts the requests by status.
+	requests      map[int]int64
+	bytesIn       int64
+	bytesOut      int64
+	quotaExceeded int64
+}
+
+func (u *usage) record(code int, in, out int64) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	if u.requests == nil {
+		u.requests = make(map[int]int64)
+	}
+	u.requests[code]++
+	u.bytesIn += in
+	u.bytesOut += out
+}
+
+// checkQuota returns true if the request is within quota. Otherwise it is
+// rejected with a 429, counted in u, and checkQuota returns false. who names
+// the owner of the quota in the response.
+func checkQuota(w http.ResponseWriter, quota *tokenBucket, u *usage, who string) bool {
+	if quota == nil {
+		return true
+	}
+	d := quota.reserve(time.Now())
+	if d <= 0 {
+		return true
+	}
+	quota.cancel()
+	u.mu.Lock()
+	u.quotaExceeded++
+	u.mu.Unlock()
+	w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(d.Seconds()))))
+	http.Error(w, "rate limit of the "+who+" exceeded", http.StatusTooManyRequests)
+	return false
+}
+
+// serveCounted serves r with h, recording the sizes and the status of the
+// request in every usage.
+func serveCounted(w http.ResponseWriter, r *http.Request, h http.Handler, usages ...*usage) {
+	body := &countingReader{r: r.Body}
+	r.Body = body
+	cw := &countingWriter{ResponseWriter: w, code: http.StatusOK}
+	// Deferred, so that the aborted responses are counted too.
+	defer func() {
+		for _, u := range usages {
+			u.record(cw.code, body.n, cw.n)
+		}
+	}()
+	h.ServeHTTP(cw, r)
+}
+
+// writeUsage writes the usage of every name in the Prometheus text format, the
+// metrics named with prefix and the name in the label. by describes the names
+// in the help of the metrics.
+func writeUsage(w io.Writer, prefix, label, by string, names []string,
+	series map[string]*usage) {
+	fmt.Fprintf(w, "# HELP %srequests_total Requests served, by %s and status.\n", prefix, by)
+	fmt.Fprintf(w, "# TYPE %srequests_total counter\n", prefix)
+	for _, name := range names {
+		u := series[name]
+		u.mu.Lock()
+		codes := make([]int, 0, len(u.requests))
+		for code := range u.requests {
+			codes = append(codes, code)
+		}
+		sort.Ints(codes)
+		for _, code := range codes {
+			fmt.Fprintf(w, "%srequests_total{%s=%q,code=\"%d\"} %d\n", prefix, label, name, code,
+				u.requests[code])
+		}
+		u.mu.Unlock()
+	}
+	for _, m := range []struct {
+		name, help string
+		value      func(u *usage) int64
+	}{
+		{"request_bytes_total", "Bytes of json received, by " + by + ".",
+			func(u *usage) int64 { return u.bytesIn }},
+		{"response_bytes_total", "Bytes of xml sent, by " + by + ".",
+			func(u *usage) int64 { return u.bytesOut }},
+		{"quota_exceeded_total", "Requests rejected by the rate limit of their " + by + ".",
+			func(u *usage) int64 { return u.quotaExceeded }},
+	} {
+		fmt.Fprintf(w, "# HELP %s%s %s\n# TYPE %s%s counter\n", prefix, m.name, m.help,
+			prefix, m.name)
+		for _, name := range names {
+			u := series[name]
+			u.mu.Lock()
+			fmt.Fprintf(w, "%s%s{%s=%q} %d\n", prefix, m.name, label, name, m.value(u))
+			u.mu.Unlock()
+		}
+	}
+}
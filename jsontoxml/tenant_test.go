@@ -0,0 +1,162 @@
+package jsontoxml
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestTenants(t *testing.T) {
+	release := make(chan struct{})
+	source := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/slow" {
+			<-release
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"a": 1}`))
+	}))
+	defer source.Close()
+	defer close(release)
+	dir, err := ioutil.TempDir("", "tenants")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	jobs, err := newJobStore(ctx, dir, &convertOptions{generic: true}, 2, time.Hour)
+	require.NoError(t, err)
+	jobs.newRunner = func() *Runner { return &Runner{Workers: 1, capture: newFailureCapture()} }
+	tenants := newTenantSet()
+	tenants.get("b").maxJobs = 1
+	mux := http.NewServeMux()
+	jobs.register(mux)
+	outer := http.NewServeMux()
+	outer.Handle("/metrics", tenants.handler("X-Tenant", tenants))
+	outer.Handle("/", tenants.handler("X-Tenant", mux))
+	srv := httptest.NewServer(outer)
+	defer srv.Close()
+
+	do := func(method, path, tenant, body string) *http.Response {
+		req, err := http.NewRequest(method, srv.URL+path, strings.NewReader(body))
+		require.NoError(t, err)
+		if len(tenant) > 0 {
+			req.Header.Set("X-Tenant", tenant)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		return resp
+	}
+	submit := func(tenant, url string) (*http.Response, jobStatus) {
+		resp := do(http.MethodPost, "/jobs", tenant, `{"urls": ["`+url+`"]}`)
+		defer resp.Body.Close()
+		var st jobStatus
+		if resp.StatusCode == http.StatusAccepted {
+			require.NoError(t, json.NewDecoder(resp.Body).Decode(&st))
+		}
+		return resp, st
+	}
+
+	resp, a := submit("a", source.URL+"/a")
+	require.Equal(t, http.StatusAccepted, resp.StatusCode)
+	require.Eventually(t, func() bool {
+		resp := do(http.MethodGet, "/jobs/"+a.ID, "a", "")
+		defer resp.Body.Close()
+		var st jobStatus
+		json.NewDecoder(resp.Body).Decode(&st)
+		return st.State == jobDone
+	}, 5*time.Second, 10*time.Millisecond)
+	_, err = os.Stat(filepath.Join(dir, "a", a.ID, "0.xml"))
+	require.NoError(t, err)
+
+	// The jobs of a tenant are hidden from the others.
+	for _, path := range []string{"/jobs/" + a.ID, "/jobs/" + a.ID + "/results"} {
+		resp := do(http.MethodGet, path, "b", "")
+		resp.Body.Close()
+		require.Equal(t, http.StatusNotFound, resp.StatusCode, path)
+	}
+	resp = do(http.MethodGet, "/jobs/"+a.ID+"/results", "a", "")
+	resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+
+	// b can only have a job in progress.
+	resp, _ = submit("b", source.URL+"/slow")
+	require.Equal(t, http.StatusAccepted, resp.StatusCode)
+	resp, _ = submit("b", source.URL+"/a")
+	require.Equal(t, http.StatusTooManyRequests, resp.StatusCode)
+	resp, _ = submit("a", source.URL+"/a")
+	require.Equal(t, http.StatusAccepted, resp.StatusCode)
+
+	for _, tenant := range []string{"", "../a", "a/b"} {
+		resp, _ := submit(tenant, source.URL+"/a")
+		require.Equal(t, http.StatusBadRequest, resp.StatusCode, tenant)
+	}
+
+	resp = do(http.MethodGet, "/metrics", "", "")
+	resp.Body.Close()
+	require.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	resp = do(http.MethodGet, "/metrics", "a", "")
+	metrics, err := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	require.NoError(t, err)
+	for _, line := range []string{
+		`jsontoxml_tenant_requests_total{tenant="a",code="202"} 2`,
+		`jsontoxml_tenant_requests_total{tenant="b",code="202"} 1`,
+		`jsontoxml_tenant_requests_total{tenant="b",code="404"} 2`,
+		`jsontoxml_tenant_requests_total{tenant="b",code="429"} 1`,
+		`jsontoxml_tenant_quota_exceeded_total{tenant="b"} 0`,
+	} {
+		require.Contains(t, string(metrics), line+"\n")
+	}
+}
+
+func TestValidTenant(t *testing.T) {
+	for name, want := range map[string]bool{
+		"team-a": true, "Team_1.eu": true, "": false, ".": false, "..": false, "a/b": false,
+		"a b": false, strings.Repeat("a", 65): false,
+	} {
+		require.Equal(t, want, validTenant(name), name)
+	}
+}
+
+func TestTenantLimits(t *testing.T) {
+	tenants := newTenantSet()
+	tenants.max, tenants.rate, tenants.maxJobs = 2, 1, 3
+	h := tenants.handler("X-Tenant", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	do := func(tenant string) int {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.Header.Set("X-Tenant", tenant)
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		return rec.Code
+	}
+
+	require.Equal(t, http.StatusOK, do("a"))
+	require.Equal(t, http.StatusTooManyRequests, do("a"))
+	require.Equal(t, 3, tenants.get("a").maxJobs)
+	require.Equal(t, http.StatusOK, do("b"))
+	require.Equal(t, http.StatusServiceUnavailable, do("c"))
+
+	// The tenants are forgotten once idle for a whole recycle.
+	tenants.expire()
+	require.Equal(t, http.StatusServiceUnavailable, do("c"))
+	// Rejected over the rate limit, but active.
+	require.Equal(t, http.StatusTooManyRequests, do("a"))
+	tenants.expire()
+	require.Equal(t, http.StatusOK, do("c"))
+	require.Equal(t, http.StatusServiceUnavailable, do("b"))
+
+	// The jobs of a forgotten tenant still belong to it when it comes back.
+	require.True(t, sameTenant(&tenant{name: "a"}, &tenant{name: "a"}))
+	require.False(t, sameTenant(&tenant{name: "a"}, &tenant{name: "b"}))
+	require.False(t, sameTenant(&tenant{name: "a"}, nil))
+	require.True(t, sameTenant(nil, nil))
+}
@@ -0,0 +1,70 @@
+package jsontoxml
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"io/ioutil"
+	"log"
+
+	"github.com/pkg/errors"
+)
+
+var (
+	caCert, clientCert, clientKey string
+	insecure                      bool
+	// tlsConfig is the TLS configuration of fetchClient. The default one if
+	// nil.
+	tlsConfig *tls.Config
+)
+
+func init() {
+	rootCmd.PersistentFlags().StringVar(&caCert, "ca-cert", "",
+		"PEM file of CA certificates trusted in addition to the system ones, e.g. a private CA.")
+	rootCmd.PersistentFlags().StringVar(&clientCert, "client-cert", "",
+		"PEM file of the client certificate presented to the servers (mutual TLS). Requires"+
+			" --client-key.")
+	rootCmd.PersistentFlags().StringVar(&clientKey, "client-key", "",
+		"PEM file of the private key of --client-cert.")
+	rootCmd.PersistentFlags().BoolVar(&insecure, "insecure", false,
+		"Don't verify the certificates of the servers. Only for development: the connections"+
+			" can be intercepted.")
+}
+
+// newTLSConfig builds the TLS configuration from the flags. It returns nil if
+// no flag is set.
+func newTLSConfig() (*tls.Config, error) {
+	if len(caCert) == 0 && len(clientCert) == 0 && len(clientKey) == 0 && !insecure {
+		return nil, nil
+	}
+	cfg := &tls.Config{}
+	if insecure {
+		log.Printf("WARNING: --insecure is set. The certificates of the servers aren't" +
+			" verified, so the connections can be intercepted. Never use it in production.")
+		cfg.InsecureSkipVerify = true
+	}
+	if len(caCert) > 0 {
+		pem, err := ioutil.ReadFile(caCert)
+		if err != nil {
+			return nil, errors.Wrap(err, "read --ca-cert")
+		}
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, errors.Errorf("no certificate found in --ca-cert %q", caCert)
+		}
+		cfg.RootCAs = pool
+	}
+	if len(clientCert) > 0 || len(clientKey) > 0 {
+		if len(clientCert) == 0 || len(clientKey) == 0 {
+			return nil, errors.New("--client-cert and --client-key must be set together")
+		}
+		cert, err := tls.LoadX509KeyPair(clientCert, clientKey)
+		if err != nil {
+			return nil, errors.Wrap(err, "load client certificate")
+		}
+		cfg.Certificates = []tls.Certificate{cert}
+	}
+	return cfg, nil
+}
@@ -0,0 +1,110 @@
+package jsontoxml
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// writePEM writes the block to dir/name and returns its path.
+func writePEM(t *testing.T, dir, name, typ string, der []byte) string {
+	p := filepath.Join(dir, name)
+	require.NoError(t, ioutil.WriteFile(p, pem.EncodeToMemory(&pem.Block{Type: typ, Bytes: der}), 0600))
+	return p
+}
+
+func TestTLSConfig(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tls")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	// Self-signed client certificate.
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "worker"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	require.NoError(t, err)
+	cert, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	require.NoError(t, err)
+
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(r.TLS.PeerCertificates[0].Subject.CommonName))
+	}))
+	clients := x509.NewCertPool()
+	clients.AddCert(cert)
+	srv.TLS = &tls.Config{ClientAuth: tls.RequireAndVerifyClientCert, ClientCAs: clients}
+	srv.StartTLS()
+	defer srv.Close()
+
+	defer func(a, b, c string, cfg *tls.Config) {
+		caCert, clientCert, clientKey, tlsConfig = a, b, c, cfg
+	}(caCert, clientCert, clientKey, tlsConfig)
+	get := func() (string, error) {
+		var err error
+		if tlsConfig, err = newTLSConfig(); err != nil {
+			return "", err
+		}
+		resp, err := newHTTPClient().Get(srv.URL)
+		if err != nil {
+			return "", err
+		}
+		defer resp.Body.Close()
+		body, err := ioutil.ReadAll(resp.Body)
+		return string(body), err
+	}
+
+	// The server certificate isn't trusted.
+	_, err = get()
+	require.Error(t, err)
+	caCert = writePEM(t, dir, "ca.pem", "CERTIFICATE", srv.Certificate().Raw)
+	// The server requires a client certificate.
+	_, err = get()
+	require.Error(t, err)
+	clientCert = writePEM(t, dir, "client.pem", "CERTIFICATE", der)
+	_, err = get()
+	require.Error(t, err, "--client-key is missing")
+	clientKey = writePEM(t, dir, "client.key", "EC PRIVATE KEY", keyDER)
+	name, err := get()
+	require.NoError(t, err)
+	require.Equal(t, "worker", name)
+
+	caCert = clientKey
+	_, err = get()
+	require.Error(t, err)
+}
+
+func TestInsecure(t *testing.T) {
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer srv.Close()
+	defer func(v bool, cfg *tls.Config) { insecure, tlsConfig = v, cfg }(insecure, tlsConfig)
+
+	insecure = true
+	var err error
+	tlsConfig, err = newTLSConfig()
+	require.NoError(t, err)
+	resp, err := newHTTPClient().Get(srv.URL)
+	require.NoError(t, err)
+	resp.Body.Close()
+}
@@ -0,0 +1,82 @@
+package jsontoxml
+
+import (
+	"encoding/xml"
+	"reflect"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// truncatedAttr marks the values cut to --max-field-length. On the element of
+// a value it holds the length of the value in bytes, on a jsonData record the
+// json keys of its truncated fields.
+const truncatedAttr = "truncated"
+
+var maxFieldLength int
+
+func init() {
+	rootCmd.PersistentFlags().IntVar(&maxFieldLength, "max-field-length", 0,
+		"Maximum length, in bytes, of the string values. Longer values are truncated and"+
+			" marked with a truncated attribute. No limit if 0.")
+}
+
+// truncateString returns the first n bytes of s at most, without splitting a
+// character.
+func truncateString(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	for n > 0 && !utf8.RuneStart(s[n]) {
+		n--
+	}
+	return s[:n]
+}
+
+// truncate cuts the string v, held by the element start, to the maximum field
+// length. The element of a truncated value gets the truncated attribute.
+func (o *convertOptions) truncate(start xml.StartElement, v interface{}) (xml.StartElement,
+	interface{}) {
+	s, ok := v.(string)
+	if !ok || o.maxFieldLength <= 0 || len(s) <= o.maxFieldLength {
+		return start, v
+	}
+	start.Attr = append(start.Attr, xml.Attr{Name: xml.Name{Local: truncatedAttr},
+		Value: strconv.Itoa(len(s))})
+	o.warnTruncated(start.Name.Local, len(s))
+	return start, truncateString(s, o.maxFieldLength)
+}
+
+// truncateRecord cuts the string fields of p to the maximum field length. It
+// returns the truncated attribute of the record, listing the json keys of the
+// truncated fields, as the fields are nested in elements it doesn't control.
+func (o *convertOptions) truncateRecord(p *jsonData) (xml.Attr, bool) {
+	if o.maxFieldLength <= 0 {
+		return xml.Attr{}, false
+	}
+	var keys []string
+	v := reflect.ValueOf(p).Elem()
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		f := v.Field(i)
+		if f.Kind() != reflect.String || f.Len() <= o.maxFieldLength {
+			continue
+		}
+		key := strings.Split(t.Field(i).Tag.Get("json"), ",")[0]
+		if len(key) == 0 {
+			key = t.Field(i).Name
+		}
+		o.warnTruncated(key, f.Len())
+		f.SetString(truncateString(f.String(), o.maxFieldLength))
+		keys = append(keys, key)
+	}
+	if len(keys) == 0 {
+		return xml.Attr{}, false
+	}
+	return xml.Attr{Name: xml.Name{Local: truncatedAttr}, Value: strings.Join(keys, " ")}, true
+}
+
+// warnTruncated records that the value of field was truncated.
+func (o *convertOptions) warnTruncated(field string, n int) {
+	o.warn("value of %s truncated from %d to %d bytes", field, n, o.maxFieldLength)
+}
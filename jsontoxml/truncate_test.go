@@ -0,0 +1,32 @@
+package jsontoxml
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestTruncateString(t *testing.T) {
+	require.Equal(t, "abc", truncateString("abc", 5))
+	require.Equal(t, "ab", truncateString("abc", 2))
+	// The characters aren't split.
+	require.Equal(t, "a", truncateString("aéb", 2))
+}
+
+func TestMaxFieldLength(t *testing.T) {
+	warnings := &docWarnings{}
+	opts := &convertOptions{generic: true, maxFieldLength: 4, warnings: warnings,
+		layout: &xmlLayout{compact: true}}
+	out, err := convert([]byte(`{"blob": "aGVsbG8gd29ybGQ=", "n": 123456, "s": "abcd"}`), opts)
+	require.NoError(t, err)
+	require.Equal(t, `<jsonData><blob truncated="16">aGVs</blob><n>123456</n><s>abcd</s></jsonData>`,
+		string(out))
+	require.Equal(t, []string{"value of blob truncated from 16 to 4 bytes"}, warnings.messages())
+
+	opts = &convertOptions{maxFieldLength: 3, layout: &xmlLayout{compact: true}}
+	out, err = convert([]byte(`{"id": 1, "first_name": "Jane", "City": "Oslo", "State": "NO"}`),
+		opts)
+	require.NoError(t, err)
+	require.Equal(t, `<jsonData truncated="first_name City"><Id>1</Id><name><first>Jan</first>`+
+		`<last></last></name><City>Osl</City><State>NO</State></jsonData>`, string(out))
+}
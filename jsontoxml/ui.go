@@ -0,0 +1,97 @@
+package jsontoxml
+
+import (
+	_ "embed"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// uiPage is the web page served at / by the serve subcommand, to convert
+// documents without the command line.
+//
+//go:embed ui.html
+var uiPage []byte
+
+func serveUI(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Write(uiPage)
+}
+
+// conversion is a conversion requested to /convert.
+type conversion struct {
+	opts *convertOptions
+	// url is the url of the json document, if it isn't in the request body.
+	url string
+	// download names the xml document, sent as an attachment, if set.
+	download string
+}
+
+// parseConversion reads the options of a conversion from the query of the
+// request, overriding those of base:
+//
+//	format=struct|generic|lossless  array_root=NAME  root_element=NAME
+//	indent=1|2|4|tab|none  url=URL  download=NAME
+//
+// The host of the url must be allowed by allow.
+func parseConversion(base *convertOptions, allow hostAllowlist, q url.Values) (*conversion,
+	error) {
+	opts := *base
+	c := &conversion{opts: &opts, url: q.Get("url"), download: q.Get("download")}
+	switch q.Get("format") {
+	case "":
+	case "struct":
+		opts.generic, opts.lossless = false, false
+	case "generic":
+		opts.generic, opts.lossless = true, false
+	case "lossless":
+		opts.generic, opts.lossless = true, true
+	default:
+		return nil, errors.Errorf("invalid format %q. Expected struct, generic or lossless",
+			q.Get("format"))
+	}
+	if root := q.Get("array_root"); len(root) > 0 {
+		if _, ok := xmlName(root); !ok {
+			return nil, errors.Errorf("invalid array_root %q", root)
+		}
+		opts.arrayRoot = root
+	}
+	if root := q.Get("root_element"); len(root) > 0 {
+		if _, ok := xmlName(root); !ok {
+			return nil, errors.Errorf("invalid root_element %q", root)
+		}
+		opts.rootElement = root
+	}
+	switch q.Get("indent") {
+	case "":
+	case "1":
+		opts.layout = nil
+	case "2":
+		opts.layout = &xmlLayout{indent: "  "}
+	case "4":
+		opts.layout = &xmlLayout{indent: "    "}
+	case "tab":
+		opts.layout = &xmlLayout{indent: "\t"}
+	case "none":
+		opts.layout = &xmlLayout{compact: true}
+	default:
+		return nil, errors.Errorf("invalid indent %q. Expected 1, 2, 4, tab or none",
+			q.Get("indent"))
+	}
+	if len(c.url) > 0 {
+		u, err := url.Parse(c.url)
+		if err != nil || u.Scheme != "http" && u.Scheme != "https" || len(u.Host) == 0 {
+			return nil, errors.Errorf("invalid url %q", c.url)
+		}
+		if !allow.allows(u.Hostname()) {
+			return nil, errors.Errorf("url %q: host %q isn't allowed by --allow-host", c.url,
+				u.Hostname())
+		}
+	}
+	if strings.ContainsAny(c.download, "\"\\/\r\n") {
+		return nil, errors.Errorf("invalid download name %q", c.download)
+	}
+	return c, nil
+}
@@ -0,0 +1,118 @@
+package jsontoxml
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestIndentWriter(t *testing.T) {
+	const xml = "<!DOCTYPE a [\n<!ENTITY b \"c\">\n]>\n <a>\n  <b>c d</b>\n </a>\n"
+	for _, tc := range []struct {
+		query, want string
+	}{
+		{"", xml},
+		{"indent=2", "<!DOCTYPE a [\n<!ENTITY b \"c\">\n]>\n<a>\n  <b>c d</b>\n</a>\n"},
+		{"indent=tab", "<!DOCTYPE a [\n<!ENTITY b \"c\">\n]>\n<a>\n\t<b>c d</b>\n</a>\n"},
+		{"indent=none", "<!DOCTYPE a [<!ENTITY b \"c\">]><a><b>c d</b></a>"},
+	} {
+		q, err := url.ParseQuery(tc.query)
+		require.NoError(t, err)
+		c, err := parseConversion(&convertOptions{}, nil, q)
+		require.NoError(t, err)
+		var sb strings.Builder
+		w := c.opts.layout.writer(&sb)
+		// The lines are split across writes.
+		for i := 0; i < len(xml); i += 3 {
+			_, err := w.Write([]byte(xml[i:min(i+3, len(xml))]))
+			require.NoError(t, err)
+		}
+		require.Equal(t, tc.want, sb.String(), tc.query)
+	}
+}
+
+func TestParseConversion(t *testing.T) {
+	base := &convertOptions{xsiTypes: true}
+	q, _ := url.ParseQuery("format=lossless&array_root=rows&root_element=row&url=https://example.com/a.json")
+	allow := hostAllowlist{"example.com"}
+	c, err := parseConversion(base, allow, q)
+	require.NoError(t, err)
+	require.Equal(t, &convertOptions{xsiTypes: true, generic: true, lossless: true, arrayRoot: "rows",
+		rootElement: "row"}, c.opts)
+	require.Equal(t, "https://example.com/a.json", c.url)
+	require.Equal(t, &convertOptions{xsiTypes: true}, base)
+
+	for _, query := range []string{
+		"format=yaml", "array_root=1a", "root_element=a b", "indent=3", "url=file:///etc/passwd", "download=../a",
+		"url=http://169.254.169.254/latest/meta-data/",
+	} {
+		q, _ := url.ParseQuery(query)
+		_, err := parseConversion(base, allow, q)
+		require.Error(t, err, query)
+	}
+}
+
+func TestServeConversion(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/text" {
+			w.Header().Set("Content-Type", "text/plain")
+		} else {
+			w.Header().Set("Content-Type", "application/json")
+		}
+		w.Write([]byte(`{"a": 1}`))
+	}))
+	defer upstream.Close()
+	mux := http.NewServeMux()
+	allow := hostAllowlist{"127.0.0.1"}
+	mux.Handle("/convert", &converter{opts: &convertOptions{}, client: newServeClient(allow),
+		allow: allow})
+	mux.HandleFunc("GET /{$}", serveUI)
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	resp, err := http.Get(srv.URL)
+	require.NoError(t, err)
+	page, err := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	require.NoError(t, err)
+	require.Equal(t, "text/html; charset=utf-8", resp.Header.Get("Content-Type"))
+	require.Contains(t, string(page), "<form")
+
+	post := func(query, body string) (*http.Response, string) {
+		resp, err := http.Post(srv.URL+"/convert?"+query, "application/json", strings.NewReader(body))
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		got, err := ioutil.ReadAll(resp.Body)
+		require.NoError(t, err)
+		return resp, string(got)
+	}
+	resp, got := post("format=generic&indent=2&download=a.xml", `{"a": 1}`)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	require.Equal(t, `attachment; filename="a.xml"`, resp.Header.Get("Content-Disposition"))
+	require.Equal(t, "<jsonData>\n  <a>1</a>\n</jsonData>\n", got)
+
+	q := url.Values{
+		"url": {upstream.URL + "/a.json"}, "format": {"generic"}, "indent": {"none"},
+	}
+	resp, got = post(q.Encode(), "")
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	require.Equal(t, "<jsonData><a>1</a></jsonData>", got)
+
+	q.Set("url", upstream.URL+"/text")
+	resp, got = post(q.Encode(), "")
+	require.Equal(t, http.StatusBadGateway, resp.StatusCode)
+	require.Contains(t, got, "Content-Type")
+
+	q.Set("url", strings.Replace(upstream.URL, "127.0.0.1", "localhost", 1)+"/a.json")
+	resp, got = post(q.Encode(), "")
+	require.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	require.Contains(t, got, `host "localhost" isn't allowed by --allow-host`)
+
+	resp, _ = post("indent=3", `{}`)
+	require.Equal(t, http.StatusBadRequest, resp.StatusCode)
+}
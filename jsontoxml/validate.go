@@ -0,0 +1,88 @@
+package jsontoxml
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"io"
+
+	"github.com/pkg/errors"
+)
+
+// Validator checks a payload before it is converted. It can inspect the status
+// and headers in m, peek at the start of body or read it fully. It returns the
+// payload to convert: body itself, or a rewritten payload, e.g. the content of
+// an envelope. An error rejects the payload, which then fails like any other.
+type Validator func(m Metadata, body *bufio.Reader) (io.Reader, error)
+
+// UnwrapEnvelope returns a Validator converting the value of key in payloads of
+// the form {"<key>": ...}. Payloads without the key are rejected. The whole
+// payload is read, so envelopes aren't streamed.
+func UnwrapEnvelope(key string) Validator {
+	return func(m Metadata, body *bufio.Reader) (io.Reader, error) {
+		var env map[string]json.RawMessage
+		if err := json.NewDecoder(body).Decode(&env); err != nil {
+			return nil, errors.Wrap(err, "decode envelope")
+		}
+		v, ok := env[key]
+		if !ok {
+			return nil, errors.Errorf("no %q in the envelope", key)
+		}
+		return bytes.NewReader(v), nil
+	}
+}
+
+// Validators returns a Validator applying vs in turn, each to the payload
+// returned by the previous one.
+func Validators(vs ...Validator) Validator {
+	return func(m Metadata, body *bufio.Reader) (io.Reader, error) {
+		for _, v := range vs {
+			r, err := v(m, body)
+			if err != nil {
+				return nil, err
+			}
+			if br, ok := r.(*bufio.Reader); ok {
+				body = br
+			} else {
+				body = bufio.NewReader(r)
+			}
+		}
+		return body, nil
+	}
+}
+
+// newValidator returns the Validator of the command line flags: the soft
+// errors are detected before the hypermedia envelopes are unwrapped, and the
+// unwrapped payloads are checked by the JSON Schema. It returns nil if no flag
+// is set.
+func newValidator() (Validator, error) {
+	var vs []Validator
+	soft, err := newSoftErrorValidator(errorField, errorPath)
+	if err != nil {
+		return nil, err
+	}
+	unwrap, err := newHypermediaValidator(hypermedia)
+	if err != nil {
+		return nil, err
+	}
+	var schema Validator
+	if len(jsonSchemaFile) > 0 {
+		s, err := loadJSONSchema(jsonSchemaFile)
+		if err != nil {
+			return nil, err
+		}
+		schema = newSchemaValidator(s)
+	}
+	for _, v := range []Validator{soft, unwrap, schema} {
+		if v != nil {
+			vs = append(vs, v)
+		}
+	}
+	switch len(vs) {
+	case 0:
+		return nil, nil
+	case 1:
+		return vs[0], nil
+	}
+	return Validators(vs...), nil
+}
@@ -0,0 +1,67 @@
+package jsontoxml
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/require"
+)
+
+func TestValidators(t *testing.T) {
+	validate := func(v Validator, payload string) (string, error) {
+		r, err := v(Metadata{}, bufio.NewReader(strings.NewReader(payload)))
+		if err != nil {
+			return "", err
+		}
+		data, err := ioutil.ReadAll(r)
+		return string(data), err
+	}
+	got, err := validate(UnwrapEnvelope("data"), `{"data": [{"id": 1}], "meta": {}}`)
+	require.NoError(t, err)
+	require.Equal(t, `[{"id": 1}]`, got)
+	_, err = validate(UnwrapEnvelope("data"), `{"errors": []}`)
+	require.Error(t, err)
+	_, err = validate(UnwrapEnvelope("data"), `[]`)
+	require.Error(t, err)
+
+	got, err = validate(Validators(UnwrapEnvelope("outer"), UnwrapEnvelope("inner")),
+		`{"outer": {"inner": {"id": 1}}}`)
+	require.NoError(t, err)
+	require.Equal(t, `{"id": 1}`, got)
+}
+
+func TestRunnerValidate(t *testing.T) {
+	var sinks memSinks
+	r := &Runner{
+		NewSink: sinks.newSink,
+		Validate: func(m Metadata, body *bufio.Reader) (io.Reader, error) {
+			// Soft errors are reported with a 200 status and an error body.
+			if start, _ := body.Peek(9); string(start) == `{"error":` {
+				return nil, errors.New("soft error")
+			}
+			require.Equal(t, 200, m.StatusCode)
+			return body, nil
+		},
+	}
+	r.AddURLs("http://a/valid")
+	r.Add(&queueSource{ch: func() chan []byte {
+		ch := make(chan []byte, 1)
+		ch <- []byte(`{"error": "quota exceeded"}`)
+		close(ch)
+		return ch
+	}()})
+	r.Transport = RoundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		return new(mockClient).Get(req.URL.Path[1:])
+	})
+	rep, err := r.Run(context.Background())
+	require.NoError(t, err)
+	require.Equal(t, 1, rep.Failed)
+	require.EqualError(t, rep.Errors["queue:0"], "validation failed: soft error")
+	require.Contains(t, sinks.docs["0.xml"], "<first>firstname</first>")
+}
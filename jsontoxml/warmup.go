@@ -0,0 +1,130 @@
+package jsontoxml
+
+import (
+	"context"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"sync/atomic"
+	"time"
+
+	"github.com/pkg/errors"
+	"golang.org/x/sync/errgroup"
+)
+
+var warmUp bool
+
+func init() {
+	rootCmd.PersistentFlags().BoolVar(&warmUp, "warm-up", false,
+		"Before the run, resolve the hosts of the urls and open connections to them, up to one"+
+			" per worker, so that the first urls don't wait for DNS and TLS handshakes.")
+}
+
+// warmUpList reads the url list again and returns the urls owned by the shard,
+// up to conns per host, which is all warmUpHosts uses. The urls read from
+// stdin can't be read twice and are left out.
+func warmUpList(sh shard, conns int) ([]string, error) {
+	var it *urlIter
+	if urlFile == "-" {
+		it = newURLIter(urls, nil)
+	} else {
+		var err error
+		if it, err = openURLList(); err != nil {
+			return nil, err
+		}
+		defer it.Close()
+	}
+	perHost := make(map[string]int)
+	var owned []string
+	for {
+		entry, err := it.next()
+		if err == io.EOF {
+			return owned, errors.Wrap(it.Err(), "read the url list")
+		}
+		u := primaryURL(entry)
+		pu, err := url.Parse(u)
+		if err != nil || !sh.owns(u) || perHost[pu.Host] >= conns {
+			continue
+		}
+		perHost[pu.Host]++
+		owned = append(owned, u)
+	}
+}
+
+// warmUpHosts opens up to conns connections to the hosts of the urls, with
+// HEAD requests to their first url, which leave the connections in the pool of
+// the client. Failures are ignored: the urls report them when they are
+// fetched.
+func warmUpHosts(ctx context.Context, c *http.Client, urls []string, conns int) {
+	type host struct {
+		url string
+		n   int
+	}
+	if conns < 1 {
+		conns = 1
+	}
+	hosts := make(map[string]*host)
+	var order []*host
+	for _, u := range urls {
+		pu, err := url.Parse(u)
+		if err != nil || pu.Scheme != "http" && pu.Scheme != "https" {
+			continue
+		}
+		key := pu.Scheme + "://" + pu.Host
+		h, ok := hosts[key]
+		if !ok {
+			h = &host{url: u}
+			hosts[key] = h
+			order = append(order, h)
+		}
+		// One connection per url, as many as the workers can use.
+		if h.n < conns {
+			h.n++
+		}
+	}
+	if len(order) == 0 {
+		return
+	}
+	start := time.Now()
+	var opened int64
+	// The requests to a host are sent at the same time, so that they open
+	// separate connections.
+	var hostGroup errgroup.Group
+	hostGroup.SetLimit(conns)
+	for _, h := range order {
+		h := h
+		hostGroup.Go(func() error {
+			var eg errgroup.Group
+			for i := 0; i < h.n; i++ {
+				eg.Go(func() error {
+					if warmUpURL(ctx, c, h.url) {
+						atomic.AddInt64(&opened, 1)
+					}
+					return nil
+				})
+			}
+			return eg.Wait()
+		})
+	}
+	hostGroup.Wait()
+	log.Printf("Warmed up %d connections to %d hosts in %s", opened, len(order),
+		time.Since(start))
+}
+
+// warmUpURL sends a HEAD request to u. It returns true if it succeeded.
+func warmUpURL(ctx context.Context, c *http.Client, u string) bool {
+	req, err := http.NewRequestWithContext(ctx, http.MethodHead, u, nil)
+	if err != nil {
+		return false
+	}
+	resp, err := c.Do(req)
+	if err != nil {
+		log.Printf("Failed warming up url: %q err: %s", u, err)
+		return false
+	}
+	io.Copy(ioutil.Discard, resp.Body)
+	resp.Body.Close()
+	return true
+}
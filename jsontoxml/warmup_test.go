@@ -0,0 +1,58 @@
+package jsontoxml
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestWarmUpHosts(t *testing.T) {
+	var mu sync.Mutex
+	conns := make(map[string]int)
+	heads := make(map[string]int)
+	newServer := func(name string) *httptest.Server {
+		srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			mu.Lock()
+			heads[name+" "+r.Method]++
+			mu.Unlock()
+		}))
+		srv.Config.ConnState = func(c net.Conn, s http.ConnState) {
+			if s == http.StateNew {
+				mu.Lock()
+				conns[name]++
+				mu.Unlock()
+			}
+		}
+		srv.Start()
+		return srv
+	}
+	a, b := newServer("a"), newServer("b")
+	defer a.Close()
+	defer b.Close()
+
+	c := &http.Client{Transport: &http.Transport{MaxIdleConnsPerHost: 4}}
+	warmUpHosts(context.Background(), c, []string{
+		a.URL + "/1", a.URL + "/2", a.URL + "/3", b.URL + "/1", "file:///a.json", "%",
+	}, 2)
+	require.Equal(t, map[string]int{"a": 2, "b": 1}, conns)
+	require.Equal(t, map[string]int{"a HEAD": 2, "b HEAD": 1}, heads)
+
+	// The urls are then fetched over the warm connections.
+	var wg sync.WaitGroup
+	for i := 0; i < 2; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			resp, err := c.Get(a.URL + "/1")
+			require.NoError(t, err)
+			resp.Body.Close()
+		}()
+	}
+	wg.Wait()
+	require.Equal(t, 2, conns["a"])
+}
@@ -0,0 +1,72 @@
+package jsontoxml
+
+import (
+	"fmt"
+	"strconv"
+)
+
+// maxWarnings is the number of distinct warnings kept for a document. The
+// others are only counted.
+const maxWarnings = 100
+
+// docWarnings collects the warnings of a document: the issues that don't
+// fail its conversion but change its content, e.g. a key renamed to be a
+// valid xml name. A warning repeated by every record of the document is kept
+// once, with its count.
+type docWarnings struct {
+	msgs   []string
+	counts map[string]int
+	// dropped counts the warnings beyond maxWarnings distinct ones.
+	dropped int
+}
+
+// add records a warning. w may be nil.
+func (w *docWarnings) add(msg string) {
+	if w == nil {
+		return
+	}
+	if w.counts == nil {
+		w.counts = make(map[string]int)
+	}
+	if _, ok := w.counts[msg]; !ok && len(w.msgs) == maxWarnings {
+		w.dropped++
+		return
+	} else if !ok {
+		w.msgs = append(w.msgs, msg)
+	}
+	w.counts[msg]++
+}
+
+// messages returns the warnings in the order they were first seen, with their
+// count if they were repeated. w may be nil.
+func (w *docWarnings) messages() []string {
+	if w == nil || len(w.msgs) == 0 {
+		return nil
+	}
+	out := make([]string, 0, len(w.msgs)+1)
+	for _, msg := range w.msgs {
+		if n := w.counts[msg]; n > 1 {
+			msg += " (x" + strconv.Itoa(n) + ")"
+		}
+		out = append(out, msg)
+	}
+	if w.dropped > 0 {
+		out = append(out, fmt.Sprintf("%d other warnings", w.dropped))
+	}
+	return out
+}
+
+// warn records a warning of the document being converted, if its warnings
+// are collected.
+func (o *convertOptions) warn(format string, args ...interface{}) {
+	if o.warnings == nil {
+		return
+	}
+	o.warnings.add(fmt.Sprintf(format, args...))
+	o.stats.addWarning()
+}
+
+// warnRenamed records that the json key was written as the element name.
+func (o *convertOptions) warnRenamed(key, name string) {
+	o.warn("key %q written as <%s>", key, name)
+}
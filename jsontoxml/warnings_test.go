@@ -0,0 +1,48 @@
+package jsontoxml
+
+import (
+	"context"
+	"io/ioutil"
+	"path/filepath"
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestDocWarnings(t *testing.T) {
+	var nilWarnings *docWarnings
+	nilWarnings.add("ignored")
+	require.Nil(t, nilWarnings.messages())
+
+	w := &docWarnings{}
+	w.add("a")
+	w.add("b")
+	w.add("a")
+	require.Equal(t, []string{"a (x2)", "b"}, w.messages())
+
+	w = &docWarnings{}
+	for i := 0; i < maxWarnings+2; i++ {
+		w.add(strconv.Itoa(i))
+	}
+	msgs := w.messages()
+	require.Len(t, msgs, maxWarnings+1)
+	require.Equal(t, "2 other warnings", msgs[maxWarnings])
+}
+
+func TestRunnerWarnings(t *testing.T) {
+	dir := t.TempDir()
+	src := filepath.Join(dir, "in.json")
+	payload := `[{"first name": "a"}, {"first name": "b"}]`
+	require.NoError(t, ioutil.WriteFile(src, []byte(payload), 0600))
+	var sinks memSinks
+	r := &Runner{NewSink: sinks.newSink, Stats: StatsComment}
+	r.opts = &convertOptions{generic: true}
+	r.Add(&fileSource{paths: []string{src}})
+	rep, err := r.Run(context.Background())
+	require.NoError(t, err)
+	require.Zero(t, rep.Failed)
+	require.Equal(t, map[string][]string{src: {`key "first name" written as <first_name> (x2)`}},
+		rep.Warnings)
+	require.Contains(t, sinks.docs["0.xml"], `"warnings":2}-->`)
+}
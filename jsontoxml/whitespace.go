@@ -0,0 +1,187 @@
+package jsontoxml
+
+import (
+	"encoding/xml"
+	"reflect"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// Whitespace policies of the string values.
+const (
+	// whitespaceKeep writes the values as they are. It is the default.
+	whitespaceKeep = "keep"
+	// whitespacePreserve writes the values as they are, and marks the
+	// elements of the values with significant whitespace with
+	// xml:space="preserve".
+	whitespacePreserve = "preserve"
+	// whitespaceTrim removes the leading and trailing whitespace.
+	whitespaceTrim = "trim"
+	// whitespaceNormalize trims the values and collapses the runs of
+	// whitespace to a single space.
+	whitespaceNormalize = "normalize"
+)
+
+// xmlSpacePreserve is the xml:space="preserve" attribute.
+var xmlSpacePreserve = xml.Attr{
+	Name:  xml.Name{Space: "http://www.w3.org/XML/1998/namespace", Local: "space"},
+	Value: "preserve",
+}
+
+var (
+	whitespaceDefault string
+	whitespaceFields  []string
+)
+
+func init() {
+	rootCmd.PersistentFlags().StringVar(&whitespaceDefault, "whitespace", whitespaceKeep,
+		"Whitespace policy of the string values: keep, preserve (keep and mark the values"+
+			" with significant whitespace with xml:space=\"preserve\"), trim or normalize (trim"+
+			" and collapse inner runs of whitespace).")
+	rootCmd.PersistentFlags().StringArrayVar(&whitespaceFields, "whitespace-field", nil,
+		"Whitespace policy of the values of a json key, as key=policy, overriding"+
+			" --whitespace. Can be repeated.")
+}
+
+// whitespaceOptions apply the whitespace policies to the string values. A nil
+// whitespaceOptions keeps every value.
+type whitespaceOptions struct {
+	def string
+	// fields are the policies by json key.
+	fields map[string]string
+}
+
+// newWhitespaceOptions builds the whitespace options from the flags. It
+// returns nil if every value is kept.
+func newWhitespaceOptions(def string, fields []string) (*whitespaceOptions, error) {
+	valid := func(p string) bool {
+		switch p {
+		case whitespaceKeep, whitespacePreserve, whitespaceTrim, whitespaceNormalize:
+			return true
+		}
+		return false
+	}
+	if !valid(def) {
+		return nil, errors.Errorf("invalid --whitespace %q. Expected keep, preserve, trim or"+
+			" normalize", def)
+	}
+	wo := &whitespaceOptions{def: def, fields: make(map[string]string)}
+	for _, f := range fields {
+		parts := strings.SplitN(f, "=", 2)
+		if len(parts) != 2 || len(parts[0]) == 0 || !valid(parts[1]) {
+			return nil, errors.Errorf("invalid --whitespace-field %q. Expected key=policy with"+
+				" a policy of keep, preserve, trim or normalize", f)
+		}
+		wo.fields[parts[0]] = parts[1]
+	}
+	if def == whitespaceKeep && len(wo.fields) == 0 {
+		return nil, nil
+	}
+	return wo, nil
+}
+
+// policy returns the policy of the values of the json key. The keys of the
+// jsonData fields are matched case insensitively, as encoding/json does.
+func (wo *whitespaceOptions) policy(key string, fold bool) string {
+	if p, ok := wo.fields[key]; ok {
+		return p
+	}
+	if fold {
+		for k, p := range wo.fields {
+			if strings.EqualFold(k, key) {
+				return p
+			}
+		}
+	}
+	return wo.def
+}
+
+// applyWhitespace applies the policy to the string s.
+func applyWhitespace(policy, s string) string {
+	switch policy {
+	case whitespaceTrim:
+		return strings.TrimSpace(s)
+	case whitespaceNormalize:
+		return strings.Join(strings.Fields(s), " ")
+	}
+	return s
+}
+
+// significantSpace returns true if the whitespace of s would be changed by an
+// xml processor that doesn't preserve it: leading or trailing whitespace,
+// line breaks, tabs or runs of spaces.
+func significantSpace(s string) bool {
+	return s != strings.TrimSpace(s) || strings.ContainsAny(s, "\t\r\n") ||
+		strings.Contains(s, "  ")
+}
+
+// value applies the policies to the strings of the decoded json value v, held
+// by the json key. The entries of an array are held by the key of the array.
+func (wo *whitespaceOptions) value(key string, v interface{}) interface{} {
+	if wo == nil {
+		return v
+	}
+	switch val := v.(type) {
+	case map[string]interface{}:
+		for k, item := range val {
+			val[k] = wo.value(k, item)
+		}
+	case []interface{}:
+		for i, item := range val {
+			val[i] = wo.value(key, item)
+		}
+	case string:
+		return applyWhitespace(wo.policy(key, false), val)
+	}
+	return v
+}
+
+// preserve returns true if the element of the json key holding v must be marked
+// with xml:space="preserve": if it is a string, or an array of strings, with
+// significant whitespace and the preserve policy.
+func (wo *whitespaceOptions) preserve(key string, v interface{}) bool {
+	if wo == nil || wo.policy(key, false) != whitespacePreserve {
+		return false
+	}
+	switch val := v.(type) {
+	case string:
+		return significantSpace(val)
+	case []interface{}:
+		for _, item := range val {
+			if s, ok := item.(string); ok && significantSpace(s) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// record applies the policies to the string fields of p. It returns true if
+// a field has significant whitespace and the preserve policy, in which case the
+// whole record is marked with xml:space="preserve", as the fields are nested
+// in elements it doesn't control.
+func (wo *whitespaceOptions) record(p *jsonData) (preserve bool) {
+	if wo == nil {
+		return false
+	}
+	v := reflect.ValueOf(p).Elem()
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if f.Type.Kind() != reflect.String {
+			continue
+		}
+		key := strings.Split(f.Tag.Get("json"), ",")[0]
+		if len(key) == 0 {
+			key = f.Name
+		}
+		policy := wo.policy(key, true)
+		s := v.Field(i).String()
+		if policy == whitespacePreserve && significantSpace(s) {
+			preserve = true
+		}
+		v.Field(i).SetString(applyWhitespace(policy, s))
+	}
+	return preserve
+}
@@ -0,0 +1,76 @@
+package jsontoxml
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewWhitespaceOptions(t *testing.T) {
+	wo, err := newWhitespaceOptions(whitespaceKeep, nil)
+	require.NoError(t, err)
+	require.Nil(t, wo)
+	wo, err = newWhitespaceOptions(whitespaceTrim, []string{"code=keep", "note=normalize"})
+	require.NoError(t, err)
+	require.Equal(t, whitespaceKeep, wo.policy("code", false))
+	require.Equal(t, whitespaceNormalize, wo.policy("note", false))
+	require.Equal(t, whitespaceTrim, wo.policy("Note", false))
+	require.Equal(t, whitespaceNormalize, wo.policy("Note", true))
+
+	_, err = newWhitespaceOptions("strip", nil)
+	require.EqualError(t, err, `invalid --whitespace "strip". Expected keep, preserve, trim or normalize`)
+	_, err = newWhitespaceOptions(whitespaceKeep, []string{"code"})
+	require.EqualError(t, err, `invalid --whitespace-field "code". Expected key=policy with a policy`+
+		` of keep, preserve, trim or normalize`)
+}
+
+func TestWhitespaceStruct(t *testing.T) {
+	wo, err := newWhitespaceOptions(whitespaceTrim, []string{"last_name=normalize",
+		"city=preserve"})
+	require.NoError(t, err)
+	opts := &convertOptions{whitespace: wo}
+	data, err := convert([]byte(`{"first_name": "  Ada ", "last_name": " Lovelace \n King ",
+		"City": "London", "State": " x "}`), opts)
+	require.NoError(t, err)
+	require.Contains(t, string(data), "<first>Ada</first>")
+	require.Contains(t, string(data), "<last>Lovelace King</last>")
+	require.Contains(t, string(data), "<State>x</State>")
+	// London has no significant whitespace.
+	require.True(t, strings.HasPrefix(string(data), " <jsonData>"), string(data))
+
+	data, err = convert([]byte(`[{"first_name": "a", "City": " London"}]`), opts)
+	require.NoError(t, err)
+	require.Contains(t, string(data), `<jsonData xml:space="preserve">`)
+	require.Contains(t, string(data), "<City> London</City>")
+}
+
+func TestWhitespaceGeneric(t *testing.T) {
+	wo, err := newWhitespaceOptions(whitespacePreserve, []string{"name=trim",
+		"tags=normalize"})
+	require.NoError(t, err)
+	opts := &convertOptions{generic: true, whitespace: wo}
+	in := `{"name": " a ", "code": "  007", "plain": "b", "tags": [" x  y "],
+		"nested": {"name": " c ", "lines": ["one\ntwo"]}}`
+	want := []string{
+		"<name>a</name>",
+		`<code xml:space="preserve">  007</code>`,
+		"<plain>b</plain>",
+		"<item>x y</item>",
+		"<name>c</name>",
+		`<lines xml:space="preserve">`,
+	}
+	data, err := convert([]byte(in), opts)
+	require.NoError(t, err)
+	for _, w := range want {
+		require.Contains(t, string(data), w)
+	}
+
+	// The streamed arrays apply the same policies.
+	var buf bytes.Buffer
+	require.NoError(t, convertStream(strings.NewReader("["+in+"]"), &buf, opts, false))
+	for _, w := range want {
+		require.Contains(t, buf.String(), w)
+	}
+}
@@ -0,0 +1,139 @@
+package jsontoxml
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// windowSource reads the payloads pushed to a channel, like queueSource, and
+// groups their records in tumbling windows: every window is a json array of
+// the records read until it holds size records or its duration elapses,
+// whichever comes first. It gives the consumers of a stream documents at a
+// predictable cadence. Empty windows are skipped.
+type windowSource struct {
+	ch <-chan []byte
+	// size is the number of records closing a window, if positive.
+	size int
+	// every is the duration of a window, if positive.
+	every time.Duration
+
+	mu sync.Mutex
+	// end is when the current window closes.
+	end time.Time
+	// pending are the records read past the size of the previous window.
+	pending []json.RawMessage
+	closed  bool
+	next    int
+	// payloads counts the payloads read, to name the invalid ones.
+	payloads int
+}
+
+// NewWindowSource returns a source of the windows of the payloads of ch. The
+// windows close at size records or after every, and at least one of them
+// must be positive.
+func NewWindowSource(ch <-chan []byte, size int, every time.Duration) (Source, error) {
+	if size <= 0 && every <= 0 {
+		return nil, errors.New("expected a window size or duration")
+	}
+	return &windowSource{ch: ch, size: size, every: every}, nil
+}
+
+func (s *windowSource) Next() (io.ReadCloser, Metadata, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var timeout <-chan time.Time
+	if s.every > 0 {
+		if s.end.IsZero() {
+			s.end = time.Now().Add(s.every)
+		}
+		t := time.NewTimer(time.Until(s.end))
+		defer t.Stop()
+		timeout = t.C
+	}
+	records := s.pending
+	s.pending = nil
+	byTime := false
+read:
+	for !s.closed && (s.size <= 0 || len(records) < s.size) {
+		select {
+		case payload, ok := <-s.ch:
+			if !ok {
+				s.closed = true
+				break
+			}
+			m := Metadata{Index: s.payloads, URL: fmt.Sprintf("queue:%d", s.payloads),
+				ContentType: "application/json"}
+			s.payloads++
+			rs, err := payloadRecords(payload)
+			if err != nil {
+				// The records read so far go to the window, the payload fails
+				// on its own.
+				s.pending = records
+				return nil, m, err
+			}
+			records = append(records, rs...)
+		case <-timeout:
+			s.advance()
+			if len(records) > 0 {
+				byTime = true
+				break read
+			}
+			timeout = time.After(time.Until(s.end))
+		}
+	}
+	if len(records) == 0 {
+		return nil, Metadata{}, io.EOF
+	}
+	if s.size > 0 && len(records) > s.size {
+		s.pending = records[s.size:]
+		records = records[:s.size]
+	}
+	if s.every > 0 && !byTime {
+		// The window closed early: the next one lasts a whole duration.
+		s.end = time.Now().Add(s.every)
+	}
+	data, err := json.Marshal(records)
+	if err != nil {
+		return nil, Metadata{}, errors.Wrap(err, "json.Marshal")
+	}
+	m := Metadata{Index: s.next, URL: fmt.Sprintf("window:%d", s.next),
+		ContentType: "application/json"}
+	s.next++
+	return ioutil.NopCloser(bytes.NewReader(data)), m, nil
+}
+
+// advance moves the end of the window past now, keeping the cadence of the
+// windows.
+func (s *windowSource) advance() {
+	for now := time.Now(); !s.end.After(now); {
+		s.end = s.end.Add(s.every)
+	}
+}
+
+// payloadRecords returns the records of a json payload: the entries of an
+// array, or the whole document.
+func payloadRecords(payload []byte) ([]json.RawMessage, error) {
+	array, err := peekArray(bufio.NewReader(bytes.NewReader(payload)))
+	if err != nil {
+		return nil, errors.Wrap(err, "read")
+	}
+	if !array {
+		if !json.Valid(payload) {
+			return nil, errors.New("json.Unmarshal: invalid json")
+		}
+		return []json.RawMessage{append(json.RawMessage(nil), payload...)}, nil
+	}
+	var records []json.RawMessage
+	if err := json.Unmarshal(payload, &records); err != nil {
+		return nil, errors.Wrap(err, "json.Unmarshal")
+	}
+	return records, nil
+}
@@ -0,0 +1,78 @@
+package jsontoxml
+
+import (
+	"context"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestWindowSourceSize(t *testing.T) {
+	ch := make(chan []byte, 3)
+	ch <- []byte(`{"id": 1}`)
+	ch <- []byte(`[{"id": 2}, {"id": 3}, {"id": 4}]`)
+	ch <- []byte(`{"id": 5}`)
+	close(ch)
+	src, err := NewWindowSource(ch, 2, 0)
+	require.NoError(t, err)
+	payloads, metas := drain(t, src)
+	// The records past the size of a window go to the next one.
+	require.Equal(t, []string{`[{"id":1},{"id":2}]`, `[{"id":3},{"id":4}]`, `[{"id":5}]`},
+		payloads)
+	require.Equal(t, "window:2", metas[2].URL)
+}
+
+func TestWindowSourceDuration(t *testing.T) {
+	ch := make(chan []byte)
+	src, err := NewWindowSource(ch, 0, 50*time.Millisecond)
+	require.NoError(t, err)
+	go func() {
+		ch <- []byte(`{"id": 1}`)
+		ch <- []byte(`{"id": 2}`)
+		// The empty windows are skipped.
+		time.Sleep(120 * time.Millisecond)
+		ch <- []byte(`{"id": 3}`)
+		close(ch)
+	}()
+	payloads, _ := drain(t, src)
+	require.Equal(t, []string{`[{"id":1},{"id":2}]`, `[{"id":3}]`}, payloads)
+}
+
+func TestWindowSourceInvalid(t *testing.T) {
+	ch := make(chan []byte, 3)
+	ch <- []byte(`{"id": 1}`)
+	ch <- []byte(`{"id": `)
+	ch <- []byte(`{"id": 2}`)
+	close(ch)
+	src, err := NewWindowSource(ch, 10, 0)
+	require.NoError(t, err)
+	_, m, err := src.Next()
+	require.Error(t, err)
+	require.Equal(t, "queue:1", m.URL)
+	payloads, _ := drain(t, src)
+	require.Equal(t, []string{`[{"id":1},{"id":2}]`}, payloads)
+
+	_, err = NewWindowSource(ch, 0, 0)
+	require.Error(t, err)
+}
+
+func TestRunnerWindows(t *testing.T) {
+	ch := make(chan []byte, 2)
+	ch <- []byte(`{"id": 7}`)
+	ch <- []byte(`{"id": 8}`)
+	close(ch)
+	src, err := NewWindowSource(ch, 0, time.Minute)
+	require.NoError(t, err)
+	var sinks memSinks
+	r := &Runner{NewSink: sinks.newSink}
+	r.Add(src)
+	rep, err := r.Run(context.Background())
+	require.NoError(t, err)
+	require.Equal(t, 1, rep.Processed)
+	require.Contains(t, sinks.docs["0.xml"], "<Id>7</Id>")
+	require.Contains(t, sinks.docs["0.xml"], "<Id>8</Id>")
+	_, _, err = src.Next()
+	require.Equal(t, io.EOF, err)
+}
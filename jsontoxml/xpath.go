@@ -0,0 +1,1180 @@
+package jsontoxml
+
+import (
+	"bytes"
+	"encoding/xml"
+	"io"
+	"math"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/pkg/errors"
+)
+
+// Kinds of the nodes of an xtNode tree.
+const (
+	xtRoot = iota
+	xtElement
+	xtAttribute
+	xtText
+	xtComment
+)
+
+// xtNode is a node of the documents and stylesheets of --xslt, as seen by
+// XPath. The namespaces of the documents are ignored: nodes are named by their
+// local name.
+type xtNode struct {
+	kind int
+	name string
+	// space is the namespace of the node, to tell the instructions of a
+	// stylesheet from its literal elements.
+	space string
+	// value is the text of the attribute, text and comment nodes.
+	value    string
+	parent   *xtNode
+	attrs    []*xtNode
+	children []*xtNode
+	// order is the position of the node in its document.
+	order int
+}
+
+// parseXTTree returns the root node of the xml document. Adjacent texts and
+// CDATA sections are merged into one text node.
+func parseXTTree(data []byte) (*xtNode, error) {
+	dec := xml.NewDecoder(bytes.NewReader(data))
+	// Entities declared in a DOCTYPE aren't expanded by encoding/xml.
+	dec.Strict = false
+	root := &xtNode{kind: xtRoot}
+	cur, order := root, 1
+	add := func(n *xtNode) {
+		n.parent, n.order = cur, order
+		order++
+		cur.children = append(cur.children, n)
+	}
+	for {
+		tok, err := dec.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, errors.Wrap(err, "xml.Unmarshal")
+		}
+		switch t := tok.(type) {
+		case xml.StartElement:
+			n := &xtNode{kind: xtElement, name: t.Name.Local, space: t.Name.Space}
+			add(n)
+			for _, a := range t.Attr {
+				if a.Name.Space == "xmlns" || len(a.Name.Space) == 0 && a.Name.Local == "xmlns" {
+					continue
+				}
+				n.attrs = append(n.attrs, &xtNode{kind: xtAttribute, name: a.Name.Local,
+					space: a.Name.Space, value: a.Value, parent: n, order: order})
+				order++
+			}
+			cur = n
+		case xml.EndElement:
+			cur = cur.parent
+		case xml.CharData:
+			if cur == root {
+				continue
+			}
+			if last := cur.lastChild(); last != nil && last.kind == xtText {
+				last.value += string(t)
+				continue
+			}
+			add(&xtNode{kind: xtText, value: string(t)})
+		case xml.Comment:
+			add(&xtNode{kind: xtComment, value: string(t)})
+		}
+	}
+	if root.element() == nil {
+		return nil, errors.New("no root element")
+	}
+	return root, nil
+}
+
+func (n *xtNode) lastChild() *xtNode {
+	if len(n.children) == 0 {
+		return nil
+	}
+	return n.children[len(n.children)-1]
+}
+
+// element returns the first element child of n, e.g. the root element of a
+// document.
+func (n *xtNode) element() *xtNode {
+	for _, c := range n.children {
+		if c.kind == xtElement {
+			return c
+		}
+	}
+	return nil
+}
+
+// root returns the root node of the tree of n.
+func (n *xtNode) root() *xtNode {
+	for n.parent != nil {
+		n = n.parent
+	}
+	return n
+}
+
+// attr returns the value of the attribute without namespace.
+func (n *xtNode) attr(name string) (string, bool) {
+	for _, a := range n.attrs {
+		if a.name == name && len(a.space) == 0 {
+			return a.value, true
+		}
+	}
+	return "", false
+}
+
+// stringValue returns the XPath string-value of n: the text of its
+// descendants for the root and elements.
+func (n *xtNode) stringValue() string {
+	if n.kind != xtRoot && n.kind != xtElement {
+		return n.value
+	}
+	var b strings.Builder
+	var walk func(n *xtNode)
+	walk = func(n *xtNode) {
+		for _, c := range n.children {
+			switch c.kind {
+			case xtText:
+				b.WriteString(c.value)
+			case xtElement:
+				walk(c)
+			}
+		}
+	}
+	walk(n)
+	return b.String()
+}
+
+// xpNodes is an XPath node-set, in document order. The other XPath values are
+// strings, float64 and bool.
+type xpNodes []*xtNode
+
+// xpVars is a scope of the XPath variables.
+type xpVars struct {
+	name  string
+	value interface{}
+	next  *xpVars
+}
+
+// with returns the scope with the variable added.
+func (v *xpVars) with(name string, value interface{}) *xpVars {
+	return &xpVars{name: name, value: value, next: v}
+}
+
+func (v *xpVars) lookup(name string) (interface{}, bool) {
+	for ; v != nil; v = v.next {
+		if v.name == name {
+			return v.value, true
+		}
+	}
+	return nil, false
+}
+
+// xpCtx is the context of the evaluation of an expression.
+type xpCtx struct {
+	node      *xtNode
+	pos, size int
+	// current is the node of the xslt instruction, see current().
+	current *xtNode
+	vars    *xpVars
+}
+
+// xpExpr is a compiled XPath expression.
+type xpExpr interface {
+	eval(c *xpCtx) (interface{}, error)
+}
+
+// compileXPath compiles the XPath 1.0 expression. The axes following and
+// preceding, the processing instructions and the functions id, lang, key,
+// document and format-number aren't supported.
+func compileXPath(expr string) (xpExpr, error) {
+	toks, err := lexXPath(expr)
+	if err != nil {
+		return nil, errors.Wrapf(err, "invalid xpath %q", expr)
+	}
+	p := &xpParser{toks: toks}
+	e, err := p.or()
+	if err == nil && p.i < len(p.toks) {
+		err = errors.Errorf("unexpected %q", p.toks[p.i].text)
+	}
+	if err != nil {
+		return nil, errors.Wrapf(err, "invalid xpath %q", expr)
+	}
+	return e, nil
+}
+
+// Kinds of the tokens of an expression.
+const (
+	xpTokName = iota
+	xpTokNumber
+	xpTokLiteral
+	xpTokVar
+	xpTokOp
+)
+
+type xpToken struct {
+	kind int
+	text string
+	num  float64
+}
+
+// xpOperators are the tokens after which * is a name test and and, or, div
+// and mod are names.
+var xpOperators = map[string]bool{
+	"@": true, "::": true, "(": true, "[": true, ",": true, "and": true, "or": true,
+	"mod": true, "div": true, "mul": true, "/": true, "//": true, "|": true, "+": true,
+	"-": true, "=": true, "!=": true, "<": true, "<=": true, ">": true, ">=": true,
+}
+
+var xpNumberExpr = regexp.MustCompile(`^[0-9]+(\.[0-9]*)?|^\.[0-9]+`)
+
+func isNameStart(r rune) bool {
+	return r == '_' || unicode.IsLetter(r)
+}
+
+func isNameChar(r rune) bool {
+	return isNameStart(r) || r == '-' || r == '.' || unicode.IsDigit(r)
+}
+
+// lexXPath splits the expression into tokens. The multiplication * is the
+// operator mul.
+func lexXPath(s string) ([]xpToken, error) {
+	var toks []xpToken
+	// operator is set if the next token can be an operator, as the previous
+	// token ends an operand.
+	operator := func() bool {
+		if len(toks) == 0 {
+			return false
+		}
+		prev := toks[len(toks)-1]
+		return prev.kind != xpTokOp || !xpOperators[prev.text]
+	}
+	name := func(i int) int {
+		for i < len(s) {
+			r, size := utf8.DecodeRuneInString(s[i:])
+			if !isNameChar(r) {
+				break
+			}
+			i += size
+		}
+		return i
+	}
+	for i := 0; i < len(s); {
+		c := s[i]
+		r, _ := utf8.DecodeRuneInString(s[i:])
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			i++
+		case strings.HasPrefix(s[i:], "//") || strings.HasPrefix(s[i:], "::") ||
+			strings.HasPrefix(s[i:], "..") || strings.HasPrefix(s[i:], "!=") ||
+			strings.HasPrefix(s[i:], "<=") || strings.HasPrefix(s[i:], ">="):
+			toks = append(toks, xpToken{kind: xpTokOp, text: s[i : i+2]})
+			i += 2
+		case xpNumberExpr.MatchString(s[i:]):
+			m := xpNumberExpr.FindString(s[i:])
+			f, _ := strconv.ParseFloat(m, 64)
+			toks = append(toks, xpToken{kind: xpTokNumber, text: m, num: f})
+			i += len(m)
+		case strings.IndexByte("()[],@|+-=<>/.", c) >= 0:
+			toks = append(toks, xpToken{kind: xpTokOp, text: string(c)})
+			i++
+		case c == '*':
+			if operator() {
+				toks = append(toks, xpToken{kind: xpTokOp, text: "mul"})
+			} else {
+				toks = append(toks, xpToken{kind: xpTokName, text: "*"})
+			}
+			i++
+		case c == '"' || c == '\'':
+			end := strings.IndexByte(s[i+1:], c)
+			if end < 0 {
+				return nil, errors.New("unclosed literal")
+			}
+			toks = append(toks, xpToken{kind: xpTokLiteral, text: s[i+1 : i+1+end]})
+			i += end + 2
+		case c == '$':
+			end := name(i + 1)
+			if end < len(s) && s[end] == ':' {
+				end = name(end + 1)
+			}
+			if end == i+1 {
+				return nil, errors.New("expected a variable name after $")
+			}
+			toks = append(toks, xpToken{kind: xpTokVar, text: s[i+1 : end]})
+			i = end
+		case isNameStart(r):
+			end := name(i)
+			n := s[i:end]
+			if operator() && (n == "and" || n == "or" || n == "div" || n == "mod") {
+				toks = append(toks, xpToken{kind: xpTokOp, text: n})
+				i = end
+				continue
+			}
+			// The prefixes are ignored, like the namespaces.
+			if end+1 < len(s) && s[end] == ':' && s[end+1] != ':' {
+				if s[end+1] == '*' {
+					n, end = "*", end+2
+				} else if local := name(end + 1); local > end+1 {
+					n, end = s[end+1:local], local
+				}
+			}
+			toks = append(toks, xpToken{kind: xpTokName, text: n})
+			i = end
+		default:
+			return nil, errors.Errorf("unexpected %q", r)
+		}
+	}
+	return toks, nil
+}
+
+// xpFunctions holds the minimum and maximum (-1 if unbounded) numbers of
+// arguments of the functions.
+var xpFunctions = map[string][2]int{
+	"last": {0, 0}, "position": {0, 0}, "count": {1, 1}, "name": {0, 1},
+	"local-name": {0, 1}, "string": {0, 1}, "concat": {2, -1}, "starts-with": {2, 2},
+	"contains": {2, 2}, "substring-before": {2, 2}, "substring-after": {2, 2},
+	"substring": {2, 3}, "string-length": {0, 1}, "normalize-space": {0, 1},
+	"translate": {3, 3}, "boolean": {1, 1}, "not": {1, 1}, "true": {0, 0}, "false": {0, 0},
+	"number": {0, 1}, "sum": {1, 1}, "floor": {1, 1}, "ceiling": {1, 1}, "round": {1, 1},
+	"current": {0, 0},
+}
+
+// xpAxes are the supported axes.
+var xpAxes = map[string]bool{
+	"child": true, "attribute": true, "self": true, "parent": true, "descendant": true,
+	"descendant-or-self": true, "ancestor": true, "ancestor-or-self": true,
+	"following-sibling": true, "preceding-sibling": true,
+}
+
+type xpParser struct {
+	toks []xpToken
+	i    int
+}
+
+func (p *xpParser) peekAt(i int) xpToken {
+	if p.i+i < len(p.toks) {
+		return p.toks[p.i+i]
+	}
+	return xpToken{kind: -1}
+}
+
+func (p *xpParser) isOp(text string) bool {
+	t := p.peekAt(0)
+	return t.kind == xpTokOp && t.text == text
+}
+
+func (p *xpParser) expect(text string) error {
+	if !p.isOp(text) {
+		return errors.Errorf("expected %s", text)
+	}
+	p.i++
+	return nil
+}
+
+// binary parses the left associative operators ops between the operands
+// parsed by next.
+func (p *xpParser) binary(next func() (xpExpr, error), ops ...string) (xpExpr, error) {
+	l, err := next()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		var op string
+		for _, o := range ops {
+			if p.isOp(o) {
+				op = o
+			}
+		}
+		if len(op) == 0 {
+			return l, nil
+		}
+		p.i++
+		r, err := next()
+		if err != nil {
+			return nil, err
+		}
+		l = &xpBinary{op: op, l: l, r: r}
+	}
+}
+
+func (p *xpParser) or() (xpExpr, error) { return p.binary(p.and, "or") }
+
+func (p *xpParser) and() (xpExpr, error) { return p.binary(p.equality, "and") }
+
+func (p *xpParser) equality() (xpExpr, error) { return p.binary(p.relational, "=", "!=") }
+
+func (p *xpParser) relational() (xpExpr, error) {
+	return p.binary(p.additive, "<", "<=", ">", ">=")
+}
+
+func (p *xpParser) additive() (xpExpr, error) { return p.binary(p.multiplicative, "+", "-") }
+
+func (p *xpParser) multiplicative() (xpExpr, error) {
+	return p.binary(p.unary, "mul", "div", "mod")
+}
+
+func (p *xpParser) unary() (xpExpr, error) {
+	if p.isOp("-") {
+		p.i++
+		e, err := p.unary()
+		if err != nil {
+			return nil, err
+		}
+		return &xpNeg{e: e}, nil
+	}
+	return p.binary(p.path, "|")
+}
+
+// startsStep returns true if the next token starts a step of a location
+// path.
+func (p *xpParser) startsStep() bool {
+	t := p.peekAt(0)
+	switch t.kind {
+	case xpTokOp:
+		return t.text == "." || t.text == ".." || t.text == "@"
+	case xpTokName:
+		next := p.peekAt(1)
+		if next.kind == xpTokOp && next.text == "(" {
+			return t.text == "node" || t.text == "text" || t.text == "comment" ||
+				t.text == "processing-instruction"
+		}
+		return true
+	}
+	return false
+}
+
+func (p *xpParser) path() (xpExpr, error) {
+	if p.isOp("/") || p.isOp("//") || p.startsStep() {
+		path := &xpPath{}
+		if p.isOp("/") {
+			p.i++
+			path.abs = true
+			if !p.startsStep() {
+				return path, nil
+			}
+		} else if p.isOp("//") {
+			p.i++
+			path.abs = true
+			path.steps = append(path.steps, descendantOrSelf())
+		}
+		return path, p.steps(path)
+	}
+	e, err := p.primary()
+	if err != nil {
+		return nil, err
+	}
+	preds, err := p.predicates()
+	if err != nil {
+		return nil, err
+	}
+	if len(preds) > 0 {
+		e = &xpFilter{e: e, preds: preds}
+	}
+	if !p.isOp("/") && !p.isOp("//") {
+		return e, nil
+	}
+	path := &xpPath{filter: e}
+	if p.isOp("//") {
+		path.steps = append(path.steps, descendantOrSelf())
+	}
+	p.i++
+	return path, p.steps(path)
+}
+
+// descendantOrSelf returns the step of //.
+func descendantOrSelf() *xpStep {
+	return &xpStep{axis: "descendant-or-self", kind: "node"}
+}
+
+// steps parses the steps of a relative location path.
+func (p *xpParser) steps(path *xpPath) error {
+	for {
+		s, err := p.step()
+		if err != nil {
+			return err
+		}
+		path.steps = append(path.steps, s)
+		switch {
+		case p.isOp("/"):
+		case p.isOp("//"):
+			path.steps = append(path.steps, descendantOrSelf())
+		default:
+			return nil
+		}
+		p.i++
+	}
+}
+
+func (p *xpParser) step() (*xpStep, error) {
+	switch {
+	case p.isOp("."):
+		p.i++
+		return &xpStep{axis: "self", kind: "node"}, nil
+	case p.isOp(".."):
+		p.i++
+		return &xpStep{axis: "parent", kind: "node"}, nil
+	}
+	s := &xpStep{axis: "child"}
+	if p.isOp("@") {
+		p.i++
+		s.axis = "attribute"
+	} else if next := p.peekAt(1); p.peekAt(0).kind == xpTokName && next.kind == xpTokOp &&
+		next.text == "::" {
+		s.axis = p.peekAt(0).text
+		if !xpAxes[s.axis] {
+			return nil, errors.Errorf("unsupported axis %s", s.axis)
+		}
+		p.i += 2
+	}
+	t := p.peekAt(0)
+	if t.kind != xpTokName {
+		return nil, errors.New("expected a node test")
+	}
+	p.i++
+	if p.isOp("(") {
+		switch t.text {
+		case "node", "text", "comment":
+		default:
+			return nil, errors.Errorf("unsupported node test %s()", t.text)
+		}
+		p.i++
+		if err := p.expect(")"); err != nil {
+			return nil, err
+		}
+		s.kind = t.text
+	} else {
+		s.name = t.text
+	}
+	var err error
+	s.preds, err = p.predicates()
+	return s, err
+}
+
+func (p *xpParser) predicates() ([]xpExpr, error) {
+	var preds []xpExpr
+	for p.isOp("[") {
+		p.i++
+		e, err := p.or()
+		if err != nil {
+			return nil, err
+		}
+		if err := p.expect("]"); err != nil {
+			return nil, err
+		}
+		preds = append(preds, e)
+	}
+	return preds, nil
+}
+
+func (p *xpParser) primary() (xpExpr, error) {
+	t := p.peekAt(0)
+	p.i++
+	switch t.kind {
+	case xpTokVar:
+		return &xpVar{name: t.text}, nil
+	case xpTokLiteral:
+		return &xpLiteral{v: t.text}, nil
+	case xpTokNumber:
+		return &xpLiteral{v: t.num}, nil
+	case xpTokOp:
+		if t.text != "(" {
+			break
+		}
+		e, err := p.or()
+		if err != nil {
+			return nil, err
+		}
+		return e, p.expect(")")
+	case xpTokName:
+		if !p.isOp("(") {
+			break
+		}
+		arity, ok := xpFunctions[t.text]
+		if !ok {
+			return nil, errors.Errorf("unsupported function %s()", t.text)
+		}
+		p.i++
+		f := &xpFunc{name: t.text}
+		for !p.isOp(")") {
+			if len(f.args) > 0 {
+				if err := p.expect(","); err != nil {
+					return nil, err
+				}
+			}
+			arg, err := p.or()
+			if err != nil {
+				return nil, err
+			}
+			f.args = append(f.args, arg)
+		}
+		p.i++
+		if len(f.args) < arity[0] || arity[1] >= 0 && len(f.args) > arity[1] {
+			return nil, errors.Errorf("wrong number of arguments of %s()", t.text)
+		}
+		return f, nil
+	case -1:
+		return nil, errors.New("unexpected end")
+	}
+	return nil, errors.Errorf("unexpected %q", t.text)
+}
+
+type xpLiteral struct {
+	v interface{}
+}
+
+func (e *xpLiteral) eval(c *xpCtx) (interface{}, error) {
+	return e.v, nil
+}
+
+type xpVar struct {
+	name string
+}
+
+func (e *xpVar) eval(c *xpCtx) (interface{}, error) {
+	v, ok := c.vars.lookup(e.name)
+	if !ok {
+		return nil, errors.Errorf("undefined variable $%s", e.name)
+	}
+	return v, nil
+}
+
+type xpNeg struct {
+	e xpExpr
+}
+
+func (e *xpNeg) eval(c *xpCtx) (interface{}, error) {
+	v, err := e.e.eval(c)
+	if err != nil {
+		return nil, err
+	}
+	return -xpNumber(v), nil
+}
+
+type xpBinary struct {
+	op   string
+	l, r xpExpr
+}
+
+func (e *xpBinary) eval(c *xpCtx) (interface{}, error) {
+	l, err := e.l.eval(c)
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case e.op == "or" && xpBool(l):
+		return true, nil
+	case e.op == "and" && !xpBool(l):
+		return false, nil
+	}
+	r, err := e.r.eval(c)
+	if err != nil {
+		return nil, err
+	}
+	switch e.op {
+	case "or", "and":
+		return xpBool(r), nil
+	case "=", "!=", "<", "<=", ">", ">=":
+		return xpCompare(e.op, l, r), nil
+	case "|":
+		ln, lok := l.(xpNodes)
+		rn, rok := r.(xpNodes)
+		if !lok || !rok {
+			return nil, errors.New("| expects node-sets")
+		}
+		return xpUnion(ln, rn), nil
+	}
+	a, b := xpNumber(l), xpNumber(r)
+	switch e.op {
+	case "+":
+		return a + b, nil
+	case "-":
+		return a - b, nil
+	case "mul":
+		return a * b, nil
+	case "div":
+		return a / b, nil
+	}
+	return math.Mod(a, b), nil
+}
+
+// xpUnion returns the nodes of a and b in document order.
+func xpUnion(a, b xpNodes) xpNodes {
+	seen := make(map[*xtNode]bool, len(a)+len(b))
+	var out xpNodes
+	for _, n := range append(append(xpNodes{}, a...), b...) {
+		if !seen[n] {
+			seen[n] = true
+			out = append(out, n)
+		}
+	}
+	sortNodes(out)
+	return out
+}
+
+func sortNodes(nodes xpNodes) {
+	sort.Slice(nodes, func(i, j int) bool { return nodes[i].order < nodes[j].order })
+}
+
+// xpCompare compares the values as XPath 1.0 does: node-sets are compared by
+// the values of their nodes.
+func xpCompare(op string, l, r interface{}) bool {
+	ln, lok := l.(xpNodes)
+	rn, rok := r.(xpNodes)
+	switch {
+	case lok && rok:
+		for _, a := range ln {
+			for _, b := range rn {
+				if xpCompareAtoms(op, a.stringValue(), b.stringValue()) {
+					return true
+				}
+			}
+		}
+		return false
+	case lok:
+		return xpCompareNodes(op, ln, r)
+	case rok:
+		flipped := map[string]string{"<": ">", "<=": ">=", ">": "<", ">=": "<="}[op]
+		if len(flipped) == 0 {
+			flipped = op
+		}
+		return xpCompareNodes(flipped, rn, l)
+	}
+	return xpCompareAtoms(op, l, r)
+}
+
+// xpCompareNodes compares the nodes to v, which isn't a node-set.
+func xpCompareNodes(op string, nodes xpNodes, v interface{}) bool {
+	if b, ok := v.(bool); ok {
+		return xpCompareAtoms(op, len(nodes) > 0, b)
+	}
+	for _, n := range nodes {
+		var nv interface{} = n.stringValue()
+		if _, ok := v.(float64); ok {
+			nv = xpNumber(nv)
+		}
+		if xpCompareAtoms(op, nv, v) {
+			return true
+		}
+	}
+	return false
+}
+
+func xpCompareAtoms(op string, l, r interface{}) bool {
+	if op == "=" || op == "!=" {
+		var eq bool
+		_, lb := l.(bool)
+		_, rb := r.(bool)
+		_, lf := l.(float64)
+		_, rf := r.(float64)
+		switch {
+		case lb || rb:
+			eq = xpBool(l) == xpBool(r)
+		case lf || rf:
+			eq = xpNumber(l) == xpNumber(r)
+		default:
+			eq = xpString(l) == xpString(r)
+		}
+		return eq == (op == "=")
+	}
+	a, b := xpNumber(l), xpNumber(r)
+	switch op {
+	case "<":
+		return a < b
+	case "<=":
+		return a <= b
+	case ">":
+		return a > b
+	}
+	return a >= b
+}
+
+// xpString converts v to a string as the function string does.
+func xpString(v interface{}) string {
+	switch v := v.(type) {
+	case xpNodes:
+		if len(v) == 0 {
+			return ""
+		}
+		return v[0].stringValue()
+	case float64:
+		switch {
+		case math.IsNaN(v):
+			return "NaN"
+		case math.IsInf(v, 1):
+			return "Infinity"
+		case math.IsInf(v, -1):
+			return "-Infinity"
+		case v == 0:
+			return "0"
+		}
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	case bool:
+		return strconv.FormatBool(v)
+	}
+	return v.(string)
+}
+
+var xpNumberValueExpr = regexp.MustCompile(`^-?([0-9]+(\.[0-9]*)?|\.[0-9]+)$`)
+
+// xpNumber converts v to a number as the function number does.
+func xpNumber(v interface{}) float64 {
+	switch v := v.(type) {
+	case float64:
+		return v
+	case bool:
+		if v {
+			return 1
+		}
+		return 0
+	}
+	s := strings.Trim(xpString(v), " \t\r\n")
+	if !xpNumberValueExpr.MatchString(s) {
+		return math.NaN()
+	}
+	f, _ := strconv.ParseFloat(s, 64)
+	return f
+}
+
+// xpBool converts v to a boolean as the function boolean does.
+func xpBool(v interface{}) bool {
+	switch v := v.(type) {
+	case xpNodes:
+		return len(v) > 0
+	case float64:
+		return v != 0 && !math.IsNaN(v)
+	case bool:
+		return v
+	}
+	return len(v.(string)) > 0
+}
+
+// xpPath is a location path, or a filter expression followed by steps.
+type xpPath struct {
+	// filter is the expression the steps start from. They start from the
+	// context node, or its root if abs is set, if nil.
+	filter xpExpr
+	abs    bool
+	steps  []*xpStep
+}
+
+func (e *xpPath) eval(c *xpCtx) (interface{}, error) {
+	var nodes xpNodes
+	switch {
+	case e.filter != nil:
+		v, err := e.filter.eval(c)
+		if err != nil {
+			return nil, err
+		}
+		var ok bool
+		if nodes, ok = v.(xpNodes); !ok {
+			return nil, errors.New("/ expects a node-set")
+		}
+	case e.abs:
+		nodes = xpNodes{c.node.root()}
+	default:
+		nodes = xpNodes{c.node}
+	}
+	for _, s := range e.steps {
+		var err error
+		if nodes, err = s.apply(nodes, c); err != nil {
+			return nil, err
+		}
+	}
+	return nodes, nil
+}
+
+// xpStep is a step of a location path.
+type xpStep struct {
+	axis string
+	// name is the name tested, or * for any. kind is the node type tested
+	// instead, if set: node, text or comment.
+	name  string
+	kind  string
+	preds []xpExpr
+}
+
+// test returns true if n passes the node test of the step.
+func (s *xpStep) test(n *xtNode) bool {
+	switch s.kind {
+	case "node":
+		return true
+	case "text":
+		return n.kind == xtText
+	case "comment":
+		return n.kind == xtComment
+	}
+	principal := xtElement
+	if s.axis == "attribute" {
+		principal = xtAttribute
+	}
+	return n.kind == principal && (s.name == "*" || s.name == n.name)
+}
+
+// axis returns the nodes of the axis of the step from n, the closest first.
+func (s *xpStep) axisNodes(n *xtNode) []*xtNode {
+	var out []*xtNode
+	var descendants func(n *xtNode)
+	descendants = func(n *xtNode) {
+		for _, c := range n.children {
+			out = append(out, c)
+			descendants(c)
+		}
+	}
+	switch s.axis {
+	case "child":
+		return n.children
+	case "attribute":
+		return n.attrs
+	case "self":
+		return []*xtNode{n}
+	case "parent":
+		if n.parent != nil {
+			out = append(out, n.parent)
+		}
+	case "descendant-or-self":
+		out = append(out, n)
+		descendants(n)
+	case "descendant":
+		descendants(n)
+	case "ancestor-or-self":
+		out = append(out, n)
+		fallthrough
+	case "ancestor":
+		for a := n.parent; a != nil; a = a.parent {
+			out = append(out, a)
+		}
+	case "following-sibling", "preceding-sibling":
+		if n.parent == nil || n.kind == xtAttribute {
+			return nil
+		}
+		siblings := n.parent.children
+		i := 0
+		for siblings[i] != n {
+			i++
+		}
+		if s.axis == "following-sibling" {
+			return siblings[i+1:]
+		}
+		for j := i - 1; j >= 0; j-- {
+			out = append(out, siblings[j])
+		}
+	}
+	return out
+}
+
+// apply returns the nodes selected by the step from the nodes.
+func (s *xpStep) apply(nodes xpNodes, c *xpCtx) (xpNodes, error) {
+	var out xpNodes
+	seen := make(map[*xtNode]bool)
+	for _, n := range nodes {
+		var sel []*xtNode
+		for _, a := range s.axisNodes(n) {
+			if s.test(a) {
+				sel = append(sel, a)
+			}
+		}
+		var err error
+		if sel, err = filterNodes(sel, s.preds, c); err != nil {
+			return nil, err
+		}
+		for _, a := range sel {
+			if !seen[a] {
+				seen[a] = true
+				out = append(out, a)
+			}
+		}
+	}
+	sortNodes(out)
+	return out, nil
+}
+
+// filterNodes returns the nodes matching the predicates, their positions being
+// those in nodes.
+func filterNodes(nodes []*xtNode, preds []xpExpr, c *xpCtx) ([]*xtNode, error) {
+	for _, pred := range preds {
+		var out []*xtNode
+		for i, n := range nodes {
+			pc := &xpCtx{node: n, pos: i + 1, size: len(nodes), current: c.current, vars: c.vars}
+			v, err := pred.eval(pc)
+			if err != nil {
+				return nil, err
+			}
+			if f, ok := v.(float64); ok && f == float64(i+1) || !ok && xpBool(v) {
+				out = append(out, n)
+			}
+		}
+		nodes = out
+	}
+	return nodes, nil
+}
+
+// xpFilter filters a node-set with predicates.
+type xpFilter struct {
+	e     xpExpr
+	preds []xpExpr
+}
+
+func (e *xpFilter) eval(c *xpCtx) (interface{}, error) {
+	v, err := e.e.eval(c)
+	if err != nil {
+		return nil, err
+	}
+	nodes, ok := v.(xpNodes)
+	if !ok {
+		return nil, errors.New("predicates expect a node-set")
+	}
+	sel, err := filterNodes(nodes, e.preds, c)
+	return xpNodes(sel), err
+}
+
+type xpFunc struct {
+	name string
+	args []xpExpr
+}
+
+func (e *xpFunc) eval(c *xpCtx) (interface{}, error) {
+	args := make([]interface{}, len(e.args))
+	for i, a := range e.args {
+		var err error
+		if args[i], err = a.eval(c); err != nil {
+			return nil, err
+		}
+	}
+	// arg returns the i-th argument, the context node if it is missing.
+	arg := func(i int) interface{} {
+		if i < len(args) {
+			return args[i]
+		}
+		return xpNodes{c.node}
+	}
+	nodes := func(i int) (xpNodes, error) {
+		n, ok := arg(i).(xpNodes)
+		if !ok {
+			return nil, errors.Errorf("%s() expects a node-set", e.name)
+		}
+		return n, nil
+	}
+	str := func(i int) string { return xpString(arg(i)) }
+	switch e.name {
+	case "last":
+		return float64(c.size), nil
+	case "position":
+		return float64(c.pos), nil
+	case "count":
+		n, err := nodes(0)
+		return float64(len(n)), err
+	case "name", "local-name":
+		n, err := nodes(0)
+		if err != nil || len(n) == 0 {
+			return "", err
+		}
+		return n[0].name, nil
+	case "string":
+		return str(0), nil
+	case "concat":
+		var b strings.Builder
+		for i := range args {
+			b.WriteString(str(i))
+		}
+		return b.String(), nil
+	case "starts-with":
+		return strings.HasPrefix(str(0), str(1)), nil
+	case "contains":
+		return strings.Contains(str(0), str(1)), nil
+	case "substring-before":
+		if i := strings.Index(str(0), str(1)); i >= 0 {
+			return str(0)[:i], nil
+		}
+		return "", nil
+	case "substring-after":
+		if i := strings.Index(str(0), str(1)); i >= 0 {
+			return str(0)[i+len(str(1)):], nil
+		}
+		return "", nil
+	case "substring":
+		return xpSubstring(str(0), args[1:]), nil
+	case "string-length":
+		return float64(len([]rune(str(0)))), nil
+	case "normalize-space":
+		return strings.Join(strings.Fields(str(0)), " "), nil
+	case "translate":
+		return xpTranslate(str(0), str(1), str(2)), nil
+	case "boolean":
+		return xpBool(args[0]), nil
+	case "not":
+		return !xpBool(args[0]), nil
+	case "true":
+		return true, nil
+	case "false":
+		return false, nil
+	case "number":
+		return xpNumber(arg(0)), nil
+	case "sum":
+		n, err := nodes(0)
+		var sum float64
+		for _, node := range n {
+			sum += xpNumber(node.stringValue())
+		}
+		return sum, err
+	case "floor":
+		return math.Floor(xpNumber(args[0])), nil
+	case "ceiling":
+		return math.Ceil(xpNumber(args[0])), nil
+	case "round":
+		f := xpNumber(args[0])
+		if math.IsNaN(f) || math.IsInf(f, 0) {
+			return f, nil
+		}
+		return math.Floor(f + 0.5), nil
+	}
+	// current
+	return xpNodes{c.current}, nil
+}
+
+// xpSubstring returns the characters of s from the position start, rounded,
+// for length characters, as the function substring does.
+func xpSubstring(s string, args []interface{}) string {
+	runes := []rune(s)
+	start := math.Floor(xpNumber(args[0]) + 0.5)
+	end := math.Inf(1)
+	if len(args) > 1 {
+		end = start + math.Floor(xpNumber(args[1])+0.5)
+	}
+	var b strings.Builder
+	for i, r := range runes {
+		if p := float64(i + 1); p >= start && p < end {
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
+
+// xpTranslate replaces the characters of s found in from by the ones at the
+// same position in to, removing those past the end of to.
+func xpTranslate(s, from, to string) string {
+	f, t := []rune(from), []rune(to)
+	var b strings.Builder
+	for _, r := range s {
+		i := 0
+		for i < len(f) && f[i] != r {
+			i++
+		}
+		switch {
+		case i == len(f):
+			b.WriteRune(r)
+		case i < len(t):
+			b.WriteRune(t[i])
+		}
+	}
+	return b.String()
+}
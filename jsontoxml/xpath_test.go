@@ -0,0 +1,68 @@
+package jsontoxml
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestXPath(t *testing.T) {
+	doc, err := parseXTTree([]byte(`<r a="1"><i n="2">x</i><i n="10">y<b/></i><!--c--><j>3</j></r>`))
+	require.NoError(t, err)
+	vars := (*xpVars)(nil).with("v", 2.0)
+	for expr, want := range map[string]string{
+		"count(/r/i)":                       "2",
+		"/r/i[2]/@n":                        "10",
+		"//i[@n > 5]":                       "y",
+		"sum(//@n)":                         "12",
+		"name(/*)":                          "r",
+		"/r/i[last()]/following-sibling::j": "3",
+		"/r/j/preceding-sibling::i[1]/@n":   "10",
+		"count(//b/ancestor::*)":            "2",
+		"count(/r/node())":                  "4",
+		"count(//comment())":                "1",
+		"/r/*[self::j]":                     "3",
+		"(//i)[2]/@n":                       "10",
+		"count(//i[@n = '2'] | //j)":        "2",
+		"string-length(/r)":                 "3",
+		"concat('a', 1 + 2 * 3, true())":    "a7true",
+		"10 div 4":                          "2.5",
+		"7 mod 3":                           "1",
+		"-(2)":                              "-2",
+		"$v + 1":                            "3",
+		"substring('12345', 1.5, 2.6)":      "234",
+		"substring('12345', 3)":             "345",
+		"substring-after('a=b', '=')":       "b",
+		"translate('bar', 'abc', 'AB')":     "BAr",
+		"normalize-space('  a  b ')":        "a b",
+		"//i = 'y'":                         "true",
+		"//i != 'y'":                        "true",
+		"//@n < 2":                          "false",
+		"//@* < 2":                          "true",
+		"not(//i = 'z')":                    "true",
+		"//i[1] = //j":                      "false",
+		"string(1 div 0)":                   "Infinity",
+		"number('x')":                       "NaN",
+		"round(2.5)":                        "3",
+		"floor(-1.5)":                       "-2",
+		"starts-with(local-name(//@*[1]), 'a') and 1":     "true",
+		"boolean(/r/missing) or contains('abc', 'bc')":    "true",
+		"count(/descendant-or-self::node()/attribute::*)": "3",
+	} {
+		e, err := compileXPath(expr)
+		require.NoError(t, err, expr)
+		v, err := e.eval(&xpCtx{node: doc, pos: 1, size: 1, current: doc, vars: vars})
+		require.NoError(t, err, expr)
+		require.Equal(t, want, xpString(v), expr)
+	}
+
+	for _, expr := range []string{"", "a[", "foo()", "following::x", "1 +", "'abc", "$",
+		"count()", "processing-instruction()"} {
+		_, err := compileXPath(expr)
+		require.Error(t, err, expr)
+	}
+	e, err := compileXPath("$missing")
+	require.NoError(t, err)
+	_, err = e.eval(&xpCtx{node: doc})
+	require.EqualError(t, err, "undefined variable $missing")
+}
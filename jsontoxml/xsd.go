@@ -0,0 +1,984 @@
+package jsontoxml
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/pkg/errors"
+)
+
+// maxXSDErrors bounds the schema violations reported for a document.
+const maxXSDErrors = 10
+
+// Modes of --xsd-mode.
+const (
+	xsdFail = "fail"
+	xsdWarn = "warn"
+)
+
+// unbounded is the maxOccurs of the particles without a maximum.
+const unbounded = -1
+
+var xsdFile, xsdMode string
+
+func init() {
+	rootCmd.PersistentFlags().StringVar(&xsdFile, "xsd", "",
+		"XML Schema file every generated document is validated against, e.g. schema.xsd.")
+	rootCmd.PersistentFlags().StringVar(&xsdMode, "xsd-mode", xsdFail,
+		"What happens to the documents that don't conform to --xsd: fail fails their payload,"+
+			" warn writes them with a warning per violation.")
+}
+
+// xsdSchema is a compiled XML Schema. The namespaces are ignored: elements and
+// types are matched by their local names.
+type xsdSchema struct {
+	elements map[string]*xsdElement
+	types    map[string]*xsdType
+	// warn reports the violations as warnings instead of failing.
+	warn bool
+}
+
+// xsdElement is an element declaration.
+type xsdElement struct {
+	name string
+	typ  *xsdType
+}
+
+// xsdType is a simple or complex type. A complex type without content model
+// is empty, unless any is set.
+type xsdType struct {
+	name string
+	// simple is set for the simple types.
+	simple *xsdSimple
+	// any is set for xs:anyType, which accepts any content.
+	any bool
+	// text is the type of the text of the complex types with simple content.
+	text    *xsdType
+	mixed   bool
+	content *xsdParticle
+	attrs   []*xsdAttribute
+	anyAttr bool
+}
+
+// xsdSimple is a simple type: a built-in type, or a restriction of base.
+type xsdSimple struct {
+	builtin string
+	base    *xsdType
+	enum    []string
+	pattern []*regexp.Regexp
+	// length, minLength and maxLength are -1 if unset.
+	length, minLength, maxLength int
+	minIncl, maxIncl             *xsdBound
+	minExcl, maxExcl             *xsdBound
+}
+
+// xsdBound is a bound of the values of a simple type, with its text.
+type xsdBound struct {
+	r    *big.Rat
+	text string
+}
+
+type xsdAttribute struct {
+	name     string
+	typ      *xsdType
+	required bool
+}
+
+// xsdParticle is a term of a content model, with its occurrences.
+type xsdParticle struct {
+	// kind is element, sequence, choice, all or any.
+	kind     string
+	elem     *xsdElement
+	items    []*xsdParticle
+	min, max int
+}
+
+// xsdNode is an element of the schema document.
+type xsdNode struct {
+	XMLName  xml.Name
+	Attrs    []xml.Attr `xml:",any,attr"`
+	Children []*xsdNode `xml:",any"`
+}
+
+func (n *xsdNode) attr(name string) (string, bool) {
+	for _, a := range n.Attrs {
+		if a.Name.Space == "" && a.Name.Local == name {
+			return a.Value, true
+		}
+	}
+	return "", false
+}
+
+// xsdBuiltins are the built-in simple types that are checked.
+var xsdBuiltins = map[string]bool{
+	"anySimpleType": true, "string": true, "normalizedString": true, "token": true,
+	"language": true, "Name": true, "NCName": true, "ID": true, "IDREF": true, "NMTOKEN": true,
+	"anyURI": true, "QName": true, "boolean": true, "decimal": true, "float": true,
+	"double": true, "integer": true, "long": true, "int": true, "short": true, "byte": true,
+	"nonNegativeInteger": true, "positiveInteger": true, "nonPositiveInteger": true,
+	"negativeInteger": true, "unsignedLong": true, "unsignedInt": true, "unsignedShort": true,
+	"unsignedByte": true, "date": true, "dateTime": true, "time": true, "base64Binary": true,
+	"hexBinary": true,
+}
+
+// loadXSD reads and compiles the XML Schema file at p.
+func loadXSD(p string) (*xsdSchema, error) {
+	data, err := ioutil.ReadFile(p)
+	if err != nil {
+		return nil, errors.Wrap(err, "--xsd")
+	}
+	s, err := compileXSD(data)
+	return s, errors.Wrapf(err, "--xsd %s", p)
+}
+
+// xsdCompiler compiles a schema. The references to the named types and
+// elements are resolved once the whole schema is read, by fixups.
+type xsdCompiler struct {
+	s      *xsdSchema
+	fixups []func() error
+}
+
+// compileXSD compiles the schema document data. xs:import, xs:include,
+// xs:list, xs:union and the like are rejected.
+func compileXSD(data []byte) (*xsdSchema, error) {
+	var root xsdNode
+	if err := xml.Unmarshal(data, &root); err != nil {
+		return nil, errors.Wrap(err, "xml.Unmarshal")
+	}
+	if root.XMLName.Space != xsNamespace || root.XMLName.Local != "schema" {
+		return nil, errors.New("expected an xs:schema root element")
+	}
+	c := &xsdCompiler{s: &xsdSchema{
+		elements: make(map[string]*xsdElement),
+		types:    make(map[string]*xsdType),
+	}}
+	for _, n := range root.Children {
+		if !isXS(n) {
+			return nil, errors.Errorf("unexpected element %s", n.XMLName.Local)
+		}
+		switch n.XMLName.Local {
+		case "annotation":
+		case "element":
+			e, err := c.element(n)
+			if err != nil {
+				return nil, err
+			}
+			c.s.elements[e.name] = e
+		case "complexType", "simpleType":
+			name, ok := n.attr("name")
+			if !ok {
+				return nil, errors.Errorf("xs:%s without a name", n.XMLName.Local)
+			}
+			t, err := c.typ(n)
+			if err != nil {
+				return nil, err
+			}
+			t.name = name
+			c.s.types[name] = t
+		default:
+			return nil, errors.Errorf("unsupported xs:%s", n.XMLName.Local)
+		}
+	}
+	if len(c.s.elements) == 0 {
+		return nil, errors.New("no top-level xs:element")
+	}
+	for _, fix := range c.fixups {
+		if err := fix(); err != nil {
+			return nil, err
+		}
+	}
+	return c.s, nil
+}
+
+func isXS(n *xsdNode) bool {
+	return n.XMLName.Space == xsNamespace
+}
+
+// children returns the schema elements under n, without the annotations.
+func children(n *xsdNode) ([]*xsdNode, error) {
+	var out []*xsdNode
+	for _, c := range n.Children {
+		if !isXS(c) {
+			return nil, errors.Errorf("unexpected element %s in xs:%s", c.XMLName.Local,
+				n.XMLName.Local)
+		}
+		if c.XMLName.Local != "annotation" {
+			out = append(out, c)
+		}
+	}
+	return out, nil
+}
+
+// localName strips the prefix of a QName, e.g. xs:string.
+func localName(qname string) string {
+	return qname[strings.IndexByte(qname, ':')+1:]
+}
+
+// typeRef sets *t to the type named name once the schema is read.
+func (c *xsdCompiler) typeRef(name string, t **xsdType) {
+	c.fixups = append(c.fixups, func() error {
+		local := localName(name)
+		if named, ok := c.s.types[local]; ok {
+			*t = named
+			return nil
+		}
+		if local == "anyType" {
+			*t = &xsdType{name: local, any: true}
+			return nil
+		}
+		if xsdBuiltins[local] {
+			*t = &xsdType{name: local, simple: &xsdSimple{builtin: local, length: -1,
+				minLength: -1, maxLength: -1}}
+			return nil
+		}
+		return errors.Errorf("unknown or unsupported type %q", name)
+	})
+}
+
+// element compiles the element declaration n.
+func (c *xsdCompiler) element(n *xsdNode) (*xsdElement, error) {
+	name, ok := n.attr("name")
+	if !ok {
+		return nil, errors.New("xs:element without a name")
+	}
+	e := &xsdElement{name: name}
+	kids, err := children(n)
+	if err != nil {
+		return nil, err
+	}
+	typeName, hasType := n.attr("type")
+	switch {
+	case hasType && len(kids) > 0:
+		return nil, errors.Errorf("element %s: both a type and an inline type", name)
+	case hasType:
+		c.typeRef(typeName, &e.typ)
+	case len(kids) == 1 && (kids[0].XMLName.Local == "complexType" ||
+		kids[0].XMLName.Local == "simpleType"):
+		if e.typ, err = c.typ(kids[0]); err != nil {
+			return nil, errors.Wrapf(err, "element %s", name)
+		}
+	case len(kids) == 0:
+		e.typ = &xsdType{name: "anyType", any: true}
+	default:
+		return nil, errors.Errorf("element %s: unsupported content", name)
+	}
+	return e, nil
+}
+
+// typ compiles the xs:complexType or xs:simpleType n.
+func (c *xsdCompiler) typ(n *xsdNode) (*xsdType, error) {
+	kids, err := children(n)
+	if err != nil {
+		return nil, err
+	}
+	if n.XMLName.Local == "simpleType" {
+		if len(kids) != 1 || kids[0].XMLName.Local != "restriction" {
+			return nil, errors.New("xs:simpleType: only xs:restriction is supported")
+		}
+		s, err := c.restriction(kids[0])
+		if err != nil {
+			return nil, err
+		}
+		return &xsdType{simple: s}, nil
+	}
+	t := &xsdType{}
+	if mixed, _ := n.attr("mixed"); mixed == "true" {
+		t.mixed = true
+	}
+	for _, k := range kids {
+		switch k.XMLName.Local {
+		case "sequence", "choice", "all":
+			if t.content != nil {
+				return nil, errors.New("xs:complexType: several content models")
+			}
+			if t.content, err = c.particle(k); err != nil {
+				return nil, err
+			}
+		case "attribute":
+			a, err := c.attribute(k)
+			if err != nil {
+				return nil, err
+			}
+			if a != nil {
+				t.attrs = append(t.attrs, a)
+			}
+		case "anyAttribute":
+			t.anyAttr = true
+		case "simpleContent":
+			if err := c.simpleContent(t, k); err != nil {
+				return nil, err
+			}
+		default:
+			return nil, errors.Errorf("xs:complexType: unsupported xs:%s", k.XMLName.Local)
+		}
+	}
+	return t, nil
+}
+
+// simpleContent compiles the xs:extension of a simple content into t.
+func (c *xsdCompiler) simpleContent(t *xsdType, n *xsdNode) error {
+	kids, err := children(n)
+	if err != nil {
+		return err
+	}
+	if len(kids) != 1 || kids[0].XMLName.Local != "extension" {
+		return errors.New("xs:simpleContent: only xs:extension is supported")
+	}
+	base, ok := kids[0].attr("base")
+	if !ok {
+		return errors.New("xs:extension without a base")
+	}
+	c.typeRef(base, &t.text)
+	exts, err := children(kids[0])
+	if err != nil {
+		return err
+	}
+	for _, k := range exts {
+		switch k.XMLName.Local {
+		case "attribute":
+			a, err := c.attribute(k)
+			if err != nil {
+				return err
+			}
+			if a != nil {
+				t.attrs = append(t.attrs, a)
+			}
+		case "anyAttribute":
+			t.anyAttr = true
+		default:
+			return errors.Errorf("xs:extension: unsupported xs:%s", k.XMLName.Local)
+		}
+	}
+	c.fixups = append(c.fixups, func() error {
+		if t.text.simple == nil {
+			return errors.Errorf("xs:extension: base %s isn't a simple type", base)
+		}
+		return nil
+	})
+	return nil
+}
+
+// attribute compiles the xs:attribute n.
+func (c *xsdCompiler) attribute(n *xsdNode) (*xsdAttribute, error) {
+	name, ok := n.attr("name")
+	if !ok {
+		return nil, errors.New("xs:attribute without a name")
+	}
+	a := &xsdAttribute{name: name}
+	use, _ := n.attr("use")
+	switch use {
+	case "", "optional":
+	case "required":
+		a.required = true
+	case "prohibited":
+		// A prohibited attribute is an undeclared one.
+		return nil, nil
+	default:
+		return nil, errors.Errorf("attribute %s: invalid use %q", name, use)
+	}
+	kids, err := children(n)
+	if err != nil {
+		return nil, err
+	}
+	typeName, hasType := n.attr("type")
+	switch {
+	case hasType && len(kids) == 0:
+		c.typeRef(typeName, &a.typ)
+		c.fixups = append(c.fixups, func() error {
+			if a.typ.simple == nil {
+				return errors.Errorf("attribute %s: %s isn't a simple type", name, typeName)
+			}
+			return nil
+		})
+	case !hasType && len(kids) == 1 && kids[0].XMLName.Local == "simpleType":
+		if a.typ, err = c.typ(kids[0]); err != nil {
+			return nil, errors.Wrapf(err, "attribute %s", name)
+		}
+	case !hasType && len(kids) == 0:
+		c.typeRef("anySimpleType", &a.typ)
+	default:
+		return nil, errors.Errorf("attribute %s: unsupported content", name)
+	}
+	return a, nil
+}
+
+// restriction compiles the xs:restriction n of a simple type.
+func (c *xsdCompiler) restriction(n *xsdNode) (*xsdSimple, error) {
+	s := &xsdSimple{length: -1, minLength: -1, maxLength: -1}
+	base, ok := n.attr("base")
+	if !ok {
+		return nil, errors.New("xs:restriction without a base")
+	}
+	c.typeRef(base, &s.base)
+	c.fixups = append(c.fixups, func() error {
+		if s.base.simple == nil {
+			return errors.Errorf("xs:restriction: base %s isn't a simple type", base)
+		}
+		return nil
+	})
+	kids, err := children(n)
+	if err != nil {
+		return nil, err
+	}
+	for _, k := range kids {
+		facet := k.XMLName.Local
+		value, ok := k.attr("value")
+		if !ok {
+			return nil, errors.Errorf("xs:%s without a value", facet)
+		}
+		switch facet {
+		case "enumeration":
+			s.enum = append(s.enum, value)
+		case "pattern":
+			// The patterns match the whole value.
+			re, err := regexp.Compile("^(?:" + value + ")$")
+			if err != nil {
+				return nil, errors.Wrapf(err, "xs:pattern %q", value)
+			}
+			s.pattern = append(s.pattern, re)
+		case "length", "minLength", "maxLength":
+			l, err := strconv.Atoi(value)
+			if err != nil || l < 0 {
+				return nil, errors.Errorf("xs:%s: invalid value %q", facet, value)
+			}
+			switch facet {
+			case "length":
+				s.length = l
+			case "minLength":
+				s.minLength = l
+			default:
+				s.maxLength = l
+			}
+		case "minInclusive", "maxInclusive", "minExclusive", "maxExclusive":
+			r, ok := new(big.Rat).SetString(value)
+			if !ok {
+				return nil, errors.Errorf("xs:%s: invalid value %q", facet, value)
+			}
+			b := &xsdBound{r: r, text: value}
+			switch facet {
+			case "minInclusive":
+				s.minIncl = b
+			case "maxInclusive":
+				s.maxIncl = b
+			case "minExclusive":
+				s.minExcl = b
+			default:
+				s.maxExcl = b
+			}
+		case "whiteSpace":
+		default:
+			return nil, errors.Errorf("xs:restriction: unsupported xs:%s", facet)
+		}
+	}
+	return s, nil
+}
+
+// occurs returns the minOccurs and maxOccurs of n, 1 by default.
+func occurs(n *xsdNode) (min, max int, err error) {
+	min, max = 1, 1
+	if v, ok := n.attr("minOccurs"); ok {
+		if min, err = strconv.Atoi(v); err != nil || min < 0 {
+			return 0, 0, errors.Errorf("invalid minOccurs %q", v)
+		}
+	}
+	if v, ok := n.attr("maxOccurs"); ok {
+		if v == "unbounded" {
+			return min, unbounded, nil
+		}
+		if max, err = strconv.Atoi(v); err != nil || max < min {
+			return 0, 0, errors.Errorf("invalid maxOccurs %q", v)
+		}
+	}
+	return min, max, nil
+}
+
+// particle compiles the particle n of a content model.
+func (c *xsdCompiler) particle(n *xsdNode) (*xsdParticle, error) {
+	min, max, err := occurs(n)
+	if err != nil {
+		return nil, err
+	}
+	p := &xsdParticle{kind: n.XMLName.Local, min: min, max: max}
+	switch p.kind {
+	case "element":
+		if ref, ok := n.attr("ref"); ok {
+			p.elem = &xsdElement{name: localName(ref)}
+			c.fixups = append(c.fixups, func() error {
+				e, ok := c.s.elements[p.elem.name]
+				if !ok {
+					return errors.Errorf("unknown element %q", ref)
+				}
+				p.elem = e
+				return nil
+			})
+			return p, nil
+		}
+		p.elem, err = c.element(n)
+		return p, err
+	case "any":
+		return p, nil
+	case "sequence", "choice", "all":
+		kids, err := children(n)
+		if err != nil {
+			return nil, err
+		}
+		for _, k := range kids {
+			item, err := c.particle(k)
+			if err != nil {
+				return nil, err
+			}
+			if p.kind == "all" && item.kind != "element" {
+				return nil, errors.New("xs:all can only hold elements")
+			}
+			p.items = append(p.items, item)
+		}
+		return p, nil
+	}
+	return nil, errors.Errorf("unsupported xs:%s in a content model", p.kind)
+}
+
+// xsdErrors collects the violations of a document, up to maxXSDErrors.
+type xsdErrors []string
+
+func (e *xsdErrors) add(path, format string, args ...interface{}) {
+	if len(*e) < maxXSDErrors {
+		*e = append(*e, path+": "+fmt.Sprintf(format, args...))
+	}
+}
+
+// validate returns the violations of the schema by the xml document data.
+func (s *xsdSchema) validate(data []byte) []string {
+	root, err := parseXMLTree(bytes.NewReader(data))
+	if err != nil {
+		return []string{err.Error()}
+	}
+	var errs xsdErrors
+	path := "/" + root.name
+	e, ok := s.elements[root.name]
+	if !ok {
+		errs.add(path, "no declaration of the root element")
+		return errs
+	}
+	s.checkElement(e.typ, root, path, &errs)
+	return errs
+}
+
+// isSpecialAttr returns true for the namespace declarations and the xsi
+// attributes, which aren't declared by the schemas.
+func isSpecialAttr(a xml.Attr) bool {
+	return a.Name.Space == "xmlns" || a.Name.Space == "" && a.Name.Local == "xmlns" ||
+		a.Name.Space == xsiNamespace
+}
+
+// isNil returns true if the element has xsi:nil="true".
+func isNil(n *xnode) bool {
+	for _, a := range n.attrList {
+		if a.Name.Space == xsiNamespace && a.Name.Local == "nil" {
+			return a.Value == "true"
+		}
+	}
+	return false
+}
+
+// checkElement adds the violations of the type t by the element n at path,
+// e.g. /records/jsonData[2], to errs.
+func (s *xsdSchema) checkElement(t *xsdType, n *xnode, path string, errs *xsdErrors) {
+	if t.any {
+		return
+	}
+	nilled := isNil(n)
+	if t.simple != nil {
+		if len(n.children) > 0 {
+			errs.add(path, "unexpected child element %s", n.children[0].name)
+		}
+		for _, a := range n.attrList {
+			if !isSpecialAttr(a) {
+				errs.add(path, "unexpected attribute %s", a.Name.Local)
+			}
+		}
+		if !nilled {
+			t.simple.check(n.text.String(), path, errs)
+		}
+		return
+	}
+	s.checkAttrs(t, n, path, errs)
+	if nilled {
+		return
+	}
+	if t.text != nil {
+		if len(n.children) > 0 {
+			errs.add(path, "unexpected child element %s", n.children[0].name)
+		}
+		t.text.simple.check(n.text.String(), path, errs)
+		return
+	}
+	if !t.mixed && len(strings.TrimSpace(n.text.String())) > 0 {
+		errs.add(path, "unexpected text")
+	}
+	names := make([]string, len(n.children))
+	for i, c := range n.children {
+		names[i] = c.name
+	}
+	if !t.content.accepts(names) {
+		errs.add(path, "unexpected child elements %s, expected %s", strings.Join(names, ", "),
+			t.content)
+		return
+	}
+	index := make(map[string]int)
+	for _, c := range n.children {
+		index[c.name]++
+		if e := t.content.find(c.name); e != nil {
+			s.checkElement(e.typ, c, fmt.Sprintf("%s/%s[%d]", path, c.name, index[c.name]), errs)
+		}
+	}
+}
+
+func (s *xsdSchema) checkAttrs(t *xsdType, n *xnode, path string, errs *xsdErrors) {
+	seen := make(map[string]bool)
+	for _, a := range n.attrList {
+		if isSpecialAttr(a) {
+			continue
+		}
+		seen[a.Name.Local] = true
+		var decl *xsdAttribute
+		for _, d := range t.attrs {
+			if d.name == a.Name.Local {
+				decl = d
+			}
+		}
+		if decl == nil {
+			if !t.anyAttr {
+				errs.add(path, "unexpected attribute %s", a.Name.Local)
+			}
+			continue
+		}
+		decl.typ.simple.check(a.Value, path+"/@"+a.Name.Local, errs)
+	}
+	for _, d := range t.attrs {
+		if d.required && !seen[d.name] {
+			errs.add(path, "missing required attribute %s", d.name)
+		}
+	}
+}
+
+// accepts returns true if the content model p, nil for the empty content,
+// matches the child element names.
+func (p *xsdParticle) accepts(names []string) bool {
+	if p == nil {
+		return len(names) == 0
+	}
+	for _, end := range p.match(names, 0) {
+		if end == len(names) {
+			return true
+		}
+	}
+	return false
+}
+
+// match returns the positions in names where the matches of the particle,
+// with its occurrences, starting at pos can end.
+func (p *xsdParticle) match(names []string, pos int) []int {
+	current := map[int]bool{pos: true}
+	var ends []int
+	if p.min == 0 {
+		ends = append(ends, pos)
+	}
+	for n := 1; p.max == unbounded || n <= p.max; n++ {
+		next := make(map[int]bool)
+		for start := range current {
+			for _, end := range p.matchOnce(names, start) {
+				// A match of nothing can't repeat.
+				if end > start || n <= p.min {
+					next[end] = true
+				}
+			}
+		}
+		if len(next) == 0 {
+			break
+		}
+		if n >= p.min {
+			for end := range next {
+				ends = append(ends, end)
+			}
+		}
+		current = next
+	}
+	return ends
+}
+
+// matchOnce returns the positions where a single occurrence of the particle
+// starting at pos can end.
+func (p *xsdParticle) matchOnce(names []string, pos int) []int {
+	switch p.kind {
+	case "element":
+		if pos < len(names) && names[pos] == p.elem.name {
+			return []int{pos + 1}
+		}
+	case "any":
+		if pos < len(names) {
+			return []int{pos + 1}
+		}
+	case "sequence":
+		positions := []int{pos}
+		for _, item := range p.items {
+			seen := make(map[int]bool)
+			var next []int
+			for _, start := range positions {
+				for _, end := range item.match(names, start) {
+					if !seen[end] {
+						seen[end] = true
+						next = append(next, end)
+					}
+				}
+			}
+			positions = next
+		}
+		return positions
+	case "choice":
+		var ends []int
+		for _, item := range p.items {
+			ends = append(ends, item.match(names, pos)...)
+		}
+		return ends
+	case "all":
+		// Every element appears at most once, in any order.
+		used := make(map[string]bool)
+		end := pos
+		for end < len(names) && !used[names[end]] {
+			known := false
+			for _, item := range p.items {
+				known = known || item.elem.name == names[end]
+			}
+			if !known {
+				break
+			}
+			used[names[end]] = true
+			end++
+		}
+		for _, item := range p.items {
+			if item.min > 0 && !used[item.elem.name] {
+				return nil
+			}
+		}
+		return []int{end}
+	}
+	return nil
+}
+
+// find returns the declaration of the child element name in the content
+// model, or nil if it is matched by xs:any.
+func (p *xsdParticle) find(name string) *xsdElement {
+	if p.kind == "element" {
+		if p.elem.name == name {
+			return p.elem
+		}
+		return nil
+	}
+	for _, item := range p.items {
+		if e := item.find(name); e != nil {
+			return e
+		}
+	}
+	return nil
+}
+
+// String describes the content model, e.g. (Id, FirstName?, Tag*).
+func (p *xsdParticle) String() string {
+	if p == nil {
+		return "no child element"
+	}
+	var s string
+	switch p.kind {
+	case "element":
+		s = p.elem.name
+	case "any":
+		s = "any"
+	default:
+		sep := ", "
+		switch p.kind {
+		case "choice":
+			sep = " | "
+		case "all":
+			sep = " & "
+		}
+		items := make([]string, len(p.items))
+		for i, item := range p.items {
+			items[i] = item.String()
+		}
+		s = "(" + strings.Join(items, sep) + ")"
+	}
+	switch {
+	case p.min == 0 && p.max == 1:
+		s += "?"
+	case p.min == 0 && p.max == unbounded:
+		s += "*"
+	case p.min == 1 && p.max == unbounded:
+		s += "+"
+	case p.min != 1 || p.max != 1:
+		s += fmt.Sprintf("{%d,%d}", p.min, p.max)
+	}
+	return s
+}
+
+var (
+	xsdDecimalExpr = regexp.MustCompile(`^[+-]?(\d+(\.\d*)?|\.\d+)$`)
+	xsdIntegerExpr = regexp.MustCompile(`^[+-]?\d+$`)
+	xsdFloatExpr   = regexp.MustCompile(`^([+-]?(\d+(\.\d*)?|\.\d+)([eE][+-]?\d+)?|[+-]?INF|NaN)$`)
+	// xsdIntegerRanges are the bounds of the bounded integer types.
+	xsdIntegerRanges = map[string][2]string{
+		"long":               {"-9223372036854775808", "9223372036854775807"},
+		"int":                {"-2147483648", "2147483647"},
+		"short":              {"-32768", "32767"},
+		"byte":               {"-128", "127"},
+		"nonNegativeInteger": {"0", ""},
+		"positiveInteger":    {"1", ""},
+		"nonPositiveInteger": {"", "0"},
+		"negativeInteger":    {"", "-1"},
+		"unsignedLong":       {"0", "18446744073709551615"},
+		"unsignedInt":        {"0", "4294967295"},
+		"unsignedShort":      {"0", "65535"},
+		"unsignedByte":       {"0", "255"},
+	}
+)
+
+// builtinType returns the built-in type the simple type derives from.
+func (s *xsdSimple) builtinType() string {
+	if s.base != nil {
+		return s.base.simple.builtinType()
+	}
+	return s.builtin
+}
+
+// check adds the violations of the simple type by the text v at path to
+// errs.
+func (s *xsdSimple) check(v, path string, errs *xsdErrors) {
+	builtin := s.builtinType()
+	if builtin != "string" && builtin != "anySimpleType" {
+		v = strings.Join(strings.Fields(v), " ")
+	}
+	if s.base != nil {
+		n := len(*errs)
+		s.base.simple.check(v, path, errs)
+		if len(*errs) > n {
+			return
+		}
+	} else if !validBuiltin(s.builtin, v) {
+		errs.add(path, "%q is not a valid %s", v, s.builtin)
+		return
+	}
+	if s.enum != nil {
+		ok := false
+		for _, e := range s.enum {
+			ok = ok || e == v
+		}
+		if !ok {
+			errs.add(path, "%q is not one of %s", v, strings.Join(s.enum, ", "))
+		}
+	}
+	for _, re := range s.pattern {
+		if !re.MatchString(v) {
+			errs.add(path, "%q doesn't match %s", v, re)
+		}
+	}
+	n := utf8.RuneCountInString(v)
+	switch {
+	case s.length >= 0 && n != s.length:
+		errs.add(path, "%q isn't %d characters long", v, s.length)
+	case s.minLength >= 0 && n < s.minLength:
+		errs.add(path, "%q is shorter than %d characters", v, s.minLength)
+	case s.maxLength >= 0 && n > s.maxLength:
+		errs.add(path, "%q is longer than %d characters", v, s.maxLength)
+	}
+	if s.minIncl == nil && s.maxIncl == nil && s.minExcl == nil && s.maxExcl == nil {
+		return
+	}
+	r, ok := new(big.Rat).SetString(v)
+	if !ok {
+		return
+	}
+	switch {
+	case s.minIncl != nil && r.Cmp(s.minIncl.r) < 0:
+		errs.add(path, "%s is less than %s", v, s.minIncl.text)
+	case s.maxIncl != nil && r.Cmp(s.maxIncl.r) > 0:
+		errs.add(path, "%s is greater than %s", v, s.maxIncl.text)
+	case s.minExcl != nil && r.Cmp(s.minExcl.r) <= 0:
+		errs.add(path, "%s is not greater than %s", v, s.minExcl.text)
+	case s.maxExcl != nil && r.Cmp(s.maxExcl.r) >= 0:
+		errs.add(path, "%s is not less than %s", v, s.maxExcl.text)
+	}
+}
+
+// validBuiltin returns true if v is a value of the built-in type.
+func validBuiltin(typ, v string) bool {
+	switch typ {
+	case "boolean":
+		return v == "true" || v == "false" || v == "1" || v == "0"
+	case "decimal":
+		return xsdDecimalExpr.MatchString(v)
+	case "float", "double":
+		return xsdFloatExpr.MatchString(v)
+	case "date":
+		return parsesAs(v, "2006-01-02", "2006-01-02Z07:00")
+	case "dateTime":
+		return parsesAs(v, "2006-01-02T15:04:05.999999999", "2006-01-02T15:04:05.999999999Z07:00")
+	case "time":
+		return parsesAs(v, "15:04:05.999999999", "15:04:05.999999999Z07:00")
+	case "base64Binary":
+		_, err := base64.StdEncoding.DecodeString(strings.Join(strings.Fields(v), ""))
+		return err == nil
+	case "hexBinary":
+		_, err := hex.DecodeString(v)
+		return err == nil
+	case "integer":
+		return xsdIntegerExpr.MatchString(v)
+	}
+	if bounds, ok := xsdIntegerRanges[typ]; ok {
+		n, ok := new(big.Int).SetString(strings.TrimPrefix(v, "+"), 10)
+		if !ok || !xsdIntegerExpr.MatchString(v) {
+			return false
+		}
+		if min, ok := new(big.Int).SetString(bounds[0], 10); ok && n.Cmp(min) < 0 {
+			return false
+		}
+		if max, ok := new(big.Int).SetString(bounds[1], 10); ok && n.Cmp(max) > 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// parsesAs returns true if v parses with one of the time layouts.
+func parsesAs(v string, layouts ...string) bool {
+	for _, layout := range layouts {
+		if _, err := time.Parse(layout, v); err == nil {
+			return true
+		}
+	}
+	return false
+}
+
+// checkXSD validates the generated document data against the --xsd schema.
+// The violations fail the document, or are warnings with --xsd-mode warn.
+func (o *convertOptions) checkXSD(data []byte) error {
+	errs := o.xsd.validate(data)
+	if len(errs) == 0 {
+		return nil
+	}
+	if !o.xsd.warn {
+		return errors.Errorf("xsd: %s", strings.Join(errs, "; "))
+	}
+	for _, e := range errs {
+		o.warn("xsd: %s", e)
+	}
+	return nil
+}
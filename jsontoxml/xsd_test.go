@@ -0,0 +1,147 @@
+package jsontoxml
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+const testXSD = `<?xml version="1.0"?>
+<xs:schema xmlns:xs="http://www.w3.org/2001/XMLSchema">
+  <xs:element name="records">
+    <xs:complexType>
+      <xs:sequence>
+        <xs:element ref="jsonData" minOccurs="0" maxOccurs="unbounded"/>
+      </xs:sequence>
+    </xs:complexType>
+  </xs:element>
+  <xs:element name="jsonData" type="Person"/>
+  <xs:complexType name="Person">
+    <xs:annotation><xs:documentation>A person.</xs:documentation></xs:annotation>
+    <xs:sequence>
+      <xs:element name="Id" type="xs:positiveInteger"/>
+      <xs:element name="name" minOccurs="0">
+        <xs:complexType>
+          <xs:all>
+            <xs:element name="first" type="xs:string"/>
+            <xs:element name="last" type="xs:string" minOccurs="0"/>
+          </xs:all>
+        </xs:complexType>
+      </xs:element>
+      <xs:element name="City" type="xs:string"/>
+      <xs:element name="State" type="State"/>
+    </xs:sequence>
+    <xs:attribute name="id" type="xs:string"/>
+  </xs:complexType>
+  <xs:simpleType name="State">
+    <xs:restriction base="xs:token">
+      <xs:pattern value="[A-Z]{2}|"/>
+    </xs:restriction>
+  </xs:simpleType>
+</xs:schema>`
+
+func TestXSD(t *testing.T) {
+	s, err := compileXSD([]byte(testXSD))
+	require.NoError(t, err)
+	opts := &convertOptions{xsd: s}
+	var buf bytes.Buffer
+	require.NoError(t, jsonToXml([]byte(`[{"id": 1, "firstName": "a", "state": "CA"},
+		{"id": 2, "lastName": "b"}]`), &buf, opts))
+	require.Contains(t, buf.String(), "<Id>2</Id>")
+
+	require.Empty(t, s.validate([]byte(`<records/>`)))
+	require.Equal(t, []string{
+		`/records/jsonData[1]/Id[1]: "0" is not a valid positiveInteger`,
+		`/records/jsonData[1]/State[1]: "California" doesn't match ^(?:[A-Z]{2}|)$`,
+		`/records/jsonData[2]: unexpected child elements Id, State, City, expected (Id, name?,` +
+			` City, State)`,
+		`/records/jsonData[3]: unexpected attribute ref`,
+		`/records/jsonData[3]/name[1]: unexpected child elements last, last, expected` +
+			` (first & last?)`,
+	}, s.validate([]byte(`<records>
+ <jsonData><Id>0</Id><City/><State>California</State></jsonData>
+ <jsonData><Id>2</Id><State/><City/></jsonData>
+ <jsonData ref="x"><Id>3</Id><name><last/><last/></name><City/><State/></jsonData>
+</records>`)))
+	require.Equal(t, []string{"/person: no declaration of the root element"},
+		s.validate([]byte(`<person/>`)))
+
+	err = jsonToXml([]byte(`{"id": 1, "state": "California"}`), &buf, opts)
+	require.Error(t, err)
+	require.Contains(t, err.Error(), `xsd: /jsonData/State[1]: "California" doesn't match`)
+
+	// In warn mode the document is written with warnings.
+	s.warn = true
+	opts.warnings = &docWarnings{}
+	buf.Reset()
+	require.NoError(t, jsonToXml([]byte(`{"id": 1, "state": "California"}`), &buf, opts))
+	require.Contains(t, buf.String(), "<State>California</State>")
+	require.Len(t, opts.warnings.messages(), 1)
+}
+
+func TestXSDTypes(t *testing.T) {
+	s, err := compileXSD([]byte(`<xs:schema xmlns:xs="http://www.w3.org/2001/XMLSchema">
+  <xs:element name="v">
+    <xs:complexType>
+      <xs:choice maxOccurs="unbounded">
+        <xs:element name="b" type="xs:boolean"/>
+        <xs:element name="d" type="xs:date"/>
+        <xs:element name="t" type="xs:dateTime"/>
+        <xs:element name="n">
+          <xs:simpleType>
+            <xs:restriction base="xs:decimal">
+              <xs:minInclusive value="0"/>
+              <xs:maxExclusive value="10.5"/>
+            </xs:restriction>
+          </xs:simpleType>
+        </xs:element>
+        <xs:element name="e">
+          <xs:complexType>
+            <xs:simpleContent>
+              <xs:extension base="xs:byte">
+                <xs:attribute name="unit" type="xs:string" use="required"/>
+              </xs:extension>
+            </xs:simpleContent>
+          </xs:complexType>
+        </xs:element>
+        <xs:any/>
+      </xs:choice>
+    </xs:complexType>
+  </xs:element>
+</xs:schema>`))
+	require.NoError(t, err)
+	require.Empty(t, s.validate([]byte(`<v xmlns:xsi="http://www.w3.org/2001/XMLSchema-instance">
+  <b>true</b><d>2023-01-01</d><t>2023-01-01T10:00:00.5Z</t><n> 10.25 </n><e unit="m">-3</e>
+  <other><x/></other><n xsi:nil="true"></n>
+</v>`)))
+	require.Equal(t, []string{
+		`/v/b[1]: "yes" is not a valid boolean`,
+		`/v/d[1]: "2023-02-30" is not a valid date`,
+		`/v/t[1]: "2023-01-01" is not a valid dateTime`,
+		`/v/n[1]: 10.5 is not less than 10.5`,
+		`/v/e[1]: missing required attribute unit`,
+		`/v/e[1]: "300" is not a valid byte`,
+	}, s.validate([]byte(`<v><b>yes</b><d>2023-02-30</d><t>2023-01-01</t><n>10.5</n>`+
+		`<e>300</e></v>`)))
+}
+
+func TestXSDInvalid(t *testing.T) {
+	for _, schema := range []string{
+		`<schema/>`,
+		`<xs:schema xmlns:xs="http://www.w3.org/2001/XMLSchema"/>`,
+		`<xs:schema xmlns:xs="http://www.w3.org/2001/XMLSchema">
+  <xs:import namespace="urn:x"/><xs:element name="a"/></xs:schema>`,
+		`<xs:schema xmlns:xs="http://www.w3.org/2001/XMLSchema">
+  <xs:element name="a" type="Missing"/></xs:schema>`,
+		`<xs:schema xmlns:xs="http://www.w3.org/2001/XMLSchema">
+  <xs:element name="a"><xs:simpleType><xs:list itemType="xs:int"/></xs:simpleType></xs:element>
+</xs:schema>`,
+		`<xs:schema xmlns:xs="http://www.w3.org/2001/XMLSchema">
+  <xs:element name="a"><xs:complexType><xs:sequence>
+    <xs:element ref="b"/></xs:sequence></xs:complexType></xs:element></xs:schema>`,
+	} {
+		_, err := compileXSD([]byte(schema))
+		require.Error(t, err, schema)
+	}
+}
@@ -0,0 +1,135 @@
+package jsontoxml
+
+import (
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"io"
+	"reflect"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	xsiNamespace = "http://www.w3.org/2001/XMLSchema-instance"
+	xsNamespace  = "http://www.w3.org/2001/XMLSchema"
+)
+
+// xsiNamespaceAttrs declare the namespaces used by the xsi:type annotations. They
+// are added to the root element.
+var xsiNamespaceAttrs = []xml.Attr{
+	{Name: xml.Name{Local: "xmlns:xsi"}, Value: xsiNamespace},
+	{Name: xml.Name{Local: "xmlns:xs"}, Value: xsNamespace},
+}
+
+// xsiType returns the xsi:type attribute for a value of the xml schema type.
+func xsiType(typ string) xml.Attr {
+	return xml.Attr{Name: xml.Name{Local: "xsi:type"}, Value: typ}
+}
+
+// xsiNil marks an element as nil.
+var xsiNil = xml.Attr{Name: xml.Name{Local: "xsi:nil"}, Value: "true"}
+
+// jsonXSIType returns the xsi:type attribute of a decoded json scalar. ok is
+// false for objects and arrays, which aren't annotated.
+func jsonXSIType(v interface{}) (attr xml.Attr, ok bool) {
+	switch val := v.(type) {
+	case string:
+		return xsiType("xs:string"), true
+	case json.Number:
+		if strings.ContainsAny(val.String(), ".eE") {
+			return xsiType("xs:decimal"), true
+		}
+		return xsiType("xs:integer"), true
+	case bool:
+		return xsiType("xs:boolean"), true
+	case nil:
+		return xsiNil, true
+	}
+	return xml.Attr{}, false
+}
+
+// kindXSIType returns the xml schema type of a go kind.
+func kindXSIType(k reflect.Kind) string {
+	switch k {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return "xs:integer"
+	case reflect.Float32, reflect.Float64:
+		return "xs:decimal"
+	case reflect.Bool:
+		return "xs:boolean"
+	case reflect.String:
+		return "xs:string"
+	}
+	return ""
+}
+
+// structXSITypes returns the xml schema type of every leaf element of the struct
+// type t, keyed by the element path relative to the root (e.g. "name>first").
+func structXSITypes(t reflect.Type) map[string]string {
+	types := make(map[string]string)
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if len(f.PkgPath) > 0 || f.Name == "XMLName" {
+			continue
+		}
+		name := f.Name
+		tag := strings.Split(f.Tag.Get("xml"), ",")
+		if tag[0] == "-" || len(tag) > 1 && tag[1] != "" {
+			// Attributes, chardata and the like aren't elements.
+			continue
+		}
+		if len(tag[0]) > 0 {
+			name = tag[0]
+		}
+		if typ := kindXSIType(f.Type.Kind()); len(typ) > 0 {
+			types[name] = typ
+		}
+	}
+	return types
+}
+
+// addXSITypes annotates the leaf elements of the marshaled xml with their
+// xsi:type and, if declare is set, declares the namespaces on the root element.
+// The element paths in types are relative to the record elements, which are at
+// depth skip (1 if the record is the root element).
+func addXSITypes(data []byte, types map[string]string, skip int, declare bool) ([]byte, error) {
+	dec := xml.NewDecoder(bytes.NewReader(data))
+	var buf bytes.Buffer
+	enc := xml.NewEncoder(&buf)
+	var path []string
+	for {
+		tok, err := dec.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, errors.Wrap(err, "xsi")
+		}
+		switch t := tok.(type) {
+		case xml.StartElement:
+			if len(path) == 0 && declare {
+				t.Attr = append(t.Attr, xsiNamespaceAttrs...)
+			}
+			if len(path) >= skip {
+				typ, ok := types[strings.Join(append(path[skip:], t.Name.Local), ">")]
+				if ok {
+					t.Attr = append(t.Attr, xsiType(typ))
+				}
+			}
+			path = append(path, t.Name.Local)
+			tok = t
+		case xml.EndElement:
+			path = path[:len(path)-1]
+		}
+		if err := enc.EncodeToken(tok); err != nil {
+			return nil, errors.Wrap(err, "xsi")
+		}
+	}
+	if err := enc.Flush(); err != nil {
+		return nil, errors.Wrap(err, "xsi")
+	}
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,48 @@
+package jsontoxml
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestXSITypesStruct(t *testing.T) {
+	jdata := []byte(`{"id": 10, "first_name": "firstname", "last_name":"lastname"}`)
+	buf := &bytes.Buffer{}
+	require.NoError(t, jsonToXml(jdata, buf, &convertOptions{xsiTypes: true}))
+	res := ` <jsonData xmlns:xsi="http://www.w3.org/2001/XMLSchema-instance" xmlns:xs="http://www.w3.org/2001/XMLSchema">
+  <Id xsi:type="xs:integer">10</Id>
+  <name>
+   <first xsi:type="xs:string">firstname</first>
+   <last xsi:type="xs:string">lastname</last>
+  </name>
+  <City xsi:type="xs:string"></City>
+  <State xsi:type="xs:string"></State>
+ </jsonData>`
+	require.Equal(t, res, buf.String())
+}
+
+func TestXSITypesGeneric(t *testing.T) {
+	jdata := []byte(`{"a": 1, "b": 1.5, "c": true, "d": null, "e": ["x"]}`)
+	buf := &bytes.Buffer{}
+	opts := &convertOptions{lossless: true, xsiTypes: true}
+	require.NoError(t, jsonToXml(jdata, buf, opts))
+	res := ` <jsonData xmlns:xsi="http://www.w3.org/2001/XMLSchema-instance" xmlns:xs="http://www.w3.org/2001/XMLSchema" type="object">
+  <a type="number" xsi:type="xs:integer">1</a>
+  <b type="number" xsi:type="xs:decimal">1.5</b>
+  <c type="bool" xsi:type="xs:boolean">true</c>
+  <d type="null" xsi:nil="true"></d>
+  <e type="array">
+   <item xsi:type="xs:string">x</item>
+  </e>
+ </jsonData>`
+	require.Equal(t, res, buf.String())
+}
+
+func TestXSITypesArray(t *testing.T) {
+	buf := &bytes.Buffer{}
+	require.NoError(t, jsonToXml([]byte(`[{"id": 1}]`), buf, &convertOptions{xsiTypes: true}))
+	require.Contains(t, buf.String(), ` <records xmlns:xsi=`)
+	require.Contains(t, buf.String(), `<Id xsi:type="xs:integer">1</Id>`)
+}
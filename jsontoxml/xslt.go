@@ -0,0 +1,1153 @@
+package jsontoxml
+
+import (
+	"bytes"
+	"encoding/xml"
+	"io/ioutil"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// xslNS is the namespace of the xslt instructions.
+const xslNS = "http://www.w3.org/1999/XSL/Transform"
+
+// maxXSLTDepth bounds the nesting of the templates, e.g. of a recursive
+// template that never ends.
+const maxXSLTDepth = 5000
+
+var xsltFile string
+
+func init() {
+	rootCmd.PersistentFlags().StringVar(&xsltFile, "xslt", "",
+		"XSLT 1.0 stylesheet every converted document is transformed with before it is"+
+			" written, e.g. transform.xsl.")
+}
+
+// xsltStylesheet is a compiled XSLT 1.0 stylesheet. The namespaces of the
+// documents are ignored, as in XPath expressions, and the result is written
+// without them: the stylesheets creating elements or attributes in a
+// namespace are rejected. It is safe for concurrent use.
+type xsltStylesheet struct {
+	rules []*xsltRule
+	named map[string]*xsltTemplate
+	// globals are the top-level variables and parameters, in order.
+	globals []*xsltInstr
+	// indent is set by <xsl:output indent="yes"/>.
+	indent bool
+	// strip and preserve list the elements of xsl:strip-space and
+	// xsl:preserve-space.
+	strip, preserve []string
+}
+
+// xsltTemplate is an xsl:template.
+type xsltTemplate struct {
+	params []*xsltInstr
+	body   []*xsltInstr
+}
+
+// xsltRule is an alternative of the match pattern of a template.
+type xsltRule struct {
+	pattern  *xpPath
+	mode     string
+	priority float64
+	// index is the position of the template in the stylesheet: the last
+	// template wins among those with the highest priority.
+	index int
+	t     *xsltTemplate
+}
+
+// xsltInstr is an instruction of a template: op is the name of the xsl
+// element, literal for the literal result elements and text for their text.
+type xsltInstr struct {
+	op string
+	// name is the name of literal result elements, variables, parameters
+	// and named templates.
+	name string
+	// avt is the name of xsl:element and xsl:attribute.
+	avt   xsltAVT
+	attrs []xsltAttr
+	text  string
+	// expr is the select or test expression.
+	expr   xpExpr
+	mode   string
+	sorts  []*xsltSort
+	params []*xsltInstr
+	body   []*xsltInstr
+	// whens are the branches of xsl:choose, xsl:otherwise having no expr.
+	whens []*xsltInstr
+}
+
+// xsltAttr is an attribute of a literal result element.
+type xsltAttr struct {
+	name  string
+	value xsltAVT
+}
+
+// xsltSort is an xsl:sort.
+type xsltSort struct {
+	expr       xpExpr
+	descending bool
+	number     bool
+}
+
+// xsltAVT is an attribute value template: a text with expressions in braces.
+type xsltAVT []xsltAVTPart
+
+// xsltAVTPart is a text, or an expression if expr is set.
+type xsltAVTPart struct {
+	text string
+	expr xpExpr
+}
+
+// compileAVT compiles the attribute value template s, e.g. item-{@id}.
+func compileAVT(s string) (xsltAVT, error) {
+	var avt xsltAVT
+	var text strings.Builder
+	flush := func() {
+		if text.Len() > 0 {
+			avt = append(avt, xsltAVTPart{text: text.String()})
+			text.Reset()
+		}
+	}
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; {
+		case (c == '{' || c == '}') && i+1 < len(s) && s[i+1] == c:
+			text.WriteByte(c)
+			i++
+		case c == '{':
+			end := strings.IndexByte(s[i:], '}')
+			if end < 0 {
+				return nil, errors.Errorf("unclosed { in %q", s)
+			}
+			e, err := compileXPath(s[i+1 : i+end])
+			if err != nil {
+				return nil, err
+			}
+			flush()
+			avt = append(avt, xsltAVTPart{expr: e})
+			i += end
+		case c == '}':
+			return nil, errors.Errorf("unexpected } in %q", s)
+		default:
+			text.WriteByte(c)
+		}
+	}
+	flush()
+	return avt, nil
+}
+
+func (a xsltAVT) eval(c *xpCtx) (string, error) {
+	var b strings.Builder
+	for _, part := range a {
+		if part.expr == nil {
+			b.WriteString(part.text)
+			continue
+		}
+		v, err := part.expr.eval(c)
+		if err != nil {
+			return "", err
+		}
+		b.WriteString(xpString(v))
+	}
+	return b.String(), nil
+}
+
+// loadXSLT reads and compiles the stylesheet at p.
+func loadXSLT(p string) (*xsltStylesheet, error) {
+	data, err := ioutil.ReadFile(p)
+	if err != nil {
+		return nil, errors.Wrap(err, "--xslt")
+	}
+	s, err := compileXSLT(data)
+	return s, errors.Wrapf(err, "--xslt %s", p)
+}
+
+// compileXSLT compiles the subset of XSLT 1.0 made of the templates (named
+// or matching patterns, with modes, priorities and parameters), the top-level
+// variables and parameters, xsl:output, xsl:strip-space, xsl:preserve-space
+// and the instructions apply-templates, call-template, for-each, sort,
+// value-of, copy-of, copy, element, attribute, text, comment, if, choose,
+// variable and with-param. The other elements, e.g. xsl:import, xsl:key or
+// xsl:number, are rejected.
+func compileXSLT(data []byte) (*xsltStylesheet, error) {
+	doc, err := parseXTTree(data)
+	if err != nil {
+		return nil, err
+	}
+	root := doc.element()
+	if root.space != xslNS || root.name != "stylesheet" && root.name != "transform" {
+		return nil, errors.New("expected an xsl:stylesheet root element")
+	}
+	s := &xsltStylesheet{named: make(map[string]*xsltTemplate)}
+	for i, n := range root.children {
+		switch {
+		case n.kind == xtText && len(strings.TrimSpace(n.value)) > 0:
+			return nil, errors.Errorf("unexpected text %q", strings.TrimSpace(n.value))
+		case n.kind != xtElement || n.space != xslNS:
+			// Top-level elements of other namespaces are ignored.
+			continue
+		}
+		switch n.name {
+		case "template":
+			if err := s.addTemplate(n, i); err != nil {
+				return nil, err
+			}
+		case "variable", "param":
+			v, err := compileVariable(n)
+			if err != nil {
+				return nil, err
+			}
+			s.globals = append(s.globals, v)
+		case "output":
+			if method, ok := n.attr("method"); ok && method != "xml" {
+				return nil, errors.Errorf("unsupported output method %q", method)
+			}
+			indent, _ := n.attr("indent")
+			s.indent = indent == "yes"
+		case "strip-space", "preserve-space":
+			elements, _ := n.attr("elements")
+			var names []string
+			for _, name := range strings.Fields(elements) {
+				names = append(names, name[strings.IndexByte(name, ':')+1:])
+			}
+			if n.name == "strip-space" {
+				s.strip = append(s.strip, names...)
+			} else {
+				s.preserve = append(s.preserve, names...)
+			}
+		default:
+			return nil, errors.Errorf("unsupported xsl:%s", n.name)
+		}
+	}
+	return s, nil
+}
+
+// addTemplate compiles the template n, at index i of the stylesheet.
+func (s *xsltStylesheet) addTemplate(n *xtNode, i int) error {
+	match, hasMatch := n.attr("match")
+	name, hasName := n.attr("name")
+	if !hasMatch && !hasName {
+		return errors.New("xsl:template without match nor name")
+	}
+	t := &xsltTemplate{}
+	children := n.children
+	for len(children) > 0 {
+		c := children[0]
+		if c.kind == xtElement && (c.space != xslNS || c.name != "param") ||
+			c.kind == xtText && len(strings.TrimSpace(c.value)) > 0 {
+			break
+		}
+		if c.kind == xtElement {
+			p, err := compileVariable(c)
+			if err != nil {
+				return err
+			}
+			t.params = append(t.params, p)
+		}
+		children = children[1:]
+	}
+	var err error
+	if t.body, err = compileBody(children); err != nil {
+		return errors.Wrapf(err, "xsl:template %s", strings.TrimSpace(match+" "+name))
+	}
+	if hasName {
+		if s.named[name] != nil {
+			return errors.Errorf("duplicate xsl:template %s", name)
+		}
+		s.named[name] = t
+	}
+	if !hasMatch {
+		return nil
+	}
+	paths, err := compilePattern(match)
+	if err != nil {
+		return err
+	}
+	mode, _ := n.attr("mode")
+	for _, p := range paths {
+		r := &xsltRule{pattern: p, mode: mode, priority: defaultPriority(p), index: i, t: t}
+		if prio, ok := n.attr("priority"); ok {
+			if r.priority, err = strconv.ParseFloat(strings.TrimSpace(prio), 64); err != nil {
+				return errors.Errorf("invalid priority %q", prio)
+			}
+		}
+		s.rules = append(s.rules, r)
+	}
+	return nil
+}
+
+// compilePattern compiles the alternatives of a match pattern: location paths
+// made of child and attribute steps, separated by / or //.
+func compilePattern(expr string) ([]*xpPath, error) {
+	e, err := compileXPath(expr)
+	if err != nil {
+		return nil, err
+	}
+	var paths []*xpPath
+	var collect func(e xpExpr) bool
+	collect = func(e xpExpr) bool {
+		switch e := e.(type) {
+		case *xpBinary:
+			return e.op == "|" && collect(e.l) && collect(e.r)
+		case *xpPath:
+			if e.filter != nil {
+				return false
+			}
+			for _, s := range e.steps {
+				if s.axis != "child" && s.axis != "attribute" && !s.isDescendantOrSelf() {
+					return false
+				}
+			}
+			paths = append(paths, e)
+			return true
+		}
+		return false
+	}
+	if !collect(e) {
+		return nil, errors.Errorf("invalid pattern %q", expr)
+	}
+	return paths, nil
+}
+
+// isDescendantOrSelf returns true for the step of //.
+func (s *xpStep) isDescendantOrSelf() bool {
+	return s.axis == "descendant-or-self" && s.kind == "node" && len(s.preds) == 0
+}
+
+// defaultPriority returns the priority of a pattern without priority
+// attribute.
+func defaultPriority(p *xpPath) float64 {
+	if p.abs || len(p.steps) != 1 || len(p.steps[0].preds) > 0 {
+		return 0.5
+	}
+	if s := p.steps[0]; len(s.kind) > 0 || s.name == "*" {
+		return -0.5
+	}
+	return 0
+}
+
+// compileVariable compiles an xsl:variable, xsl:param or xsl:with-param.
+func compileVariable(n *xtNode) (*xsltInstr, error) {
+	name, ok := n.attr("name")
+	if !ok {
+		return nil, errors.Errorf("xsl:%s without name", n.name)
+	}
+	in := &xsltInstr{op: n.name, name: name}
+	var err error
+	if sel, ok := n.attr("select"); ok {
+		if in.expr, err = compileXPath(sel); err != nil {
+			return nil, err
+		}
+		return in, nil
+	}
+	in.body, err = compileBody(n.children)
+	return in, err
+}
+
+// compileBody compiles the instructions of a template. The texts made of
+// whitespace are stripped.
+func compileBody(nodes []*xtNode) ([]*xsltInstr, error) {
+	var body []*xsltInstr
+	for _, n := range nodes {
+		switch n.kind {
+		case xtText:
+			if len(strings.TrimSpace(n.value)) > 0 {
+				body = append(body, &xsltInstr{op: "text", text: n.value})
+			}
+			continue
+		case xtElement:
+		default:
+			continue
+		}
+		in, err := compileInstr(n)
+		if err != nil {
+			return nil, err
+		}
+		body = append(body, in)
+	}
+	return body, nil
+}
+
+// compileInstr compiles the instruction or literal result element n.
+func compileInstr(n *xtNode) (*xsltInstr, error) {
+	in := &xsltInstr{op: n.name}
+	// expr compiles the required or optional attribute as an expression.
+	expr := func(attr string, required bool) error {
+		v, ok := n.attr(attr)
+		if !ok {
+			if required {
+				return errors.Errorf("xsl:%s without %s", n.name, attr)
+			}
+			return nil
+		}
+		var err error
+		in.expr, err = compileXPath(v)
+		return err
+	}
+	var err error
+	if n.space != xslNS {
+		// The result is written without namespaces: the literal result
+		// elements in one would silently lose it.
+		if len(n.space) > 0 {
+			return nil, errors.Errorf("literal result element %s in namespace %q: namespaces"+
+				" aren't supported", n.name, n.space)
+		}
+		in.op, in.name = "literal", n.name
+		for _, a := range n.attrs {
+			if a.space == xslNS {
+				continue
+			}
+			if len(a.space) > 0 {
+				return nil, errors.Errorf("attribute %s of %s in namespace %q: namespaces aren't"+
+					" supported", a.name, n.name, a.space)
+			}
+			avt, err := compileAVT(a.value)
+			if err != nil {
+				return nil, err
+			}
+			in.attrs = append(in.attrs, xsltAttr{name: a.name, value: avt})
+		}
+		in.body, err = compileBody(n.children)
+		return in, err
+	}
+	switch n.name {
+	case "apply-templates", "for-each":
+		if err := expr("select", n.name == "for-each"); err != nil {
+			return nil, err
+		}
+		in.mode, _ = n.attr("mode")
+		var rest []*xtNode
+		for _, c := range n.children {
+			switch {
+			case c.kind == xtElement && c.space == xslNS && c.name == "sort":
+				s := &xsltSort{}
+				sel, ok := c.attr("select")
+				if !ok {
+					sel = "."
+				}
+				if s.expr, err = compileXPath(sel); err != nil {
+					return nil, err
+				}
+				order, _ := c.attr("order")
+				dataType, _ := c.attr("data-type")
+				s.descending, s.number = order == "descending", dataType == "number"
+				in.sorts = append(in.sorts, s)
+			case c.kind == xtElement && c.space == xslNS && c.name == "with-param" &&
+				n.name == "apply-templates":
+				p, err := compileVariable(c)
+				if err != nil {
+					return nil, err
+				}
+				in.params = append(in.params, p)
+			default:
+				rest = append(rest, c)
+			}
+		}
+		if n.name == "apply-templates" {
+			if _, err := compileBody(rest); err != nil || hasElements(rest) {
+				return nil, errors.New("xsl:apply-templates can only hold xsl:sort and xsl:with-param")
+			}
+			return in, nil
+		}
+		in.body, err = compileBody(rest)
+	case "call-template":
+		var ok bool
+		if in.name, ok = n.attr("name"); !ok {
+			return nil, errors.New("xsl:call-template without name")
+		}
+		for _, c := range n.children {
+			if c.kind != xtElement {
+				continue
+			}
+			if c.space != xslNS || c.name != "with-param" {
+				return nil, errors.New("xsl:call-template can only hold xsl:with-param")
+			}
+			p, err := compileVariable(c)
+			if err != nil {
+				return nil, err
+			}
+			in.params = append(in.params, p)
+		}
+	case "value-of", "copy-of":
+		err = expr("select", true)
+		if v, _ := n.attr("disable-output-escaping"); v == "yes" {
+			return nil, errors.New("disable-output-escaping isn't supported")
+		}
+	case "if":
+		if err := expr("test", true); err != nil {
+			return nil, err
+		}
+		in.body, err = compileBody(n.children)
+	case "choose":
+		for _, c := range n.children {
+			if c.kind != xtElement {
+				continue
+			}
+			if c.space != xslNS || c.name != "when" && c.name != "otherwise" {
+				return nil, errors.New("xsl:choose can only hold xsl:when and xsl:otherwise")
+			}
+			when := &xsltInstr{op: c.name}
+			if c.name == "when" {
+				test, ok := c.attr("test")
+				if !ok {
+					return nil, errors.New("xsl:when without test")
+				}
+				if when.expr, err = compileXPath(test); err != nil {
+					return nil, err
+				}
+			}
+			if when.body, err = compileBody(c.children); err != nil {
+				return nil, err
+			}
+			in.whens = append(in.whens, when)
+		}
+	case "element", "attribute":
+		name, ok := n.attr("name")
+		if !ok {
+			return nil, errors.Errorf("xsl:%s without name", n.name)
+		}
+		if _, ok := n.attr("namespace"); ok {
+			return nil, errors.Errorf("xsl:%s with namespace: namespaces aren't supported", n.name)
+		}
+		if in.avt, err = compileAVT(name); err != nil {
+			return nil, err
+		}
+		in.body, err = compileBody(n.children)
+	case "text":
+		for _, c := range n.children {
+			if c.kind != xtText {
+				return nil, errors.New("xsl:text can only hold text")
+			}
+			in.text += c.value
+		}
+		if v, _ := n.attr("disable-output-escaping"); v == "yes" {
+			return nil, errors.New("disable-output-escaping isn't supported")
+		}
+	case "copy", "comment":
+		in.body, err = compileBody(n.children)
+	case "variable":
+		return compileVariable(n)
+	case "param":
+		return nil, errors.New("xsl:param must come first in xsl:template")
+	default:
+		return nil, errors.Errorf("unsupported xsl:%s", n.name)
+	}
+	return in, err
+}
+
+func hasElements(nodes []*xtNode) bool {
+	for _, n := range nodes {
+		if n.kind == xtElement {
+			return true
+		}
+	}
+	return false
+}
+
+// transform returns the document data transformed by the stylesheet. The
+// result must have a single root element.
+func (s *xsltStylesheet) transform(data []byte) ([]byte, error) {
+	doc, err := parseXTTree(data)
+	if err != nil {
+		return nil, err
+	}
+	s.stripSpace(doc)
+	r := &xsltRun{s: s}
+	c := &xpCtx{node: doc, pos: 1, size: 1, current: doc}
+	for _, g := range s.globals {
+		v, err := r.value(g, c)
+		if err != nil {
+			return nil, errors.Wrapf(err, "xsl:%s %s", g.op, g.name)
+		}
+		c.vars = c.vars.with(g.name, v)
+	}
+	r.globals = c.vars
+	out := &xtNode{kind: xtRoot}
+	if err := r.applyTemplates(xpNodes{doc}, "", nil, out); err != nil {
+		return nil, err
+	}
+	return s.serialize(out)
+}
+
+// stripSpace removes the texts made of whitespace of the elements listed by
+// xsl:strip-space and not by xsl:preserve-space.
+func (s *xsltStylesheet) stripSpace(n *xtNode) {
+	if len(s.strip) == 0 {
+		return
+	}
+	has := func(list []string, name string) bool {
+		for _, l := range list {
+			if l == name {
+				return true
+			}
+		}
+		return false
+	}
+	strip := n.kind == xtElement && !has(s.preserve, n.name) &&
+		(has(s.strip, n.name) || has(s.strip, "*") && !has(s.preserve, "*"))
+	children := n.children[:0]
+	for _, c := range n.children {
+		if strip && c.kind == xtText && len(strings.Trim(c.value, " \t\r\n")) == 0 {
+			continue
+		}
+		s.stripSpace(c)
+		children = append(children, c)
+	}
+	n.children = children
+}
+
+// xsltRun is the state of a transformation.
+type xsltRun struct {
+	s *xsltStylesheet
+	// globals holds the top-level variables and parameters.
+	globals *xpVars
+	depth   int
+	// order numbers the nodes of the result trees.
+	order int
+}
+
+// match returns the template of the node in the mode, or nil if there is
+// none.
+func (r *xsltRun) match(n *xtNode, mode string) (*xsltTemplate, error) {
+	var best *xsltRule
+	for _, rule := range r.s.rules {
+		if rule.mode != mode || best != nil && (rule.priority < best.priority ||
+			rule.priority == best.priority && rule.index < best.index) {
+			continue
+		}
+		ok, err := r.matches(n, rule.pattern.steps, rule.pattern.abs)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			best = rule
+		}
+	}
+	if best == nil {
+		return nil, nil
+	}
+	return best.t, nil
+}
+
+// matches returns true if n matches the steps of a pattern, read from the
+// last one.
+func (r *xsltRun) matches(n *xtNode, steps []*xpStep, abs bool) (bool, error) {
+	if len(steps) == 0 {
+		return !abs || n.kind == xtRoot, nil
+	}
+	last := steps[len(steps)-1]
+	if last.isDescendantOrSelf() {
+		for a := n; a != nil; a = a.parent {
+			if ok, err := r.matches(a, steps[:len(steps)-1], abs); ok || err != nil {
+				return ok, err
+			}
+		}
+		return false, nil
+	}
+	if n.parent == nil || !last.test(n) || (last.axis == "attribute") != (n.kind == xtAttribute) {
+		return false, nil
+	}
+	if len(last.preds) > 0 {
+		sel, err := last.apply(xpNodes{n.parent}, &xpCtx{current: n, vars: r.globals})
+		if err != nil {
+			return false, err
+		}
+		found := false
+		for _, s := range sel {
+			found = found || s == n
+		}
+		if !found {
+			return false, nil
+		}
+	}
+	return r.matches(n.parent, steps[:len(steps)-1], abs)
+}
+
+// applyTemplates processes the nodes with their templates, or the built-in
+// ones: the children of the root and the elements are processed, the text of
+// the texts and attributes is copied.
+func (r *xsltRun) applyTemplates(nodes xpNodes, mode string, params map[string]interface{},
+	out *xtNode) error {
+	for i, n := range nodes {
+		t, err := r.match(n, mode)
+		if err != nil {
+			return err
+		}
+		if t != nil {
+			c := &xpCtx{node: n, pos: i + 1, size: len(nodes), current: n}
+			if err := r.call(t, c, params, out); err != nil {
+				return err
+			}
+			continue
+		}
+		switch n.kind {
+		case xtRoot, xtElement:
+			if err := r.applyTemplates(n.children, mode, nil, out); err != nil {
+				return err
+			}
+		case xtText, xtAttribute:
+			r.appendText(out, n.value)
+		}
+	}
+	return nil
+}
+
+// call processes the node of c with the template. Its body sees the global
+// variables and its parameters, set to params or their default value.
+func (r *xsltRun) call(t *xsltTemplate, c *xpCtx, params map[string]interface{},
+	out *xtNode) error {
+	if r.depth++; r.depth > maxXSLTDepth {
+		return errors.Errorf("more than %d nested templates", maxXSLTDepth)
+	}
+	defer func() { r.depth-- }()
+	tc := *c
+	tc.vars = r.globals
+	for _, p := range t.params {
+		v, ok := params[p.name]
+		if !ok {
+			var err error
+			if v, err = r.value(p, &tc); err != nil {
+				return err
+			}
+		}
+		tc.vars = tc.vars.with(p.name, v)
+	}
+	return r.execBody(t.body, tc, out)
+}
+
+// value returns the value of a variable or parameter: its select expression,
+// or the result tree of its body.
+func (r *xsltRun) value(in *xsltInstr, c *xpCtx) (interface{}, error) {
+	if in.expr != nil {
+		return in.expr.eval(c)
+	}
+	if len(in.body) == 0 {
+		return "", nil
+	}
+	frag := &xtNode{kind: xtRoot}
+	if err := r.execBody(in.body, *c, frag); err != nil {
+		return nil, err
+	}
+	return xpNodes{frag}, nil
+}
+
+// params returns the values of the xsl:with-param, by name.
+func (r *xsltRun) params(with []*xsltInstr, c *xpCtx) (map[string]interface{}, error) {
+	params := make(map[string]interface{}, len(with))
+	for _, p := range with {
+		v, err := r.value(p, c)
+		if err != nil {
+			return nil, err
+		}
+		params[p.name] = v
+	}
+	return params, nil
+}
+
+// text returns the text of the result of the body.
+func (r *xsltRun) text(body []*xsltInstr, c *xpCtx) (string, error) {
+	frag := &xtNode{kind: xtRoot}
+	if err := r.execBody(body, *c, frag); err != nil {
+		return "", err
+	}
+	return frag.stringValue(), nil
+}
+
+// execBody executes the instructions. The variables are visible to the
+// instructions following them.
+func (r *xsltRun) execBody(body []*xsltInstr, c xpCtx, out *xtNode) error {
+	for _, in := range body {
+		if in.op == "variable" {
+			v, err := r.value(in, &c)
+			if err != nil {
+				return errors.Wrapf(err, "xsl:variable %s", in.name)
+			}
+			c.vars = c.vars.with(in.name, v)
+			continue
+		}
+		if err := r.exec(in, &c, out); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// eval evaluates the expression of the instruction.
+func (r *xsltRun) eval(in *xsltInstr, c *xpCtx) (interface{}, error) {
+	v, err := in.expr.eval(c)
+	return v, errors.Wrapf(err, "xsl:%s", in.op)
+}
+
+// nodes evaluates the expression of the instruction to a node-set, sorted by
+// the xsl:sort of the instruction.
+func (r *xsltRun) nodes(in *xsltInstr, c *xpCtx) (xpNodes, error) {
+	nodes := xpNodes(c.node.children)
+	if in.expr != nil {
+		v, err := r.eval(in, c)
+		if err != nil {
+			return nil, err
+		}
+		var ok bool
+		if nodes, ok = v.(xpNodes); !ok {
+			return nil, errors.Errorf("xsl:%s: select must be a node-set", in.op)
+		}
+	}
+	if len(in.sorts) == 0 {
+		return nodes, nil
+	}
+	keys := make([][]interface{}, len(nodes))
+	for i, n := range nodes {
+		sc := &xpCtx{node: n, pos: i + 1, size: len(nodes), current: n, vars: c.vars}
+		for _, s := range in.sorts {
+			v, err := s.expr.eval(sc)
+			if err != nil {
+				return nil, errors.Wrap(err, "xsl:sort")
+			}
+			if s.number {
+				keys[i] = append(keys[i], xpNumber(v))
+			} else {
+				keys[i] = append(keys[i], xpString(v))
+			}
+		}
+	}
+	idx := make([]int, len(nodes))
+	for i := range idx {
+		idx[i] = i
+	}
+	sort.SliceStable(idx, func(a, b int) bool {
+		for k, s := range in.sorts {
+			cmp := compareSortKeys(keys[idx[a]][k], keys[idx[b]][k])
+			if s.descending {
+				cmp = -cmp
+			}
+			if cmp != 0 {
+				return cmp < 0
+			}
+		}
+		return false
+	})
+	sorted := make(xpNodes, len(nodes))
+	for i, j := range idx {
+		sorted[i] = nodes[j]
+	}
+	return sorted, nil
+}
+
+// compareSortKeys compares two keys of xsl:sort. NaN comes before the other
+// numbers.
+func compareSortKeys(a, b interface{}) int {
+	if fa, ok := a.(float64); ok {
+		fb := b.(float64)
+		switch {
+		case math.IsNaN(fa) && math.IsNaN(fb):
+			return 0
+		case math.IsNaN(fa) || fa < fb:
+			return -1
+		case math.IsNaN(fb) || fa > fb:
+			return 1
+		}
+		return 0
+	}
+	return strings.Compare(a.(string), b.(string))
+}
+
+// exec executes the instruction, adding its result to out.
+func (r *xsltRun) exec(in *xsltInstr, c *xpCtx, out *xtNode) error {
+	switch in.op {
+	case "text":
+		r.appendText(out, in.text)
+	case "literal":
+		el := r.appendElement(out, in.name)
+		for _, a := range in.attrs {
+			v, err := a.value.eval(c)
+			if err != nil {
+				return errors.Wrapf(err, "attribute %s of %s", a.name, in.name)
+			}
+			r.setAttr(el, a.name, v)
+		}
+		return r.execBody(in.body, *c, el)
+	case "element", "attribute":
+		name, err := in.avt.eval(c)
+		if err != nil {
+			return errors.Wrapf(err, "xsl:%s", in.op)
+		}
+		if strings.Contains(name, ":") {
+			return errors.Errorf("xsl:%s: prefixed name %q: namespaces aren't supported", in.op,
+				name)
+		}
+		if !xmlNameExpr.MatchString(name) {
+			return errors.Errorf("xsl:%s: invalid name %q", in.op, name)
+		}
+		if in.op == "element" {
+			return r.execBody(in.body, *c, r.appendElement(out, name))
+		}
+		v, err := r.text(in.body, c)
+		if err != nil {
+			return err
+		}
+		return r.addAttr(out, name, v)
+	case "value-of":
+		v, err := r.eval(in, c)
+		if err != nil {
+			return err
+		}
+		r.appendText(out, xpString(v))
+	case "copy-of":
+		v, err := r.eval(in, c)
+		if err != nil {
+			return err
+		}
+		nodes, ok := v.(xpNodes)
+		if !ok {
+			r.appendText(out, xpString(v))
+			return nil
+		}
+		for _, n := range nodes {
+			if err := r.copyNode(out, n); err != nil {
+				return err
+			}
+		}
+	case "copy":
+		switch n := c.node; n.kind {
+		case xtElement:
+			return r.execBody(in.body, *c, r.appendElement(out, n.name))
+		case xtRoot:
+			return r.execBody(in.body, *c, out)
+		default:
+			return r.copyNode(out, n)
+		}
+	case "comment":
+		v, err := r.text(in.body, c)
+		if err != nil {
+			return err
+		}
+		r.appendNode(out, &xtNode{kind: xtComment, value: v})
+	case "if":
+		v, err := r.eval(in, c)
+		if err != nil || !xpBool(v) {
+			return err
+		}
+		return r.execBody(in.body, *c, out)
+	case "choose":
+		for _, when := range in.whens {
+			if when.expr != nil {
+				v, err := r.eval(when, c)
+				if err != nil {
+					return err
+				}
+				if !xpBool(v) {
+					continue
+				}
+			}
+			return r.execBody(when.body, *c, out)
+		}
+	case "for-each":
+		nodes, err := r.nodes(in, c)
+		if err != nil {
+			return err
+		}
+		for i, n := range nodes {
+			fc := *c
+			fc.node, fc.current, fc.pos, fc.size = n, n, i+1, len(nodes)
+			if err := r.execBody(in.body, fc, out); err != nil {
+				return err
+			}
+		}
+	case "apply-templates":
+		nodes, err := r.nodes(in, c)
+		if err != nil {
+			return err
+		}
+		params, err := r.params(in.params, c)
+		if err != nil {
+			return err
+		}
+		return r.applyTemplates(nodes, in.mode, params, out)
+	case "call-template":
+		t := r.s.named[in.name]
+		if t == nil {
+			return errors.Errorf("xsl:call-template: no template named %s", in.name)
+		}
+		params, err := r.params(in.params, c)
+		if err != nil {
+			return err
+		}
+		return r.call(t, c, params, out)
+	}
+	return nil
+}
+
+func (r *xsltRun) appendNode(out, n *xtNode) {
+	r.order++
+	n.parent, n.order = out, r.order
+	out.children = append(out.children, n)
+}
+
+func (r *xsltRun) appendElement(out *xtNode, name string) *xtNode {
+	el := &xtNode{kind: xtElement, name: name}
+	r.appendNode(out, el)
+	return el
+}
+
+// appendText adds the text to out, merged with the text preceding it.
+func (r *xsltRun) appendText(out *xtNode, text string) {
+	if len(text) == 0 {
+		return
+	}
+	if last := out.lastChild(); last != nil && last.kind == xtText {
+		last.value += text
+		return
+	}
+	r.appendNode(out, &xtNode{kind: xtText, value: text})
+}
+
+// addAttr sets the attribute of the element out, which must have no
+// children yet.
+func (r *xsltRun) addAttr(out *xtNode, name, value string) error {
+	if out.kind != xtElement {
+		return errors.Errorf("attribute %s added outside of an element", name)
+	}
+	if len(out.children) > 0 {
+		return errors.Errorf("attribute %s added after the children of %s", name, out.name)
+	}
+	r.setAttr(out, name, value)
+	return nil
+}
+
+// setAttr sets the attribute of the element, replacing the one of the same
+// name.
+func (r *xsltRun) setAttr(el *xtNode, name, value string) {
+	for _, a := range el.attrs {
+		if a.name == name {
+			a.value = value
+			return
+		}
+	}
+	r.order++
+	el.attrs = append(el.attrs, &xtNode{kind: xtAttribute, name: name, value: value, parent: el,
+		order: r.order})
+}
+
+// copyNode adds a deep copy of n to out, the children of n if it is a root.
+func (r *xsltRun) copyNode(out, n *xtNode) error {
+	switch n.kind {
+	case xtRoot:
+		for _, c := range n.children {
+			if err := r.copyNode(out, c); err != nil {
+				return err
+			}
+		}
+	case xtElement:
+		el := r.appendElement(out, n.name)
+		for _, a := range n.attrs {
+			r.setAttr(el, a.name, a.value)
+		}
+		for _, c := range n.children {
+			if err := r.copyNode(el, c); err != nil {
+				return err
+			}
+		}
+	case xtAttribute:
+		return r.addAttr(out, n.name, n.value)
+	case xtText:
+		r.appendText(out, n.value)
+	case xtComment:
+		r.appendNode(out, &xtNode{kind: xtComment, value: n.value})
+	}
+	return nil
+}
+
+// serialize writes the result tree as an xml document. With indent set, the
+// elements holding only elements have a line per child, indented as the
+// converter does.
+func (s *xsltStylesheet) serialize(out *xtNode) ([]byte, error) {
+	roots := 0
+	for _, c := range out.children {
+		switch {
+		case c.kind == xtElement:
+			roots++
+		case c.kind == xtText && len(strings.TrimSpace(c.value)) > 0:
+			return nil, errors.Errorf("the result has text outside of its root element: %q",
+				strings.TrimSpace(c.value))
+		}
+	}
+	if roots != 1 {
+		return nil, errors.Errorf("the result has %d root elements, expected 1", roots)
+	}
+	var buf bytes.Buffer
+	for _, c := range out.children {
+		if c.kind == xtText {
+			continue
+		}
+		if s.indent {
+			buf.WriteByte(' ')
+		}
+		writeXTNode(&buf, c, 0, s.indent)
+		if s.indent && c.kind == xtComment {
+			buf.WriteByte('\n')
+		}
+	}
+	return buf.Bytes(), nil
+}
+
+// xsltTextEscaper escapes the text of the result. Unlike xml.EscapeText, it
+// keeps the line breaks and tabs, which are only escaped in attributes.
+var xsltTextEscaper = strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;", "\r", "&#xD;")
+
+func writeXTNode(buf *bytes.Buffer, n *xtNode, depth int, indent bool) {
+	switch n.kind {
+	case xtText:
+		xsltTextEscaper.WriteString(buf, n.value)
+	case xtComment:
+		buf.WriteString("<!--")
+		buf.WriteString(n.value)
+		buf.WriteString("-->")
+	case xtElement:
+		buf.WriteByte('<')
+		buf.WriteString(n.name)
+		for _, a := range n.attrs {
+			buf.WriteByte(' ')
+			buf.WriteString(a.name)
+			buf.WriteString(`="`)
+			xml.EscapeText(buf, []byte(a.value))
+			buf.WriteByte('"')
+		}
+		buf.WriteByte('>')
+		block := indent && len(n.children) > 0
+		for _, c := range n.children {
+			block = block && c.kind != xtText
+		}
+		for _, c := range n.children {
+			if block {
+				buf.WriteString("\n" + strings.Repeat(" ", depth+2))
+			}
+			writeXTNode(buf, c, depth+1, indent)
+		}
+		if block {
+			buf.WriteString("\n" + strings.Repeat(" ", depth+1))
+		}
+		buf.WriteString("</")
+		buf.WriteString(n.name)
+		buf.WriteByte('>')
+	}
+}
+
+// transform returns the document transformed by the --xslt stylesheet, with
+// the declaration and layout of the converted documents.
+func (o *convertOptions) transform(data []byte) ([]byte, error) {
+	out, err := o.xslt.transform(data)
+	if err != nil {
+		return nil, errors.Wrap(err, "xslt")
+	}
+	if o.selfClosing {
+		out = selfClose(out)
+	}
+	if o.declaration {
+		out = append([]byte(xml.Header), out...)
+	}
+	return o.layout.apply(out), nil
+}
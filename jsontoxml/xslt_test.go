@@ -0,0 +1,196 @@
+package jsontoxml
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+const testXSLT = `<?xml version="1.0"?>
+<xsl:stylesheet version="1.0" xmlns:xsl="http://www.w3.org/1999/XSL/Transform">
+  <xsl:output method="xml" indent="yes"/>
+  <xsl:strip-space elements="*"/>
+  <xsl:param name="prefix" select="'p-'"/>
+
+  <xsl:template match="/records">
+    <people count="{count(jsonData)}">
+      <xsl:apply-templates select="jsonData">
+        <xsl:sort select="Id" data-type="number" order="descending"/>
+      </xsl:apply-templates>
+    </people>
+  </xsl:template>
+
+  <xsl:template match="jsonData">
+    <person id="{$prefix}{Id}">
+      <xsl:if test="string(City)">
+        <xsl:attribute name="city"><xsl:value-of select="City"/></xsl:attribute>
+      </xsl:if>
+      <xsl:call-template name="name">
+        <xsl:with-param name="n" select="name"/>
+      </xsl:call-template>
+      <xsl:choose>
+        <xsl:when test="Id mod 2 = 0"><even/></xsl:when>
+        <xsl:otherwise><xsl:element name="odd-{Id}"/></xsl:otherwise>
+      </xsl:choose>
+    </person>
+  </xsl:template>
+
+  <xsl:template name="name">
+    <xsl:param name="n"/>
+    <xsl:for-each select="$n/*[string(.)]">
+      <xsl:copy><xsl:value-of select="translate(., 'abc', 'ABC')"/></xsl:copy>
+    </xsl:for-each>
+  </xsl:template>
+</xsl:stylesheet>`
+
+func TestXSLT(t *testing.T) {
+	s, err := compileXSLT([]byte(testXSLT))
+	require.NoError(t, err)
+	opts := &convertOptions{xslt: s}
+	var buf bytes.Buffer
+	require.NoError(t, jsonToXml([]byte(`[{"id": 1, "first_name": "abc", "city": "Paris"},
+		{"id": 2, "last_name": "b & c"}]`), &buf, opts))
+	require.Equal(t, ` <people count="2">
+  <person id="p-2">
+   <last>B &amp; C</last>
+   <even></even>
+  </person>
+  <person id="p-1" city="Paris">
+   <first>ABC</first>
+   <odd-1></odd-1>
+  </person>
+ </people>`, buf.String())
+
+	// The documents keep the declaration and layout of the converter.
+	opts.declaration, opts.selfClosing = true, true
+	opts.layout = &xmlLayout{compact: true}
+	buf.Reset()
+	require.NoError(t, jsonToXml([]byte(`{"id": 4}`), &buf, opts))
+	require.Equal(t, `<?xml version="1.0" encoding="UTF-8"?>`+
+		`<person id="p-4"><even/></person>`, buf.String())
+}
+
+func TestXSLTIdentity(t *testing.T) {
+	s, err := compileXSLT([]byte(`<xsl:transform version="1.0"
+    xmlns:xsl="http://www.w3.org/1999/XSL/Transform">
+  <xsl:variable name="ids"><id>1</id><id>3</id></xsl:variable>
+  <xsl:template match="@*|node()">
+    <xsl:copy><xsl:apply-templates select="@*|node()"/></xsl:copy>
+  </xsl:template>
+  <xsl:template match="State"/>
+  <xsl:template match="name//text()" priority="1">
+    <xsl:value-of select="normalize-space(.)"/>
+  </xsl:template>
+  <xsl:template match="jsonData[Id = $ids/id]">
+    <kept><xsl:apply-templates select="Id" mode="id"/></kept>
+  </xsl:template>
+  <xsl:template match="Id" mode="id"><xsl:copy-of select="."/><xsl:comment>id</xsl:comment>
+  </xsl:template>
+</xsl:transform>`))
+	require.NoError(t, err)
+	out, err := s.transform([]byte(`<records><jsonData a="1"><Id>1</Id><State/></jsonData>` +
+		`<jsonData><Id>2</Id><name><first> x </first></name><State>CA</State></jsonData>` +
+		`</records>`))
+	require.NoError(t, err)
+	require.Equal(t, `<records><kept><Id>1</Id><!--id--></kept>`+
+		`<jsonData><Id>2</Id><name><first>x</first></name></jsonData></records>`, string(out))
+}
+
+func TestXSLTTemplates(t *testing.T) {
+	s, err := compileXSLT([]byte(`<xsl:stylesheet version="1.0"
+    xmlns:xsl="http://www.w3.org/1999/XSL/Transform">
+  <xsl:strip-space elements="*"/>
+  <xsl:template match="/">
+    <out>
+      <xsl:apply-templates select="r/i">
+        <xsl:sort select="@g"/>
+        <xsl:sort select="n" data-type="number" order="descending"/>
+      </xsl:apply-templates>
+      <xsl:apply-templates select="r/i[1]" mode="copy"/>
+      <xsl:apply-templates select="r/note" mode="unknown"/>
+    </out>
+  </xsl:template>
+  <xsl:template match="i"><low/></xsl:template>
+  <!-- The most specific pattern wins, then the last of the same priority. -->
+  <xsl:template match="i[@g]"><first g="{@g}" n="{n}"/></xsl:template>
+  <xsl:template match="i[@g]"><i g="{@g}" n="{n}"/></xsl:template>
+  <xsl:template match="*" mode="copy">
+    <xsl:copy><xsl:attribute name="mode">copy</xsl:attribute><xsl:copy-of select="@*|*"/></xsl:copy>
+  </xsl:template>
+</xsl:stylesheet>`))
+	require.NoError(t, err)
+	out, err := s.transform([]byte(`<r><i g="b"><n>2</n></i><i g="a"><n>9</n></i>` +
+		`<i g="b"><n>10</n></i><i><n>1</n></i><note>kept <b>text</b></note></r>`))
+	require.NoError(t, err)
+	// The elements without a template in the mode get the built-in rules,
+	// which copy their text.
+	require.Equal(t, `<out><low></low><i g="a" n="9"></i><i g="b" n="10"></i><i g="b" n="2"></i>`+
+		`<i mode="copy" g="b"><n>2</n></i>kept text</out>`, string(out))
+}
+
+func TestXSLTText(t *testing.T) {
+	s, err := compileXSLT([]byte(`<xsl:stylesheet version="1.0"
+    xmlns:xsl="http://www.w3.org/1999/XSL/Transform">
+  <xsl:template match="/"><a b="{r}"><xsl:value-of select="r"/></a></xsl:template>
+</xsl:stylesheet>`))
+	require.NoError(t, err)
+	out, err := s.transform([]byte("<r>1 &lt; 2 &amp;&#xD;\n\t&gt; \"'</r>"))
+	require.NoError(t, err)
+	// The line breaks and tabs are only escaped in the attributes, where they
+	// would be normalized to spaces.
+	require.Equal(t, "<a b=\"1 &lt; 2 &amp;&#xD;&#xA;&#x9;&gt; &#34;&#39;\">"+
+		"1 &lt; 2 &amp;&#xD;\n\t&gt; \"'</a>", string(out))
+}
+
+func TestXSLTErrors(t *testing.T) {
+	for _, stylesheet := range []string{
+		`<stylesheet/>`,
+		`<xsl:stylesheet xmlns:xsl="http://www.w3.org/1999/XSL/Transform">
+  <xsl:import href="other.xsl"/></xsl:stylesheet>`,
+		`<xsl:stylesheet xmlns:xsl="http://www.w3.org/1999/XSL/Transform">
+  <xsl:output method="text"/></xsl:stylesheet>`,
+		`<xsl:stylesheet xmlns:xsl="http://www.w3.org/1999/XSL/Transform">
+  <xsl:template><a/></xsl:template></xsl:stylesheet>`,
+		`<xsl:stylesheet xmlns:xsl="http://www.w3.org/1999/XSL/Transform">
+  <xsl:template match="$x"/></xsl:stylesheet>`,
+		`<xsl:stylesheet xmlns:xsl="http://www.w3.org/1999/XSL/Transform">
+  <xsl:template match="ancestor::a"/></xsl:stylesheet>`,
+		`<xsl:stylesheet xmlns:xsl="http://www.w3.org/1999/XSL/Transform">
+  <xsl:template match="a"><xsl:value-of select="a["/></xsl:template></xsl:stylesheet>`,
+		`<xsl:stylesheet xmlns:xsl="http://www.w3.org/1999/XSL/Transform">
+  <xsl:template match="a"><b/><xsl:param name="p"/></xsl:template></xsl:stylesheet>`,
+		`<xsl:stylesheet xmlns:xsl="http://www.w3.org/1999/XSL/Transform">
+  <xsl:template match="a"><xsl:number/></xsl:template></xsl:stylesheet>`,
+		`<xsl:stylesheet xmlns:xsl="http://www.w3.org/1999/XSL/Transform">
+  <xsl:template match="a"><b c="{@d"/></xsl:template></xsl:stylesheet>`,
+		// The result is written without namespaces.
+		`<xsl:stylesheet xmlns:xsl="http://www.w3.org/1999/XSL/Transform">
+  <xsl:template match="/"><o:doc xmlns:o="urn:out"/></xsl:template></xsl:stylesheet>`,
+		`<xsl:stylesheet xmlns:xsl="http://www.w3.org/1999/XSL/Transform">
+  <xsl:template match="/"><doc xmlns="urn:out"/></xsl:template></xsl:stylesheet>`,
+		`<xsl:stylesheet xmlns:xsl="http://www.w3.org/1999/XSL/Transform">
+  <xsl:template match="/"><doc xmlns:o="urn:out" o:a="1"/></xsl:template></xsl:stylesheet>`,
+		`<xsl:stylesheet xmlns:xsl="http://www.w3.org/1999/XSL/Transform">
+  <xsl:template match="/"><xsl:element name="doc" namespace="urn:out"/></xsl:template>
+  </xsl:stylesheet>`,
+	} {
+		_, err := compileXSLT([]byte(stylesheet))
+		require.Error(t, err, stylesheet)
+	}
+
+	for stylesheet, want := range map[string]string{
+		`<xsl:template match="/"><a/><b/></xsl:template>`: "the result has 2 root elements, expected 1",
+		`<xsl:template match="/"><a><b/><xsl:attribute name="c">d</xsl:attribute></a>` +
+			`</xsl:template>`: "attribute c added after the children of a",
+		`<xsl:template match="/" name="loop"><xsl:call-template name="loop"/></xsl:template>`: "more than 5000 nested templates",
+		`<xsl:template match="/"><xsl:call-template name="missing"/></xsl:template>`:          "xsl:call-template: no template named missing",
+		`<xsl:template match="/"><xsl:element name="o:doc"/></xsl:template>`:                  `xsl:element: prefixed name "o:doc": namespaces aren't supported`,
+	} {
+		s, err := compileXSLT([]byte(`<xsl:stylesheet version="1.0"
+    xmlns:xsl="http://www.w3.org/1999/XSL/Transform">` + stylesheet + `</xsl:stylesheet>`))
+		require.NoError(t, err, stylesheet)
+		_, err = s.transform([]byte(`<r/>`))
+		require.EqualError(t, err, want, stylesheet)
+	}
+}